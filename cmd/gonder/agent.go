@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ercansavas/gonder/internal/config"
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/fleet"
+	"github.com/ercansavas/gonder/pkg/forward"
+	"github.com/ercansavas/gonder/pkg/handler"
+)
+
+// newAgentCommand creates `gonder agent`
+func newAgentCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "agent",
+		Short: "Collect log lines and forward them to an aggregator",
+		Long: `Runs gonder as a thin edge agent: sources are tailed as usual but lines are
+not parsed locally. They are batched, compressed and sent to a gonder
+aggregator (a "gonder serve" instance with AGENT_TOKEN set). Batches that
+cannot be delivered are spooled to disk and replayed once the aggregator is
+reachable again.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := config.Load()
+			applyConfigFlags(cmd, cfg)
+			flags := cmd.Flags()
+			if flags.Changed("aggregator") {
+				cfg.AggregatorURL, _ = flags.GetString("aggregator")
+			}
+			if flags.Changed("agent-id") {
+				cfg.AgentID, _ = flags.GetString("agent-id")
+			}
+			if flags.Changed("spool-dir") {
+				cfg.SpoolDir, _ = flags.GetString("spool-dir")
+			}
+			return runAgent(cfg)
+		},
+	}
+	addConfigFlags(cmd)
+	cmd.Flags().String("aggregator", "", "Aggregator base URL (overrides AGGREGATOR_URL)")
+	cmd.Flags().String("agent-id", "", "Agent identifier (overrides AGENT_ID)")
+	cmd.Flags().String("spool-dir", "", "Directory for undelivered batches (overrides SPOOL_DIR)")
+	return cmd
+}
+
+// runAgent tails the configured sources and forwards lines until shutdown
+func runAgent(cfg *config.Config) error {
+	if cfg.AggregatorURL == "" {
+		return fmt.Errorf("aggregator URL is required (set AGGREGATOR_URL or --aggregator)")
+	}
+
+	auditLogger := audit.New()
+	auditLogger.SetRepeatWindow(cfg.AuditRepeatWindow)
+
+	ln, handover, err := listen(":" + cfg.Port)
+	if err != nil {
+		auditLogger.LogError(err, "Listener error", nil)
+		return err
+	}
+	tlsConfig, err := serverTLSConfig(cfg)
+	if err != nil {
+		auditLogger.LogError(err, "TLS configuration error", nil)
+		return err
+	}
+	cipher, err := loadCipher(cfg)
+	if err != nil {
+		auditLogger.LogError(err, "Encryption key error", nil)
+		return err
+	}
+
+	collector.SetNodeID(cfg.NodeID)
+	logCollector := collector.New(auditLogger)
+	if err := loadSources(logCollector, cfg); err != nil {
+		auditLogger.LogError(err, "Log source configuration error", nil)
+		return err
+	}
+	if err := loadQuotas(logCollector, cfg); err != nil {
+		auditLogger.LogError(err, "Quota configuration error", map[string]interface{}{"path": cfg.QuotasFile})
+		return err
+	}
+	if err := addKubernetesEvents(logCollector, cfg); err != nil {
+		auditLogger.LogError(err, "Kubernetes events configuration error", nil)
+		return err
+	}
+	if err := addInputs(logCollector, cfg); err != nil {
+		auditLogger.LogError(err, "Input configuration error", map[string]interface{}{"path": cfg.InputsFile})
+		return err
+	}
+	// Lines are parsed and written by the aggregator
+	if err := logCollector.ConfigureOutputs(collector.OutputConfig{DisableConsole: true}); err != nil {
+		return err
+	}
+	logCollector.SetFileWatch(cfg.WatchFiles)
+	if err := logCollector.SetBreakerPolicy(breakerPolicy(cfg)); err != nil {
+		auditLogger.LogError(err, "Circuit breaker configuration error", nil)
+		return err
+	}
+
+	// During a hot upgrade, wait for the previous process to flush its
+	// checkpoints and spool before taking them over
+	if err := handover.complete(auditLogger); err != nil {
+		auditLogger.LogError(err, "Upgrade handover error", nil)
+	}
+
+	client, err := aggregatorClient(cfg)
+	if err != nil {
+		auditLogger.LogError(err, "Forwarder configuration error", nil)
+		return err
+	}
+	forwarder, err := forward.New(forward.Config{
+		URL:           cfg.AggregatorURL,
+		Token:         cfg.AgentToken,
+		AgentID:       cfg.AgentID,
+		AgentVersion:  version,
+		BatchSize:     cfg.ForwardBatchSize,
+		FlushInterval: cfg.ForwardFlushInterval,
+		Compression:   cfg.ForwardCompression,
+		SpoolDir:      cfg.SpoolDir,
+		SpoolMaxBytes: cfg.SpoolMaxBytes,
+		Client:        client,
+		Cipher:        cipher,
+	}, auditLogger)
+	if err != nil {
+		auditLogger.LogError(err, "Forwarder configuration error", nil)
+		return err
+	}
+
+	if cfg.CheckpointFile != "" {
+		if err := logCollector.ConfigureCheckpoints(cfg.CheckpointFile, collector.CheckpointPolicy{
+			FlushEntries:     cfg.CheckpointFlushEntries,
+			FlushInterval:    cfg.CheckpointFlushInterval,
+			Fsync:            cfg.CheckpointFsync,
+			Cipher:           cipher,
+			FingerprintBytes: cfg.CheckpointFingerprintBytes,
+		}); err != nil {
+			auditLogger.LogError(err, "Checkpoint configuration error", nil)
+		}
+	}
+	if err := logCollector.ConfigureUsage(cfg.UsageFile, cfg.UsageRetentionDays); err != nil {
+		auditLogger.LogError(err, "Usage accounting configuration error", nil)
+	}
+	filtered := fleet.NewFilteredForwarder(forwarder)
+	logCollector.SetForwarder(filtered)
+	fleetAgent := fleet.NewAgent(fleet.AgentOptions{
+		Token:    cfg.AgentToken,
+		AgentID:  cfg.AgentID,
+		Version:  version,
+		Interval: cfg.HeartbeatInterval,
+		Client:   client,
+	}, logCollector, forwarder, filtered, auditLogger)
+
+	auditLogger.LogStartup(cfg.Port, map[string]interface{}{
+		"host":       cfg.Host,
+		"version":    version,
+		"purpose":    "log_forwarding_agent",
+		"agent_id":   cfg.AgentID,
+		"aggregator": cfg.AggregatorURL,
+	})
+
+	h := handler.New(auditLogger)
+	logHandler := handler.NewLogHandler(logCollector)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/health", audit.MiddlewareFunc(auditLogger, h.Health))
+	mux.HandleFunc("/api/logs/status", audit.MiddlewareFunc(auditLogger, logHandler.GetStatus))
+	mux.HandleFunc("/api/logs/sources", audit.MiddlewareFunc(auditLogger, logHandler.GetSources))
+	mux.HandleFunc("/api/agent/status", audit.MiddlewareFunc(auditLogger, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"agent_id":         cfg.AgentID,
+			"version":          version,
+			"config_version":   fleetAgent.ConfigVersion(),
+			"filtered_dropped": filtered.Dropped(),
+			"forward":          forwarder.Stats(),
+		})
+	}))
+
+	if err := logCollector.Start(); err != nil {
+		auditLogger.LogError(err, "Log collector startup error", nil)
+	}
+	fleetAgent.Start()
+
+	ready := map[string]interface{}{
+		"port":              cfg.Port,
+		"agent_id":          cfg.AgentID,
+		"aggregator":        cfg.AggregatorURL,
+		"collector_running": logCollector.IsRunning(),
+		"sources":           len(logCollector.GetSources()),
+		"endpoints":         []string{"/api/health", "/api/logs/status", "/api/logs/sources", "/api/agent/status"},
+	}
+	if tlsConfig != nil {
+		ready["tls"] = clientAuthMode(tlsConfig)
+	}
+	if cipher != nil {
+		ready["spool_key"] = cipher.KeyID()
+	}
+	auditLogger.LogEvent(audit.AuditEvent{
+		EventType: audit.EventTypeStartup,
+		Message:   fmt.Sprintf("Forwarding to %s as agent %s, status on port %s", cfg.AggregatorURL, cfg.AgentID, cfg.Port),
+		Details:   ready,
+	})
+
+	return serveHTTP(cfg, ln, tlsConfig, mux, logCollector, auditLogger, func(reason string, deadline time.Time) {
+		// Stop reading first so every line read is either sent or spooled
+		fleetAgent.Stop()
+		logCollector.Close()
+		forwarder.Close()
+
+		auditLogger.FlushRepeated()
+		auditLogger.LogEvent(audit.AuditEvent{
+			EventType: "system_shutdown",
+			Message:   "Agent is shutting down cleanly",
+			Details:   map[string]interface{}{"reason": reason},
+		})
+	})
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ercansavas/gonder/internal/config"
+	"github.com/ercansavas/gonder/pkg/archive"
+	"github.com/ercansavas/gonder/pkg/arrow"
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// newArchiveCommand creates `gonder archive`
+func newArchiveCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "archive",
+		Short: "Work with archive directories written by ARCHIVE_DIR",
+	}
+	cmd.AddCommand(newArchiveVerifyCommand(), newArchiveCatCommand(), newArchiveExportCommand(), newArchiveImportCommand())
+	return cmd
+}
+
+// newArchiveVerifyCommand creates `gonder archive verify`
+func newArchiveVerifyCommand() *cobra.Command {
+	var publicKey string
+
+	cmd := &cobra.Command{
+		Use:   "verify DIR",
+		Short: "Check archive objects against their checksums and signed manifests",
+		Long: `Checks every object listed in the manifests of an archive directory
+against its size and SHA-256, and reports sealed objects missing from the
+manifests. With --public-key the signatures of the manifests, of the
+summaries of downsampled entries and of the holds are verified too, so
+changed, removed or added objects are detected. Exits 1 when anything
+fails verification.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var key ed25519.PublicKey
+			if publicKey != "" {
+				var err error
+				if key, err = archive.LoadPublicKey(publicKey); err != nil {
+					return err
+				}
+			}
+			report, err := archive.Verify(args[0], key)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			for _, problem := range report.Problems {
+				fmt.Fprintf(out, "❌ %s\n", problem)
+			}
+			if !report.OK() {
+				fmt.Fprintf(out, "%d problem(s) in %d manifest(s), %d object(s)\n", len(report.Problems), report.Manifests, report.Objects)
+				return exitError{code: 1}
+			}
+			signed := ""
+			if key != nil {
+				signed = ", signatures valid"
+			}
+			summaries := ""
+			if report.Summaries > 0 {
+				summaries = fmt.Sprintf(", %d summary day(s)", report.Summaries)
+			}
+			fmt.Fprintf(out, "✅ %d manifest(s), %d object(s)%s verified%s\n", report.Manifests, report.Objects, summaries, signed)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&publicKey, "public-key", "", "PEM Ed25519 public key to verify manifest signatures with")
+	return cmd
+}
+
+// newArchiveCatCommand creates `gonder archive cat`
+func newArchiveCatCommand() *cobra.Command {
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "cat DIR OBJECT...",
+		Short: "Print the entries of archive objects with their raw lines restored",
+		Long: `Prints the entries of archive objects as NDJSON, or with --format arrow
+as an Arrow IPC stream to load into pandas, polars or DuckDB. Raw lines
+written with ARCHIVE_COMPRESS_RAW are decompressed with the dictionaries
+in DIR.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var write func(*collector.SystemLog) error
+			done := func() error { return nil }
+			switch format {
+			case "ndjson":
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				write = func(entry *collector.SystemLog) error { return enc.Encode(entry) }
+			case "arrow":
+				out := bufio.NewWriterSize(cmd.OutOrStdout(), 64*1024)
+				aw := arrow.NewWriter(out)
+				write = aw.Write
+				done = func() error {
+					if err := aw.Close(); err != nil {
+						return err
+					}
+					return out.Flush()
+				}
+			default:
+				return fmt.Errorf("--format must be ndjson or arrow")
+			}
+			for _, name := range args[1:] {
+				if err := archive.ReadObject(args[0], name, write); err != nil {
+					return err
+				}
+			}
+			return done()
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "ndjson", "Output format: ndjson or arrow")
+	return cmd
+}
+
+// newArchiveExportCommand creates `gonder archive export`
+func newArchiveExportCommand() *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "export DIR",
+		Short: "Pack the sealed objects and manifests of an archive into a portable file",
+		Long: `Writes the manifests, signatures, dictionaries and sealed objects of an
+archive directory to a gzipped tar, for moving an instance to another host
+or keeping a backup. Objects are checked against their manifests while
+they are packed; a damaged archive fails the export.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var w io.Writer = cmd.OutOrStdout()
+			if output != "-" {
+				file, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				w = file
+			}
+			report, err := archive.Export(args[0], w)
+			if err != nil {
+				if output != "-" {
+					os.Remove(output)
+				}
+				return err
+			}
+			if output != "-" {
+				fmt.Fprintf(cmd.ErrOrStderr(), "📦 %d manifest(s), %d object(s), %d dictionary(ies), %d summary day(s), %d bytes exported to %s\n",
+					report.Manifests, report.Objects, report.Dictionaries, report.Summaries, report.Bytes, output)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "-", "file to write the export to (- for standard output)")
+	return cmd
+}
+
+// newArchiveImportCommand creates `gonder archive import`
+func newArchiveImportCommand() *cobra.Command {
+	var publicKey string
+
+	cmd := &cobra.Command{
+		Use:   "import FILE DIR",
+		Short: "Restore an archive export into an archive directory",
+		Long: `Unpacks an export written by "gonder archive export" (- reads standard
+input) and verifies it before moving anything into DIR; with --public-key
+the manifest signatures must be valid too. Files already in DIR must be
+identical, so the same export can be imported twice, but a manifest of the
+same day from another archive is refused. Import before starting the
+instance that archives to DIR.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var key ed25519.PublicKey
+			if publicKey != "" {
+				var err error
+				if key, err = archive.LoadPublicKey(publicKey); err != nil {
+					return err
+				}
+			}
+			var r io.Reader = cmd.InOrStdin()
+			if args[0] != "-" {
+				file, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				r = file
+			}
+			report, err := archive.Import(r, args[1], key)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "✅ %d manifest(s), %d object(s), %d dictionary(ies), %d summary day(s) imported into %s",
+				report.Manifests, report.Objects, report.Dictionaries, report.Summaries, args[1])
+			if report.Skipped > 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), " (%d file(s) already present)", report.Skipped)
+			}
+			fmt.Fprintln(cmd.OutOrStdout())
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&publicKey, "public-key", "", "PEM Ed25519 public key the manifest signatures must verify with")
+	return cmd
+}
+
+// addArchiveOutput adds the archive output when ARCHIVE_DIR is set and
+// returns it, for snapshots. It must be called after the collector outputs
+// are configured.
+func addArchiveOutput(lc *collector.LogCollector, cfg *config.Config, auditLogger *audit.Logger) (*archive.Output, error) {
+	if cfg.ArchiveDir == "" {
+		return nil, nil
+	}
+	archiveCfg := archive.Config{
+		Dir:              cfg.ArchiveDir,
+		MaxBytes:         cfg.ArchiveMaxBytes,
+		MaxAge:           cfg.ArchiveMaxAge,
+		CompressRaw:      cfg.ArchiveCompressRaw,
+		CompactInterval:  cfg.ArchiveCompactInterval,
+		Retention:        cfg.ArchiveRetention,
+		Downsample:       cfg.ArchiveDownsample,
+		SummaryRetention: cfg.ArchiveSummaryRetention,
+		Capacity:         cfg.ArchiveCapacityBytes,
+		ImmutableFor:     cfg.ArchiveImmutableFor,
+	}
+	if cfg.ArchiveSigningKeyFile != "" {
+		key, err := archive.LoadSigningKey(cfg.ArchiveSigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		archiveCfg.SigningKey = key
+	}
+	output, err := archive.New(archiveCfg, auditLogger)
+	if err != nil {
+		return nil, err
+	}
+	if err := lc.AddOutput(output); err != nil {
+		output.Close()
+		return nil, err
+	}
+	return output, nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// benchOptions holds the flags of the bench command
+type benchOptions struct {
+	format   string
+	rate     int
+	duration time.Duration
+	poll     int
+	drain    time.Duration
+	samples  int
+}
+
+// benchTracker records when each synthetic line was generated and received
+type benchTracker struct {
+	mu        sync.Mutex
+	generated []time.Time
+	received  []time.Duration
+	seen      map[int]bool
+	lastSeen  time.Time
+}
+
+func newBenchTracker() *benchTracker {
+	return &benchTracker{seen: make(map[int]bool)}
+}
+
+// next registers a new generated line and returns its sequence number
+func (t *benchTracker) next(now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.generated = append(t.generated, now)
+	return len(t.generated) - 1
+}
+
+func (t *benchTracker) counts() (generated, received int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.generated), len(t.seen)
+}
+
+// Write receives NDJSON lines from the collector output and matches them to
+// generated lines via the seq=<n> marker embedded in every message
+func (t *benchTracker) Write(p []byte) (int, error) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, line := range bytes.Split(p, []byte{'\n'}) {
+		idx := bytes.Index(line, []byte("seq="))
+		if idx < 0 {
+			continue
+		}
+		end := idx + 4
+		for end < len(line) && line[end] >= '0' && line[end] <= '9' {
+			end++
+		}
+		seq, err := strconv.Atoi(string(line[idx+4 : end]))
+		if err != nil || seq >= len(t.generated) || t.seen[seq] {
+			continue
+		}
+		t.seen[seq] = true
+		t.received = append(t.received, now.Sub(t.generated[seq]))
+		t.lastSeen = now
+	}
+	return len(p), nil
+}
+
+// newBenchCommand creates `gonder bench`
+func newBenchCommand() *cobra.Command {
+	opts := benchOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Generate synthetic log lines and measure ingestion throughput and latency",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBench(opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.format, "format", "syslog", "Synthetic line format: syslog, nginx or json")
+	flags.IntVar(&opts.rate, "rate", 1000, "Lines generated per second")
+	flags.DurationVar(&opts.duration, "duration", 10*time.Second, "How long to generate lines")
+	flags.IntVar(&opts.poll, "poll", 1, "Source poll interval in seconds")
+	flags.DurationVar(&opts.drain, "drain", 5*time.Second, "How long to wait for in-flight lines after generation stops")
+	flags.IntVar(&opts.samples, "parse-samples", 100000, "Lines used to measure parse latency")
+	return cmd
+}
+
+// runBench runs the benchmark; it fails when lines were dropped
+func runBench(opts benchOptions) error {
+	source, ok := benchSources[opts.format]
+	if !ok {
+		return fmt.Errorf("unknown format %q (expected syslog, nginx or json)", opts.format)
+	}
+	if opts.rate <= 0 || opts.duration <= 0 || opts.poll <= 0 {
+		return fmt.Errorf("rate, duration and poll must be positive")
+	}
+
+	dir, err := os.MkdirTemp("", "gonder-bench-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "bench.log")
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create temp source: %w", err)
+	}
+	defer file.Close()
+
+	config := collector.LogSourceConfig{
+		Name:     "bench_" + opts.format,
+		Source:   source,
+		Path:     path,
+		Enabled:  true,
+		Tags:     []string{"bench"},
+		Interval: opts.poll,
+	}
+
+	fmt.Printf("⏱️  Benchmarking %s ingestion: %d lines/s for %s (poll %ds)\n", opts.format, opts.rate, opts.duration, opts.poll)
+
+	parseLatencies := measureParseLatency(config, opts)
+
+	tracker := newBenchTracker()
+	lc := collector.New(audit.NewWithWriter(io.Discard))
+	if err := lc.SetSources([]collector.LogSourceConfig{config}); err != nil {
+		return fmt.Errorf("failed to configure source: %w", err)
+	}
+	if err := lc.ConfigureOutputs(collector.OutputConfig{
+		DisableConsole: true,
+		FlushInterval:  10 * time.Millisecond,
+		Writers:        map[string]io.Writer{"bench": tracker},
+	}); err != nil {
+		return fmt.Errorf("failed to configure outputs: %w", err)
+	}
+	if err := lc.Start(); err != nil {
+		return fmt.Errorf("failed to start collector: %w", err)
+	}
+
+	started := time.Now()
+	generateBenchLines(file, opts, tracker)
+	generationTime := time.Since(started)
+
+	// Wait until every generated line arrived or the drain period is over
+	deadline := time.Now().Add(opts.drain + time.Duration(opts.poll)*time.Second)
+	for time.Now().Before(deadline) {
+		if generated, received := tracker.counts(); received >= generated {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	lc.Close()
+
+	generated, received := tracker.counts()
+	tracker.mu.Lock()
+	e2e := append([]time.Duration(nil), tracker.received...)
+	elapsed := tracker.lastSeen.Sub(started)
+	tracker.mu.Unlock()
+
+	if elapsed <= 0 {
+		elapsed = generationTime
+	}
+
+	fmt.Println()
+	fmt.Println("📊 Results")
+	fmt.Printf("  Lines generated:     %d (%.0f/s)\n", generated, float64(generated)/generationTime.Seconds())
+	fmt.Printf("  Lines delivered:     %d (%.0f/s end-to-end)\n", received, float64(received)/elapsed.Seconds())
+	fmt.Printf("  Dropped:             %d (%.2f%%)\n", generated-received, percentOf(generated-received, generated))
+	printLatencies("  Parse latency:      ", parseLatencies)
+	printLatencies("  End-to-end latency: ", e2e)
+
+	if generated != received {
+		return exitError{code: 1}
+	}
+	return nil
+}
+
+// benchSources maps bench formats to collector source types
+var benchSources = map[string]collector.LogSource{
+	"syslog": collector.SourceSyslog,
+	"nginx":  collector.SourceNginx,
+	"json":   collector.SourceCustom,
+}
+
+var (
+	benchServices = []string{"sshd", "systemd", "cron", "kernel", "nginx", "app"}
+	benchLevels   = []string{"info", "info", "info", "warning", "error", "debug"}
+	benchPaths    = []string{"/", "/api/health", "/api/logs/status", "/login", "/static/app.js"}
+	benchStatuses = []int{200, 200, 200, 201, 301, 404, 500, 503}
+)
+
+// benchLine renders one synthetic line in the requested format
+func benchLine(format string, seq int, now time.Time, rng *rand.Rand) string {
+	switch format {
+	case "nginx":
+		return fmt.Sprintf(`10.0.%d.%d - - [%s] "GET %s?seq=%d HTTP/1.1" %d %d "-" "gonder-bench/1.0"`,
+			rng.Intn(256), rng.Intn(256), now.Format("02/Jan/2006:15:04:05 -0700"),
+			benchPaths[rng.Intn(len(benchPaths))], seq, benchStatuses[rng.Intn(len(benchStatuses))], rng.Intn(50000))
+	case "json":
+		return fmt.Sprintf(`{"time":"%s","level":"%s","service":"%s","msg":"synthetic event seq=%d","latency_ms":%d}`,
+			now.Format(time.RFC3339Nano), benchLevels[rng.Intn(len(benchLevels))],
+			benchServices[rng.Intn(len(benchServices))], seq, rng.Intn(1000))
+	default:
+		service := benchServices[rng.Intn(len(benchServices))]
+		return fmt.Sprintf("%s benchhost %s[%d]: %s synthetic event seq=%d",
+			now.Format("Jan _2 15:04:05"), service, 1000+rng.Intn(9000),
+			benchLevels[rng.Intn(len(benchLevels))], seq)
+	}
+}
+
+// generateBenchLines appends lines to file at the configured rate
+func generateBenchLines(file *os.File, opts benchOptions, tracker *benchTracker) {
+	const tick = 10 * time.Millisecond
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	writer := bufio.NewWriter(file)
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	started := time.Now()
+	written := 0
+	for now := range ticker.C {
+		elapsed := now.Sub(started)
+		if elapsed > opts.duration {
+			elapsed = opts.duration
+		}
+		target := int(float64(opts.rate) * elapsed.Seconds())
+		for ; written < target; written++ {
+			seq := tracker.next(time.Now())
+			writer.WriteString(benchLine(opts.format, seq, now, rng))
+			writer.WriteByte('\n')
+		}
+		writer.Flush()
+
+		if elapsed >= opts.duration {
+			return
+		}
+	}
+}
+
+// measureParseLatency times the parser alone on synthetic lines
+func measureParseLatency(config collector.LogSourceConfig, opts benchOptions) []time.Duration {
+	lc := collector.New(audit.NewWithWriter(io.Discard))
+	rng := rand.New(rand.NewSource(1))
+	now := time.Now()
+
+	lines := make([]string, opts.samples)
+	for i := range lines {
+		lines[i] = benchLine(opts.format, i, now, rng)
+	}
+
+	latencies := make([]time.Duration, 0, len(lines))
+	for _, line := range lines {
+		start := time.Now()
+		lc.ParseLine(line, config)
+		latencies = append(latencies, time.Since(start))
+	}
+	return latencies
+}
+
+// printLatencies prints p50/p90/p99/max of the given latencies
+func printLatencies(label string, latencies []time.Duration) {
+	if len(latencies) == 0 {
+		fmt.Printf("%s n/a\n", label)
+		return
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	fmt.Printf("%s p50=%s p90=%s p99=%s max=%s\n", label,
+		percentile(latencies, 0.50), percentile(latencies, 0.90),
+		percentile(latencies, 0.99), latencies[len(latencies)-1])
+}
+
+// percentile returns the q-th percentile of sorted latencies
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	idx := int(q * float64(len(sorted)-1))
+	return sorted[idx]
+}
+
+func percentOf(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ercansavas/gonder/internal/config"
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// newExportCommand creates `gonder export`
+func newExportCommand() *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:       "export {sources|checkpoints}",
+		Short:     "Export the effective log sources or saved checkpoints as JSON",
+		Args:      cobra.ExactValidArgs(1),
+		ValidArgs: []string{"sources", "checkpoints"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := config.Load()
+			applyConfigFlags(cmd, cfg)
+
+			out := cmd.OutOrStdout()
+			if output != "" && output != "-" {
+				f, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				out = f
+			}
+
+			switch args[0] {
+			case "checkpoints":
+				return exportCheckpoints(out, cfg)
+			default:
+				return exportSources(out, cfg)
+			}
+		},
+	}
+	addConfigFlags(cmd)
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Write to this file instead of stdout")
+	return cmd
+}
+
+// exportSources writes the effective sources in the --sources file format
+func exportSources(out io.Writer, cfg *config.Config) error {
+	lc := collector.New(audit.NewWithWriter(io.Discard))
+	if err := loadSources(lc, cfg); err != nil {
+		return err
+	}
+	return writeJSON(out, lc.GetSources())
+}
+
+// exportCheckpoints writes the checkpoints saved in the checkpoint file
+func exportCheckpoints(out io.Writer, cfg *config.Config) error {
+	if cfg.CheckpointFile == "" {
+		return fmt.Errorf("no checkpoint file configured (set CHECKPOINT_FILE or --checkpoint-file)")
+	}
+	if _, err := os.Stat(cfg.CheckpointFile); err != nil {
+		return err
+	}
+	cipher, err := loadCipher(cfg)
+	if err != nil {
+		return err
+	}
+	store, err := collector.OpenCheckpointStore(cfg.CheckpointFile, collector.CheckpointPolicy{Cipher: cipher})
+	if err != nil {
+		return err
+	}
+	checkpoints := store.All()
+	return writeJSON(out, checkpoints)
+}
+
+func writeJSON(out io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ercansavas/gonder/internal/config"
+)
+
+// newHealthcheckCommand creates `gonder healthcheck`
+func newHealthcheckCommand() *cobra.Command {
+	var (
+		url     string
+		timeout time.Duration
+		verbose bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "healthcheck",
+		Short: "Check the local health endpoint and exit 0 (healthy) or 1",
+		Long: `Calls the health endpoint of a running gonder and exits 0 when it reports
+healthy, 1 otherwise. Meant for Docker HEALTHCHECK and Kubernetes exec
+probes in images without curl or wget. The port is taken from PORT unless
+--url is given; with TLS_CERT_FILE set the local endpoint is called over
+https without verifying the certificate.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := &http.Client{Timeout: timeout}
+			if url == "" {
+				cfg := config.Load()
+				applyConfigFlags(cmd, cfg)
+				url = "http://127.0.0.1:" + cfg.Port + "/api/health"
+				if cfg.TLSCertFile != "" {
+					// The certificate names the service, not 127.0.0.1
+					url = "https://127.0.0.1:" + cfg.Port + "/api/health"
+					client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+				}
+			}
+
+			if err := checkHealth(client, url); err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "unhealthy: %v\n", err)
+				return exitError{code: 1}
+			}
+			if verbose {
+				fmt.Fprintln(cmd.OutOrStdout(), "healthy")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String("port", "", "HTTP port (overrides PORT)")
+	cmd.Flags().StringVar(&url, "url", "", "Health endpoint URL (default http(s)://127.0.0.1:$PORT/api/health)")
+	cmd.Flags().DurationVar(&timeout, "timeout", 3*time.Second, "Request timeout")
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Print the result when healthy")
+	return cmd
+}
+
+// checkHealth calls a health endpoint and requires a 200 with status "healthy"
+func checkHealth(client *http.Client, url string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	var health struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return fmt.Errorf("invalid health response: %w", err)
+	}
+	if health.Status != "healthy" {
+		return fmt.Errorf("status is %q", health.Status)
+	}
+	return nil
+}
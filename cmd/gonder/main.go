@@ -1,114 +1,65 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
-	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
 
-	"gonder/internal/config"
-	"gonder/pkg/audit"
-	"gonder/pkg/collector"
-	"gonder/pkg/handler"
+	"github.com/spf13/cobra"
 )
 
-func main() {
-	fmt.Println("🚀 Gonder - System Log Collection Service starting...")
-
-	// Start audit logger
-	auditLogger := audit.New()
-
-	// Load configuration
-	cfg := config.Load()
-
-	// Start log collector
-	logCollector := collector.New(auditLogger)
-
-	// Startup audit log
-	auditLogger.LogStartup(cfg.Port, map[string]interface{}{
-		"host":      cfg.Host,
-		"log_level": cfg.LogLevel,
-		"version":   "2.0.0",
-		"purpose":   "system_log_collection",
-		"features": []string{
-			"system_log_collection",
-			"audit_logging",
-			"real_time_monitoring",
-			"log_parsing",
-			"structured_output",
-		},
-	})
-
-	// Start handlers
-	h := handler.New(auditLogger)
-	logHandler := handler.NewLogHandler(logCollector)
-
-	// Define routes - wrap with audit middleware
-	http.HandleFunc("/", audit.MiddlewareFunc(auditLogger, h.Home))
-	http.HandleFunc("/api/health", audit.MiddlewareFunc(auditLogger, h.Health))
-
-	// Log management endpoints
-	http.HandleFunc("/api/logs/status", audit.MiddlewareFunc(auditLogger, logHandler.GetStatus))
-	http.HandleFunc("/api/logs/sources", audit.MiddlewareFunc(auditLogger, logHandler.GetSources))
-	http.HandleFunc("/api/logs/start", audit.MiddlewareFunc(auditLogger, logHandler.StartCollector))
-	http.HandleFunc("/api/logs/stop", audit.MiddlewareFunc(auditLogger, logHandler.StopCollector))
-
-	// Backward compatibility (deprecated)
-	http.HandleFunc("/api/send", audit.MiddlewareFunc(auditLogger, h.Send))
-
-	// Auto-start log collector
-	fmt.Println("🔧 Starting system log collector...")
-	if err := logCollector.Start(); err != nil {
-		auditLogger.LogError(err, "Log collector startup error", nil)
-		fmt.Printf("⚠️ Log collector could not be started: %v\n", err)
-	} else {
-		fmt.Println("✅ System log collector started successfully")
-	}
-
-	// Signal handler for graceful shutdown
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-
-	go func() {
-		<-sigCh
-		fmt.Println("\n🛑 Shutdown signal received, starting clean shutdown process...")
-
-		// Stop log collector
-		logCollector.Stop()
-
-		// Shutdown audit log
-		auditLogger.LogEvent(audit.AuditEvent{
-			EventType: "system_shutdown",
-			Message:   "System is shutting down cleanly",
-		})
+// version is the gonder release, overridable with -ldflags "-X main.version=..."
+var version = "2.0.0"
 
-		os.Exit(0)
-	}()
+// exitError makes a command exit with a specific status code
+type exitError struct {
+	code int
+}
 
-	// Start server
-	fmt.Printf("🌐 Server running on port %s\n", cfg.Port)
-	fmt.Println("📋 Endpoints:")
-	fmt.Println("  GET  /                    - Home page")
-	fmt.Println("  GET  /api/health          - System health check")
-	fmt.Println("  GET  /api/logs/status     - Log collector status")
-	fmt.Println("  GET  /api/logs/sources    - List log sources")
-	fmt.Println("  POST /api/logs/start      - Start log collector")
-	fmt.Println("  POST /api/logs/stop       - Stop log collector")
-	fmt.Println("  POST /api/send            - [DEPRECATED] Send message")
-	fmt.Println("📊 System log collection active - Logs are written to console")
-	fmt.Println("🔍 Monitored log files:")
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
 
-	// Show active log sources
-	sources := logCollector.GetSources()
-	for _, source := range sources {
-		if source.Enabled {
-			fmt.Printf("  ✅ %s (%s) - %s\n", source.Name, source.Source, source.Path)
-		} else {
-			fmt.Printf("  ❌ %s (%s) - %s [DISABLED]\n", source.Name, source.Source, source.Path)
+func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		var exit exitError
+		if errors.As(err, &exit) {
+			os.Exit(exit.code)
 		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
+}
 
-	log.Fatal(http.ListenAndServe(":"+cfg.Port, nil))
+// newRootCommand builds the gonder command tree. Running gonder without a
+// subcommand starts the server, as earlier releases did.
+func newRootCommand() *cobra.Command {
+	serve := newServeCommand()
+
+	root := &cobra.Command{
+		Use:           "gonder",
+		Short:         "Gonder - System Log Collection Service",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE:          serve.RunE,
+	}
+	root.Flags().AddFlagSet(serve.Flags())
+
+	root.AddCommand(
+		serve,
+		newAgentCommand(),
+		newValidateCommand(),
+		newParseCommand(),
+		newBenchCommand(),
+		newVersionCommand(),
+		newExportCommand(),
+		newServiceCommand(),
+		newHealthcheckCommand(),
+		newArchiveCommand(),
+		newSnapshotCommand(),
+		newTailCommand(),
+		newQueryCommand(),
+		newHashPasswordCommand(),
+	)
+	return root
 }
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ercansavas/gonder/internal/config"
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// parseOptions holds the flags of the parse command
+type parseOptions struct {
+	source         string
+	file           string
+	output         string
+	out            string
+	timezone       string
+	layouts        []string
+	logFormat      string
+	tags           []string
+	maxFailureRate float64
+	quiet          bool
+}
+
+// parseSummary counts how the lines of a parse run were handled
+type parseSummary struct {
+	Lines     int `json:"lines"`
+	Matched   int `json:"matched"`
+	Unmatched int `json:"unmatched"`
+	Raw       int `json:"raw"`
+	Skipped   int `json:"skipped"`
+}
+
+// failureRate is the share of non-empty lines the parser couldn't match
+func (s parseSummary) failureRate() float64 {
+	parsed := s.Lines - s.Skipped
+	if parsed == 0 {
+		return 0
+	}
+	return float64(s.Unmatched) / float64(parsed)
+}
+
+// parseOutputFormats are the supported --output values
+var parseOutputFormats = []string{"ndjson", "json", "text", "none"}
+
+// newParseCommand creates `gonder parse`
+func newParseCommand() *cobra.Command {
+	opts := parseOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "parse",
+		Short: "Parse a log file (or stdin) once and print structured entries",
+		Long: `Run a file (or stdin) through the parsers once and write the results to
+stdout or a file. The command exits with status 3 when the share of lines the
+parser couldn't match exceeds --max-failure-rate, which makes it usable for
+backfills and for validating parser patterns in CI.`,
+		Example: `  gonder parse --source nginx --file access.log --output ndjson
+  cat auth.log | gonder parse --source syslog --timezone Europe/Istanbul --max-failure-rate 0.05`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runParse(cmd, opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.source, "source", string(collector.SourceSyslog), "Source type used to pick the parser")
+	flags.StringVarP(&opts.file, "file", "f", "", "File to parse (default stdin)")
+	flags.StringVarP(&opts.output, "output", "o", "ndjson", "Output format: "+strings.Join(parseOutputFormats, ", "))
+	flags.StringVar(&opts.out, "out", "", "Write entries to this file instead of stdout")
+	flags.StringVar(&opts.timezone, "timezone", "", "Timezone of timestamps without an offset (default local)")
+	flags.StringSliceVar(&opts.layouts, "timestamp-layout", nil, "Go time layout(s) to parse timestamps with")
+	flags.StringVar(&opts.logFormat, "log-format", "", "nginx log_format definition the lines were written with")
+	flags.StringSliceVar(&opts.tags, "tags", nil, "Tags added to every entry")
+	flags.Float64Var(&opts.maxFailureRate, "max-failure-rate", 1, "Fail when more than this fraction (0-1) of lines don't match the parser")
+	flags.BoolVarP(&opts.quiet, "quiet", "q", false, "Don't print the summary to stderr")
+	return cmd
+}
+
+// runParse parses the input once, writes entries and checks the failure rate
+func runParse(cmd *cobra.Command, opts parseOptions) error {
+	if !isParseOutputFormat(opts.output) {
+		return fmt.Errorf("unknown output format %q (expected %s)", opts.output, strings.Join(parseOutputFormats, ", "))
+	}
+
+	source := collector.LogSourceConfig{
+		Name:             "cli",
+		Source:           collector.LogSource(opts.source),
+		Path:             opts.file,
+		Enabled:          true,
+		Tags:             opts.tags,
+		Interval:         1,
+		Timezone:         opts.timezone,
+		TimestampLayouts: opts.layouts,
+		LogFormat:        opts.logFormat,
+	}
+	if source.Path == "" {
+		source.Path = "-"
+	}
+	if err := source.Validate(); err != nil {
+		return err
+	}
+
+	in := cmd.InOrStdin()
+	if opts.file != "" && opts.file != "-" {
+		f, err := os.Open(opts.file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	out := cmd.OutOrStdout()
+	if opts.out != "" && opts.out != "-" {
+		f, err := os.Create(opts.out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	summary, err := parseStream(in, out, source, opts.output)
+	if err != nil {
+		return err
+	}
+
+	rate := summary.failureRate()
+	if !opts.quiet {
+		fmt.Fprintf(cmd.ErrOrStderr(), "📊 lines=%d matched=%d unmatched=%d raw=%d skipped=%d failure_rate=%.2f%%\n",
+			summary.Lines, summary.Matched, summary.Unmatched, summary.Raw, summary.Skipped, rate*100)
+	}
+
+	if rate > opts.maxFailureRate {
+		fmt.Fprintf(cmd.ErrOrStderr(), "❌ parse failure rate %.2f%% exceeds threshold %.2f%%\n", rate*100, opts.maxFailureRate*100)
+		return exitError{code: 3}
+	}
+	return nil
+}
+
+// parseStream parses every line from in and writes entries to out in format
+func parseStream(in io.Reader, out io.Writer, source collector.LogSourceConfig, format string) (parseSummary, error) {
+	lc := collector.New(audit.NewWithWriter(io.Discard))
+	lc.SetStatusLevelRules(statusLevelRules(config.Load()))
+	writer := bufio.NewWriter(out)
+	defer writer.Flush()
+	encoder := json.NewEncoder(writer)
+
+	summary := parseSummary{}
+	first := true
+	if format == "json" {
+		writer.WriteString("[")
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		summary.Lines++
+		entry, status := lc.ParseLine(scanner.Text(), source)
+		switch status {
+		case collector.ParseSkipped:
+			summary.Skipped++
+			continue
+		case collector.ParseMatched:
+			summary.Matched++
+		case collector.ParseUnmatched:
+			summary.Unmatched++
+		case collector.ParseRaw:
+			summary.Raw++
+		}
+
+		var err error
+		switch format {
+		case "ndjson":
+			err = encoder.Encode(entry)
+		case "json":
+			if !first {
+				writer.WriteString(",")
+			}
+			writer.WriteString("\n  ")
+			var data []byte
+			data, err = json.Marshal(entry)
+			writer.Write(data)
+		case "text":
+			_, err = fmt.Fprintf(writer, "%s %-7s %-8s %s\n",
+				entry.Timestamp.Format("2006-01-02T15:04:05Z07:00"), entry.Level, status, entry.Message)
+		}
+		if err != nil {
+			return summary, err
+		}
+		first = false
+	}
+
+	if format == "json" {
+		writer.WriteString("\n]\n")
+	}
+	return summary, scanner.Err()
+}
+
+func isParseOutputFormat(format string) bool {
+	for _, known := range parseOutputFormats {
+		if format == known {
+			return true
+		}
+	}
+	return false
+}
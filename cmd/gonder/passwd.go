@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ercansavas/gonder/pkg/handler"
+)
+
+// newHashPasswordCommand creates `gonder hash-password`
+func newHashPasswordCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "hash-password",
+		Short: "Hash a UI password for UI_USERS_FILE",
+		Long: `Reads a password from the first line of stdin and prints its hash for the
+password_hash of a user in UI_USERS_FILE. The password is read from stdin
+rather than an argument so it doesn't end up in the shell history:
+
+  printf '%s' "$PASSWORD" | gonder hash-password`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			line, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+			password := strings.TrimRight(line, "\r\n")
+			if password == "" {
+				if err != nil && !errors.Is(err, io.EOF) {
+					return err
+				}
+				return fmt.Errorf("no password on stdin")
+			}
+			hash, err := handler.HashPassword(password)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), hash)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ercansavas/gonder/internal/config"
+	"github.com/ercansavas/gonder/pkg/arrow"
+	"github.com/ercansavas/gonder/pkg/client"
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// newQueryCommand creates `gonder query`
+func newQueryCommand() *cobra.Command {
+	var (
+		since   time.Duration
+		sources []string
+		level   string
+		limit   int
+		cursor  string
+		output  string
+		url     string
+		token   string
+		timeout time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "query [QUERY]",
+		Short: "Search the recent entries of a running gonder",
+		Long: `Searches the entries a running gonder keeps in memory for each source (the
+last CONTEXT_BUFFER entries) and prints the matches oldest first, for
+example
+
+  gonder query --since 1h --source auth_log --level error 'failed password'
+
+QUERY uses the query language of rules and gonder tail. --source takes
+source names and may be repeated; --level keeps entries at least that
+severe. The server is http(s)://127.0.0.1:$PORT unless --url is given,
+and the search needs the admin token (ADMIN_TOKEN or --token). Exits 1
+when nothing matched.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var terms []string
+			if len(args) == 1 && strings.TrimSpace(args[0]) != "" {
+				terms = append(terms, "("+args[0]+")")
+			}
+			if level != "" {
+				terms = append(terms, "level>="+level)
+			}
+			query := strings.Join(terms, " ")
+			if _, err := collector.ParseQuery(query); err != nil {
+				return err
+			}
+			write, ok := searchWriters[output]
+			if !ok {
+				return fmt.Errorf("invalid --output %q: must be table, json, csv or arrow", output)
+			}
+
+			cfg := config.Load()
+			applyConfigFlags(cmd, cfg)
+			if token == "" {
+				token = cfg.AdminToken
+			}
+			api, err := newLocalClient(cfg, url, token)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			result, err := api.Search(ctx, client.SearchOptions{
+				Query:   query,
+				Sources: sources,
+				Since:   since,
+				Limit:   limit,
+				Cursor:  cursor,
+			})
+			if err != nil {
+				return err
+			}
+
+			if err := write(cmd.OutOrStdout(), result); err != nil {
+				return err
+			}
+			if result.Truncated {
+				fmt.Fprintf(cmd.ErrOrStderr(), "⚠️ Only the newest %d matches are shown; raise --limit or pass --cursor %s for older ones\n", len(result.Entries), result.NextCursor)
+			}
+			if len(result.Entries) == 0 {
+				return exitError{code: 1}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String("port", "", "HTTP port (overrides PORT)")
+	cmd.Flags().DurationVar(&since, "since", 0, "Only entries from this far back, e.g. 1h (default everything kept)")
+	cmd.Flags().StringArrayVar(&sources, "source", nil, "Source name to search; repeatable (default all)")
+	cmd.Flags().StringVar(&level, "level", "", "Minimum level: debug, info, warn, error or fatal")
+	cmd.Flags().IntVar(&limit, "limit", 100, "Most entries to print, the newest ones")
+	cmd.Flags().StringVar(&cursor, "cursor", "", "Continue with the entries before this cursor from a previous query")
+	cmd.Flags().StringVarP(&output, "output", "o", "table", "Output format: table, json, csv or arrow (Arrow IPC stream)")
+	cmd.Flags().StringVar(&url, "url", "", "Server URL (default http(s)://127.0.0.1:$PORT)")
+	cmd.Flags().StringVar(&token, "token", "", "Admin token (default ADMIN_TOKEN)")
+	cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "Request timeout")
+	return cmd
+}
+
+// searchWriters print search results in the --output formats
+var searchWriters = map[string]func(io.Writer, *collector.SearchResult) error{
+	"table": writeSearchTable,
+	"json": func(out io.Writer, result *collector.SearchResult) error {
+		return writeJSON(out, result.Entries)
+	},
+	"csv":   writeSearchCSV,
+	"arrow": writeSearchArrow,
+}
+
+func writeSearchTable(out io.Writer, result *collector.SearchResult) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tLEVEL\tSOURCE\tHOST\tMESSAGE")
+	for _, entry := range result.Entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			entry.Timestamp.Local().Format("2006-01-02 15:04:05"),
+			strings.ToUpper(string(entry.Level)),
+			entry.SourceName,
+			entry.Host,
+			// Tabs and newlines would break the columns
+			strings.Join(strings.Fields(collector.Summary(&entry.SystemLog)), " "))
+	}
+	return tw.Flush()
+}
+
+func writeSearchArrow(out io.Writer, result *collector.SearchResult) error {
+	bw := bufio.NewWriter(out)
+	w := arrow.NewWriter(bw)
+	for i := range result.Entries {
+		if err := w.WriteLine(&result.Entries[i]); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func writeSearchCSV(out io.Writer, result *collector.SearchResult) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"id", "timestamp", "level", "source_name", "source", "host", "service", "status_code", "message"})
+	for _, entry := range result.Entries {
+		status := ""
+		if entry.StatusCode != 0 {
+			status = fmt.Sprint(entry.StatusCode)
+		}
+		w.Write([]string{
+			entry.ID,
+			entry.Timestamp.Format(time.RFC3339Nano),
+			string(entry.Level),
+			entry.SourceName,
+			string(entry.Source),
+			entry.Host,
+			entry.Service,
+			status,
+			entry.Message,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/ercansavas/gonder/internal/config"
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/encryption"
+	"github.com/ercansavas/gonder/pkg/handler"
+	"github.com/ercansavas/gonder/pkg/kubernetes"
+	"github.com/ercansavas/gonder/pkg/tcpinput"
+)
+
+// readyDetails is the state logReady can't read from the configuration
+type readyDetails struct {
+	tls         *tls.Config
+	cipher      *encryption.Cipher
+	hostMetrics bool
+}
+
+// logReady logs one startup event summarizing what the server runs: its
+// listener, sources, optional features and endpoints. The endpoints
+// themselves are listed by GET /api/endpoints.
+func logReady(auditLogger *audit.Logger, cfg *config.Config, router *handler.Router, lc *collector.LogCollector, details readyDetails) {
+	scheme := "http"
+	summary := map[string]interface{}{
+		"port":              cfg.Port,
+		"version":           version,
+		"collector_running": lc.IsRunning(),
+		"endpoints":         len(router.Endpoints()),
+	}
+	if details.tls != nil {
+		scheme = "https"
+		summary["tls"] = clientAuthMode(details.tls)
+	}
+	if cfg.ReadOnly {
+		summary["read_only"] = true
+	}
+	if details.cipher != nil {
+		summary["checkpoint_key"] = details.cipher.KeyID()
+	}
+
+	type readySource struct {
+		Name    string              `json:"name"`
+		Source  collector.LogSource `json:"source"`
+		Path    string              `json:"path,omitempty"`
+		Enabled bool                `json:"enabled"`
+	}
+	var sources []readySource
+	enabled := 0
+	for _, source := range lc.GetSources() {
+		sources = append(sources, readySource{source.Name, source.Source, source.Path, source.Enabled})
+		if source.Enabled {
+			enabled++
+		}
+	}
+	if cfg.KubernetesEvents {
+		namespace := cfg.KubernetesEventsNamespace
+		if namespace == "" {
+			namespace = "all namespaces"
+		}
+		sources = append(sources, readySource{Name: "kubernetes-events", Source: kubernetes.SourceKubernetesEvents, Path: namespace, Enabled: true})
+		enabled++
+	}
+	if cfg.InputsFile != "" {
+		inputs, _ := tcpinput.LoadFile(cfg.InputsFile)
+		for _, input := range inputs {
+			sources = append(sources, readySource{Name: input.Name, Source: input.Source, Path: input.URL(), Enabled: true})
+			enabled++
+		}
+	}
+	summary["sources"] = sources
+
+	if details.hostMetrics {
+		summary["host_metrics"] = map[string]interface{}{
+			"interval":  cfg.HostMetricsInterval.String(),
+			"min_level": cfg.HostMetricsLevel,
+		}
+	}
+	if cfg.ArchiveDir != "" {
+		summary["archive"] = map[string]interface{}{
+			"dir":        cfg.ArchiveDir,
+			"max_age":    cfg.ArchiveMaxAge.String(),
+			"max_bytes":  cfg.ArchiveMaxBytes,
+			"signed":     cfg.ArchiveSigningKeyFile != "",
+			"compact":    cfg.ArchiveCompactInterval.String(),
+			"retention":  cfg.ArchiveRetention.String(),
+			"capacity":   cfg.ArchiveCapacityBytes,
+			"downsample": cfg.ArchiveDownsample,
+			"immutable":  cfg.ArchiveImmutableFor.String(),
+		}
+	}
+
+	if cfg.SnapshotDir != "" {
+		summary["snapshots"] = map[string]interface{}{
+			"dir":    cfg.SnapshotDir,
+			"keep":   cfg.SnapshotKeep,
+			"upload": cfg.SnapshotUploadURL != "",
+		}
+	}
+
+	auditLogger.LogEvent(audit.AuditEvent{
+		EventType: audit.EventTypeStartup,
+		Message: fmt.Sprintf("Serving %s on port %s: %d of %d sources enabled, %d endpoints (GET /api/endpoints)",
+			scheme, cfg.Port, enabled, len(sources), summary["endpoints"]),
+		Details: summary,
+	})
+}
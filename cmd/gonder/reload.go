@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ercansavas/gonder/internal/systemd"
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/handler"
+)
+
+// reloadOnHangup reloads SOURCES_FILE and RULES_FILE on every SIGHUP, e.g.
+// from systemctl reload, until the returned function is called
+func reloadOnHangup(configHandler *handler.ConfigHandler, auditLogger *audit.Logger) func() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			case <-hup:
+			}
+			systemd.Notify(systemd.StateReload)
+			auditLogger.LogEvent(audit.AuditEvent{
+				EventType: "config_reload_signal",
+				Message:   "Reload signal received, reloading configuration files",
+				Details:   map[string]interface{}{"signal": "SIGHUP"},
+			})
+			if _, err := configHandler.Reload(nil, "SIGHUP"); err != nil {
+				auditLogger.LogError(err, "Configuration reload failed, keeping the current configuration", nil)
+			}
+			systemd.Notify(systemd.StateReady)
+		}
+	}()
+	return func() {
+		signal.Stop(hup)
+		close(done)
+		// A reload in progress finishes before the collector is closed
+		<-stopped
+	}
+}
@@ -0,0 +1,751 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ercansavas/gonder/internal/config"
+	"github.com/ercansavas/gonder/pkg/analytics"
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/backfill"
+	"github.com/ercansavas/gonder/pkg/cluster"
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/encryption"
+	"github.com/ercansavas/gonder/pkg/fleet"
+	"github.com/ercansavas/gonder/pkg/forward"
+	"github.com/ercansavas/gonder/pkg/handler"
+	"github.com/ercansavas/gonder/pkg/hostmetrics"
+	"github.com/ercansavas/gonder/pkg/jobs"
+	"github.com/ercansavas/gonder/pkg/kubernetes"
+	"github.com/ercansavas/gonder/pkg/logstream"
+	"github.com/ercansavas/gonder/pkg/procinfo"
+	"github.com/ercansavas/gonder/pkg/rdns"
+	"github.com/ercansavas/gonder/pkg/rules"
+	"github.com/ercansavas/gonder/pkg/script"
+	"github.com/ercansavas/gonder/pkg/secrets"
+	"github.com/ercansavas/gonder/pkg/snapshot"
+	"github.com/ercansavas/gonder/pkg/tcpinput"
+	"github.com/ercansavas/gonder/pkg/threatintel"
+	"github.com/ercansavas/gonder/pkg/wasm"
+)
+
+// newServeCommand creates `gonder serve`
+func newServeCommand() *cobra.Command {
+	var readOnly bool
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run the log collector and HTTP API",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := config.Load()
+			applyConfigFlags(cmd, cfg)
+			if cmd.Flags().Changed("read-only") {
+				cfg.ReadOnly = readOnly
+			}
+			return runServe(cfg)
+		},
+	}
+	addConfigFlags(cmd)
+	cmd.Flags().BoolVar(&readOnly, "read-only", false, "Reject API calls that change the server (overrides READ_ONLY)")
+	return cmd
+}
+
+// addConfigFlags registers flags that override environment configuration
+func addConfigFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.String("port", "", "HTTP port (overrides PORT)")
+	flags.String("host", "", "Host address (overrides HOST)")
+	flags.String("sources", "", "JSON file with log sources (overrides SOURCES_FILE)")
+	flags.String("output-file", "", "Also write collected logs to this NDJSON file (overrides OUTPUT_FILE)")
+	flags.String("checkpoint-file", "", "Persist source positions to this file (overrides CHECKPOINT_FILE)")
+	flags.String("console-format", "", "Console log format: json or pretty (overrides CONSOLE_FORMAT)")
+}
+
+// applyConfigFlags copies explicitly set flags onto cfg
+func applyConfigFlags(cmd *cobra.Command, cfg *config.Config) {
+	flags := cmd.Flags()
+	overrides := map[string]*string{
+		"port":            &cfg.Port,
+		"host":            &cfg.Host,
+		"sources":         &cfg.SourcesFile,
+		"output-file":     &cfg.OutputFile,
+		"checkpoint-file": &cfg.CheckpointFile,
+		"console-format":  &cfg.ConsoleFormat,
+	}
+	for name, target := range overrides {
+		if flags.Lookup(name) != nil && flags.Changed(name) {
+			*target, _ = flags.GetString(name)
+		}
+	}
+}
+
+// loadSources applies the configured sources file to the collector
+func loadSources(lc *collector.LogCollector, cfg *config.Config) error {
+	if cfg.SourcesFile == "" {
+		return nil
+	}
+	sources, err := collector.LoadSourcesFile(cfg.SourcesFile)
+	if err != nil {
+		return err
+	}
+	return lc.SetSources(sources)
+}
+
+// loadQuotas applies the ingestion quotas of QUOTAS_FILE
+func loadQuotas(lc *collector.LogCollector, cfg *config.Config) error {
+	if cfg.QuotasFile == "" {
+		return nil
+	}
+	quotas, err := collector.LoadQuotasFile(cfg.QuotasFile)
+	if err != nil {
+		return err
+	}
+	return lc.SetQuotas(quotas)
+}
+
+// loadFieldMap adds the field names of FIELD_MAP_FILE to the default
+// field map
+func loadFieldMap(lc *collector.LogCollector, cfg *config.Config) error {
+	if cfg.FieldMapFile == "" {
+		return nil
+	}
+	fieldMap, err := collector.LoadFieldMapFile(cfg.FieldMapFile)
+	if err != nil {
+		return err
+	}
+	return lc.SetFieldMap(fieldMap)
+}
+
+// addKubernetesEvents adds the Kubernetes events watcher when K8S_EVENTS is
+// set
+func addKubernetesEvents(lc *collector.LogCollector, cfg *config.Config) error {
+	if !cfg.KubernetesEvents {
+		return nil
+	}
+	source, err := kubernetes.NewEventSource(kubernetes.Config{
+		Namespace: cfg.KubernetesEventsNamespace,
+		APIServer: cfg.KubernetesAPIServer,
+		TokenFile: cfg.KubernetesTokenFile,
+		CAFile:    cfg.KubernetesCAFile,
+	})
+	if err != nil {
+		return fmt.Errorf("kubernetes events: %w", err)
+	}
+	return lc.AddSource(source)
+}
+
+// addInputs adds the TCP inputs of INPUTS_FILE
+func addInputs(lc *collector.LogCollector, cfg *config.Config) error {
+	if cfg.InputsFile == "" {
+		return nil
+	}
+	inputs, err := tcpinput.LoadFile(cfg.InputsFile)
+	if err != nil {
+		return err
+	}
+	for _, inputConfig := range inputs {
+		input, err := tcpinput.New(inputConfig)
+		if err != nil {
+			return err
+		}
+		if err := lc.AddSource(input); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statusLevelRules applies the web access level settings to the defaults
+func statusLevelRules(cfg *config.Config) collector.StatusLevelRules {
+	rules := collector.DefaultStatusLevelRules()
+	rules.ClientError = collector.LogLevel(cfg.WebClientErrorLevel)
+	rules.SlowThreshold = cfg.WebSlowRequestThreshold
+	return rules
+}
+
+// breakerPolicy returns the circuit breaker settings of sources and outputs
+func breakerPolicy(cfg *config.Config) collector.BreakerPolicy {
+	return collector.BreakerPolicy{
+		Failures:    cfg.CircuitBreakerFailures,
+		Cooldown:    cfg.CircuitBreakerCooldown,
+		MaxCooldown: cfg.CircuitBreakerMaxCooldown,
+	}
+}
+
+// loadPlugins creates the WASM plugin manager and loads PLUGIN_DIR. It runs
+// before the sources are loaded since parser plugins add source types.
+func loadPlugins(cfg *config.Config) (*wasm.Manager, error) {
+	manager := wasm.NewManager(wasm.Limits{
+		Timeout:     cfg.PluginTimeout,
+		MemoryLimit: cfg.PluginMemoryLimit,
+	})
+	if cfg.PluginDir == "" {
+		return manager, nil
+	}
+	return manager, manager.LoadDir(cfg.PluginDir)
+}
+
+// loadScript loads the SCRIPT_FILE stage; it returns nil when no script is
+// configured
+func loadScript(cfg *config.Config) (*script.Stage, error) {
+	if cfg.ScriptFile == "" {
+		return nil, nil
+	}
+	return script.New(cfg.ScriptFile, cfg.ScriptTimeout)
+}
+
+// startHostMetrics starts the host metrics sampler and adds its enrichment
+// stage; it returns nil when HOST_METRICS is off
+func startHostMetrics(lc *collector.LogCollector, cfg *config.Config) (*hostmetrics.Sampler, error) {
+	if !cfg.HostMetrics {
+		return nil, nil
+	}
+	samplerCfg := hostmetrics.Config{
+		Interval: cfg.HostMetricsInterval,
+		DiskPath: cfg.HostMetricsDiskPath,
+	}
+	if cfg.HostMetricsEmit {
+		samplerCfg.OnSample = func(snapshot hostmetrics.Snapshot) {
+			lc.IngestLog(snapshot.Log())
+		}
+	}
+	sampler, err := hostmetrics.New(samplerCfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := lc.AddProcessor(sampler.Processor(collector.LogLevel(cfg.HostMetricsLevel))); err != nil {
+		return nil, err
+	}
+	sampler.Start()
+	return sampler, nil
+}
+
+// addProcessEnrichment adds the PID enrichment stage when
+// PROCESS_ENRICHMENT is set
+func addProcessEnrichment(lc *collector.LogCollector, cfg *config.Config) error {
+	if !cfg.ProcessEnrichment {
+		return nil
+	}
+	enricher, err := procinfo.New()
+	if err != nil {
+		return err
+	}
+	return lc.AddProcessor(enricher.Processor())
+}
+
+// loadThreatIntel loads THREAT_INTEL_FEEDS; it returns nil when no feeds
+// are configured. Feeds that fail to load are retried on refresh, so the
+// matcher is returned along with the error.
+func loadThreatIntel(cfg *config.Config, auditLogger *audit.Logger) (*threatintel.Intel, error) {
+	if len(cfg.ThreatIntelFeeds) == 0 {
+		return nil, nil
+	}
+	return threatintel.New(threatintel.Config{
+		Feeds:         cfg.ThreatIntelFeeds,
+		Refresh:       cfg.ThreatIntelRefresh,
+		AlertSources:  cfg.ThreatIntelAlertSources,
+		AlertLevel:    collector.LogLevel(cfg.ThreatIntelAlertLevel),
+		AlertCooldown: cfg.ThreatIntelAlertCooldown,
+	}, auditLogger)
+}
+
+// loadRules compiles RULES_FILE; it returns nil when no rules file is
+// configured
+func loadRules(cfg *config.Config, auditLogger *audit.Logger) (*rules.Engine, error) {
+	if cfg.RulesFile == "" {
+		return nil, nil
+	}
+	configs, err := rules.LoadFile(cfg.RulesFile)
+	if err != nil {
+		return nil, err
+	}
+	return rules.New(configs, rulesPolicy(cfg), auditLogger)
+}
+
+// rulesPolicy is the action policy of rules, also applied to simulated ones
+func rulesPolicy(cfg *config.Config) rules.Policy {
+	return rules.Policy{
+		AllowedCommands: cfg.ActionsAllowedCommands,
+		DryRun:          cfg.ActionsDryRun,
+		Timeout:         cfg.ActionTimeout,
+	}
+}
+
+// loadCipher loads the encryption key for the spool and checkpoints, or
+// returns nil when none is configured
+func loadCipher(cfg *config.Config) (*encryption.Cipher, error) {
+	return encryption.Load(encryption.KeySource{
+		Key:     cfg.EncryptionKey,
+		File:    cfg.EncryptionKeyFile,
+		Command: cfg.EncryptionKeyCommand,
+	})
+}
+
+// setupCluster creates the cluster node and the fleet registry. Without
+// CLUSTER_STORE the node is standalone and the fleet configuration is kept in
+// FLEET_CONFIG_FILE; in a cluster it is shared through the cluster store and
+// refreshed from it until ctx is cancelled.
+func setupCluster(ctx context.Context, cfg *config.Config, auditLogger *audit.Logger) (*cluster.Node, *fleet.Registry, error) {
+	nodeCfg := cluster.Config{
+		NodeID:      cfg.ClusterNodeID,
+		Address:     cfg.Host + ":" + cfg.Port,
+		Version:     version,
+		LeaseTTL:    cfg.ClusterLeaseTTL,
+		DedupWindow: cfg.DedupWindow,
+	}
+
+	if cfg.ClusterStore == "" {
+		registry, err := fleet.NewRegistry(cfg.FleetConfigFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cluster.New(nodeCfg, nil, auditLogger), registry, nil
+	}
+
+	store, err := cluster.OpenDirStore(cfg.ClusterStore)
+	if err != nil {
+		return nil, nil, err
+	}
+	node := cluster.New(nodeCfg, store, auditLogger)
+	registry, err := fleet.NewRegistryWithBackend(node.DocumentBackend("fleet"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Pick up configuration pushed through other aggregators
+	go func() {
+		ticker := time.NewTicker(cfg.ClusterLeaseTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := registry.Refresh(); err != nil {
+					auditLogger.LogError(err, "Fleet configuration refresh failed", nil)
+				}
+			}
+		}
+	}()
+	return node, registry, nil
+}
+
+// prettyAuditEvent formats audit events like the entries of the pretty
+// console output
+func prettyAuditEvent(colored bool) func(audit.AuditEvent) string {
+	return func(event audit.AuditEvent) string {
+		entry := collector.SystemLog{
+			Timestamp: event.Timestamp,
+			Source:    "audit",
+			Level:     collector.LevelInfo,
+			Message:   event.Message,
+		}
+		switch event.EventType {
+		case audit.EventTypeError:
+			entry.Level = collector.LevelError
+		case audit.EventTypeAuthFailure, audit.EventTypeSlowRequest, audit.EventTypeLargeResponse,
+			audit.EventTypeRepeated, "source_circuit_open", "output_circuit_open":
+			entry.Level = collector.LevelWarn
+		}
+		// LogError messages and their repeat summaries already hold the error
+		if event.Error != "" && !strings.Contains(event.Message, event.Error) {
+			entry.Message += ": " + event.Error
+		}
+		return collector.FormatPretty(&entry, colored)
+	}
+}
+
+// runServe starts the collector and serves the HTTP API until shutdown
+func runServe(cfg *config.Config) error {
+	// Start audit logger
+	auditLogger := audit.New()
+	consoleColor, colorErr := useColor(cfg.ConsoleColor, os.Stdout)
+	if cfg.ConsoleFormat == collector.ConsoleFormatPretty {
+		auditLogger.SetFormat(prettyAuditEvent(consoleColor))
+	}
+	auditLogger.SetRequestThresholds(cfg.SlowRequestThreshold, cfg.LargeResponseThreshold)
+	auditLogger.SetRepeatWindow(cfg.AuditRepeatWindow)
+	auditWebhooks, err := audit.NewWebhooks(auditLogger, cfg.AuditWebhooksFile)
+	if err != nil {
+		auditLogger.LogError(err, "Audit webhooks configuration error", map[string]interface{}{"path": cfg.AuditWebhooksFile})
+		return err
+	}
+
+	ln, handover, err := listen(":" + cfg.Port)
+	if err != nil {
+		auditLogger.LogError(err, "Listener error", nil)
+		return err
+	}
+	tlsConfig, err := serverTLSConfig(cfg)
+	if err != nil {
+		auditLogger.LogError(err, "TLS configuration error", nil)
+		return err
+	}
+	cipher, err := loadCipher(cfg)
+	if err != nil {
+		auditLogger.LogError(err, "Encryption key error", nil)
+		return err
+	}
+
+	// Start log collector
+	collector.SetNodeID(cfg.NodeID)
+	logCollector := collector.New(auditLogger)
+	plugins, err := loadPlugins(cfg)
+	if err != nil {
+		auditLogger.LogError(err, "Plugin load error", map[string]interface{}{"dir": cfg.PluginDir})
+	}
+	// Secrets are redacted before any other stage sees the entry
+	if cfg.SecretDetection {
+		logCollector.AddProcessor(secrets.New(secrets.Config{
+			Redact:        cfg.SecretRedact,
+			AlertCooldown: cfg.SecretAlertCooldown,
+		}, auditLogger).Processor())
+	}
+	// Enrichment comes first so plugins and scripts see it
+	hostMetrics, err := startHostMetrics(logCollector, cfg)
+	if err != nil {
+		auditLogger.LogError(err, "Host metrics error", nil)
+	}
+	if err := addProcessEnrichment(logCollector, cfg); err != nil {
+		auditLogger.LogError(err, "Process enrichment error", nil)
+	}
+	if cfg.ReverseDNS {
+		logCollector.AddProcessor(rdns.New(rdns.Config{
+			Timeout:     cfg.ReverseDNSTimeout,
+			CacheSize:   cfg.ReverseDNSCacheSize,
+			TTL:         cfg.ReverseDNSTTL,
+			NegativeTTL: cfg.ReverseDNSNegativeTTL,
+		}).Processor())
+	}
+	threatIntel, err := loadThreatIntel(cfg, auditLogger)
+	if err != nil {
+		auditLogger.LogError(err, "Threat intel load error", nil)
+	}
+	if threatIntel != nil {
+		logCollector.AddProcessor(threatIntel.Processor())
+		threatIntel.Start()
+	}
+	logCollector.AddProcessor(plugins.Processor())
+	scriptStage, err := loadScript(cfg)
+	if err != nil {
+		auditLogger.LogError(err, "Script load error", map[string]interface{}{"path": cfg.ScriptFile})
+	} else if scriptStage != nil {
+		logCollector.AddProcessor(scriptStage)
+	}
+	// Rules see entries after every other stage
+	ruleEngine, err := loadRules(cfg, auditLogger)
+	if err != nil {
+		auditLogger.LogError(err, "Rules configuration error", map[string]interface{}{"path": cfg.RulesFile})
+		return err
+	}
+	if ruleEngine != nil {
+		logCollector.AddProcessor(ruleEngine.Processor())
+	}
+	var tracker *analytics.Tracker
+	if cfg.TopRetention > 0 {
+		tracker = analytics.New(analytics.Config{Retention: cfg.TopRetention, Capacity: cfg.TopCapacity})
+		logCollector.AddProcessor(tracker.Processor())
+	}
+	if err := loadSources(logCollector, cfg); err != nil {
+		auditLogger.LogError(err, "Log source configuration error", nil)
+		return err
+	}
+	if err := loadQuotas(logCollector, cfg); err != nil {
+		auditLogger.LogError(err, "Quota configuration error", map[string]interface{}{"path": cfg.QuotasFile})
+		return err
+	}
+	if err := loadFieldMap(logCollector, cfg); err != nil {
+		auditLogger.LogError(err, "Field map configuration error", map[string]interface{}{"path": cfg.FieldMapFile})
+		return err
+	}
+	if err := addKubernetesEvents(logCollector, cfg); err != nil {
+		auditLogger.LogError(err, "Kubernetes events configuration error", nil)
+		return err
+	}
+	if err := addInputs(logCollector, cfg); err != nil {
+		auditLogger.LogError(err, "Input configuration error", map[string]interface{}{"path": cfg.InputsFile})
+		return err
+	}
+	if colorErr != nil {
+		auditLogger.LogError(colorErr, "Console color configuration error", map[string]interface{}{"value": cfg.ConsoleColor})
+	}
+	if err := logCollector.ConfigureOutputs(collector.OutputConfig{
+		FilePath:      cfg.OutputFile,
+		BufferSize:    cfg.OutputBufferSize,
+		FlushInterval: cfg.OutputFlushInterval,
+		ConsoleFormat: cfg.ConsoleFormat,
+		ConsoleColor:  consoleColor,
+	}); err != nil {
+		auditLogger.LogError(err, "Log output configuration error", nil)
+	}
+	archiveOutput, err := addArchiveOutput(logCollector, cfg, auditLogger)
+	if err != nil {
+		auditLogger.LogError(err, "Archive output configuration error", nil)
+	}
+
+	logCollector.SetStatusLevelRules(statusLevelRules(cfg))
+	if cfg.SelfMonitor {
+		logCollector.EnableSelfMonitoring(cfg.SelfMonitorExclude)
+	}
+	logCollector.EnableContext(cfg.ContextBuffer)
+	logCollector.SetFileWatch(cfg.WatchFiles)
+	if err := logCollector.SetBreakerPolicy(breakerPolicy(cfg)); err != nil {
+		auditLogger.LogError(err, "Circuit breaker configuration error", nil)
+	}
+
+	// Startup audit log
+	auditLogger.LogStartup(cfg.Port, map[string]interface{}{
+		"host":      cfg.Host,
+		"log_level": cfg.LogLevel,
+		"version":   version,
+		"purpose":   "system_log_collection",
+		"features": []string{
+			"system_log_collection",
+			"audit_logging",
+			"real_time_monitoring",
+			"log_parsing",
+			"structured_output",
+		},
+	})
+
+	// Start handlers
+	h := handler.New(auditLogger)
+	logHandler := handler.NewLogHandler(logCollector)
+
+	debugHandler := handler.NewDebugHandler(logCollector)
+	clusterCtx, stopCluster := context.WithCancel(context.Background())
+	defer stopCluster()
+	clusterNode, fleetRegistry, err := setupCluster(clusterCtx, cfg, auditLogger)
+	if err != nil {
+		auditLogger.LogError(err, "Cluster configuration error", nil)
+		return err
+	}
+	fleetRegistry.SetClockSkewTolerance(cfg.ClockSkewTolerance)
+	agentHandler := handler.NewAgentHandler(logCollector, fleetRegistry, clusterNode, auditLogger, cfg.AgentToken)
+	agentHandler.SetClockSkewCorrection(cfg.ClockSkewCorrect)
+	fleetHandler := handler.NewFleetHandler(fleetRegistry, auditLogger)
+	clusterHandler := handler.NewClusterHandler(clusterNode)
+	otlpHandler := handler.NewOTLPHandler(logCollector, auditLogger)
+	alertmanagerHandler := handler.NewAlertmanagerHandler(logCollector, auditLogger)
+	pluginHandler := handler.NewPluginHandler(plugins, auditLogger)
+	scriptHandler := handler.NewScriptHandler(scriptStage, auditLogger)
+	threatIntelHandler := handler.NewThreatIntelHandler(threatIntel, auditLogger)
+	rulesHandler := handler.NewRulesHandler(ruleEngine)
+	pipelineHandler := handler.NewPipelineHandler(logCollector, rulesPolicy(cfg))
+	jobManager, err := jobs.New(jobs.Config{File: cfg.JobsFile}, auditLogger)
+	if err != nil {
+		auditLogger.LogError(err, "Job records could not be loaded", nil)
+		return err
+	}
+	backfiller := backfill.New(logCollector, jobManager)
+	jobsHandler := handler.NewJobsHandler(jobManager, backfiller)
+	catalogHandler := handler.NewCatalogHandler(logCollector, backfiller)
+	snapshotHandler := handler.NewSnapshotHandler(snapshot.New(snapshot.Config{
+		Dir:            cfg.SnapshotDir,
+		Keep:           cfg.SnapshotKeep,
+		UploadURL:      cfg.SnapshotUploadURL,
+		UploadToken:    cfg.SnapshotUploadToken,
+		ArchiveDir:     cfg.ArchiveDir,
+		CheckpointFile: cfg.CheckpointFile,
+		CatalogFile:    cfg.CatalogFile,
+	}, logCollector, archiveOutput, jobManager))
+	storeHandler := handler.NewStoreHandler(archiveOutput)
+	analyticsHandler := handler.NewAnalyticsHandler(tracker)
+	analyticsHandler.SetCache(cfg.TopCacheTTL, cfg.TopCacheSize)
+	grafanaHandler := handler.NewGrafanaHandler(tracker)
+	graphqlHandler := handler.NewGraphQLHandler(logCollector, tracker, ruleEngine)
+	logStreamHandler := handler.NewLogStreamHandler(logCollector)
+	configHandler := handler.NewConfigHandler(logCollector, ruleEngine, cfg.SourcesFile, cfg.RulesFile, auditLogger)
+	configHandler.SetHistory(cfg.ConfigHistorySize)
+	logHandler.SetConfigVersion(configHandler.Version)
+	webhookHandler := handler.NewWebhookHandler(auditWebhooks, auditLogger)
+	statsHandler := handler.NewStatsHandler(auditLogger.HTTPStats())
+	maintenanceHandler := handler.NewMaintenanceHandler(logCollector, auditLogger)
+
+	// A dedicated mux, so nothing registered on http.DefaultServeMux (e.g.
+	// by net/http/pprof) is exposed unauthenticated
+	router := handler.NewRouter(auditLogger, cfg.AdminToken, cfg.IngestToken)
+	get, post := []string{http.MethodGet}, []string{http.MethodPost}
+	getPost := []string{http.MethodGet, http.MethodPost}
+	router.SetReadOnly(cfg.ReadOnly)
+	router.SetIdempotency(cfg.IdempotencyTTL, cfg.IdempotencySize)
+	if cfg.UIUsersFile != "" {
+		users, err := handler.LoadUsersFile(cfg.UIUsersFile)
+		if err != nil {
+			auditLogger.LogError(err, "UI users configuration error", map[string]interface{}{"path": cfg.UIUsersFile})
+			return err
+		}
+		sessions, err := handler.NewSessionStore(users, cfg.SessionTTL)
+		if err != nil {
+			auditLogger.LogError(err, "UI users configuration error", map[string]interface{}{"path": cfg.UIUsersFile})
+			return err
+		}
+		router.SetSessions(sessions)
+		sessionHandler := handler.NewSessionHandler(sessions, auditLogger)
+		router.Handle(handler.Endpoint{Path: handler.LoginPath, Methods: getPost, Description: "Log in to the UI with a username and password"}, sessionHandler.Login)
+		router.Handle(handler.Endpoint{Path: handler.LogoutPath, Methods: post, Description: "End the UI session (needs its CSRF token)"}, sessionHandler.Logout)
+		router.Handle(handler.Endpoint{Path: handler.SessionPath, Methods: get, Description: "User and CSRF token of the UI session"}, sessionHandler.Session)
+	}
+
+	router.Handle(handler.Endpoint{Path: "/", Methods: get, Description: "Home page"}, h.Home)
+	router.Handle(handler.Endpoint{Path: "/api/health", Methods: get, Description: "System health check"}, h.Health)
+	router.Handle(handler.Endpoint{Path: "/api/errors", Methods: get, Description: "Error code catalog"}, h.Errors)
+	router.Handle(handler.Endpoint{Path: "/api/endpoints", Methods: get, Description: "This list of endpoints"}, router.ListEndpoints)
+
+	// Log management endpoints
+	router.Handle(handler.Endpoint{Path: "/api/logs/status", Methods: get, Description: "Log collector status"}, logHandler.GetStatus)
+	router.Handle(handler.Endpoint{Path: "/api/logs/sources", Methods: get, Description: "List log sources"}, logHandler.GetSources)
+	router.Handle(handler.Endpoint{Path: "/api/logs/top", Methods: get, Description: "Top services, hosts, paths, statuses or message templates"}, analyticsHandler.Top)
+	router.Handle(handler.Endpoint{Path: "/api/logs/histogram", Methods: get, Description: "Log counts over time for charts"}, analyticsHandler.Histogram)
+	router.Handle(handler.Endpoint{Path: handler.GrafanaPath, Methods: getPost, Description: "Grafana JSON data source: /search, /query, /tag-keys, /tag-values"}, grafanaHandler.Serve)
+	router.Handle(handler.Endpoint{Path: "/api/logs/start", Methods: post, Description: "Start log collector", Mutating: true}, logHandler.StartCollector)
+	router.Handle(handler.Endpoint{Path: "/api/logs/stop", Methods: post, Description: "Stop log collector", Mutating: true}, logHandler.StopCollector)
+	router.Handle(handler.Endpoint{Path: "/api/cluster/status", Methods: get, Description: "Cluster members and leader"}, clusterHandler.Status)
+
+	// Push ingestion (ingest token required when configured)
+	router.Handle(handler.Endpoint{Path: "/v1/logs", Methods: post, Auth: handler.AuthIngest, Description: "OTLP/HTTP log receiver (protobuf or JSON)"}, otlpHandler.Logs)
+	router.Handle(handler.Endpoint{Path: "/api/alerts/alertmanager", Methods: post, Auth: handler.AuthIngest, Description: "Alertmanager webhook receiver"}, alertmanagerHandler.Webhook)
+
+	// Agent ingestion (agent token required)
+	router.Handle(handler.Endpoint{Path: forward.IngestPath, Methods: post, Auth: handler.AuthAgent, Description: "Receive batches from agents"}, agentHandler.Ingest)
+	router.Handle(handler.Endpoint{Path: fleet.HeartbeatPath, Methods: post, Auth: handler.AuthAgent, Description: "Agent heartbeats and config delivery"}, agentHandler.Heartbeat)
+
+	// Diagnostics endpoints (admin token required)
+	router.Handle(handler.Endpoint{Path: "/api/agents", Methods: get, Auth: handler.AuthAdmin, Description: "List agents with health and lag"}, fleetHandler.ListAgents)
+	router.Handle(handler.Endpoint{Path: "/api/agents/", Methods: []string{http.MethodGet, http.MethodPut, http.MethodDelete}, Auth: handler.AuthAdmin, Description: "Inspect or forget agents and push configuration: /{id}[/config]", Mutating: true}, fleetHandler.Agent)
+	router.Handle(handler.Endpoint{Path: "/api/plugins", Methods: get, Auth: handler.AuthAdmin, Description: "Loaded WASM plugins with their counters"}, pluginHandler.ListPlugins)
+	router.Handle(handler.Endpoint{Path: "/api/plugins/", Methods: []string{http.MethodPut, http.MethodDelete}, Auth: handler.AuthAdmin, Description: "Upload or unload a WASM plugin: /{name}", Mutating: true}, pluginHandler.Plugin)
+	router.Handle(handler.Endpoint{Path: "/api/script", Methods: getPost, Auth: handler.AuthAdmin, Description: "Lua script stage counters, POST to reload", Mutating: true}, scriptHandler.Script)
+	router.Handle(handler.Endpoint{Path: "/api/threatintel", Methods: getPost, Auth: handler.AuthAdmin, Description: "Threat feed status and matches, POST to reload", Mutating: true}, threatIntelHandler.ThreatIntel)
+	router.Handle(handler.Endpoint{Path: "/api/usage", Methods: get, Auth: handler.AuthAdmin, Description: "Daily bytes and entries per source, tenant and output"}, logHandler.GetUsage)
+	router.Handle(handler.Endpoint{Path: "/api/quotas", Methods: get, Auth: handler.AuthAdmin, Description: "Ingestion quota usage and dropped lines"}, logHandler.GetQuotas)
+	router.Handle(handler.Endpoint{Path: "/api/admin/maintenance", Methods: getPost, Auth: handler.AuthAdmin, Description: "Pause ingestion and drain outputs for maintenance, or resume", Mutating: true}, maintenanceHandler.Maintenance)
+	router.Handle(handler.Endpoint{Path: "/api/stats/http", Methods: get, Auth: handler.AuthAdmin, Description: "Requests, errors and latency percentiles per endpoint"}, statsHandler.HTTP)
+	router.Handle(handler.Endpoint{Path: "/metrics", Methods: get, Auth: handler.AuthAdmin, Description: "HTTP request metrics in the Prometheus text format"}, statsHandler.Metrics)
+	router.Handle(handler.Endpoint{Path: "/api/rules", Methods: get, Auth: handler.AuthAdmin, Description: "Alert rule counters and response action outcomes"}, rulesHandler.Rules)
+	router.Handle(handler.Endpoint{Path: "/api/logs/sources/batch", Methods: post, Auth: handler.AuthAdmin, Description: "Create, update and delete many sources with per-operation results", Mutating: true}, configHandler.SourcesBatch)
+	router.Handle(handler.Endpoint{Path: "/api/rules/batch", Methods: post, Auth: handler.AuthAdmin, Description: "Create, update and delete many alert rules with per-operation results", Mutating: true}, configHandler.RulesBatch)
+	router.Handle(handler.Endpoint{Path: handler.AuditWebhooksPath, Methods: get, Auth: handler.AuthAdmin, Description: "Webhooks notified of audit events, with delivery counters"}, webhookHandler.List)
+	router.Handle(handler.Endpoint{Path: handler.AuditWebhooksPath + "/", Methods: []string{http.MethodPut, http.MethodDelete}, Auth: handler.AuthAdmin, Description: "Register or remove an audit webhook: /{name}", Mutating: true}, webhookHandler.Webhook)
+	router.Handle(handler.Endpoint{Path: "/api/config", Methods: get, Auth: handler.AuthAdmin, Description: "Current sources and alert rules, as taken by /api/config/apply"}, configHandler.Config)
+	router.Handle(handler.Endpoint{Path: "/api/config/apply", Methods: post, Auth: handler.AuthAdmin, Description: "Reconcile sources and alert rules with a desired state, dry_run=true to plan", Mutating: true}, configHandler.Apply)
+	router.Handle(handler.Endpoint{Path: "/api/config/reload", Methods: post, Auth: handler.AuthAdmin, Description: "Reload SOURCES_FILE and RULES_FILE", Mutating: true}, configHandler.ReloadFiles)
+	router.Handle(handler.Endpoint{Path: "/api/config/history", Methods: get, Auth: handler.AuthAdmin, Description: "Last revisions of the sources and alert rules with masked diffs"}, configHandler.History)
+	router.Handle(handler.Endpoint{Path: "/api/config/rollback/", Methods: post, Auth: handler.AuthAdmin, Description: "Restore a configuration revision from the history: /{revision}, dry_run=true to plan", Mutating: true}, configHandler.Rollback)
+	router.Handle(handler.Endpoint{Path: "/api/pipeline/simulate", Methods: post, Auth: handler.AuthAdmin, Description: "Dry-run sample lines through candidate source, filters and rules"}, pipelineHandler.Simulate)
+	router.Handle(handler.Endpoint{Path: handler.JobsPath, Methods: get, Auth: handler.AuthAdmin, Description: "List background jobs with their state and progress"}, jobsHandler.List)
+	router.Handle(handler.Endpoint{Path: handler.JobsPath + "/", Methods: []string{http.MethodGet, http.MethodDelete}, Auth: handler.AuthAdmin, Description: "Background job status, DELETE to cancel: /{id}", Mutating: true}, jobsHandler.Job)
+	router.Handle(handler.Endpoint{Path: handler.CatalogPath, Methods: get, Auth: handler.AuthAdmin, Description: "List the files the collector has tailed"}, catalogHandler.List)
+	router.Handle(handler.Endpoint{Path: handler.CatalogPath + "/", Methods: getPost, Auth: handler.AuthAdmin, Description: "Catalogued file, POST /{id}/reingest to read it again as a backfill job", Mutating: true}, catalogHandler.File)
+	router.Handle(handler.Endpoint{Path: handler.BackfillPath, Methods: getPost, Auth: handler.AuthAdmin, Description: "List backfill jobs, POST to ingest historical files", Mutating: true}, jobsHandler.Backfill)
+	router.Handle(handler.Endpoint{Path: handler.StoreStatusPath, Methods: get, Auth: handler.AuthAdmin, Description: "Archive objects, disk usage against capacity, compaction backlog and days until full"}, storeHandler.Status)
+	router.Handle(handler.Endpoint{Path: handler.StoreSummariesPath, Methods: get, Auth: handler.AuthAdmin, Description: "Hourly summaries of downsampled archive entries"}, storeHandler.Summaries)
+	router.Handle(handler.Endpoint{Path: handler.StoreHoldsPath, Methods: getPost, Auth: handler.AuthAdmin, Description: "List archive holds, POST to keep a time range immutable", Mutating: true}, storeHandler.Holds)
+	router.Handle(handler.Endpoint{Path: handler.StoreHoldsPath + "/", Methods: []string{http.MethodPut, http.MethodDelete}, Auth: handler.AuthAdmin, Description: "Extend or release an archive hold: /{id}", Mutating: true}, storeHandler.Hold)
+	router.Handle(handler.Endpoint{Path: handler.SnapshotPath, Methods: post, Auth: handler.AuthAdmin, Description: "Snapshot the archive, checkpoints and catalog as a background job, optionally uploaded", Mutating: true}, snapshotHandler.Create)
+	router.Handle(handler.Endpoint{Path: handler.SnapshotsPath, Methods: get, Auth: handler.AuthAdmin, Description: "List the snapshots kept in SNAPSHOT_DIR"}, snapshotHandler.List)
+	router.Handle(handler.Endpoint{Path: "/api/logs/", Methods: get, Auth: handler.AuthAdmin, Description: "Entries around a log entry from the same source: /{id}/context"}, logHandler.Entry)
+	router.Handle(handler.Endpoint{Path: "/api/logs/stream", Methods: get, Auth: handler.AuthAdmin, Description: "Live stream of matching entries, server-sent events"}, logHandler.Stream)
+	router.Handle(handler.Endpoint{Path: logstream.Path, Methods: post, Auth: handler.AuthAdmin, Description: "Live stream of matching entries, gRPC server streaming over HTTP/2"}, logStreamHandler.StreamLogs)
+	router.Handle(handler.Endpoint{Path: "/api/logs/search", Methods: get, Auth: handler.AuthAdmin, Description: "Search the recent entries kept per source"}, logHandler.Search)
+	router.Handle(handler.Endpoint{Path: handler.GraphQLPath, Methods: getPost, Auth: handler.AuthAdmin, Description: "GraphQL queries over logs, sources, alerts and stats"}, graphqlHandler.Serve)
+	router.Handle(handler.Endpoint{Path: "/api/debug/runtime", Methods: get, Auth: handler.AuthAdmin, Description: "Runtime diagnostics"}, debugHandler.Runtime)
+	router.Handle(handler.Endpoint{Path: "/debug/pprof/", Methods: get, Auth: handler.AuthAdmin, Description: "Go profiling index and profiles"}, pprof.Index)
+	router.Handle(handler.Endpoint{Path: "/debug/pprof/cmdline", Methods: get, Auth: handler.AuthAdmin, Description: "Command line of the process"}, pprof.Cmdline)
+	router.Handle(handler.Endpoint{Path: "/debug/pprof/profile", Methods: get, Auth: handler.AuthAdmin, Description: "CPU profile"}, pprof.Profile)
+	router.Handle(handler.Endpoint{Path: "/debug/pprof/symbol", Methods: getPost, Auth: handler.AuthAdmin, Description: "Symbol lookup"}, pprof.Symbol)
+	router.Handle(handler.Endpoint{Path: "/debug/pprof/trace", Methods: get, Auth: handler.AuthAdmin, Description: "Execution trace"}, pprof.Trace)
+
+	// Backward compatibility (deprecated)
+	router.Handle(handler.Endpoint{Path: "/api/send", Methods: post, Description: "Send message", Deprecated: true}, h.Send)
+
+	// During a hot upgrade, wait for the previous process to flush its
+	// checkpoints before loading them
+	if err := handover.complete(auditLogger); err != nil {
+		auditLogger.LogError(err, "Upgrade handover error", nil)
+	}
+	if cfg.CheckpointFile != "" {
+		if err := logCollector.ConfigureCheckpoints(cfg.CheckpointFile, collector.CheckpointPolicy{
+			FlushEntries:     cfg.CheckpointFlushEntries,
+			FlushInterval:    cfg.CheckpointFlushInterval,
+			Fsync:            cfg.CheckpointFsync,
+			Cipher:           cipher,
+			FingerprintBytes: cfg.CheckpointFingerprintBytes,
+		}); err != nil {
+			auditLogger.LogError(err, "Checkpoint configuration error", nil)
+		}
+	}
+	if err := logCollector.ConfigureUsage(cfg.UsageFile, cfg.UsageRetentionDays); err != nil {
+		auditLogger.LogError(err, "Usage accounting configuration error", nil)
+	}
+	if err := logCollector.ConfigureCatalog(cfg.CatalogFile); err != nil {
+		auditLogger.LogError(err, "File catalog configuration error", nil)
+	}
+
+	clusterNode.Start()
+	// Release the leader lease also when serving fails
+	defer clusterNode.Stop()
+
+	// Auto-start log collector
+	if err := logCollector.Start(); err != nil {
+		auditLogger.LogError(err, "Log collector startup error", nil)
+	}
+	logReady(auditLogger, cfg, router, logCollector, readyDetails{
+		tls:         tlsConfig,
+		cipher:      cipher,
+		hostMetrics: hostMetrics != nil,
+	})
+
+	stopReload := reloadOnHangup(configHandler, auditLogger)
+	return serveHTTP(cfg, ln, tlsConfig, router, logCollector, auditLogger, func(reason string, deadline time.Time) {
+		// The HTTP inputs are closed by now. Stop background jobs and the
+		// sources, let the pipeline finish the entries in flight, flush
+		// the outputs and write the final checkpoints.
+		started := time.Now()
+		stopReload()
+		jobManager.Close()
+		logCollector.Close()
+		if ruleEngine != nil {
+			ruleEngine.Close()
+		}
+		plugins.Close()
+		if scriptStage != nil {
+			scriptStage.Close()
+		}
+		if hostMetrics != nil {
+			hostMetrics.Close()
+		}
+		if threatIntel != nil {
+			threatIntel.Close()
+		}
+
+		// Hand leadership over to another aggregator
+		stopCluster()
+		clusterNode.Stop()
+
+		// Shutdown audit log, after the errors repeated since the last
+		// summaries
+		auditLogger.FlushRepeated()
+		message := "System is shutting down cleanly"
+		if reason == "upgrade" {
+			message = "System handed over to the upgraded process"
+		}
+		auditLogger.LogEvent(audit.AuditEvent{
+			EventType: "system_shutdown",
+			Message:   message,
+			Duration:  time.Since(started).String(),
+			Details:   map[string]interface{}{"reason": reason},
+		})
+		webhookTimeout := 5 * time.Second
+		if !deadline.IsZero() {
+			webhookTimeout = min(webhookTimeout, time.Until(deadline))
+		}
+		auditWebhooks.Close(webhookTimeout)
+	})
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"text/template"
+
+	"github.com/spf13/cobra"
+)
+
+// serviceOptions holds the flags of `gonder service install`
+type serviceOptions struct {
+	name      string
+	mode      string
+	binary    string
+	user      string
+	group     string
+	envFile   string
+	unitDir   string
+	watchdog  string
+	readPaths []string
+	dryRun    bool
+	start     bool
+}
+
+// unitTemplate is a hardened systemd unit. gonder only needs to read log
+// files and write its own state, so the filesystem is read-only apart from
+// StateDirectory and most kernel interfaces are hidden.
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=Gonder system log collection ({{.Mode}})
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=notify
+NotifyAccess=main
+ExecStart={{.Binary}} {{.Mode}}
+{{- if eq .Mode "serve"}}
+ExecReload=/bin/kill -HUP $MAINPID
+{{- end}}
+Restart=on-failure
+RestartSec=5s
+{{- if .Watchdog}}
+WatchdogSec={{.Watchdog}}
+{{- end}}
+TimeoutStopSec=30s
+
+User={{.User}}
+Group={{.Group}}
+# Read access to the usual log files
+SupplementaryGroups=adm systemd-journal
+EnvironmentFile=-{{.EnvFile}}
+Environment=CHECKPOINT_FILE=/var/lib/{{.Name}}/checkpoints.json
+Environment=SPOOL_DIR=/var/lib/{{.Name}}/spool
+StateDirectory={{.Name}}
+WorkingDirectory=/var/lib/{{.Name}}
+
+# Hardening
+NoNewPrivileges=yes
+ProtectSystem=strict
+ProtectHome=yes
+PrivateTmp=yes
+PrivateDevices=yes
+ProtectKernelTunables=yes
+ProtectKernelModules=yes
+ProtectKernelLogs=yes
+ProtectControlGroups=yes
+ProtectClock=yes
+ProtectHostname=yes
+RestrictNamespaces=yes
+RestrictRealtime=yes
+RestrictSUIDSGID=yes
+LockPersonality=yes
+MemoryDenyWriteExecute=yes
+RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6
+SystemCallArchitectures=native
+SystemCallFilter=@system-service
+CapabilityBoundingSet=
+AmbientCapabilities=
+UMask=0027
+{{- range .ReadPaths}}
+ReadOnlyPaths={{.}}
+{{- end}}
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// newServiceCommand creates `gonder service`
+func newServiceCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "service",
+		Short: "Install or remove gonder as a systemd service",
+	}
+	cmd.AddCommand(newServiceInstallCommand(), newServiceUninstallCommand())
+	return cmd
+}
+
+func newServiceInstallCommand() *cobra.Command {
+	opts := serviceOptions{}
+	cmd := &cobra.Command{
+		Use:   "install",
+		Short: "Write a hardened systemd unit and enable it",
+		Long: `Writes a systemd unit running gonder with Type=notify readiness signaling
+and a watchdog, reloads systemd and enables the service. Configuration is
+read from the environment file (see docs/docker/DOCKER.md for variables).
+Use --dry-run to print the unit without installing it.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return installService(opts)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&opts.name, "name", "gonder", "Service name")
+	flags.StringVar(&opts.mode, "mode", "serve", "Command the service runs: serve or agent")
+	flags.StringVar(&opts.binary, "binary", "", "Path of the gonder binary (default: this executable)")
+	flags.StringVar(&opts.user, "user", "gonder", "User the service runs as (created if missing)")
+	flags.StringVar(&opts.group, "group", "gonder", "Group the service runs as")
+	flags.StringVar(&opts.envFile, "env-file", "/etc/gonder/gonder.env", "Environment file with gonder settings")
+	flags.StringVar(&opts.unitDir, "unit-dir", "/etc/systemd/system", "Directory for the unit file")
+	flags.StringVar(&opts.watchdog, "watchdog", "30s", "WatchdogSec value; empty disables the watchdog")
+	flags.StringSliceVar(&opts.readPaths, "read-path", nil, "Extra read-only path the service needs (repeatable)")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "Print the unit instead of installing it")
+	flags.BoolVar(&opts.start, "start", false, "Start the service after installing")
+	return cmd
+}
+
+func newServiceUninstallCommand() *cobra.Command {
+	var name, unitDir string
+	cmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: "Stop, disable and remove the systemd unit",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			unitPath := filepath.Join(unitDir, name+".service")
+			if _, err := os.Stat(unitPath); err != nil {
+				return fmt.Errorf("service %s is not installed: %w", name, err)
+			}
+
+			// Stopping or disabling fails harmlessly if it's not running or enabled
+			systemctl("disable", "--now", name+".service")
+			if err := os.Remove(unitPath); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", unitPath, err)
+			}
+			if err := systemctl("daemon-reload"); err != nil {
+				return err
+			}
+			fmt.Printf("✅ Service %s removed (state in /var/lib/%s was kept)\n", name, name)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "gonder", "Service name")
+	cmd.Flags().StringVar(&unitDir, "unit-dir", "/etc/systemd/system", "Directory of the unit file")
+	return cmd
+}
+
+// installService renders and installs the unit
+func installService(opts serviceOptions) error {
+	if opts.mode != "serve" && opts.mode != "agent" {
+		return fmt.Errorf("unsupported mode %q (use serve or agent)", opts.mode)
+	}
+	if opts.binary == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("cannot determine binary path, use --binary: %w", err)
+		}
+		if opts.binary, err = filepath.EvalSymlinks(exe); err != nil {
+			return err
+		}
+	}
+
+	var unit bytes.Buffer
+	err := unitTemplate.Execute(&unit, map[string]interface{}{
+		"Name":      opts.name,
+		"Mode":      opts.mode,
+		"Binary":    opts.binary,
+		"User":      opts.user,
+		"Group":     opts.group,
+		"EnvFile":   opts.envFile,
+		"Watchdog":  opts.watchdog,
+		"ReadPaths": opts.readPaths,
+	})
+	if err != nil {
+		return err
+	}
+
+	if opts.dryRun {
+		fmt.Print(unit.String())
+		return nil
+	}
+
+	if err := ensureServiceUser(opts.user, opts.group); err != nil {
+		return err
+	}
+
+	unitPath := filepath.Join(opts.unitDir, opts.name+".service")
+	if err := os.WriteFile(unitPath, unit.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", unitPath, err)
+	}
+	fmt.Printf("📝 Wrote %s\n", unitPath)
+
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	if err := systemctl("enable", opts.name+".service"); err != nil {
+		return err
+	}
+	if opts.start {
+		if err := systemctl("restart", opts.name+".service"); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Service %s installed and started\n", opts.name)
+	} else {
+		fmt.Printf("✅ Service %s installed; start it with: systemctl start %s\n", opts.name, opts.name)
+	}
+	if _, err := os.Stat(opts.envFile); err != nil {
+		fmt.Printf("ℹ️  Put settings (PORT, SOURCES_FILE, ...) in %s\n", opts.envFile)
+	}
+	return nil
+}
+
+// ensureServiceUser creates a system user and group when they don't exist
+func ensureServiceUser(user, group string) error {
+	if user == "root" {
+		return nil
+	}
+	if exec.Command("getent", "group", group).Run() != nil {
+		if out, err := exec.Command("groupadd", "--system", group).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to create group %s: %v: %s", group, err, bytes.TrimSpace(out))
+		}
+	}
+	if exec.Command("id", "-u", user).Run() != nil {
+		out, err := exec.Command("useradd", "--system", "--no-create-home", "--shell", "/usr/sbin/nologin", "--gid", group, user).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to create user %s: %v: %s", user, err, bytes.TrimSpace(out))
+		}
+	}
+	return nil
+}
+
+// systemctl runs a systemctl command
+func systemctl(args ...string) error {
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %v: %v: %s", args, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
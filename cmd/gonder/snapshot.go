@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ercansavas/gonder/internal/config"
+	"github.com/ercansavas/gonder/pkg/archive"
+	"github.com/ercansavas/gonder/pkg/snapshot"
+)
+
+// newSnapshotCommand creates `gonder snapshot`
+func newSnapshotCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Work with snapshots taken through /api/store/snapshot",
+	}
+	cmd.AddCommand(newSnapshotRestoreCommand())
+	return cmd
+}
+
+// newSnapshotRestoreCommand creates `gonder snapshot restore`
+func newSnapshotRestoreCommand() *cobra.Command {
+	var publicKey string
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "restore FILE",
+		Short: "Put the archive, checkpoints and catalog of a snapshot in place",
+		Long: `Restores a snapshot into the ARCHIVE_DIR, CHECKPOINT_FILE and CATALOG_FILE
+of the environment (or the flags). The archive is imported like "gonder
+archive import" does, verified before anything is moved; with --public-key
+the manifest signatures must be valid too. Existing checkpoint and catalog
+files are only replaced with --force. Restore before starting the instance,
+with the ENCRYPTION_KEY the snapshot was taken with if its checkpoints are
+encrypted.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var key ed25519.PublicKey
+			if publicKey != "" {
+				var err error
+				if key, err = archive.LoadPublicKey(publicKey); err != nil {
+					return err
+				}
+			}
+			cfg := config.Load()
+			for flag, target := range map[string]*string{"archive-dir": &cfg.ArchiveDir, "checkpoint-file": &cfg.CheckpointFile, "catalog-file": &cfg.CatalogFile} {
+				if cmd.Flags().Changed(flag) {
+					*target = cmd.Flags().Lookup(flag).Value.String()
+				}
+			}
+			meta, skipped, err := snapshot.Restore(args[0], snapshot.Config{
+				ArchiveDir:     cfg.ArchiveDir,
+				CheckpointFile: cfg.CheckpointFile,
+				CatalogFile:    cfg.CatalogFile,
+			}, key, force)
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "✅ Restored snapshot %s taken %s", meta.Name, meta.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+			if meta.Host != "" {
+				fmt.Fprintf(out, " on %s", meta.Host)
+			}
+			fmt.Fprintln(out)
+			for _, name := range skipped {
+				fmt.Fprintf(out, "⚠️ %s skipped: no destination configured\n", name)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String("archive-dir", "", "Archive directory to import into (overrides ARCHIVE_DIR)")
+	cmd.Flags().String("checkpoint-file", "", "Checkpoint file to write (overrides CHECKPOINT_FILE)")
+	cmd.Flags().String("catalog-file", "", "Catalog file to write (overrides CATALOG_FILE)")
+	cmd.Flags().StringVar(&publicKey, "public-key", "", "PEM Ed25519 public key the manifest signatures must verify with")
+	cmd.Flags().BoolVar(&force, "force", false, "replace existing checkpoint and catalog files")
+	return cmd
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ercansavas/gonder/internal/config"
+	"github.com/ercansavas/gonder/pkg/client"
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// tailReconnectDelay is the wait before reconnecting a dropped stream
+const tailReconnectDelay = 2 * time.Second
+
+// newTailCommand creates `gonder tail`
+func newTailCommand() *cobra.Command {
+	var (
+		filter  string
+		url     string
+		token   string
+		color   string
+		asJSON  bool
+		noRetry bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "tail",
+		Short: "Stream matching log entries from a running gonder",
+		Long: `Connects to the live stream of a running gonder and prints the entries
+matching --filter as they are processed, for example
+
+  gonder tail --filter 'level>=error source:nginx'
+
+The filter uses the query language of rules and agent filters. The server
+is http(s)://127.0.0.1:$PORT unless --url is given, and the stream needs
+the admin token (ADMIN_TOKEN or --token). Levels are colored when printing
+to a terminal. A dropped connection is retried every 2s; entries processed
+meanwhile are not shown.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := collector.ParseQuery(filter); err != nil {
+				return err
+			}
+			cfg := config.Load()
+			applyConfigFlags(cmd, cfg)
+			if token == "" {
+				token = cfg.AdminToken
+			}
+			api, err := newLocalClient(cfg, url, token)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			colored, err := useColor(color, out)
+			if err != nil {
+				return err
+			}
+			show := func(entry collector.SystemLog) error {
+				if asJSON {
+					return json.NewEncoder(out).Encode(entry)
+				}
+				_, err := fmt.Fprintln(out, collector.FormatPretty(&entry, colored))
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			for {
+				err := api.Tail(ctx, filter, show)
+				if ctx.Err() != nil {
+					return nil
+				}
+				if errors.Is(err, io.EOF) {
+					err = errors.New("stream closed by the server")
+				}
+				var apiErr *client.APIError
+				if noRetry || (errors.As(err, &apiErr) && apiErr.StatusCode < 500) {
+					return err
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "⚠️ %v, reconnecting...\n", err)
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(tailReconnectDelay):
+				}
+			}
+		},
+	}
+	cmd.Flags().String("port", "", "HTTP port (overrides PORT)")
+	cmd.Flags().StringVarP(&filter, "filter", "f", "", "Query selecting the entries, e.g. 'level>=error source:nginx'")
+	cmd.Flags().StringVar(&url, "url", "", "Server URL (default http(s)://127.0.0.1:$PORT)")
+	cmd.Flags().StringVar(&token, "token", "", "Admin token (default ADMIN_TOKEN)")
+	cmd.Flags().StringVar(&color, "color", "auto", "Color levels: auto, always or never")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print entries as JSON lines")
+	cmd.Flags().BoolVar(&noRetry, "no-reconnect", false, "Exit when the stream drops instead of reconnecting")
+	return cmd
+}
+
+// newLocalClient creates an API client for url, or for the local server on
+// PORT. With TLS_CERT_FILE set the local server is called over https
+// without verifying the certificate, which names the service, not
+// 127.0.0.1.
+func newLocalClient(cfg *config.Config, url, token string) (*client.Client, error) {
+	clientCfg := client.Config{BaseURL: url, Token: token, UserAgent: "gonder-cli/" + version}
+	if url == "" {
+		clientCfg.BaseURL = "http://127.0.0.1:" + cfg.Port
+		if cfg.TLSCertFile != "" {
+			clientCfg.BaseURL = "https://127.0.0.1:" + cfg.Port
+			clientCfg.HTTPClient = &http.Client{
+				Timeout:   client.DefaultTimeout,
+				Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+			}
+		}
+	}
+	return client.New(clientCfg)
+}
+
+// useColor resolves a color setting (--color, CONSOLE_COLOR); auto colors
+// terminals unless NO_COLOR is set
+func useColor(mode string, out io.Writer) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		f, ok := out.(*os.File)
+		if !ok {
+			return false, nil
+		}
+		info, err := f.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf("invalid color %q: must be auto, always or never", mode)
+}
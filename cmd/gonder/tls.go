@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ercansavas/gonder/internal/config"
+	"github.com/ercansavas/gonder/pkg/forward"
+)
+
+// Client certificate modes of TLS_CLIENT_AUTH
+const (
+	clientAuthNone     = "none"
+	clientAuthOptional = "optional"
+	clientAuthRequire  = "require"
+)
+
+// certReloadInterval is how often the certificate files are checked for
+// changes, so renewed certificates are picked up without a restart
+const certReloadInterval = 30 * time.Second
+
+// serverTLSConfig returns the TLS settings of the HTTP listener, or nil
+// when TLS_CERT_FILE is not set
+func serverTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		if cfg.TLSClientCAFile != "" {
+			return nil, fmt.Errorf("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	cert, err := newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cert.getCertificate,
+	}
+
+	mode := cfg.TLSClientAuth
+	if mode == "" {
+		mode = clientAuthNone
+		if cfg.TLSClientCAFile != "" {
+			mode = clientAuthRequire
+		}
+	}
+	switch mode {
+	case clientAuthNone:
+		return tlsConfig, nil
+	case clientAuthOptional:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case clientAuthRequire:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid TLS_CLIENT_AUTH %q (none, optional, require)", mode)
+	}
+	if cfg.TLSClientCAFile == "" {
+		return nil, fmt.Errorf("TLS_CLIENT_AUTH=%s requires TLS_CLIENT_CA_FILE", mode)
+	}
+	if tlsConfig.ClientCAs, err = loadCertPool(cfg.TLSClientCAFile); err != nil {
+		return nil, err
+	}
+	return tlsConfig, nil
+}
+
+// clientAuthMode describes the client certificate mode for the banner
+func clientAuthMode(tlsConfig *tls.Config) string {
+	switch tlsConfig.ClientAuth {
+	case tls.VerifyClientCertIfGiven:
+		return "client certificates verified when given"
+	case tls.RequireAndVerifyClientCert:
+		return "client certificates required"
+	default:
+		return "no client certificates"
+	}
+}
+
+// aggregatorClient returns the HTTP client an agent uses to reach the
+// aggregator, or nil for the default client when no TLS files are set
+func aggregatorClient(cfg *config.Config) (*http.Client, error) {
+	if cfg.AggregatorCAFile == "" && cfg.AggregatorCertFile == "" && cfg.AggregatorKeyFile == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.AggregatorCAFile != "" {
+		pool, err := loadCertPool(cfg.AggregatorCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.AggregatorCertFile != "" || cfg.AggregatorKeyFile != "" {
+		if cfg.AggregatorCertFile == "" || cfg.AggregatorKeyFile == "" {
+			return nil, fmt.Errorf("AGGREGATOR_CERT_FILE and AGGREGATOR_KEY_FILE must be set together")
+		}
+		cert, err := newCertReloader(cfg.AggregatorCertFile, cfg.AggregatorKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = cert.getClientCertificate
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport, Timeout: forward.DefaultTimeout}, nil
+}
+
+// loadCertPool reads a PEM bundle of CA certificates
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file %s: %w", path, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", path)
+	}
+	return pool, nil
+}
+
+// certReloader serves a certificate and key pair, reloading them when
+// either file changes. A failed reload keeps the previous pair.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load reads the pair. r.mu must be held or r not yet shared.
+func (r *certReloader) load() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s: %w", r.certFile, err)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	r.checkedAt = time.Now()
+	return nil
+}
+
+// latestModTime returns the newer modification time of the two files
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to read certificate: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// current returns the pair, reloading it first when the files changed
+func (r *certReloader) current() *tls.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checkedAt) >= certReloadInterval {
+		r.checkedAt = time.Now()
+		if modTime, err := r.latestModTime(); err == nil && !modTime.Equal(r.modTime) {
+			r.load()
+		}
+	}
+	return r.cert
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.current(), nil
+}
+
+func (r *certReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.current(), nil
+}
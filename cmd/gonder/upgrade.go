@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/ercansavas/gonder/internal/config"
+	"github.com/ercansavas/gonder/internal/systemd"
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// Hot upgrades: on SIGUSR2 the running process starts the (possibly
+// replaced) gonder binary with the same arguments and hands it the
+// listening socket. The new process loads its configuration and reports
+// ready; the old one then stops accepting connections, drains in-flight
+// requests, flushes outputs and checkpoints and releases the new process,
+// which restores the checkpoints and starts collecting. Connections
+// arriving meanwhile wait in the shared socket's backlog. Passing the
+// socket needs Unix file descriptor inheritance, see upgrade_unix.go;
+// elsewhere only SIGINT and SIGTERM are handled.
+const (
+	// envUpgrade marks a process started by a hot upgrade
+	envUpgrade = "GONDER_UPGRADE"
+
+	// upgradeReadyTimeout bounds how long the old process waits for the new
+	// one to initialize before giving up and carrying on
+	upgradeReadyTimeout = 30 * time.Second
+	// handoverTimeout bounds how long the new process waits for the old one
+	// to finish flushing
+	handoverTimeout = 60 * time.Second
+	// drainTimeout bounds how long in-flight requests may take on shutdown,
+	// within the overall SHUTDOWN_TIMEOUT
+	drainTimeout = 10 * time.Second
+)
+
+// handover is the new process's end of an upgrade
+type handover struct {
+	ready *os.File
+	done  *os.File
+}
+
+// complete tells the old process this one is ready and waits until it has
+// flushed its state. It is a no-op for a normal start.
+func (h *handover) complete(auditLogger *audit.Logger) error {
+	if h == nil {
+		return nil
+	}
+	defer h.done.Close()
+
+	auditLogger.LogEvent(audit.AuditEvent{
+		EventType: "upgrade_handover",
+		Message:   "Upgrade: new process ready, waiting for the previous one to hand over",
+		Details:   map[string]interface{}{"state": "waiting"},
+	})
+	_, err := h.ready.Write([]byte{1})
+	h.ready.Close()
+	if err != nil {
+		return fmt.Errorf("failed to signal upgrade readiness: %w", err)
+	}
+
+	// The old process closes its end when it's done (or when it dies)
+	h.done.SetReadDeadline(time.Now().Add(handoverTimeout))
+	if _, err := io.Copy(io.Discard, h.done); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("upgrade handover failed: %w", err)
+	}
+	auditLogger.LogEvent(audit.AuditEvent{
+		EventType: "upgrade_handover",
+		Message:   "Upgrade: handover complete",
+		Details:   map[string]interface{}{"state": "complete"},
+	})
+	return nil
+}
+
+// serveHTTP serves handler on ln, over TLS when tlsConfig is set, until a
+// shutdown signal or a completed hot upgrade, then stops accepting requests
+// and runs shutdown, which must be done by the deadline it is given (see
+// enforceShutdownDeadline). It tells systemd the service is ready and
+// keeps watchdog pings flowing only while the collector's locks can be
+// taken, so a deadlocked collector gets restarted.
+func serveHTTP(cfg *config.Config, ln net.Listener, tlsConfig *tls.Config, handler http.Handler, lc *collector.LogCollector, auditLogger *audit.Logger, shutdown func(reason string, deadline time.Time)) error {
+	srv := newHTTPServer(cfg, handler)
+	srv.TLSConfig = tlsConfig
+	// Log streams never finish on their own; end them so draining doesn't
+	// wait for its timeout
+	srv.RegisterOnShutdown(lc.CloseSubscriptions)
+	serveErr := make(chan error, 1)
+	go func() {
+		// The raw listener is kept for upgrades; TLS wraps the accepted
+		// connections
+		limited := limitConnections(ln, cfg.HTTPMaxConnections)
+		if tlsConfig != nil {
+			serveErr <- srv.ServeTLS(limited, "", "")
+			return
+		}
+		serveErr <- srv.Serve(limited)
+	}()
+
+	systemd.Notify(systemd.StateReady)
+	systemd.Status(fmt.Sprintf("Collecting %d sources, listening on %s", len(lc.GetSources()), ln.Addr()))
+	stopWatchdog, _ := systemd.StartWatchdog(func() bool {
+		lc.GetOutputStatuses()
+		lc.GetSourceStatuses()
+		return true
+	})
+	defer stopWatchdog()
+
+	sigCh := make(chan os.Signal, 1)
+	notifySignals(sigCh)
+	defer signal.Stop(sigCh)
+
+	for {
+		select {
+		case err := <-serveErr:
+			return err
+		case sig := <-sigCh:
+			if sig != upgradeSignal {
+				auditLogger.LogEvent(audit.AuditEvent{
+					EventType: "shutdown_signal",
+					Message:   "Shutdown signal received, starting clean shutdown",
+					Details:   map[string]interface{}{"signal": sig.String(), "timeout": cfg.ShutdownTimeout.String()},
+				})
+				systemd.Notify(systemd.StateStopping)
+				deadline, done := enforceShutdownDeadline(cfg.ShutdownTimeout, sigCh, auditLogger)
+				drain(srv, deadline)
+				shutdown("shutdown", deadline)
+				done()
+				return nil
+			}
+
+			auditLogger.LogEvent(audit.AuditEvent{
+				EventType: "upgrade_signal",
+				Message:   "Upgrade signal received, starting new process",
+				Details:   map[string]interface{}{"signal": sig.String()},
+			})
+			process, release, err := startUpgrade(ln)
+			if err != nil {
+				auditLogger.LogError(err, "Upgrade aborted, continuing with the current process", nil)
+				continue
+			}
+
+			// The new process becomes the service's main process
+			systemd.Notify(fmt.Sprintf("MAINPID=%d", process.Pid))
+			deadline, done := enforceShutdownDeadline(cfg.ShutdownTimeout, sigCh, auditLogger)
+			drain(srv, deadline)
+			shutdown("upgrade", deadline)
+			done()
+			release.Close()
+			return nil
+		}
+	}
+}
+
+// drain stops accepting connections and waits for in-flight requests, at
+// most drainTimeout and not past deadline
+func drain(srv *http.Server, deadline time.Time) {
+	if limit := time.Now().Add(drainTimeout); deadline.IsZero() || limit.Before(deadline) {
+		deadline = limit
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	srv.Shutdown(ctx)
+}
+
+// enforceShutdownDeadline bounds a clean shutdown: the process exits with
+// status 1 when it hasn't finished within timeout or when another shutdown
+// signal arrives, so an output that can't be flushed doesn't keep the
+// service from stopping. A zero timeout waits for as long as it takes and
+// returns a zero deadline. Call done once the shutdown finished.
+func enforceShutdownDeadline(timeout time.Duration, sigCh <-chan os.Signal, auditLogger *audit.Logger) (deadline time.Time, done func()) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+		timer := time.NewTimer(timeout)
+		expired = timer.C
+	}
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-finished:
+			return
+		case <-expired:
+			auditLogger.LogEvent(audit.AuditEvent{
+				EventType: audit.EventTypeShutdown,
+				Message:   fmt.Sprintf("Shutdown did not finish within %s, exiting; entries still buffered are lost and sources re-read from their last checkpoint", timeout),
+				Details:   map[string]interface{}{"reason": "timeout", "timeout": timeout.String()},
+			})
+		case sig := <-sigCh:
+			auditLogger.LogEvent(audit.AuditEvent{
+				EventType: audit.EventTypeShutdown,
+				Message:   "Second shutdown signal received, exiting immediately",
+				Details:   map[string]interface{}{"reason": "signal", "signal": sig.String()},
+			})
+		}
+		os.Exit(1)
+	}()
+	return deadline, func() { close(finished) }
+}
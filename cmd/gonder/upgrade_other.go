@@ -0,0 +1,37 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+)
+
+// upgradeSignal is nil: hot upgrades pass the listening socket to the new
+// process as an inherited file descriptor, which this platform doesn't
+// support
+var upgradeSignal os.Signal
+
+// notifySignals relays the shutdown signals to ch
+func notifySignals(ch chan<- os.Signal) {
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+}
+
+// listen binds addr. A process can't inherit a listener here, so a start
+// marked as a hot upgrade fails.
+func listen(addr string) (net.Listener, *handover, error) {
+	if os.Getenv(envUpgrade) != "" {
+		return nil, nil, fmt.Errorf("hot upgrades are not supported on %s", runtime.GOOS)
+	}
+	ln, err := net.Listen("tcp", addr)
+	return ln, nil, err
+}
+
+// startUpgrade reports that hot upgrades are not supported
+func startUpgrade(ln net.Listener) (*os.Process, *os.File, error) {
+	return nil, nil, fmt.Errorf("hot upgrades are not supported on %s", runtime.GOOS)
+}
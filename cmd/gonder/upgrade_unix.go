@@ -0,0 +1,107 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// File descriptors passed to the new process
+const (
+	upgradeListenerFD = 3
+	upgradeReadyFD    = 4
+	upgradeDoneFD     = 5
+)
+
+// upgradeSignal starts a hot upgrade
+var upgradeSignal os.Signal = syscall.SIGUSR2
+
+// notifySignals relays the shutdown signals and upgradeSignal to ch
+func notifySignals(ch chan<- os.Signal) {
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR2)
+}
+
+// listen returns the socket inherited from an upgrading process, or binds
+// addr when started normally
+func listen(addr string) (net.Listener, *handover, error) {
+	if os.Getenv(envUpgrade) == "" {
+		ln, err := net.Listen("tcp", addr)
+		return ln, nil, err
+	}
+	os.Unsetenv(envUpgrade)
+
+	file := os.NewFile(upgradeListenerFD, "listener")
+	ln, err := net.FileListener(file)
+	file.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to inherit listener: %w", err)
+	}
+	return ln, &handover{
+		ready: os.NewFile(upgradeReadyFD, "upgrade-ready"),
+		done:  os.NewFile(upgradeDoneFD, "upgrade-done"),
+	}, nil
+}
+
+// startUpgrade starts a new gonder process with the listening socket and
+// waits for it to become ready. The returned file must be closed once the
+// old process has flushed its state.
+func startUpgrade(ln net.Listener) (*os.Process, *os.File, error) {
+	tcp, ok := ln.(*net.TCPListener)
+	if !ok {
+		return nil, nil, fmt.Errorf("listener does not support handover")
+	}
+	lnFile, err := tcp.File()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer lnFile.Close()
+
+	// Resolve the binary by name so a replaced file is picked up
+	binary, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot find gonder binary: %w", err)
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer readyR.Close()
+	doneR, doneW, err := os.Pipe()
+	if err != nil {
+		readyW.Close()
+		return nil, nil, err
+	}
+
+	cmd := exec.Command(binary, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), envUpgrade+"=1")
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{lnFile, readyW, doneR}
+	err = cmd.Start()
+	readyW.Close()
+	doneR.Close()
+	if err != nil {
+		doneW.Close()
+		return nil, nil, fmt.Errorf("failed to start new process: %w", err)
+	}
+
+	readyR.SetReadDeadline(time.Now().Add(upgradeReadyTimeout))
+	if _, err := readyR.Read(make([]byte, 1)); err != nil {
+		doneW.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, nil, fmt.Errorf("new process did not become ready: %w", err)
+	}
+
+	// Reap the new process if it exits while we are still around
+	go cmd.Wait()
+	return cmd.Process, doneW, nil
+}
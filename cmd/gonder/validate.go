@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ercansavas/gonder/internal/config"
+	"github.com/ercansavas/gonder/pkg/archive"
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/forward"
+	"github.com/ercansavas/gonder/pkg/wasm"
+)
+
+// newValidateCommand creates `gonder validate`
+func newValidateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Validate configuration and log sources without starting the service",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := config.Load()
+			applyConfigFlags(cmd, cfg)
+			return runValidate(cmd.OutOrStdout(), cfg)
+		},
+	}
+	addConfigFlags(cmd)
+	return cmd
+}
+
+// runValidate checks the effective configuration and reports problems.
+// Errors make the command fail; warnings are informational.
+func runValidate(out io.Writer, cfg *config.Config) error {
+	var errs, warnings []string
+
+	if cfg.Port == "" {
+		errs = append(errs, "port is empty")
+	}
+	for _, t := range []struct {
+		name    string
+		timeout time.Duration
+	}{
+		{"HTTP_READ_HEADER_TIMEOUT", cfg.HTTPReadHeaderTimeout},
+		{"HTTP_READ_TIMEOUT", cfg.HTTPReadTimeout},
+		{"HTTP_WRITE_TIMEOUT", cfg.HTTPWriteTimeout},
+		{"HTTP_IDLE_TIMEOUT", cfg.HTTPIdleTimeout},
+	} {
+		if t.timeout < 0 {
+			errs = append(errs, t.name+" must not be negative")
+		}
+	}
+	if cfg.HTTPReadHeaderTimeout == 0 {
+		warnings = append(warnings, "HTTP_READ_HEADER_TIMEOUT is 0, slow clients can hold connections open indefinitely")
+	}
+	if cfg.HTTPMaxHeaderBytes < 0 {
+		errs = append(errs, "HTTP_MAX_HEADER_BYTES must not be negative")
+	}
+	if cfg.HTTPMaxConnections <= 0 {
+		warnings = append(warnings, "HTTP_MAX_CONNECTIONS is not set, concurrent connections are unlimited")
+	}
+	if !isLevel(cfg.WebClientErrorLevel) {
+		errs = append(errs, fmt.Sprintf("WEB_CLIENT_ERROR_LEVEL %q is not a level (debug, info, warn, error, fatal)", cfg.WebClientErrorLevel))
+	}
+	if cfg.HostMetrics {
+		if !isLevel(cfg.HostMetricsLevel) {
+			errs = append(errs, fmt.Sprintf("HOST_METRICS_LEVEL %q is not a level (debug, info, warn, error, fatal)", cfg.HostMetricsLevel))
+		}
+		if cfg.HostMetricsInterval <= 0 {
+			errs = append(errs, "HOST_METRICS_INTERVAL must be positive")
+		}
+	}
+	if cfg.TopRetention < 0 {
+		errs = append(errs, "TOP_RETENTION must not be negative")
+	}
+	if cfg.TopRetention > 0 && cfg.TopCapacity <= 0 {
+		errs = append(errs, "TOP_CAPACITY must be positive")
+	}
+	if cfg.TopCacheTTL < 0 {
+		errs = append(errs, "TOP_CACHE_TTL must not be negative")
+	}
+	if cfg.TopCacheTTL > 0 && cfg.TopCacheSize <= 0 {
+		errs = append(errs, "TOP_CACHE_SIZE must be positive")
+	}
+	if cfg.ContextBuffer < 0 {
+		errs = append(errs, "CONTEXT_BUFFER must not be negative")
+	}
+	if cfg.ReverseDNS && cfg.ReverseDNSTimeout > time.Second {
+		warnings = append(warnings, fmt.Sprintf("REVERSE_DNS_TIMEOUT %s is long; lookups delay collection on cache misses", cfg.ReverseDNSTimeout))
+	}
+	if len(cfg.ThreatIntelFeeds) > 0 {
+		if !isLevel(cfg.ThreatIntelAlertLevel) {
+			errs = append(errs, fmt.Sprintf("THREAT_INTEL_ALERT_LEVEL %q is not a level (debug, info, warn, error, fatal)", cfg.ThreatIntelAlertLevel))
+		}
+		for _, feed := range cfg.ThreatIntelFeeds {
+			if strings.HasPrefix(feed, "http://") || strings.HasPrefix(feed, "https://") {
+				continue
+			}
+			if _, err := os.Stat(feed); err != nil {
+				errs = append(errs, fmt.Sprintf("threat feed: %v", err))
+			}
+		}
+	}
+	if cfg.OutputBufferSize <= 0 {
+		errs = append(errs, "OUTPUT_BUFFER_SIZE must be positive")
+	}
+	if cfg.OutputFlushInterval <= 0 {
+		errs = append(errs, "OUTPUT_FLUSH_INTERVAL must be positive")
+	}
+	if cfg.ConsoleFormat != collector.ConsoleFormatJSON && cfg.ConsoleFormat != collector.ConsoleFormatPretty {
+		errs = append(errs, "CONSOLE_FORMAT must be json or pretty")
+	}
+	if _, err := useColor(cfg.ConsoleColor, io.Discard); err != nil {
+		errs = append(errs, "CONSOLE_COLOR must be auto, always or never")
+	}
+	if cfg.CheckpointFile != "" && cfg.CheckpointFlushEntries <= 0 {
+		errs = append(errs, "CHECKPOINT_FLUSH_ENTRIES must be positive")
+	}
+	if cfg.CheckpointFingerprintBytes <= 0 {
+		errs = append(errs, "CHECKPOINT_FINGERPRINT_BYTES must be positive")
+	}
+	if cfg.ClockSkewTolerance < 0 {
+		errs = append(errs, "CLOCK_SKEW_TOLERANCE must not be negative")
+	}
+	if cfg.UsageRetentionDays <= 0 {
+		errs = append(errs, "USAGE_RETENTION_DAYS must be positive")
+	}
+	if cfg.AdminToken == "" {
+		warnings = append(warnings, "ADMIN_TOKEN is not set, admin endpoints are disabled")
+	}
+
+	if cfg.PluginDir != "" && !wasm.Available() {
+		errs = append(errs, "PLUGIN_DIR is set but this build has no WASM runtime (it was built with -tags nowazero)")
+	}
+	plugins, err := loadPlugins(cfg)
+	if err != nil && wasm.Available() {
+		errs = append(errs, err.Error())
+	}
+	defer plugins.Close()
+
+	if cfg.ScriptFile != "" {
+		if stage, err := loadScript(cfg); err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			stage.Close()
+		}
+	}
+
+	if _, err := serverTLSConfig(cfg); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if _, err := aggregatorClient(cfg); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := forward.ValidateCompressions(cfg.ForwardCompression); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if _, err := loadCipher(cfg); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if cfg.ArchiveCompactInterval < 0 {
+		errs = append(errs, "ARCHIVE_COMPACT_INTERVAL must not be negative")
+	}
+	if cfg.ArchiveRetention < 0 {
+		errs = append(errs, "ARCHIVE_RETENTION must not be negative")
+	}
+	if cfg.ArchiveRetention > 0 && cfg.ArchiveCompactInterval == 0 {
+		warnings = append(warnings, "ARCHIVE_RETENTION is set but ARCHIVE_COMPACT_INTERVAL is not, expired entries are never removed")
+	}
+	if cfg.ArchiveSummaryRetention < 0 {
+		errs = append(errs, "ARCHIVE_SUMMARY_RETENTION must not be negative")
+	}
+	if cfg.ArchiveDownsample && cfg.ArchiveRetention == 0 {
+		warnings = append(warnings, "ARCHIVE_DOWNSAMPLE is set but ARCHIVE_RETENTION is not, no entry is ever summarized")
+	}
+	if cfg.ArchiveDownsample && cfg.ArchiveSummaryRetention > 0 && cfg.ArchiveSummaryRetention <= cfg.ArchiveRetention {
+		warnings = append(warnings, "ARCHIVE_SUMMARY_RETENTION is not longer than ARCHIVE_RETENTION, summaries are removed as soon as they are written")
+	}
+	if cfg.ArchiveImmutableFor < 0 {
+		errs = append(errs, "ARCHIVE_IMMUTABLE_FOR must not be negative")
+	}
+	if cfg.ArchiveImmutableFor > 0 && cfg.ArchiveRetention > 0 && cfg.ArchiveImmutableFor > cfg.ArchiveRetention {
+		warnings = append(warnings, "ARCHIVE_IMMUTABLE_FOR is longer than ARCHIVE_RETENTION, entries are kept until their objects' immutability ends")
+	}
+	if cfg.ArchiveCapacityBytes < 0 {
+		errs = append(errs, "ARCHIVE_CAPACITY_BYTES must not be negative")
+	}
+	if cfg.SnapshotKeep < 1 {
+		errs = append(errs, "SNAPSHOT_KEEP must be at least 1")
+	}
+	if u := cfg.SnapshotUploadURL; u != "" && !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		errs = append(errs, "SNAPSHOT_UPLOAD_URL must be an http or https URL")
+	}
+	if cfg.SnapshotDir != "" && cfg.CheckpointFile == "" && cfg.ArchiveDir == "" {
+		warnings = append(warnings, "SNAPSHOT_DIR is set but neither CHECKPOINT_FILE nor ARCHIVE_DIR is, snapshots hold no state")
+	}
+	if cfg.SnapshotUploadURL != "" && cfg.SnapshotDir == "" {
+		warnings = append(warnings, "SNAPSHOT_UPLOAD_URL is set but SNAPSHOT_DIR is not, no snapshot is taken")
+	}
+	if cfg.ArchiveSigningKeyFile != "" {
+		if _, err := archive.LoadSigningKey(cfg.ArchiveSigningKeyFile); err != nil {
+			errs = append(errs, err.Error())
+		}
+		if cfg.ArchiveDir == "" {
+			warnings = append(warnings, "ARCHIVE_SIGNING_KEY_FILE is set but ARCHIVE_DIR is not, nothing is archived")
+		}
+	}
+
+	if cfg.RulesFile != "" {
+		if engine, err := loadRules(cfg, audit.NewWithWriter(io.Discard)); err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			engine.Close()
+		}
+	}
+	if cfg.ActionsDryRun && cfg.RulesFile != "" {
+		warnings = append(warnings, "ACTIONS_DRY_RUN is set, response actions are only recorded")
+	}
+
+	lc := collector.New(audit.NewWithWriter(io.Discard))
+	if err := loadSources(lc, cfg); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := loadQuotas(lc, cfg); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := loadFieldMap(lc, cfg); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := addKubernetesEvents(lc, cfg); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := addInputs(lc, cfg); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	sources := lc.GetSources()
+	enabled := 0
+	for _, source := range sources {
+		if !source.Enabled {
+			continue
+		}
+		enabled++
+		if _, err := os.Stat(source.Path); err != nil {
+			warnings = append(warnings, fmt.Sprintf("source %s: %v", source.Name, err))
+		}
+	}
+	if enabled == 0 {
+		warnings = append(warnings, "no log sources are enabled")
+	}
+
+	for _, warning := range warnings {
+		fmt.Fprintf(out, "⚠️  %s\n", warning)
+	}
+	for _, err := range errs {
+		fmt.Fprintf(out, "❌ %s\n", err)
+	}
+
+	if len(errs) > 0 {
+		fmt.Fprintf(out, "Configuration is invalid (%d errors, %d warnings)\n", len(errs), len(warnings))
+		return exitError{code: 1}
+	}
+	fmt.Fprintf(out, "✅ Configuration is valid (%d sources, %d enabled, %d warnings)\n", len(sources), enabled, len(warnings))
+	return nil
+}
+
+// isLevel reports whether level names a log level
+func isLevel(level string) bool {
+	switch collector.LogLevel(level) {
+	case collector.LevelDebug, collector.LevelInfo, collector.LevelWarn, collector.LevelError, collector.LevelFatal:
+		return true
+	}
+	return false
+}
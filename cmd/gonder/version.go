@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/spf13/cobra"
+)
+
+// newVersionCommand creates `gonder version`
+func newVersionCommand() *cobra.Command {
+	var short bool
+
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Print the gonder version",
+		Run: func(cmd *cobra.Command, args []string) {
+			if short {
+				fmt.Fprintln(cmd.OutOrStdout(), version)
+				return
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "gonder %s (%s %s/%s)\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+		},
+	}
+	cmd.Flags().BoolVar(&short, "short", false, "Print only the version number")
+	return cmd
+}
@@ -1,3 +1,17 @@
-module gonder
+module github.com/ercansavas/gonder
 
 go 1.24.4
+
+require (
+	github.com/fsnotify/fsnotify v1.10.1
+	github.com/klauspost/compress v1.18.0
+	github.com/spf13/cobra v1.10.2
+	github.com/tetratelabs/wazero v1.9.0
+	github.com/yuin/gopher-lua v1.1.2
+)
+
+require (
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/spf13/pflag v1.0.9 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+)
@@ -2,6 +2,9 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Config represents application configuration
@@ -9,6 +12,259 @@ type Config struct {
 	Port     string
 	Host     string
 	LogLevel string
+	NodeID   string
+
+	// HTTP server limits; zero timeouts and HTTPMaxConnections disable the
+	// respective limit
+	HTTPReadHeaderTimeout time.Duration
+	HTTPReadTimeout       time.Duration
+	HTTPWriteTimeout      time.Duration
+	HTTPIdleTimeout       time.Duration
+	HTTPMaxHeaderBytes    int
+	HTTPMaxConnections    int
+	// ShutdownTimeout bounds a clean shutdown: finishing requests, flushing
+	// the pipeline and outputs and writing checkpoints. The process exits
+	// when it runs out; zero waits indefinitely.
+	ShutdownTimeout time.Duration
+	// SlowRequestThreshold and LargeResponseThreshold log API requests
+	// taking longer or answering with more bytes as slow_request and
+	// large_response warnings; zero disables either
+	SlowRequestThreshold   time.Duration
+	LargeResponseThreshold int64
+	// AuditRepeatWindow collapses an error repeating within it into one
+	// summary per window; 0 logs every occurrence
+	AuditRepeatWindow time.Duration
+
+	// TLS termination on the HTTP listener. TLSClientAuth is none, optional
+	// or require; it defaults to require when TLSClientCAFile is set.
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+	TLSClientAuth   string
+
+	// SourcesFile is a JSON file replacing the built-in log sources
+	SourcesFile string
+	// WatchFiles reads source files when the kernel reports a change;
+	// without it they are polled on their interval
+	WatchFiles bool
+	// QuotasFile is a JSON file of ingestion quotas per source or tag
+	QuotasFile string
+	// InputsFile is a JSON file of TCP inputs, each with its own listener
+	// and TLS settings
+	InputsFile string
+	// FieldMapFile is a JSON file of source-specific field names filling
+	// the canonical entry fields
+	FieldMapFile string
+
+	// AdminToken protects diagnostics and admin endpoints; empty disables them
+	AdminToken string
+	// ReadOnly rejects API calls that change the server, e.g. stopping the
+	// collector or reloading plugins; collection and queries keep working
+	ReadOnly bool
+	// IdempotencyTTL remembers the responses to POSTs sent with an
+	// Idempotency-Key, up to IdempotencySize of them; zero disables it
+	IdempotencyTTL  time.Duration
+	IdempotencySize int
+	// AuditWebhooksFile persists the webhooks notified of audit events;
+	// empty keeps them in memory
+	AuditWebhooksFile string
+	// UIUsersFile lists the users who log in to the UI with a password
+	// (see gonder hash-password); empty disables session login. Sessions
+	// last SessionTTL.
+	UIUsersFile string
+	SessionTTL  time.Duration
+
+	// Output settings
+	OutputFile          string
+	OutputBufferSize    int
+	OutputFlushInterval time.Duration
+	// ConsoleFormat is json (NDJSON lines) or pretty (aligned lines for
+	// people); ConsoleColor colors pretty levels: auto, always or never
+	ConsoleFormat string
+	ConsoleColor  string
+
+	// Archive output: rotated NDJSON objects with checksums and manifests,
+	// signed when ArchiveSigningKeyFile is set; disabled when ArchiveDir is
+	// empty. ArchiveCompressRaw compresses raw lines with dictionaries;
+	// ArchiveCompactInterval merges small objects and drops entries older
+	// than ArchiveRetention; with ArchiveDownsample they are rolled up into
+	// hourly summaries kept for ArchiveSummaryRetention (0 = forever).
+	// ArchiveCapacityBytes is the space planned for the archive, reported
+	// by /api/store/status. ArchiveImmutableFor keeps objects unchanged for
+	// that long after they are sealed, whatever the retention.
+	ArchiveDir              string
+	ArchiveMaxBytes         int64
+	ArchiveMaxAge           time.Duration
+	ArchiveSigningKeyFile   string
+	ArchiveCompressRaw      bool
+	ArchiveCompactInterval  time.Duration
+	ArchiveRetention        time.Duration
+	ArchiveDownsample       bool
+	ArchiveSummaryRetention time.Duration
+	ArchiveCapacityBytes    int64
+	ArchiveImmutableFor     time.Duration
+
+	// Snapshots of the archive, checkpoints and catalog taken through
+	// /api/store/snapshot; disabled when SnapshotDir is empty. The newest
+	// SnapshotKeep are kept, and each is PUT to SnapshotUploadURL if set.
+	SnapshotDir         string
+	SnapshotKeep        int
+	SnapshotUploadURL   string
+	SnapshotUploadToken string
+
+	// Levels of web access entries: 4xx responses get WebClientErrorLevel
+	// and requests slower than WebSlowRequestThreshold (0 = off) at least warn
+	WebClientErrorLevel     string
+	WebSlowRequestThreshold time.Duration
+
+	// SelfMonitor feeds gonder's own audit events through the pipeline,
+	// except the event types in SelfMonitorExclude
+	SelfMonitor        bool
+	SelfMonitorExclude []string
+
+	// WASM plugin settings; PluginDir holds <name>.wasm modules with
+	// <name>.json manifests loaded at startup
+	PluginDir         string
+	PluginTimeout     time.Duration
+	PluginMemoryLimit int64
+
+	// ScriptFile is a Lua script run on every entry; ScriptTimeout bounds
+	// one call
+	ScriptFile    string
+	ScriptTimeout time.Duration
+
+	// Secret leak detection; SecretRedact replaces detected secrets
+	SecretDetection     bool
+	SecretRedact        bool
+	SecretAlertCooldown time.Duration
+
+	// Host metrics sampling; snapshots are attached to entries at
+	// HostMetricsLevel or above and optionally emitted as entries
+	HostMetrics         bool
+	HostMetricsInterval time.Duration
+	HostMetricsLevel    string
+	HostMetricsEmit     bool
+	HostMetricsDiskPath string
+
+	// ProcessEnrichment adds executable, owner and container details to
+	// entries carrying the PID of a local process
+	ProcessEnrichment bool
+
+	// Reverse DNS enrichment of entry IPs
+	ReverseDNS            bool
+	ReverseDNSTimeout     time.Duration
+	ReverseDNSCacheSize   int
+	ReverseDNSTTL         time.Duration
+	ReverseDNSNegativeTTL time.Duration
+
+	// Threat intel blocklists (files or URLs); matches in the alert
+	// sources (source types or tags) raise threat_match audit events
+	ThreatIntelFeeds         []string
+	ThreatIntelRefresh       time.Duration
+	ThreatIntelAlertSources  []string
+	ThreatIntelAlertLevel    string
+	ThreatIntelAlertCooldown time.Duration
+
+	// Alert rules with response actions; commands must be listed in
+	// ActionsAllowedCommands
+	RulesFile              string
+	ActionsAllowedCommands []string
+	ActionsDryRun          bool
+	ActionTimeout          time.Duration
+
+	// ConfigHistorySize is the number of revisions of the sources and
+	// rules kept for /api/config/history
+	ConfigHistorySize int
+
+	// Top-N analytics over the last TopRetention; zero disables them
+	TopRetention time.Duration
+	// TopCacheTTL keeps top and histogram results for dashboards refreshing
+	// often; zero disables the cache
+	TopCacheTTL  time.Duration
+	TopCacheSize int
+	TopCapacity  int
+
+	// Entries kept per source for /api/logs/{id}/context; zero disables it
+	ContextBuffer int
+
+	// Kubernetes events watcher; KubernetesAPIServer defaults to the
+	// in-cluster API server and service account
+	KubernetesEvents          bool
+	KubernetesEventsNamespace string
+	KubernetesAPIServer       string
+	KubernetesTokenFile       string
+	KubernetesCAFile          string
+
+	// Checkpoint settings; checkpoints are disabled when CheckpointFile is empty
+	CheckpointFile          string
+	CheckpointFlushEntries  int
+	CheckpointFlushInterval time.Duration
+	CheckpointFsync         bool
+	// CheckpointFingerprintBytes is how much of the start of a file
+	// identifies it across renames and rotations
+	CheckpointFingerprintBytes int
+
+	// Circuit breakers rest sources and outputs after CircuitBreakerFailures
+	// consecutive failures (0 = off) and probe them after a cooldown that
+	// doubles up to CircuitBreakerMaxCooldown
+	CircuitBreakerFailures    int
+	CircuitBreakerCooldown    time.Duration
+	CircuitBreakerMaxCooldown time.Duration
+
+	// UsageFile persists the daily usage accounting; UsageRetentionDays is
+	// the number of days kept
+	UsageFile          string
+	UsageRetentionDays int
+
+	// CatalogFile persists the catalog of tailed files
+	CatalogFile string
+
+	// Encryption at rest of the spool and checkpoints; the key is given
+	// directly, read from a file or printed by a command (e.g. a KMS CLI)
+	EncryptionKey        string
+	EncryptionKeyFile    string
+	EncryptionKeyCommand []string
+
+	// IngestToken protects push ingestion endpoints (OTLP, webhooks); empty
+	// leaves them open
+	IngestToken string
+
+	// AgentToken authenticates agents forwarding to this aggregator; empty
+	// disables agent ingestion
+	AgentToken string
+	// FleetConfigFile persists configuration pushed to agents
+	FleetConfigFile string
+	// ClockSkewTolerance is the agent clock skew beyond which agents are
+	// reported as skewed; ClockSkewCorrect corrects their timestamps
+	ClockSkewTolerance time.Duration
+	ClockSkewCorrect   bool
+
+	// JobsFile persists the records of background jobs; empty keeps them in
+	// memory
+	JobsFile string
+
+	// Cluster settings; aggregators sharing ClusterStore form a cluster
+	ClusterStore    string
+	ClusterNodeID   string
+	ClusterLeaseTTL time.Duration
+	DedupWindow     time.Duration
+
+	// Agent mode settings (gonder agent)
+	AggregatorURL string
+	AgentID       string
+	// TLS settings for reaching the aggregator: a private CA and a client
+	// certificate for aggregators that require one
+	AggregatorCAFile     string
+	AggregatorCertFile   string
+	AggregatorKeyFile    string
+	ForwardBatchSize     int
+	ForwardFlushInterval time.Duration
+	// ForwardCompression lists the batch compressions to offer aggregators,
+	// preferred first
+	ForwardCompression []string
+	SpoolDir           string
+	SpoolMaxBytes      int64
+	HeartbeatInterval  time.Duration
 }
 
 // Load loads configuration from environment variables or default values
@@ -17,10 +273,176 @@ func Load() *Config {
 		Port:     getEnv("PORT", "8080"),
 		Host:     getEnv("HOST", "localhost"),
 		LogLevel: getEnv("LOG_LEVEL", "info"),
+		NodeID:   getEnv("NODE_ID", ""),
+
+		HTTPReadHeaderTimeout: getEnvDuration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second),
+		HTTPReadTimeout:       getEnvDuration("HTTP_READ_TIMEOUT", time.Minute),
+		HTTPWriteTimeout:      getEnvDuration("HTTP_WRITE_TIMEOUT", time.Minute),
+		HTTPIdleTimeout:       getEnvDuration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+		HTTPMaxHeaderBytes:    getEnvInt("HTTP_MAX_HEADER_BYTES", 64*1024),
+		HTTPMaxConnections:    getEnvInt("HTTP_MAX_CONNECTIONS", 1024),
+		ShutdownTimeout:       getEnvDuration("SHUTDOWN_TIMEOUT", 25*time.Second),
+
+		SlowRequestThreshold:   getEnvDuration("SLOW_REQUEST_THRESHOLD", 5*time.Second),
+		LargeResponseThreshold: int64(getEnvInt("LARGE_RESPONSE_THRESHOLD", 0)),
+		AuditRepeatWindow:      getEnvDuration("AUDIT_REPEAT_WINDOW", 10*time.Minute),
+
+		TLSCertFile:     getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:      getEnv("TLS_KEY_FILE", ""),
+		TLSClientCAFile: getEnv("TLS_CLIENT_CA_FILE", ""),
+		TLSClientAuth:   getEnv("TLS_CLIENT_AUTH", ""),
+
+		SourcesFile: getEnv("SOURCES_FILE", ""),
+		WatchFiles:  getEnvBool("WATCH_FILES", true),
+		QuotasFile:  getEnv("QUOTAS_FILE", ""),
+		InputsFile:  getEnv("INPUTS_FILE", ""),
+
+		FieldMapFile: getEnv("FIELD_MAP_FILE", ""),
+
+		AdminToken: getEnv("ADMIN_TOKEN", ""),
+		ReadOnly:   getEnvBool("READ_ONLY", false),
+
+		IdempotencyTTL:  getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+		IdempotencySize: getEnvInt("IDEMPOTENCY_SIZE", 10000),
+
+		AuditWebhooksFile: getEnv("AUDIT_WEBHOOKS_FILE", ""),
+
+		UIUsersFile: getEnv("UI_USERS_FILE", ""),
+		SessionTTL:  getEnvDuration("SESSION_TTL", 12*time.Hour),
+
+		OutputFile:          getEnv("OUTPUT_FILE", ""),
+		OutputBufferSize:    getEnvInt("OUTPUT_BUFFER_SIZE", 64*1024),
+		OutputFlushInterval: getEnvDuration("OUTPUT_FLUSH_INTERVAL", time.Second),
+		ConsoleFormat:       getEnv("CONSOLE_FORMAT", "json"),
+		ConsoleColor:        getEnv("CONSOLE_COLOR", "auto"),
+
+		ArchiveDir:              getEnv("ARCHIVE_DIR", ""),
+		ArchiveMaxBytes:         int64(getEnvInt("ARCHIVE_MAX_BYTES", 64*1024*1024)),
+		ArchiveMaxAge:           getEnvDuration("ARCHIVE_MAX_AGE", time.Hour),
+		ArchiveSigningKeyFile:   getEnv("ARCHIVE_SIGNING_KEY_FILE", ""),
+		ArchiveCompressRaw:      getEnvBool("ARCHIVE_COMPRESS_RAW", false),
+		ArchiveCompactInterval:  getEnvDuration("ARCHIVE_COMPACT_INTERVAL", 0),
+		ArchiveRetention:        getEnvDuration("ARCHIVE_RETENTION", 0),
+		ArchiveDownsample:       getEnvBool("ARCHIVE_DOWNSAMPLE", false),
+		ArchiveSummaryRetention: getEnvDuration("ARCHIVE_SUMMARY_RETENTION", 0),
+		ArchiveCapacityBytes:    int64(getEnvInt("ARCHIVE_CAPACITY_BYTES", 0)),
+		ArchiveImmutableFor:     getEnvDuration("ARCHIVE_IMMUTABLE_FOR", 0),
+
+		SnapshotDir:         getEnv("SNAPSHOT_DIR", ""),
+		SnapshotKeep:        getEnvInt("SNAPSHOT_KEEP", 7),
+		SnapshotUploadURL:   getEnv("SNAPSHOT_UPLOAD_URL", ""),
+		SnapshotUploadToken: getEnv("SNAPSHOT_UPLOAD_TOKEN", ""),
+
+		WebClientErrorLevel:     getEnv("WEB_CLIENT_ERROR_LEVEL", "warn"),
+		WebSlowRequestThreshold: getEnvDuration("WEB_SLOW_REQUEST_THRESHOLD", 0),
+
+		SelfMonitor:        getEnvBool("SELF_MONITOR", false),
+		SelfMonitorExclude: getEnvList("SELF_MONITOR_EXCLUDE", []string{"api_call", "health_check"}),
+
+		PluginDir:         getEnv("PLUGIN_DIR", ""),
+		PluginTimeout:     getEnvDuration("PLUGIN_TIMEOUT", 10*time.Millisecond),
+		PluginMemoryLimit: int64(getEnvInt("PLUGIN_MEMORY_LIMIT", 16*1024*1024)),
+
+		ScriptFile:    getEnv("SCRIPT_FILE", ""),
+		ScriptTimeout: getEnvDuration("SCRIPT_TIMEOUT", 10*time.Millisecond),
+
+		SecretDetection:     getEnvBool("SECRET_DETECTION", false),
+		SecretRedact:        getEnvBool("SECRET_REDACT", true),
+		SecretAlertCooldown: getEnvDuration("SECRET_ALERT_COOLDOWN", 10*time.Minute),
+
+		HostMetrics:         getEnvBool("HOST_METRICS", false),
+		HostMetricsInterval: getEnvDuration("HOST_METRICS_INTERVAL", 15*time.Second),
+		HostMetricsLevel:    getEnv("HOST_METRICS_LEVEL", "error"),
+		HostMetricsEmit:     getEnvBool("HOST_METRICS_EMIT", false),
+		HostMetricsDiskPath: getEnv("HOST_METRICS_DISK_PATH", "/"),
+
+		ProcessEnrichment: getEnvBool("PROCESS_ENRICHMENT", false),
+
+		ReverseDNS:            getEnvBool("REVERSE_DNS", false),
+		ReverseDNSTimeout:     getEnvDuration("REVERSE_DNS_TIMEOUT", 200*time.Millisecond),
+		ReverseDNSCacheSize:   getEnvInt("REVERSE_DNS_CACHE_SIZE", 10000),
+		ReverseDNSTTL:         getEnvDuration("REVERSE_DNS_TTL", time.Hour),
+		ReverseDNSNegativeTTL: getEnvDuration("REVERSE_DNS_NEGATIVE_TTL", 5*time.Minute),
+
+		ThreatIntelFeeds:         getEnvList("THREAT_INTEL_FEEDS", nil),
+		ThreatIntelRefresh:       getEnvDuration("THREAT_INTEL_REFRESH", time.Hour),
+		ThreatIntelAlertSources:  getEnvList("THREAT_INTEL_ALERT_SOURCES", []string{"nginx", "apache", "auth", "security", "web"}),
+		ThreatIntelAlertLevel:    getEnv("THREAT_INTEL_ALERT_LEVEL", "warn"),
+		ThreatIntelAlertCooldown: getEnvDuration("THREAT_INTEL_ALERT_COOLDOWN", 10*time.Minute),
+
+		RulesFile:              getEnv("RULES_FILE", ""),
+		ConfigHistorySize:      getEnvInt("CONFIG_HISTORY_SIZE", 20),
+		ActionsAllowedCommands: getEnvList("ACTIONS_ALLOWED_COMMANDS", nil),
+		ActionsDryRun:          getEnvBool("ACTIONS_DRY_RUN", false),
+		ActionTimeout:          getEnvDuration("ACTION_TIMEOUT", 30*time.Second),
+		TopRetention:           getEnvDuration("TOP_RETENTION", time.Hour),
+		TopCapacity:            getEnvInt("TOP_CAPACITY", 200),
+		TopCacheTTL:            getEnvDuration("TOP_CACHE_TTL", 5*time.Second),
+		TopCacheSize:           getEnvInt("TOP_CACHE_SIZE", 256),
+		ContextBuffer:          getEnvInt("CONTEXT_BUFFER", 1000),
+
+		KubernetesEvents:          getEnvBool("K8S_EVENTS", false),
+		KubernetesEventsNamespace: getEnv("K8S_EVENTS_NAMESPACE", ""),
+		KubernetesAPIServer:       getEnv("K8S_API_SERVER", ""),
+		KubernetesTokenFile:       getEnv("K8S_TOKEN_FILE", ""),
+		KubernetesCAFile:          getEnv("K8S_CA_FILE", ""),
+
+		CheckpointFile:             getEnv("CHECKPOINT_FILE", ""),
+		CheckpointFlushEntries:     getEnvInt("CHECKPOINT_FLUSH_ENTRIES", 1000),
+		CheckpointFlushInterval:    getEnvDuration("CHECKPOINT_FLUSH_INTERVAL", 5*time.Second),
+		CheckpointFsync:            getEnvBool("CHECKPOINT_FSYNC", true),
+		CheckpointFingerprintBytes: getEnvInt("CHECKPOINT_FINGERPRINT_BYTES", 1024),
+
+		CircuitBreakerFailures:    getEnvInt("CIRCUIT_BREAKER_FAILURES", 5),
+		CircuitBreakerCooldown:    getEnvDuration("CIRCUIT_BREAKER_COOLDOWN", 30*time.Second),
+		CircuitBreakerMaxCooldown: getEnvDuration("CIRCUIT_BREAKER_MAX_COOLDOWN", 10*time.Minute),
+
+		UsageFile:          getEnv("USAGE_FILE", ""),
+		CatalogFile:        getEnv("CATALOG_FILE", ""),
+		UsageRetentionDays: getEnvInt("USAGE_RETENTION_DAYS", 30),
+
+		EncryptionKey:        getEnv("ENCRYPTION_KEY", ""),
+		EncryptionKeyFile:    getEnv("ENCRYPTION_KEY_FILE", ""),
+		EncryptionKeyCommand: strings.Fields(getEnv("ENCRYPTION_KEY_COMMAND", "")),
+
+		IngestToken: getEnv("INGEST_TOKEN", ""),
+
+		AgentToken:      getEnv("AGENT_TOKEN", ""),
+		FleetConfigFile: getEnv("FLEET_CONFIG_FILE", ""),
+
+		ClockSkewTolerance: getEnvDuration("CLOCK_SKEW_TOLERANCE", 5*time.Second),
+		ClockSkewCorrect:   getEnvBool("CLOCK_SKEW_CORRECT", false),
+
+		JobsFile: getEnv("JOBS_FILE", ""),
+
+		ClusterStore:    getEnv("CLUSTER_STORE", ""),
+		ClusterNodeID:   getEnv("CLUSTER_NODE_ID", hostname()),
+		ClusterLeaseTTL: getEnvDuration("CLUSTER_LEASE_TTL", 15*time.Second),
+		DedupWindow:     getEnvDuration("DEDUP_WINDOW", 10*time.Minute),
+
+		AggregatorURL:        getEnv("AGGREGATOR_URL", ""),
+		AgentID:              getEnv("AGENT_ID", hostname()),
+		AggregatorCAFile:     getEnv("AGGREGATOR_CA_FILE", ""),
+		AggregatorCertFile:   getEnv("AGGREGATOR_CERT_FILE", ""),
+		AggregatorKeyFile:    getEnv("AGGREGATOR_KEY_FILE", ""),
+		ForwardBatchSize:     getEnvInt("FORWARD_BATCH_SIZE", 500),
+		ForwardFlushInterval: getEnvDuration("FORWARD_FLUSH_INTERVAL", 2*time.Second),
+		ForwardCompression:   getEnvList("FORWARD_COMPRESSION", []string{"gzip"}),
+		SpoolDir:             getEnv("SPOOL_DIR", "data/spool"),
+		SpoolMaxBytes:        int64(getEnvInt("SPOOL_MAX_BYTES", 256*1024*1024)),
+		HeartbeatInterval:    getEnvDuration("AGENT_HEARTBEAT_INTERVAL", 15*time.Second),
 	}
 	return cfg
 }
 
+// hostname returns the machine hostname, or "unknown"
+func hostname() string {
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return name
+	}
+	return "unknown"
+}
+
 // getEnv gets environment variable, returns default value if not found
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -28,3 +450,48 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvInt gets an integer environment variable, returns default value if not found or invalid
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+// getEnvDuration gets a duration environment variable (e.g. "500ms"), returns default value if not found or invalid
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+// getEnvList gets a comma-separated list environment variable, returns default value if not found
+func getEnvList(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvBool gets a boolean environment variable, returns default value if not found or invalid
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,90 @@
+// Package systemd implements the parts of the systemd service protocol
+// gonder needs: readiness and status notifications (sd_notify) and watchdog
+// keep-alive pings. Everything is a no-op when not running under systemd.
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notification states understood by systemd
+const (
+	StateReady    = "READY=1"
+	StateStopping = "STOPPING=1"
+	StateReload   = "RELOADING=1"
+	StateWatchdog = "WATCHDOG=1"
+)
+
+// Notify sends state to the service manager. It reports false without an
+// error when NOTIFY_SOCKET is not set, i.e. when not started by systemd with
+// Type=notify.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// Abstract namespace sockets are announced with a leading '@'
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Status sends a free-form status line shown by `systemctl status`
+func Status(status string) (bool, error) {
+	return Notify("STATUS=" + status)
+}
+
+// WatchdogInterval returns the watchdog timeout configured with WatchdogSec=,
+// or false when the watchdog is not enabled for this process
+func WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
+
+// StartWatchdog pings the watchdog at half its timeout for as long as
+// healthy reports true, so systemd restarts a process that stopped making
+// progress. It returns a function stopping the pings and reports whether
+// the watchdog is enabled at all.
+func StartWatchdog(healthy func() bool) (stop func(), enabled bool) {
+	timeout, ok := WatchdogInterval()
+	if !ok {
+		return func() {}, false
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(timeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if healthy == nil || healthy() {
+					Notify(StateWatchdog)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }, true
+}
@@ -0,0 +1,171 @@
+package alertmanager
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// SourceAlertmanager marks logs converted from Alertmanager notifications
+const SourceAlertmanager collector.LogSource = "alertmanager"
+
+// Alert status values
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// Webhook is the payload Alertmanager posts to webhook receivers (version 4)
+type Webhook struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []Alert           `json:"alerts"`
+}
+
+// Alert is a single alert of a webhook notification
+type Alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// Validate checks that the payload looks like an Alertmanager notification
+func (w *Webhook) Validate() error {
+	if len(w.Alerts) == 0 {
+		return fmt.Errorf("webhook contains no alerts")
+	}
+	for i, alert := range w.Alerts {
+		if alert.Status != StatusFiring && alert.Status != StatusResolved {
+			return fmt.Errorf("alert %d has invalid status %q", i, alert.Status)
+		}
+	}
+	return nil
+}
+
+// ToSystemLogs converts every alert of a notification into a log entry.
+// Firing alerts are logged at their severity label (error when absent),
+// resolved alerts at info, so both ends of an incident can be correlated
+// with the application logs around them.
+func ToSystemLogs(w *Webhook) []collector.SystemLog {
+	now := time.Now()
+	logs := make([]collector.SystemLog, 0, len(w.Alerts))
+
+	for _, alert := range w.Alerts {
+		name := alert.Labels["alertname"]
+		severity := alert.Labels["severity"]
+
+		tags := []string{"alertmanager", "alert", "status:" + alert.Status}
+		if name != "" {
+			tags = append(tags, "alertname:"+name)
+		}
+		if severity != "" {
+			tags = append(tags, "severity:"+severity)
+		}
+
+		parsed := map[string]interface{}{
+			"status":      alert.Status,
+			"labels":      alert.Labels,
+			"annotations": alert.Annotations,
+			"starts_at":   alert.StartsAt,
+			"fingerprint": alert.Fingerprint,
+			"receiver":    w.Receiver,
+		}
+		if !alert.EndsAt.IsZero() {
+			parsed["ends_at"] = alert.EndsAt
+		}
+		if alert.GeneratorURL != "" {
+			parsed["generator_url"] = alert.GeneratorURL
+		}
+		if w.GroupKey != "" {
+			parsed["group_key"] = w.GroupKey
+		}
+		if w.ExternalURL != "" {
+			parsed["external_url"] = w.ExternalURL
+		}
+
+		message := alertMessage(alert, name)
+		logs = append(logs, collector.SystemLog{
+			Timestamp:   alertTime(alert, now),
+			Source:      SourceAlertmanager,
+			Level:       alertLevel(alert.Status, severity),
+			Message:     message,
+			Host:        instanceHost(alert.Labels["instance"]),
+			Service:     firstLabel(alert.Labels, "service", "job"),
+			RawLog:      message,
+			ParsedData:  parsed,
+			Tags:        tags,
+			CollectedAt: now,
+		})
+	}
+	return logs
+}
+
+// alertMessage builds "[FIRING] Name: summary" from the usual annotations
+func alertMessage(alert Alert, name string) string {
+	if name == "" {
+		name = "alert " + alert.Fingerprint
+	}
+	message := fmt.Sprintf("[%s] %s", strings.ToUpper(alert.Status), name)
+	if text := firstLabel(alert.Annotations, "summary", "description", "message"); text != "" {
+		message += ": " + text
+	}
+	return message
+}
+
+// alertTime returns when the alert started firing or, for resolved alerts,
+// when it ended
+func alertTime(alert Alert, now time.Time) time.Time {
+	if alert.Status == StatusResolved && !alert.EndsAt.IsZero() {
+		return alert.EndsAt.UTC()
+	}
+	if !alert.StartsAt.IsZero() {
+		return alert.StartsAt.UTC()
+	}
+	return now.UTC()
+}
+
+// alertLevel maps the conventional severity label values to log levels
+func alertLevel(status, severity string) collector.LogLevel {
+	if status == StatusResolved {
+		return collector.LevelInfo
+	}
+	switch strings.ToLower(severity) {
+	case "critical", "page", "emergency", "fatal":
+		return collector.LevelFatal
+	case "warning", "warn":
+		return collector.LevelWarn
+	case "info", "informational", "none":
+		return collector.LevelInfo
+	}
+	return collector.LevelError
+}
+
+// instanceHost strips the port from a Prometheus instance label
+func instanceHost(instance string) string {
+	if i := strings.LastIndex(instance, ":"); i > 0 && !strings.Contains(instance[i:], "]") {
+		return strings.Trim(instance[:i], "[]")
+	}
+	return instance
+}
+
+func firstLabel(labels map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if v := labels[key]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,341 @@
+// Package analytics answers top-N questions such as "the 10 services
+// logging the most errors in the last hour", and counts over time for
+// charts, in milliseconds, from compact summaries updated as entries pass
+// through the pipeline instead of by scanning stored logs.
+//
+// Every minute gets exact counts per source and level, and one heavy-hitter
+// summary per dimension, source and level; a query merges the minutes in
+// its window. Summary counts are exact while a summary holds fewer keys
+// than its capacity and approximate beyond that, so top-N results carry
+// their maximum error.
+package analytics
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// Dimension is what top-N results are grouped by
+type Dimension string
+
+// Dimensions of an entry
+const (
+	DimensionService  Dimension = "service"
+	DimensionHost     Dimension = "host"
+	DimensionPath     Dimension = "path"
+	DimensionStatus   Dimension = "status"
+	DimensionTemplate Dimension = "template"
+)
+
+// Dimensions lists every dimension
+var Dimensions = []Dimension{DimensionService, DimensionHost, DimensionPath, DimensionStatus, DimensionTemplate}
+
+const (
+	// DefaultRetention is the default longest query window
+	DefaultRetention = time.Hour
+	// DefaultCapacity is the default number of keys kept per dimension,
+	// level and minute
+	DefaultCapacity = 200
+	// DefaultLimit is the default number of results
+	DefaultLimit = 10
+
+	bucketWidth = time.Minute
+)
+
+// Config configures a Tracker
+type Config struct {
+	// Retention is the longest window that can be queried
+	Retention time.Duration
+	// Capacity bounds the keys kept per dimension, level and minute;
+	// larger values make counts of rarer keys exact
+	Capacity int
+}
+
+// Tracker keeps the top-N summaries
+type Tracker struct {
+	capacity int
+
+	mu      sync.Mutex
+	buckets []bucket
+}
+
+// bucket holds the counts and summaries of one minute
+type bucket struct {
+	minute    int64 // Unix minute; 0 when unused
+	counts    map[seriesKey]uint64
+	summaries map[summaryKey]*summary
+}
+
+type seriesKey struct {
+	source collector.LogSource
+	level  collector.LogLevel
+}
+
+type summaryKey struct {
+	dimension Dimension
+	seriesKey
+}
+
+// New creates a tracker
+func New(cfg Config) *Tracker {
+	if cfg.Retention <= 0 {
+		cfg.Retention = DefaultRetention
+	}
+	if cfg.Capacity <= 0 {
+		cfg.Capacity = DefaultCapacity
+	}
+	return &Tracker{
+		capacity: cfg.Capacity,
+		buckets:  make([]bucket, (cfg.Retention+bucketWidth-1)/bucketWidth),
+	}
+}
+
+// Retention returns the longest window that can be queried
+func (t *Tracker) Retention() time.Duration {
+	return time.Duration(len(t.buckets)) * bucketWidth
+}
+
+// Processor returns the pipeline stage feeding the tracker; it never drops
+// entries
+func (t *Tracker) Processor() collector.Processor {
+	return collector.ProcessorFunc(func(entry *collector.SystemLog) bool {
+		t.observe(entry, time.Now())
+		return true
+	})
+}
+
+// observe counts an entry in the minute of its timestamp. Entries older
+// than the retention (e.g. backfilled files) can't show up in any window
+// and are skipped; timestamps in the future count as now.
+func (t *Tracker) observe(entry *collector.SystemLog, now time.Time) {
+	at := entry.Timestamp
+	if at.IsZero() || at.After(now) {
+		at = now
+	}
+	minute := at.Unix() / 60
+	if minute <= now.Unix()/60-int64(len(t.buckets)) {
+		return
+	}
+	level := entry.Level
+	if level == "" {
+		level = collector.LevelUnknown
+	}
+	// Computed before locking; templates take the most time
+	keys := make([]string, len(Dimensions))
+	for i, dimension := range Dimensions {
+		keys[i] = keyOf(entry, dimension)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := &t.buckets[minute%int64(len(t.buckets))]
+	if b.minute != minute {
+		*b = bucket{
+			minute:    minute,
+			counts:    make(map[seriesKey]uint64),
+			summaries: make(map[summaryKey]*summary),
+		}
+	}
+	series := seriesKey{source: entry.Source, level: level}
+	b.counts[series]++
+	for i, dimension := range Dimensions {
+		if keys[i] == "" {
+			continue
+		}
+		sk := summaryKey{dimension: dimension, seriesKey: series}
+		s, ok := b.summaries[sk]
+		if !ok {
+			s = newSummary(t.capacity)
+			b.summaries[sk] = s
+		}
+		s.add(keys[i])
+	}
+}
+
+// keyOf returns the value of an entry in a dimension; empty when it has none
+func keyOf(entry *collector.SystemLog, dimension Dimension) string {
+	switch dimension {
+	case DimensionService:
+		return entry.Service
+	case DimensionHost:
+		return entry.Host
+	case DimensionPath:
+		path, _, _ := strings.Cut(entry.Path, "?")
+		return path
+	case DimensionStatus:
+		if entry.StatusCode > 0 {
+			return strconv.Itoa(entry.StatusCode)
+		}
+	case DimensionTemplate:
+		return Template(entry.Message)
+	}
+	return ""
+}
+
+// Filter selects the entries a query counts
+type Filter struct {
+	// Sources counts entries of any of the source types; empty counts all
+	Sources []collector.LogSource
+	// MinLevel only counts entries at least this severe; empty counts all
+	MinLevel collector.LogLevel
+}
+
+func (f Filter) validate() error {
+	if f.MinLevel != "" && !f.MinLevel.AtLeast(collector.LevelDebug) {
+		return fmt.Errorf("unknown level %q", f.MinLevel)
+	}
+	return nil
+}
+
+func (f Filter) selects(key seriesKey) bool {
+	if f.MinLevel != "" && !key.level.AtLeast(f.MinLevel) {
+		return false
+	}
+	if len(f.Sources) == 0 {
+		return true
+	}
+	for _, source := range f.Sources {
+		if key.source == source {
+			return true
+		}
+	}
+	return false
+}
+
+// Query selects top-N results
+type Query struct {
+	Filter
+	Dimension Dimension
+	// Window is how far back to look; rounded up to whole minutes
+	Window time.Duration
+	Limit  int
+}
+
+// Item is one top-N result. The true count is within Error of Count.
+type Item struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+	Error uint64 `json:"error,omitempty"`
+}
+
+// Result is the answer to a Query
+type Result struct {
+	Dimension Dimension             `json:"dimension"`
+	Window    string                `json:"window"`
+	Sources   []collector.LogSource `json:"sources,omitempty"`
+	MinLevel  collector.LogLevel    `json:"min_level,omitempty"`
+	From      time.Time             `json:"from"`
+	// Total counts the entries in the window selected by the filter, with
+	// or without a value in the dimension
+	Total uint64 `json:"total"`
+	Top   []Item `json:"top"`
+}
+
+// Top returns the keys of a dimension seen most often within the window
+func (t *Tracker) Top(q Query, now time.Time) (*Result, error) {
+	if !validDimension(q.Dimension) {
+		return nil, fmt.Errorf("unknown dimension %q", q.Dimension)
+	}
+	if err := t.validateWindow(q.Window); err != nil {
+		return nil, err
+	}
+	if err := q.Filter.validate(); err != nil {
+		return nil, err
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	minutes := int64((q.Window + bucketWidth - 1) / bucketWidth)
+	last := now.Unix() / 60
+	first := last - minutes + 1
+
+	result := &Result{
+		Dimension: q.Dimension,
+		Window:    (time.Duration(minutes) * bucketWidth).String(),
+		Sources:   q.Sources,
+		MinLevel:  q.MinLevel,
+		From:      time.Unix(first*60, 0).UTC(),
+		Top:       []Item{},
+	}
+
+	t.mu.Lock()
+	var summaries []*summary
+	for i := range t.buckets {
+		b := &t.buckets[i]
+		if b.minute < first || b.minute > last {
+			continue
+		}
+		for key, count := range b.counts {
+			if q.selects(key) {
+				result.Total += count
+			}
+		}
+		for key, s := range b.summaries {
+			if key.dimension == q.Dimension && q.selects(key.seriesKey) {
+				summaries = append(summaries, s)
+			}
+		}
+	}
+	// Merging reads the summaries, so it stays under the lock
+	merged := merge(summaries)
+	t.mu.Unlock()
+
+	for key, e := range merged {
+		result.Top = append(result.Top, Item{Key: key, Count: e.count, Error: e.err})
+	}
+	sort.Slice(result.Top, func(i, j int) bool {
+		if result.Top[i].Count != result.Top[j].Count {
+			return result.Top[i].Count > result.Top[j].Count
+		}
+		return result.Top[i].Key < result.Top[j].Key
+	})
+	if len(result.Top) > q.Limit {
+		result.Top = result.Top[:q.Limit]
+	}
+	return result, nil
+}
+
+func (t *Tracker) validateWindow(window time.Duration) error {
+	if window <= 0 || window > t.Retention() {
+		return fmt.Errorf("window must be between 1m and %s", t.Retention())
+	}
+	return nil
+}
+
+func validDimension(dimension Dimension) bool {
+	for _, d := range Dimensions {
+		if d == dimension {
+			return true
+		}
+	}
+	return false
+}
+
+// Sources returns the source types with entries within the retention
+func (t *Tracker) Sources(now time.Time) []collector.LogSource {
+	oldest := now.Unix()/60 - int64(len(t.buckets)) + 1
+	seen := make(map[collector.LogSource]bool)
+	t.mu.Lock()
+	for i := range t.buckets {
+		if t.buckets[i].minute < oldest {
+			continue
+		}
+		for key := range t.buckets[i].counts {
+			seen[key.source] = true
+		}
+	}
+	t.mu.Unlock()
+
+	sources := make([]collector.LogSource, 0, len(seen))
+	for source := range seen {
+		sources = append(sources, source)
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i] < sources[j] })
+	return sources
+}
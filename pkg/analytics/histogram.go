@@ -0,0 +1,179 @@
+package analytics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+const (
+	// MaxPoints bounds the points of a histogram series
+	MaxPoints = 1440
+	// defaultPoints is the number of points aimed at without an interval
+	defaultPoints = 60
+)
+
+// Ways to split a histogram into series
+const (
+	SplitNone   = ""
+	SplitLevel  = "level"
+	SplitSource = "source"
+)
+
+// HistogramQuery selects counts over time
+type HistogramQuery struct {
+	Filter
+	// Window is how far back to look; rounded up to whole intervals
+	Window time.Duration
+	// Interval is the width of a point, a whole number of minutes. Zero
+	// picks one giving about 60 points.
+	Interval time.Duration
+	// Query counts only entries whose message template (see Template)
+	// contains the text, ignoring case. Numbers, IDs and addresses are
+	// masked in templates, so they can't be searched for.
+	Query string
+	// Split returns one series per level or source instead of a total
+	Split string
+}
+
+// Point is the number of entries within one interval starting at Time
+type Point struct {
+	Time  time.Time `json:"time"`
+	Count uint64    `json:"count"`
+}
+
+// Series is a named list of points, oldest first
+type Series struct {
+	Name   string  `json:"name"`
+	Points []Point `json:"points"`
+}
+
+// HistogramResult is the answer to a HistogramQuery
+type HistogramResult struct {
+	Window   string                `json:"window"`
+	Interval string                `json:"interval"`
+	From     time.Time             `json:"from"`
+	Sources  []collector.LogSource `json:"sources,omitempty"`
+	MinLevel collector.LogLevel    `json:"min_level,omitempty"`
+	Query    string                `json:"query,omitempty"`
+	Split    string                `json:"split,omitempty"`
+	// Approximate is set when a query matched templates of a summary that
+	// had reached its capacity, so some entries may be missing
+	Approximate bool     `json:"approximate,omitempty"`
+	Total       uint64   `json:"total"`
+	Series      []Series `json:"series"`
+}
+
+// Histogram returns entry counts per interval over the window. Points
+// without entries are included with a zero count.
+func (t *Tracker) Histogram(q HistogramQuery, now time.Time) (*HistogramResult, error) {
+	if err := t.validateWindow(q.Window); err != nil {
+		return nil, err
+	}
+	if err := q.Filter.validate(); err != nil {
+		return nil, err
+	}
+	switch q.Split {
+	case SplitNone, SplitLevel, SplitSource:
+	default:
+		return nil, fmt.Errorf("split must be %q or %q", SplitLevel, SplitSource)
+	}
+	if q.Interval == 0 {
+		q.Interval = (q.Window + defaultPoints - 1) / defaultPoints
+		q.Interval = (q.Interval + bucketWidth - 1) / bucketWidth * bucketWidth
+	}
+	if q.Interval < bucketWidth || q.Interval%bucketWidth != 0 {
+		return nil, fmt.Errorf("interval must be a whole number of minutes")
+	}
+	width := int64(q.Interval / bucketWidth)
+	points := (int64(q.Window) + int64(q.Interval) - 1) / int64(q.Interval)
+	if points > MaxPoints {
+		return nil, fmt.Errorf("window %s at interval %s gives more than %d points", q.Window, q.Interval, MaxPoints)
+	}
+	if max := int64(len(t.buckets)) / width; points > max {
+		// Whole intervals within the retention
+		points = max
+	}
+
+	// Intervals are aligned to multiples of their width, so the same
+	// query gives the same points as time goes on
+	last := now.Unix() / 60 / width * width
+	first := last - (points-1)*width
+	query := strings.ToLower(q.Query)
+
+	result := &HistogramResult{
+		Window:   (time.Duration(points) * q.Interval).String(),
+		Interval: q.Interval.String(),
+		From:     time.Unix(first*60, 0).UTC(),
+		Sources:  q.Sources,
+		MinLevel: q.MinLevel,
+		Query:    q.Query,
+		Split:    q.Split,
+		Series:   []Series{},
+	}
+	counts := make(map[string][]uint64)
+	add := func(key seriesKey, minute int64, count uint64) {
+		name := "total"
+		switch q.Split {
+		case SplitLevel:
+			name = string(key.level)
+		case SplitSource:
+			name = string(key.source)
+		}
+		series, ok := counts[name]
+		if !ok {
+			series = make([]uint64, points)
+			counts[name] = series
+		}
+		series[(minute-first)/width] += count
+		result.Total += count
+	}
+
+	t.mu.Lock()
+	for i := range t.buckets {
+		b := &t.buckets[i]
+		if b.minute < first || b.minute >= last+width {
+			continue
+		}
+		if query == "" {
+			for key, count := range b.counts {
+				if q.selects(key) {
+					add(key, b.minute, count)
+				}
+			}
+			continue
+		}
+		for key, s := range b.summaries {
+			if key.dimension != DimensionTemplate || !q.selects(key.seriesKey) {
+				continue
+			}
+			matched := false
+			for template, c := range s.index {
+				if strings.Contains(strings.ToLower(template), query) {
+					add(key.seriesKey, b.minute, c.count)
+					matched = true
+				}
+			}
+			if matched && s.floor() > 0 {
+				result.Approximate = true
+			}
+		}
+	}
+	t.mu.Unlock()
+
+	if len(counts) == 0 && q.Split == SplitNone {
+		counts["total"] = make([]uint64, points)
+	}
+	for name, values := range counts {
+		series := Series{Name: name, Points: make([]Point, points)}
+		for i, count := range values {
+			series.Points[i] = Point{Time: time.Unix((first+int64(i)*width)*60, 0).UTC(), Count: count}
+		}
+		result.Series = append(result.Series, series)
+	}
+	sort.Slice(result.Series, func(i, j int) bool { return result.Series[i].Name < result.Series[j].Name })
+	return result, nil
+}
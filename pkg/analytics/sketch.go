@@ -0,0 +1,113 @@
+package analytics
+
+import (
+	"container/heap"
+	"strings"
+)
+
+// summary is a Space-Saving heavy-hitter summary (Metwally et al.). It
+// keeps at most capacity keys. When it is full a new key takes the place
+// of the key with the smallest count and inherits that count as its error,
+// so a kept count overestimates the true count by at most its error, and a
+// key that isn't kept occurred at most floor() times.
+type summary struct {
+	capacity int
+	index    map[string]*counter
+	heap     counterHeap
+}
+
+type counter struct {
+	key   string
+	count uint64
+	err   uint64
+	pos   int
+}
+
+func newSummary(capacity int) *summary {
+	return &summary{capacity: capacity, index: make(map[string]*counter)}
+}
+
+func (s *summary) add(key string) {
+	if c, ok := s.index[key]; ok {
+		c.count++
+		heap.Fix(&s.heap, c.pos)
+		return
+	}
+	// Keys often point into a whole log line; don't keep the line alive
+	key = strings.Clone(key)
+	if len(s.heap) < s.capacity {
+		c := &counter{key: key, count: 1}
+		s.index[key] = c
+		heap.Push(&s.heap, c)
+		return
+	}
+	min := s.heap[0]
+	delete(s.index, min.key)
+	min.key = key
+	min.err = min.count
+	min.count++
+	s.index[key] = min
+	heap.Fix(&s.heap, 0)
+}
+
+// floor is the most a key missing from the summary can have occurred
+func (s *summary) floor() uint64 {
+	if len(s.heap) < s.capacity {
+		return 0
+	}
+	return s.heap[0].count
+}
+
+// counterHeap is a min-heap of counters by count
+type counterHeap []*counter
+
+func (h counterHeap) Len() int           { return len(h) }
+func (h counterHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h counterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos = i
+	h[j].pos = j
+}
+
+func (h *counterHeap) Push(x interface{}) {
+	c := x.(*counter)
+	c.pos = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *counterHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// estimate is a merged count with its maximum error
+type estimate struct {
+	count uint64
+	err   uint64
+}
+
+// merge adds up summaries. A key's error is the sum of its errors in the
+// summaries that kept it and of the floors of those that didn't.
+func merge(summaries []*summary) map[string]*estimate {
+	var floors uint64
+	for _, s := range summaries {
+		floors += s.floor()
+	}
+	merged := make(map[string]*estimate)
+	for _, s := range summaries {
+		floor := s.floor()
+		for key, c := range s.index {
+			e, ok := merged[key]
+			if !ok {
+				e = &estimate{err: floors}
+				merged[key] = e
+			}
+			e.count += c.count
+			// Kept here, so this summary's floor doesn't apply
+			e.err = e.err - floor + c.err
+		}
+	}
+	return merged
+}
@@ -0,0 +1,52 @@
+package analytics
+
+import "strings"
+
+const (
+	// maxTemplateTokens bounds the tokens of a template
+	maxTemplateTokens = 32
+	// wildcard replaces variable tokens
+	wildcard = "<*>"
+)
+
+// Template reduces a message to its shape by replacing the tokens that
+// vary between occurrences (anything with a digit: counts, IDs, IPs,
+// durations) with <*>, so "Failed password for root from 10.0.0.5 port
+// 4242" and the same line for another IP share a template. key=value
+// tokens keep their key.
+func Template(message string) string {
+	var b strings.Builder
+	tokens := 0
+	lastWild := false
+	for _, token := range strings.Fields(message) {
+		if tokens == maxTemplateTokens {
+			b.WriteString(" …")
+			break
+		}
+		if key, value, ok := strings.Cut(token, "="); ok && key != "" && hasDigit(value) {
+			token = key + "=" + wildcard
+		} else if hasDigit(token) {
+			if lastWild {
+				// Runs of variable tokens collapse into one
+				continue
+			}
+			token = wildcard
+		}
+		if tokens > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(token)
+		lastWild = token == wildcard
+		tokens++
+	}
+	return b.String()
+}
+
+func hasDigit(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			return true
+		}
+	}
+	return false
+}
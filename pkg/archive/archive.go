@@ -0,0 +1,506 @@
+// Package archive writes collected entries to a directory of immutable
+// NDJSON objects for long-term storage, e.g. a directory synced to S3.
+//
+// Entries go to an open object named "*.ndjson.partial". When it reaches
+// its size or age limit it is sealed: renamed to "*.ndjson", its SHA-256 is
+// written next to it in sha256sum format ("*.ndjson.sha256") and it is
+// added to the manifest of its day ("manifest-2006-01-02.json"). With a
+// signing key every manifest gets a detached Ed25519 signature
+// ("manifest-2006-01-02.json.sig"), so consumers holding the public key can
+// check that no object was changed, removed or added (see Verify).
+//
+// With raw line compression, the raw lines of each source type are
+// compressed with a zstd dictionary trained on its first lines
+// ("dictionary-<sha256>.bin", listed by the objects using it); ReadObject
+// restores them, and the DEFLATE-compressed lines of older archives.
+//
+// With downsampling, entries compaction drops for their age are rolled up
+// into hourly summaries first ("summary-2006-01-02.json", signed too).
+package archive
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// Default object limits
+const (
+	DefaultMaxBytes = 64 * 1024 * 1024
+	DefaultMaxAge   = time.Hour
+)
+
+// File name parts
+const (
+	objectSuffix    = ".ndjson"
+	partialSuffix   = ".partial"
+	checksumSuffix  = ".sha256"
+	signatureSuffix = ".sig"
+	manifestPrefix  = "manifest-"
+	manifestSuffix  = ".json"
+	manifestDay     = "2006-01-02"
+)
+
+// Config configures the archive output
+type Config struct {
+	Dir string
+	// MaxBytes and MaxAge seal the open object once it is this large or
+	// this old
+	MaxBytes int64
+	MaxAge   time.Duration
+	// SigningKey signs the manifests when set
+	SigningKey ed25519.PrivateKey
+	// CompressRaw compresses raw lines with per-source dictionaries
+	CompressRaw bool
+	// CompactInterval runs Compact periodically when positive
+	CompactInterval time.Duration
+	// Retention makes Compact drop entries older than it; zero keeps all
+	Retention time.Duration
+	// Downsample makes Compact roll the entries it drops into hourly
+	// summaries, which are kept for SummaryRetention (zero keeps them)
+	Downsample       bool
+	SummaryRetention time.Duration
+	// ImmutableFor keeps every object unchanged for this long after it was
+	// sealed (write once, read many): compaction neither merges nor expires
+	// it meanwhile
+	ImmutableFor time.Duration
+	// Capacity is the space planned for the archive, which Status reports
+	// usage against; zero plans with the free space of the file system
+	Capacity int64
+}
+
+// Object describes a sealed object in a manifest
+type Object struct {
+	Name           string     `json:"name"`
+	Size           int64      `json:"size"`
+	SHA256         string     `json:"sha256"`
+	Entries        int        `json:"entries"`
+	FirstTimestamp *time.Time `json:"first_timestamp,omitempty"`
+	LastTimestamp  *time.Time `json:"last_timestamp,omitempty"`
+	SealedAt       time.Time  `json:"sealed_at"`
+	// Dictionaries are the IDs of the dictionaries raw lines of the object
+	// are compressed with
+	Dictionaries []string `json:"dictionaries,omitempty"`
+}
+
+// Manifest lists the objects sealed on one day
+type Manifest struct {
+	Version int    `json:"version"`
+	Day     string `json:"day"`
+	// KeyID names the signing key, if any
+	KeyID   string   `json:"key_id,omitempty"`
+	Objects []Object `json:"objects"`
+}
+
+// Output is a collector.Output writing to the archive directory
+type Output struct {
+	config      Config
+	auditLogger *audit.Logger
+
+	mu      sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	current Object
+	opened  time.Time
+	seq     int
+	raw     *rawCompressor // nil without raw line compression
+	rate    ingestRate
+
+	lastCompaction    time.Time
+	lastCompactReport *CompactReport
+
+	// holdsMu guards holds, which compaction skips the objects of
+	holdsMu sync.Mutex
+	holds   []*Hold
+
+	// compactMu serializes compactions
+	compactMu   sync.Mutex
+	compactDone chan struct{}
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// New opens the archive directory. An object left open by a previous run is
+// sealed first.
+func New(cfg Config, auditLogger *audit.Logger) (*Output, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("archive directory is required")
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultMaxBytes
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = DefaultMaxAge
+	}
+	if err := os.MkdirAll(cfg.Dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory %s: %w", cfg.Dir, err)
+	}
+
+	o := &Output{
+		config:      cfg,
+		auditLogger: auditLogger,
+		rate:        ingestRate{started: time.Now()},
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if cfg.CompressRaw {
+		o.raw = newRawCompressor(cfg.Dir)
+	}
+	holds, err := loadHolds(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	o.holds = holds
+	if err := o.recover(); err != nil {
+		return nil, err
+	}
+	go o.rotateLoop()
+	if cfg.CompactInterval > 0 {
+		o.compactDone = make(chan struct{})
+		go o.compactLoop()
+	}
+	return o, nil
+}
+
+// Name returns the output name
+func (o *Output) Name() string {
+	return "archive"
+}
+
+// Write appends entry to the open object, sealing it first when full
+func (o *Output) Write(entry *collector.SystemLog) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	archived := plainEntry(entry)
+	if o.raw != nil {
+		var err error
+		if archived, err = o.raw.encode(entry); err != nil {
+			return fmt.Errorf("failed to compress raw line: %w", err)
+		}
+	}
+	line, err := json.Marshal(archived)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if o.file != nil && o.current.Size+int64(len(line)) > o.config.MaxBytes {
+		if err := o.sealLocked(); err != nil {
+			return err
+		}
+	}
+	if o.file == nil {
+		if err := o.openLocked(); err != nil {
+			return err
+		}
+	}
+	if _, err := o.writer.Write(line); err != nil {
+		return fmt.Errorf("failed to write archive object: %w", err)
+	}
+	o.current.Size += int64(len(line))
+	o.current.Entries++
+	o.rate.add(time.Now(), int64(len(line)))
+	if id := archived.dictionary(); id != "" && !slices.Contains(o.current.Dictionaries, id) {
+		o.current.Dictionaries = append(o.current.Dictionaries, id)
+	}
+	timestamp := entry.Timestamp.UTC()
+	if o.current.FirstTimestamp == nil {
+		o.current.FirstTimestamp = &timestamp
+	}
+	o.current.LastTimestamp = &timestamp
+	return nil
+}
+
+// Flush writes buffered entries to the open object
+func (o *Output) Flush() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.writer == nil {
+		return nil
+	}
+	return o.writer.Flush()
+}
+
+// Close seals the open object and stops the rotation and compaction loops
+func (o *Output) Close() error {
+	o.once.Do(func() {
+		close(o.stop)
+	})
+	<-o.done
+	if o.compactDone != nil {
+		<-o.compactDone
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.sealLocked()
+}
+
+// rotateLoop seals objects that reached their age limit
+func (o *Output) rotateLoop() {
+	defer close(o.done)
+	ticker := time.NewTicker(min(o.config.MaxAge, time.Minute))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-o.stop:
+			return
+		case <-ticker.C:
+			o.mu.Lock()
+			if o.file != nil && time.Since(o.opened) >= o.config.MaxAge {
+				if err := o.sealLocked(); err != nil {
+					o.auditLogger.LogError(err, "Failed to seal archive object", map[string]interface{}{"object": o.current.Name})
+				}
+			}
+			o.mu.Unlock()
+		}
+	}
+}
+
+// openLocked starts a new object. o.mu must be held.
+func (o *Output) openLocked() error {
+	now := time.Now().UTC()
+	name := o.nextNameLocked(now)
+	file, err := os.OpenFile(filepath.Join(o.config.Dir, name+partialSuffix), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to create archive object: %w", err)
+	}
+	o.file = file
+	o.writer = bufio.NewWriterSize(file, 64*1024)
+	o.current = Object{Name: name}
+	o.opened = now
+	return nil
+}
+
+// nextNameLocked returns an unused object name. o.mu must be held.
+func (o *Output) nextNameLocked(now time.Time) string {
+	for {
+		o.seq++
+		name := fmt.Sprintf("logs-%s-%04d%s", now.Format("20060102T150405Z"), o.seq, objectSuffix)
+		if _, err := os.Stat(filepath.Join(o.config.Dir, name)); os.IsNotExist(err) {
+			return name
+		}
+	}
+}
+
+// sealLocked closes the open object, if any, and records it. o.mu must be
+// held.
+func (o *Output) sealLocked() error {
+	if o.file == nil {
+		return nil
+	}
+	file, writer, object := o.file, o.writer, o.current
+	o.file, o.writer, o.current = nil, nil, Object{}
+
+	err := writer.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to close archive object %s: %w", object.Name, err)
+	}
+	return o.seal(object)
+}
+
+// seal renames a closed partial object, writes its checksum and adds it to
+// the manifest of the day
+func (o *Output) seal(object Object) error {
+	partial := filepath.Join(o.config.Dir, object.Name+partialSuffix)
+	if object.Entries == 0 {
+		return os.Remove(partial)
+	}
+
+	sum, size, err := hashFile(partial)
+	if err != nil {
+		return err
+	}
+	object.SHA256 = sum
+	object.Size = size
+	object.SealedAt = time.Now().UTC()
+
+	path := filepath.Join(o.config.Dir, object.Name)
+	if err := os.Rename(partial, path); err != nil {
+		return fmt.Errorf("failed to seal archive object %s: %w", object.Name, err)
+	}
+	if err := writeFileAtomic(path+checksumSuffix, []byte(sum+"  "+object.Name+"\n")); err != nil {
+		return err
+	}
+	return o.addToManifest(object)
+}
+
+// addToManifest appends object to its day's manifest and re-signs it
+func (o *Output) addToManifest(object Object) error {
+	day := object.SealedAt.Format(manifestDay)
+	path := filepath.Join(o.config.Dir, manifestPrefix+day+manifestSuffix)
+	manifest, _, err := readManifest(path)
+	switch {
+	case os.IsNotExist(err):
+		manifest = &Manifest{Version: 1, Day: day}
+	case err != nil:
+		return err
+	}
+	manifest.Objects = append(manifest.Objects, object)
+	return o.writeManifest(path, manifest)
+}
+
+// writeManifest replaces a manifest and its signature
+func (o *Output) writeManifest(path string, manifest *Manifest) error {
+	if o.config.SigningKey != nil {
+		manifest.KeyID = KeyID(o.config.SigningKey.Public().(ed25519.PublicKey))
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return o.writeSigned(path, append(data, '\n'))
+}
+
+// writeSigned replaces a file and, with a signing key, its signature
+func (o *Output) writeSigned(path string, data []byte) error {
+	if o.config.SigningKey != nil {
+		// Write the new signature first: a crash in between leaves a
+		// file that fails verification rather than an outdated one that
+		// passes
+		signature := ed25519.Sign(o.config.SigningKey, data)
+		if err := writeFileAtomic(path+signatureSuffix, []byte(hex.EncodeToString(signature)+"\n")); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(path, data)
+}
+
+// recover seals objects left open by a previous run and removes the
+// objects of an interrupted compaction
+func (o *Output) recover() error {
+	stale, err := filepath.Glob(filepath.Join(o.config.Dir, compactTempPattern))
+	if err != nil {
+		return err
+	}
+	for _, path := range stale {
+		os.Remove(path)
+	}
+	matches, err := filepath.Glob(filepath.Join(o.config.Dir, "*"+objectSuffix+partialSuffix))
+	if err != nil {
+		return err
+	}
+	for _, partial := range matches {
+		object, err := scanPartial(partial)
+		if err != nil {
+			return err
+		}
+		if err := o.seal(object); err != nil {
+			return err
+		}
+		o.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: "archive_recovered",
+			Message:   fmt.Sprintf("Sealed archive object %s left open by a previous run", object.Name),
+			Details:   map[string]interface{}{"object": object.Name, "entries": object.Entries},
+		})
+	}
+	return nil
+}
+
+// scanPartial counts the entries of a partial object and collects its
+// dictionaries, dropping a torn last line
+func scanPartial(path string) (Object, error) {
+	object := Object{Name: strings.TrimSuffix(filepath.Base(path), partialSuffix)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return object, fmt.Errorf("failed to read archive object: %w", err)
+	}
+	if end := strings.LastIndexByte(string(data), '\n') + 1; end < len(data) {
+		data = data[:end]
+		if err := os.Truncate(path, int64(end)); err != nil {
+			return object, fmt.Errorf("failed to truncate archive object: %w", err)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry struct {
+			Timestamp time.Time `json:"timestamp"`
+			compressedLine
+		}
+		json.Unmarshal([]byte(line), &entry)
+		timestamp := entry.Timestamp.UTC()
+		if object.FirstTimestamp == nil {
+			object.FirstTimestamp = &timestamp
+		}
+		object.LastTimestamp = &timestamp
+		object.Entries++
+		if id := entry.dictionary(); id != "" && !slices.Contains(object.Dictionaries, id) {
+			object.Dictionaries = append(object.Dictionaries, id)
+		}
+	}
+	return object, nil
+}
+
+// hashFile returns the hex SHA-256 and size of a file
+func hashFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to hash %s: %w", filepath.Base(path), err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// readManifest reads a manifest and returns it with its raw bytes
+func readManifest(path string) (*Manifest, []byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("invalid manifest %s: %w", filepath.Base(path), err)
+	}
+	return &manifest, data, nil
+}
+
+// writeFileAtomic replaces path through a synced temporary file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,426 @@
+package archive
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// compactTempPattern names objects being merged; they are removed on start
+// if compaction was interrupted
+const compactTempPattern = ".compact-*.tmp"
+
+// CompactReport is the outcome of a compaction
+type CompactReport struct {
+	// Merged counts the objects merged into Created larger ones
+	Merged  int `json:"merged"`
+	Created int `json:"created"`
+	// Expired counts the objects removed and ExpiredEntries the entries
+	// dropped for being older than the retention
+	Expired        int   `json:"expired"`
+	ExpiredEntries int   `json:"expired_entries"`
+	BytesBefore    int64 `json:"bytes_before"`
+	BytesAfter     int64 `json:"bytes_after"`
+	// With downsampling, Summarized counts the expired entries rolled up
+	// into summaries and ExpiredSummaries the summary days removed
+	Summarized       int `json:"summarized,omitempty"`
+	ExpiredSummaries int `json:"expired_summaries,omitempty"`
+}
+
+func (r *CompactReport) changed() bool {
+	return r.Merged > 0 || r.Expired > 0 || r.ExpiredEntries > 0 || r.ExpiredSummaries > 0
+}
+
+// compactLoop compacts the archive every CompactInterval
+func (o *Output) compactLoop() {
+	defer close(o.compactDone)
+	ticker := time.NewTicker(o.config.CompactInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-o.stop:
+			return
+		case <-ticker.C:
+			report, err := o.Compact()
+			if err != nil {
+				o.auditLogger.LogError(err, "Failed to compact archive", nil)
+				continue
+			}
+			if report.changed() {
+				o.auditLogger.LogEvent(audit.AuditEvent{
+					EventType: "archive_compacted",
+					Message:   fmt.Sprintf("Compacted archive: %d object(s) merged into %d, %d expired entries dropped", report.Merged, report.Created, report.ExpiredEntries),
+					Details: map[string]interface{}{
+						"merged":            report.Merged,
+						"created":           report.Created,
+						"expired":           report.Expired,
+						"expired_entries":   report.ExpiredEntries,
+						"bytes_before":      report.BytesBefore,
+						"bytes_after":       report.BytesAfter,
+						"summarized":        report.Summarized,
+						"expired_summaries": report.ExpiredSummaries,
+					},
+				})
+			}
+		}
+	}
+}
+
+// Compact merges runs of small sealed objects of the same day (less than
+// half of MaxBytes) into objects of up to MaxBytes, and with a Retention
+// drops the entries older than it: objects holding only expired entries
+// are removed, others rewritten without them. With Downsample the expired
+// entries are rolled up into the summaries of their days first, and
+// summaries older than SummaryRetention are removed. Merged objects replace
+// their inputs in the manifest, which is signed again, and dictionaries no
+// object needs any more are removed.
+func (o *Output) Compact() (*CompactReport, error) {
+	o.compactMu.Lock()
+	defer o.compactMu.Unlock()
+
+	manifests, err := filepath.Glob(filepath.Join(o.config.Dir, manifestPrefix+"*"+manifestSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(manifests)
+	cutoff := o.cutoff()
+	var ds *downsampler
+	if o.config.Downsample && !cutoff.IsZero() {
+		ds = newDownsampler(o.config.Dir)
+	}
+
+	report := &CompactReport{}
+	for _, path := range manifests {
+		if err := o.compactManifest(path, cutoff, ds, report); err != nil {
+			return report, err
+		}
+	}
+	if o.config.SummaryRetention > 0 {
+		expired, err := o.expireSummaries(time.Now().Add(-o.config.SummaryRetention))
+		report.ExpiredSummaries = expired
+		if err != nil {
+			return report, err
+		}
+	}
+	if report.changed() {
+		if err := o.removeUnusedDictionaries(); err != nil {
+			return report, err
+		}
+	}
+	o.mu.Lock()
+	o.lastCompaction, o.lastCompactReport = time.Now().UTC(), report
+	o.mu.Unlock()
+	return report, nil
+}
+
+// cutoff returns the time before which entries expire, zero without
+// retention
+func (o *Output) cutoff() time.Time {
+	if o.config.Retention <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-o.config.Retention)
+}
+
+// compaction replaces the objects Inputs of a manifest by Output, or
+// removes them when Output is nil
+type compaction struct {
+	inputs []Object
+	output *Object
+}
+
+// compactManifest rewrites the objects of a manifest. Summaries are written
+// before the expired objects are removed: a crash in between counts their
+// entries twice rather than losing them.
+func (o *Output) compactManifest(path string, cutoff time.Time, ds *downsampler, report *CompactReport) error {
+	manifest, _, err := readManifest(path)
+	if err != nil {
+		return err
+	}
+
+	plans := o.plan(manifest.Objects, cutoff)
+	var done []compaction
+	for _, inputs := range plans {
+		output, expired, err := o.merge(inputs, cutoff, ds)
+		if err != nil {
+			return err
+		}
+		done = append(done, compaction{inputs: inputs, output: output})
+		for _, input := range inputs {
+			report.BytesBefore += input.Size
+		}
+		if output != nil {
+			report.BytesAfter += output.Size
+		}
+		report.ExpiredEntries += expired
+		if len(inputs) > 1 {
+			report.Merged += len(inputs)
+			report.Created++
+		} else if output == nil {
+			report.Expired++
+		}
+	}
+	if len(done) == 0 {
+		return nil
+	}
+	if ds != nil {
+		report.Summarized += ds.entries
+		ds.entries = 0
+		if err := o.writeSummaries(ds); err != nil {
+			return err
+		}
+	}
+	return o.replaceInManifest(path, done)
+}
+
+// plan returns the groups of objects of a manifest a compaction rewrites:
+// runs of small objects merged into one, and single objects holding expired
+// entries. Held objects are left alone.
+func (o *Output) plan(objects []Object, cutoff time.Time) [][]Object {
+	now := time.Now()
+	var plans [][]Object
+	var run []Object
+	var runSize int64
+	flush := func() {
+		if len(run) > 1 {
+			plans = append(plans, run)
+		}
+		run, runSize = nil, 0
+	}
+	for _, object := range objects {
+		if o.held(object, now) {
+			flush()
+			continue
+		}
+		if expires(object, cutoff) {
+			flush()
+			plans = append(plans, []Object{object})
+			continue
+		}
+		if object.Size >= o.config.MaxBytes/2 {
+			flush()
+			continue
+		}
+		if runSize+object.Size > o.config.MaxBytes {
+			flush()
+		}
+		run = append(run, object)
+		runSize += object.Size
+	}
+	flush()
+	return plans
+}
+
+// expires reports whether an object holds entries older than cutoff
+func expires(object Object, cutoff time.Time) bool {
+	return !cutoff.IsZero() && object.FirstTimestamp != nil && object.FirstTimestamp.Before(cutoff)
+}
+
+// merge writes the entries of inputs not older than cutoff to a new sealed
+// object, in order, and returns it with the number of entries dropped,
+// which ds summarizes when set. It returns no object when every entry
+// expired.
+func (o *Output) merge(inputs []Object, cutoff time.Time, ds *downsampler) (*Object, int, error) {
+	tmp, err := os.CreateTemp(o.config.Dir, compactTempPattern)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create archive object: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	writer := bufio.NewWriterSize(tmp, 64*1024)
+
+	var object Object
+	expired := 0
+	for _, input := range inputs {
+		n, err := copyEntries(writer, filepath.Join(o.config.Dir, input.Name), cutoff, ds, &object)
+		if err != nil {
+			tmp.Close()
+			return nil, 0, err
+		}
+		expired += n
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to write archive object: %w", err)
+	}
+	if object.Entries == 0 {
+		return nil, expired, nil
+	}
+
+	o.mu.Lock()
+	object.Name = o.nextNameLocked(time.Now().UTC())
+	o.mu.Unlock()
+	sum, size, err := hashFile(tmp.Name())
+	if err != nil {
+		return nil, 0, err
+	}
+	object.SHA256, object.Size, object.SealedAt = sum, size, time.Now().UTC()
+	path := filepath.Join(o.config.Dir, object.Name)
+	if err := os.Chmod(tmp.Name(), 0640); err != nil {
+		return nil, 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, 0, fmt.Errorf("failed to seal archive object %s: %w", object.Name, err)
+	}
+	if err := writeFileAtomic(path+checksumSuffix, []byte(sum+"  "+object.Name+"\n")); err != nil {
+		return nil, 0, err
+	}
+	return &object, expired, nil
+}
+
+// copyEntries appends the lines of an object not older than cutoff to w,
+// accounting for them in object, and returns the number of lines dropped
+// after passing them to ds, if set
+func copyEntries(w *bufio.Writer, path string, cutoff time.Time, ds *downsampler, object *Object) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read archive object: %w", err)
+	}
+	defer file.Close()
+
+	expired := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var entry struct {
+			Timestamp time.Time `json:"timestamp"`
+			compressedLine
+		}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return 0, fmt.Errorf("invalid entry in %s: %w", filepath.Base(path), err)
+		}
+		timestamp := entry.Timestamp.UTC()
+		if !cutoff.IsZero() && timestamp.Before(cutoff) {
+			if ds != nil {
+				if err := ds.add(line); err != nil {
+					return 0, fmt.Errorf("failed to summarize entry in %s: %w", filepath.Base(path), err)
+				}
+			}
+			expired++
+			continue
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+		object.Entries++
+		if object.FirstTimestamp == nil || timestamp.Before(*object.FirstTimestamp) {
+			object.FirstTimestamp = &timestamp
+		}
+		if object.LastTimestamp == nil || timestamp.After(*object.LastTimestamp) {
+			object.LastTimestamp = &timestamp
+		}
+		if id := entry.dictionary(); id != "" && !slices.Contains(object.Dictionaries, id) {
+			object.Dictionaries = append(object.Dictionaries, id)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read archive object %s: %w", filepath.Base(path), err)
+	}
+	return expired, nil
+}
+
+// replaceInManifest puts the outputs of compactions in the place of their
+// inputs, signs the manifest again and then removes the inputs. The
+// manifest is read again as an object may have been sealed meanwhile.
+func (o *Output) replaceInManifest(path string, done []compaction) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	manifest, _, err := readManifest(path)
+	if err != nil {
+		return err
+	}
+	replaced := make(map[string]*Object)
+	removed := make(map[string]bool)
+	for _, c := range done {
+		for i, input := range c.inputs {
+			removed[input.Name] = true
+			if i == 0 && c.output != nil {
+				replaced[input.Name] = c.output
+			}
+		}
+	}
+	objects := make([]Object, 0, len(manifest.Objects))
+	for _, object := range manifest.Objects {
+		if output, ok := replaced[object.Name]; ok {
+			objects = append(objects, *output)
+		} else if !removed[object.Name] {
+			objects = append(objects, object)
+		}
+	}
+	manifest.Objects = objects
+
+	if len(objects) == 0 {
+		os.Remove(path + signatureSuffix)
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	} else if err := o.writeManifest(path, manifest); err != nil {
+		return err
+	}
+	for name := range removed {
+		object := filepath.Join(o.config.Dir, name)
+		os.Remove(object + checksumSuffix)
+		if err := os.Remove(object); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeUnusedDictionaries removes the dictionaries no listed object and
+// no object still being written needs
+func (o *Output) removeUnusedDictionaries() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	used := make(map[string]bool)
+	for _, id := range o.current.Dictionaries {
+		used[id] = true
+	}
+	if o.raw != nil {
+		for _, dict := range o.raw.dictionaries {
+			used[dict.id] = true
+		}
+	}
+	manifests, err := filepath.Glob(filepath.Join(o.config.Dir, manifestPrefix+"*"+manifestSuffix))
+	if err != nil {
+		return err
+	}
+	for _, path := range manifests {
+		manifest, _, err := readManifest(path)
+		if err != nil {
+			return err
+		}
+		for _, object := range manifest.Objects {
+			for _, id := range object.Dictionaries {
+				used[id] = true
+			}
+		}
+	}
+	dictionaries, err := filepath.Glob(filepath.Join(o.config.Dir, dictionaryPrefix+"*"+dictionarySuffix))
+	if err != nil {
+		return err
+	}
+	for _, path := range dictionaries {
+		id := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), dictionaryPrefix), dictionarySuffix)
+		if !used[id] {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
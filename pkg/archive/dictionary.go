@@ -0,0 +1,299 @@
+package archive
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// dictionarySamples is the number of raw lines of a source a dictionary
+	// is trained on; they are archived uncompressed
+	dictionarySamples = 256
+	// maxDictionaryBytes bounds the history of a dictionary, the sampled
+	// lines later lines can copy from
+	maxDictionaryBytes = 32 * 1024
+	// zstdDictionaryID is the ID dictionaries are trained with. Frames
+	// carry it in a single byte; which dictionary a line needs is recorded
+	// next to it, so the ID need not tell dictionaries apart.
+	zstdDictionaryID = 1
+
+	dictionaryPrefix = "dictionary-"
+	dictionarySuffix = ".bin"
+)
+
+// compressedLine is the raw line of an archived entry compressed with a
+// dictionary: its ID and the compressed line in unpadded base64,
+// "<dictionary>:<data>". raw_log_zstd holds a zstd frame; raw_log_deflate
+// is only read, from archives written before lines were compressed with
+// zstd.
+type compressedLine struct {
+	RawLogZstd    string `json:"raw_log_zstd,omitempty"`
+	RawLogDeflate string `json:"raw_log_deflate,omitempty"`
+}
+
+// dictionary returns the ID of the dictionary the raw line is compressed
+// with, if any
+func (c *compressedLine) dictionary() string {
+	id, _, _ := strings.Cut(c.RawLogZstd+c.RawLogDeflate, ":")
+	return id
+}
+
+// archivedEntry is an entry as written to an object. With raw line
+// compression raw_log is left out for raw_log_zstd.
+type archivedEntry struct {
+	*collector.SystemLog
+	// RawLog shadows the line of the entry, so it can be left out
+	RawLog *string `json:"raw_log,omitempty"`
+	compressedLine
+}
+
+func plainEntry(log *collector.SystemLog) archivedEntry {
+	return archivedEntry{SystemLog: log, RawLog: &log.RawLog}
+}
+
+// rawDictionary is a trained dictionary with a reusable encoder
+type rawDictionary struct {
+	id      string
+	encoder *zstd.Encoder
+	buf     []byte
+}
+
+// rawCompressor trains a zstd dictionary per source type on its first raw
+// lines and compresses later ones with it. Log lines of one format share
+// most of their text, which a single line is too short to exploit on its
+// own; with the dictionary lines shrink several-fold.
+//
+// Each line is compressed into a frame of its own so that it can be
+// restored, and compaction can drop it, without the lines around it.
+// Frames leave out the checksum and the window size to keep that overhead
+// down: the archive object has a SHA-256 of its own.
+type rawCompressor struct {
+	dir          string
+	samples      map[collector.LogSource][]string
+	dictionaries map[collector.LogSource]*rawDictionary
+}
+
+func newRawCompressor(dir string) *rawCompressor {
+	return &rawCompressor{
+		dir:          dir,
+		samples:      make(map[collector.LogSource][]string),
+		dictionaries: make(map[collector.LogSource]*rawDictionary),
+	}
+}
+
+// encode returns the entry to write for log. Until the dictionary of its
+// source is trained the raw line is kept as it is.
+func (rc *rawCompressor) encode(log *collector.SystemLog) (archivedEntry, error) {
+	if log.RawLog == "" {
+		return plainEntry(log), nil
+	}
+	dict := rc.dictionaries[log.Source]
+	if dict == nil {
+		samples := append(rc.samples[log.Source], log.RawLog)
+		if len(samples) < dictionarySamples {
+			rc.samples[log.Source] = samples
+			return plainEntry(log), nil
+		}
+		delete(rc.samples, log.Source)
+		var err error
+		if dict, err = rc.train(samples); err != nil {
+			return archivedEntry{}, err
+		}
+		rc.dictionaries[log.Source] = dict
+	}
+
+	dict.buf = dict.encoder.EncodeAll([]byte(log.RawLog), dict.buf[:0])
+	return archivedEntry{
+		SystemLog:      log,
+		compressedLine: compressedLine{RawLogZstd: dict.id + ":" + base64.RawStdEncoding.EncodeToString(dict.buf)},
+	}, nil
+}
+
+// train builds a dictionary from sampled lines and writes it to the archive
+// directory. The deduplicated samples, newest last, are its history and
+// its entropy tables are fitted to them. Dictionaries are named by their
+// SHA-256, so a manifest listing an object's dictionaries covers their
+// content too.
+func (rc *rawCompressor) train(samples []string) (*rawDictionary, error) {
+	seen := make(map[string]bool, len(samples))
+	var history []byte
+	var contents [][]byte
+	for i := len(samples) - 1; i >= 0 && len(history) < maxDictionaryBytes; i-- {
+		if seen[samples[i]] {
+			continue
+		}
+		seen[samples[i]] = true
+		history = append([]byte(samples[i]+"\n"), history...)
+		contents = append(contents, []byte(samples[i]))
+	}
+	if len(history) > maxDictionaryBytes {
+		history = history[len(history)-maxDictionaryBytes:]
+	}
+	data, err := zstd.BuildDict(zstd.BuildDictOptions{ID: zstdDictionaryID, Contents: contents, History: history, Level: zstd.SpeedDefault})
+	if err != nil {
+		return nil, fmt.Errorf("failed to train archive dictionary: %w", err)
+	}
+
+	id := dictionaryID(data)
+	path := filepath.Join(rc.dir, dictionaryPrefix+id+dictionarySuffix)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := writeFileAtomic(path, data); err != nil {
+			return nil, fmt.Errorf("failed to write archive dictionary: %w", err)
+		}
+	}
+	encoder, err := zstd.NewWriter(nil,
+		zstd.WithEncoderDict(data),
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithEncoderCRC(false),
+		zstd.WithSingleSegment(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &rawDictionary{id: id, encoder: encoder}, nil
+}
+
+func dictionaryID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// readDictionary reads a dictionary and checks it against its ID
+func readDictionary(dir, id string) ([]byte, error) {
+	if strings.ContainsAny(id, `/\`) {
+		return nil, fmt.Errorf("invalid dictionary ID %q", id)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, dictionaryPrefix+id+dictionarySuffix))
+	if err != nil {
+		return nil, err
+	}
+	if dictionaryID(data) != id {
+		return nil, fmt.Errorf("dictionary %s: SHA-256 does not match its name", id)
+	}
+	return data, nil
+}
+
+// ReadObject calls fn for each entry of an archive object, with raw lines
+// compressed by a dictionary restored
+func ReadObject(dir, name string, fn func(*collector.SystemLog) error) error {
+	file, err := os.Open(filepath.Join(dir, filepath.Base(name)))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	entries := newEntryDecoder(dir)
+	for {
+		var entry archivedEntry
+		if err := decoder.Decode(&entry); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("invalid entry in %s: %w", filepath.Base(name), err)
+		}
+		log, err := entries.restore(&entry)
+		if err != nil {
+			return err
+		}
+		if err := fn(log); err != nil {
+			return err
+		}
+	}
+}
+
+// entryDecoder restores archived entries, caching the dictionaries read
+// and a decoder per zstd dictionary
+type entryDecoder struct {
+	dir          string
+	dictionaries map[string][]byte
+	decoders     map[string]*zstd.Decoder
+}
+
+func newEntryDecoder(dir string) *entryDecoder {
+	return &entryDecoder{dir: dir, dictionaries: make(map[string][]byte), decoders: make(map[string]*zstd.Decoder)}
+}
+
+// decode parses an archived line and restores it
+func (d *entryDecoder) decode(line []byte) (*collector.SystemLog, error) {
+	var entry archivedEntry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return nil, err
+	}
+	return d.restore(&entry)
+}
+
+// dictionary returns a dictionary, reading it on first use
+func (d *entryDecoder) dictionary(id string) ([]byte, error) {
+	if dict, cached := d.dictionaries[id]; cached {
+		return dict, nil
+	}
+	dict, err := readDictionary(d.dir, id)
+	if err != nil {
+		return nil, err
+	}
+	d.dictionaries[id] = dict
+	return dict, nil
+}
+
+// restore returns the entry with its raw line decompressed
+func (d *entryDecoder) restore(entry *archivedEntry) (*collector.SystemLog, error) {
+	if entry.SystemLog == nil {
+		entry.SystemLog = &collector.SystemLog{}
+	}
+	if entry.RawLog != nil {
+		entry.SystemLog.RawLog = *entry.RawLog
+	}
+	compressed, legacy := entry.RawLogZstd, false
+	if compressed == "" {
+		compressed, legacy = entry.RawLogDeflate, true
+	}
+	id, data, ok := strings.Cut(compressed, ":")
+	if !ok {
+		return entry.SystemLog, nil
+	}
+	dict, err := d.dictionary(id)
+	if err != nil {
+		return nil, err
+	}
+	frame, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid raw line of entry %s: %w", entry.ID, err)
+	}
+	var raw []byte
+	if legacy {
+		raw, err = io.ReadAll(flate.NewReaderDict(bytes.NewReader(frame), dict))
+	} else {
+		raw, err = d.decompress(id, dict, frame)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid raw line of entry %s: %w", entry.ID, err)
+	}
+	entry.SystemLog.RawLog = string(raw)
+	return entry.SystemLog, nil
+}
+
+// decompress decodes a zstd frame compressed with the dictionary id
+func (d *entryDecoder) decompress(id string, dict, frame []byte) ([]byte, error) {
+	decoder := d.decoders[id]
+	if decoder == nil {
+		var err error
+		decoder, err = zstd.NewReader(nil, zstd.WithDecoderDicts(dict), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		d.decoders[id] = decoder
+	}
+	return decoder.DecodeAll(frame, nil)
+}
@@ -0,0 +1,126 @@
+package archive
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+func nginxLine(i int) string {
+	return fmt.Sprintf(`10.0.%d.%d - - [01/Mar/2026:12:%02d:%02d +0000] "GET /api/items/%d HTTP/1.1" 200 %d "-" "Mozilla/5.0 (X11; Linux x86_64)"`,
+		i%7, i%251, i/60%60, i%60, i*13, 100+i%900)
+}
+
+func TestRawCompressorRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	rc := newRawCompressor(dir)
+	decoder := newEntryDecoder(dir)
+
+	raw, stored := 0, 0
+	for i := 0; i < dictionarySamples+200; i++ {
+		line := nginxLine(i)
+		entry, err := rc.encode(&collector.SystemLog{Source: collector.SourceNginx, Message: "request", RawLog: line})
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed := entry.RawLogZstd != ""
+		if want := i >= dictionarySamples-1; compressed != want {
+			t.Fatalf("line %d: compressed = %v, want %v", i, compressed, want)
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		log, err := decoder.decode(data)
+		if err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if log.RawLog != line || log.Message != "request" {
+			t.Fatalf("line %d restored as %+v", i, log)
+		}
+		if compressed {
+			raw += len(line)
+			_, data, _ := strings.Cut(entry.RawLogZstd, ":")
+			stored += len(data)
+		}
+	}
+	if stored*2 > raw {
+		t.Fatalf("compressed lines take %d of %d bytes", stored, raw)
+	}
+}
+
+func TestRawCompressorPerSource(t *testing.T) {
+	rc := newRawCompressor(t.TempDir())
+	for i := 0; i < dictionarySamples; i++ {
+		if _, err := rc.encode(&collector.SystemLog{Source: collector.SourceNginx, RawLog: nginxLine(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entry, err := rc.encode(&collector.SystemLog{Source: collector.SourceSyslog, RawLog: "kernel: eth0 up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.RawLogZstd != "" {
+		t.Fatal("a source without a trained dictionary was compressed")
+	}
+	if entry, _ := rc.encode(&collector.SystemLog{Source: collector.SourceNginx}); entry.RawLogZstd != "" {
+		t.Fatal("an empty raw line was compressed")
+	}
+}
+
+func TestRestoreChangedDictionary(t *testing.T) {
+	dir := t.TempDir()
+	rc := newRawCompressor(dir)
+	var entry archivedEntry
+	for i := 0; i < dictionarySamples; i++ {
+		var err error
+		if entry, err = rc.encode(&collector.SystemLog{Source: collector.SourceNginx, RawLog: nginxLine(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, dictionaryPrefix+entry.dictionary()+dictionarySuffix)
+	if err := os.WriteFile(path, []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newEntryDecoder(dir).decode(data); err == nil {
+		t.Fatal("a changed dictionary was used")
+	}
+}
+
+func TestRestoreDeflateLine(t *testing.T) {
+	dir := t.TempDir()
+	dict := []byte(nginxLine(0) + "\n")
+	id := dictionaryID(dict)
+	if err := os.WriteFile(filepath.Join(dir, dictionaryPrefix+id+dictionarySuffix), dict, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, err := flate.NewWriterDict(&buf, flate.BestCompression, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(nginxLine(1)))
+	w.Close()
+
+	// An entry of an archive written before lines were compressed with zstd
+	data := fmt.Sprintf(`{"message":"request","raw_log_deflate":"%s:%s"}`, id, base64.RawStdEncoding.EncodeToString(buf.Bytes()))
+	log, err := newEntryDecoder(dir).decode([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.RawLog != nginxLine(1) || log.Message != "request" {
+		t.Fatalf("restored as %+v", log)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build linux
+
+package archive
+
+import "syscall"
+
+// diskSpace returns the size of the file system holding dir and the space
+// available to unprivileged users
+func diskSpace(dir string) (total, free uint64, err error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err != nil {
+		return 0, 0, err
+	}
+	return fs.Blocks * uint64(fs.Bsize), fs.Bavail * uint64(fs.Bsize), nil
+}
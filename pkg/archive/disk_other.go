@@ -0,0 +1,10 @@
+//go:build !linux
+
+package archive
+
+import "errors"
+
+// diskSpace is not implemented on this platform
+func diskSpace(dir string) (total, free uint64, err error) {
+	return 0, 0, errors.New("file system space is not available on this platform")
+}
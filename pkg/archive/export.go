@@ -0,0 +1,318 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TransferReport is the outcome of Export and Import
+type TransferReport struct {
+	Manifests    int   `json:"manifests"`
+	Objects      int   `json:"objects"`
+	Dictionaries int   `json:"dictionaries"`
+	Summaries    int   `json:"summaries,omitempty"`
+	Bytes        int64 `json:"bytes"`
+	// Skipped counts the files Import found already present, unchanged
+	Skipped int `json:"skipped,omitempty"`
+}
+
+// Export writes the sealed contents of an archive directory to w as a
+// gzipped tar: the manifests and summaries with their signatures first,
+// then the dictionaries and the objects they list with their checksums. Objects are
+// checked against their manifest as they are read, so a damaged archive
+// fails the export rather than being carried over. Objects being written
+// and unlisted files are left out.
+func Export(dir string, w io.Writer) (*TransferReport, error) {
+	manifests, err := filepath.Glob(filepath.Join(dir, manifestPrefix+"*"+manifestSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(manifests)
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	report := &TransferReport{}
+	var objects []Object
+	dictionaries := make(map[string]bool)
+	for _, path := range manifests {
+		manifest, _, err := readManifest(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := addFile(tw, path, report); err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(path + signatureSuffix); err == nil {
+			if err := addFile(tw, path+signatureSuffix, report); err != nil {
+				return nil, err
+			}
+		}
+		report.Manifests++
+		for _, object := range manifest.Objects {
+			objects = append(objects, object)
+			for _, id := range object.Dictionaries {
+				dictionaries[id] = true
+			}
+		}
+	}
+
+	summaries, err := filepath.Glob(filepath.Join(dir, summaryPrefix+"*"+summarySuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(summaries)
+	for _, path := range summaries {
+		if err := addFile(tw, path, report); err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(path + signatureSuffix); err == nil {
+			if err := addFile(tw, path+signatureSuffix, report); err != nil {
+				return nil, err
+			}
+		}
+		report.Summaries++
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, holdsFile)); err == nil {
+		for _, name := range []string{holdsFile, holdsFile + signatureSuffix} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				if err := addFile(tw, filepath.Join(dir, name), report); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(dictionaries))
+	for id := range dictionaries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if _, err := readDictionary(dir, id); err != nil {
+			return nil, err
+		}
+		if err := addFile(tw, filepath.Join(dir, dictionaryPrefix+id+dictionarySuffix), report); err != nil {
+			return nil, err
+		}
+		report.Dictionaries++
+	}
+
+	for _, object := range objects {
+		path := filepath.Join(dir, filepath.Base(object.Name))
+		sum, err := addObject(tw, path, object.Size, report)
+		if err != nil {
+			return nil, err
+		}
+		if sum != object.SHA256 {
+			return nil, fmt.Errorf("%s: SHA-256 does not match the manifest", object.Name)
+		}
+		if _, err := os.Stat(path + checksumSuffix); err == nil {
+			if err := addFile(tw, path+checksumSuffix, report); err != nil {
+				return nil, err
+			}
+		}
+		report.Objects++
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Snapshot seals the open object, so every entry written so far is
+// included, and exports the archive. Compaction waits meanwhile, so the
+// objects listed stay in place.
+func (o *Output) Snapshot(w io.Writer) (*TransferReport, error) {
+	o.compactMu.Lock()
+	defer o.compactMu.Unlock()
+	o.mu.Lock()
+	err := o.sealLocked()
+	o.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return Export(o.config.Dir, w)
+}
+
+// addFile adds a small file to the tar
+func addFile(tw *tar.Writer, path string, report *TransferReport) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: filepath.Base(path), Mode: 0640, Size: int64(len(data)), ModTime: time.Now()}
+	if info, err := os.Stat(path); err == nil {
+		header.ModTime = info.ModTime()
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	report.Bytes += int64(len(data))
+	return err
+}
+
+// addObject streams an object of the given size to the tar and returns
+// its SHA-256
+func addObject(tw *tar.Writer, path string, size int64, report *TransferReport) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.Size() != size {
+		return "", fmt.Errorf("%s: size is %d, manifest says %d", filepath.Base(path), info.Size(), size)
+	}
+	header := &tar.Header{Name: filepath.Base(path), Mode: 0640, Size: size, ModTime: info.ModTime()}
+	if err := tw.WriteHeader(header); err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tw, hash), file); err != nil {
+		return "", err
+	}
+	report.Bytes += size
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// isArchiveFile reports whether name is a file Export writes
+func isArchiveFile(name string) bool {
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return false
+	}
+	switch {
+	case name == holdsFile || name == holdsFile+signatureSuffix:
+		return true
+	case strings.HasPrefix(name, manifestPrefix):
+		return strings.HasSuffix(name, manifestSuffix) || strings.HasSuffix(name, manifestSuffix+signatureSuffix)
+	case strings.HasPrefix(name, dictionaryPrefix):
+		return strings.HasSuffix(name, dictionarySuffix)
+	case strings.HasPrefix(name, summaryPrefix):
+		return strings.HasSuffix(name, summarySuffix) || strings.HasSuffix(name, summarySuffix+signatureSuffix)
+	}
+	return strings.HasSuffix(name, objectSuffix) || strings.HasSuffix(name, objectSuffix+checksumSuffix)
+}
+
+// Import restores an export into dir. The files are unpacked next to it
+// and verified like Verify does (signatures too when key is given) before
+// any is moved in, so a damaged or tampered export changes nothing. Files
+// already in dir must be identical to the exported ones: importing the same
+// export twice is harmless, but a manifest of the same day from another
+// archive is refused rather than overwritten.
+func Import(r io.Reader, dir string, key ed25519.PublicKey) (*TransferReport, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory %s: %w", dir, err)
+	}
+	staging, err := os.MkdirTemp(dir, ".import-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid export: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	report := &TransferReport{}
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid export: %w", err)
+		}
+		// Tolerate archives packed by hand with "tar -C dir ."
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		name := strings.TrimPrefix(header.Name, "./")
+		if header.Typeflag != tar.TypeReg || !isArchiveFile(name) {
+			return nil, fmt.Errorf("invalid export: unexpected entry %q", header.Name)
+		}
+		file, err := os.OpenFile(filepath.Join(staging, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+		if err != nil {
+			return nil, fmt.Errorf("invalid export: %w", err)
+		}
+		n, err := io.Copy(file, tr)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to unpack %s: %w", name, err)
+		}
+		report.Bytes += n
+		names = append(names, name)
+		switch {
+		case strings.HasPrefix(name, summaryPrefix):
+			if !strings.HasSuffix(name, signatureSuffix) {
+				report.Summaries++
+			}
+		case strings.HasSuffix(name, manifestSuffix):
+			report.Manifests++
+		case strings.HasSuffix(name, dictionarySuffix):
+			report.Dictionaries++
+		case strings.HasSuffix(name, objectSuffix):
+			report.Objects++
+		}
+	}
+
+	verified, err := Verify(staging, key)
+	if err != nil {
+		return nil, err
+	}
+	if !verified.OK() {
+		return nil, fmt.Errorf("export failed verification: %s", strings.Join(verified.Problems, "; "))
+	}
+
+	var moves []string
+	for _, name := range names {
+		existing, _, err := hashFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			moves = append(moves, name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		imported, _, err := hashFile(filepath.Join(staging, name))
+		if err != nil {
+			return nil, err
+		}
+		if existing != imported {
+			return nil, fmt.Errorf("%s already exists in %s with different content", name, dir)
+		}
+		report.Skipped++
+	}
+	// Manifests last, so they never list objects that aren't in place yet
+	sort.SliceStable(moves, func(i, j int) bool {
+		return !strings.HasPrefix(moves[i], manifestPrefix) && strings.HasPrefix(moves[j], manifestPrefix)
+	})
+	for _, name := range moves {
+		if err := os.Rename(filepath.Join(staging, name), filepath.Join(dir, name)); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
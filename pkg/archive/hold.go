@@ -0,0 +1,254 @@
+package archive
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// holdsFile lists the holds, signed like the manifests
+const holdsFile = "holds.json"
+
+// Hold errors
+var (
+	ErrHoldNotFound = errors.New("hold not found")
+	// ErrHoldLocked is returned when releasing or shortening a locked hold
+	// before it expires
+	ErrHoldLocked = errors.New("hold is locked until it expires")
+	// ErrInvalidHold wraps the rejections of a hold's fields
+	ErrInvalidHold = errors.New("invalid hold")
+)
+
+// Hold keeps the objects holding entries of a time range immutable:
+// compaction neither merges, rewrites nor expires them while it is active.
+// A hold without Until lasts until released. A locked hold (compliance
+// mode) can't be released or shortened before Until, only extended; others
+// (legal holds) can be released any time.
+type Hold struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+	// From and To limit the hold to objects with entries in that range;
+	// nil is an open end
+	From      *time.Time `json:"from,omitempty"`
+	To        *time.Time `json:"to,omitempty"`
+	Until     *time.Time `json:"until,omitempty"`
+	Locked    bool       `json:"locked"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// HoldState is a hold as listed, with whether it is in force
+type HoldState struct {
+	Hold
+	Active bool `json:"active"`
+}
+
+// active reports whether the hold is in force at now
+func (h *Hold) active(now time.Time) bool {
+	return h.Until == nil || now.Before(*h.Until)
+}
+
+// covers reports whether an object has entries in the hold's range
+func (h *Hold) covers(object Object) bool {
+	if h.From != nil && object.LastTimestamp != nil && object.LastTimestamp.Before(*h.From) {
+		return false
+	}
+	if h.To != nil && object.FirstTimestamp != nil && object.FirstTimestamp.After(*h.To) {
+		return false
+	}
+	return true
+}
+
+// holdsDocument is the content of the holds file
+type holdsDocument struct {
+	Version int     `json:"version"`
+	KeyID   string  `json:"key_id,omitempty"`
+	Holds   []*Hold `json:"holds"`
+}
+
+// loadHolds reads the holds file of the archive, if any
+func loadHolds(dir string) ([]*Hold, error) {
+	data, err := os.ReadFile(filepath.Join(dir, holdsFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc holdsDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", holdsFile, err)
+	}
+	return doc.Holds, nil
+}
+
+// saveHoldsLocked writes the holds file. o.holdsMu must be held.
+func (o *Output) saveHoldsLocked() error {
+	doc := holdsDocument{Version: 1, Holds: o.holds}
+	if o.config.SigningKey != nil {
+		doc.KeyID = KeyID(o.config.SigningKey.Public().(ed25519.PublicKey))
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return o.writeSigned(filepath.Join(o.config.Dir, holdsFile), append(data, '\n'))
+}
+
+// held reports whether an object must not be changed or removed at now:
+// it was sealed less than ImmutableFor ago or an active hold covers it
+func (o *Output) held(object Object, now time.Time) bool {
+	if o.config.ImmutableFor > 0 && now.Before(object.SealedAt.Add(o.config.ImmutableFor)) {
+		return true
+	}
+	o.holdsMu.Lock()
+	defer o.holdsMu.Unlock()
+	for _, hold := range o.holds {
+		if hold.active(now) && hold.covers(object) {
+			return true
+		}
+	}
+	return false
+}
+
+// Holds returns the holds, active ones first
+func (o *Output) Holds() []HoldState {
+	now := time.Now()
+	o.holdsMu.Lock()
+	holds := make([]HoldState, 0, len(o.holds))
+	for _, hold := range o.holds {
+		holds = append(holds, HoldState{Hold: *hold, Active: hold.active(now)})
+	}
+	o.holdsMu.Unlock()
+	sort.SliceStable(holds, func(i, j int) bool { return holds[i].Active && !holds[j].Active })
+	return holds
+}
+
+// PlaceHold adds a hold. It waits for a running compaction, so the objects
+// the hold covers are in place once it returns.
+func (o *Output) PlaceHold(hold Hold) (HoldState, error) {
+	now := time.Now().UTC()
+	switch {
+	case hold.Reason == "":
+		return HoldState{}, fmt.Errorf("%w: reason is required", ErrInvalidHold)
+	case hold.Locked && hold.Until == nil:
+		return HoldState{}, fmt.Errorf("%w: a locked hold needs an expiry (until)", ErrInvalidHold)
+	case hold.Until != nil && !hold.Until.After(now):
+		return HoldState{}, fmt.Errorf("%w: until must be in the future", ErrInvalidHold)
+	case hold.From != nil && hold.To != nil && hold.To.Before(*hold.From):
+		return HoldState{}, fmt.Errorf("%w: to must not be before from", ErrInvalidHold)
+	}
+	var id [8]byte
+	rand.Read(id[:])
+	hold.ID, hold.CreatedAt = "hold_"+hex.EncodeToString(id[:]), now
+
+	o.compactMu.Lock()
+	defer o.compactMu.Unlock()
+	o.holdsMu.Lock()
+	defer o.holdsMu.Unlock()
+	o.holds = append(o.holds, &hold)
+	if err := o.saveHoldsLocked(); err != nil {
+		o.holds = o.holds[:len(o.holds)-1]
+		return HoldState{}, fmt.Errorf("failed to save holds: %w", err)
+	}
+	o.logHold("archive_hold_placed", "Placed", hold)
+	return HoldState{Hold: hold, Active: true}, nil
+}
+
+// ExtendHold moves the expiry of a hold. Locked holds can't be shortened
+// or made to expire before it was due; until nil makes a hold indefinite.
+// As an expired hold may come back in force, it waits for a running
+// compaction like PlaceHold.
+func (o *Output) ExtendHold(id string, until *time.Time) (HoldState, error) {
+	now := time.Now()
+	if until != nil && !until.After(now) {
+		return HoldState{}, fmt.Errorf("%w: until must be in the future", ErrInvalidHold)
+	}
+	o.compactMu.Lock()
+	defer o.compactMu.Unlock()
+	o.holdsMu.Lock()
+	defer o.holdsMu.Unlock()
+	hold := o.findHoldLocked(id)
+	if hold == nil {
+		return HoldState{}, ErrHoldNotFound
+	}
+	if hold.Locked && until == nil {
+		return HoldState{}, fmt.Errorf("%w: a locked hold needs an expiry (until)", ErrInvalidHold)
+	}
+	if hold.Locked && hold.active(now) && until.Before(*hold.Until) {
+		return HoldState{}, ErrHoldLocked
+	}
+	previous := hold.Until
+	hold.Until = until
+	if err := o.saveHoldsLocked(); err != nil {
+		hold.Until = previous
+		return HoldState{}, fmt.Errorf("failed to save holds: %w", err)
+	}
+	o.logHold("archive_hold_changed", "Changed the expiry of", *hold)
+	return HoldState{Hold: *hold, Active: hold.active(now)}, nil
+}
+
+// ReleaseHold removes a hold; locked holds only once they expired
+func (o *Output) ReleaseHold(id string) error {
+	o.holdsMu.Lock()
+	defer o.holdsMu.Unlock()
+	hold := o.findHoldLocked(id)
+	if hold == nil {
+		return ErrHoldNotFound
+	}
+	if hold.Locked && hold.active(time.Now()) {
+		return ErrHoldLocked
+	}
+	holds := o.holds
+	o.holds = make([]*Hold, 0, len(holds)-1)
+	for _, h := range holds {
+		if h != hold {
+			o.holds = append(o.holds, h)
+		}
+	}
+	if err := o.saveHoldsLocked(); err != nil {
+		o.holds = holds
+		return fmt.Errorf("failed to save holds: %w", err)
+	}
+	o.logHold("archive_hold_released", "Released", *hold)
+	return nil
+}
+
+func (o *Output) findHoldLocked(id string) *Hold {
+	for _, hold := range o.holds {
+		if hold.ID == id {
+			return hold
+		}
+	}
+	return nil
+}
+
+func (o *Output) logHold(eventType, verb string, hold Hold) {
+	details := map[string]interface{}{
+		"hold_id": hold.ID,
+		"reason":  hold.Reason,
+		"locked":  hold.Locked,
+	}
+	if hold.From != nil {
+		details["from"] = hold.From
+	}
+	if hold.To != nil {
+		details["to"] = hold.To
+	}
+	if hold.Until != nil {
+		details["until"] = hold.Until
+	}
+	o.auditLogger.LogEvent(audit.AuditEvent{
+		EventType: audit.EventType(eventType),
+		Message:   fmt.Sprintf("%s archive hold %s: %s", verb, hold.ID, hold.Reason),
+		Details:   details,
+	})
+}
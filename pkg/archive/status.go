@@ -0,0 +1,299 @@
+package archive
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Status levels
+const (
+	StatusOK       = "ok"
+	StatusWarning  = "warning"
+	StatusCritical = "critical"
+)
+
+// Thresholds of the status: share of the capacity used, and days until the
+// archive is full at the recent ingest rate
+const (
+	warnUsedPercent     = 80
+	criticalUsedPercent = 95
+	warnDaysLeft        = 7
+	criticalDaysLeft    = 1
+)
+
+// Status describes the contents of the archive and how long its space lasts
+type Status struct {
+	Status string `json:"status"`
+	// Reasons explain a status other than ok
+	Reasons []string `json:"reasons,omitempty"`
+
+	Dir          string `json:"dir"`
+	Manifests    int    `json:"manifests"`
+	Objects      int    `json:"objects"`
+	Dictionaries int    `json:"dictionaries"`
+	Entries      int64  `json:"entries"`
+	// Summaries counts the days of downsampled entries kept as hourly
+	// summaries, from OldestSummary on
+	Summaries int `json:"summaries"`
+	// HeldObjects are immutable for now, by ImmutableFor or a hold
+	HeldObjects   int        `json:"held_objects"`
+	OldestSummary *time.Time `json:"oldest_summary,omitempty"`
+	// ObjectBytes is the size of the sealed objects, DiskBytes that of every
+	// file in the directory, the open object and dictionaries included
+	ObjectBytes int64       `json:"object_bytes"`
+	DiskBytes   int64       `json:"disk_bytes"`
+	OldestEntry *time.Time  `json:"oldest_entry,omitempty"`
+	NewestEntry *time.Time  `json:"newest_entry,omitempty"`
+	OpenObject  *OpenObject `json:"open_object,omitempty"`
+
+	// Capacity is nil when neither a capacity is configured nor the free
+	// space of the file system known
+	Capacity   *Capacity        `json:"capacity,omitempty"`
+	Compaction CompactionStatus `json:"compaction"`
+
+	// IngestBytesPerDay is the rate of the last 24 hours, once 10 minutes
+	// were observed
+	IngestBytesPerDay *float64 `json:"ingest_bytes_per_day,omitempty"`
+	// DaysUntilFull projects when the capacity runs out at that rate. With
+	// a retention the archive stops growing at SteadyStateBytes; it is left
+	// out when that fits.
+	DaysUntilFull    *float64 `json:"days_until_full,omitempty"`
+	SteadyStateBytes int64    `json:"steady_state_bytes,omitempty"`
+}
+
+// OpenObject is the object entries are written to
+type OpenObject struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Entries  int       `json:"entries"`
+	OpenedAt time.Time `json:"opened_at"`
+}
+
+// Capacity is the space of the archive
+type Capacity struct {
+	// LimitBytes is the configured capacity, 0 when there is none
+	LimitBytes int64 `json:"limit_bytes"`
+	// AvailableBytes is what the archive can still grow by: what is left of
+	// the limit, at most the free space of the file system
+	AvailableBytes int64   `json:"available_bytes"`
+	UsedPercent    float64 `json:"used_percent"`
+
+	FilesystemTotalBytes uint64 `json:"filesystem_total_bytes,omitempty"`
+	FilesystemFreeBytes  uint64 `json:"filesystem_free_bytes,omitempty"`
+}
+
+// CompactionStatus is the work waiting for compaction
+type CompactionStatus struct {
+	Interval  string `json:"interval"`
+	Retention string `json:"retention"`
+	// PendingObjects are the small objects the next pass merges and
+	// ExpiringObjects those holding entries older than the retention;
+	// PendingBytes is their size
+	PendingObjects  int            `json:"pending_objects"`
+	ExpiringObjects int            `json:"expiring_objects"`
+	PendingBytes    int64          `json:"pending_bytes"`
+	LastRun         *time.Time     `json:"last_run,omitempty"`
+	LastReport      *CompactReport `json:"last_report,omitempty"`
+}
+
+// Status reads the manifests and the directory to report the archive's
+// contents, capacity and compaction backlog
+func (o *Output) Status() (*Status, error) {
+	status := &Status{Status: StatusOK, Dir: o.config.Dir}
+	status.Compaction.Interval = o.config.CompactInterval.String()
+	status.Compaction.Retention = o.config.Retention.String()
+
+	manifests, err := filepath.Glob(filepath.Join(o.config.Dir, manifestPrefix+"*"+manifestSuffix))
+	if err != nil {
+		return nil, err
+	}
+	cutoff, now := o.cutoff(), time.Now()
+	for _, path := range manifests {
+		manifest, _, err := readManifest(path)
+		if err != nil {
+			return nil, err
+		}
+		status.Manifests++
+		for _, object := range manifest.Objects {
+			status.Objects++
+			status.Entries += int64(object.Entries)
+			status.ObjectBytes += object.Size
+			status.include(object)
+			if o.held(object, now) {
+				status.HeldObjects++
+			}
+		}
+		for _, group := range o.plan(manifest.Objects, cutoff) {
+			for _, object := range group {
+				if len(group) > 1 {
+					status.Compaction.PendingObjects++
+				} else {
+					status.Compaction.ExpiringObjects++
+				}
+				status.Compaction.PendingBytes += object.Size
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(o.config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		status.DiskBytes += info.Size()
+		switch name := entry.Name(); {
+		case strings.HasPrefix(name, dictionaryPrefix) && strings.HasSuffix(name, dictionarySuffix):
+			status.Dictionaries++
+		case strings.HasPrefix(name, summaryPrefix) && strings.HasSuffix(name, summarySuffix):
+			status.Summaries++
+			day, err := summaryDay(name)
+			if err == nil && (status.OldestSummary == nil || day.Before(*status.OldestSummary)) {
+				status.OldestSummary = &day
+			}
+		}
+	}
+
+	o.mu.Lock()
+	if o.file != nil {
+		status.OpenObject = &OpenObject{Name: o.current.Name, Size: o.current.Size, Entries: o.current.Entries, OpenedAt: o.opened}
+		status.Entries += int64(o.current.Entries)
+		status.include(o.current)
+	}
+	rate, rateKnown := o.rate.perDay(now)
+	if !o.lastCompaction.IsZero() {
+		last := o.lastCompaction
+		status.Compaction.LastRun, status.Compaction.LastReport = &last, o.lastCompactReport
+	}
+	o.mu.Unlock()
+
+	status.Capacity = o.capacity(status.DiskBytes)
+	if rateKnown {
+		status.IngestBytesPerDay = &rate
+	}
+	if status.Capacity != nil && rateKnown && rate > 0 {
+		fits := false
+		if o.config.Retention > 0 {
+			status.SteadyStateBytes = int64(rate * o.config.Retention.Hours() / 24)
+			fits = status.SteadyStateBytes <= status.DiskBytes+status.Capacity.AvailableBytes
+		}
+		if !fits {
+			days := float64(status.Capacity.AvailableBytes) / rate
+			status.DaysUntilFull = &days
+		}
+	}
+	status.assess()
+	return status, nil
+}
+
+// include widens the time range of the status to that of object
+func (s *Status) include(object Object) {
+	if object.FirstTimestamp != nil && (s.OldestEntry == nil || object.FirstTimestamp.Before(*s.OldestEntry)) {
+		first := *object.FirstTimestamp
+		s.OldestEntry = &first
+	}
+	if object.LastTimestamp != nil && (s.NewestEntry == nil || object.LastTimestamp.After(*s.NewestEntry)) {
+		last := *object.LastTimestamp
+		s.NewestEntry = &last
+	}
+}
+
+// capacity combines the configured capacity with the file system's space
+func (o *Output) capacity(used int64) *Capacity {
+	capacity := &Capacity{LimitBytes: o.config.Capacity}
+	available := int64(-1)
+	if o.config.Capacity > 0 {
+		available = max(o.config.Capacity-used, 0)
+	}
+	if total, free, err := diskSpace(o.config.Dir); err == nil {
+		capacity.FilesystemTotalBytes, capacity.FilesystemFreeBytes = total, free
+		if available < 0 || int64(free) < available {
+			available = int64(free)
+		}
+	}
+	if available < 0 {
+		return nil
+	}
+	capacity.AvailableBytes = available
+	if used+available > 0 {
+		capacity.UsedPercent = float64(used) * 100 / float64(used+available)
+	}
+	return capacity
+}
+
+// assess sets the status level from the capacity and projection
+func (s *Status) assess() {
+	raise := func(level, reason string) {
+		if level == StatusCritical || s.Status == StatusOK {
+			s.Status = level
+		}
+		s.Reasons = append(s.Reasons, reason)
+	}
+	if s.Capacity != nil {
+		switch used := s.Capacity.UsedPercent; {
+		case used >= criticalUsedPercent:
+			raise(StatusCritical, fmt.Sprintf("%.1f%% of the capacity is used", used))
+		case used >= warnUsedPercent:
+			raise(StatusWarning, fmt.Sprintf("%.1f%% of the capacity is used", used))
+		}
+	}
+	if s.DaysUntilFull != nil {
+		switch days := *s.DaysUntilFull; {
+		case days < criticalDaysLeft:
+			raise(StatusCritical, fmt.Sprintf("full in %.1f hours at the recent ingest rate", days*24))
+		case days < warnDaysLeft:
+			raise(StatusWarning, fmt.Sprintf("full in %.1f days at the recent ingest rate", days))
+		}
+	}
+}
+
+// Ingest rate window
+const (
+	rateBuckets   = 24 // hours
+	minRateWindow = 10 * time.Minute
+)
+
+// ingestRate counts the bytes written per hour over the last day
+type ingestRate struct {
+	started time.Time
+	hours   [rateBuckets]int64
+	hour    int64 // Unix hour of the newest bucket
+}
+
+func (r *ingestRate) add(now time.Time, n int64) {
+	hour := now.Unix() / 3600
+	r.advance(hour)
+	r.hours[hour%rateBuckets] += n
+}
+
+// advance clears the buckets of the hours passed since the newest one
+func (r *ingestRate) advance(hour int64) {
+	for h := max(r.hour+1, hour-rateBuckets+1); h <= hour; h++ {
+		r.hours[h%rateBuckets] = 0
+	}
+	r.hour = max(r.hour, hour)
+}
+
+// perDay returns the bytes written per day over the hours kept, or false
+// until minRateWindow was observed
+func (r *ingestRate) perDay(now time.Time) (float64, bool) {
+	hour := now.Unix() / 3600
+	r.advance(hour)
+	// The buckets span the past 23 hours and the current one so far
+	kept := (rateBuckets-1)*time.Hour + now.Sub(time.Unix(hour*3600, 0))
+	window := min(now.Sub(r.started), kept)
+	if window < minRateWindow {
+		return 0, false
+	}
+	var total int64
+	for _, n := range r.hours {
+		total += n
+	}
+	return float64(total) / window.Hours() * 24, true
+}
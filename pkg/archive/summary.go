@@ -0,0 +1,329 @@
+package archive
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+const (
+	summaryPrefix = "summary-"
+	summarySuffix = ".json"
+
+	// maxTemplates is the number of templates a summary hour lists, the
+	// most frequent; maxHourTemplates bounds those counted while
+	// summarizing
+	maxTemplates     = 20
+	maxHourTemplates = 1000
+	// maxTemplateLength truncates long templates
+	maxTemplateLength = 200
+)
+
+// Summary holds the hourly aggregates of one day's downsampled entries. It
+// is written as "summary-2006-01-02.json", signed like the manifests.
+type Summary struct {
+	Version int    `json:"version"`
+	Day     string `json:"day"`
+	KeyID   string `json:"key_id,omitempty"`
+	// Hours are ordered by hour, then source
+	Hours []HourSummary `json:"hours"`
+}
+
+// HourSummary aggregates the entries of one source type in one hour
+type HourSummary struct {
+	Hour    time.Time        `json:"hour"`
+	Source  string           `json:"source"`
+	Entries int64            `json:"entries"`
+	Levels  map[string]int64 `json:"levels"`
+	// Templates are the most frequent messages with the parts holding
+	// digits replaced by <*>; OtherTemplates counts the entries of the
+	// rest
+	Templates      []TemplateCount `json:"templates,omitempty"`
+	OtherTemplates int64           `json:"other_templates,omitempty"`
+	// Exemplars keep one entry per level
+	Exemplars []*collector.SystemLog `json:"exemplars,omitempty"`
+}
+
+// TemplateCount is a message template and its number of entries
+type TemplateCount struct {
+	Template string `json:"template"`
+	Count    int64  `json:"count"`
+}
+
+// entryTemplate reduces the message of an entry to its template: the
+// segments of tokens (split at / and =) holding digits, such as counts,
+// IDs, addresses and times, become <*>. Web access entries have no message
+// and are templated from their method, path and status.
+func entryTemplate(log *collector.SystemLog) string {
+	text := log.Message
+	if text == "" && log.Method != "" {
+		text = fmt.Sprintf("%s %s %d", log.Method, log.Path, log.StatusCode)
+	}
+	fields := strings.Fields(text)
+	for i, field := range fields {
+		if !strings.ContainsAny(field, "0123456789") {
+			continue
+		}
+		segments := strings.FieldsFunc(field, func(r rune) bool { return r == '/' || r == '=' })
+		for _, segment := range segments {
+			if strings.ContainsAny(segment, "0123456789") {
+				field = strings.Replace(field, segment, "<*>", 1)
+			}
+		}
+		fields[i] = field
+	}
+	template := strings.Join(fields, " ")
+	if len(template) > maxTemplateLength {
+		template = template[:maxTemplateLength]
+		for !utf8.ValidString(template) {
+			template = template[:len(template)-1]
+		}
+	}
+	return template
+}
+
+// hourKey identifies a summary hour
+type hourKey struct {
+	hour   int64 // Unix seconds
+	source string
+}
+
+// hourAggregate is a summary hour being built
+type hourAggregate struct {
+	entries        int64
+	levels         map[string]int64
+	templates      map[string]int64
+	otherTemplates int64
+	exemplars      map[string]*collector.SystemLog
+}
+
+func newHourAggregate() *hourAggregate {
+	return &hourAggregate{
+		levels:    make(map[string]int64),
+		templates: make(map[string]int64),
+		exemplars: make(map[string]*collector.SystemLog),
+	}
+}
+
+func (a *hourAggregate) addTemplate(template string, count int64) {
+	if _, ok := a.templates[template]; ok || len(a.templates) < maxHourTemplates {
+		a.templates[template] += count
+	} else {
+		a.otherTemplates += count
+	}
+}
+
+// downsampler collects the summaries of the entries a compaction drops
+type downsampler struct {
+	decoder *entryDecoder
+	days    map[string]map[hourKey]*hourAggregate
+	entries int
+}
+
+func newDownsampler(dir string) *downsampler {
+	return &downsampler{decoder: newEntryDecoder(dir), days: make(map[string]map[hourKey]*hourAggregate)}
+}
+
+// add counts an archived line in its hour
+func (d *downsampler) add(line []byte) error {
+	log, err := d.decoder.decode(line)
+	if err != nil {
+		return err
+	}
+	timestamp := log.Timestamp.UTC()
+	day := timestamp.Format(manifestDay)
+	hours := d.days[day]
+	if hours == nil {
+		hours = make(map[hourKey]*hourAggregate)
+		d.days[day] = hours
+	}
+	key := hourKey{hour: timestamp.Truncate(time.Hour).Unix(), source: string(log.Source)}
+	agg := hours[key]
+	if agg == nil {
+		agg = newHourAggregate()
+		hours[key] = agg
+	}
+	agg.entries++
+	agg.levels[string(log.Level)]++
+	agg.addTemplate(entryTemplate(log), 1)
+	if _, ok := agg.exemplars[string(log.Level)]; !ok {
+		agg.exemplars[string(log.Level)] = log
+	}
+	d.entries++
+	return nil
+}
+
+// writeSummaries merges the collected hours into the summary files of their
+// days and starts collecting anew
+func (o *Output) writeSummaries(d *downsampler) error {
+	for day, hours := range d.days {
+		path := filepath.Join(o.config.Dir, summaryPrefix+day+summarySuffix)
+		existing, err := ReadSummary(path)
+		switch {
+		case os.IsNotExist(err):
+			existing = &Summary{}
+		case err != nil:
+			return err
+		}
+		for _, hour := range existing.Hours {
+			key := hourKey{hour: hour.Hour.Unix(), source: hour.Source}
+			agg := hours[key]
+			if agg == nil {
+				agg = newHourAggregate()
+				hours[key] = agg
+			}
+			agg.entries += hour.Entries
+			for level, n := range hour.Levels {
+				agg.levels[level] += n
+			}
+			for _, t := range hour.Templates {
+				agg.addTemplate(t.Template, t.Count)
+			}
+			agg.otherTemplates += hour.OtherTemplates
+			// Earlier exemplars win, they were summarized first
+			for _, exemplar := range hour.Exemplars {
+				agg.exemplars[string(exemplar.Level)] = exemplar
+			}
+		}
+
+		summary := &Summary{Version: 1, Day: day}
+		for key, agg := range hours {
+			summary.Hours = append(summary.Hours, agg.summary(key))
+		}
+		sort.Slice(summary.Hours, func(i, j int) bool {
+			a, b := summary.Hours[i], summary.Hours[j]
+			if !a.Hour.Equal(b.Hour) {
+				return a.Hour.Before(b.Hour)
+			}
+			return a.Source < b.Source
+		})
+		if err := o.writeSummary(path, summary); err != nil {
+			return err
+		}
+	}
+	d.days = make(map[string]map[hourKey]*hourAggregate)
+	return nil
+}
+
+// summary returns the hour with its most frequent templates
+func (a *hourAggregate) summary(key hourKey) HourSummary {
+	hour := HourSummary{
+		Hour:           time.Unix(key.hour, 0).UTC(),
+		Source:         key.source,
+		Entries:        a.entries,
+		Levels:         a.levels,
+		OtherTemplates: a.otherTemplates,
+	}
+	for template, count := range a.templates {
+		hour.Templates = append(hour.Templates, TemplateCount{Template: template, Count: count})
+	}
+	sort.Slice(hour.Templates, func(i, j int) bool {
+		if hour.Templates[i].Count != hour.Templates[j].Count {
+			return hour.Templates[i].Count > hour.Templates[j].Count
+		}
+		return hour.Templates[i].Template < hour.Templates[j].Template
+	})
+	if len(hour.Templates) > maxTemplates {
+		for _, t := range hour.Templates[maxTemplates:] {
+			hour.OtherTemplates += t.Count
+		}
+		hour.Templates = hour.Templates[:maxTemplates]
+	}
+	levels := make([]string, 0, len(a.exemplars))
+	for level := range a.exemplars {
+		levels = append(levels, level)
+	}
+	sort.Strings(levels)
+	for _, level := range levels {
+		hour.Exemplars = append(hour.Exemplars, a.exemplars[level])
+	}
+	return hour
+}
+
+func (o *Output) writeSummary(path string, summary *Summary) error {
+	if o.config.SigningKey != nil {
+		summary.KeyID = KeyID(o.config.SigningKey.Public().(ed25519.PublicKey))
+	}
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return o.writeSigned(path, append(data, '\n'))
+}
+
+// summaryDay returns the day of a summary file name
+func summaryDay(name string) (time.Time, error) {
+	return time.Parse(manifestDay, strings.TrimSuffix(strings.TrimPrefix(name, summaryPrefix), summarySuffix))
+}
+
+// ReadSummary reads a summary file
+func ReadSummary(path string) (*Summary, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var summary Summary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return nil, fmt.Errorf("invalid summary %s: %w", filepath.Base(path), err)
+	}
+	return &summary, nil
+}
+
+// expireSummaries removes the summaries of days that ended before cutoff
+// and returns their number
+func (o *Output) expireSummaries(cutoff time.Time) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(o.config.Dir, summaryPrefix+"*"+summarySuffix))
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, path := range paths {
+		day, err := summaryDay(filepath.Base(path))
+		if err != nil || day.Add(24*time.Hour).After(cutoff) {
+			continue
+		}
+		os.Remove(path + signatureSuffix)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Summaries returns the summary hours between from and to (zero times are
+// open ends), of one source type when source is set
+func (o *Output) Summaries(from, to time.Time, source string) ([]HourSummary, error) {
+	paths, err := filepath.Glob(filepath.Join(o.config.Dir, summaryPrefix+"*"+summarySuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	hours := []HourSummary{}
+	for _, path := range paths {
+		day, err := summaryDay(filepath.Base(path))
+		if err != nil || (!from.IsZero() && !day.Add(24*time.Hour).After(from)) || (!to.IsZero() && day.After(to)) {
+			continue
+		}
+		summary, err := ReadSummary(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, hour := range summary.Hours {
+			if (source != "" && hour.Source != source) ||
+				(!from.IsZero() && !hour.Hour.Add(time.Hour).After(from)) || (!to.IsZero() && hour.Hour.After(to)) {
+				continue
+			}
+			hours = append(hours, hour)
+		}
+	}
+	return hours, nil
+}
@@ -0,0 +1,209 @@
+package archive
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LoadSigningKey reads a PEM encoded Ed25519 private key, as created by
+// "openssl genpkey -algorithm ed25519"
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key %s: %w", path, err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not an Ed25519 key", path)
+	}
+	return private, nil
+}
+
+// LoadPublicKey reads a PEM encoded Ed25519 public key, as created by
+// "openssl pkey -pubout"
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %s: %w", path, err)
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an Ed25519 key", path)
+	}
+	return public, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	return block, nil
+}
+
+// KeyID identifies a public key in manifests
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Report is the outcome of Verify
+type Report struct {
+	Manifests int `json:"manifests"`
+	Objects   int `json:"objects"`
+	Summaries int `json:"summaries"`
+	// Problems lists everything that failed verification
+	Problems []string `json:"problems,omitempty"`
+}
+
+// OK reports whether verification found no problems
+func (r *Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *Report) problem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// Verify checks an archive directory: the signatures of every manifest and
+// summary and of the holds (when key is given), and every listed object's size and SHA-256
+// and the content of its dictionaries. Sealed objects not listed in any manifest are reported
+// too. An object still being written
+// (*.partial) is skipped.
+func Verify(dir string, key ed25519.PublicKey) (*Report, error) {
+	manifests, err := filepath.Glob(filepath.Join(dir, manifestPrefix+"*"+manifestSuffix))
+	if err != nil {
+		return nil, err
+	}
+	objects, err := filepath.Glob(filepath.Join(dir, "*"+objectSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(manifests)
+
+	report := &Report{}
+	listed := make(map[string]bool)
+	dictionaries := make(map[string]error)
+	for _, path := range manifests {
+		report.Manifests++
+		name := filepath.Base(path)
+		manifest, data, err := readManifest(path)
+		if err != nil {
+			report.problem("%s: %v", name, err)
+			continue
+		}
+		if key != nil {
+			verifySignature(report, path, data, key)
+		}
+		for _, object := range manifest.Objects {
+			report.Objects++
+			if listed[object.Name] {
+				report.problem("%s: object %s is listed twice", name, object.Name)
+			}
+			listed[object.Name] = true
+			verifyObject(report, dir, object)
+			for _, id := range object.Dictionaries {
+				if _, checked := dictionaries[id]; !checked {
+					_, err := readDictionary(dir, id)
+					dictionaries[id] = err
+					switch {
+					case os.IsNotExist(err):
+						report.problem("%s: dictionary %s is missing", object.Name, id)
+					case err != nil:
+						report.problem("%s: %v", object.Name, err)
+					}
+				}
+			}
+		}
+	}
+	for _, path := range objects {
+		if !listed[filepath.Base(path)] {
+			report.problem("%s is not listed in any manifest", filepath.Base(path))
+		}
+	}
+
+	if key != nil {
+		if data, err := os.ReadFile(filepath.Join(dir, holdsFile)); err == nil {
+			verifySignature(report, filepath.Join(dir, holdsFile), data, key)
+		}
+	}
+
+	summaries, err := filepath.Glob(filepath.Join(dir, summaryPrefix+"*"+summarySuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(summaries)
+	for _, path := range summaries {
+		report.Summaries++
+		if _, err := ReadSummary(path); err != nil {
+			report.problem("%v", err)
+			continue
+		}
+		if key != nil {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				report.problem("%s: %v", filepath.Base(path), err)
+				continue
+			}
+			verifySignature(report, path, data, key)
+		}
+	}
+	return report, nil
+}
+
+func verifySignature(report *Report, path string, data []byte, key ed25519.PublicKey) {
+	name := filepath.Base(path)
+	encoded, err := os.ReadFile(path + signatureSuffix)
+	if err != nil {
+		report.problem("%s: missing signature", name)
+		return
+	}
+	signature, err := hex.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil || !ed25519.Verify(key, data, signature) {
+		report.problem("%s: signature does not match", name)
+	}
+}
+
+func verifyObject(report *Report, dir string, object Object) {
+	path := filepath.Join(dir, filepath.Base(object.Name))
+	sum, size, err := hashFile(path)
+	switch {
+	case os.IsNotExist(err):
+		report.problem("%s: missing", object.Name)
+		return
+	case err != nil:
+		report.problem("%s: %v", object.Name, err)
+		return
+	}
+	if size != object.Size {
+		report.problem("%s: size is %d, manifest says %d", object.Name, size, object.Size)
+	}
+	if sum != object.SHA256 {
+		report.problem("%s: SHA-256 does not match the manifest", object.Name)
+	}
+	if checksum, err := os.ReadFile(path + checksumSuffix); err == nil {
+		if fields := strings.Fields(string(checksum)); len(fields) == 0 || fields[0] != object.SHA256 {
+			report.problem("%s: checksum file does not match the manifest", object.Name)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package arrow
+
+import "encoding/binary"
+
+// A minimal FlatBuffers writer for the Arrow IPC message headers
+// (https://flatbuffers.dev/internals/), so gonder doesn't need the
+// flatbuffers and Arrow libraries for one export format. Objects are laid
+// out front to back: a table comes before the strings, vectors and tables it
+// refers to, which keeps every offset positive as the format requires.
+
+// fbTable is a table to serialize, its fields indexed by their id. Nil
+// fields are left out and read as their default.
+type fbTable []interface{}
+
+// fbTables is a vector of tables
+type fbTables []fbTable
+
+// fbScalar is a scalar field of 1, 2, 4 or 8 bytes
+type fbScalar struct {
+	width int
+	bits  uint64
+}
+
+// fbStructs is a vector of structs of 8-byte members, already encoded
+type fbStructs struct {
+	count int
+	data  []byte
+}
+
+func fbBool(v bool) fbScalar {
+	if v {
+		return fbScalar{1, 1}
+	}
+	return fbScalar{1, 0}
+}
+
+func fbUint8(v uint8) fbScalar { return fbScalar{1, uint64(v)} }
+func fbInt16(v int16) fbScalar { return fbScalar{2, uint64(v)} }
+func fbInt32(v int32) fbScalar { return fbScalar{4, uint64(v)} }
+func fbInt64(v int64) fbScalar { return fbScalar{8, uint64(v)} }
+
+// fbFinish serializes root into a buffer
+func fbFinish(t fbTable) []byte {
+	b := &fbBuilder{buf: make([]byte, 4, 512)}
+	root := b.table(t)
+	binary.LittleEndian.PutUint32(b.buf, uint32(root))
+	return b.buf
+}
+
+type fbBuilder struct {
+	buf []byte
+}
+
+// pad appends zeros until len+extra is a multiple of align
+func (b *fbBuilder) pad(align, extra int) {
+	for (len(b.buf)+extra)%align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+// table writes t preceded by its vtable and returns its position. The
+// table starts 8-byte aligned and its fields are placed largest first, so
+// each is aligned to its size.
+func (b *fbBuilder) table(t fbTable) int {
+	vtableSize := 4 + 2*len(t)
+	b.pad(8, vtableSize)
+	vtable := len(b.buf)
+	start := vtable + vtableSize
+
+	offsets := make([]int, len(t))
+	size := 4 // the vtable offset
+	for _, width := range []int{8, 4, 2, 1} {
+		for id, field := range t {
+			if field == nil {
+				continue
+			}
+			w := 4 // offsets to other objects
+			if scalar, ok := field.(fbScalar); ok {
+				w = scalar.width
+			}
+			if w == width {
+				size = (size + width - 1) / width * width
+				offsets[id] = size
+				size += width
+			}
+		}
+	}
+
+	b.buf = append(b.buf, make([]byte, vtableSize+size)...)
+	binary.LittleEndian.PutUint16(b.buf[vtable:], uint16(vtableSize))
+	binary.LittleEndian.PutUint16(b.buf[vtable+2:], uint16(size))
+	for id, offset := range offsets {
+		binary.LittleEndian.PutUint16(b.buf[vtable+4+2*id:], uint16(offset))
+	}
+	binary.LittleEndian.PutUint32(b.buf[start:], uint32(vtableSize))
+
+	for id, field := range t {
+		at := start + offsets[id]
+		switch field := field.(type) {
+		case nil:
+		case fbScalar:
+			for i := 0; i < field.width; i++ {
+				b.buf[at+i] = byte(field.bits >> (8 * i))
+			}
+		default:
+			pos := b.object(field)
+			binary.LittleEndian.PutUint32(b.buf[at:], uint32(pos-at))
+		}
+	}
+	return start
+}
+
+// object writes a string, table or vector and returns its position
+func (b *fbBuilder) object(v interface{}) int {
+	switch v := v.(type) {
+	case string:
+		b.pad(4, 0)
+		pos := len(b.buf)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(v)))
+		b.buf = append(append(b.buf, v...), 0)
+		return pos
+	case fbTable:
+		return b.table(v)
+	case fbTables:
+		b.pad(4, 0)
+		pos := len(b.buf)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(v)))
+		b.buf = append(b.buf, make([]byte, 4*len(v))...)
+		for i, t := range v {
+			at := pos + 4 + 4*i
+			// Written after the table, which may move b.buf
+			table := b.table(t)
+			binary.LittleEndian.PutUint32(b.buf[at:], uint32(table-at))
+		}
+		return pos
+	case fbStructs:
+		// The structs hold 8-byte members and follow the 4-byte length
+		b.pad(8, 4)
+		pos := len(b.buf)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(v.count))
+		b.buf = append(b.buf, v.data...)
+		return pos
+	}
+	panic("arrow: unsupported flatbuffers value")
+}
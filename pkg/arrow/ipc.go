@@ -0,0 +1,299 @@
+// Package arrow writes log entries in the Apache Arrow IPC streaming format
+// (https://arrow.apache.org/docs/format/Columnar.html#ipc-streaming-format),
+// which pandas, polars and DuckDB read into columns without parsing JSON or
+// CSV.
+package arrow
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// ContentType is the media type of an Arrow IPC stream
+const ContentType = "application/vnd.apache.arrow.stream"
+
+// Message header values of the Arrow format (Schema.fbs and Message.fbs)
+const (
+	metadataV5        = 4
+	headerSchema      = 1
+	headerRecordBatch = 3
+
+	typeInt       = 2
+	typeUtf8      = 5
+	typeTimestamp = 10
+	typeList      = 12
+
+	unitMicrosecond = 2
+)
+
+// continuation starts every message; a zero length after it ends the stream
+const continuation = 0xFFFFFFFF
+
+// Batches are flushed after this many rows or bytes of column data,
+// whichever comes first
+const (
+	batchRows  = 8192
+	batchBytes = 16 << 20
+)
+
+// columnType is the Arrow type of a column
+type columnType int
+
+const (
+	int32Type     columnType = iota
+	utf8Type                 // strings
+	timestampType            // microseconds in UTC
+	utf8ListType             // lists of strings
+)
+
+// field is a column of the schema
+type field struct {
+	name string
+	typ  columnType
+}
+
+// schema returns the flatbuffers Field table of f; every column is nullable
+func (f field) schema() fbTable {
+	// Field: name, nullable, type_type, type, dictionary, children
+	t := fbTable{f.name, fbBool(true), nil, nil, nil, fbTables{}}
+	switch f.typ {
+	case int32Type:
+		t[2], t[3] = fbUint8(typeInt), fbTable{fbInt32(32), fbBool(true)}
+	case utf8Type:
+		t[2], t[3] = fbUint8(typeUtf8), fbTable{}
+	case timestampType:
+		t[2], t[3] = fbUint8(typeTimestamp), fbTable{fbInt16(unitMicrosecond), "UTC"}
+	case utf8ListType:
+		t[2], t[3] = fbUint8(typeList), fbTable{}
+		t[5] = fbTables{field{name: "item", typ: utf8Type}.schema()}
+	}
+	return t
+}
+
+// column collects the values of one column for the current batch
+type column struct {
+	typ      columnType
+	length   int
+	nulls    int
+	validity []byte
+	offsets  []byte // int32 offsets of strings and lists
+	data     []byte // fixed-width values or string bytes
+	items    *column
+}
+
+func newColumn(typ columnType) *column {
+	c := &column{typ: typ}
+	if typ == utf8ListType {
+		c.items = newColumn(utf8Type)
+	}
+	c.reset()
+	return c
+}
+
+func (c *column) reset() {
+	c.length, c.nulls = 0, 0
+	c.validity, c.data = c.validity[:0], c.data[:0]
+	c.offsets = binary.LittleEndian.AppendUint32(c.offsets[:0], 0)
+	if c.items != nil {
+		c.items.reset()
+	}
+}
+
+// next appends the validity bit of a new row
+func (c *column) next(valid bool) {
+	if c.length%8 == 0 {
+		c.validity = append(c.validity, 0)
+	}
+	if valid {
+		c.validity[c.length/8] |= 1 << (c.length % 8)
+	} else {
+		c.nulls++
+	}
+	c.length++
+}
+
+func (c *column) appendNull() {
+	c.next(false)
+	switch c.typ {
+	case int32Type:
+		c.data = append(c.data, 0, 0, 0, 0)
+	case timestampType:
+		c.data = append(c.data, make([]byte, 8)...)
+	case utf8Type:
+		c.offsets = binary.LittleEndian.AppendUint32(c.offsets, uint32(len(c.data)))
+	case utf8ListType:
+		c.offsets = binary.LittleEndian.AppendUint32(c.offsets, uint32(c.items.length))
+	}
+}
+
+func (c *column) appendInt32(v int32) {
+	c.next(true)
+	c.data = binary.LittleEndian.AppendUint32(c.data, uint32(v))
+}
+
+func (c *column) appendTimestamp(t time.Time) {
+	c.next(true)
+	c.data = binary.LittleEndian.AppendUint64(c.data, uint64(t.UnixMicro()))
+}
+
+func (c *column) appendString(s string) {
+	c.next(true)
+	c.data = append(c.data, s...)
+	c.offsets = binary.LittleEndian.AppendUint32(c.offsets, uint32(len(c.data)))
+}
+
+func (c *column) appendStrings(values []string) {
+	c.next(true)
+	for _, s := range values {
+		c.items.appendString(s)
+	}
+	c.offsets = binary.LittleEndian.AppendUint32(c.offsets, uint32(c.items.length))
+}
+
+// size is the number of bytes the column's buffers hold
+func (c *column) size() int {
+	n := len(c.validity) + len(c.offsets) + len(c.data)
+	if c.items != nil {
+		n += c.items.size()
+	}
+	return n
+}
+
+// batch appends the field nodes and buffers of the column, depth first as
+// the format orders them
+func (c *column) batch(nodes *[]byte, buffers *[][]byte) {
+	*nodes = binary.LittleEndian.AppendUint64(*nodes, uint64(c.length))
+	*nodes = binary.LittleEndian.AppendUint64(*nodes, uint64(c.nulls))
+	validity := c.validity
+	if c.nulls == 0 {
+		// Without nulls the validity bitmap may be left out
+		validity = nil
+	}
+	*buffers = append(*buffers, validity)
+	switch c.typ {
+	case int32Type, timestampType:
+		*buffers = append(*buffers, c.data)
+	case utf8Type:
+		*buffers = append(*buffers, c.offsets, c.data)
+	case utf8ListType:
+		*buffers = append(*buffers, c.offsets)
+		c.items.batch(nodes, buffers)
+	}
+}
+
+// stream writes the messages of an Arrow IPC stream
+type stream struct {
+	w       io.Writer
+	fields  []field
+	columns []*column
+	started bool
+}
+
+func newStream(w io.Writer, fields []field) *stream {
+	s := &stream{w: w, fields: fields}
+	for _, f := range fields {
+		s.columns = append(s.columns, newColumn(f.typ))
+	}
+	return s
+}
+
+// full reports whether the batch should be flushed
+func (s *stream) full() bool {
+	if s.columns[0].length >= batchRows {
+		return true
+	}
+	size := 0
+	for _, c := range s.columns {
+		size += c.size()
+	}
+	return size >= batchBytes
+}
+
+// start writes the schema message once
+func (s *stream) start() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	fields := make(fbTables, len(s.fields))
+	for i, f := range s.fields {
+		fields[i] = f.schema()
+	}
+	// Schema: endianness (little), fields
+	schema := fbTable{fbInt16(0), fields}
+	return s.message(headerSchema, schema, nil)
+}
+
+// flush writes the rows collected as a record batch
+func (s *stream) flush() error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	rows := s.columns[0].length
+	if rows == 0 {
+		return nil
+	}
+	var nodes []byte
+	var buffers [][]byte
+	for _, c := range s.columns {
+		c.batch(&nodes, &buffers)
+	}
+	var layout []byte
+	offset := 0
+	for _, buffer := range buffers {
+		layout = binary.LittleEndian.AppendUint64(layout, uint64(offset))
+		layout = binary.LittleEndian.AppendUint64(layout, uint64(len(buffer)))
+		offset += padded(len(buffer))
+	}
+	// RecordBatch: length, nodes, buffers
+	batch := fbTable{
+		fbInt64(int64(rows)),
+		fbStructs{count: len(nodes) / 16, data: nodes},
+		fbStructs{count: len(buffers), data: layout},
+	}
+	if err := s.message(headerRecordBatch, batch, buffers); err != nil {
+		return err
+	}
+	for _, c := range s.columns {
+		c.reset()
+	}
+	return nil
+}
+
+// close flushes the last batch and ends the stream
+func (s *stream) close() error {
+	if err := s.flush(); err != nil {
+		return err
+	}
+	_, err := s.w.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0})
+	return err
+}
+
+// message writes an encapsulated message: the continuation marker, the
+// length of the metadata, the metadata padded to 8 bytes and the body
+func (s *stream) message(headerType uint8, header fbTable, body [][]byte) error {
+	bodyLength := 0
+	for _, buffer := range body {
+		bodyLength += padded(len(buffer))
+	}
+	// Message: version, header_type, header, bodyLength
+	metadata := fbFinish(fbTable{fbInt16(metadataV5), fbUint8(headerType), header, fbInt64(int64(bodyLength))})
+	metadata = append(metadata, make([]byte, padded(len(metadata))-len(metadata))...)
+
+	out := make([]byte, 0, 8+len(metadata)+bodyLength)
+	out = binary.LittleEndian.AppendUint32(out, continuation)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(metadata)))
+	out = append(out, metadata...)
+	for _, buffer := range body {
+		out = append(out, buffer...)
+		out = append(out, make([]byte, padded(len(buffer))-len(buffer))...)
+	}
+	_, err := s.w.Write(out)
+	return err
+}
+
+// padded rounds n up to a multiple of 8
+func padded(n int) int {
+	return (n + 7) &^ 7
+}
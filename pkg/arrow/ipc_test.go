@@ -0,0 +1,357 @@
+package arrow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// fbReader reads the tables fbFinish writes, following the FlatBuffers
+// layout rather than the writer's code
+type fbReader []byte
+
+func (r fbReader) u16(pos int) int { return int(binary.LittleEndian.Uint16(r[pos:])) }
+func (r fbReader) u32(pos int) int { return int(binary.LittleEndian.Uint32(r[pos:])) }
+
+func (r fbReader) root() int { return r.u32(0) }
+
+// field returns the position of a table's field, or -1 when it is absent
+func (r fbReader) field(table, id int) int {
+	vtable := table - int(int32(r.u32(table)))
+	if 4+2*id >= r.u16(vtable) {
+		return -1
+	}
+	if offset := r.u16(vtable + 4 + 2*id); offset != 0 {
+		return table + offset
+	}
+	return -1
+}
+
+func (r fbReader) ref(table, id int) int {
+	at := r.field(table, id)
+	if at < 0 {
+		return -1
+	}
+	return at + r.u32(at)
+}
+
+func (r fbReader) scalar(table, id, width int) uint64 {
+	at := r.field(table, id)
+	if at < 0 {
+		return 0
+	}
+	var v uint64
+	for i := 0; i < width; i++ {
+		v |= uint64(r[at+i]) << (8 * i)
+	}
+	return v
+}
+
+func (r fbReader) str(table, id int) string {
+	pos := r.ref(table, id)
+	if pos < 0 {
+		return ""
+	}
+	return string(r[pos+4 : pos+4+r.u32(pos)])
+}
+
+// tables returns the positions of the tables of a vector field
+func (r fbReader) tables(table, id int) []int {
+	pos := r.ref(table, id)
+	if pos < 0 {
+		return nil
+	}
+	var tables []int
+	for i := 0; i < r.u32(pos); i++ {
+		at := pos + 4 + 4*i
+		tables = append(tables, at+r.u32(at))
+	}
+	return tables
+}
+
+// structs returns the 8-byte members of a struct vector field
+func (r fbReader) structs(table, id, members int) []int64 {
+	pos := r.ref(table, id)
+	var values []int64
+	for i := 0; i < r.u32(pos)*members; i++ {
+		values = append(values, int64(binary.LittleEndian.Uint64(r[pos+4+8*i:])))
+	}
+	return values
+}
+
+// readField is a column of a decoded schema
+type readField struct {
+	name     string
+	typeType int
+	children []readField
+}
+
+func readFields(r fbReader, table, id int) []readField {
+	var fields []readField
+	for _, f := range r.tables(table, id) {
+		fields = append(fields, readField{
+			name:     r.str(f, 0),
+			typeType: int(r.scalar(f, 2, 1)),
+			children: readFields(r, f, 5),
+		})
+	}
+	return fields
+}
+
+// batchReader decodes the columns of one record batch
+type batchReader struct {
+	nodes   []int64
+	buffers []int64
+	body    []byte
+}
+
+func (b *batchReader) node() (length, nulls int) {
+	length, nulls = int(b.nodes[0]), int(b.nodes[1])
+	b.nodes = b.nodes[2:]
+	return length, nulls
+}
+
+func (b *batchReader) buffer() []byte {
+	offset, length := b.buffers[0], b.buffers[1]
+	b.buffers = b.buffers[2:]
+	return b.body[offset : offset+length]
+}
+
+// column returns the values of a column, nil for nulls
+func (b *batchReader) column(f readField) []interface{} {
+	length, nulls := b.node()
+	validity := b.buffer()
+	valid := func(i int) bool { return nulls == 0 || validity[i/8]&(1<<(i%8)) != 0 }
+	values := make([]interface{}, length)
+	switch f.typeType {
+	case typeInt:
+		data := b.buffer()
+		for i := range values {
+			if valid(i) {
+				values[i] = int(int32(binary.LittleEndian.Uint32(data[4*i:])))
+			}
+		}
+	case typeTimestamp:
+		data := b.buffer()
+		for i := range values {
+			if valid(i) {
+				values[i] = time.UnixMicro(int64(binary.LittleEndian.Uint64(data[8*i:]))).UTC()
+			}
+		}
+	case typeUtf8:
+		offsets, data := b.buffer(), b.buffer()
+		for i := range values {
+			if valid(i) {
+				values[i] = string(data[binary.LittleEndian.Uint32(offsets[4*i:]):binary.LittleEndian.Uint32(offsets[4*i+4:])])
+			}
+		}
+	case typeList:
+		offsets := b.buffer()
+		items := b.column(f.children[0])
+		for i := range values {
+			if valid(i) {
+				var list []string
+				for _, item := range items[binary.LittleEndian.Uint32(offsets[4*i:]):binary.LittleEndian.Uint32(offsets[4*i+4:])] {
+					list = append(list, item.(string))
+				}
+				values[i] = list
+			}
+		}
+	}
+	return values
+}
+
+// readStream decodes an Arrow IPC stream into rows of column values
+func readStream(t *testing.T, data []byte) (fields []readField, rows []map[string]interface{}, batches int) {
+	t.Helper()
+	for {
+		if len(data) < 8 || binary.LittleEndian.Uint32(data) != continuation {
+			t.Fatalf("missing continuation marker")
+		}
+		length := int(binary.LittleEndian.Uint32(data[4:]))
+		if length == 0 {
+			if len(data) != 8 {
+				t.Fatalf("%d bytes after the end of the stream", len(data)-8)
+			}
+			return fields, rows, batches
+		}
+		if length%8 != 0 {
+			t.Fatalf("metadata length %d is not padded", length)
+		}
+		r := fbReader(data[8 : 8+length])
+		message := r.root()
+		if version := r.scalar(message, 0, 2); version != metadataV5 {
+			t.Fatalf("metadata version %d", version)
+		}
+		bodyLength := int(r.scalar(message, 3, 8))
+		body := data[8+length : 8+length+bodyLength]
+		data = data[8+length+bodyLength:]
+
+		header := r.ref(message, 2)
+		switch r.scalar(message, 1, 1) {
+		case headerSchema:
+			if fields != nil {
+				t.Fatal("second schema message")
+			}
+			fields = readFields(r, header, 1)
+		case headerRecordBatch:
+			batches++
+			b := &batchReader{nodes: r.structs(header, 1, 2), buffers: r.structs(header, 2, 2), body: body}
+			n := int(r.scalar(header, 0, 8))
+			batch := make([]map[string]interface{}, n)
+			for i := range batch {
+				batch[i] = map[string]interface{}{}
+			}
+			for _, f := range fields {
+				for i, v := range b.column(f) {
+					if v != nil {
+						batch[i][f.name] = v
+					}
+				}
+			}
+			rows = append(rows, batch...)
+		default:
+			t.Fatalf("unexpected message type %d", r.scalar(message, 1, 1))
+		}
+	}
+}
+
+// fromRow rebuilds the entry a row was written from
+func fromRow(t *testing.T, row map[string]interface{}) (*collector.SystemLog, string) {
+	entry := &collector.SystemLog{}
+	str := func(name string) string { s, _ := row[name].(string); return s }
+	num := func(name string) int { n, _ := row[name].(int); return n }
+	at := func(name string) time.Time { ts, _ := row[name].(time.Time); return ts }
+	entry.ID = str("id")
+	entry.Timestamp = at("timestamp")
+	entry.OriginalTimestamp = str("original_timestamp")
+	entry.Source = collector.LogSource(str("source"))
+	entry.Level = collector.LogLevel(str("level"))
+	entry.Message = str("message")
+	entry.Host = str("host")
+	entry.Service = str("service")
+	entry.PID = num("pid")
+	entry.User = str("user")
+	entry.IP = str("ip")
+	entry.Method = str("method")
+	entry.Path = str("path")
+	entry.StatusCode = num("status_code")
+	entry.RawLog = str("raw_log")
+	if parsed := str("parsed_data"); parsed != "" {
+		if err := json.Unmarshal([]byte(parsed), &entry.ParsedData); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entry.Tags, _ = row["tags"].([]string)
+	entry.CollectedAt = at("collected_at")
+	entry.Fingerprint = str("fingerprint")
+	return entry, str("source_name")
+}
+
+func testEntries(n int) []collector.SystemLog {
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	entries := make([]collector.SystemLog, n)
+	for i := range entries {
+		e := &entries[i]
+		e.ID = fmt.Sprintf("id-%d", i)
+		e.Timestamp = base.Add(time.Duration(i) * time.Microsecond)
+		e.Source = collector.SourceNginx
+		e.Level = collector.LevelInfo
+		e.Message = fmt.Sprintf("request %d", i)
+		e.RawLog = "raw ü " + e.Message
+		// Optional fields vary so every column has nulls and values
+		if i%2 == 0 {
+			e.Host, e.PID, e.StatusCode = "web-1", 100+i, 200
+			e.Tags = []string{"web", fmt.Sprint(i)}
+			e.ParsedData = map[string]interface{}{"bytes": float64(i)}
+			e.CollectedAt = e.Timestamp.Add(time.Second)
+		}
+		if i%3 == 0 {
+			e.Tags = append(e.Tags, "")
+			e.OriginalTimestamp, e.Service, e.User, e.IP = "Mar  1 12:00:00", "nginx", "root", "10.0.0.1"
+			e.Method, e.Path, e.Fingerprint = "GET", "/", "f00d"
+		}
+	}
+	return entries
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries int
+		batches int
+	}{
+		{"empty", 0, 0},
+		{"one", 1, 1},
+		{"mixed nulls", 17, 1},
+		{"several batches", batchRows*2 + 5, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries := testEntries(test.entries)
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			for i := range entries {
+				var err error
+				if i%2 == 0 {
+					err = w.Write(&entries[i])
+				} else {
+					err = w.WriteLine(&collector.ContextLine{SystemLog: entries[i], SourceName: "access"})
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			fields, rows, batches := readStream(t, buf.Bytes())
+			if len(fields) != len(logFields) {
+				t.Fatalf("schema has %d fields, want %d", len(fields), len(logFields))
+			}
+			for i, f := range fields {
+				if f.name != logFields[i].name {
+					t.Fatalf("field %d is %q, want %q", i, f.name, logFields[i].name)
+				}
+			}
+			if batches != test.batches || len(rows) != len(entries) {
+				t.Fatalf("read %d rows in %d batches, want %d in %d", len(rows), batches, len(entries), test.batches)
+			}
+			for i, row := range rows {
+				got, sourceName := fromRow(t, row)
+				if !reflect.DeepEqual(got, &entries[i]) {
+					t.Fatalf("row %d is %+v, want %+v", i, got, entries[i])
+				}
+				if want := map[bool]string{false: "", true: "access"}[i%2 == 1]; sourceName != want {
+					t.Fatalf("row %d has source name %q, want %q", i, sourceName, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriterFlush(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	entries := testEntries(3)
+	w.Write(&entries[0])
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	w.Write(&entries[1])
+	w.Write(&entries[2])
+	w.Flush()
+	// Flushing nothing writes no empty batch
+	w.Flush()
+	w.Close()
+	if _, rows, batches := readStream(t, buf.Bytes()); batches != 2 || len(rows) != 3 {
+		t.Fatalf("read %d rows in %d batches, want 3 in 2", len(rows), batches)
+	}
+}
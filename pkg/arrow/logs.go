@@ -0,0 +1,132 @@
+package arrow
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// logFields are the columns of exported entries, named like their JSON
+// fields. Fields the JSON leaves out when empty are null; parsed_data holds
+// the parsed fields as a JSON object. source_name is set for search results.
+var logFields = []field{
+	{"id", utf8Type},
+	{"timestamp", timestampType},
+	{"original_timestamp", utf8Type},
+	{"source", utf8Type},
+	{"level", utf8Type},
+	{"message", utf8Type},
+	{"host", utf8Type},
+	{"service", utf8Type},
+	{"pid", int32Type},
+	{"user", utf8Type},
+	{"ip", utf8Type},
+	{"method", utf8Type},
+	{"path", utf8Type},
+	{"status_code", int32Type},
+	{"raw_log", utf8Type},
+	{"parsed_data", utf8Type},
+	{"tags", utf8ListType},
+	{"collected_at", timestampType},
+	{"fingerprint", utf8Type},
+	{"source_name", utf8Type},
+}
+
+// Writer writes log entries as an Arrow IPC stream, in record batches of up
+// to 8192 rows. Close must be called to write the last batch and the end of
+// the stream; a stream without entries still carries the schema.
+type Writer struct {
+	stream *stream
+}
+
+// NewWriter creates a writer of entries to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{stream: newStream(w, logFields)}
+}
+
+// Write adds an entry, writing a record batch when one is full
+func (w *Writer) Write(entry *collector.SystemLog) error {
+	return w.write(entry, "")
+}
+
+// WriteLine adds a search result with the name of its source
+func (w *Writer) WriteLine(line *collector.ContextLine) error {
+	return w.write(&line.SystemLog, line.SourceName)
+}
+
+func (w *Writer) write(entry *collector.SystemLog, sourceName string) error {
+	c := w.stream.columns
+	c[0].appendString(entry.ID)
+	appendTime(c[1], entry.Timestamp)
+	appendOptional(c[2], entry.OriginalTimestamp)
+	c[3].appendString(string(entry.Source))
+	c[4].appendString(string(entry.Level))
+	c[5].appendString(entry.Message)
+	appendOptional(c[6], entry.Host)
+	appendOptional(c[7], entry.Service)
+	appendOptionalInt(c[8], entry.PID)
+	appendOptional(c[9], entry.User)
+	appendOptional(c[10], entry.IP)
+	appendOptional(c[11], entry.Method)
+	appendOptional(c[12], entry.Path)
+	appendOptionalInt(c[13], entry.StatusCode)
+	c[14].appendString(entry.RawLog)
+	if len(entry.ParsedData) == 0 {
+		c[15].appendNull()
+	} else if data, err := json.Marshal(entry.ParsedData); err != nil {
+		c[15].appendNull()
+	} else {
+		c[15].appendString(string(data))
+	}
+	if len(entry.Tags) == 0 {
+		c[16].appendNull()
+	} else {
+		c[16].appendStrings(entry.Tags)
+	}
+	appendTime(c[17], entry.CollectedAt)
+	appendOptional(c[18], entry.Fingerprint)
+	appendOptional(c[19], sourceName)
+
+	if w.stream.full() {
+		return w.stream.flush()
+	}
+	return nil
+}
+
+// Flush writes the entries added so far as a record batch, e.g. before
+// waiting for more
+func (w *Writer) Flush() error {
+	return w.stream.flush()
+}
+
+// Close writes the last batch and the end of the stream. It doesn't close
+// the underlying writer.
+func (w *Writer) Close() error {
+	return w.stream.close()
+}
+
+func appendOptional(c *column, s string) {
+	if s == "" {
+		c.appendNull()
+		return
+	}
+	c.appendString(s)
+}
+
+func appendOptionalInt(c *column, n int) {
+	if n == 0 {
+		c.appendNull()
+		return
+	}
+	c.appendInt32(int32(n))
+}
+
+func appendTime(c *column, t time.Time) {
+	if t.IsZero() {
+		c.appendNull()
+		return
+	}
+	c.appendTimestamp(t)
+}
@@ -3,9 +3,12 @@ package audit
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -19,6 +22,11 @@ const (
 	EventTypeStartup     EventType = "startup"
 	EventTypeShutdown    EventType = "shutdown"
 	EventTypeHealthCheck EventType = "health_check"
+	EventTypeAuthFailure EventType = "auth_failure"
+	// EventTypeSlowRequest and EventTypeLargeResponse flag requests over
+	// the thresholds set with SetRequestThresholds
+	EventTypeSlowRequest   EventType = "slow_request"
+	EventTypeLargeResponse EventType = "large_response"
 )
 
 // AuditEvent represents system events
@@ -42,37 +50,88 @@ type AuditEvent struct {
 // Logger audit logger
 type Logger struct {
 	logger *log.Logger
+	// format renders events for the console instead of JSON, see SetFormat
+	format func(AuditEvent) string
+
+	sinksMu sync.RWMutex
+	sinks   []func(AuditEvent)
+
+	httpStats *HTTPStats
+	// slowRequest and largeResponse are the thresholds of slow_request and
+	// large_response events; zero disables them
+	slowRequest   time.Duration
+	largeResponse int64
+
+	// repeats collapses recurring errors, see SetRepeatWindow
+	repeats repeats
 }
 
 // New creates a new audit logger
 func New() *Logger {
-	logger := log.New(os.Stdout, "[AUDIT] ", 0)
+	return NewWithWriter(os.Stdout)
+}
+
+// NewWithWriter creates a new audit logger writing to w
+func NewWithWriter(w io.Writer) *Logger {
+	logger := log.New(w, "[AUDIT] ", 0)
 	return &Logger{
-		logger: logger,
+		logger:    logger,
+		httpStats: NewHTTPStats(),
 	}
 }
 
+// HTTPStats returns the per-route request statistics the middleware
+// collects
+func (l *Logger) HTTPStats() *HTTPStats {
+	return l.httpStats
+}
+
 // LogEvent logs an audit event
 func (l *Logger) LogEvent(event AuditEvent) {
 	if event.Timestamp.IsZero() {
 		event.Timestamp = time.Now()
 	}
+	if l.suppress(event) {
+		return
+	}
 
-	// Serialize to JSON format
-	jsonData, err := json.Marshal(event)
-	if err != nil {
+	if l.format != nil {
+		l.logger.Println(l.format(event))
+	} else if jsonData, err := json.Marshal(event); err != nil {
 		l.logger.Printf("AUDIT LOG ERROR: %v", err)
-		return
+	} else {
+		l.logger.Println(string(jsonData))
 	}
 
-	// Write to console
-	l.logger.Println(string(jsonData))
+	l.sinksMu.RLock()
+	defer l.sinksMu.RUnlock()
+	for _, sink := range l.sinks {
+		sink(event)
+	}
+}
+
+// SetFormat makes the logger print every event as format(event), one line
+// without the [AUDIT] prefix, instead of JSON. It must be called before the
+// logger is used.
+func (l *Logger) SetFormat(format func(AuditEvent) string) {
+	l.format = format
+	l.logger.SetPrefix("")
+}
+
+// AddSink registers fn to receive every event after it is logged. Sinks are
+// called synchronously and must not block.
+func (l *Logger) AddSink(fn func(AuditEvent)) {
+	l.sinksMu.Lock()
+	defer l.sinksMu.Unlock()
+	l.sinks = append(l.sinks, fn)
 }
 
 // LogAPICall logs API calls
 func (l *Logger) LogAPICall(r *http.Request, statusCode int, duration time.Duration, details interface{}) {
 	event := AuditEvent{
 		EventType:  EventTypeAPICall,
+		UserID:     RequestUser(r),
+		RequestID:  RequestID(r),
 		Method:     r.Method,
 		Path:       r.URL.Path,
 		StatusCode: statusCode,
@@ -94,6 +153,64 @@ func (l *Logger) LogAPICall(r *http.Request, statusCode int, duration time.Durat
 	l.LogEvent(event)
 }
 
+// SetRequestThresholds makes the middleware log a slow_request event for
+// requests taking longer than slow and a large_response event for
+// responses of more than large bytes; zero disables either
+func (l *Logger) SetRequestThresholds(slow time.Duration, large int64) {
+	l.slowRequest = slow
+	l.largeResponse = large
+}
+
+// checkRequestThresholds logs the requests over the thresholds with what
+// helps find the cause: the handler, the route and the query parameters.
+// Event streams are meant to last, so they are never slow.
+func (l *Logger) checkRequestThresholds(r *http.Request, w *ResponseWriter, handler string, duration time.Duration) {
+	statusCode, written := w.StatusCode(), w.written
+	stream := strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+	slow := l.slowRequest > 0 && duration > l.slowRequest && !stream
+	large := l.largeResponse > 0 && int64(written) > l.largeResponse
+	if !slow && !large {
+		return
+	}
+
+	details := map[string]interface{}{
+		"route":         r.Pattern,
+		"duration_ms":   float64(duration) / float64(time.Millisecond),
+		"bytes_written": written,
+	}
+	if handler != "" {
+		details["handler"] = handler
+	}
+	if r.URL.RawQuery != "" {
+		details["query_params"] = r.URL.Query()
+	}
+	event := AuditEvent{
+		UserID:     RequestUser(r),
+		RequestID:  RequestID(r),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		StatusCode: statusCode,
+		Duration:   duration.String(),
+		Details:    details,
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+	}
+	if slow {
+		took := duration
+		if took > time.Millisecond {
+			took = took.Round(time.Millisecond)
+		}
+		event.EventType = EventTypeSlowRequest
+		event.Message = fmt.Sprintf("Slow request: %s %s took %s (threshold %s)", r.Method, r.URL.Path, took, l.slowRequest)
+		l.LogEvent(event)
+	}
+	if large {
+		event.EventType = EventTypeLargeResponse
+		event.Message = fmt.Sprintf("Large response: %s %s wrote %d bytes (threshold %d)", r.Method, r.URL.Path, written, l.largeResponse)
+		l.LogEvent(event)
+	}
+}
+
 // LogMessageSent logs message sending
 func (l *Logger) LogMessageSent(recipient, messageType, messageID string, success bool, details interface{}) {
 	message := fmt.Sprintf("Message sent: %s -> %s (ID: %s)", messageType, recipient, messageID)
@@ -128,6 +245,26 @@ func (l *Logger) LogError(err error, context string, details interface{}) {
 	l.LogEvent(event)
 }
 
+// LogAuthFailure logs a request rejected for a missing or wrong token;
+// realm names the token, e.g. admin or agent
+func (l *Logger) LogAuthFailure(r *http.Request, realm string, err error, details map[string]interface{}) {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	details["realm"] = realm
+	l.LogEvent(AuditEvent{
+		EventType:  EventTypeAuthFailure,
+		RequestID:  RequestID(r),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Message:    fmt.Sprintf("Authentication failed for %s %s", r.Method, r.URL.Path),
+		Error:      err.Error(),
+		Details:    details,
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+	})
+}
+
 // LogStartup logs application startup
 func (l *Logger) LogStartup(port string, details interface{}) {
 	event := AuditEvent{
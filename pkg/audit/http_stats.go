@@ -0,0 +1,244 @@
+package audit
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds in seconds of the latency histogram
+// kept per route, as exposed on /metrics
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// HTTPStats aggregates the requests the middleware sees per route and
+// method, so slow or failing endpoints can be found without parsing the
+// api_call events
+type HTTPStats struct {
+	mu     sync.Mutex
+	since  time.Time
+	routes map[routeKey]*routeStats
+}
+
+// routeKey identifies a route: the pattern it was registered with, not the
+// request path, so IDs in paths don't create a series each
+type routeKey struct {
+	route  string
+	method string
+}
+
+// routeStats are the counters of one route and method
+type routeStats struct {
+	requests  uint64
+	statuses  [6]uint64 // by status class, 1xx to 5xx; [0] is unused
+	bytes     uint64
+	sum       time.Duration
+	max       time.Duration
+	histogram []uint64 // per latencyBuckets, not cumulative; the last is +Inf
+}
+
+// RouteStats is the summary of one route and method
+type RouteStats struct {
+	Route    string            `json:"route"`
+	Method   string            `json:"method"`
+	Requests uint64            `json:"requests"`
+	Statuses map[string]uint64 `json:"statuses"`
+	// ErrorRate is the share of 5xx responses
+	ErrorRate    float64 `json:"error_rate"`
+	BytesWritten uint64  `json:"bytes_written"`
+	// Latencies are in milliseconds; the percentiles are estimated from
+	// the histogram, so they are as precise as its buckets
+	MeanMs float64 `json:"mean_ms"`
+	P50Ms  float64 `json:"p50_ms"`
+	P95Ms  float64 `json:"p95_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+// NewHTTPStats creates empty request statistics
+func NewHTTPStats() *HTTPStats {
+	return &HTTPStats{since: time.Now(), routes: make(map[routeKey]*routeStats)}
+}
+
+// Observe records a finished request. route is the pattern the request
+// matched, e.g. /api/agents/; requests that matched none count as "other".
+func (s *HTTPStats) Observe(route, method string, status int, duration time.Duration, written int) {
+	if route == "" {
+		route = "other"
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+	default:
+		// Arbitrary methods would create arbitrary series
+		method = "OTHER"
+	}
+	bucket := sort.SearchFloat64s(latencyBuckets, duration.Seconds())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := routeKey{route: route, method: method}
+	stats := s.routes[key]
+	if stats == nil {
+		stats = &routeStats{histogram: make([]uint64, len(latencyBuckets)+1)}
+		s.routes[key] = stats
+	}
+	stats.requests++
+	if class := status / 100; class >= 1 && class <= 5 {
+		stats.statuses[class]++
+	}
+	stats.bytes += uint64(written)
+	stats.sum += duration
+	if duration > stats.max {
+		stats.max = duration
+	}
+	stats.histogram[bucket]++
+}
+
+// Snapshot returns the statistics of every route, slowest p95 first
+func (s *HTTPStats) Snapshot() []RouteStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]RouteStats, 0, len(s.routes))
+	for key, stats := range s.routes {
+		summary := RouteStats{
+			Route:        key.route,
+			Method:       key.method,
+			Requests:     stats.requests,
+			Statuses:     make(map[string]uint64),
+			BytesWritten: stats.bytes,
+			MeanMs:       milliseconds(stats.sum) / float64(stats.requests),
+			P50Ms:        stats.quantile(0.5),
+			P95Ms:        stats.quantile(0.95),
+			P99Ms:        stats.quantile(0.99),
+			MaxMs:        milliseconds(stats.max),
+		}
+		for class := 1; class <= 5; class++ {
+			if stats.statuses[class] > 0 {
+				summary.Statuses[strconv.Itoa(class)+"xx"] = stats.statuses[class]
+			}
+		}
+		summary.ErrorRate = float64(stats.statuses[5]) / float64(stats.requests)
+		result = append(result, summary)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].P95Ms != result[j].P95Ms {
+			return result[i].P95Ms > result[j].P95Ms
+		}
+		if result[i].Route != result[j].Route {
+			return result[i].Route < result[j].Route
+		}
+		return result[i].Method < result[j].Method
+	})
+	return result
+}
+
+// Since returns when the statistics started
+func (s *HTTPStats) Since() time.Time {
+	return s.since
+}
+
+// quantile estimates the q-quantile in milliseconds by interpolating
+// within its histogram bucket, capped by the slowest request; the open last
+// bucket reports the maximum
+func (stats *routeStats) quantile(q float64) float64 {
+	rank := q * float64(stats.requests)
+	var seen float64
+	for i, count := range stats.histogram {
+		if count == 0 {
+			continue
+		}
+		if seen+float64(count) < rank {
+			seen += float64(count)
+			continue
+		}
+		if i == len(latencyBuckets) {
+			return milliseconds(stats.max)
+		}
+		lower, upper := 0.0, min(latencyBuckets[i]*1000, milliseconds(stats.max))
+		if i > 0 {
+			lower = latencyBuckets[i-1] * 1000
+		}
+		return lower + (upper-lower)*(rank-seen)/float64(count)
+	}
+	return 0
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// WritePrometheus writes the statistics in the Prometheus text format:
+// gonder_http_requests_total by route, method and status class,
+// gonder_http_response_bytes_total and the
+// gonder_http_request_duration_seconds histogram
+func (s *HTTPStats) WritePrometheus(w io.Writer) error {
+	s.mu.Lock()
+	keys := make([]routeKey, 0, len(s.routes))
+	copies := make(map[routeKey]routeStats, len(s.routes))
+	for key, stats := range s.routes {
+		keys = append(keys, key)
+		copied := *stats
+		copied.histogram = append([]uint64(nil), stats.histogram...)
+		copies[key] = copied
+	}
+	s.mu.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	ew := &errWriter{w: w}
+	ew.printf("# HELP gonder_http_requests_total HTTP requests by route, method and status class.\n")
+	ew.printf("# TYPE gonder_http_requests_total counter\n")
+	for _, key := range keys {
+		stats := copies[key]
+		for class := 1; class <= 5; class++ {
+			if stats.statuses[class] > 0 {
+				ew.printf("gonder_http_requests_total{%s,status=\"%dxx\"} %d\n", key.labels(), class, stats.statuses[class])
+			}
+		}
+	}
+	ew.printf("# HELP gonder_http_response_bytes_total Response body bytes by route and method.\n")
+	ew.printf("# TYPE gonder_http_response_bytes_total counter\n")
+	for _, key := range keys {
+		ew.printf("gonder_http_response_bytes_total{%s} %d\n", key.labels(), copies[key].bytes)
+	}
+	ew.printf("# HELP gonder_http_request_duration_seconds HTTP request latency by route and method.\n")
+	ew.printf("# TYPE gonder_http_request_duration_seconds histogram\n")
+	for _, key := range keys {
+		stats := copies[key]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += stats.histogram[i]
+			ew.printf("gonder_http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", key.labels(), strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		ew.printf("gonder_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", key.labels(), stats.requests)
+		ew.printf("gonder_http_request_duration_seconds_sum{%s} %s\n", key.labels(), strconv.FormatFloat(stats.sum.Seconds(), 'g', -1, 64))
+		ew.printf("gonder_http_request_duration_seconds_count{%s} %d\n", key.labels(), stats.requests)
+	}
+	return ew.err
+}
+
+// labels renders the Prometheus labels of a route
+func (key routeKey) labels() string {
+	return fmt.Sprintf("route=%s,method=%q", strconv.Quote(key.route), key.method)
+}
+
+// errWriter keeps the first write error, so a long exposition doesn't
+// check every line
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err == nil {
+		_, ew.err = fmt.Fprintf(ew.w, format, args...)
+	}
+}
@@ -2,6 +2,9 @@ package audit
 
 import (
 	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
 	"time"
 )
 
@@ -33,6 +36,12 @@ func (rw *ResponseWriter) Write(data []byte) (int, error) {
 	return written, err
 }
 
+// Unwrap returns the wrapped writer, so http.ResponseController can reach
+// its Flush and deadlines
+func (rw *ResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // StatusCode returns captured status code
 func (rw *ResponseWriter) StatusCode() int {
 	return rw.statusCode
@@ -46,6 +55,7 @@ func Middleware(auditLogger *Logger) func(http.Handler) http.Handler {
 
 			// Wrap response writer
 			wrappedWriter := NewResponseWriter(w)
+			r = withRequestID(wrappedWriter, r)
 
 			// Process request
 			next.ServeHTTP(wrappedWriter, r)
@@ -64,18 +74,28 @@ func Middleware(auditLogger *Logger) func(http.Handler) http.Handler {
 				details["content_type"] = contentType
 			}
 
+			auditLogger.httpStats.Observe(r.Pattern, r.Method, wrappedWriter.StatusCode(), duration, wrappedWriter.written)
 			auditLogger.LogAPICall(r, wrappedWriter.StatusCode(), duration, details)
+			auditLogger.checkRequestThresholds(r, wrappedWriter, "", duration)
 		})
 	}
 }
 
 // MiddlewareFunc middleware as function
 func MiddlewareFunc(auditLogger *Logger, next http.HandlerFunc) http.HandlerFunc {
+	return MiddlewareNamed(auditLogger, HandlerName(next), next)
+}
+
+// MiddlewareNamed is MiddlewareFunc for a handler wrapped by others, e.g.
+// authentication, reporting the given handler name in slow_request and
+// large_response events instead of the outermost wrapper
+func MiddlewareNamed(auditLogger *Logger, handler string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
 		// Wrap response writer
 		wrappedWriter := NewResponseWriter(w)
+		r = withRequestID(wrappedWriter, r)
 
 		// Process request
 		next.ServeHTTP(wrappedWriter, r)
@@ -94,6 +114,22 @@ func MiddlewareFunc(auditLogger *Logger, next http.HandlerFunc) http.HandlerFunc
 			details["content_type"] = contentType
 		}
 
+		auditLogger.httpStats.Observe(r.Pattern, r.Method, wrappedWriter.StatusCode(), duration, wrappedWriter.written)
 		auditLogger.LogAPICall(r, wrappedWriter.StatusCode(), duration, details)
+		auditLogger.checkRequestThresholds(r, wrappedWriter, handler, duration)
+	}
+}
+
+// HandlerName returns the short name of a handler function, e.g.
+// handler.(*LogHandler).GetStatus
+func HandlerName(fn http.HandlerFunc) string {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return ""
+	}
+	name := strings.TrimSuffix(f.Name(), "-fm")
+	if slash := strings.LastIndex(name, "/"); slash >= 0 {
+		name = name[slash+1:]
 	}
+	return name
 }
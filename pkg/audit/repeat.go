@@ -0,0 +1,177 @@
+package audit
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventTypeRepeated summarizes the repeats of an error event that were
+// suppressed during a window, see SetRepeatWindow
+const EventTypeRepeated EventType = "repeated"
+
+// maxRepeatKeys bounds the errors tracked at once; further errors are
+// logged in full
+const maxRepeatKeys = 1000
+
+// repeatKey identifies repeats of the same error. Numbers in the message
+// are masked, so counters and backoffs in it don't tell repeats apart.
+type repeatKey struct {
+	eventType EventType
+	message   string
+	err       string
+}
+
+// repeatState counts the suppressed repeats of one error in the current
+// window
+type repeatState struct {
+	last      AuditEvent
+	count     int
+	firstSeen time.Time
+	timer     *time.Timer
+}
+
+// repeats collapses error events that keep recurring
+type repeats struct {
+	mu     sync.Mutex
+	window time.Duration
+	states map[repeatKey]*repeatState
+}
+
+// SetRepeatWindow collapses repeated errors: an error event is logged in
+// full the first time, further events with the same type, error and
+// message (numbers aside) within window are counted instead, and one
+// EventTypeRepeated summary is logged per window while they continue. Events of a request and
+// authentication failures are always logged. Zero disables it.
+func (l *Logger) SetRepeatWindow(window time.Duration) {
+	l.repeats.mu.Lock()
+	defer l.repeats.mu.Unlock()
+	l.repeats.window = window
+	if l.repeats.states == nil {
+		l.repeats.states = make(map[repeatKey]*repeatState)
+	}
+}
+
+// suppress reports whether event repeats an error logged during the
+// current window, counting it if so
+func (l *Logger) suppress(event AuditEvent) bool {
+	if event.Error == "" || event.RequestID != "" || event.EventType == EventTypeAuthFailure || event.EventType == EventTypeRepeated {
+		return false
+	}
+	r := &l.repeats
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.window <= 0 {
+		return false
+	}
+
+	key := repeatKey{eventType: event.EventType, message: maskDigits(event.Message), err: event.Error}
+	if state, ok := r.states[key]; ok {
+		if state.count == 0 {
+			state.firstSeen = event.Timestamp
+		}
+		state.count++
+		state.last = event
+		return true
+	}
+	if len(r.states) >= maxRepeatKeys {
+		return false
+	}
+	state := &repeatState{}
+	state.timer = time.AfterFunc(r.window, func() { l.endRepeatWindow(key) })
+	r.states[key] = state
+	return false
+}
+
+// endRepeatWindow logs the summary of an error that repeated during the
+// window and starts the next window; an error that didn't repeat is
+// forgotten, so it is logged in full when it comes back
+func (l *Logger) endRepeatWindow(key repeatKey) {
+	r := &l.repeats
+	r.mu.Lock()
+	state, ok := r.states[key]
+	if !ok {
+		r.mu.Unlock()
+		return
+	}
+	if state.count == 0 {
+		delete(r.states, key)
+		r.mu.Unlock()
+		return
+	}
+	summary := repeatSummary(state, r.window)
+	state.count = 0
+	state.timer.Reset(r.window)
+	r.mu.Unlock()
+	l.LogEvent(summary)
+}
+
+// FlushRepeated logs the summaries of the current windows, e.g. before
+// shutting down, and forgets every tracked error
+func (l *Logger) FlushRepeated() {
+	r := &l.repeats
+	r.mu.Lock()
+	var summaries []AuditEvent
+	now := time.Now()
+	for key, state := range r.states {
+		state.timer.Stop()
+		if state.count > 0 {
+			summaries = append(summaries, repeatSummary(state, now.Sub(state.firstSeen)))
+		}
+		delete(r.states, key)
+	}
+	r.mu.Unlock()
+	for _, summary := range summaries {
+		l.LogEvent(summary)
+	}
+}
+
+// maskDigits replaces every run of digits with #
+func maskDigits(s string) string {
+	var b strings.Builder
+	digits := false
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			if !digits {
+				b.WriteByte('#')
+			}
+			digits = true
+			continue
+		}
+		digits = false
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// repeatSummary describes the repeats of an error, e.g. "Error in open:
+// no such file ×120 in last 10m"
+func repeatSummary(state *repeatState, window time.Duration) AuditEvent {
+	last := state.last
+	return AuditEvent{
+		EventType: EventTypeRepeated,
+		Message:   fmt.Sprintf("%s ×%d in last %s", last.Message, state.count, shortDuration(window)),
+		Error:     last.Error,
+		Details: map[string]interface{}{
+			"event_type": last.EventType,
+			"count":      state.count,
+			"first_seen": state.firstSeen,
+			"last_seen":  last.Timestamp,
+			"details":    last.Details,
+		},
+	}
+}
+
+// shortDuration formats d to the second without zero units, e.g. 10m
+// instead of 10m0s
+func shortDuration(d time.Duration) string {
+	s := d.Round(time.Second).String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
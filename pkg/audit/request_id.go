@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader carries the request ID. A valid ID sent by the client is
+// kept, so calls can be correlated across proxies.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds a client-supplied request ID
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// userKey holds a *string the handler fills with SetUser
+type userKey struct{}
+
+// RequestID returns the ID the middleware assigned to the request, or an
+// empty string outside the middleware
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID assigns the request its ID and echoes it in the response
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	w.Header().Set(RequestIDHeader, id)
+	ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+	return r.WithContext(context.WithValue(ctx, userKey{}, new(string)))
+}
+
+// SetUser records who made the request, e.g. the user of a login session,
+// as the user_id of its api_call event
+func SetUser(r *http.Request, user string) {
+	if holder, ok := r.Context().Value(userKey{}).(*string); ok {
+		*holder = user
+	}
+}
+
+// RequestUser returns the user set with SetUser, or an empty string for
+// requests made with a token
+func RequestUser(r *http.Request) string {
+	if holder, ok := r.Context().Value(userKey{}).(*string); ok {
+		return *holder
+	}
+	return ""
+}
+
+// validRequestID accepts short printable ASCII IDs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [12]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,465 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventTypeWebhookFailed records a delivery that failed after its retries.
+// Events of this type are never delivered, so a failing webhook can't feed
+// itself.
+const EventTypeWebhookFailed EventType = "audit_webhook_failed"
+
+// Webhook delivery headers
+const (
+	WebhookEventHeader     = "X-Gonder-Event"
+	WebhookDeliveryHeader  = "X-Gonder-Delivery"
+	WebhookSignatureHeader = "X-Gonder-Signature"
+)
+
+const (
+	// webhookQueueSize bounds the events waiting for one webhook; further
+	// events are dropped
+	webhookQueueSize = 256
+	// webhookAttempts is the number of tries of one delivery
+	webhookAttempts = 3
+	webhookTimeout  = 10 * time.Second
+	// webhookBackoff is the wait before the first retry; it doubles
+	webhookBackoff = time.Second
+)
+
+// ErrWebhookNotFound is returned for an unknown webhook name
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// WebhookConfig subscribes a URL to audit event types
+type WebhookConfig struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Events are the event types delivered, e.g. source_deleted or
+	// auth_failure; "*" delivers every event
+	Events []string `json:"events"`
+	// Secret signs every body: X-Gonder-Signature is "sha256=" and the hex
+	// HMAC-SHA256 of the body with the secret
+	Secret  string            `json:"secret,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Validate checks a webhook configuration
+func (c WebhookConfig) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("webhook name is required")
+	}
+	parsed, err := url.Parse(c.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("webhook %s: url must be http or https: %q", c.Name, c.URL)
+	}
+	if len(c.Events) == 0 {
+		return fmt.Errorf("webhook %s: events are required", c.Name)
+	}
+	for _, event := range c.Events {
+		if event == "" {
+			return fmt.Errorf("webhook %s: empty event type", c.Name)
+		}
+	}
+	return nil
+}
+
+// WebhookStatus is a webhook with its delivery counters; the secret is not
+// included
+type WebhookStatus struct {
+	Name         string     `json:"name"`
+	URL          string     `json:"url"`
+	Events       []string   `json:"events"`
+	Signed       bool       `json:"signed"`
+	Delivered    uint64     `json:"delivered"`
+	Failed       uint64     `json:"failed"`
+	Dropped      uint64     `json:"dropped"`
+	LastDelivery *time.Time `json:"last_delivery,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// Webhooks delivers audit events to the webhooks subscribed to their type.
+// Every webhook has its own queue and worker, so a slow receiver delays
+// only its own events; the logger is never blocked.
+type Webhooks struct {
+	logger *Logger
+	path   string
+	client *http.Client
+
+	mu    sync.RWMutex
+	hooks map[string]*webhook
+}
+
+// webhook is a registered webhook with its queue
+type webhook struct {
+	config WebhookConfig
+	all    bool
+	events map[string]bool
+	queue  chan AuditEvent
+	done   chan struct{}
+	closed atomic.Bool
+
+	delivered atomic.Uint64
+	failed    atomic.Uint64
+	dropped   atomic.Uint64
+
+	mu           sync.Mutex
+	lastDelivery time.Time
+	lastError    string
+}
+
+// NewWebhooks creates the webhook dispatcher of logger. With a path the
+// webhooks are loaded from it and every change is saved there.
+func NewWebhooks(logger *Logger, path string) (*Webhooks, error) {
+	w := &Webhooks{
+		logger: logger,
+		path:   path,
+		client: &http.Client{Timeout: webhookTimeout},
+		hooks:  make(map[string]*webhook),
+	}
+	if path != "" {
+		configs, err := loadWebhooks(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, cfg := range configs {
+			if err := cfg.Validate(); err != nil {
+				return nil, err
+			}
+			if _, exists := w.hooks[cfg.Name]; exists {
+				return nil, fmt.Errorf("duplicate webhook name: %s", cfg.Name)
+			}
+			w.hooks[cfg.Name] = w.start(cfg)
+		}
+	}
+	logger.AddSink(w.dispatch)
+	return w, nil
+}
+
+// loadWebhooks reads the webhooks file; a missing file has no webhooks
+func loadWebhooks(path string) ([]WebhookConfig, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhooks file %s: %w", path, err)
+	}
+	var configs []WebhookConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&configs); err != nil {
+		return nil, fmt.Errorf("failed to decode webhooks file %s: %w", path, err)
+	}
+	return configs, nil
+}
+
+// start creates a webhook and its worker
+func (w *Webhooks) start(cfg WebhookConfig) *webhook {
+	hook := &webhook{
+		config: cfg,
+		events: make(map[string]bool, len(cfg.Events)),
+		queue:  make(chan AuditEvent, webhookQueueSize),
+		done:   make(chan struct{}),
+	}
+	for _, event := range cfg.Events {
+		if event == "*" {
+			hook.all = true
+		}
+		hook.events[event] = true
+	}
+	go w.run(hook)
+	return hook
+}
+
+// Set registers a webhook or replaces the one with the same name. Events
+// queued for a replaced webhook are still delivered with its old settings.
+func (w *Webhooks) Set(cfg WebhookConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	configs := w.configsLocked()
+	replaced := false
+	for i := range configs {
+		if configs[i].Name == cfg.Name {
+			configs[i] = cfg
+			replaced = true
+		}
+	}
+	if !replaced {
+		configs = append(configs, cfg)
+	}
+	if err := w.save(configs); err != nil {
+		return err
+	}
+	if old, exists := w.hooks[cfg.Name]; exists {
+		old.stop()
+	}
+	w.hooks[cfg.Name] = w.start(cfg)
+	return nil
+}
+
+// Delete removes a webhook
+func (w *Webhooks) Delete(name string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	hook, exists := w.hooks[name]
+	if !exists {
+		return ErrWebhookNotFound
+	}
+	configs := w.configsLocked()
+	kept := configs[:0]
+	for _, cfg := range configs {
+		if cfg.Name != name {
+			kept = append(kept, cfg)
+		}
+	}
+	if err := w.save(kept); err != nil {
+		return err
+	}
+	hook.stop()
+	delete(w.hooks, name)
+	return nil
+}
+
+// List returns every webhook with its counters, by name
+func (w *Webhooks) List() []WebhookStatus {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	statuses := make([]WebhookStatus, 0, len(w.hooks))
+	for _, hook := range w.hooks {
+		statuses = append(statuses, hook.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Close stops the workers, giving queued events up to timeout to be
+// delivered
+func (w *Webhooks) Close(timeout time.Duration) {
+	w.mu.Lock()
+	hooks := w.hooks
+	w.hooks = make(map[string]*webhook)
+	w.mu.Unlock()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for _, hook := range hooks {
+		hook.stop()
+	}
+	for _, hook := range hooks {
+		select {
+		case <-hook.done:
+		case <-deadline.C:
+			return
+		}
+	}
+}
+
+// configsLocked returns the configurations by name. w.mu must be held.
+func (w *Webhooks) configsLocked() []WebhookConfig {
+	configs := make([]WebhookConfig, 0, len(w.hooks))
+	for _, hook := range w.hooks {
+		configs = append(configs, hook.config)
+	}
+	sort.Slice(configs, func(i, j int) bool { return configs[i].Name < configs[j].Name })
+	return configs
+}
+
+// save atomically writes the webhooks file, readable only by the owner
+// since it holds the secrets
+func (w *Webhooks) save(configs []WebhookConfig) error {
+	if w.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(configs, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(w.path), filepath.Base(w.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create webhooks file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write webhooks file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to fsync webhooks file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), w.path); err != nil {
+		return fmt.Errorf("failed to replace webhooks file %s: %w", w.path, err)
+	}
+	return nil
+}
+
+// dispatch is the audit sink; it queues the event for every subscribed
+// webhook without blocking
+func (w *Webhooks) dispatch(event AuditEvent) {
+	if event.EventType == EventTypeWebhookFailed {
+		return
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for _, hook := range w.hooks {
+		if !hook.all && !hook.events[string(event.EventType)] {
+			continue
+		}
+		if hook.closed.Load() {
+			continue
+		}
+		select {
+		case hook.queue <- event:
+		default:
+			hook.dropped.Add(1)
+		}
+	}
+}
+
+// stop closes the queue; the worker delivers what is queued and exits
+func (h *webhook) stop() {
+	if !h.closed.Swap(true) {
+		close(h.queue)
+	}
+}
+
+func (w *Webhooks) run(hook *webhook) {
+	defer close(hook.done)
+	for event := range hook.queue {
+		err := w.deliver(hook, event)
+		hook.mu.Lock()
+		if err != nil {
+			hook.lastError = err.Error()
+		} else {
+			hook.lastDelivery = time.Now()
+			hook.lastError = ""
+		}
+		hook.mu.Unlock()
+		if err == nil {
+			hook.delivered.Add(1)
+			continue
+		}
+		hook.failed.Add(1)
+		w.logger.LogEvent(AuditEvent{
+			EventType: EventTypeWebhookFailed,
+			Message:   fmt.Sprintf("Audit webhook %s could not deliver a %s event", hook.config.Name, event.EventType),
+			Error:     err.Error(),
+			Details: map[string]interface{}{
+				"webhook":    hook.config.Name,
+				"event_type": event.EventType,
+				"attempts":   webhookAttempts,
+			},
+		})
+	}
+}
+
+// deliver posts an event, retrying network errors, 429 and 5xx responses
+func (w *Webhooks) deliver(hook *webhook, event AuditEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var id [12]byte
+	rand.Read(id[:])
+	delivery := hex.EncodeToString(id[:])
+
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = w.post(hook.config, event, delivery, body)
+		if err == nil || !retry || attempt == webhookAttempts {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends one attempt and reports whether a failure is worth retrying
+func (w *Webhooks) post(cfg WebhookConfig, event AuditEvent, delivery string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for name, value := range cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gonder-audit-webhook")
+	req.Header.Set(WebhookEventHeader, string(event.EventType))
+	req.Header.Set(WebhookDeliveryHeader, delivery)
+	if cfg.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(cfg.Secret))
+		mac.Write(body)
+		req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook answered %s", strings.TrimSpace(resp.Status))
+}
+
+// status returns the webhook's counters
+func (h *webhook) status() WebhookStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status := WebhookStatus{
+		Name:      h.config.Name,
+		URL:       redactURL(h.config.URL),
+		Events:    h.config.Events,
+		Signed:    h.config.Secret != "",
+		Delivered: h.delivered.Load(),
+		Failed:    h.failed.Load(),
+		Dropped:   h.dropped.Load(),
+		LastError: h.lastError,
+	}
+	if !h.lastDelivery.IsZero() {
+		last := h.lastDelivery
+		status.LastDelivery = &last
+	}
+	return status
+}
+
+// redactURL hides a password in a webhook URL
+func redactURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return parsed.Redacted()
+}
@@ -0,0 +1,331 @@
+// Package backfill ingests historical log files as background jobs,
+// separately from the live sources: the files are read once from the start
+// (gzip files are decompressed), parsed with the job's source configuration
+// and sent through the collector outputs like live lines. Backfills run on
+// the job manager, which tracks their progress and lets them be cancelled;
+// they can be rate limited.
+package backfill
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/jobs"
+)
+
+const (
+	// Kind is the job kind of backfills
+	Kind = "backfill"
+	// maxFiles bounds the files of one job after glob expansion
+	maxFiles = 10000
+	// maxLineSize is the longest line read; longer lines fail the file
+	maxLineSize = 1024 * 1024
+)
+
+// Request describes a backfill
+type Request struct {
+	// Paths are files or glob patterns; files ending in .gz are
+	// decompressed
+	Paths []string `json:"paths"`
+	// Source is the source configuration the lines are parsed with; its
+	// name tags the entries and their fingerprints. Path and interval are
+	// not used.
+	Source collector.LogSourceConfig `json:"source"`
+	// Rate limits the lines ingested per second; 0 is unlimited
+	Rate int `json:"rate,omitempty"`
+	// From and To limit a backfill of a single uncompressed file to the
+	// lines starting in the byte range [From, To); To 0 reads to the end.
+	// The lines keep their offsets, so their fingerprints match those of
+	// the lines once tailed from the file under the same source name.
+	From int64 `json:"from,omitempty"`
+	To   int64 `json:"to,omitempty"`
+}
+
+// FileStatus is the outcome of one file
+type FileStatus struct {
+	Path  string `json:"path"`
+	Lines uint64 `json:"lines"`
+	Error string `json:"error,omitempty"`
+}
+
+// job is the progress of a running backfill
+type job struct {
+	request Request
+	files   []string
+	total   int64
+
+	bytesRead atomic.Int64
+	lines     atomic.Uint64
+	unmatched atomic.Uint64
+
+	mu      sync.Mutex
+	current string
+	results []FileStatus
+}
+
+// Backfiller starts backfills into a collector
+type Backfiller struct {
+	collector *collector.LogCollector
+	jobs      *jobs.Manager
+}
+
+// New creates a backfiller ingesting into lc and running on manager
+func New(lc *collector.LogCollector, manager *jobs.Manager) *Backfiller {
+	return &Backfiller{collector: lc, jobs: manager}
+}
+
+// Start validates req, expands its globs and starts the job in the
+// background
+func (b *Backfiller) Start(req Request) (jobs.Record, error) {
+	if len(req.Paths) == 0 {
+		return jobs.Record{}, fmt.Errorf("paths are required")
+	}
+	if req.Rate < 0 {
+		return jobs.Record{}, fmt.Errorf("rate must not be negative")
+	}
+	if req.From < 0 || req.To < 0 || (req.To > 0 && req.To < req.From) {
+		return jobs.Record{}, fmt.Errorf("invalid byte range %d-%d", req.From, req.To)
+	}
+	source := req.Source
+	if source.Name == "" {
+		source.Name = "backfill"
+	}
+	// Path and interval only matter for tailing
+	check := source
+	check.Path, check.Interval = "-", 1
+	if err := check.Validate(); err != nil {
+		return jobs.Record{}, err
+	}
+	req.Source = source
+
+	files, err := expand(req.Paths)
+	if err != nil {
+		return jobs.Record{}, err
+	}
+	ranged := req.From > 0 || req.To > 0
+	if ranged && (len(files) != 1 || strings.HasSuffix(files[0], ".gz")) {
+		return jobs.Record{}, fmt.Errorf("a byte range needs a single uncompressed file")
+	}
+	var total int64
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return jobs.Record{}, err
+		}
+		if !info.Mode().IsRegular() {
+			return jobs.Record{}, fmt.Errorf("%s is not a regular file", file)
+		}
+		total += info.Size()
+	}
+	if ranged {
+		if req.To > 0 && req.To < total {
+			total = req.To
+		}
+		if total -= req.From; total < 0 {
+			return jobs.Record{}, fmt.Errorf("%s is shorter than offset %d", files[0], req.From)
+		}
+	}
+
+	j := &job{request: req, files: files, total: total}
+	return b.jobs.Start(Kind, req, func(ctx context.Context, handle *jobs.Job) error {
+		handle.Report(j.progress)
+		return b.run(ctx, j)
+	})
+}
+
+// expand resolves the glob patterns into a sorted list of distinct files
+func expand(patterns []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			return nil, fmt.Errorf("path must be absolute: %q", pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %s", pattern)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+	if len(files) > maxFiles {
+		return nil, fmt.Errorf("%d files match, at most %d can be backfilled at once", len(files), maxFiles)
+	}
+	return files, nil
+}
+
+// run ingests the files of j one after the other
+func (b *Backfiller) run(ctx context.Context, j *job) error {
+	limiter := newPacer(j.request.Rate)
+	failed := 0
+	for _, path := range j.files {
+		j.mu.Lock()
+		j.current = path
+		j.mu.Unlock()
+
+		lines, err := b.ingestFile(ctx, j, path, limiter)
+		result := FileStatus{Path: path, Lines: lines}
+		if err != nil && ctx.Err() == nil {
+			result.Error = err.Error()
+			failed++
+		}
+		j.mu.Lock()
+		j.results = append(j.results, result)
+		j.mu.Unlock()
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	j.mu.Lock()
+	j.current = ""
+	j.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(j.files))
+	}
+	return nil
+}
+
+// ingestFile reads one file into the collector and returns its line count
+func (b *Backfiller) ingestFile(ctx context.Context, j *job, path string, limiter *pacer) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	offset := j.request.From
+	if offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+	}
+
+	var r io.Reader = &countingReader{r: file, n: &j.bytesRead}
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	config := j.request.Source
+	config.Path = path
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	var lines uint64
+	for scanner.Scan() {
+		if j.request.To > 0 && offset >= j.request.To {
+			break
+		}
+		if err := limiter.wait(ctx); err != nil {
+			return lines, err
+		}
+		line := scanner.Text()
+		// Offsets give backfilled entries fingerprints, so a file
+		// backfilled twice under the same source name can be deduplicated
+		status := b.collector.IngestLineAt(line, offset, config)
+		offset += int64(len(line)) + 1
+		if status == collector.ParseSkipped {
+			continue
+		}
+		lines++
+		j.lines.Add(1)
+		if status == collector.ParseUnmatched {
+			j.unmatched.Add(1)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// progress reports the bytes read; bytes are those of the files on disk,
+// so gzip files count compressed bytes
+func (j *job) progress() jobs.Progress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return jobs.Progress{
+		// A ranged read buffers past its end
+		Done:  min(j.bytesRead.Load(), j.total),
+		Total: j.total,
+		Unit:  "bytes",
+		Details: map[string]interface{}{
+			"files_total":  len(j.files),
+			"files_done":   len(j.results),
+			"lines":        j.lines.Load(),
+			"unmatched":    j.unmatched.Load(),
+			"current_file": j.current,
+			"files":        append([]FileStatus{}, j.results...),
+		},
+	}
+}
+
+// countingReader adds the bytes read to n
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// pacer spaces lines to a rate per second
+type pacer struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func newPacer(rate int) *pacer {
+	if rate <= 0 {
+		return &pacer{}
+	}
+	return &pacer{interval: time.Second / time.Duration(rate)}
+}
+
+// wait blocks until the next line may be ingested or ctx is done
+func (p *pacer) wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil || p.interval == 0 {
+		return err
+	}
+	now := time.Now()
+	if p.next.Before(now) {
+		// Don't let an idle period build up a burst
+		p.next = now
+	}
+	if delay := p.next.Sub(now); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	p.next = p.next.Add(p.interval)
+	return nil
+}
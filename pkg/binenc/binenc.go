@@ -0,0 +1,285 @@
+// Package binenc encodes API responses as MessagePack (https://msgpack.org/)
+// or CBOR (RFC 8949), for machine consumers that find JSON too large or too
+// slow to decode. Values are shaped like their JSON: maps keyed by the JSON
+// field names, with the fields JSON leaves out when empty left out too.
+// Times are native timestamps, the MessagePack timestamp extension and
+// CBOR's RFC 3339 date/time tag, so they decode without string parsing.
+package binenc
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// Format is a binary encoding of responses
+type Format struct {
+	// Name is the value of the format query parameter
+	Name string
+	// ContentType is the media type of a single value
+	ContentType string
+	// StreamContentType is the media type of a sequence of values, as
+	// written by streaming endpoints
+	StreamContentType string
+	// accept lists the media types selecting the format in Accept
+	accept []string
+	enc    encoder
+}
+
+// The supported formats
+var (
+	MsgPack = &Format{
+		Name:              "msgpack",
+		ContentType:       "application/msgpack",
+		StreamContentType: "application/msgpack",
+		accept:            []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"},
+		enc:               msgpackEncoder{},
+	}
+	CBOR = &Format{
+		Name:              "cbor",
+		ContentType:       "application/cbor",
+		StreamContentType: "application/cbor-seq",
+		accept:            []string{"application/cbor", "application/cbor-seq"},
+		enc:               cborEncoder{},
+	}
+)
+
+var formats = []*Format{MsgPack, CBOR}
+
+// ByName returns the format with the given name, or nil
+func ByName(name string) *Format {
+	for _, f := range formats {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Negotiate returns the format an Accept header asks for, or nil when it
+// prefers JSON or names neither format. Media types are taken in the
+// order listed; those with q=0 are skipped.
+func Negotiate(accept string) *Format {
+	for _, item := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		if mediaType == "application/json" || mediaType == "application/x-ndjson" {
+			return nil
+		}
+		for _, f := range formats {
+			for _, t := range f.accept {
+				if mediaType == t {
+					return f
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// encoder appends the items of one format
+type encoder interface {
+	appendNil(b []byte) []byte
+	appendBool(b []byte, v bool) []byte
+	appendInt(b []byte, v int64) []byte
+	appendFloat(b []byte, v float64) []byte
+	appendString(b []byte, s string) []byte
+	appendTime(b []byte, t time.Time) []byte
+	appendArrayHeader(b []byte, n int) []byte
+	appendMapHeader(b []byte, n int) []byte
+}
+
+// Map is a map written with its keys in order, like a Go struct through
+// encoding/json
+type Map []KeyValue
+
+// KeyValue is an entry of a Map
+type KeyValue struct {
+	Key   string
+	Value interface{}
+}
+
+// Append appends v. Maps, slices, strings, numbers, booleans, times, Maps
+// and log entries are written directly; anything else is written as the
+// value its JSON decodes to.
+func (f *Format) Append(b []byte, v interface{}) ([]byte, error) {
+	enc := f.enc
+	switch v := v.(type) {
+	case nil:
+		return enc.appendNil(b), nil
+	case bool:
+		return enc.appendBool(b, v), nil
+	case int:
+		return enc.appendInt(b, int64(v)), nil
+	case int64:
+		return enc.appendInt(b, v), nil
+	case float64:
+		return enc.appendFloat(b, v), nil
+	case string:
+		return enc.appendString(b, v), nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return enc.appendInt(b, n), nil
+		}
+		n, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return enc.appendFloat(b, n), nil
+	case time.Time:
+		return enc.appendTime(b, v), nil
+	case []string:
+		b = enc.appendArrayHeader(b, len(v))
+		for _, s := range v {
+			b = enc.appendString(b, s)
+		}
+		return b, nil
+	case []interface{}:
+		b = enc.appendArrayHeader(b, len(v))
+		var err error
+		for _, item := range v {
+			if b, err = f.Append(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = enc.appendMapHeader(b, len(v))
+		var err error
+		for key, item := range v {
+			b = enc.appendString(b, key)
+			if b, err = f.Append(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case Map:
+		b = enc.appendMapHeader(b, len(v))
+		var err error
+		for _, kv := range v {
+			b = enc.appendString(b, kv.Key)
+			if b, err = f.Append(b, kv.Value); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case *collector.SystemLog:
+		return f.Append(b, entryMap(v, nil, ""))
+	case *collector.ContextLine:
+		return f.Append(b, entryMap(&v.SystemLog, v.Offset, v.SourceName))
+	case []collector.ContextLine:
+		b = enc.appendArrayHeader(b, len(v))
+		var err error
+		for i := range v {
+			if b, err = f.Append(b, &v[i]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case *collector.LogContext:
+		return f.Append(b, Map{
+			{"source", v.Source},
+			{"before", v.Before},
+			{"entry", &v.Entry},
+			{"after", v.After},
+		})
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	return f.Append(b, decoded)
+}
+
+// AppendArrayHeader appends the start of an array of n items, for writing
+// the items one at a time
+func (f *Format) AppendArrayHeader(b []byte, n int) []byte {
+	return f.enc.appendArrayHeader(b, n)
+}
+
+// AppendMapHeader appends the start of a map of n entries, each written as
+// its key followed by its value
+func (f *Format) AppendMapHeader(b []byte, n int) []byte {
+	return f.enc.appendMapHeader(b, n)
+}
+
+// AppendString appends a string, e.g. a map key
+func (f *Format) AppendString(b []byte, s string) []byte {
+	return f.enc.appendString(b, s)
+}
+
+// AppendInt appends an integer
+func (f *Format) AppendInt(b []byte, v int64) []byte {
+	return f.enc.appendInt(b, v)
+}
+
+// entryMap is an entry with the keys and omissions of its JSON
+func entryMap(entry *collector.SystemLog, offset *int64, sourceName string) Map {
+	m := Map{
+		{"id", entry.ID},
+		{"timestamp", entry.Timestamp},
+	}
+	if entry.OriginalTimestamp != "" {
+		m = append(m, KeyValue{"original_timestamp", entry.OriginalTimestamp})
+	}
+	m = append(m,
+		KeyValue{"source", string(entry.Source)},
+		KeyValue{"level", string(entry.Level)},
+		KeyValue{"message", entry.Message},
+	)
+	for _, kv := range []KeyValue{
+		{"host", entry.Host},
+		{"service", entry.Service},
+	} {
+		if kv.Value != "" {
+			m = append(m, kv)
+		}
+	}
+	if entry.PID != 0 {
+		m = append(m, KeyValue{"pid", entry.PID})
+	}
+	for _, kv := range []KeyValue{
+		{"user", entry.User},
+		{"ip", entry.IP},
+		{"method", entry.Method},
+		{"path", entry.Path},
+	} {
+		if kv.Value != "" {
+			m = append(m, kv)
+		}
+	}
+	if entry.StatusCode != 0 {
+		m = append(m, KeyValue{"status_code", entry.StatusCode})
+	}
+	m = append(m, KeyValue{"raw_log", entry.RawLog})
+	if len(entry.ParsedData) > 0 {
+		m = append(m, KeyValue{"parsed_data", entry.ParsedData})
+	}
+	if len(entry.Tags) > 0 {
+		m = append(m, KeyValue{"tags", entry.Tags})
+	}
+	m = append(m, KeyValue{"collected_at", entry.CollectedAt})
+	if entry.Fingerprint != "" {
+		m = append(m, KeyValue{"fingerprint", entry.Fingerprint})
+	}
+	if offset != nil {
+		m = append(m, KeyValue{"offset", *offset})
+	}
+	if sourceName != "" {
+		m = append(m, KeyValue{"source_name", sourceName})
+	}
+	return m
+}
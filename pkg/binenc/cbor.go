@@ -0,0 +1,81 @@
+package binenc
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// cborEncoder writes CBOR with definite lengths and the shortest argument
+// encodings, the preferred serialization of RFC 8949 section 4.1
+type cborEncoder struct{}
+
+// Major types of CBOR
+const (
+	cborUint   = 0 << 5
+	cborNegint = 1 << 5
+	cborText   = 3 << 5
+	cborArray  = 4 << 5
+	cborMap    = 5 << 5
+	cborTag    = 6 << 5
+)
+
+// cborDateTime is the tag of RFC 3339 date/time strings
+const cborDateTime = 0
+
+// appendHead appends the initial byte of a major type with its argument
+func appendHead(b []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, major|27), n)
+}
+
+func (cborEncoder) appendNil(b []byte) []byte {
+	return append(b, 0xf6)
+}
+
+func (cborEncoder) appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 0xf5)
+	}
+	return append(b, 0xf4)
+}
+
+func (cborEncoder) appendInt(b []byte, v int64) []byte {
+	if v >= 0 {
+		return appendHead(b, cborUint, uint64(v))
+	}
+	return appendHead(b, cborNegint, uint64(-1-v))
+}
+
+func (cborEncoder) appendFloat(b []byte, v float64) []byte {
+	return binary.BigEndian.AppendUint64(append(b, 0xfb), math.Float64bits(v))
+}
+
+func (cborEncoder) appendString(b []byte, s string) []byte {
+	b = appendHead(b, cborText, uint64(len(s)))
+	return append(b, s...)
+}
+
+// appendTime appends a tagged RFC 3339 string, which keeps nanoseconds an
+// epoch float would round
+func (e cborEncoder) appendTime(b []byte, t time.Time) []byte {
+	b = appendHead(b, cborTag, cborDateTime)
+	return e.appendString(b, t.Format(time.RFC3339Nano))
+}
+
+func (cborEncoder) appendArrayHeader(b []byte, n int) []byte {
+	return appendHead(b, cborArray, uint64(n))
+}
+
+func (cborEncoder) appendMapHeader(b []byte, n int) []byte {
+	return appendHead(b, cborMap, uint64(n))
+}
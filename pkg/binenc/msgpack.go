@@ -0,0 +1,101 @@
+package binenc
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// msgpackEncoder writes the MessagePack format, using the smallest
+// representation of every value as the specification recommends
+type msgpackEncoder struct{}
+
+// msgpackTimestamp is the extension type of timestamps
+const msgpackTimestamp = 0xff // -1
+
+func (msgpackEncoder) appendNil(b []byte) []byte {
+	return append(b, 0xc0)
+}
+
+func (msgpackEncoder) appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 0xc3)
+	}
+	return append(b, 0xc2)
+}
+
+func (msgpackEncoder) appendInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0 && v < 128:
+		return append(b, byte(v))
+	case v >= 0 && v <= math.MaxUint8:
+		return append(b, 0xcc, byte(v))
+	case v >= 0 && v <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(v))
+	case v >= 0 && v <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(v))
+	case v >= 0:
+		return binary.BigEndian.AppendUint64(append(b, 0xcf), uint64(v))
+	case v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt8:
+		return append(b, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(v))
+	case v >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v))
+}
+
+func (msgpackEncoder) appendFloat(b []byte, v float64) []byte {
+	return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(v))
+}
+
+func (msgpackEncoder) appendString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+// appendTime appends a timestamp extension: 64 bits of nanoseconds and
+// seconds for times from 1970 to 2514, 96 bits for the rest
+func (msgpackEncoder) appendTime(b []byte, t time.Time) []byte {
+	seconds, nanos := t.Unix(), uint64(t.Nanosecond())
+	if seconds >= 0 && seconds < 1<<34 {
+		b = append(b, 0xd7, msgpackTimestamp)
+		return binary.BigEndian.AppendUint64(b, nanos<<34|uint64(seconds))
+	}
+	b = append(b, 0xc7, 12, msgpackTimestamp)
+	b = binary.BigEndian.AppendUint32(b, uint32(nanos))
+	return binary.BigEndian.AppendUint64(b, uint64(seconds))
+}
+
+func (msgpackEncoder) appendArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xdc), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdd), uint32(n))
+}
+
+func (msgpackEncoder) appendMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdf), uint32(n))
+}
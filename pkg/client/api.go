@@ -0,0 +1,379 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/alertmanager"
+	"github.com/ercansavas/gonder/pkg/cluster"
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/fleet"
+	"github.com/ercansavas/gonder/pkg/otlp"
+)
+
+// Health is the /api/health response
+type Health struct {
+	Status    string `json:"status"`
+	Timestamp string `json:"timestamp"`
+	Version   string `json:"version"`
+	Uptime    string `json:"uptime"`
+	Purpose   string `json:"purpose"`
+}
+
+// CollectorStatus is the /api/logs/status response
+type CollectorStatus struct {
+	Running        bool                           `json:"running"`
+	TotalSources   int                            `json:"total_sources"`
+	EnabledSources int                            `json:"enabled_sources"`
+	Sources        []collector.LogSourceConfig    `json:"sources"`
+	SourceStatus   []collector.SourceStatus       `json:"source_status"`
+	SelfMonitor    collector.SelfMonitorStatus    `json:"self_monitor"`
+	Subscriptions  []collector.SubscriptionStatus `json:"subscriptions"`
+}
+
+// Health checks that the server is up
+func (c *Client) Health(ctx context.Context) (*Health, error) {
+	var health Health
+	if err := c.doJSON(ctx, http.MethodGet, "/api/health", "", nil, &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// Status returns the state of the log collector and its sources
+func (c *Client) Status(ctx context.Context) (*CollectorStatus, error) {
+	var resp struct {
+		envelope
+		Status CollectorStatus `json:"status"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/api/logs/status", "", nil, &resp); err != nil {
+		return nil, err
+	}
+	if err := checkSuccess(resp.envelope); err != nil {
+		return nil, err
+	}
+	return &resp.Status, nil
+}
+
+// Sources returns every configured source with its runtime state
+func (c *Client) Sources(ctx context.Context) ([]collector.SourceSnapshot, error) {
+	var resp struct {
+		envelope
+		Data []collector.SourceSnapshot `json:"data"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/api/logs/sources", "", nil, &resp); err != nil {
+		return nil, err
+	}
+	if err := checkSuccess(resp.envelope); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// StartCollector starts log collection. Starting a running collector
+// returns an APIError.
+func (c *Client) StartCollector(ctx context.Context) error {
+	return c.post(ctx, "/api/logs/start")
+}
+
+// StopCollector stops log collection. Stopping a stopped collector returns
+// an APIError.
+func (c *Client) StopCollector(ctx context.Context) error {
+	return c.post(ctx, "/api/logs/stop")
+}
+
+func (c *Client) post(ctx context.Context, path string) error {
+	var resp envelope
+	if err := c.doJSON(ctx, http.MethodPost, path, "", nil, &resp); err != nil {
+		return err
+	}
+	return checkSuccess(resp)
+}
+
+// Entry is a log entry sent with Ingest
+type Entry struct {
+	// Timestamp defaults to the time of the call
+	Timestamp time.Time
+	// Level is one of debug, info, warn, error or fatal; when empty the
+	// server detects it from the message
+	Level   string
+	Message string
+	Service string
+	Host    string
+	// Attributes are kept in the entry's parsed_data
+	Attributes map[string]interface{}
+	TraceID    string
+	SpanID     string
+}
+
+// severityNumbers maps levels to the first OTLP severity number of each range
+var severityNumbers = map[string]int32{
+	"debug": 5,
+	"info":  9,
+	"warn":  13,
+	"error": 17,
+	"fatal": 21,
+}
+
+// Ingest sends log entries through the server's OTLP/HTTP receiver. Entries
+// are grouped into one resource per service and host. A retried request may
+// deliver entries twice.
+func (c *Client) Ingest(ctx context.Context, entries ...Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	type resourceKey struct{ service, host string }
+	index := map[resourceKey]int{}
+	req := otlp.ExportLogsRequest{}
+	now := time.Now()
+
+	for _, entry := range entries {
+		key := resourceKey{entry.Service, entry.Host}
+		i, ok := index[key]
+		if !ok {
+			resource := map[string]interface{}{}
+			if entry.Service != "" {
+				resource["service.name"] = entry.Service
+			}
+			if entry.Host != "" {
+				resource["host.name"] = entry.Host
+			}
+			i = len(req.ResourceLogs)
+			index[key] = i
+			req.ResourceLogs = append(req.ResourceLogs, otlp.ResourceLogs{
+				Resource:  otlp.Resource{Attributes: otlp.Attributes(resource)},
+				ScopeLogs: []otlp.ScopeLogs{{Scope: otlp.InstrumentationScope{Name: "gonder-client"}}},
+			})
+		}
+
+		timestamp := entry.Timestamp
+		if timestamp.IsZero() {
+			timestamp = now
+		}
+		body := otlp.Value(entry.Message)
+		scope := &req.ResourceLogs[i].ScopeLogs[0]
+		scope.LogRecords = append(scope.LogRecords, otlp.LogRecord{
+			TimeUnixNano:   otlp.Uint64(timestamp.UnixNano()),
+			SeverityNumber: severityNumbers[entry.Level],
+			SeverityText:   entry.Level,
+			Body:           &body,
+			Attributes:     otlp.Attributes(entry.Attributes),
+			TraceID:        entry.TraceID,
+			SpanID:         entry.SpanID,
+		})
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, request{
+		method:      http.MethodPost,
+		path:        "/v1/logs",
+		token:       c.cfg.IngestToken,
+		contentType: otlp.ContentTypeJSON,
+		body:        data,
+	})
+	return err
+}
+
+// SendAlerts posts alerts in the Alertmanager webhook format; each becomes
+// a log entry with source "alertmanager"
+func (c *Client) SendAlerts(ctx context.Context, alerts ...alertmanager.Alert) error {
+	if len(alerts) == 0 {
+		return nil
+	}
+	for i := range alerts {
+		if alerts[i].Status == "" {
+			alerts[i].Status = alertmanager.StatusFiring
+		}
+		if alerts[i].StartsAt.IsZero() {
+			alerts[i].StartsAt = time.Now()
+		}
+	}
+
+	webhook := alertmanager.Webhook{
+		Version:  "4",
+		Status:   alerts[0].Status,
+		Receiver: "gonder-client",
+		Alerts:   alerts,
+	}
+	var resp envelope
+	if err := c.doJSON(ctx, http.MethodPost, "/api/alerts/alertmanager", c.cfg.IngestToken, webhook, &resp); err != nil {
+		return err
+	}
+	return checkSuccess(resp)
+}
+
+// ClusterStatus returns cluster membership and leadership
+func (c *Client) ClusterStatus(ctx context.Context) (*cluster.Status, error) {
+	var resp struct {
+		envelope
+		Data cluster.Status `json:"data"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/api/cluster/status", "", nil, &resp); err != nil {
+		return nil, err
+	}
+	if err := checkSuccess(resp.envelope); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// Agents lists the agents known to an aggregator (admin token)
+func (c *Client) Agents(ctx context.Context) ([]fleet.AgentInfo, error) {
+	var resp struct {
+		envelope
+		Data []fleet.AgentInfo `json:"data"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/api/agents", c.cfg.Token, nil, &resp); err != nil {
+		return nil, err
+	}
+	if err := checkSuccess(resp.envelope); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// AgentConfig returns the configuration pushed to an agent; the ID
+// "default" addresses the fleet-wide default (admin token)
+func (c *Client) AgentConfig(ctx context.Context, id string) (*fleet.AgentConfig, error) {
+	var resp struct {
+		envelope
+		Data fleet.AgentConfig `json:"data"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, agentConfigPath(id), c.cfg.Token, nil, &resp); err != nil {
+		return nil, err
+	}
+	if err := checkSuccess(resp.envelope); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// SetAgentConfig pushes configuration to an agent and returns the stored
+// version (admin token). config.Version is the version the change is based
+// on, as returned by AgentConfig, or 0 when the agent has none yet; a 412
+// APIError means it was changed in the meantime.
+func (c *Client) SetAgentConfig(ctx context.Context, id string, config fleet.AgentConfig) (*fleet.AgentConfig, error) {
+	var resp struct {
+		envelope
+		Data fleet.AgentConfig `json:"data"`
+	}
+	header := versionPrecondition(config.Version)
+	if err := c.doJSONHeader(ctx, http.MethodPut, agentConfigPath(id), c.cfg.Token, header, config, &resp); err != nil {
+		return nil, err
+	}
+	if err := checkSuccess(resp.envelope); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// DeleteAgentConfig removes the configuration pushed to an agent if it is
+// still at version (admin token)
+func (c *Client) DeleteAgentConfig(ctx context.Context, id string, version int64) error {
+	var resp envelope
+	header := http.Header{"If-Match": {versionETag(version)}}
+	if err := c.doJSONHeader(ctx, http.MethodDelete, agentConfigPath(id), c.cfg.Token, header, nil, &resp); err != nil {
+		return err
+	}
+	return checkSuccess(resp)
+}
+
+// Maintenance returns the maintenance mode and drain progress (admin
+// token)
+func (c *Client) Maintenance(ctx context.Context) (*collector.MaintenanceStatus, error) {
+	return c.maintenance(ctx, http.MethodGet, nil)
+}
+
+// SetMaintenance enters or leaves maintenance mode (admin token). Entering
+// returns while the outputs drain; poll Maintenance until the phase is
+// collector.MaintenanceDrained.
+func (c *Client) SetMaintenance(ctx context.Context, enabled bool, reason string) (*collector.MaintenanceStatus, error) {
+	return c.maintenance(ctx, http.MethodPost, map[string]interface{}{"enabled": enabled, "reason": reason})
+}
+
+func (c *Client) maintenance(ctx context.Context, method string, in interface{}) (*collector.MaintenanceStatus, error) {
+	var resp struct {
+		envelope
+		Data collector.MaintenanceStatus `json:"data"`
+	}
+	if err := c.doJSON(ctx, method, "/api/admin/maintenance", c.cfg.Token, in, &resp); err != nil {
+		return nil, err
+	}
+	if err := checkSuccess(resp.envelope); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// versionPrecondition makes a change apply only to the given version of a
+// resource, or only while there is none for version 0
+func versionPrecondition(version int64) http.Header {
+	if version == 0 {
+		return http.Header{"If-None-Match": {"*"}}
+	}
+	return http.Header{"If-Match": {versionETag(version)}}
+}
+
+func versionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+func agentConfigPath(id string) string {
+	return "/api/agents/" + url.PathEscape(id) + "/config"
+}
+
+// SearchOptions selects entries for Search
+type SearchOptions struct {
+	// Query uses the query language, see collector.ParseQuery
+	Query string
+	// Sources are source names, e.g. "auth_log"; empty searches all
+	Sources []string
+	// Since is how far back to search; zero searches everything kept
+	Since time.Duration
+	// Limit is the most entries returned, the newest ones; zero uses the
+	// server default
+	Limit int
+	// Cursor is the NextCursor of the previous page, for older entries
+	Cursor string
+}
+
+// Search returns the matching entries among the recent entries the server
+// keeps per source (CONTEXT_BUFFER), oldest first. It needs the admin token.
+func (c *Client) Search(ctx context.Context, opts SearchOptions) (*collector.SearchResult, error) {
+	params := url.Values{}
+	if opts.Query != "" {
+		params.Set("q", opts.Query)
+	}
+	for _, source := range opts.Sources {
+		params.Add("source", source)
+	}
+	if opts.Since > 0 {
+		params.Set("since", opts.Since.String())
+	}
+	if opts.Limit > 0 {
+		params.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Cursor != "" {
+		params.Set("cursor", opts.Cursor)
+	}
+
+	var resp struct {
+		envelope
+		Data collector.SearchResult `json:"data"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/api/logs/search?"+params.Encode(), c.cfg.Token, nil, &resp); err != nil {
+		return nil, err
+	}
+	if err := checkSuccess(resp.envelope); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
@@ -0,0 +1,279 @@
+// Package client is a Go client for the gonder HTTP API. It covers log
+// ingestion (over the OTLP/HTTP receiver), alert submission, live log
+// streams, collector and source management and the agent fleet, with bearer
+// authentication and retries of transient failures.
+//
+//	c, err := client.New(client.Config{BaseURL: "http://gonder:8080", IngestToken: token})
+//	err = c.Ingest(ctx, client.Entry{Level: "error", Service: "checkout", Message: "payment failed"})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Defaults applied by New to unset Config fields
+const (
+	DefaultTimeout      = 10 * time.Second
+	DefaultMaxRetries   = 3
+	DefaultRetryBackoff = 200 * time.Millisecond
+)
+
+// maxErrorBody caps how much of an error response is kept in an APIError
+const maxErrorBody = 4096
+
+// Config configures a Client
+type Config struct {
+	// BaseURL is the server address, e.g. http://gonder:8080
+	BaseURL string
+	// Token is sent to admin endpoints (ADMIN_TOKEN on the server)
+	Token string
+	// IngestToken is sent to push endpoints (INGEST_TOKEN on the server);
+	// Token is used when empty
+	IngestToken string
+
+	// MaxRetries is the number of attempts for requests failing with a
+	// network error, 429 or a 5xx status. 1 disables retries.
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// Timeout applies to each attempt when HTTPClient is not set
+	Timeout    time.Duration
+	HTTPClient *http.Client
+	UserAgent  string
+}
+
+// Client calls the gonder API. It is safe for concurrent use.
+type Client struct {
+	cfg     Config
+	baseURL string
+	http    *http.Client
+}
+
+// New creates a client
+func New(cfg Config) (*Client, error) {
+	u, err := url.Parse(cfg.BaseURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q", cfg.BaseURL)
+	}
+	if cfg.IngestToken == "" {
+		cfg.IngestToken = cfg.Token
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = DefaultMaxRetries
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = DefaultRetryBackoff
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = "gonder-client"
+	}
+
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: cfg.Timeout}
+	}
+
+	return &Client{
+		cfg:     cfg,
+		baseURL: strings.TrimRight(cfg.BaseURL, "/"),
+		http:    httpClient,
+	}, nil
+}
+
+// APIError is a non-2xx response, or a response with "success": false
+type APIError struct {
+	StatusCode int
+	// Code is the machine-readable error code, e.g. "not_found"; it is
+	// empty for responses without the JSON error envelope
+	Code      string
+	Message   string
+	RequestID string
+	Details   interface{}
+}
+
+func (e *APIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("gonder: %d %s: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("gonder: %d %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 response
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// retryable reports whether a failed attempt may succeed when repeated
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500 || apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// request describes one API call
+type request struct {
+	method      string
+	path        string
+	token       string
+	contentType string
+	header      http.Header
+	body        []byte
+}
+
+// doJSON sends a request with an optional JSON body and decodes the JSON
+// response into out, when not nil
+func (c *Client) doJSON(ctx context.Context, method, path, token string, in, out interface{}) error {
+	return c.doJSONHeader(ctx, method, path, token, nil, in, out)
+}
+
+// doJSONHeader is doJSON with extra request headers, e.g. preconditions
+func (c *Client) doJSONHeader(ctx context.Context, method, path, token string, header http.Header, in, out interface{}) error {
+	req := request{method: method, path: path, token: token, header: header}
+	if in != nil {
+		body, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		req.body = body
+		req.contentType = "application/json"
+	}
+
+	data, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", path, err)
+	}
+	return nil
+}
+
+// do sends a request, retrying transient failures with exponential backoff,
+// and returns the response body
+func (c *Client) do(ctx context.Context, req request) ([]byte, error) {
+	backoff := c.cfg.RetryBackoff
+	var err error
+	for attempt := 1; attempt <= c.cfg.MaxRetries; attempt++ {
+		var data []byte
+		data, err = c.attempt(ctx, req)
+		if err == nil {
+			return data, nil
+		}
+		if ctx.Err() != nil || !retryable(err) || attempt == c.cfg.MaxRetries {
+			break
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+	return nil, err
+}
+
+func (c *Client) attempt(ctx context.Context, req request) ([]byte, error) {
+	var body io.Reader
+	if req.body != nil {
+		body = bytes.NewReader(req.body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, c.baseURL+req.path, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range req.header {
+		httpReq.Header[name] = values
+	}
+	if req.contentType != "" {
+		httpReq.Header.Set("Content-Type", req.contentType)
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	httpReq.Header.Set("User-Agent", c.cfg.UserAgent)
+	if req.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+req.token)
+	}
+
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, responseError(resp.StatusCode, data)
+	}
+	return data, nil
+}
+
+// responseError builds the APIError of a non-2xx response body
+func responseError(status int, data []byte) *APIError {
+	var env envelope
+	if json.Unmarshal(data, &env) == nil && env.Error != nil {
+		return env.apiError(status)
+	}
+	message := strings.TrimSpace(string(data))
+	if len(message) > maxErrorBody {
+		message = message[:maxErrorBody]
+	}
+	return &APIError{StatusCode: status, Message: message}
+}
+
+// envelope is the common {"success": ..., "message": ...} response wrapper;
+// errors carry an "error" object
+type envelope struct {
+	Success *bool          `json:"success"`
+	Message string         `json:"message"`
+	Error   *errorEnvelope `json:"error"`
+}
+
+type errorEnvelope struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	RequestID string      `json:"request_id"`
+	Details   interface{} `json:"details"`
+}
+
+func (env envelope) apiError(status int) *APIError {
+	if env.Error == nil {
+		return &APIError{StatusCode: status, Message: env.Message}
+	}
+	return &APIError{
+		StatusCode: status,
+		Code:       env.Error.Code,
+		Message:    env.Error.Message,
+		RequestID:  env.Error.RequestID,
+		Details:    env.Error.Details,
+	}
+}
+
+// checkSuccess turns a 200 response with "success": false into an APIError
+func checkSuccess(env envelope) error {
+	if env.Success != nil && !*env.Success {
+		return env.apiError(http.StatusOK)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// maxStreamEvent bounds one server-sent event of a log stream
+const maxStreamEvent = 4 << 20
+
+// Tail streams the entries matching query (see collector.ParseQuery) as
+// they are processed, calling fn for each until ctx is done, fn returns an
+// error or the server ends the stream (io.EOF). It needs the admin token.
+// The stream is not retried: entries processed while disconnected are
+// missed.
+func (c *Client) Tail(ctx context.Context, query string, fn func(collector.SystemLog) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/logs/stream?q="+url.QueryEscape(query), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("User-Agent", c.cfg.UserAgent)
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+
+	// The timeout covers reading the whole body, which never ends
+	streamClient := *c.http
+	streamClient.Timeout = 0
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return responseError(resp.StatusCode, data)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxStreamEvent)
+	var event, data string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// End of an event
+			if event == "log" && data != "" {
+				var entry collector.SystemLog
+				if err := json.Unmarshal([]byte(data), &entry); err != nil {
+					return fmt.Errorf("invalid stream entry: %w", err)
+				}
+				if err := fn(entry); err != nil {
+					return err
+				}
+			}
+			event, data = "", ""
+		case strings.HasPrefix(line, ":"):
+			// Comment, e.g. keep-alive
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			value := strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+			if data != "" {
+				data += "\n"
+			}
+			data += value
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
@@ -0,0 +1,350 @@
+package cluster
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// Store keys
+const (
+	leaderKey      = "leader"
+	memberPrefix   = "members/"
+	batchPrefix    = "batches/"
+	documentPrefix = "documents/"
+)
+
+// Default cluster settings
+const (
+	DefaultLeaseTTL    = 15 * time.Second
+	DefaultDedupWindow = 10 * time.Minute
+)
+
+// Config configures a cluster node
+type Config struct {
+	NodeID  string
+	Address string
+	Version string
+	// LeaseTTL is how long leadership and membership last without renewal.
+	// Nodes renew every LeaseTTL/3.
+	LeaseTTL time.Duration
+	// DedupWindow is how long batch IDs are remembered
+	DedupWindow time.Duration
+}
+
+// Member describes a node of the cluster
+type Member struct {
+	NodeID    string    `json:"node_id"`
+	Address   string    `json:"address,omitempty"`
+	Version   string    `json:"version,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	SeenAt    time.Time `json:"seen_at"`
+	Leader    bool      `json:"leader"`
+}
+
+// Status summarizes the cluster as seen by this node
+type Status struct {
+	NodeID      string   `json:"node_id"`
+	Clustered   bool     `json:"clustered"`
+	Leader      string   `json:"leader"`
+	IsLeader    bool     `json:"is_leader"`
+	Members     []Member `json:"members"`
+	DedupWindow string   `json:"dedup_window"`
+	LastError   string   `json:"last_error,omitempty"`
+}
+
+// Node is this aggregator's membership in the cluster
+type Node struct {
+	cfg         Config
+	store       Store
+	clustered   bool
+	auditLogger *audit.Logger
+	startedAt   time.Time
+
+	mu        sync.RWMutex
+	leader    string
+	lastError string
+	listeners []func(leader bool)
+
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// New creates a cluster node. A nil store makes a standalone node that is
+// always the leader.
+func New(cfg Config, store Store, auditLogger *audit.Logger) *Node {
+	if cfg.LeaseTTL <= 0 {
+		cfg.LeaseTTL = DefaultLeaseTTL
+	}
+	if cfg.DedupWindow <= 0 {
+		cfg.DedupWindow = DefaultDedupWindow
+	}
+	n := &Node{
+		cfg:         cfg,
+		store:       store,
+		clustered:   store != nil,
+		auditLogger: auditLogger,
+		startedAt:   time.Now().UTC(),
+		done:        make(chan struct{}),
+	}
+	if store == nil {
+		n.store = NewMemoryStore()
+		n.leader = cfg.NodeID
+	}
+	return n
+}
+
+// Store returns the store shared by the cluster
+func (n *Node) Store() Store {
+	return n.store
+}
+
+// OnLeadershipChange registers fn to be called whenever this node gains or
+// loses leadership
+func (n *Node) OnLeadershipChange(fn func(leader bool)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.listeners = append(n.listeners, fn)
+}
+
+// Start joins the cluster and begins competing for leadership
+func (n *Node) Start() {
+	if !n.clustered {
+		n.notify(true)
+		return
+	}
+	n.tick()
+	n.wg.Add(1)
+	go n.loop()
+}
+
+// Stop leaves the cluster, releasing leadership so another node can take
+// over without waiting for the lease to expire. Calls after the first do
+// nothing.
+func (n *Node) Stop() {
+	if !n.clustered {
+		return
+	}
+	n.stopOnce.Do(func() {
+		close(n.done)
+		n.wg.Wait()
+
+		current, ok, _ := n.store.Get(leaderKey)
+		if ok && string(current) == n.cfg.NodeID {
+			n.store.CompareAndSwap(leaderKey, current, []byte(""), time.Millisecond)
+		}
+		n.store.Delete(memberPrefix + n.cfg.NodeID)
+	})
+}
+
+// IsLeader reports whether this node currently owns the leader lease
+func (n *Node) IsLeader() bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.leader == n.cfg.NodeID
+}
+
+func (n *Node) loop() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.cfg.LeaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+			n.tick()
+		}
+	}
+}
+
+// tick renews membership and acquires or renews the leader lease
+func (n *Node) tick() {
+	member, _ := json.Marshal(Member{
+		NodeID:    n.cfg.NodeID,
+		Address:   n.cfg.Address,
+		Version:   n.cfg.Version,
+		StartedAt: n.startedAt,
+		SeenAt:    time.Now().UTC(),
+	})
+	if err := n.store.Put(memberPrefix+n.cfg.NodeID, member, n.cfg.LeaseTTL); err != nil {
+		n.fail(err)
+		return
+	}
+
+	self := []byte(n.cfg.NodeID)
+	current, ok, err := n.store.Get(leaderKey)
+	if err != nil {
+		n.fail(err)
+		return
+	}
+
+	leader := string(current)
+	switch {
+	case ok && leader == n.cfg.NodeID:
+		// Renew our lease
+		if renewed, err := n.store.CompareAndSwap(leaderKey, current, self, n.cfg.LeaseTTL); err != nil || !renewed {
+			leader = ""
+			n.fail(err)
+		}
+	case !ok || leader == "":
+		// No leader (or its lease expired): try to take over
+		var old []byte
+		if ok {
+			old = current
+		}
+		if acquired, err := n.store.CompareAndSwap(leaderKey, old, self, n.cfg.LeaseTTL); err != nil {
+			n.fail(err)
+			return
+		} else if acquired {
+			leader = n.cfg.NodeID
+		} else if current, _, err = n.store.Get(leaderKey); err == nil {
+			leader = string(current)
+		}
+	}
+
+	n.setLeader(leader)
+}
+
+func (n *Node) fail(err error) {
+	if err == nil {
+		return
+	}
+	n.mu.Lock()
+	n.lastError = err.Error()
+	n.mu.Unlock()
+	n.auditLogger.LogError(err, "Cluster store error", map[string]interface{}{
+		"node_id": n.cfg.NodeID,
+	})
+}
+
+// setLeader records the current leader and notifies listeners on changes of
+// this node's role
+func (n *Node) setLeader(leader string) {
+	n.mu.Lock()
+	wasLeader := n.leader == n.cfg.NodeID
+	n.leader = leader
+	n.lastError = ""
+	isLeader := leader == n.cfg.NodeID
+	n.mu.Unlock()
+
+	if wasLeader == isLeader {
+		return
+	}
+
+	n.auditLogger.LogEvent(audit.AuditEvent{
+		EventType: "cluster_leadership_change",
+		Message:   "Cluster leader is now " + leader,
+		Details: map[string]interface{}{
+			"node_id":   n.cfg.NodeID,
+			"leader":    leader,
+			"is_leader": isLeader,
+		},
+	})
+	n.notify(isLeader)
+}
+
+func (n *Node) notify(leader bool) {
+	n.mu.RLock()
+	listeners := append([]func(bool){}, n.listeners...)
+	n.mu.RUnlock()
+	for _, fn := range listeners {
+		fn(leader)
+	}
+}
+
+// SeenBatch records a batch ID and reports whether any node of the cluster
+// already recorded it within the dedup window
+func (n *Node) SeenBatch(agentID, batchID string) (bool, error) {
+	stored, err := n.store.PutIfAbsent(batchPrefix+agentID+"/"+batchID, []byte(n.cfg.NodeID), n.cfg.DedupWindow)
+	if err != nil {
+		return false, err
+	}
+	return !stored, nil
+}
+
+// ForgetBatch removes a recorded batch ID, so a batch that couldn't be
+// processed is accepted again when the agent resends it
+func (n *Node) ForgetBatch(agentID, batchID string) error {
+	return n.store.Delete(batchPrefix + agentID + "/" + batchID)
+}
+
+// Status returns the membership and leadership as seen by this node
+func (n *Node) Status() Status {
+	n.mu.RLock()
+	status := Status{
+		NodeID:      n.cfg.NodeID,
+		Clustered:   n.clustered,
+		Leader:      n.leader,
+		IsLeader:    n.leader == n.cfg.NodeID,
+		DedupWindow: n.cfg.DedupWindow.String(),
+		LastError:   n.lastError,
+	}
+	n.mu.RUnlock()
+
+	if !n.clustered {
+		status.Members = []Member{{
+			NodeID:    n.cfg.NodeID,
+			Address:   n.cfg.Address,
+			Version:   n.cfg.Version,
+			StartedAt: n.startedAt,
+			SeenAt:    time.Now().UTC(),
+			Leader:    true,
+		}}
+		return status
+	}
+
+	status.Members = []Member{}
+	entries, err := n.store.List(memberPrefix)
+	if err != nil {
+		status.LastError = err.Error()
+		return status
+	}
+	for _, data := range entries {
+		var member Member
+		if json.Unmarshal(data, &member) == nil {
+			member.Leader = member.NodeID == status.Leader
+			status.Members = append(status.Members, member)
+		}
+	}
+	sort.Slice(status.Members, func(i, j int) bool { return status.Members[i].NodeID < status.Members[j].NodeID })
+	return status
+}
+
+// Document returns a shared document
+func (n *Node) Document(name string) ([]byte, bool, error) {
+	return n.store.Get(documentPrefix + name)
+}
+
+// PutDocument stores a shared document visible to every node
+func (n *Node) PutDocument(name string, data []byte) error {
+	return n.store.Put(documentPrefix+name, data, 0)
+}
+
+// DocumentBackend loads and saves a single shared document, e.g. to back the
+// fleet configuration with the cluster store
+type DocumentBackend struct {
+	node *Node
+	name string
+}
+
+// DocumentBackend returns a backend for the shared document name
+func (n *Node) DocumentBackend(name string) DocumentBackend {
+	return DocumentBackend{node: n, name: name}
+}
+
+// Load returns the document
+func (b DocumentBackend) Load() ([]byte, bool, error) {
+	return b.node.Document(b.name)
+}
+
+// Save replaces the document
+func (b DocumentBackend) Save(data []byte) error {
+	return b.node.PutDocument(b.name, data)
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package cluster
+
+import "errors"
+
+// lockFile is not supported on this platform
+func lockFile(path string) (func(), error) {
+	return nil, errors.New("directory cluster store requires a unix platform")
+}
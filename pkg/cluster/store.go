@@ -0,0 +1,143 @@
+// Package cluster lets several aggregators run side by side. They share
+// state through a Store: a leader lease decides which node owns singleton
+// work such as rule evaluation, batch IDs are recorded so a batch retried
+// against another aggregator is not processed twice, and small documents
+// (like the fleet configuration) are visible to every node.
+package cluster
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store is a small key/value store shared by the nodes of a cluster.
+// Entries may carry a TTL after which they are treated as absent.
+type Store interface {
+	// Get returns the value of key
+	Get(key string) ([]byte, bool, error)
+	// Put stores value under key; ttl <= 0 keeps it forever
+	Put(key string, value []byte, ttl time.Duration) error
+	// PutIfAbsent stores value only if key is absent or expired and reports
+	// whether it did
+	PutIfAbsent(key string, value []byte, ttl time.Duration) (bool, error)
+	// CompareAndSwap replaces the value of key if it currently equals old
+	// (nil meaning absent or expired) and reports whether it did
+	CompareAndSwap(key string, old, value []byte, ttl time.Duration) (bool, error)
+	// Delete removes key
+	Delete(key string) error
+	// List returns the live entries whose key starts with prefix
+	List(prefix string) (map[string][]byte, error)
+}
+
+// memoryEntry is a value with an optional expiry
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// MemoryStore is an in-process Store used by standalone aggregators
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]memoryEntry)}
+}
+
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+func (s *MemoryStore) getLocked(key string) ([]byte, bool) {
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if entry.expired(time.Now()) {
+		delete(s.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.getLocked(key)
+	return append([]byte(nil), value...), ok, nil
+}
+
+// Put implements Store
+func (s *MemoryStore) Put(key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = memoryEntry{value: append([]byte(nil), value...), expiresAt: expiry(ttl)}
+	return nil
+}
+
+// PutIfAbsent implements Store
+func (s *MemoryStore) PutIfAbsent(key string, value []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.getLocked(key); ok {
+		return false, nil
+	}
+	s.entries[key] = memoryEntry{value: append([]byte(nil), value...), expiresAt: expiry(ttl)}
+	return true, nil
+}
+
+// CompareAndSwap implements Store
+func (s *MemoryStore) CompareAndSwap(key string, old, value []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.getLocked(key)
+	if !matches(current, ok, old) {
+		return false, nil
+	}
+	s.entries[key] = memoryEntry{value: append([]byte(nil), value...), expiresAt: expiry(ttl)}
+	return true, nil
+}
+
+// Delete implements Store
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// List implements Store
+func (s *MemoryStore) List(prefix string) (map[string][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make(map[string][]byte)
+	for key := range s.entries {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if value, ok := s.getLocked(key); ok {
+			result[key] = append([]byte(nil), value...)
+		}
+	}
+	return result, nil
+}
+
+// matches reports whether the current value satisfies a CompareAndSwap
+// expectation (old == nil meaning "absent")
+func matches(current []byte, exists bool, old []byte) bool {
+	if old == nil {
+		return !exists
+	}
+	return exists && string(current) == string(old)
+}
@@ -0,0 +1,180 @@
+package cluster
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// dirEntry is the on-disk form of a DirStore entry
+type dirEntry struct {
+	Key       string    `json:"key"`
+	Value     []byte    `json:"value"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// DirStore is a Store kept in a directory shared by all aggregators (e.g. a
+// network volume). Mutations are serialized with an exclusive file lock.
+type DirStore struct {
+	dir string
+}
+
+// OpenDirStore opens (creating if needed) a directory store
+func OpenDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cluster store %s: %w", dir, err)
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+// entryPath maps a key to a file name that is safe on any filesystem
+func (s *DirStore) entryPath(key string) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(key))+".json")
+}
+
+// withLock runs fn while holding the store lock
+func (s *DirStore) withLock(fn func() error) error {
+	unlock, err := lockFile(filepath.Join(s.dir, ".lock"))
+	if err != nil {
+		return fmt.Errorf("failed to lock cluster store: %w", err)
+	}
+	defer unlock()
+	return fn()
+}
+
+// read returns a live entry; expired entries are reported as absent
+func (s *DirStore) read(key string) ([]byte, bool, error) {
+	data, err := os.ReadFile(s.entryPath(key))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var entry dirEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false, fmt.Errorf("corrupt cluster store entry %q: %w", key, err)
+	}
+	if !entry.ExpiresAt.IsZero() && time.Now().After(entry.ExpiresAt) {
+		return nil, false, nil
+	}
+	return entry.Value, true, nil
+}
+
+// write atomically replaces an entry
+func (s *DirStore) write(key string, value []byte, ttl time.Duration) error {
+	data, err := json.Marshal(dirEntry{Key: key, Value: value, ExpiresAt: expiry(ttl)})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.entryPath(key))
+}
+
+// Get implements Store
+func (s *DirStore) Get(key string) ([]byte, bool, error) {
+	return s.read(key)
+}
+
+// Put implements Store
+func (s *DirStore) Put(key string, value []byte, ttl time.Duration) error {
+	return s.withLock(func() error {
+		return s.write(key, value, ttl)
+	})
+}
+
+// PutIfAbsent implements Store
+func (s *DirStore) PutIfAbsent(key string, value []byte, ttl time.Duration) (bool, error) {
+	return s.CompareAndSwap(key, nil, value, ttl)
+}
+
+// CompareAndSwap implements Store
+func (s *DirStore) CompareAndSwap(key string, old, value []byte, ttl time.Duration) (bool, error) {
+	swapped := false
+	err := s.withLock(func() error {
+		current, ok, err := s.read(key)
+		if err != nil {
+			return err
+		}
+		if !matches(current, ok, old) {
+			return nil
+		}
+		swapped = true
+		return s.write(key, value, ttl)
+	})
+	return swapped, err
+}
+
+// Delete implements Store
+func (s *DirStore) Delete(key string) error {
+	return s.withLock(func() error {
+		err := os.Remove(s.entryPath(key))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	})
+}
+
+// List implements Store. Expired entries found while listing are removed.
+func (s *DirStore) List(prefix string) (map[string][]byte, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	hexPrefix := hex.EncodeToString([]byte(prefix))
+	result := make(map[string][]byte)
+	var expired []string
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasSuffix(name, ".json") || !strings.HasPrefix(name, hexPrefix) {
+			continue
+		}
+		raw, err := hex.DecodeString(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+		key := string(raw)
+		value, ok, err := s.read(key)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			expired = append(expired, key)
+			continue
+		}
+		result[key] = value
+	}
+
+	if len(expired) > 0 {
+		s.withLock(func() error {
+			for _, key := range expired {
+				if _, ok, _ := s.read(key); !ok {
+					os.Remove(s.entryPath(key))
+				}
+			}
+			return nil
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,158 @@
+package collector
+
+import (
+	"hash/fnv"
+	"strings"
+)
+
+const (
+	// bloomBlockEntries is the number of consecutive entries of a source
+	// buffer summarized by one filter
+	bloomBlockEntries = 256
+	// bloomBits is the size of a filter (8 KiB), for a false positive rate
+	// around 1% with the few thousand distinct keys of a block
+	bloomBits   = 1 << 16
+	bloomHashes = 3
+)
+
+// bloomFields are the entry fields whose values are indexed for field:value
+// terms
+var bloomFields = []string{"source", "level", "host", "service", "user", "ip", "method", "path", "status"}
+
+// blockBloom is a bloom filter over the message trigrams and the field
+// values of a block of entries. Text terms match anywhere in the message,
+// so messages are indexed by their lower-cased three-byte substrings: a
+// block can only hold a match when it has every trigram of the text.
+type blockBloom struct {
+	bits [bloomBits / 64]uint64
+}
+
+func (b *blockBloom) add(h uint64) {
+	h1, h2 := uint32(h), uint32(h>>32)
+	for i := uint32(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % bloomBits
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (b *blockBloom) has(h uint64) bool {
+	h1, h2 := uint32(h), uint32(h>>32)
+	for i := uint32(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % bloomBits
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// addEntry indexes the message and field values of log
+func (b *blockBloom) addEntry(log *SystemLog) {
+	msg := log.Message
+	for i := 0; i+3 <= len(msg); i++ {
+		b.add(trigramHash(lowerASCII(msg[i]), lowerASCII(msg[i+1]), lowerASCII(msg[i+2])))
+	}
+	for _, field := range bloomFields {
+		if value, ok := queryField(log, field); ok && value != "" {
+			b.add(fieldHash(field, value))
+		}
+	}
+	for _, tag := range log.Tags {
+		b.add(fieldHash("tag", tag))
+	}
+}
+
+func lowerASCII(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func trigramHash(a, b, c byte) uint64 {
+	return mix64(uint64(a)<<16 | uint64(b)<<8 | uint64(c))
+}
+
+func fieldHash(field, value string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(field))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.ToLower(value)))
+	return mix64(h.Sum64())
+}
+
+// mix64 spreads the bits of a key (the splitmix64 finalizer)
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// mayMatch reports whether a block with filter b can hold an entry matching
+// node. Only text terms of at least three bytes and exact field:value terms
+// on indexed fields can rule a block out; anything else, and every negated
+// term, may match.
+func mayMatch(node queryNode, b *blockBloom) bool {
+	switch n := node.(type) {
+	case nil:
+		return true
+	case andNode:
+		for _, child := range n {
+			if !mayMatch(child, b) {
+				return false
+			}
+		}
+		return true
+	case orNode:
+		for _, child := range n {
+			if mayMatch(child, b) {
+				return true
+			}
+		}
+		return false
+	case textNode:
+		text := string(n)
+		for i := 0; i+3 <= len(text); i++ {
+			if !b.has(trigramHash(text[i], text[i+1], text[i+2])) {
+				return false
+			}
+		}
+		return true
+	case *fieldNode:
+		if n.op != opEqual || n.network != nil || strings.Contains(n.value, "*") || !bloomIndexed(n.field) {
+			return true
+		}
+		return b.has(fieldHash(bloomFieldName(n.field), n.value))
+	}
+	return true
+}
+
+func bloomIndexed(field string) bool {
+	if field == "tag" || field == "tags" {
+		return true
+	}
+	for _, indexed := range bloomFields {
+		if field == indexed {
+			return true
+		}
+	}
+	return false
+}
+
+func bloomFieldName(field string) string {
+	if field == "tags" {
+		return "tag"
+	}
+	return field
+}
+
+// emptyBloom rules out every block for queries that can use the filters
+var emptyBloom = &blockBloom{}
+
+// usesBloom reports whether the filters can rule out blocks for q
+func (q *Query) usesBloom() bool {
+	return q != nil && q.root != nil && !mayMatch(q.root, emptyBloom)
+}
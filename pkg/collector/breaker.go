@@ -0,0 +1,242 @@
+package collector
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// Circuit breaker states, see BreakerStatus
+const (
+	// CircuitClosed lets every read or write through
+	CircuitClosed = "closed"
+	// CircuitOpen rests a source or output that kept failing until its
+	// next probe
+	CircuitOpen = "open"
+	// CircuitHalfOpen lets one probe through; its outcome closes or
+	// reopens the circuit
+	CircuitHalfOpen = "half_open"
+)
+
+// BreakerPolicy decides when a source or output is considered broken
+type BreakerPolicy struct {
+	// Failures is the number of consecutive failures that opens the
+	// circuit; 0 disables the breakers
+	Failures int
+	// Cooldown is the wait before the first probe of an open circuit. It
+	// doubles with every failed probe, up to MaxCooldown.
+	Cooldown    time.Duration
+	MaxCooldown time.Duration
+}
+
+// DefaultBreakerPolicy opens after 5 consecutive failures and probes after
+// 30 seconds, backing off to 10 minutes
+func DefaultBreakerPolicy() BreakerPolicy {
+	return BreakerPolicy{Failures: 5, Cooldown: 30 * time.Second, MaxCooldown: 10 * time.Minute}
+}
+
+// BreakerStatus is the circuit breaker of a source or output as reported
+// by the status APIs
+type BreakerStatus struct {
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	// Opens counts how often the circuit opened
+	Opens     int        `json:"opens,omitempty"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+	NextProbe *time.Time `json:"next_probe,omitempty"`
+	// Skipped counts the entries an open output circuit did not write
+	Skipped   uint64 `json:"skipped,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Breaker transitions reported by breaker.failure and breaker.success
+const (
+	circuitUnchanged = iota
+	circuitOpened
+	circuitReopened
+	circuitClosed
+)
+
+// breaker counts the consecutive failures of a source or output and opens
+// the circuit when they reach the policy's Failures
+type breaker struct {
+	// healthy is set while the circuit is closed without failures, so the
+	// write path of a working output takes no lock
+	healthy atomic.Bool
+
+	mu       sync.Mutex
+	policy   BreakerPolicy
+	status   BreakerStatus
+	cooldown time.Duration
+	// probing is set while the one probe of a half-open circuit is out
+	probing bool
+}
+
+func newBreaker(policy BreakerPolicy) *breaker {
+	b := &breaker{policy: policy, status: BreakerStatus{State: CircuitClosed}}
+	b.healthy.Store(true)
+	return b
+}
+
+// allow reports whether a read or write may go ahead. An open circuit
+// turns half-open once its probe is due and lets that one call through.
+func (b *breaker) allow() bool {
+	if b.healthy.Load() {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.status.State {
+	case CircuitOpen:
+		if time.Now().Before(*b.status.NextProbe) {
+			b.status.Skipped++
+			return false
+		}
+		b.status.State = CircuitHalfOpen
+		b.probing = true
+		return true
+	case CircuitHalfOpen:
+		if b.probing {
+			b.status.Skipped++
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// probeAt returns when an open circuit may be tried next; zero when it is
+// not open
+func (b *breaker) probeAt() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.status.State != CircuitOpen {
+		return time.Time{}
+	}
+	return *b.status.NextProbe
+}
+
+// failure records a failed read or write and reports whether it opened the
+// circuit (circuitOpened) or a probe failed (circuitReopened)
+func (b *breaker) failure(err error, now time.Time) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.healthy.Store(false)
+	b.status.ConsecutiveFailures++
+	b.status.LastError = err.Error()
+	if b.policy.Failures <= 0 {
+		return circuitUnchanged
+	}
+
+	transition := circuitUnchanged
+	switch {
+	case b.status.State != CircuitClosed:
+		b.cooldown = min(b.cooldown*2, b.policy.MaxCooldown)
+		transition = circuitReopened
+	case b.status.ConsecutiveFailures >= b.policy.Failures:
+		b.cooldown = b.policy.Cooldown
+		b.status.Opens++
+		opened := now
+		b.status.OpenedAt = &opened
+		transition = circuitOpened
+	default:
+		return circuitUnchanged
+	}
+	next := now.Add(b.cooldown)
+	b.status.State = CircuitOpen
+	b.status.NextProbe = &next
+	b.probing = false
+	return transition
+}
+
+// success records a working read or write and reports whether it closed
+// the circuit
+func (b *breaker) success() int {
+	if b.healthy.Load() {
+		return circuitUnchanged
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.healthy.Store(true)
+	b.status.ConsecutiveFailures = 0
+	if b.status.State == CircuitClosed {
+		return circuitUnchanged
+	}
+	b.status.State = CircuitClosed
+	b.status.NextProbe = nil
+	b.probing = false
+	return circuitClosed
+}
+
+// snapshot returns a copy of the status that shares no pointers with the
+// breaker
+func (b *breaker) snapshot() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := b.status
+	status.OpenedAt = copyTime(status.OpenedAt)
+	status.NextProbe = copyTime(status.NextProbe)
+	return status
+}
+
+// SetBreakerPolicy sets when sources and outputs that keep failing are
+// rested, see BreakerPolicy. It must be called while the collector is
+// stopped; breakers already created keep their policy.
+func (lc *LogCollector) SetBreakerPolicy(policy BreakerPolicy) error {
+	if policy.Failures > 0 && policy.Cooldown <= 0 {
+		return fmt.Errorf("circuit breaker cooldown must be positive")
+	}
+	policy.MaxCooldown = max(policy.MaxCooldown, policy.Cooldown)
+	lc.breakerPolicy.Store(&policy)
+	return nil
+}
+
+func (lc *LogCollector) newBreaker() *breaker {
+	if policy := lc.breakerPolicy.Load(); policy != nil {
+		return newBreaker(*policy)
+	}
+	return newBreaker(DefaultBreakerPolicy())
+}
+
+// outputBreaker returns the breaker of an output, created on first use
+func (lc *LogCollector) outputBreaker(output Output) *breaker {
+	if b, ok := lc.outputBreakers.Load(output.Name()); ok {
+		return b.(*breaker)
+	}
+	b, _ := lc.outputBreakers.LoadOrStore(output.Name(), lc.newBreaker())
+	return b.(*breaker)
+}
+
+// logCircuit logs the transitions of a breaker. kind is source or output.
+func (lc *LogCollector) logCircuit(kind, name string, b *breaker, transition int, err error) {
+	status := b.snapshot()
+	details := map[string]interface{}{
+		kind:                   name,
+		"consecutive_failures": status.ConsecutiveFailures,
+	}
+	switch transition {
+	case circuitOpened:
+		details["next_probe"] = status.NextProbe
+		lc.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: audit.EventType(kind + "_circuit_open"),
+			Message:   fmt.Sprintf("Circuit of %s %s opened after %d consecutive failures, next probe at %s", kind, name, status.ConsecutiveFailures, status.NextProbe.Format(time.RFC3339)),
+			Error:     err.Error(),
+			Details:   details,
+		})
+	case circuitClosed:
+		if status.OpenedAt != nil {
+			details["open_for"] = time.Since(*status.OpenedAt).Round(time.Second).String()
+		}
+		details["skipped"] = status.Skipped
+		lc.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: audit.EventType(kind + "_circuit_closed"),
+			Message:   fmt.Sprintf("Circuit of %s %s closed, it works again", kind, name),
+			Details:   details,
+		})
+	}
+	// Failed probes are only counted; the circuit stays open
+}
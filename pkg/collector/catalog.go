@@ -0,0 +1,299 @@
+package collector
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxCatalogFiles bounds the catalog; the files seen longest ago are
+	// forgotten first
+	maxCatalogFiles = 10000
+	// catalogSaveInterval is how often a changed catalog is written to its
+	// file
+	catalogSaveInterval = time.Minute
+)
+
+// CatalogFile is a file tailed by a source. A file keeps its entry while it
+// is appended to, renamed or read on by another source (recognized by its
+// fingerprint); a rotated or replaced file at the same path gets a new one.
+type CatalogFile struct {
+	ID          string `json:"id"`
+	Source      string `json:"source"`
+	Path        string `json:"path"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// FingerprintSize is the number of bytes hashed; it is less than the
+	// fingerprint length while the file is short
+	FingerprintSize int `json:"fingerprint_size,omitempty"`
+	// Size is the size of the file when it was last read
+	Size int64 `json:"size"`
+	// FirstOffset and LastOffset delimit the bytes ingested from the file
+	FirstOffset int64     `json:"first_offset"`
+	LastOffset  int64     `json:"last_offset"`
+	Lines       uint64    `json:"lines"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// fileCatalog keeps the tailed files, optionally persisted to a file
+type fileCatalog struct {
+	mu      sync.Mutex
+	files   map[string]*CatalogFile
+	current map[string]string // source name → ID of the file it reads
+	path    string
+	dirty   bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newFileCatalog() *fileCatalog {
+	return &fileCatalog{
+		files:   make(map[string]*CatalogFile),
+		current: make(map[string]string),
+	}
+}
+
+// record notes that the bytes from..to of the file of config were read.
+// complete tells that fp has the full fingerprint length.
+func (fc *fileCatalog) record(config LogSourceConfig, fp fingerprint, complete bool, size, from, to int64, lines int) {
+	now := time.Now()
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	file := fc.files[fc.current[config.Name]]
+	if file == nil || file.Path != config.Path {
+		file = fc.find(config, fp, complete, from)
+		if file == nil {
+			file = &CatalogFile{ID: newCatalogID(), FirstOffset: from, FirstSeen: now}
+			fc.files[file.ID] = file
+			fc.evict()
+		}
+		fc.current[config.Name] = file.ID
+	}
+	file.Source, file.Path = config.Name, config.Path
+	file.Fingerprint, file.FingerprintSize = fp.hash, fp.size
+	file.Size = size
+	if from < file.FirstOffset {
+		file.FirstOffset = from
+	}
+	if to > file.LastOffset {
+		file.LastOffset = to
+	}
+	file.Lines += uint64(lines)
+	file.LastSeen = now
+	fc.dirty = true
+}
+
+// release ends the file a source reads; it was rotated or replaced, so the
+// next lines belong to a new one
+func (fc *fileCatalog) release(source string) {
+	fc.mu.Lock()
+	delete(fc.current, source)
+	fc.mu.Unlock()
+}
+
+// find returns the entry of a file read before: one with the same complete
+// fingerprint, or the entry of the same source and path that ended where
+// reading resumes (after a restart). fc.mu must be held.
+func (fc *fileCatalog) find(config LogSourceConfig, fp fingerprint, complete bool, from int64) *CatalogFile {
+	for _, file := range fc.files {
+		if complete && file.Fingerprint == fp.hash && file.FingerprintSize == fp.size {
+			return file
+		}
+	}
+	if from == 0 {
+		return nil
+	}
+	for _, file := range fc.files {
+		if file.Source == config.Name && file.Path == config.Path && file.LastOffset == from {
+			return file
+		}
+	}
+	return nil
+}
+
+// evict forgets the files seen longest ago beyond maxCatalogFiles. fc.mu
+// must be held.
+func (fc *fileCatalog) evict() {
+	for len(fc.files) > maxCatalogFiles {
+		var oldest *CatalogFile
+		for _, file := range fc.files {
+			if oldest == nil || file.LastSeen.Before(oldest.LastSeen) {
+				oldest = file
+			}
+		}
+		delete(fc.files, oldest.ID)
+	}
+}
+
+func newCatalogID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return "file_" + hex.EncodeToString(b[:])
+}
+
+// ConfigureCatalog persists the file catalog at path so it survives
+// restarts. It must be called while the collector is stopped.
+func (lc *LogCollector) ConfigureCatalog(path string) error {
+	lc.lifecycleMu.Lock()
+	defer lc.lifecycleMu.Unlock()
+
+	if lc.running.Load() {
+		return fmt.Errorf("cannot configure the file catalog while log collector is running")
+	}
+
+	fc := lc.catalog
+	fc.close()
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.path = path
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return fmt.Errorf("failed to read catalog file %s: %w", path, err)
+	default:
+		var files []*CatalogFile
+		if err := json.Unmarshal(data, &files); err != nil {
+			return fmt.Errorf("failed to decode catalog file %s: %w", path, err)
+		}
+		for _, file := range files {
+			fc.files[file.ID] = file
+		}
+		fc.evict()
+	}
+
+	fc.stop, fc.done = make(chan struct{}), make(chan struct{})
+	go lc.saveCatalogLoop(fc.stop, fc.done)
+	return nil
+}
+
+// CatalogFiles returns the files of the catalog, the most recently read
+// first. A non-empty source only returns the files of that source.
+func (lc *LogCollector) CatalogFiles(source string) []CatalogFile {
+	fc := lc.catalog
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	files := make([]CatalogFile, 0, len(fc.files))
+	for _, file := range fc.files {
+		if source == "" || file.Source == source {
+			files = append(files, *file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].LastSeen.After(files[j].LastSeen) })
+	return files
+}
+
+// CatalogFile returns a file of the catalog by ID
+func (lc *LogCollector) CatalogFile(id string) (CatalogFile, bool) {
+	fc := lc.catalog
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	file, ok := fc.files[id]
+	if !ok {
+		return CatalogFile{}, false
+	}
+	return *file, true
+}
+
+// VerifyCatalogFile checks that the file at the path of a catalog entry is
+// still the one that was read, by its fingerprint
+func VerifyCatalogFile(file CatalogFile) error {
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if file.Fingerprint == "" {
+		return nil
+	}
+	same, err := fingerprint{hash: file.Fingerprint, size: file.FingerprintSize}.matches(f)
+	if err != nil {
+		return err
+	}
+	if !same {
+		return fmt.Errorf("%s is no longer the file that was read (fingerprint changed)", file.Path)
+	}
+	return nil
+}
+
+func (lc *LogCollector) saveCatalogLoop(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(catalogSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := lc.catalog.save(); err != nil {
+				lc.auditLogger.LogError(err, "Failed to write catalog file", nil)
+			}
+		}
+	}
+}
+
+// close stops the save loop. The final save is left to the caller.
+func (fc *fileCatalog) close() {
+	fc.mu.Lock()
+	stop, done := fc.stop, fc.done
+	fc.stop, fc.done = nil, nil
+	fc.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// save writes the catalog to its file when it changed
+func (fc *fileCatalog) save() error {
+	fc.mu.Lock()
+	if fc.path == "" || !fc.dirty {
+		fc.mu.Unlock()
+		return nil
+	}
+	files := make([]*CatalogFile, 0, len(fc.files))
+	for _, file := range fc.files {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].FirstSeen.Before(files[j].FirstSeen) })
+	data, err := json.MarshalIndent(files, "", "  ")
+	fc.dirty = false
+	path := fc.path
+	fc.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create catalog directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create catalog file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write catalog file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace catalog file: %w", err)
+	}
+	return nil
+}
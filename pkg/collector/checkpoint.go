@@ -0,0 +1,373 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/encryption"
+)
+
+const (
+	// DefaultCheckpointFlushEntries is the default number of lines read
+	// before pending checkpoints are written
+	DefaultCheckpointFlushEntries = 1000
+	// DefaultCheckpointFlushInterval is the default maximum time pending
+	// checkpoints stay in memory
+	DefaultCheckpointFlushInterval = 5 * time.Second
+)
+
+// ErrCheckpointsHeld is returned by checkpoint writes while delivery fails
+var ErrCheckpointsHeld = errors.New("checkpoints held at the last delivered positions")
+
+// CheckpointPolicy controls how often source positions are persisted.
+// Checkpoint updates are batched in memory and written when FlushEntries
+// lines have been read or FlushInterval has elapsed, whichever comes first.
+// Checkpoints are always written and fsynced on shutdown.
+type CheckpointPolicy struct {
+	FlushEntries  int
+	FlushInterval time.Duration
+	// Fsync makes every checkpoint write durable before returning. Without
+	// it writes survive a process crash but not necessarily a power loss.
+	Fsync bool
+	// Cipher encrypts the checkpoint file when set. Unencrypted files are
+	// still read and encrypted on the next write.
+	Cipher *encryption.Cipher
+	// FingerprintBytes is the number of bytes at the start of a file that
+	// identify it, see Checkpoint.Fingerprint. Defaults to
+	// DefaultFingerprintBytes.
+	FingerprintBytes int
+}
+
+// Checkpoint is the persisted read position of a source
+type Checkpoint struct {
+	Source    string    `json:"source"`
+	Path      string    `json:"path"`
+	Offset    int64     `json:"offset"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Fingerprint is a hash of the first FingerprintSize bytes of the file,
+	// which recognizes it after a rename and detects a different file at
+	// the same path
+	Fingerprint     string `json:"fingerprint,omitempty"`
+	FingerprintSize int    `json:"fingerprint_size,omitempty"`
+}
+
+// CheckpointStore persists source checkpoints to a JSON file.
+//
+// With a barrier (see SetBarrier) positions are only written once the
+// barrier confirms that everything read up to them was delivered, so a
+// crash replays undelivered lines instead of skipping them. While the
+// barrier fails the file stays at the last confirmed positions; the newer
+// ones stay pending and are written once a later barrier succeeds.
+type CheckpointStore struct {
+	mu          sync.Mutex
+	path        string
+	policy      CheckpointPolicy
+	checkpoints map[string]Checkpoint
+	pending     int
+	dirty       bool
+
+	// writeMu serializes writes, which run the barrier without holding mu
+	writeMu  sync.Mutex
+	barrier  func() error
+	held     error // the last barrier failure, nil once it succeeds
+	unsynced bool
+
+	kick      chan struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// OpenCheckpointStore loads checkpoints from path (if it exists) and starts
+// the periodic flush loop
+func OpenCheckpointStore(path string, policy CheckpointPolicy) (*CheckpointStore, error) {
+	if policy.FlushEntries <= 0 {
+		policy.FlushEntries = DefaultCheckpointFlushEntries
+	}
+	if policy.FlushInterval <= 0 {
+		policy.FlushInterval = DefaultCheckpointFlushInterval
+	}
+
+	cs := &CheckpointStore{
+		path:        path,
+		policy:      policy,
+		checkpoints: make(map[string]Checkpoint),
+		kick:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if data, err = policy.Cipher.Open(data, encryption.PurposeCheckpoint); err != nil {
+			return nil, fmt.Errorf("failed to read checkpoint file %s: %w", path, err)
+		}
+		var checkpoints []Checkpoint
+		if err := json.Unmarshal(data, &checkpoints); err != nil {
+			return nil, fmt.Errorf("failed to decode checkpoint file %s: %w", path, err)
+		}
+		for _, cp := range checkpoints {
+			cs.checkpoints[cp.Source] = cp
+		}
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("failed to read checkpoint file %s: %w", path, err)
+	}
+
+	cs.wg.Add(1)
+	go cs.flushLoop()
+
+	return cs, nil
+}
+
+// Get returns the checkpoint of a source
+func (cs *CheckpointStore) Get(source string) (Checkpoint, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cp, ok := cs.checkpoints[source]
+	return cp, ok
+}
+
+// All returns a copy of all checkpoints
+func (cs *CheckpointStore) All() []Checkpoint {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	checkpoints := make([]Checkpoint, 0, len(cs.checkpoints))
+	for _, cp := range cs.checkpoints {
+		checkpoints = append(checkpoints, cp)
+	}
+	return checkpoints
+}
+
+// SetBarrier makes every write wait for barrier to confirm that the lines
+// read so far were delivered; an error keeps the file at its last
+// confirmed positions
+func (cs *CheckpointStore) SetBarrier(barrier func() error) {
+	cs.writeMu.Lock()
+	defer cs.writeMu.Unlock()
+	cs.barrier = barrier
+}
+
+// Update records a new position for a source after entries lines were read.
+// The checkpoint file is written once enough lines are pending.
+func (cs *CheckpointStore) Update(source, path string, offset int64, entries int) error {
+	return cs.Record(Checkpoint{Source: source, Path: path, Offset: offset}, entries)
+}
+
+// Record is Update for a checkpoint that carries a fingerprint
+func (cs *CheckpointStore) Record(cp Checkpoint, entries int) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cp.UpdatedAt = time.Now()
+	cs.checkpoints[cp.Source] = cp
+	cs.dirty = true
+	cs.pending += entries
+
+	if cs.pending >= cs.policy.FlushEntries {
+		// Written by the flush loop, so slow outputs don't hold up reading
+		select {
+		case cs.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Flush writes pending checkpoints; sync forces an fsync regardless of policy
+func (cs *CheckpointStore) Flush(sync bool) error {
+	return cs.write(sync || cs.policy.Fsync)
+}
+
+// Close stops the flush loop and writes and fsyncs the final checkpoints
+func (cs *CheckpointStore) Close() error {
+	var err error
+	cs.closeOnce.Do(func() {
+		close(cs.done)
+		cs.wg.Wait()
+		err = cs.write(true)
+	})
+	return err
+}
+
+// write atomically replaces the checkpoint file (write to a temp file, then
+// rename) so a crash never leaves a half-written file behind
+func (cs *CheckpointStore) write(sync bool) error {
+	cs.writeMu.Lock()
+	defer cs.writeMu.Unlock()
+
+	cs.mu.Lock()
+	if !cs.dirty {
+		cs.mu.Unlock()
+		if sync && cs.unsynced {
+			return cs.syncFile()
+		}
+		return nil
+	}
+	checkpoints := make([]Checkpoint, 0, len(cs.checkpoints))
+	for _, cp := range cs.checkpoints {
+		checkpoints = append(checkpoints, cp)
+	}
+	cs.dirty = false
+	cs.pending = 0
+	cs.mu.Unlock()
+
+	// Every line up to the snapshot positions has been handed to the
+	// outputs; make sure they have it before recording the positions
+	if cs.barrier != nil {
+		if err := cs.barrier(); err != nil {
+			// Kept pending, so the next write asks the barrier again
+			cs.mu.Lock()
+			cs.dirty = true
+			cs.mu.Unlock()
+			cs.held = fmt.Errorf("%w: %w", ErrCheckpointsHeld, err)
+			return cs.held
+		}
+		cs.held = nil
+	}
+	if err := cs.writeFile(checkpoints, sync); err != nil {
+		cs.mu.Lock()
+		cs.dirty = true
+		cs.mu.Unlock()
+		return err
+	}
+	cs.unsynced = !sync
+	return nil
+}
+
+// writeFile replaces the checkpoint file with checkpoints
+func (cs *CheckpointStore) writeFile(checkpoints []Checkpoint, sync bool) error {
+	sort.Slice(checkpoints, func(i, j int) bool { return checkpoints[i].Source < checkpoints[j].Source })
+	data, err := json.MarshalIndent(checkpoints, "", "  ")
+	if err != nil {
+		return err
+	}
+	if data, err = cs.policy.Cipher.Seal(data, encryption.PurposeCheckpoint); err != nil {
+		return fmt.Errorf("failed to encrypt checkpoint file: %w", err)
+	}
+
+	dir := filepath.Dir(cs.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(cs.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write checkpoint file: %w", err)
+	}
+	if sync {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to fsync checkpoint file: %w", err)
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), cs.path); err != nil {
+		return fmt.Errorf("failed to replace checkpoint file: %w", err)
+	}
+	if sync {
+		// Persist the rename itself
+		if d, err := os.Open(dir); err == nil {
+			d.Sync()
+			d.Close()
+		}
+	}
+	return nil
+}
+
+// syncFile fsyncs a checkpoint file written without fsync
+func (cs *CheckpointStore) syncFile() error {
+	file, err := os.Open(cs.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to fsync checkpoint file: %w", err)
+	}
+	cs.unsynced = false
+	return nil
+}
+
+func (cs *CheckpointStore) flushLoop() {
+	defer cs.wg.Done()
+
+	ticker := time.NewTicker(cs.policy.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cs.done:
+			return
+		case <-ticker.C:
+			cs.Flush(false)
+		case <-cs.kick:
+			cs.Flush(false)
+		}
+	}
+}
+
+// ConfigureCheckpoints enables persistent source positions stored at path.
+// It must be called while the collector is stopped.
+func (lc *LogCollector) ConfigureCheckpoints(path string, policy CheckpointPolicy) error {
+	lc.lifecycleMu.Lock()
+	defer lc.lifecycleMu.Unlock()
+
+	if lc.running.Load() {
+		return fmt.Errorf("cannot configure checkpoints while log collector is running")
+	}
+
+	store, err := OpenCheckpointStore(path, policy)
+	if err != nil {
+		return err
+	}
+
+	if lc.checkpoints != nil {
+		lc.checkpoints.Close()
+	}
+	store.SetBarrier(lc.acknowledge)
+	lc.checkpoints = store
+	return nil
+}
+
+// PersistState writes the pending checkpoints, once the outputs confirm
+// delivery, and the file catalog now, e.g. before the files are copied into
+// a snapshot. Held checkpoints are no error: the file stays at positions
+// that were delivered.
+func (lc *LogCollector) PersistState() error {
+	lc.lifecycleMu.Lock()
+	defer lc.lifecycleMu.Unlock()
+	if lc.checkpoints != nil {
+		if err := lc.checkpoints.Flush(true); err != nil && !errors.Is(err, ErrCheckpointsHeld) {
+			return err
+		}
+	}
+	return lc.catalog.save()
+}
+
+// saveCheckpoint records the current offset of a source
+func (lc *LogCollector) saveCheckpoint(config LogSourceConfig, offset int64, fp fingerprint, entries int) {
+	if lc.checkpoints == nil {
+		return
+	}
+	cp := Checkpoint{Source: config.Name, Path: config.Path, Offset: offset}
+	cp.Fingerprint, cp.FingerprintSize = fp.hash, fp.size
+	if err := lc.checkpoints.Record(cp, entries); err != nil {
+		lc.auditLogger.LogError(err, "Failed to write checkpoints", map[string]interface{}{
+			"source": config.Name,
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package collector
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// openTestCheckpoints opens a store that is only written when flushed
+func openTestCheckpoints(t *testing.T, path string) *CheckpointStore {
+	t.Helper()
+	store, err := OpenCheckpointStore(path, CheckpointPolicy{FlushEntries: 1 << 30, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// storedOffset reads the offset of source from the checkpoint file
+func storedOffset(t *testing.T, path, source string) int64 {
+	t.Helper()
+	store := openTestCheckpoints(t, path)
+	defer store.Close()
+	cp, ok := store.Get(source)
+	if !ok {
+		return -1
+	}
+	return cp.Offset
+}
+
+func TestCheckpointBarrier(t *testing.T) {
+	errLost := errors.New("output lost lines")
+	tests := []struct {
+		name string
+		// results of the barrier for successive flushes, each after a read
+		// that advances the offset by 100
+		barrier []error
+		// want is the stored offset after each flush, -1 for none
+		want []int64
+	}{
+		{"no barrier", nil, []int64{100, 200}},
+		{"delivered", []error{nil, nil, nil}, []int64{100, 200, 300}},
+		{"first delivery failed", []error{errLost, nil}, []int64{-1, 200}},
+		// The failed batch is held until a later one is delivered
+		{"delivery failed once", []error{nil, errLost, nil}, []int64{100, 100, 300}},
+		{"delivery keeps failing", []error{nil, errLost, errLost}, []int64{100, 100, 100}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "checkpoints.json")
+			store := openTestCheckpoints(t, path)
+			flushes := max(len(test.barrier), len(test.want))
+			calls := 0
+			if test.barrier != nil {
+				store.SetBarrier(func() error {
+					calls++
+					return test.barrier[calls-1]
+				})
+			}
+
+			held := false
+			for i := 0; i < flushes; i++ {
+				store.Update("app", "/var/log/app.log", int64(100*(i+1)), 10)
+				err := store.Flush(false)
+				held = test.barrier != nil && test.barrier[i] != nil
+				if held != errors.Is(err, ErrCheckpointsHeld) {
+					t.Fatalf("flush %d: %v, held %v", i+1, err, held)
+				}
+				if got := storedOffset(t, path, "app"); got != test.want[i] {
+					t.Fatalf("flush %d: stored offset %d, want %d", i+1, got, test.want[i])
+				}
+			}
+			if test.barrier != nil && calls != flushes {
+				t.Fatalf("barrier called %d times for %d flushes", calls, flushes)
+			}
+			if held {
+				// Close asks the barrier once more for the held positions
+				test.barrier = append(test.barrier, errLost)
+			}
+			if err := store.Close(); held != errors.Is(err, ErrCheckpointsHeld) {
+				t.Fatalf("Close() = %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckpointRetryAfterFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	store := openTestCheckpoints(t, path)
+	defer store.Close()
+	failing := true
+	store.SetBarrier(func() error {
+		if failing {
+			return errors.New("output lost lines")
+		}
+		return nil
+	})
+	store.Update("app", "/var/log/app.log", 100, 10)
+	if err := store.Flush(false); !errors.Is(err, ErrCheckpointsHeld) {
+		t.Fatalf("Flush() = %v", err)
+	}
+
+	// The held positions are written once delivery works again, without
+	// waiting for new lines
+	failing = false
+	if err := store.Flush(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := storedOffset(t, path, "app"); got != 100 {
+		t.Fatalf("stored offset %d after the retry", got)
+	}
+}
+
+func TestCheckpointFlushWithoutChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	store := openTestCheckpoints(t, path)
+	defer store.Close()
+	calls := 0
+	store.SetBarrier(func() error {
+		calls++
+		return nil
+	})
+	store.Update("app", "/var/log/app.log", 10, 1)
+	store.Flush(false)
+	store.Flush(false)
+	store.Flush(true)
+	if calls != 1 {
+		t.Fatalf("barrier called %d times for one change", calls)
+	}
+}
+
+func TestCheckpointFlushEntriesKick(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	store, err := OpenCheckpointStore(path, CheckpointPolicy{FlushEntries: 5, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	barrier := make(chan struct{})
+	store.SetBarrier(func() error {
+		<-barrier
+		return nil
+	})
+
+	// Reaching FlushEntries hands the write to the flush loop, so a slow
+	// barrier doesn't block reading
+	done := make(chan struct{})
+	go func() {
+		store.Update("app", "/var/log/app.log", 50, 5)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Update waited for the barrier")
+	}
+	close(barrier)
+	deadline := time.Now().Add(5 * time.Second)
+	for storedOffset(t, path, "app") != 50 {
+		if time.Now().After(deadline) {
+			t.Fatal("the flush loop did not write the checkpoint")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+// flakyWriter fails writes while failing is set
+type flakyWriter struct {
+	failing atomic.Bool
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	if w.failing.Load() {
+		return 0, errors.New("disk full")
+	}
+	return len(p), nil
+}
+
+func TestCollectorAcknowledge(t *testing.T) {
+	tests := []struct {
+		name   string
+		writer io.Writer
+		fail   bool
+	}{
+		{"delivered", io.Discard, false},
+		{"rejected", failingWriter{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lc := New(audit.NewWithWriter(io.Discard))
+			if err := lc.ConfigureOutputs(OutputConfig{DisableConsole: true, Writers: map[string]io.Writer{"out": test.writer}}); err != nil {
+				t.Fatal(err)
+			}
+			defer lc.Close()
+
+			if err := lc.acknowledge(); err != nil {
+				t.Fatalf("nothing written yet: %v", err)
+			}
+			lc.processSystemLog(&SystemLog{ID: "1", Source: SourceCustom, Level: LevelInfo, Message: "hello"})
+			err := lc.acknowledge()
+			if (err != nil) != test.fail {
+				t.Fatalf("acknowledge() = %v", err)
+			}
+			if test.fail && lc.DeliveryFailures() == 0 {
+				t.Fatal("the failure was not counted")
+			}
+		})
+	}
+}
+
+func TestCheckpointsAdvanceAfterDeliveryRecovers(t *testing.T) {
+	lc := New(audit.NewWithWriter(io.Discard))
+	writer := &flakyWriter{}
+	if err := lc.ConfigureOutputs(OutputConfig{DisableConsole: true, Writers: map[string]io.Writer{"out": writer}}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	if err := lc.ConfigureCheckpoints(path, CheckpointPolicy{FlushEntries: 1 << 30, FlushInterval: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+
+	// batch reads one line up to offset, delivered unless failing
+	batch := func(offset int64, failing bool) error {
+		writer.failing.Store(failing)
+		lc.processSystemLog(&SystemLog{ID: "1", Source: SourceCustom, Level: LevelInfo, Message: "hello"})
+		lc.checkpoints.Update("app", "/var/log/app.log", offset, 1)
+		return lc.checkpoints.Flush(false)
+	}
+	steps := []struct {
+		offset  int64
+		failing bool
+		want    int64
+	}{
+		{100, false, 100},
+		{200, true, 100},
+		{300, false, 300},
+		{400, false, 400},
+	}
+	for i, step := range steps {
+		err := batch(step.offset, step.failing)
+		if step.failing != errors.Is(err, ErrCheckpointsHeld) {
+			t.Fatalf("batch %d: Flush() = %v", i+1, err)
+		}
+		if got := storedOffset(t, path, "app"); got != step.want {
+			t.Fatalf("batch %d: stored offset %d, want %d", i+1, got, step.want)
+		}
+	}
+}
+
+// flakyOutput fails writes while failing is set
+type flakyOutput struct {
+	flakyWriter
+}
+
+func (o *flakyOutput) Name() string { return "flaky" }
+func (o *flakyOutput) Flush() error { return nil }
+func (o *flakyOutput) Close() error { return nil }
+
+func (o *flakyOutput) Write(entry *SystemLog) error {
+	_, err := o.flakyWriter.Write(nil)
+	return err
+}
+
+func TestAcknowledgeOpenCircuit(t *testing.T) {
+	lc := New(audit.NewWithWriter(io.Discard))
+	if err := lc.SetBreakerPolicy(BreakerPolicy{Failures: 1, Cooldown: 20 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lc.ConfigureOutputs(OutputConfig{DisableConsole: true}); err != nil {
+		t.Fatal(err)
+	}
+	output := &flakyOutput{}
+	if err := lc.AddOutput(output); err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Close()
+	write := func() {
+		lc.processSystemLog(&SystemLog{ID: "1", Source: SourceCustom, Level: LevelInfo, Message: "hello"})
+	}
+
+	// The failed write opens the circuit, which skips the next entry
+	output.failing.Store(true)
+	write()
+	if err := lc.acknowledge(); err == nil {
+		t.Fatal("a failed write was acknowledged")
+	}
+	write()
+	if lc.SkippedEntries() != 1 || lc.DeliveryFailures() != 1 {
+		t.Fatalf("%d skipped entries and %d delivery failures, want 1 each", lc.SkippedEntries(), lc.DeliveryFailures())
+	}
+	if err := lc.acknowledge(); err == nil {
+		t.Fatal("a batch with skipped entries was acknowledged")
+	}
+
+	// Once the probe closes the circuit, batches are acknowledged again
+	output.failing.Store(false)
+	time.Sleep(30 * time.Millisecond)
+	write()
+	if err := lc.acknowledge(); err != nil {
+		t.Fatalf("acknowledge() after the circuit closed = %v", err)
+	}
+}
+
+func TestBatchWriterReportsBackgroundFlushError(t *testing.T) {
+	bw := NewBatchWriter(failingWriter{}, 1<<20, 10*time.Millisecond)
+	defer bw.Close()
+	bw.Write([]byte("line\n"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for bw.Buffered() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the background flush did not run")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := bw.Flush(); err == nil {
+		t.Fatal("Flush() did not report the failed background flush")
+	}
+	if err := bw.Flush(); err != nil {
+		t.Fatalf("the failure was reported twice: %v", err)
+	}
+}
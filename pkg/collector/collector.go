@@ -1,16 +1,27 @@
+// Package collector tails log sources, parses lines into SystemLog entries
+// and writes them to outputs. Besides the built-in file sources, parsers and
+// NDJSON outputs it can be extended with the Source, Parser, Processor and
+// Output interfaces (see RegisterParser, AddSource, AddProcessor and
+// AddOutput), so other programs can embed it without the HTTP server.
 package collector
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/audit"
 )
 
 // LogSource defines log source types
@@ -22,6 +33,7 @@ const (
 	SourceApache     LogSource = "apache"
 	SourceDocker     LogSource = "docker"
 	SourceKubernetes LogSource = "kubernetes"
+	SourceCRI        LogSource = "cri"
 	SourceCustom     LogSource = "custom"
 )
 
@@ -39,31 +51,80 @@ const (
 
 // SystemLog represents a system log entry
 type SystemLog struct {
-	ID          string                 `json:"id"`
-	Timestamp   time.Time              `json:"timestamp"`
-	Source      LogSource              `json:"source"`
-	Level       LogLevel               `json:"level"`
-	Message     string                 `json:"message"`
-	Host        string                 `json:"host,omitempty"`
-	Service     string                 `json:"service,omitempty"`
-	PID         int                    `json:"pid,omitempty"`
-	User        string                 `json:"user,omitempty"`
-	IP          string                 `json:"ip,omitempty"`
-	Method      string                 `json:"method,omitempty"`
-	Path        string                 `json:"path,omitempty"`
-	StatusCode  int                    `json:"status_code,omitempty"`
-	RawLog      string                 `json:"raw_log"`
-	ParsedData  map[string]interface{} `json:"parsed_data,omitempty"`
-	Tags        []string               `json:"tags,omitempty"`
-	CollectedAt time.Time              `json:"collected_at"`
+	ID                string                 `json:"id"`
+	Timestamp         time.Time              `json:"timestamp"` // normalized to UTC
+	OriginalTimestamp string                 `json:"original_timestamp,omitempty"`
+	Source            LogSource              `json:"source"`
+	Level             LogLevel               `json:"level"`
+	Message           string                 `json:"message"`
+	Host              string                 `json:"host,omitempty"`
+	Service           string                 `json:"service,omitempty"`
+	PID               int                    `json:"pid,omitempty"`
+	User              string                 `json:"user,omitempty"`
+	IP                string                 `json:"ip,omitempty"`
+	Method            string                 `json:"method,omitempty"`
+	Path              string                 `json:"path,omitempty"`
+	StatusCode        int                    `json:"status_code,omitempty"`
+	RawLog            string                 `json:"raw_log"`
+	ParsedData        map[string]interface{} `json:"parsed_data,omitempty"`
+	Tags              []string               `json:"tags,omitempty"`
+	CollectedAt       time.Time              `json:"collected_at"`
+	// Fingerprint is the same for every read of a line, see Fingerprint.
+	// It is empty for entries without a source offset.
+	Fingerprint string `json:"fingerprint,omitempty"`
 }
 
 // LogCollector manages the log collection system
 type LogCollector struct {
 	auditLogger *audit.Logger
 	parsers     map[LogSource]*LogParser
-	sources     []LogSourceConfig
-	running     bool
+	registry    sourceRegistry
+
+	// lifecycleMu serializes Start/Stop transitions; running is read lock-free
+	lifecycleMu sync.Mutex
+	running     atomic.Bool
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+
+	// per-source runtime state kept across restarts of the source goroutine
+	statesMu sync.RWMutex
+	states   map[string]*sourceState
+
+	outputs       []Output
+	processors    []Processor
+	customSources []Source
+	checkpoints   *CheckpointStore
+	forwarder     LineForwarder
+	self          *selfMonitor
+	subs          subscribers
+	recent        *recentEntries
+	statusLevels  atomic.Pointer[StatusLevelRules]
+	quotas        atomic.Pointer[[]*quota]
+	usage         *usageTracker
+	catalog       *fileCatalog
+	fieldMap      atomic.Pointer[FieldMap]
+	formatParsers sync.Map // log_format definition → *LogParser
+	criPartials   sync.Map // source and stream → *criPartial
+
+	// deliveryFailures counts entries and lines an output or the forwarder
+	// did not accept
+	deliveryFailures atomic.Uint64
+	// skippedEntries counts entries not written to an output whose
+	// circuit was open
+	skippedEntries atomic.Uint64
+	// acknowledged and acknowledgedSkips are the counters at the last
+	// checkpoint barrier; checkpointsHeld is set while the barrier fails
+	acknowledged      atomic.Uint64
+	acknowledgedSkips atomic.Uint64
+	checkpointsHeld   atomic.Bool
+
+	maintenance maintenance
+
+	breakerPolicy  atomic.Pointer[BreakerPolicy]
+	outputBreakers sync.Map // output name → *breaker
+
+	// pollOnly disables file notifications, see SetFileWatch
+	pollOnly atomic.Bool
 }
 
 // LogSourceConfig log source configuration
@@ -75,6 +136,34 @@ type LogSourceConfig struct {
 	Enabled  bool      `json:"enabled"`
 	Tags     []string  `json:"tags,omitempty"`
 	Interval int       `json:"interval"` // seconds
+
+	// TimestampLayouts overrides the Go time layouts tried when parsing
+	// timestamps from this source
+	TimestampLayouts []string `json:"timestamp_layouts,omitempty"`
+	// Timezone is the IANA location of timestamps without a zone offset
+	// (e.g. "Europe/Istanbul"). Defaults to the local timezone.
+	Timezone string `json:"timezone,omitempty"`
+
+	// MaxInterval caps the adaptive poll interval of idle files (seconds).
+	// Defaults to 8x Interval.
+	MaxInterval int `json:"max_interval,omitempty"`
+
+	// LogFormat is the nginx log_format definition the lines were written
+	// with, replacing the built-in parser of the source
+	LogFormat string `json:"log_format,omitempty"`
+
+	// FieldMap names the parsed fields of this source that fill the
+	// canonical entry fields, tried before the global field map
+	FieldMap FieldMap `json:"field_map,omitempty"`
+
+	// StallAfter is the longest the source may go without new lines
+	// (seconds) before it is reported as stalled; 0 disables the check
+	StallAfter int `json:"stall_after,omitempty"`
+
+	// ClockSkew is how far the clock of the host that wrote the lines is
+	// ahead of ours; it is subtracted from the timestamps parsed from them.
+	// It is set by inputs receiving lines from remote senders.
+	ClockSkew time.Duration `json:"-"`
 }
 
 // LogParser log parser
@@ -82,6 +171,27 @@ type LogParser struct {
 	Source  LogSource
 	Pattern *regexp.Regexp
 	Fields  []string
+
+	// kinds is Fields resolved to SystemLog fields, see newLogParser
+	kinds []fieldKind
+}
+
+// newLogParser creates a parser with its field mapping pre-compiled
+func newLogParser(source LogSource, pattern *regexp.Regexp, fields []string) *LogParser {
+	return &LogParser{
+		Source:  source,
+		Pattern: pattern,
+		Fields:  fields,
+		kinds:   compileFieldKinds(fields),
+	}
+}
+
+// fieldKind returns the SystemLog field that capture group i maps to
+func (p *LogParser) fieldKind(i int) fieldKind {
+	if p.kinds != nil {
+		return p.kinds[i]
+	}
+	return fieldKinds[p.Fields[i]]
 }
 
 // New creates a new log collector
@@ -89,11 +199,17 @@ func New(auditLogger *audit.Logger) *LogCollector {
 	collector := &LogCollector{
 		auditLogger: auditLogger,
 		parsers:     make(map[LogSource]*LogParser),
-		running:     false,
+		states:      make(map[string]*sourceState),
+		usage:       newUsageTracker(),
+		catalog:     newFileCatalog(),
+		outputs: []Output{
+			newConsoleOutput(DefaultOutputBufferSize, DefaultOutputFlushInterval),
+		},
 	}
 
 	// Add default parsers
 	collector.initDefaultParsers()
+	collector.SetStatusLevelRules(DefaultStatusLevelRules())
 
 	// Add default log sources
 	collector.initDefaultSources()
@@ -104,28 +220,32 @@ func New(auditLogger *audit.Logger) *LogCollector {
 // initDefaultParsers initializes default log parsers
 func (lc *LogCollector) initDefaultParsers() {
 	// Syslog parser
-	syslogPattern := regexp.MustCompile(`^(\w+\s+\d+\s+\d+:\d+:\d+)\s+(\S+)\s+(\S+)(\[\d+\])?\s*:\s*(.*)$`)
-	lc.parsers[SourceSyslog] = &LogParser{
-		Source:  SourceSyslog,
-		Pattern: syslogPattern,
-		Fields:  []string{"timestamp", "host", "service", "pid", "message"},
-	}
-
-	// Nginx access log parser
-	nginxPattern := regexp.MustCompile(`^(\S+)\s+-\s+\S+\s+\[([^\]]+)\]\s+"(\S+)\s+(\S+)\s+\S+"\s+(\d+)\s+(\d+)\s+"[^"]*"\s+"([^"]*)"`)
-	lc.parsers[SourceNginx] = &LogParser{
-		Source:  SourceNginx,
-		Pattern: nginxPattern,
-		Fields:  []string{"ip", "timestamp", "method", "path", "status", "size", "user_agent"},
-	}
+	syslogPattern := regexp.MustCompile(`^(\w+\s+\d+\s+\d+:\d+:\d+)\s+(\S+)\s+([^\s\[:]+)(?:\[(\d+)\])?\s*:\s*(.*)$`)
+	lc.parsers[SourceSyslog] = newLogParser(SourceSyslog, syslogPattern,
+		[]string{"timestamp", "host", "service", "pid", "message"})
+
+	// Nginx access log parser for the combined format, optionally followed
+	// by $request_time and $upstream_response_time
+	nginxPattern := regexp.MustCompile(`^(\S+)\s+-\s+\S+\s+\[([^\]]+)\]\s+"(\S+)\s+(\S+)\s+\S+"\s+(\d+)\s+(\d+)\s+"[^"]*"\s+"([^"]*)"` +
+		`(?:\s+([\d.]+|-)(?:\s+([\d.]+(?:\s*[,:]\s*(?:[\d.]+|-))*|-))?)?`)
+	lc.parsers[SourceNginx] = newLogParser(SourceNginx, nginxPattern,
+		[]string{"ip", "timestamp", "method", "path", "status", "size", "user_agent", "request_time", "upstream_response_time"})
+
+	// Apache common and combined log format parser
+	apachePattern := regexp.MustCompile(`^(\S+)\s+\S+\s+(\S+)\s+\[([^\]]+)\]\s+"(\S+)\s+(\S+)[^"]*"\s+(\d{3})\s+(\S+)(?:\s+"[^"]*"\s+"([^"]*)")?`)
+	lc.parsers[SourceApache] = newLogParser(SourceApache, apachePattern,
+		[]string{"ip", "user", "timestamp", "method", "path", "status", "size", "user_agent"})
 
 	// Docker log parser
 	dockerPattern := regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d+Z)\s+(.*)$`)
-	lc.parsers[SourceDocker] = &LogParser{
-		Source:  SourceDocker,
-		Pattern: dockerPattern,
-		Fields:  []string{"timestamp", "message"},
-	}
+	lc.parsers[SourceDocker] = newLogParser(SourceDocker, dockerPattern,
+		[]string{"timestamp", "message"})
+
+	// CRI (containerd, CRI-O) container log parser; partial lines are
+	// joined by reassembleCRI before they get here
+	criPattern := regexp.MustCompile(`^(\S+) (stdout|stderr) (\S+) ?(.*)$`)
+	lc.parsers[SourceCRI] = newLogParser(SourceCRI, criPattern,
+		[]string{"timestamp", "stream", "logtag", "message"})
 }
 
 // initDefaultSources initializes default log sources
@@ -133,7 +253,7 @@ func (lc *LogCollector) initDefaultSources() {
 	// Get working directory
 	workDir, _ := os.Getwd()
 
-	lc.sources = []LogSourceConfig{
+	lc.registry.replace([]LogSourceConfig{
 		{
 			Name:     "test_syslog",
 			Source:   SourceSyslog,
@@ -182,150 +302,390 @@ func (lc *LogCollector) initDefaultSources() {
 			Tags:     []string{"security", "auth"},
 			Interval: 5,
 		},
-	}
+	})
 }
 
 // Start begins the log collection process
 func (lc *LogCollector) Start() error {
-	if lc.running {
+	lc.lifecycleMu.Lock()
+	defer lc.lifecycleMu.Unlock()
+
+	if lc.running.Load() {
 		return fmt.Errorf("log collector already running")
 	}
+	if lc.InMaintenance() {
+		return ErrMaintenance
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lc.cancel = cancel
+	lc.running.Store(true)
+
+	sources := lc.registry.list()
 
-	lc.running = true
 	lc.auditLogger.LogEvent(audit.AuditEvent{
 		EventType: "log_collector_start",
 		Message:   "System log collection started",
 		Details: map[string]interface{}{
-			"sources_count": len(lc.sources),
+			"sources_count": len(sources) + len(lc.customSources),
 			"enabled_sources": func() []string {
 				var enabled []string
-				for _, source := range lc.sources {
+				for _, source := range sources {
 					if source.Enabled {
 						enabled = append(enabled, source.Name)
 					}
 				}
+				for _, source := range lc.customSources {
+					enabled = append(enabled, source.Config().Name)
+				}
 				return enabled
 			}(),
 		},
 	})
 
 	// Start goroutine for each enabled source
-	for _, source := range lc.sources {
+	for _, source := range sources {
 		if source.Enabled {
-			go lc.collectFromSource(source)
+			state := lc.sourceStateFor(source)
+			lc.wg.Add(1)
+			go func(source LogSourceConfig) {
+				defer lc.wg.Done()
+				lc.superviseSource(ctx, source, state, func(ctx context.Context) error {
+					return lc.collectFromSource(ctx, source, state)
+				})
+			}(source)
 		}
 	}
 
+	custom := make([]LogSourceConfig, 0, len(lc.customSources))
+	for _, source := range lc.customSources {
+		config := source.Config()
+		custom = append(custom, config)
+		state := lc.sourceStateFor(config)
+		lc.wg.Add(1)
+		go func(source Source) {
+			defer lc.wg.Done()
+			lc.superviseSource(ctx, config, state, func(ctx context.Context) error {
+				return lc.runCustomSource(ctx, source, config, state)
+			})
+		}(source)
+	}
+
+	lc.wg.Add(1)
+	go func() {
+		defer lc.wg.Done()
+		lc.watchStalls(ctx, custom)
+	}()
+
 	return nil
 }
 
-// Stop stops the log collection process
+// Stop stops the log collection process and blocks until every source
+// goroutine has finished its current read and returned
 func (lc *LogCollector) Stop() {
-	lc.running = false
+	lc.lifecycleMu.Lock()
+	defer lc.lifecycleMu.Unlock()
+
+	if !lc.running.Load() {
+		return
+	}
+
+	lc.cancel()
+	lc.wg.Wait()
+	lc.cancel = nil
+	lc.running.Store(false)
+
+	// Make sure everything read so far has reached the outputs before the
+	// final positions are persisted
+	lc.flushOutputs()
+	if lc.checkpoints != nil {
+		// A hold was already reported and is reported again on Close
+		if err := lc.checkpoints.Flush(true); err != nil && !errors.Is(err, ErrCheckpointsHeld) {
+			lc.auditLogger.LogError(err, "Failed to write checkpoints", nil)
+		}
+	}
+
 	lc.auditLogger.LogEvent(audit.AuditEvent{
 		EventType: "log_collector_stop",
 		Message:   "System log collection stopped",
 	})
 }
 
-// collectFromSource collects logs from a specific source
-func (lc *LogCollector) collectFromSource(config LogSourceConfig) {
-	ticker := time.NewTicker(time.Duration(config.Interval) * time.Second)
-	defer ticker.Stop()
+// collectFromSource collects logs from a specific source until ctx is cancelled.
+// It returns an error when the source can no longer be read so the supervisor
+// can restart it with backoff. Where file notifications are available the
+// file is read as soon as it changes and only re-checked every MaxInterval,
+// for writes notifications can miss, e.g. on network file systems. Otherwise
+// idle files are polled less and less often, down to the source's
+// MaxInterval, until new data shows up again.
+func (lc *LogCollector) collectFromSource(ctx context.Context, config LogSourceConfig, state *sourceState) error {
+	poll := newAdaptiveInterval(config)
+	tail := &tailedFile{}
+	defer tail.close()
+	watch := lc.watchSource(config, state)
+	var changed <-chan struct{}
+	var failed <-chan struct{}
+	if watch != nil {
+		defer watch.Close()
+		changed, failed = watch.changed, watch.failed
+	}
+	state.setPollInterval(poll.current())
 
-	var lastPosition int64 = 0
+	timer := time.NewTimer(poll.current())
+	defer timer.Stop()
 
-	for lc.running {
+	for {
 		select {
-		case <-ticker.C:
-			// Check log file
-			if _, err := os.Stat(config.Path); os.IsNotExist(err) {
-				// File doesn't exist, continue
-				continue
-			}
+		case <-ctx.Done():
+			return nil
+		case <-failed:
+			lc.auditLogger.LogEvent(audit.AuditEvent{
+				EventType: "source_watch_failed",
+				Message:   fmt.Sprintf("Watching log source %s failed, polling it instead", config.Name),
+				Error:     watch.Err().Error(),
+				Details:   map[string]interface{}{"source": config.Name, "path": config.Path},
+			})
+			watch.Close()
+			watch, changed, failed = nil, nil, nil
+			state.setTailing(TailingPoll)
+		case <-changed:
+		case <-timer.C:
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
 
-			// Open file
-			file, err := os.Open(config.Path)
-			if err != nil {
-				lc.auditLogger.LogError(err, fmt.Sprintf("Failed to open log file: %s", config.Path), map[string]interface{}{
-					"source": config.Name,
-					"path":   config.Path,
-				})
-				continue
-			}
+		before := state.getOffset()
+		if err := lc.readNewLines(config, state, tail); err != nil {
+			return err
+		}
+		lc.sourceSucceeded(config, state)
 
-			// Get file info
-			fileInfo, err := file.Stat()
-			if err != nil {
-				file.Close()
-				continue
-			}
+		poll.observe(state.getOffset() != before)
+		next := poll.current()
+		if watch != nil && !state.isPaused() {
+			// Changes wake the source up; the timer only catches missed ones
+			next = poll.max
+		}
+		state.setPollInterval(next)
+		timer.Reset(next)
+	}
+}
 
-			// If file is smaller than last position, file might have been rotated
-			if fileInfo.Size() < lastPosition {
-				lastPosition = 0
-			}
+// watchSource watches the file of a source for changes. It returns nil
+// when the source is to be polled.
+func (lc *LogCollector) watchSource(config LogSourceConfig, state *sourceState) *fileWatch {
+	if lc.pollOnly.Load() {
+		state.setTailing(TailingPoll)
+		return nil
+	}
+	watch, err := watchFile(config.Path)
+	if err != nil {
+		state.setTailing(TailingPoll)
+		lc.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: "source_watch_failed",
+			Message:   fmt.Sprintf("Cannot watch log source %s, polling it instead", config.Name),
+			Error:     err.Error(),
+			Details:   map[string]interface{}{"source": config.Name, "path": config.Path},
+		})
+		return nil
+	}
+	state.setTailing(TailingNotify)
+	return watch
+}
 
-			// Seek to last position
-			if _, err := file.Seek(lastPosition, 0); err != nil {
-				file.Close()
-				continue
-			}
+// SetFileWatch turns file notifications off (or back on), so every source
+// is polled on its interval. It takes effect when the sources are next
+// started.
+func (lc *LogCollector) SetFileWatch(enabled bool) {
+	lc.pollOnly.Store(!enabled)
+}
 
-			// Read new lines
-			scanner := bufio.NewScanner(file)
-			for scanner.Scan() {
-				line := scanner.Text()
-				if systemLog := lc.parseLogLine(line, config); systemLog != nil {
-					lc.processSystemLog(*systemLog)
-				}
-			}
+// readNewLines reads lines appended to the source file since the last
+// recorded position and saves the new read position in state. tail is the
+// file kept open between reads. While its path is gone, e.g. right after a
+// rename, it is read on; once another file takes the path, it is read to
+// its end and the new file is read from the start.
+func (lc *LogCollector) readNewLines(config LogSourceConfig, state *sourceState, tail *tailedFile) error {
+	if !keepFilesOpen {
+		defer tail.close()
+	}
+
+	// Check log file
+	info, statErr := os.Stat(config.Path)
+	if tail.file != nil && statErr == nil && !os.SameFile(tail.info, info) {
+		if done, err := lc.drainRotated(config, state, tail); err != nil || !done {
+			return err
+		}
+	}
+	if tail.file == nil && os.IsNotExist(statErr) {
+		// File doesn't exist, continue
+		return nil
+	}
 
-			// Save new position
-			newPosition, _ := file.Seek(0, 1)
-			lastPosition = newPosition
+	// Nothing was appended since the last read, skip reading the file
+	lastPosition := state.getOffset()
+	if statErr == nil && lastPosition > 0 && info.Size() == lastPosition {
+		return nil
+	}
 
-			file.Close()
+	// Open file
+	if tail.file == nil {
+		if err := tail.open(config.Path); err != nil {
+			return err
 		}
 	}
+	file := tail.file
+
+	// Get file info
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat log file %s: %w", config.Path, err)
+	}
+	state.setFileSize(fileInfo.Size())
+
+	// If file is smaller than last position, it was truncated, e.g. by a
+	// copytruncate rotation
+	if fileInfo.Size() < lastPosition {
+		lastPosition = 0
+	}
+	// A rotated file can also have grown past the last position already
+	if lastPosition, err = lc.checkFingerprint(config, state, file, fileInfo.Size(), lastPosition); err != nil {
+		return err
+	}
+	if previous := state.getOffset(); lastPosition == 0 && previous > 0 {
+		lc.catalog.release(config.Name)
+		rotation := RotationReplaced
+		if fileInfo.Size() < previous {
+			rotation = RotationTruncated
+		}
+		lc.logRotation(config, state, rotation, previous, 0)
+	}
+
+	_, err = lc.readFrom(config, state, file, fileInfo.Size(), lastPosition)
+	return err
 }
 
-// parseLogLine parses a log line based on source type
-func (lc *LogCollector) parseLogLine(line string, config LogSourceConfig) *SystemLog {
+// readFrom reads the lines of file from lastPosition on, up to a line
+// deferred by a pausing quota, and saves the new read position in state.
+// It reports whether it read to the end of the file.
+func (lc *LogCollector) readFrom(config LogSourceConfig, state *sourceState, file *os.File, size, lastPosition int64) (bool, error) {
+	// Seek to last position
+	if _, err := file.Seek(lastPosition, 0); err != nil {
+		return false, fmt.Errorf("failed to seek log file %s: %w", config.Path, err)
+	}
+
+	// Read new lines, up to a line deferred by a pausing quota
+	lines := 0
+	offset := lastPosition
+	var pausedUntil time.Time
+	// consumed is the size of the last line with its line ending, which
+	// may be a CRLF or missing at the end of the file
+	var consumed int64
+	scanner := bufio.NewScanner(file)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil {
+			consumed = int64(advance)
+		}
+		return advance, token, err
+	})
+	for scanner.Scan() {
+		line := scanner.Text()
+		if pausedUntil = lc.handleLine(line, offset, config); !pausedUntil.IsZero() {
+			break
+		}
+		lines++
+		offset += consumed
+	}
+	state.setPausedUntil(pausedUntil)
+
+	// Save new position
+	newPosition, _ := file.Seek(0, 1)
+	if !pausedUntil.IsZero() {
+		newPosition = offset
+	}
+	state.setOffset(newPosition)
+	if newPosition != lastPosition {
+		state.markActivity()
+		fp := state.getFingerprint()
+		lc.saveCheckpoint(config, newPosition, fp, lines)
+		lc.catalog.record(config, fp, fp.size >= lc.fingerprintBytes(), size, lastPosition, newPosition, lines)
+	}
+	return pausedUntil.IsZero(), scanner.Err()
+}
+
+// ParseStatus describes how a line was handled by the parser
+type ParseStatus string
+
+const (
+	// ParseSkipped means the line was empty and produced no entry
+	ParseSkipped ParseStatus = "skipped"
+	// ParseMatched means the source parser matched the line
+	ParseMatched ParseStatus = "matched"
+	// ParseUnmatched means the source parser didn't match and the line was kept raw
+	ParseUnmatched ParseStatus = "unmatched"
+	// ParseRaw means the source has no parser and the line was kept raw
+	ParseRaw ParseStatus = "raw"
+)
+
+// parseLogLine parses a log line based on source type.
+// The returned entry comes from a pool; callers release it with releaseSystemLog.
+func (lc *LogCollector) parseLogLine(line string, config LogSourceConfig) (*SystemLog, ParseStatus) {
 	if strings.TrimSpace(line) == "" {
-		return nil
+		return nil, ParseSkipped
 	}
 
-	systemLog := &SystemLog{
-		ID:          fmt.Sprintf("log_%d%06d", time.Now().Unix(), time.Now().Nanosecond()/1000),
-		Source:      config.Source,
-		RawLog:      line,
-		Tags:        config.Tags,
-		CollectedAt: time.Now(),
-		ParsedData:  make(map[string]interface{}),
+	now := time.Now()
+	systemLog := acquireSystemLog()
+	systemLog.ID = newLogID(now)
+	systemLog.Source = config.Source
+	systemLog.RawLog = line
+	systemLog.Tags = config.Tags
+	systemLog.CollectedAt = now
+
+	// Registered parsers take precedence over the built-in ones
+	if custom, exists := registeredParsers()[config.Source]; exists {
+		systemLog.Timestamp = now.UTC()
+		if !custom.Parse(line, config, systemLog) {
+			systemLog.Message = line
+			systemLog.Level = lc.detectLogLevel(line)
+			return systemLog, ParseUnmatched
+		}
+		lc.normalize(systemLog, config.FieldMap)
+		correctClockSkew(systemLog, config.ClockSkew, now)
+		if systemLog.Level == "" {
+			systemLog.Level = lc.detectLogLevel(systemLog.Message)
+		}
+		return systemLog, ParseMatched
 	}
 
 	// If no parser exists, save as raw log
 	parser, exists := lc.parsers[config.Source]
+	if config.LogFormat != "" {
+		if formatParser, err := lc.formatParser(config.Source, config.LogFormat); err == nil {
+			parser, exists = formatParser, true
+		}
+	}
 	if !exists {
-		systemLog.Timestamp = time.Now()
+		systemLog.Timestamp = now.UTC()
 		systemLog.Message = line
 		systemLog.Level = lc.detectLogLevel(line)
-		return systemLog
+		return systemLog, ParseRaw
 	}
 
 	// Parse with regex
 	matches := parser.Pattern.FindStringSubmatch(line)
 	if matches == nil {
 		// If parsing fails, save as raw log
-		systemLog.Timestamp = time.Now()
+		systemLog.Timestamp = now.UTC()
 		systemLog.Message = line
 		systemLog.Level = lc.detectLogLevel(line)
-		return systemLog
+		return systemLog, ParseUnmatched
 	}
 
 	// Convert parsed data to SystemLog
-	systemLog.Timestamp = time.Now() // default
+	systemLog.Timestamp = now.UTC() // default
 
 	// Map data to parser fields
 	for i, field := range parser.Fields {
@@ -334,99 +694,165 @@ func (lc *LogCollector) parseLogLine(line string, config LogSourceConfig) *Syste
 			systemLog.ParsedData[field] = value
 
 			// Copy special fields to system's corresponding fields
-			switch field {
-			case "timestamp":
-				if ts, err := lc.parseTimestamp(value); err == nil {
+			switch parser.fieldKind(i) {
+			case fieldTimestamp:
+				systemLog.OriginalTimestamp = value
+				if ts, err := lc.parseTimestamp(value, config, now); err == nil {
 					systemLog.Timestamp = ts
 				}
-			case "message":
+			case fieldMessage:
 				systemLog.Message = value
 				systemLog.Level = lc.detectLogLevel(value)
-			case "host":
+			case fieldHost:
 				systemLog.Host = value
-			case "service":
+			case fieldService:
 				systemLog.Service = value
-			case "ip":
+			case fieldIP:
 				systemLog.IP = value
-			case "method":
+			case fieldMethod:
 				systemLog.Method = value
-			case "path":
+			case fieldPath:
 				systemLog.Path = value
-			case "status":
+			case fieldStatus:
 				if statusCode, err := parseStatusCode(value); err == nil {
 					systemLog.StatusCode = statusCode
 				}
+			case fieldUser:
+				if value != "-" {
+					systemLog.User = value
+				}
+			case fieldPID:
+				if pid, err := strconv.Atoi(value); err == nil {
+					systemLog.PID = pid
+				}
+			case fieldCount:
+				if n, ok := parseCount(value); ok {
+					systemLog.ParsedData[field] = n
+				} else {
+					delete(systemLog.ParsedData, field)
+				}
+			case fieldSeconds:
+				if seconds, ok := parseSeconds(value); ok {
+					systemLog.ParsedData[field] = seconds
+				} else {
+					delete(systemLog.ParsedData, field)
+				}
 			}
 		}
 	}
 
-	return systemLog
-}
+	lc.normalize(systemLog, config.FieldMap)
+	correctClockSkew(systemLog, config.ClockSkew, now)
 
-// processSystemLog processes a system log
-func (lc *LogCollector) processSystemLog(log SystemLog) {
-	// Write to console in structured format
-	jsonData, err := json.Marshal(log)
-	if err != nil {
-		lc.auditLogger.LogError(err, "Failed to marshal system log", map[string]interface{}{
-			"log_id": log.ID,
-		})
-		return
+	// Web access entries are classified by status, not message keywords
+	if systemLog.StatusCode > 0 {
+		systemLog.Level = lc.statusLevel(systemLog)
 	}
 
-	// Print to console with SYSTEM_LOG prefix
-	fmt.Printf("[SYSTEM_LOG] %s\n", string(jsonData))
-
-	// Additional processing can be added here
-	// - Database insertion
-	// - Alert checking
-	// - Metric collection
-	// - External system integration
+	return systemLog, ParseMatched
 }
 
-// parseTimestamp parses timestamp from log
-func (lc *LogCollector) parseTimestamp(ts string) (time.Time, error) {
-	// Common timestamp formats
-	formats := []string{
-		"Jan 2 15:04:05",
-		"2006-01-02T15:04:05.999999999Z07:00",
-		"2006-01-02T15:04:05Z",
-		"2006-01-02 15:04:05",
-		"02/Jan/2006:15:04:05 -0700",
-	}
-
-	for _, format := range formats {
-		if t, err := time.Parse(format, ts); err == nil {
-			// If year is missing, use current year
-			if t.Year() == 0 {
-				now := time.Now()
-				t = t.AddDate(now.Year(), 0, 0)
+// processSystemLog runs a system log through the processors and writes it
+// to every output. It reports whether the processors kept the entry.
+func (lc *LogCollector) processSystemLog(log *SystemLog) bool {
+	for _, processor := range lc.processors {
+		if !processor.Process(log) {
+			return false
+		}
+	}
+	lc.publish(log)
+
+	// Encode once in structured format for all NDJSON outputs
+	var encoded *bytes.Buffer
+	for _, output := range lc.outputs {
+		circuit := lc.outputBreaker(output)
+		if !circuit.allow() {
+			// A resting output misses the entry; see SkippedEntries
+			lc.skippedEntries.Add(1)
+			continue
+		}
+		ndjson, ok := output.(*logOutput)
+		if !ok {
+			if err := output.Write(log); err != nil {
+				lc.reportOutputError(output, log, err)
+			} else {
+				lc.outputSucceeded(output, circuit)
+				lc.usage.addOutput(output.Name(), len(log.RawLog))
 			}
-			return t, nil
+			continue
+		}
+		if encoded == nil {
+			encoded = acquireBuffer()
+			defer releaseBuffer(encoded)
+			if err := json.NewEncoder(encoded).Encode(log); err != nil {
+				lc.auditLogger.LogError(err, "Failed to marshal system log", map[string]interface{}{
+					"log_id": log.ID,
+				})
+				return true
+			}
+		}
+		if err := ndjson.write(encoded.Bytes()); err != nil {
+			lc.reportOutputError(output, log, err)
+		} else {
+			lc.outputSucceeded(output, circuit)
+			lc.usage.addOutput(output.Name(), encoded.Len())
 		}
 	}
+	return true
+}
 
-	return time.Time{}, fmt.Errorf("unable to parse timestamp: %s", ts)
+// reportOutputError records a failed output write. Failures are logged
+// until they open the output's circuit, which is logged once instead.
+func (lc *LogCollector) reportOutputError(output Output, log *SystemLog, err error) {
+	if err != nil {
+		lc.deliveryFailures.Add(1)
+		circuit := lc.outputBreaker(output)
+		switch transition := circuit.failure(err, time.Now()); transition {
+		case circuitUnchanged:
+			lc.auditLogger.LogError(err, "Failed to write system log", map[string]interface{}{
+				"log_id": log.ID,
+				"output": output.Name(),
+			})
+		default:
+			lc.logCircuit("output", output.Name(), circuit, transition, err)
+		}
+	}
+}
+
+// outputSucceeded records a working output write, closing its circuit
+// after a successful probe
+func (lc *LogCollector) outputSucceeded(output Output, circuit *breaker) {
+	if circuit.success() == circuitClosed {
+		lc.logCircuit("output", output.Name(), circuit, circuitClosed, nil)
+	}
+}
+
+// levelKeywords are checked in order by detectLogLevel; the first match wins
+var levelKeywords = []struct {
+	level    LogLevel
+	keywords []string
+}{
+	{LevelFatal, []string{"fatal", "panic"}},
+	{LevelError, []string{"error", "err"}},
+	{LevelWarn, []string{"warn", "warning"}},
+	{LevelDebug, []string{"debug"}},
+	{LevelInfo, []string{"info"}},
 }
 
 // detectLogLevel detects log level from message
 func (lc *LogCollector) detectLogLevel(message string) LogLevel {
-	lower := strings.ToLower(message)
+	return DetectLogLevel(message)
+}
 
-	if strings.Contains(lower, "fatal") || strings.Contains(lower, "panic") {
-		return LevelFatal
-	}
-	if strings.Contains(lower, "error") || strings.Contains(lower, "err") {
-		return LevelError
-	}
-	if strings.Contains(lower, "warn") || strings.Contains(lower, "warning") {
-		return LevelWarn
-	}
-	if strings.Contains(lower, "debug") {
-		return LevelDebug
-	}
-	if strings.Contains(lower, "info") {
-		return LevelInfo
+// DetectLogLevel guesses the level of a message from keywords such as
+// "error" or "warning"
+func DetectLogLevel(message string) LogLevel {
+	for _, candidate := range levelKeywords {
+		for _, keyword := range candidate.keywords {
+			if containsFold(message, keyword) {
+				return candidate.level
+			}
+		}
 	}
 
 	return LevelUnknown
@@ -439,12 +865,47 @@ func parseStatusCode(s string) (int, error) {
 	return statusCode, err
 }
 
-// GetSources returns all log sources
-func (lc *LogCollector) GetSources() []LogSourceConfig {
-	return lc.sources
+// correctClockSkew moves a timestamp parsed from the line by the sender's
+// clock skew. The sender's time is kept in OriginalTimestamp and the skew
+// in parsed_data.clock_skew. Timestamps defaulting to the read time are
+// already on our clock.
+func correctClockSkew(log *SystemLog, skew time.Duration, readAt time.Time) {
+	if skew == 0 || log.Timestamp.Equal(readAt) {
+		return
+	}
+	if log.OriginalTimestamp == "" {
+		log.OriginalTimestamp = log.Timestamp.Format(time.RFC3339Nano)
+	}
+	log.Timestamp = log.Timestamp.Add(-skew)
+	log.ParsedData["clock_skew"] = skew.Round(time.Millisecond).String()
+}
+
+// ParseLine parses a single line as it would be parsed for the given source.
+// Unlike the internal hot path the returned entry is owned by the caller.
+// Skipped lines return a zero SystemLog. Partial CRI lines are held and
+// reported as skipped until the line completing them is parsed.
+func (lc *LogCollector) ParseLine(line string, config LogSourceConfig) (SystemLog, ParseStatus) {
+	if config.Source == SourceCRI {
+		var complete bool
+		if line, complete = lc.reassembleCRI(line, config.Name); !complete {
+			return SystemLog{}, ParseSkipped
+		}
+	}
+	systemLog, status := lc.parseLogLine(line, config)
+	if systemLog == nil {
+		return SystemLog{}, status
+	}
+	defer releaseSystemLog(systemLog)
+
+	result := *systemLog
+	result.ParsedData = make(map[string]interface{}, len(systemLog.ParsedData))
+	for key, value := range systemLog.ParsedData {
+		result.ParsedData[key] = value
+	}
+	return result, status
 }
 
 // IsRunning returns whether collector is running
 func (lc *LogCollector) IsRunning() bool {
-	return lc.running
+	return lc.running.Load()
 }
@@ -0,0 +1,168 @@
+package collector
+
+import (
+	"math"
+	"time"
+)
+
+// ringColumns keep the fields searches filter on most, timestamp, source,
+// level and status, in arrays parallel to the entries of a ring. Scanning
+// them touches a few bytes per entry instead of the whole entry, and
+// entries are only read once their columns pass the filters.
+type ringColumns struct {
+	timestamps []int64  // Unix nanoseconds, see columnTime
+	sources    []uint16 // IDs of columnValues
+	levels     []uint16 // IDs of columnValues
+	statuses   []uint16
+}
+
+func newRingColumns(size int) ringColumns {
+	return ringColumns{
+		timestamps: make([]int64, size),
+		sources:    make([]uint16, size),
+		levels:     make([]uint16, size),
+		statuses:   make([]uint16, size),
+	}
+}
+
+// set fills the columns of slot i from log
+func (c *ringColumns) set(i int, log *SystemLog, values *columnValues) {
+	c.timestamps[i] = columnTime(log.Timestamp)
+	c.sources[i] = values.id(string(log.Source))
+	c.levels[i] = values.id(string(log.Level))
+	if log.StatusCode < 0 || log.StatusCode >= overflowValue {
+		c.statuses[i] = overflowValue
+	} else {
+		c.statuses[i] = uint16(log.StatusCode)
+	}
+}
+
+// columnTime converts t to Unix nanoseconds, saturating outside the range
+// they can represent
+func columnTime(t time.Time) int64 {
+	switch {
+	case t.Year() < 1678:
+		return math.MinInt64
+	case t.Year() > 2261:
+		return math.MaxInt64
+	}
+	return t.UnixNano()
+}
+
+// columnValues interns the source and level names of the columns. Both
+// take few distinct values; names beyond the table's capacity share the
+// last ID, which filters never rule out (as statuses that don't fit).
+type columnValues struct {
+	ids   map[string]uint16
+	names []string
+}
+
+// overflowValue is the column value of names and statuses that didn't fit
+const overflowValue = math.MaxUint16
+
+func newColumnValues() *columnValues {
+	return &columnValues{ids: make(map[string]uint16)}
+}
+
+func (v *columnValues) id(name string) uint16 {
+	if id, ok := v.ids[name]; ok {
+		return id
+	}
+	if len(v.names) >= overflowValue {
+		return overflowValue
+	}
+	id := uint16(len(v.names))
+	v.ids[name] = id
+	v.names = append(v.names, name)
+	return id
+}
+
+// columnFilter evaluates the terms of a query that only depend on column
+// fields. A term's outcome only depends on the value of its field, so it is
+// computed once per distinct value and remembered.
+type columnFilter struct {
+	values *columnValues
+	terms  []*columnTerm
+}
+
+type columnTerm struct {
+	node    *fieldNode
+	negated bool
+	outcome map[uint16]bool
+}
+
+// newColumnFilter returns the filter for the terms of q every match must
+// satisfy (the query itself or the operands of its top-level AND, maybe
+// negated) on source, level or status, or nil when there are none
+func newColumnFilter(q *Query, values *columnValues) *columnFilter {
+	if q == nil || q.root == nil {
+		return nil
+	}
+	terms := []queryNode{q.root}
+	if and, ok := q.root.(andNode); ok {
+		terms = and
+	}
+	filter := &columnFilter{values: values}
+	for _, term := range terms {
+		negated := false
+		if not, ok := term.(notNode); ok {
+			term, negated = not.child, true
+		}
+		if n, ok := term.(*fieldNode); ok && isColumnField(n.field) {
+			filter.terms = append(filter.terms, &columnTerm{node: n, negated: negated, outcome: make(map[uint16]bool)})
+		}
+	}
+	if len(filter.terms) == 0 {
+		return nil
+	}
+	return filter
+}
+
+func isColumnField(field string) bool {
+	switch field {
+	case "source", "level", "status":
+		return true
+	}
+	return false
+}
+
+// keep reports whether the entry in slot i of cols may match the query
+func (f *columnFilter) keep(cols *ringColumns, i int) bool {
+	for _, term := range f.terms {
+		var value uint16
+		switch term.node.field {
+		case "source":
+			value = cols.sources[i]
+		case "level":
+			value = cols.levels[i]
+		default:
+			value = cols.statuses[i]
+		}
+		if value == overflowValue {
+			continue
+		}
+		matched, ok := term.outcome[value]
+		if !ok {
+			matched = f.evaluate(term.node, value) != term.negated
+			term.outcome[value] = matched
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// evaluate matches a term against an entry holding only the column value
+func (f *columnFilter) evaluate(n *fieldNode, value uint16) bool {
+	var log SystemLog
+	switch n.field {
+	case "source":
+		log.Source = LogSource(f.values.names[value])
+	case "level":
+		log.Level = LogLevel(f.values.names[value])
+	default:
+		log.StatusCode = int(value)
+	}
+	return n.match(&log)
+}
@@ -0,0 +1,114 @@
+package collector
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Console output formats, see OutputConfig.ConsoleFormat
+const (
+	ConsoleFormatJSON   = "json"
+	ConsoleFormatPretty = "pretty"
+)
+
+// levelColors are the ANSI colors of the levels in FormatPretty
+var levelColors = map[LogLevel]string{
+	LevelDebug: "\033[90m",
+	LevelInfo:  "\033[32m",
+	LevelWarn:  "\033[33m",
+	LevelError: "\033[31m",
+	LevelFatal: "\033[1;31m",
+}
+
+// prettySourceWidth pads the source column; longer sources shift the line
+const prettySourceWidth = 10
+
+// FormatPretty renders an entry as one aligned line for people:
+//
+//	time level source host service: message
+//
+// Access log entries without a message show their request instead. With
+// colored the level is colored with ANSI escapes.
+func FormatPretty(entry *SystemLog, colored bool) string {
+	var b strings.Builder
+	b.WriteString(entry.Timestamp.Local().Format("2006-01-02 15:04:05.000"))
+	b.WriteByte(' ')
+
+	level := strings.ToUpper(string(entry.Level))
+	if level == "" {
+		level = "-"
+	}
+	if color, ok := levelColors[entry.Level]; ok && colored {
+		fmt.Fprintf(&b, "%s%-7s\033[0m", color, level)
+	} else {
+		fmt.Fprintf(&b, "%-7s", level)
+	}
+
+	fmt.Fprintf(&b, " %-*s", prettySourceWidth, entry.Source)
+	if entry.Host != "" {
+		b.WriteByte(' ')
+		b.WriteString(entry.Host)
+	}
+	if entry.Service != "" {
+		b.WriteByte(' ')
+		b.WriteString(entry.Service)
+	}
+	if entry.Host != "" || entry.Service != "" {
+		b.WriteByte(':')
+	}
+	b.WriteByte(' ')
+	// Newlines would break the one line per entry
+	b.WriteString(strings.Join(strings.Fields(Summary(entry)), " "))
+	return b.String()
+}
+
+// Summary is the message of an entry, or the request of access log entries
+// without one
+func Summary(entry *SystemLog) string {
+	if entry.Message != "" || entry.Path == "" {
+		return entry.Message
+	}
+	summary := entry.Method + " " + entry.Path
+	if entry.StatusCode != 0 {
+		summary += fmt.Sprintf(" %d", entry.StatusCode)
+	}
+	return strings.TrimSpace(summary)
+}
+
+// prettyOutput is the console output in ConsoleFormatPretty
+type prettyOutput struct {
+	colored bool
+	writer  *BatchWriter
+}
+
+// newPrettyConsoleOutput creates a console output printing FormatPretty lines
+func newPrettyConsoleOutput(colored bool, size int, interval time.Duration) *prettyOutput {
+	return &prettyOutput{colored: colored, writer: NewBatchWriter(os.Stdout, size, interval)}
+}
+
+// Name returns the output name
+func (o *prettyOutput) Name() string {
+	return "console"
+}
+
+// Write formats entry and writes it as one line
+func (o *prettyOutput) Write(entry *SystemLog) error {
+	buf := acquireBuffer()
+	defer releaseBuffer(buf)
+	buf.WriteString(FormatPretty(entry, o.colored))
+	buf.WriteByte('\n')
+	_, err := o.writer.Write(buf.Bytes())
+	return err
+}
+
+// Flush writes buffered lines to the console
+func (o *prettyOutput) Flush() error {
+	return o.writer.Flush()
+}
+
+// Close flushes the output
+func (o *prettyOutput) Close() error {
+	return o.writer.Close()
+}
@@ -0,0 +1,58 @@
+package collector
+
+import "strings"
+
+// maxCRIMessageSize bounds a reassembled CRI message; longer messages are
+// emitted in pieces of about this size
+const maxCRIMessageSize = 1024 * 1024
+
+// criPartial collects the partial (P) lines of one container stream
+type criPartial struct {
+	prefix  string // timestamp and stream of the first part
+	message strings.Builder
+}
+
+// reassembleCRI joins partial CRI lines ("<time> <stream> P <part>") with
+// the full line that completes them into one "<time> <stream> F <message>"
+// line, stamped with the time of the first part. It reports false while a
+// message is incomplete. Lines are reassembled before they are forwarded,
+// so aggregators receive whole messages.
+func (lc *LogCollector) reassembleCRI(line, name string) (string, bool) {
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) < 3 {
+		return line, true
+	}
+	timestamp, stream, logtag := fields[0], fields[1], fields[2]
+	var content string
+	if len(fields) == 4 {
+		content = fields[3]
+	}
+	partialLine := strings.HasPrefix(logtag, "P") && (len(logtag) == 1 || logtag[1] == ':')
+
+	key := name + "\x00" + stream
+	value, buffered := lc.criPartials.Load(key)
+	if !partialLine && !buffered {
+		return line, true
+	}
+	if !buffered {
+		value, _ = lc.criPartials.LoadOrStore(key, &criPartial{})
+	}
+	// Lines of a source are handled by a single goroutine, so the partial
+	// of a stream is never used concurrently
+	partial := value.(*criPartial)
+
+	if partial.message.Len() == 0 {
+		if !partialLine {
+			return line, true
+		}
+		partial.prefix = timestamp + " " + stream
+	}
+	partial.message.WriteString(content)
+	if partialLine && partial.message.Len() < maxCRIMessageSize {
+		return "", false
+	}
+
+	joined := partial.prefix + " F " + partial.message.String()
+	partial.message = strings.Builder{}
+	return joined, true
+}
@@ -0,0 +1,137 @@
+package collector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// DefaultFingerprintBytes is the default number of bytes at the start of a
+// file that identify it
+const DefaultFingerprintBytes = 1024
+
+// fingerprint identifies a file by a hash of its first bytes, which stay the
+// same while it is appended to but differ between files, whatever their path
+type fingerprint struct {
+	hash string
+	size int // bytes hashed, less than the fingerprint length for short files
+}
+
+// fingerprintBytes returns the fingerprint length of the checkpoint policy
+func (lc *LogCollector) fingerprintBytes() int {
+	if lc.checkpoints != nil && lc.checkpoints.policy.FingerprintBytes > 0 {
+		return lc.checkpoints.policy.FingerprintBytes
+	}
+	return DefaultFingerprintBytes
+}
+
+// readFingerprint hashes the first size bytes of file, or all of it when it
+// is shorter. Empty files have no fingerprint.
+func readFingerprint(file *os.File, size int) (fingerprint, error) {
+	buf := make([]byte, size)
+	n, err := file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return fingerprint{}, err
+	}
+	if n == 0 {
+		return fingerprint{}, nil
+	}
+	sum := sha256.Sum256(buf[:n])
+	return fingerprint{hash: hex.EncodeToString(sum[:16]), size: n}, nil
+}
+
+// matches reports whether file still starts with the bytes fp was taken from
+func (fp fingerprint) matches(file *os.File) (bool, error) {
+	current, err := readFingerprint(file, fp.size)
+	if err != nil {
+		return false, err
+	}
+	return current == fp, nil
+}
+
+// checkFingerprint compares the file being read with the fingerprint of its
+// position and returns the offset to read from: 0 when the file was replaced
+// by one with different content (rotated, truncated and rewritten, or
+// swapped), even if it is already longer than the old position. The
+// fingerprint is taken or extended while the file is shorter than the
+// fingerprint length.
+func (lc *LogCollector) checkFingerprint(config LogSourceConfig, state *sourceState, file *os.File, size, position int64) (int64, error) {
+	fp := state.getFingerprint()
+	if fp.hash != "" && position > 0 {
+		same, err := fp.matches(file)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read fingerprint of %s: %w", config.Path, err)
+		}
+		if !same {
+			position = 0
+		}
+	}
+	if position == 0 {
+		fp = fingerprint{}
+	}
+	if length := lc.fingerprintBytes(); fp.size < length && size > int64(fp.size) {
+		current, err := readFingerprint(file, length)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read fingerprint of %s: %w", config.Path, err)
+		}
+		fp = current
+	}
+	state.setFingerprint(fp)
+	return position, nil
+}
+
+// restorePosition returns the position a new source starts from: its
+// checkpoint when its path still matches, or else the checkpoint of any
+// source that read a file with the same fingerprint, so a file that was
+// renamed, copied or configured under another source is not read twice
+func (lc *LogCollector) restorePosition(config LogSourceConfig) (int64, fingerprint) {
+	if lc.checkpoints == nil {
+		return 0, fingerprint{}
+	}
+	if cp, ok := lc.checkpoints.Get(config.Name); ok && cp.Path == config.Path {
+		return cp.Offset, fingerprint{hash: cp.Fingerprint, size: cp.FingerprintSize}
+	}
+
+	file, err := os.Open(config.Path)
+	if err != nil {
+		return 0, fingerprint{}
+	}
+	defer file.Close()
+	length := lc.fingerprintBytes()
+	fp, err := readFingerprint(file, length)
+	// Short fingerprints are too likely to be shared by different files
+	if err != nil || fp.size < length {
+		return 0, fingerprint{}
+	}
+
+	var match Checkpoint
+	for _, cp := range lc.checkpoints.All() {
+		if cp.Fingerprint == fp.hash && cp.FingerprintSize == fp.size && cp.Offset > match.Offset {
+			match = cp
+		}
+	}
+	if match.Offset == 0 {
+		return 0, fp
+	}
+	offset := match.Offset
+	if info, err := file.Stat(); err == nil && info.Size() < offset {
+		offset = info.Size()
+	}
+	lc.auditLogger.LogEvent(audit.AuditEvent{
+		EventType: "checkpoint_fingerprint_match",
+		Message:   fmt.Sprintf("Log source %s resumes at offset %d, the file was already read as %s", config.Name, offset, match.Path),
+		Details: map[string]interface{}{
+			"source":      config.Name,
+			"path":        config.Path,
+			"offset":      offset,
+			"read_as":     match.Source,
+			"read_path":   match.Path,
+			"fingerprint": fp.hash,
+		},
+	})
+	return offset, fp
+}
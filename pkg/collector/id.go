@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// lastLogSeq is the last sequence number handed out by newLogID. Sequences
+// start from the current time in microseconds and only move forward, so IDs
+// stay unique and sortable even when many lines share the same timestamp.
+var lastLogSeq atomic.Int64
+
+// nodeID identifies this instance in generated log IDs
+var nodeID atomic.Value
+
+func init() {
+	nodeID.Store(defaultNodeID())
+}
+
+// defaultNodeID derives a short stable node ID from the hostname
+func defaultNodeID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	h := fnv.New32a()
+	h.Write([]byte(hostname))
+	return fmt.Sprintf("%06x", h.Sum32()&0xffffff)
+}
+
+// SetNodeID overrides the node ID embedded in generated log IDs, so IDs
+// from several gonder instances never collide
+func SetNodeID(id string) {
+	if id != "" {
+		nodeID.Store(id)
+	}
+}
+
+// newLogID returns a unique, monotonically increasing log ID of the form
+// log_<sequence>_<node>
+func newLogID(now time.Time) string {
+	seq := now.UnixMicro()
+	for {
+		last := lastLogSeq.Load()
+		next := seq
+		if next <= last {
+			next = last + 1
+		}
+		if lastLogSeq.CompareAndSwap(last, next) {
+			seq = next
+			break
+		}
+	}
+
+	var buf [48]byte
+	b := append(buf[:0], "log_"...)
+	b = strconv.AppendInt(b, seq, 10)
+	b = append(b, '_')
+	b = append(b, nodeID.Load().(string)...)
+	return string(b)
+}
+
+// Fingerprint returns a deterministic key for the line read from source at
+// offset: the first 128 bits of a SHA-256 over all three, hex encoded. Unlike
+// the ID, a line read again after a crash, or resent by an agent, gets the
+// same fingerprint, so sinks can use it as a document ID or idempotency key
+// and store replayed lines only once.
+func Fingerprint(source string, offset int64, line string) string {
+	h := sha256.New()
+	var buf [24]byte
+	h.Write([]byte(source))
+	h.Write(strconv.AppendInt(append(buf[:0], 0), offset, 10))
+	h.Write([]byte{0})
+	h.Write([]byte(line))
+	var sum [sha256.Size]byte
+	return hex.EncodeToString(h.Sum(sum[:0])[:16])
+}
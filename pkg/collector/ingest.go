@@ -0,0 +1,164 @@
+package collector
+
+import "time"
+
+// RawLine is an unparsed line read from a source, as handed to a LineForwarder
+type RawLine struct {
+	Source string    `json:"source"`
+	Type   LogSource `json:"type"`
+	Path   string    `json:"path"`
+	Tags   []string  `json:"tags,omitempty"`
+	// Timezone is the source timezone, for timestamps without a zone offset
+	Timezone string `json:"timezone,omitempty"`
+	// Offset is where the line starts in the file, -1 for sources without
+	// stable positions
+	Offset int64     `json:"offset"`
+	Line   string    `json:"line"`
+	ReadAt time.Time `json:"read_at"`
+}
+
+// LineForwarder receives raw lines instead of the local parse and output
+// pipeline. It is used by agents that only collect and ship lines to an
+// aggregator. Forward must not block for long since it is called from the
+// source goroutines.
+type LineForwarder interface {
+	Forward(line RawLine) error
+}
+
+// LineSyncer is implemented by forwarders that can confirm delivery. Sync
+// returns once every line handed to Forward so far was delivered or
+// durably stored, and fails if any of them was lost. Without it lines
+// count as delivered when Forward returns.
+type LineSyncer interface {
+	Sync() error
+}
+
+// SetForwarder makes the collector hand every raw line to forwarder instead
+// of parsing it locally. It must be called while the collector is stopped.
+func (lc *LogCollector) SetForwarder(forwarder LineForwarder) {
+	lc.lifecycleMu.Lock()
+	defer lc.lifecycleMu.Unlock()
+	lc.forwarder = forwarder
+}
+
+// IngestLine parses a line received from outside the collector (e.g. from an
+// agent) as if it was read from config and sends it through the outputs.
+// Lines over a quota of config are dropped and reported as skipped.
+func (lc *LogCollector) IngestLine(line string, config LogSourceConfig) ParseStatus {
+	if verdict, _ := lc.admitLine(config, len(line), false); verdict != quotaAdmit {
+		return ParseSkipped
+	}
+	systemLog, status := lc.parseLogLine(line, config)
+	if systemLog != nil {
+		if lc.processSystemLog(systemLog) {
+			lc.recent.add(config.Name, -1, systemLog)
+			lc.usage.add(usageStored, config, len(line))
+		}
+		releaseSystemLog(systemLog)
+	}
+	return status
+}
+
+// IngestLineAt is IngestLine for a line read at offset of config.Name,
+// which also sets the entry fingerprint. A negative offset means the line
+// has no stable position and gets no fingerprint.
+func (lc *LogCollector) IngestLineAt(line string, offset int64, config LogSourceConfig) ParseStatus {
+	if verdict, _ := lc.admitLine(config, len(line), false); verdict != quotaAdmit {
+		return ParseSkipped
+	}
+	return lc.ingestLineAt(line, offset, config)
+}
+
+// ingestLineAt is IngestLineAt for lines already charged to the quotas
+func (lc *LogCollector) ingestLineAt(line string, offset int64, config LogSourceConfig) ParseStatus {
+	systemLog, status := lc.parseLogLine(line, config)
+	if systemLog != nil {
+		if offset >= 0 {
+			systemLog.Fingerprint = Fingerprint(config.Name, offset, line)
+		}
+		if lc.processSystemLog(systemLog) {
+			lc.recent.add(config.Name, offset, systemLog)
+			lc.usage.add(usageStored, config, len(line))
+		}
+		releaseSystemLog(systemLog)
+	}
+	return status
+}
+
+// IngestLog sends an already structured entry (e.g. received over OTLP)
+// through the outputs. ID, Timestamp and CollectedAt are filled in when
+// empty.
+func (lc *LogCollector) IngestLog(log SystemLog) {
+	now := time.Now()
+	if log.ID == "" {
+		log.ID = newLogID(now)
+	}
+	if log.CollectedAt.IsZero() {
+		log.CollectedAt = now
+	}
+	if log.Timestamp.IsZero() {
+		log.Timestamp = now.UTC()
+	}
+	if log.Level == "" {
+		log.Level = DetectLogLevel(log.Message)
+	}
+	lc.normalize(&log, nil)
+	size := len(log.RawLog)
+	if size == 0 {
+		size = len(log.Message)
+	}
+	// Structured entries are accounted by source type
+	quotaConfig := LogSourceConfig{Name: string(log.Source), Tags: log.Tags}
+	if verdict, _ := lc.admitLine(quotaConfig, size, false); verdict != quotaAdmit {
+		return
+	}
+	if lc.processSystemLog(&log) {
+		lc.recent.add(string(log.Source), -1, &log)
+		lc.usage.add(usageStored, quotaConfig, size)
+	}
+}
+
+// handleLine sends a line read from a source to the forwarder, or parses and
+// processes it locally. Lines at an offset can be deferred by a pausing
+// quota: the line is then left unhandled and the time the source may be
+// read again is returned.
+func (lc *LogCollector) handleLine(line string, offset int64, config LogSourceConfig) time.Time {
+	verdict, pausedUntil := lc.admitLine(config, len(line), offset >= 0)
+	switch verdict {
+	case quotaDefer:
+		return pausedUntil
+	case quotaReject:
+		return time.Time{}
+	}
+	if config.Source == SourceCRI {
+		var complete bool
+		if line, complete = lc.reassembleCRI(line, config.Name); !complete {
+			return time.Time{}
+		}
+	}
+	if lc.forwarder != nil {
+		err := lc.forwarder.Forward(RawLine{
+			Source:   config.Name,
+			Type:     config.Source,
+			Path:     config.Path,
+			Tags:     config.Tags,
+			Timezone: config.Timezone,
+			Offset:   offset,
+			Line:     line,
+			ReadAt:   time.Now(),
+		})
+		if err != nil {
+			lc.deliveryFailures.Add(1)
+			lc.auditLogger.LogError(err, "Failed to forward log line", map[string]interface{}{
+				"source": config.Name,
+				"offset": offset,
+			})
+		} else {
+			lc.usage.add(usageForwarded, config, len(line))
+		}
+		return time.Time{}
+	}
+
+	lc.ingestLineAt(line, offset, config)
+	return time.Time{}
+}
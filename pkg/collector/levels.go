@@ -0,0 +1,94 @@
+package collector
+
+import (
+	"strconv"
+	"time"
+)
+
+// StatusLevelRules classify parsed web access entries (nginx, apache) by
+// their HTTP status code and latency rather than by message keywords
+type StatusLevelRules struct {
+	ServerError LogLevel // 5xx
+	ClientError LogLevel // 4xx
+	Success     LogLevel // 1xx, 2xx and 3xx
+
+	// SlowThreshold raises requests that took longer to at least SlowLevel;
+	// zero disables it. The latency is read from the request_time field
+	// (seconds, as logged by nginx's $request_time).
+	SlowThreshold time.Duration
+	SlowLevel     LogLevel
+}
+
+// DefaultStatusLevelRules maps 5xx to error, 4xx to warn and everything
+// else to info, without a latency threshold
+func DefaultStatusLevelRules() StatusLevelRules {
+	return StatusLevelRules{
+		ServerError: LevelError,
+		ClientError: LevelWarn,
+		Success:     LevelInfo,
+		SlowLevel:   LevelWarn,
+	}
+}
+
+// levelSeverity orders levels for SlowLevel, which only ever raises a level
+var levelSeverity = map[LogLevel]int{
+	LevelDebug: 1,
+	LevelInfo:  2,
+	LevelWarn:  3,
+	LevelError: 4,
+	LevelFatal: 5,
+}
+
+// AtLeast reports whether l is as severe as min or more; unknown levels
+// rank below debug
+func (l LogLevel) AtLeast(min LogLevel) bool {
+	return levelSeverity[l] >= levelSeverity[min]
+}
+
+// Level returns the level of a request with the given status and latency;
+// a zero latency means unknown
+func (r StatusLevelRules) Level(status int, latency time.Duration) LogLevel {
+	level := r.Success
+	switch {
+	case status >= 500:
+		level = r.ServerError
+	case status >= 400:
+		level = r.ClientError
+	}
+	if r.SlowThreshold > 0 && latency > r.SlowThreshold && levelSeverity[r.SlowLevel] > levelSeverity[level] {
+		level = r.SlowLevel
+	}
+	return level
+}
+
+// SetStatusLevelRules replaces the rules used for entries with a status
+// code. Safe to call while running.
+func (lc *LogCollector) SetStatusLevelRules(rules StatusLevelRules) {
+	lc.statusLevels.Store(&rules)
+}
+
+// statusLevel classifies an entry parsed with a status code
+func (lc *LogCollector) statusLevel(log *SystemLog) LogLevel {
+	return lc.statusLevels.Load().Level(log.StatusCode, requestLatency(log.ParsedData))
+}
+
+// requestLatency reads the request_time field in seconds
+func requestLatency(parsed map[string]interface{}) time.Duration {
+	var seconds float64
+	switch value := parsed["request_time"].(type) {
+	case string:
+		parsedSeconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0
+		}
+		seconds = parsedSeconds
+	case float64:
+		seconds = value
+	default:
+		return 0
+	}
+	if seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
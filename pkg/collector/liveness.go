@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// stallCheckInterval is how often sources with a StallAfter are checked
+const stallCheckInterval = 5 * time.Second
+
+// Liveness transitions reported by checkStall
+const (
+	stallUnchanged = iota
+	stallStarted
+	stallEnded
+)
+
+// watchStalls reports sources that stay silent longer than their
+// StallAfter until ctx is cancelled. Custom sources are passed in since
+// they can't change while the collector runs.
+func (lc *LogCollector) watchStalls(ctx context.Context, custom []LogSourceConfig) {
+	ticker := time.NewTicker(stallCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, config := range append(lc.registry.list(), custom...) {
+				if config.Enabled && config.StallAfter > 0 {
+					lc.checkStall(config, now)
+				}
+			}
+		}
+	}
+}
+
+// checkStall compares the last activity of a source with its StallAfter and
+// logs source_stalled when it goes silent and source_resumed when lines
+// arrive again
+func (lc *LogCollector) checkStall(config LogSourceConfig, now time.Time) {
+	lc.statesMu.RLock()
+	state, exists := lc.states[config.Name]
+	lc.statesMu.RUnlock()
+	if !exists {
+		return
+	}
+
+	threshold := time.Duration(config.StallAfter) * time.Second
+	transition, silence := state.checkStall(now, threshold)
+	switch transition {
+	case stallStarted:
+		lc.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: "source_stalled",
+			Message:   fmt.Sprintf("Log source %s has been silent for %s", config.Name, silence.Round(time.Second)),
+			Details: map[string]interface{}{
+				"source":      config.Name,
+				"path":        config.Path,
+				"stall_after": threshold.String(),
+				"silence":     silence.Round(time.Second).String(),
+			},
+		})
+	case stallEnded:
+		lc.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: "source_resumed",
+			Message:   fmt.Sprintf("Log source %s is active again after %s", config.Name, silence.Round(time.Second)),
+			Details: map[string]interface{}{
+				"source":  config.Name,
+				"path":    config.Path,
+				"silence": silence.Round(time.Second).String(),
+			},
+		})
+	}
+}
+
+// checkStall updates the stalled flag. Silence is counted from the last
+// activity, or from the start of the source when it never had any; sources
+// held by a pausing quota are not stalled. The returned duration is the
+// silence so far when the source stalls and its total when it resumes.
+func (s *sourceState) checkStall(now time.Time, threshold time.Duration) (int, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.status.Stalled {
+		if s.status.LastActivity != nil && s.status.LastActivity.After(*s.status.StalledSince) {
+			silence := s.status.LastActivity.Sub(*s.status.StalledSince)
+			s.status.Stalled = false
+			s.status.StalledSince = nil
+			return stallEnded, silence
+		}
+		return stallUnchanged, 0
+	}
+
+	since := s.status.LastActivity
+	if since == nil {
+		since = s.status.StartedAt
+	}
+	if since == nil || !s.status.Running || s.status.PausedUntil != nil {
+		return stallUnchanged, 0
+	}
+	silence := now.Sub(*since)
+	if silence <= threshold {
+		return stallUnchanged, 0
+	}
+	last := *since
+	s.status.Stalled = true
+	s.status.StalledSince = &last
+	return stallStarted, silence
+}
@@ -0,0 +1,441 @@
+package collector
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrEntryNotFound is returned by Context for IDs that are unknown or
+	// no longer kept
+	ErrEntryNotFound = errors.New("log entry not found")
+	// ErrContextDisabled is returned by Context without EnableContext
+	ErrContextDisabled = errors.New("context retrieval is disabled")
+)
+
+// ContextLine is an entry returned by Context or Search, with its offset in
+// the source file when it has one
+type ContextLine struct {
+	SystemLog
+	Offset *int64 `json:"offset,omitempty"`
+	// SourceName is the source the entry was read from; only set by Search
+	SourceName string `json:"source_name,omitempty"`
+}
+
+// LogContext is an entry with the entries read before and after it from
+// the same source, in read order
+type LogContext struct {
+	Source string        `json:"source"`
+	Before []ContextLine `json:"before"`
+	Entry  ContextLine   `json:"entry"`
+	After  []ContextLine `json:"after"`
+}
+
+// recentEntries keeps the last entries of every source for Context
+type recentEntries struct {
+	size int
+
+	mu      sync.Mutex
+	sources map[string]*recentRing
+	// index locates the kept entries by ID
+	index map[string]recentRef
+	// values interns the names in the columns of the rings
+	values *columnValues
+}
+
+// recentRing holds the last entries of one source; entry n is at n%size
+type recentRing struct {
+	entries []recentEntry
+	// total counts the entries ever added
+	total uint64
+	// disorder is the last entry with an older timestamp than the one
+	// before it; the kept entries are in timestamp order once it is gone
+	disorder    uint64
+	hasDisorder bool
+	// cols hold the hot fields of the entries, slot for slot
+	cols ringColumns
+	// blooms summarize blocks of bloomBlockEntries entries; block n is at
+	// n%len(blooms), with room for the partly overwritten oldest block
+	blooms []*blockBloom
+}
+
+// bloom returns the filter of the block holding entry seq
+func (ring *recentRing) bloom(seq uint64) *blockBloom {
+	return ring.blooms[(seq/bloomBlockEntries)%uint64(len(ring.blooms))]
+}
+
+type recentEntry struct {
+	log    SystemLog
+	offset int64
+}
+
+type recentRef struct {
+	source string
+	seq    uint64
+}
+
+// EnableContext keeps the last perSource entries of every source in memory
+// so Context can return the lines around an entry. Sources are the source
+// names of files and agent lines, and the source type of structured
+// entries (e.g. OTLP). It must be called while the collector is stopped.
+func (lc *LogCollector) EnableContext(perSource int) {
+	lc.lifecycleMu.Lock()
+	defer lc.lifecycleMu.Unlock()
+	if perSource <= 0 {
+		lc.recent = nil
+		return
+	}
+	lc.recent = &recentEntries{
+		size:    perSource,
+		sources: make(map[string]*recentRing),
+		index:   make(map[string]recentRef),
+		values:  newColumnValues(),
+	}
+}
+
+// Context returns up to before and after entries around the entry with the
+// given ID, from the same source. Only entries still kept by EnableContext
+// are found, so the lists may be shorter near the oldest kept entry or the
+// newest one.
+func (lc *LogCollector) Context(id string, before, after int) (*LogContext, error) {
+	r := lc.recent
+	if r == nil {
+		return nil, ErrContextDisabled
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ref, ok := r.index[id]
+	if !ok {
+		return nil, ErrEntryNotFound
+	}
+	ring := r.sources[ref.source]
+	oldest := uint64(0)
+	if ring.total > uint64(r.size) {
+		oldest = ring.total - uint64(r.size)
+	}
+
+	result := &LogContext{
+		Source: ref.source,
+		Before: []ContextLine{},
+		Entry:  ring.line(ref.seq),
+		After:  []ContextLine{},
+	}
+	first := oldest
+	if ref.seq-oldest > uint64(before) {
+		first = ref.seq - uint64(before)
+	}
+	for seq := first; seq < ref.seq; seq++ {
+		result.Before = append(result.Before, ring.line(seq))
+	}
+	for seq := ref.seq + 1; seq < ring.total && seq <= ref.seq+uint64(after); seq++ {
+		result.After = append(result.After, ring.line(seq))
+	}
+	return result, nil
+}
+
+// line returns a copy of the entry with sequence number seq
+func (ring *recentRing) line(seq uint64) ContextLine {
+	e := &ring.entries[seq%uint64(len(ring.entries))]
+	line := ContextLine{SystemLog: copySystemLog(&e.log)}
+	if e.offset >= 0 {
+		offset := e.offset
+		line.Offset = &offset
+	}
+	return line
+}
+
+// add keeps a copy of log read from source at offset (-1 without one)
+func (r *recentEntries) add(source string, offset int64, log *SystemLog) {
+	if r == nil {
+		return
+	}
+	entry := recentEntry{log: copySystemLog(log), offset: offset}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ring, ok := r.sources[source]
+	if !ok {
+		ring = &recentRing{entries: make([]recentEntry, r.size), cols: newRingColumns(r.size)}
+		ring.blooms = make([]*blockBloom, r.size/bloomBlockEntries+2)
+		for i := range ring.blooms {
+			ring.blooms[i] = &blockBloom{}
+		}
+		r.sources[source] = ring
+	}
+	if ring.total > 0 && log.Timestamp.Before(ring.entries[(ring.total-1)%uint64(r.size)].log.Timestamp) {
+		ring.disorder, ring.hasDisorder = ring.total, true
+	}
+	slot := &ring.entries[ring.total%uint64(r.size)]
+	if ring.total >= uint64(r.size) {
+		// Only if the ID wasn't reused by a later entry
+		evicted := recentRef{source: source, seq: ring.total - uint64(r.size)}
+		if r.index[slot.log.ID] == evicted {
+			delete(r.index, slot.log.ID)
+		}
+	}
+	*slot = entry
+	ring.cols.set(int(ring.total%uint64(r.size)), log, r.values)
+	bloom := ring.bloom(ring.total)
+	if ring.total%bloomBlockEntries == 0 {
+		*bloom = blockBloom{}
+	}
+	bloom.addEntry(log)
+	r.index[log.ID] = recentRef{source: source, seq: ring.total}
+	ring.total++
+}
+
+// SearchOptions selects entries for Search
+type SearchOptions struct {
+	Query *Query
+	// Sources limits the search to these source names; empty searches all
+	Sources []string
+	// Since skips entries with older timestamps; zero searches all
+	Since time.Time
+	// Limit is the most entries returned, the newest ones
+	Limit int
+	// Before continues a search at the NextCursor of its previous page,
+	// returning only entries older than the cursor
+	Before *SearchCursor
+}
+
+// SearchCursor is the position of an entry in search order: by timestamp,
+// then by ID. Paging backwards from a cursor is stable while new entries
+// arrive, since they all sort after it.
+type SearchCursor struct {
+	Timestamp time.Time
+	ID        string
+}
+
+// String encodes the cursor as an opaque token
+func (c SearchCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.Timestamp.UnixNano(), 10) + ":" + c.ID))
+}
+
+// ParseSearchCursor decodes a token returned as NextCursor
+func ParseSearchCursor(token string) (*SearchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	nanos, id, ok := strings.Cut(string(data), ":")
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if !ok || err != nil || id == "" {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &SearchCursor{Timestamp: time.Unix(0, n).UTC(), ID: id}, nil
+}
+
+// before reports whether an entry sorts before the cursor
+func (c *SearchCursor) before(log *SystemLog) bool {
+	return searchLess(log.Timestamp, log.ID, c.Timestamp, c.ID)
+}
+
+// searchLess orders entries by timestamp, then ID
+func searchLess(t1 time.Time, id1 string, t2 time.Time, id2 string) bool {
+	if !t1.Equal(t2) {
+		return t1.Before(t2)
+	}
+	return id1 < id2
+}
+
+// SearchResult is the answer to Search
+type SearchResult struct {
+	Entries []ContextLine `json:"entries"`
+	// Truncated is set when more entries matched than Limit
+	Truncated bool `json:"truncated"`
+	// Scanned counts the entries searched
+	Scanned int `json:"scanned"`
+	// Skipped counts the entries passed over because the bloom filter of
+	// their block rules out the query
+	Skipped int `json:"skipped"`
+	// NextCursor is set when older entries matched; pass it as Before to
+	// get them
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Search returns the entries kept by EnableContext that match, oldest
+// first. It only sees the last entries of each source, so it answers
+// questions about recent activity, not the full history. Sources are
+// searched in parallel and their matches merged.
+func (lc *LogCollector) Search(opts SearchOptions) (*SearchResult, error) {
+	r := lc.recent
+	if r == nil {
+		return nil, ErrContextDisabled
+	}
+	var names map[string]bool
+	if len(opts.Sources) > 0 {
+		names = make(map[string]bool, len(opts.Sources))
+		for _, name := range opts.Sources {
+			names[name] = true
+		}
+	}
+
+	r.mu.Lock()
+	var scans []*ringScan
+	for name, ring := range r.sources {
+		if names == nil || names[name] {
+			scans = append(scans, &ringScan{name: name, ring: ring, size: r.size, filter: newColumnFilter(opts.Query, r.values)})
+		}
+	}
+	// The workers only read the rings, which stay locked until they are done
+	workers := min(len(scans), runtime.GOMAXPROCS(0))
+	next := make(chan *ringScan, len(scans))
+	for _, scan := range scans {
+		next <- scan
+	}
+	close(next)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for scan := range next {
+				scan.run(&opts)
+			}
+		}()
+	}
+	wg.Wait()
+	r.mu.Unlock()
+
+	result := &SearchResult{Entries: []ContextLine{}}
+	for _, scan := range scans {
+		result.Scanned += scan.scanned
+		result.Skipped += scan.skipped
+		result.Truncated = result.Truncated || scan.truncated
+		for _, line := range scan.matches {
+			if opts.Limit > 0 && len(result.Entries) >= opts.Limit {
+				result.Truncated = true
+			}
+			if !keepNewest(&result.Entries, opts.Limit, line.Timestamp, line.ID) {
+				continue
+			}
+			heap.Push((*oldestFirst)(&result.Entries), line)
+		}
+	}
+
+	sort.Slice(result.Entries, func(i, j int) bool {
+		a, b := &result.Entries[i], &result.Entries[j]
+		return searchLess(a.Timestamp, a.ID, b.Timestamp, b.ID)
+	})
+	if result.Truncated {
+		oldest := result.Entries[0]
+		result.NextCursor = SearchCursor{Timestamp: oldest.Timestamp, ID: oldest.ID}.String()
+	}
+	return result, nil
+}
+
+// keepNewest makes room in a heap of at most limit lines for an entry and
+// reports whether it belongs among the newest limit (limit 0 keeps all)
+func keepNewest(lines *[]ContextLine, limit int, ts time.Time, id string) bool {
+	if limit <= 0 || len(*lines) < limit {
+		return true
+	}
+	if oldest := &(*lines)[0]; !searchLess(oldest.Timestamp, oldest.ID, ts, id) {
+		return false
+	}
+	heap.Pop((*oldestFirst)(lines))
+	return true
+}
+
+// ringScan searches the entries of one source, newest first
+type ringScan struct {
+	name   string
+	ring   *recentRing
+	size   int
+	filter *columnFilter // nil when no term filters on a column
+
+	matches   []ContextLine // heap of the newest Limit matches
+	scanned   int
+	skipped   int
+	truncated bool
+}
+
+// run collects the newest Limit matches of the ring. When the kept entries
+// are in timestamp order, the scan starts at the cursor and stops at Since
+// or once no older entry can be among the matches. Blocks whose bloom
+// filter rules out the query are skipped whole, and entries whose columns
+// rule it out are not read.
+func (s *ringScan) run(opts *SearchOptions) {
+	ring := s.ring
+	oldest := uint64(0)
+	if ring.total > uint64(s.size) {
+		oldest = ring.total - uint64(s.size)
+	}
+	ordered := !ring.hasDisorder || ring.disorder <= oldest
+	start := ring.total
+	if ordered && opts.Before != nil {
+		// The first entry after the cursor's timestamp
+		n := sort.Search(int(ring.total-oldest), func(i int) bool {
+			return ring.entries[(oldest+uint64(i))%uint64(s.size)].log.Timestamp.After(opts.Before.Timestamp)
+		})
+		start = oldest + uint64(n)
+	}
+	useBloom := opts.Query.usesBloom()
+	since := columnTime(opts.Since)
+
+	for seq := start; seq > oldest; seq-- {
+		if useBloom && (seq == start || seq%bloomBlockEntries == 0) && !mayMatch(opts.Query.root, ring.bloom(seq-1)) {
+			// Skip to the last entry of the previous block
+			first := max((seq-1)/bloomBlockEntries*bloomBlockEntries, oldest)
+			s.skipped += int(seq - first)
+			seq = first + 1
+			continue
+		}
+		// The columns rule out most entries before the entry is read
+		i := int((seq - 1) % uint64(s.size))
+		ts := ring.cols.timestamps[i]
+		if ordered {
+			if ts < since {
+				break
+			}
+			// Every older entry is older than the oldest match kept
+			if s.truncated && ts < columnTime(s.matches[0].Timestamp) {
+				break
+			}
+		}
+		s.scanned++
+		if ts < since || (s.filter != nil && !s.filter.keep(&ring.cols, i)) {
+			continue
+		}
+		e := &ring.entries[i]
+		if e.log.Timestamp.Before(opts.Since) || (opts.Before != nil && !opts.Before.before(&e.log)) || !opts.Query.Match(&e.log) {
+			continue
+		}
+		// Only the newest Limit matches are kept, so memory follows the
+		// limit rather than the number of matches
+		if opts.Limit > 0 && len(s.matches) >= opts.Limit {
+			s.truncated = true
+		}
+		if !keepNewest(&s.matches, opts.Limit, e.log.Timestamp, e.log.ID) {
+			continue
+		}
+		line := ring.line(seq - 1)
+		line.SourceName = s.name
+		heap.Push((*oldestFirst)(&s.matches), line)
+	}
+}
+
+// oldestFirst is a heap of lines with the oldest on top
+type oldestFirst []ContextLine
+
+func (h oldestFirst) Len() int { return len(h) }
+func (h oldestFirst) Less(i, j int) bool {
+	return searchLess(h[i].Timestamp, h[i].ID, h[j].Timestamp, h[j].ID)
+}
+func (h oldestFirst) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *oldestFirst) Push(x any)   { *h = append(*h, x.(ContextLine)) }
+func (h *oldestFirst) Pop() any {
+	old := *h
+	line := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return line
+}
@@ -0,0 +1,225 @@
+package collector
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// ErrMaintenance is returned when the collector is asked to start while in
+// maintenance mode
+var ErrMaintenance = errors.New("log collector is in maintenance mode")
+
+// Maintenance phases, see MaintenanceStatus
+const (
+	// MaintenanceStopping waits for the sources to finish their reads and
+	// write their checkpoints
+	MaintenanceStopping = "stopping"
+	// MaintenanceDraining flushes the outputs until nothing is buffered
+	MaintenanceDraining = "draining"
+	// MaintenanceDrained means everything collected reached the outputs;
+	// storage can be worked on
+	MaintenanceDrained = "drained"
+)
+
+// maintenanceRetry is how often a failed output flush is retried while
+// draining
+const maintenanceRetry = time.Second
+
+// OutputDrain is the drain progress of one output
+type OutputDrain struct {
+	Name          string `json:"name"`
+	BufferedBytes int    `json:"buffered_bytes"`
+	Error         string `json:"error,omitempty"`
+}
+
+// MaintenanceStatus reports maintenance mode and the progress of the drain
+type MaintenanceStatus struct {
+	Active    bool          `json:"active"`
+	Reason    string        `json:"reason,omitempty"`
+	Phase     string        `json:"phase,omitempty"`
+	Since     *time.Time    `json:"since,omitempty"`
+	DrainedAt *time.Time    `json:"drained_at,omitempty"`
+	Attempts  int           `json:"attempts,omitempty"`
+	Outputs   []OutputDrain `json:"outputs,omitempty"`
+	// ResumeCollector tells whether leaving maintenance restarts the
+	// collector, i.e. whether it was running when maintenance began
+	ResumeCollector bool `json:"resume_collector"`
+}
+
+// maintenance is the maintenance state of a collector
+type maintenance struct {
+	mu     sync.Mutex
+	status MaintenanceStatus
+	// done is closed when the drain goroutine returns
+	done chan struct{}
+	// stop ends the drain when maintenance is left early
+	stop chan struct{}
+}
+
+// InMaintenance reports whether network inputs should refuse entries
+func (lc *LogCollector) InMaintenance() bool {
+	lc.maintenance.mu.Lock()
+	defer lc.maintenance.mu.Unlock()
+	return lc.maintenance.status.Active
+}
+
+// Maintenance returns the maintenance state and drain progress
+func (lc *LogCollector) Maintenance() MaintenanceStatus {
+	lc.maintenance.mu.Lock()
+	defer lc.maintenance.mu.Unlock()
+	status := lc.maintenance.status
+	status.Outputs = append([]OutputDrain(nil), status.Outputs...)
+	return status
+}
+
+// EnterMaintenance pauses ingestion for storage work or upgrades: the
+// sources stop after writing their checkpoints, so they continue where they
+// left off, InMaintenance tells network inputs to refuse entries, and the
+// outputs are flushed in the background until nothing is buffered. Follow
+// the progress with Maintenance until the phase is MaintenanceDrained.
+// Entering maintenance again only updates the reason.
+func (lc *LogCollector) EnterMaintenance(reason string) MaintenanceStatus {
+	now := time.Now()
+	m := &lc.maintenance
+	m.mu.Lock()
+	if m.status.Active {
+		m.status.Reason = reason
+		m.mu.Unlock()
+		return lc.Maintenance()
+	}
+	m.status = MaintenanceStatus{
+		Active:          true,
+		Reason:          reason,
+		Phase:           MaintenanceStopping,
+		Since:           &now,
+		ResumeCollector: lc.IsRunning(),
+	}
+	m.done = make(chan struct{})
+	m.stop = make(chan struct{})
+	done, stop := m.done, m.stop
+	m.mu.Unlock()
+
+	lc.auditLogger.LogEvent(audit.AuditEvent{
+		EventType: "maintenance_started",
+		Message:   "Maintenance mode entered, ingestion paused",
+		Details:   map[string]interface{}{"reason": reason},
+	})
+	go func() {
+		defer close(done)
+		lc.drain(stop)
+	}()
+	return lc.Maintenance()
+}
+
+// drain stops the sources and flushes the outputs until they are empty or
+// stop is closed
+func (lc *LogCollector) drain(stop chan struct{}) {
+	lc.Stop()
+	lc.setMaintenancePhase(MaintenanceDraining)
+
+	for {
+		outputs, failed := lc.flushForDrain()
+		lc.maintenance.mu.Lock()
+		lc.maintenance.status.Attempts++
+		lc.maintenance.status.Outputs = outputs
+		lc.maintenance.mu.Unlock()
+		if !failed {
+			break
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(maintenanceRetry):
+		}
+	}
+
+	lc.maintenance.mu.Lock()
+	lc.maintenance.status.Phase = MaintenanceDrained
+	drainedAt := time.Now()
+	lc.maintenance.status.DrainedAt = &drainedAt
+	since := *lc.maintenance.status.Since
+	lc.maintenance.mu.Unlock()
+	lc.auditLogger.LogEvent(audit.AuditEvent{
+		EventType: "maintenance_drained",
+		Message:   "Outputs drained, ready for maintenance",
+		Duration:  time.Since(since).String(),
+	})
+}
+
+// flushForDrain flushes every output and reports what each still buffers
+func (lc *LogCollector) flushForDrain() (outputs []OutputDrain, failed bool) {
+	lc.lifecycleMu.Lock()
+	defer lc.lifecycleMu.Unlock()
+	for _, output := range lc.outputs {
+		drain := OutputDrain{Name: output.Name()}
+		if err := output.Flush(); err != nil {
+			drain.Error = err.Error()
+			failed = true
+		}
+		switch o := output.(type) {
+		case *logOutput:
+			drain.BufferedBytes = o.writer.Buffered()
+		case *prettyOutput:
+			drain.BufferedBytes = o.writer.Buffered()
+		}
+		if drain.BufferedBytes > 0 {
+			failed = true
+		}
+		outputs = append(outputs, drain)
+	}
+	if syncer, ok := lc.forwarder.(LineSyncer); ok {
+		drain := OutputDrain{Name: "forwarder"}
+		if err := syncer.Sync(); err != nil {
+			drain.Error = err.Error()
+			failed = true
+		}
+		outputs = append(outputs, drain)
+	}
+	return outputs, failed
+}
+
+func (lc *LogCollector) setMaintenancePhase(phase string) {
+	lc.maintenance.mu.Lock()
+	lc.maintenance.status.Phase = phase
+	lc.maintenance.mu.Unlock()
+}
+
+// ExitMaintenance ends maintenance mode: network inputs accept entries
+// again and the collector is restarted if it was running before. Leaving
+// before the drain finished abandons it.
+func (lc *LogCollector) ExitMaintenance() (MaintenanceStatus, error) {
+	m := &lc.maintenance
+	m.mu.Lock()
+	// stop is nil while another call is leaving maintenance
+	if !m.status.Active || m.stop == nil {
+		m.mu.Unlock()
+		return lc.Maintenance(), nil
+	}
+	close(m.stop)
+	m.stop = nil
+	done := m.done
+	m.mu.Unlock()
+	// The sources must have stopped before they can be started again
+	<-done
+
+	m.mu.Lock()
+	resume := m.status.ResumeCollector
+	since := *m.status.Since
+	m.status = MaintenanceStatus{}
+	m.mu.Unlock()
+
+	lc.auditLogger.LogEvent(audit.AuditEvent{
+		EventType: "maintenance_ended",
+		Message:   "Maintenance mode left, ingestion resumed",
+		Duration:  time.Since(since).String(),
+	})
+	if resume {
+		if err := lc.Start(); err != nil {
+			return lc.Maintenance(), err
+		}
+	}
+	return lc.Maintenance(), nil
+}
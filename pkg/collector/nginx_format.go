@@ -0,0 +1,125 @@
+package collector
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// nginxVariableFields maps nginx log_format variables to parser field names
+// so that custom formats fill the same fields as the built-in parser
+var nginxVariableFields = map[string]string{
+	"remote_addr":     "ip",
+	"remote_user":     "user",
+	"time_local":      "timestamp",
+	"time_iso8601":    "timestamp",
+	"request_method":  "method",
+	"request_uri":     "path",
+	"uri":             "path",
+	"status":          "status",
+	"body_bytes_sent": "size",
+	"http_referer":    "referer",
+	"http_user_agent": "user_agent",
+	"host":            "host",
+}
+
+// nginxVariable matches $name and ${name}
+var nginxVariable = regexp.MustCompile(`\$(?:\{(\w+)\}|(\w+))`)
+
+// compileNginxLogFormat builds a parser from an nginx log_format definition
+// such as `$remote_addr - $remote_user [$time_local] "$request" $status
+// $body_bytes_sent $request_time $upstream_response_time`. $request is split
+// into method and path.
+func compileNginxLogFormat(source LogSource, format string) (*LogParser, error) {
+	locations := nginxVariable.FindAllStringSubmatchIndex(format, -1)
+	if len(locations) == 0 {
+		return nil, fmt.Errorf("log_format has no $variables")
+	}
+
+	var pattern strings.Builder
+	var fields []string
+	pattern.WriteString("^")
+	last := 0
+	for i, loc := range locations {
+		pattern.WriteString(regexp.QuoteMeta(format[last:loc[0]]))
+		last = loc[1]
+
+		var name string
+		if loc[2] >= 0 {
+			name = format[loc[2]:loc[3]]
+		} else {
+			name = format[loc[4]:loc[5]]
+		}
+
+		// A variable extends up to the literal character following it
+		value := `.*`
+		if i+1 < len(locations) && locations[i+1][0] == loc[1] {
+			return nil, fmt.Errorf("log_format variables $%s and the next one are not separated", name)
+		}
+		if loc[1] < len(format) {
+			value = `[^` + regexp.QuoteMeta(format[loc[1]:loc[1]+1]) + `]*`
+		}
+
+		if name == "request" {
+			pattern.WriteString(`(\S+)\s+(\S+)` + value)
+			fields = append(fields, "method", "path")
+			continue
+		}
+		pattern.WriteString("(" + value + ")")
+		if field, ok := nginxVariableFields[name]; ok {
+			name = field
+		}
+		fields = append(fields, name)
+	}
+	pattern.WriteString(regexp.QuoteMeta(format[last:]))
+
+	compiled, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid log_format: %w", err)
+	}
+	return newLogParser(source, compiled, fields), nil
+}
+
+// formatParser returns the cached parser for a log_format definition
+func (lc *LogCollector) formatParser(source LogSource, format string) (*LogParser, error) {
+	key := string(source) + "\x00" + format
+	if parser, ok := lc.formatParsers.Load(key); ok {
+		return parser.(*LogParser), nil
+	}
+	parser, err := compileNginxLogFormat(source, format)
+	if err != nil {
+		return nil, err
+	}
+	lc.formatParsers.Store(key, parser)
+	return parser, nil
+}
+
+// parseCount parses a byte count; "-" and invalid values report false
+func parseCount(value string) (int64, bool) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	return n, err == nil
+}
+
+// parseSeconds parses an nginx time in seconds. Upstream times list one
+// value per contacted upstream ("0.010, 0.022" or "0.010 : 0.022"), which
+// are summed; "-" entries are skipped.
+func parseSeconds(value string) (float64, bool) {
+	var total float64
+	found := false
+	for _, part := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ':' }) {
+		part = strings.TrimSpace(part)
+		if part == "" || part == "-" {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, false
+		}
+		total += seconds
+		found = true
+	}
+	// Avoid float noise such as 0.33999999999999997 from the sum
+	return math.Round(total*1e6) / 1e6, found
+}
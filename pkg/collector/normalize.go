@@ -0,0 +1,223 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FieldMap maps canonical entry fields (see CanonicalFields) to the
+// source-specific parsed_data fields they are filled from, in order of
+// preference. A dotted name also looks into nested maps, so
+// "attributes.client.address" finds the client.address OTLP attribute.
+type FieldMap map[string][]string
+
+// CanonicalFields are the entry fields a FieldMap can fill
+var CanonicalFields = []string{"host", "service", "pid", "user", "ip", "method", "path", "status"}
+
+// DefaultFieldMap holds common names of the canonical fields, including
+// the OpenTelemetry semantic conventions for OTLP attributes
+var DefaultFieldMap = FieldMap{
+	"host":    {"hostname", "host_name", "server_name", "attributes.host.name"},
+	"service": {"service_name", "app", "application", "program"},
+	"pid":     {"process_id", "attributes.process.pid"},
+	"user":    {"username", "user_name", "remote_user", "attributes.user.name", "attributes.enduser.id"},
+	"ip": {"remote_addr", "client_ip", "clientip", "client_addr", "src_ip", "source_ip",
+		"attributes.client.address", "attributes.source.address"},
+	"method": {"request_method", "http_method", "verb", "attributes.http.request.method"},
+	"path":   {"request_uri", "uri", "url_path", "request_path", "attributes.url.path"},
+	"status": {"status_code", "http_status", "response_code", "attributes.http.response.status_code"},
+}
+
+// clone returns a copy of the map that shares neither the map nor its
+// name lists with m
+func (m FieldMap) clone() FieldMap {
+	if m == nil {
+		return nil
+	}
+	copied := make(FieldMap, len(m))
+	for field, names := range m {
+		copied[field] = append([]string(nil), names...)
+	}
+	return copied
+}
+
+// Validate checks that the map only names canonical fields
+func (m FieldMap) Validate() error {
+	for field, names := range m {
+		if !isCanonicalField(field) {
+			return fmt.Errorf("unknown canonical field %q, expected one of %s", field, strings.Join(CanonicalFields, ", "))
+		}
+		for _, name := range names {
+			if name == "" {
+				return fmt.Errorf("empty field name mapped to %s", field)
+			}
+		}
+	}
+	return nil
+}
+
+func isCanonicalField(field string) bool {
+	for _, canonical := range CanonicalFields {
+		if field == canonical {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadFieldMapFile reads a JSON object of canonical fields and the names
+// they are filled from
+func LoadFieldMapFile(path string) (FieldMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read field map file %s: %w", path, err)
+	}
+	var m FieldMap
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to decode field map file %s: %w", path, err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("field map file %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// SetFieldMap adds names to the default field map; they are tried before
+// the default names of the same field. It can be called while the
+// collector runs.
+func (lc *LogCollector) SetFieldMap(m FieldMap) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	merged := make(FieldMap, len(DefaultFieldMap))
+	for field, names := range DefaultFieldMap {
+		merged[field] = append(append([]string{}, m[field]...), names...)
+	}
+	lc.fieldMap.Store(&merged)
+	return nil
+}
+
+// normalize fills the empty canonical fields of an entry from its parsed
+// data, trying the names of the source map before the global ones.
+// Parsed data is left as it is.
+func (lc *LogCollector) normalize(log *SystemLog, source FieldMap) {
+	if len(log.ParsedData) == 0 {
+		return
+	}
+	global := DefaultFieldMap
+	if m := lc.fieldMap.Load(); m != nil {
+		global = *m
+	}
+	for _, field := range CanonicalFields {
+		if !canonicalEmpty(log, field) {
+			continue
+		}
+		for _, names := range [][]string{source[field], global[field]} {
+			if setCanonical(log, field, names) {
+				break
+			}
+		}
+	}
+}
+
+func canonicalEmpty(log *SystemLog, field string) bool {
+	switch field {
+	case "host":
+		return log.Host == ""
+	case "service":
+		return log.Service == ""
+	case "pid":
+		return log.PID == 0
+	case "user":
+		return log.User == ""
+	case "ip":
+		return log.IP == ""
+	case "method":
+		return log.Method == ""
+	case "path":
+		return log.Path == ""
+	case "status":
+		return log.StatusCode == 0
+	}
+	return false
+}
+
+// setCanonical sets field from the first of names with a usable value
+func setCanonical(log *SystemLog, field string, names []string) bool {
+	for _, name := range names {
+		value, ok := lookupParsed(log.ParsedData, name)
+		if !ok {
+			continue
+		}
+		switch field {
+		case "pid", "status":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				continue
+			}
+			if field == "pid" {
+				log.PID = n
+			} else {
+				log.StatusCode = n
+			}
+			return true
+		}
+		if value == "" || value == "-" {
+			continue
+		}
+		switch field {
+		case "host":
+			log.Host = value
+		case "service":
+			log.Service = value
+		case "user":
+			log.User = value
+		case "ip":
+			log.IP = value
+		case "method":
+			log.Method = value
+		case "path":
+			log.Path = value
+		}
+		return true
+	}
+	return false
+}
+
+// lookupParsed finds name in data, descending into nested maps at dots.
+// Only strings and numbers are values.
+func lookupParsed(data map[string]interface{}, name string) (string, bool) {
+	if value, ok := data[name]; ok {
+		switch v := value.(type) {
+		case string:
+			return v, true
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case int:
+			return strconv.Itoa(v), true
+		case int64:
+			return strconv.FormatInt(v, 10), true
+		}
+		return "", false
+	}
+	for i := strings.IndexByte(name, '.'); i > 0; i = nextDot(name, i) {
+		if nested, ok := data[name[:i]].(map[string]interface{}); ok {
+			if value, ok := lookupParsed(nested, name[i+1:]); ok {
+				return value, true
+			}
+		}
+	}
+	return "", false
+}
+
+// nextDot returns the index of the dot after i, or -1
+func nextDot(name string, i int) int {
+	if j := strings.IndexByte(name[i+1:], '.'); j >= 0 {
+		return i + 1 + j
+	}
+	return -1
+}
@@ -0,0 +1,294 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// OutputConfig configures where and how collected logs are written
+type OutputConfig struct {
+	// FilePath enables an NDJSON file output in addition to the console when set
+	FilePath      string
+	BufferSize    int
+	FlushInterval time.Duration
+
+	// DisableConsole turns off the default console output
+	DisableConsole bool
+	// ConsoleFormat is ConsoleFormatJSON (the default when empty), NDJSON
+	// lines prefixed with [SYSTEM_LOG], or ConsoleFormatPretty, aligned
+	// lines for people (see FormatPretty)
+	ConsoleFormat string
+	// ConsoleColor colors the levels of ConsoleFormatPretty
+	ConsoleColor bool
+	// Writers are additional named outputs receiving NDJSON lines
+	Writers map[string]io.Writer
+}
+
+// logOutput is a named, buffered NDJSON destination for processed logs.
+// processSystemLog encodes each entry once and hands the line to every
+// logOutput through write.
+type logOutput struct {
+	name   string
+	prefix string
+	writer *BatchWriter
+	closer io.Closer
+}
+
+// Name returns the output name
+func (o *logOutput) Name() string {
+	return o.name
+}
+
+// Write encodes entry and writes it as one line
+func (o *logOutput) Write(entry *SystemLog) error {
+	buf := acquireBuffer()
+	defer releaseBuffer(buf)
+	if err := json.NewEncoder(buf).Encode(entry); err != nil {
+		return err
+	}
+	return o.write(buf.Bytes())
+}
+
+// Flush writes buffered lines to the destination
+func (o *logOutput) Flush() error {
+	return o.writer.Flush()
+}
+
+// write writes one encoded log line (JSON followed by a newline) to the output
+func (o *logOutput) write(line []byte) error {
+	if o.prefix == "" {
+		_, err := o.writer.Write(line)
+		return err
+	}
+
+	buf := acquireBuffer()
+	defer releaseBuffer(buf)
+	buf.WriteString(o.prefix)
+	buf.Write(line)
+	_, err := o.writer.Write(buf.Bytes())
+	return err
+}
+
+// Close flushes the output and closes the underlying file, if any
+func (o *logOutput) Close() error {
+	err := o.writer.Close()
+	if o.closer != nil {
+		if cerr := o.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// OutputStatus reports the runtime state of an output
+type OutputStatus struct {
+	Name          string `json:"name"`
+	BufferedBytes int    `json:"buffered_bytes"`
+	// Circuit opens when writes keep failing, see BreakerPolicy
+	Circuit BreakerStatus `json:"circuit"`
+}
+
+// GetOutputStatuses returns the current buffer depth of every output
+func (lc *LogCollector) GetOutputStatuses() []OutputStatus {
+	lc.lifecycleMu.Lock()
+	defer lc.lifecycleMu.Unlock()
+
+	statuses := make([]OutputStatus, 0, len(lc.outputs))
+	for _, output := range lc.outputs {
+		status := OutputStatus{Name: output.Name(), Circuit: lc.outputBreaker(output).snapshot()}
+		switch o := output.(type) {
+		case *logOutput:
+			status.BufferedBytes = o.writer.Buffered()
+		case *prettyOutput:
+			status.BufferedBytes = o.writer.Buffered()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// newConsoleOutput creates the default console output
+func newConsoleOutput(size int, interval time.Duration) *logOutput {
+	return &logOutput{
+		name:   "console",
+		prefix: "[SYSTEM_LOG] ",
+		writer: NewBatchWriter(os.Stdout, size, interval),
+	}
+}
+
+// ConfigureOutputs replaces the collector outputs, including those added
+// with AddOutput, according to cfg.
+// It must be called while the collector is stopped.
+func (lc *LogCollector) ConfigureOutputs(cfg OutputConfig) error {
+	lc.lifecycleMu.Lock()
+	defer lc.lifecycleMu.Unlock()
+
+	if lc.running.Load() {
+		return fmt.Errorf("cannot configure outputs while log collector is running")
+	}
+
+	var outputs []Output
+	if !cfg.DisableConsole {
+		switch cfg.ConsoleFormat {
+		case "", ConsoleFormatJSON:
+			outputs = append(outputs, newConsoleOutput(cfg.BufferSize, cfg.FlushInterval))
+		case ConsoleFormatPretty:
+			outputs = append(outputs, newPrettyConsoleOutput(cfg.ConsoleColor, cfg.BufferSize, cfg.FlushInterval))
+		default:
+			return fmt.Errorf("unknown console format %q", cfg.ConsoleFormat)
+		}
+	}
+
+	if cfg.FilePath != "" {
+		file, err := os.OpenFile(cfg.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			for _, output := range outputs {
+				output.Close()
+			}
+			return fmt.Errorf("failed to open output file %s: %w", cfg.FilePath, err)
+		}
+		outputs = append(outputs, &logOutput{
+			name:   "file",
+			writer: NewBatchWriter(file, cfg.BufferSize, cfg.FlushInterval),
+			closer: file,
+		})
+	}
+
+	for name, w := range cfg.Writers {
+		outputs = append(outputs, &logOutput{
+			name:   name,
+			writer: NewBatchWriter(w, cfg.BufferSize, cfg.FlushInterval),
+		})
+	}
+
+	lc.closeOutputs()
+	lc.outputs = outputs
+	return nil
+}
+
+// flushOutputs synchronously flushes all buffered output data
+func (lc *LogCollector) flushOutputs() {
+	for _, output := range lc.outputs {
+		if err := output.Flush(); err != nil {
+			lc.deliveryFailures.Add(1)
+			lc.auditLogger.LogError(err, "Failed to flush log output", map[string]interface{}{
+				"output": output.Name(),
+			})
+		}
+	}
+}
+
+// SyncOutputs flushes every output and waits for the forwarder, if it
+// supports LineSyncer, to deliver or store what it was handed. Compare
+// DeliveryFailures and SkippedEntries before and after to learn whether
+// entries handed over meanwhile were rejected or skipped.
+func (lc *LogCollector) SyncOutputs() error {
+	for _, output := range lc.outputs {
+		if err := output.Flush(); err != nil {
+			lc.deliveryFailures.Add(1)
+			return fmt.Errorf("output %s: %w", output.Name(), err)
+		}
+	}
+	if syncer, ok := lc.forwarder.(LineSyncer); ok {
+		if err := syncer.Sync(); err != nil {
+			return fmt.Errorf("forwarder: %w", err)
+		}
+	}
+	return nil
+}
+
+// DeliveryFailures returns the number of entries and lines outputs or the
+// forwarder did not accept
+func (lc *LogCollector) DeliveryFailures() uint64 {
+	return lc.deliveryFailures.Load()
+}
+
+// SkippedEntries returns the number of entries outputs missed while their
+// circuit was open. Like delivery failures they hold the checkpoints of
+// the batch they happened in, and agent batches with skipped entries are
+// refused so the agent spools and resends them; entries read on the
+// aggregator itself are not resent once the circuit closes.
+func (lc *LogCollector) SkippedEntries() uint64 {
+	return lc.skippedEntries.Load()
+}
+
+// acknowledge is the checkpoint barrier: positions may only advance when
+// every entry handed to the outputs since the last barrier reached every
+// output. A failed batch, or one an open circuit skipped entries of, holds
+// the checkpoints at the last delivered positions, so a restart meanwhile
+// replays it; once a later batch is delivered they advance again, past the
+// entries that were lost, which are counted in DeliveryFailures and
+// SkippedEntries.
+func (lc *LogCollector) acknowledge() error {
+	err := lc.SyncOutputs()
+	failures := lc.deliveryFailures.Load()
+	skipped := lc.skippedEntries.Load()
+	previousFailures := lc.acknowledged.Swap(failures)
+	previousSkipped := lc.acknowledgedSkips.Swap(skipped)
+	switch {
+	case err != nil:
+	case failures != previousFailures:
+		err = fmt.Errorf("%d entries were not accepted by an output", failures-previousFailures)
+	case skipped != previousSkipped:
+		err = fmt.Errorf("%d entries were skipped by an output with an open circuit", skipped-previousSkipped)
+	}
+	if err != nil {
+		if lc.checkpointsHeld.CompareAndSwap(false, true) {
+			lc.auditLogger.LogError(err, "Delivery failed, checkpoints are held until outputs accept entries again", nil)
+		}
+		return err
+	}
+	if lc.checkpointsHeld.CompareAndSwap(true, false) {
+		lc.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: "checkpoints_resumed",
+			Message:   "Outputs accept entries again, checkpoints advance",
+			Details:   map[string]interface{}{"delivery_failures": failures, "skipped_entries": skipped},
+		})
+	}
+	return nil
+}
+
+// closeOutputs flushes and closes all outputs
+func (lc *LogCollector) closeOutputs() {
+	for _, output := range lc.outputs {
+		if err := output.Close(); err != nil {
+			lc.auditLogger.LogError(err, "Failed to close log output", map[string]interface{}{
+				"output": output.Name(),
+			})
+		}
+	}
+	lc.outputs = nil
+}
+
+// Close stops the collector, flushes and closes all outputs, ends all
+// subscriptions and writes the final checkpoints.
+// It is meant to be called once on shutdown.
+func (lc *LogCollector) Close() {
+	lc.Stop()
+
+	lc.lifecycleMu.Lock()
+	lc.stopSelfMonitor()
+	lc.closeSubscriptions()
+	defer lc.lifecycleMu.Unlock()
+	// Checkpoints first: their barrier flushes the outputs and must still
+	// see them to tell whether the final entries were delivered
+	if lc.checkpoints != nil {
+		if err := lc.checkpoints.Close(); err != nil {
+			lc.auditLogger.LogError(err, "Failed to write checkpoints", nil)
+		}
+	}
+	lc.closeOutputs()
+	lc.usage.close()
+	if err := lc.usage.save(); err != nil {
+		lc.auditLogger.LogError(err, "Failed to write usage file", nil)
+	}
+	lc.catalog.close()
+	if err := lc.catalog.save(); err != nil {
+		lc.auditLogger.LogError(err, "Failed to write catalog file", nil)
+	}
+}
@@ -0,0 +1,176 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Parser turns a line read from a source into a log entry. Parse receives an
+// entry with ID, Source, RawLog, Tags, CollectedAt and Timestamp (the read
+// time) already set and fills in the rest. Returning false keeps the line as
+// a raw entry. Level is detected from Message when left empty.
+//
+// Entries are pooled: a parser must not keep entry or its ParsedData map
+// after returning.
+type Parser interface {
+	Parse(line string, config LogSourceConfig, entry *SystemLog) bool
+}
+
+// ParserFunc adapts a function to the Parser interface
+type ParserFunc func(line string, config LogSourceConfig, entry *SystemLog) bool
+
+// Parse calls f
+func (f ParserFunc) Parse(line string, config LogSourceConfig, entry *SystemLog) bool {
+	return f(line, config, entry)
+}
+
+// Processor inspects or modifies every entry after parsing and before it
+// reaches the outputs, including entries ingested over the API. Returning
+// false drops the entry. Processors run on the source goroutines and must
+// not keep entries after returning.
+type Processor interface {
+	Process(entry *SystemLog) bool
+}
+
+// ProcessorFunc adapts a function to the Processor interface
+type ProcessorFunc func(entry *SystemLog) bool
+
+// Process calls f
+func (f ProcessorFunc) Process(entry *SystemLog) bool {
+	return f(entry)
+}
+
+// Output receives every processed entry. Write is called concurrently from
+// the source goroutines and must not keep entry after returning. Flush is
+// called, concurrently with Write, before checkpoints are written and agent
+// batches acknowledged: once it returns nil the entries written so far must
+// survive a crash of the process. A failed Write or Flush holds the
+// checkpoints. Close is called on shutdown.
+type Output interface {
+	Name() string
+	Write(entry *SystemLog) error
+	Flush() error
+	Close() error
+}
+
+// Source produces lines for the collector in place of a tailed file. Run
+// emits lines until ctx is cancelled; returning an error (or panicking)
+// makes the supervisor restart it with backoff. Config names the source and
+// selects its parser through Config().Source; Path and Interval are not
+// used. Custom sources are not checkpointed.
+type Source interface {
+	Config() LogSourceConfig
+	Run(ctx context.Context, emit func(line string)) error
+}
+
+// parserRegistry holds parsers registered with RegisterParser. It is
+// copy-on-write so the parse hot path reads it without locking.
+var parserRegistry struct {
+	mu      sync.Mutex
+	parsers atomic.Pointer[map[LogSource]Parser]
+}
+
+// RegisterParser makes source a known source type parsed by parser, in
+// every collector of the process. Registering a built-in type replaces its
+// parser. It is meant to be called from init functions and panics when
+// source is empty or already registered.
+func RegisterParser(source LogSource, parser Parser) {
+	if source == "" || parser == nil {
+		panic("collector: RegisterParser with empty source or nil parser")
+	}
+
+	parserRegistry.mu.Lock()
+	defer parserRegistry.mu.Unlock()
+
+	current := registeredParsers()
+	if _, exists := current[source]; exists {
+		panic(fmt.Sprintf("collector: parser for %q registered twice", source))
+	}
+	parsers := make(map[LogSource]Parser, len(current)+1)
+	for s, p := range current {
+		parsers[s] = p
+	}
+	parsers[source] = parser
+	parserRegistry.parsers.Store(&parsers)
+}
+
+// registeredParsers returns the parsers added with RegisterParser
+func registeredParsers() map[LogSource]Parser {
+	if parsers := parserRegistry.parsers.Load(); parsers != nil {
+		return *parsers
+	}
+	return nil
+}
+
+// AddProcessor appends a processor to the pipeline; processors run in the
+// order they were added. It must be called while the collector is stopped.
+func (lc *LogCollector) AddProcessor(processor Processor) error {
+	lc.lifecycleMu.Lock()
+	defer lc.lifecycleMu.Unlock()
+
+	if lc.running.Load() {
+		return fmt.Errorf("cannot add processors while log collector is running")
+	}
+	lc.processors = append(lc.processors, processor)
+	return nil
+}
+
+// AddOutput adds an output next to the configured ones. ConfigureOutputs
+// replaces every output, so call AddOutput after it. It must be called
+// while the collector is stopped.
+func (lc *LogCollector) AddOutput(output Output) error {
+	lc.lifecycleMu.Lock()
+	defer lc.lifecycleMu.Unlock()
+
+	if lc.running.Load() {
+		return fmt.Errorf("cannot add outputs while log collector is running")
+	}
+	for _, existing := range lc.outputs {
+		if existing.Name() == output.Name() {
+			return fmt.Errorf("duplicate output name: %s", output.Name())
+		}
+	}
+	lc.outputs = append(lc.outputs, output)
+	return nil
+}
+
+// AddSource registers a custom source, supervised like file sources. Its
+// name must not clash with a configured source. It must be called while
+// the collector is stopped.
+func (lc *LogCollector) AddSource(source Source) error {
+	lc.lifecycleMu.Lock()
+	defer lc.lifecycleMu.Unlock()
+
+	if lc.running.Load() {
+		return fmt.Errorf("cannot add sources while log collector is running")
+	}
+	config := source.Config()
+	if config.Name == "" {
+		return fmt.Errorf("source name is required")
+	}
+	if _, exists := lc.registry.get(config.Name); exists {
+		return fmt.Errorf("duplicate source name: %s", config.Name)
+	}
+	for _, existing := range lc.customSources {
+		if existing.Config().Name == config.Name {
+			return fmt.Errorf("duplicate source name: %s", config.Name)
+		}
+	}
+	lc.customSources = append(lc.customSources, source)
+	return nil
+}
+
+// runCustomSource runs a custom source, feeding its lines into the pipeline
+func (lc *LogCollector) runCustomSource(ctx context.Context, source Source, config LogSourceConfig, state *sourceState) error {
+	var offset int64
+	return source.Run(ctx, func(line string) {
+		state.markActivity()
+		lc.sourceSucceeded(config, state)
+		// The byte count restarts with the source, so it is no position
+		lc.handleLine(line, -1, config)
+		offset += int64(len(line)) + 1
+		state.setOffset(offset)
+	})
+}
@@ -0,0 +1,63 @@
+package collector
+
+import "time"
+
+const (
+	// idlePollsBeforeBackoff is how many polls without new data are needed
+	// before the poll interval of a source starts growing
+	idlePollsBeforeBackoff = 3
+	// defaultMaxIntervalFactor bounds the adaptive interval when a source
+	// doesn't configure MaxInterval explicitly
+	defaultMaxIntervalFactor = 8
+)
+
+// adaptiveInterval tracks the poll interval of a source, backing off while
+// the file is idle and snapping back to the base interval on activity
+type adaptiveInterval struct {
+	base      time.Duration
+	max       time.Duration
+	interval  time.Duration
+	idlePolls int
+}
+
+// newAdaptiveInterval creates the adaptive poll interval for a source
+func newAdaptiveInterval(config LogSourceConfig) *adaptiveInterval {
+	base := time.Duration(config.Interval) * time.Second
+	if base <= 0 {
+		base = time.Second
+	}
+
+	max := time.Duration(config.MaxInterval) * time.Second
+	if max <= 0 {
+		max = base * defaultMaxIntervalFactor
+	}
+	if max < base {
+		max = base
+	}
+
+	return &adaptiveInterval{base: base, max: max, interval: base}
+}
+
+// current returns the interval to wait before the next poll
+func (a *adaptiveInterval) current() time.Duration {
+	return a.interval
+}
+
+// observe updates the interval after a poll that did or didn't find new data
+func (a *adaptiveInterval) observe(active bool) {
+	if active {
+		a.idlePolls = 0
+		a.interval = a.base
+		return
+	}
+
+	a.idlePolls++
+	if a.idlePolls < idlePollsBeforeBackoff {
+		return
+	}
+
+	a.interval *= 2
+	if a.interval > a.max {
+		a.interval = a.max
+	}
+}
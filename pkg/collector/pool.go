@@ -0,0 +1,124 @@
+package collector
+
+import (
+	"bytes"
+	"sync"
+)
+
+// systemLogPool recycles SystemLog values and their ParsedData maps between lines
+var systemLogPool = sync.Pool{
+	New: func() interface{} {
+		return &SystemLog{ParsedData: make(map[string]interface{}, 8)}
+	},
+}
+
+// bufferPool recycles encode buffers used when writing logs to outputs
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// acquireSystemLog returns an empty SystemLog from the pool
+func acquireSystemLog() *SystemLog {
+	return systemLogPool.Get().(*SystemLog)
+}
+
+// releaseSystemLog resets a SystemLog and returns it to the pool.
+// The entry and its ParsedData map must not be used after release.
+func releaseSystemLog(log *SystemLog) {
+	parsedData := log.ParsedData
+	clear(parsedData)
+	*log = SystemLog{ParsedData: parsedData}
+	systemLogPool.Put(log)
+}
+
+func acquireBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func releaseBuffer(buf *bytes.Buffer) {
+	// Don't keep unusually large buffers alive in the pool
+	if buf.Cap() > 64*1024 {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// fieldKind identifies which SystemLog field a parser capture group maps to
+type fieldKind uint8
+
+const (
+	fieldOther fieldKind = iota
+	fieldTimestamp
+	fieldMessage
+	fieldHost
+	fieldService
+	fieldIP
+	fieldMethod
+	fieldPath
+	fieldStatus
+	fieldUser
+	fieldPID
+	fieldCount   // byte counts, stored as int64
+	fieldSeconds // durations in seconds, stored as float64
+)
+
+// fieldKinds maps parser field names to their SystemLog field
+var fieldKinds = map[string]fieldKind{
+	"timestamp": fieldTimestamp,
+	"message":   fieldMessage,
+	"host":      fieldHost,
+	"service":   fieldService,
+	"ip":        fieldIP,
+	"method":    fieldMethod,
+	"path":      fieldPath,
+	"status":    fieldStatus,
+	"user":      fieldUser,
+	"pid":       fieldPID,
+
+	"size":           fieldCount,
+	"bytes_sent":     fieldCount,
+	"request_length": fieldCount,
+
+	"request_time":           fieldSeconds,
+	"upstream_response_time": fieldSeconds,
+	"upstream_connect_time":  fieldSeconds,
+	"upstream_header_time":   fieldSeconds,
+}
+
+// compileFieldKinds resolves parser field names once so parsing doesn't
+// compare strings for every captured value
+func compileFieldKinds(fields []string) []fieldKind {
+	kinds := make([]fieldKind, len(fields))
+	for i, field := range fields {
+		kinds[i] = fieldKinds[field]
+	}
+	return kinds
+}
+
+// containsFold reports whether substr (which must be lower-case ASCII) is
+// within s, ignoring ASCII case, without allocating a lower-cased copy of s
+func containsFold(s, substr string) bool {
+	n := len(substr)
+	if n == 0 {
+		return true
+	}
+	for i := 0; i+n <= len(s); i++ {
+		j := 0
+		for ; j < n; j++ {
+			c := s[i+j]
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			if c != substr[j] {
+				break
+			}
+		}
+		if j == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package collector
+
+import (
+	"io"
+	"testing"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// benchmarkLines are typical lines of the built-in parsers
+var benchmarkLines = []struct {
+	source LogSource
+	line   string
+}{
+	{SourceSyslog, "Oct 16 06:47:22 web-1 sshd[1234]: Failed password for invalid user admin from 203.0.113.7 port 52144 ssh2"},
+	{SourceNginx, `203.0.113.7 - - [16/Oct/2026:06:47:22 +0000] "GET /api/users?page=2 HTTP/1.1" 200 5123 "-" "Mozilla/5.0 (X11; Linux x86_64)"`},
+}
+
+func benchmarkCollector(b *testing.B) *LogCollector {
+	b.Helper()
+	lc := New(audit.NewWithWriter(io.Discard))
+	if err := lc.ConfigureOutputs(OutputConfig{DisableConsole: true, Writers: map[string]io.Writer{"discard": io.Discard}}); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { lc.Close() })
+	return lc
+}
+
+func BenchmarkParseLogLine(b *testing.B) {
+	lc := benchmarkCollector(b)
+	for _, bench := range benchmarkLines {
+		config := LogSourceConfig{Name: string(bench.source), Source: bench.source, Path: "/dev/null", Enabled: true, Interval: 1}
+		b.Run(string(bench.source), func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				entry, _ := lc.parseLogLine(bench.line, config)
+				releaseSystemLog(entry)
+			}
+		})
+	}
+}
+
+func BenchmarkProcessSystemLog(b *testing.B) {
+	lc := benchmarkCollector(b)
+	for _, bench := range benchmarkLines {
+		config := LogSourceConfig{Name: string(bench.source), Source: bench.source, Path: "/dev/null", Enabled: true, Interval: 1}
+		entry, _ := lc.parseLogLine(bench.line, config)
+		b.Run(string(bench.source), func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				lc.processSystemLog(entry)
+			}
+		})
+		releaseSystemLog(entry)
+	}
+}
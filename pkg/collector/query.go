@@ -0,0 +1,468 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Query is a parsed log query such as
+//
+//	source:nginx level>=warn status>=500 "upstream timed out" -path:/health*
+//
+// A query is a list of terms combined with AND (the default between
+// terms), OR and NOT (also written as a leading -), grouped with
+// parentheses; NOT binds tighter than AND, AND tighter than OR. A term is
+// either text, matched case-insensitively anywhere in the message ("quote"
+// phrases with spaces), or field:value. Fields are the entry fields
+// (source, level, message, host, service, user, ip, method, path, status,
+// pid, tag, id, fingerprint, raw); any other name is looked up in
+// parsed_data. Values compare case-insensitively and may use * as a
+// wildcard; ip also accepts a CIDR range. status, pid and level (by
+// severity) take the comparisons >, >=, < and <=, and != negates a term.
+//
+// The zero Query matches everything.
+type Query struct {
+	text string
+	root queryNode
+}
+
+// ParseQuery parses a query; empty text matches everything
+func ParseQuery(text string) (*Query, error) {
+	tokens, err := lexQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	q := &Query{text: strings.TrimSpace(text)}
+	if len(tokens) == 0 {
+		return q, nil
+	}
+	if q.root, err = p.or(); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("invalid query: unexpected %s at %d", p.tokens[p.pos].describe(), p.tokens[p.pos].at+1)
+	}
+	return q, nil
+}
+
+// Match reports whether log is selected by the query
+func (q *Query) Match(log *SystemLog) bool {
+	return q == nil || q.root == nil || q.root.match(log)
+}
+
+// String returns the query text
+func (q *Query) String() string {
+	if q == nil {
+		return ""
+	}
+	return q.text
+}
+
+// MarshalJSON encodes the query as its text
+func (q *Query) MarshalJSON() ([]byte, error) {
+	return json.Marshal(q.String())
+}
+
+// UnmarshalJSON parses a query from a JSON string
+func (q *Query) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	parsed, err := ParseQuery(text)
+	if err != nil {
+		return err
+	}
+	*q = *parsed
+	return nil
+}
+
+// queryNode is a node of the parsed query
+type queryNode interface {
+	match(log *SystemLog) bool
+}
+
+type andNode []queryNode
+
+func (n andNode) match(log *SystemLog) bool {
+	for _, child := range n {
+		if !child.match(log) {
+			return false
+		}
+	}
+	return true
+}
+
+type orNode []queryNode
+
+func (n orNode) match(log *SystemLog) bool {
+	for _, child := range n {
+		if child.match(log) {
+			return true
+		}
+	}
+	return false
+}
+
+type notNode struct{ child queryNode }
+
+func (n notNode) match(log *SystemLog) bool {
+	return !n.child.match(log)
+}
+
+// textNode matches messages containing the text
+type textNode string
+
+func (n textNode) match(log *SystemLog) bool {
+	return containsFold(log.Message, string(n))
+}
+
+// Comparison operators of a field term
+const (
+	opEqual     = ":"
+	opNotEqual  = "!="
+	opLess      = "<"
+	opLessEq    = "<="
+	opGreater   = ">"
+	opGreaterEq = ">="
+)
+
+// fieldNode matches a field against a value
+type fieldNode struct {
+	field string
+	op    string
+	value string
+	// number is the value of numeric comparisons
+	number int
+	// network is the range of an ip:CIDR term
+	network *net.IPNet
+}
+
+func (n *fieldNode) match(log *SystemLog) bool {
+	switch n.field {
+	case "tag", "tags":
+		for _, tag := range log.Tags {
+			if matchValue(tag, n.value) {
+				return n.op == opEqual
+			}
+		}
+		return n.op == opNotEqual
+	case "status", "pid":
+		actual := log.StatusCode
+		if n.field == "pid" {
+			actual = log.PID
+		}
+		if n.op == opEqual || n.op == opNotEqual {
+			break
+		}
+		return actual != 0 && compare(actual, n.op, n.number)
+	case "level":
+		if n.op != opEqual && n.op != opNotEqual {
+			return compare(levelRanks[log.Level], n.op, levelRanks[LogLevel(n.value)])
+		}
+	case "ip":
+		if n.network != nil {
+			ip := net.ParseIP(log.IP)
+			return (ip != nil && n.network.Contains(ip)) == (n.op == opEqual)
+		}
+	}
+
+	actual, ok := queryField(log, n.field)
+	matched := ok && matchValue(actual, n.value)
+	return matched == (n.op == opEqual)
+}
+
+// queryField returns the value of a field of log as text
+func queryField(log *SystemLog, field string) (string, bool) {
+	switch field {
+	case "source":
+		return string(log.Source), true
+	case "level":
+		return string(log.Level), true
+	case "message", "msg":
+		return log.Message, true
+	case "host":
+		return log.Host, true
+	case "service":
+		return log.Service, true
+	case "user":
+		return log.User, true
+	case "ip":
+		return log.IP, true
+	case "method":
+		return log.Method, true
+	case "path":
+		return log.Path, true
+	case "status":
+		return strconv.Itoa(log.StatusCode), log.StatusCode != 0
+	case "pid":
+		return strconv.Itoa(log.PID), log.PID != 0
+	case "id":
+		return log.ID, true
+	case "fingerprint":
+		return log.Fingerprint, true
+	case "raw":
+		return log.RawLog, true
+	}
+	value, ok := log.ParsedData[field]
+	if !ok || value == nil {
+		return "", false
+	}
+	return fmt.Sprint(value), true
+}
+
+func compare(actual int, op string, value int) bool {
+	switch op {
+	case opLess:
+		return actual < value
+	case opLessEq:
+		return actual <= value
+	case opGreater:
+		return actual > value
+	default:
+		return actual >= value
+	}
+}
+
+// matchValue compares case-insensitively; * in pattern matches any text
+func matchValue(actual, pattern string) bool {
+	if !strings.Contains(pattern, "*") {
+		return strings.EqualFold(actual, pattern)
+	}
+	actual = strings.ToLower(actual)
+	parts := strings.Split(strings.ToLower(pattern), "*")
+	if !strings.HasPrefix(actual, parts[0]) {
+		return false
+	}
+	actual = actual[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(actual, part)
+		if i < 0 {
+			return false
+		}
+		actual = actual[i+len(part):]
+	}
+	return len(actual) >= len(last) && strings.HasSuffix(actual, last)
+}
+
+// queryToken is a lexical token: a parenthesis, keyword or term
+type queryToken struct {
+	at   int
+	text string
+	// negated is set for a leading -
+	negated bool
+	// phrase is set for quoted text, which is never a keyword or field
+	phrase bool
+}
+
+func (t queryToken) describe() string {
+	return strconv.Quote(t.text)
+}
+
+func (t queryToken) is(keyword string) bool {
+	return !t.phrase && !t.negated && t.text == keyword
+}
+
+// lexQuery splits text into tokens. A term runs to the next space or
+// parenthesis; quotes may enclose the whole term or its value.
+func lexQuery(text string) ([]queryToken, error) {
+	var tokens []queryToken
+	for i := 0; i < len(text); {
+		switch c := text[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, queryToken{at: i, text: text[i : i+1]})
+			i++
+		default:
+			token := queryToken{at: i}
+			if c == '-' && i+1 < len(text) && !strings.ContainsRune(" \t\n\r()", rune(text[i+1])) {
+				token.negated = true
+				i++
+			}
+			token.phrase = text[i] == '"'
+			var b strings.Builder
+			for i < len(text) && !strings.ContainsRune(" \t\n\r()", rune(text[i])) {
+				if text[i] != '"' {
+					b.WriteByte(text[i])
+					i++
+					continue
+				}
+				end := strings.IndexByte(text[i+1:], '"')
+				if end < 0 {
+					return nil, fmt.Errorf("invalid query: unterminated quote at %d", i+1)
+				}
+				b.WriteString(text[i+1 : i+1+end])
+				i += end + 2
+			}
+			token.text = b.String()
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// queryParser is a recursive descent parser over the tokens
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() (queryToken, bool) {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos], true
+	}
+	return queryToken{}, false
+}
+
+// or parses terms joined by OR
+func (p *queryParser) or() (queryNode, error) {
+	var nodes orNode
+	for {
+		node, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		if t, ok := p.peek(); !ok || !t.is("OR") {
+			break
+		}
+		p.pos++
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+// and parses terms joined by AND or by nothing
+func (p *queryParser) and() (queryNode, error) {
+	var nodes andNode
+	for {
+		t, ok := p.peek()
+		if !ok || t.is(")") || t.is("OR") {
+			break
+		}
+		if t.is("AND") {
+			p.pos++
+			if next, ok := p.peek(); !ok || next.is(")") || next.is("OR") || next.is("AND") {
+				return nil, fmt.Errorf("invalid query: expected a term after AND at %d", t.at+1)
+			}
+			continue
+		}
+		node, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	switch len(nodes) {
+	case 0:
+		if t, ok := p.peek(); ok {
+			return nil, fmt.Errorf("invalid query: expected a term before %s at %d", t.describe(), t.at+1)
+		}
+		return nil, fmt.Errorf("invalid query: expected a term at the end")
+	case 1:
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+// not parses a negated or plain term
+func (p *queryParser) not() (queryNode, error) {
+	t, _ := p.peek()
+	if t.is("NOT") {
+		p.pos++
+		if _, ok := p.peek(); !ok {
+			return nil, fmt.Errorf("invalid query: expected a term after NOT at %d", t.at+1)
+		}
+		child, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{child}, nil
+	}
+	if t.is("(") {
+		p.pos++
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if closing, ok := p.peek(); !ok || !closing.is(")") {
+			return nil, fmt.Errorf("invalid query: unclosed ( at %d", t.at+1)
+		}
+		p.pos++
+		return node, nil
+	}
+	p.pos++
+	node, err := parseTerm(t)
+	if err != nil || !t.negated {
+		return node, err
+	}
+	return notNode{node}, nil
+}
+
+// parseTerm turns a token into text or a field comparison
+func parseTerm(t queryToken) (queryNode, error) {
+	field, op, value, ok := splitTerm(t.text)
+	if t.phrase || !ok {
+		return textNode(t.text), nil
+	}
+	node := &fieldNode{field: strings.ToLower(field), op: op, value: value}
+	switch op {
+	case opEqual, opNotEqual:
+		if node.field == "ip" && strings.Contains(value, "/") {
+			_, network, err := net.ParseCIDR(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid query: ip range %q at %d: %w", value, t.at+1, err)
+			}
+			node.network = network
+		}
+		return node, nil
+	}
+
+	switch node.field {
+	case "status", "pid":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query: %s%s needs a number at %d", field, op, t.at+1)
+		}
+		node.number = n
+	case "level":
+		if _, known := levelRanks[LogLevel(strings.ToLower(value))]; !known {
+			return nil, fmt.Errorf("invalid query: unknown level %q at %d", value, t.at+1)
+		}
+		node.value = strings.ToLower(value)
+	default:
+		return nil, fmt.Errorf("invalid query: %s can't be compared with %s at %d", field, op, t.at+1)
+	}
+	return node, nil
+}
+
+// splitTerm splits field:value and comparisons. The field must be a name
+// so text such as "12:30" or "->" stays text.
+func splitTerm(term string) (field, op, value string, ok bool) {
+	end := 0
+	for end < len(term) && isFieldChar(term[end]) {
+		end++
+	}
+	if end == 0 || end == len(term) || term[0] >= '0' && term[0] <= '9' {
+		return "", "", "", false
+	}
+	rest := term[end:]
+	for _, op := range []string{opGreaterEq, opLessEq, opNotEqual, opGreater, opLess, opEqual} {
+		if strings.HasPrefix(rest, op) && len(rest) > len(op) {
+			return term[:end], op, rest[len(op):], true
+		}
+	}
+	return "", "", "", false
+}
+
+func isFieldChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.'
+}
@@ -0,0 +1,312 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// QuotaAction is what happens to lines over a quota
+type QuotaAction string
+
+const (
+	// QuotaDrop drops the lines over the quota
+	QuotaDrop QuotaAction = "drop"
+	// QuotaSample keeps one in SampleRate of the lines over the quota
+	QuotaSample QuotaAction = "sample"
+	// QuotaPause stops reading tailed files until the period ends, so their
+	// lines are read later instead of lost. Lines that can't wait (API,
+	// agent batches, custom sources) are dropped.
+	QuotaPause QuotaAction = "pause"
+)
+
+// quotaEffects describes the actions in audit messages
+var quotaEffects = map[QuotaAction]string{
+	QuotaDrop:   "dropping lines",
+	QuotaSample: "sampling lines",
+	QuotaPause:  "pausing sources",
+}
+
+// Quota periods
+const (
+	QuotaHourly = "hour"
+	QuotaDaily  = "day"
+)
+
+// defaultSampleRate is the SampleRate of sampling quotas that leave it unset
+const defaultSampleRate = 10
+
+// QuotaConfig is an ingestion budget of one source or of every source with
+// a tag (a tenant), per hour or day. Periods are aligned on UTC hours and
+// days.
+type QuotaConfig struct {
+	Name string `json:"name"`
+	// Source is a source name; Tag selects the sources with that tag.
+	// Exactly one of them is set.
+	Source string `json:"source,omitempty"`
+	Tag    string `json:"tag,omitempty"`
+	Period string `json:"period"`
+	// MaxBytes and MaxLines are the budgets; 0 leaves one unlimited
+	MaxBytes int64       `json:"max_bytes,omitempty"`
+	MaxLines int64       `json:"max_lines,omitempty"`
+	Action   QuotaAction `json:"action,omitempty"` // defaults to drop
+	// SampleRate keeps one in SampleRate lines over a sampling quota;
+	// defaults to 10
+	SampleRate int `json:"sample_rate,omitempty"`
+}
+
+// QuotaStatus reports the usage of a quota in its current period
+type QuotaStatus struct {
+	QuotaConfig
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	Bytes       int64     `json:"bytes"`
+	Lines       int64     `json:"lines"`
+	Exceeded    bool      `json:"exceeded"`
+	// Dropped and Sampled count the lines over the quota that were dropped
+	// and kept by sampling since the quota was configured
+	Dropped uint64 `json:"dropped"`
+	Sampled uint64 `json:"sampled"`
+	// Deferred counts the reads of a paused file stopped by the quota
+	Deferred uint64 `json:"deferred"`
+}
+
+// quotaVerdict is the decision on one line
+type quotaVerdict int
+
+const (
+	quotaAdmit quotaVerdict = iota
+	quotaReject
+	// quotaDefer asks the caller to stop reading and come back later
+	quotaDefer
+)
+
+// quota is a configured quota with the usage of its current period
+type quota struct {
+	config QuotaConfig
+
+	mu       sync.Mutex
+	start    time.Time
+	end      time.Time
+	bytes    int64
+	lines    int64
+	exceeded bool
+	seen     uint64 // lines over the quota, for sampling
+
+	dropped  atomic.Uint64
+	sampled  atomic.Uint64
+	deferred atomic.Uint64
+}
+
+// LoadQuotasFile reads a JSON array of quota configurations from path
+func LoadQuotasFile(path string) ([]QuotaConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quotas file %s: %w", path, err)
+	}
+
+	// Unknown fields are rejected so a misspelled setting is not ignored
+	var quotas []QuotaConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&quotas); err != nil {
+		return nil, fmt.Errorf("failed to decode quotas file %s: %w", path, err)
+	}
+	return quotas, nil
+}
+
+// Validate checks a quota configuration
+func (c QuotaConfig) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("quota name is required")
+	}
+	if (c.Source == "") == (c.Tag == "") {
+		return fmt.Errorf("quota %s: exactly one of source and tag is required", c.Name)
+	}
+	if c.Period != QuotaHourly && c.Period != QuotaDaily {
+		return fmt.Errorf("quota %s: period must be %q or %q", c.Name, QuotaHourly, QuotaDaily)
+	}
+	if c.MaxBytes < 0 || c.MaxLines < 0 {
+		return fmt.Errorf("quota %s: limits must not be negative", c.Name)
+	}
+	if c.MaxBytes == 0 && c.MaxLines == 0 {
+		return fmt.Errorf("quota %s: max_bytes or max_lines is required", c.Name)
+	}
+	switch c.Action {
+	case "", QuotaDrop, QuotaSample, QuotaPause:
+	default:
+		return fmt.Errorf("quota %s: unknown action %q", c.Name, c.Action)
+	}
+	if c.SampleRate < 0 {
+		return fmt.Errorf("quota %s: sample_rate must not be negative", c.Name)
+	}
+	return nil
+}
+
+// SetQuotas replaces the ingestion quotas; usage starts over. It can be
+// called while the collector runs.
+func (lc *LogCollector) SetQuotas(configs []QuotaConfig) error {
+	names := make(map[string]bool, len(configs))
+	quotas := make([]*quota, 0, len(configs))
+	for _, config := range configs {
+		if err := config.Validate(); err != nil {
+			return err
+		}
+		if names[config.Name] {
+			return fmt.Errorf("duplicate quota %s", config.Name)
+		}
+		names[config.Name] = true
+		if config.Action == "" {
+			config.Action = QuotaDrop
+		}
+		if config.Action == QuotaSample && config.SampleRate == 0 {
+			config.SampleRate = defaultSampleRate
+		}
+		quotas = append(quotas, &quota{config: config})
+	}
+	lc.quotas.Store(&quotas)
+	return nil
+}
+
+// QuotaStatuses returns the usage of every quota
+func (lc *LogCollector) QuotaStatuses() []QuotaStatus {
+	quotas := lc.quotas.Load()
+	if quotas == nil {
+		return []QuotaStatus{}
+	}
+	now := time.Now()
+	statuses := make([]QuotaStatus, len(*quotas))
+	for i, q := range *quotas {
+		q.mu.Lock()
+		q.roll(now)
+		statuses[i] = QuotaStatus{
+			QuotaConfig: q.config,
+			PeriodStart: q.start,
+			PeriodEnd:   q.end,
+			Bytes:       q.bytes,
+			Lines:       q.lines,
+			Exceeded:    q.exceeded,
+			Dropped:     q.dropped.Load(),
+			Sampled:     q.sampled.Load(),
+			Deferred:    q.deferred.Load(),
+		}
+		q.mu.Unlock()
+	}
+	return statuses
+}
+
+// admitLine charges a line of config to its quotas and counts it as
+// ingested once admitted. canDefer tells whether the caller can stop reading
+// and retry the line later; it then gets quotaDefer from pausing quotas
+// along with the end of the pause.
+func (lc *LogCollector) admitLine(config LogSourceConfig, size int, canDefer bool) (quotaVerdict, time.Time) {
+	quotas := lc.quotas.Load()
+	if quotas == nil {
+		lc.usage.add(usageIngested, config, size)
+		return quotaAdmit, time.Time{}
+	}
+	now := time.Now()
+	var matching []*quota
+	for _, q := range *quotas {
+		if q.applies(config) {
+			// The line is only charged once every quota admits it
+			if verdict, until := q.check(now, canDefer); verdict != quotaAdmit {
+				return verdict, until
+			}
+			matching = append(matching, q)
+		}
+	}
+	for _, q := range matching {
+		if q.charge(now, int64(size)) {
+			lc.auditLogger.LogEvent(audit.AuditEvent{
+				EventType: "quota_exceeded",
+				Message:   fmt.Sprintf("Quota %s exceeded, %s until %s", q.config.Name, quotaEffects[q.config.Action], q.end.Format(time.RFC3339)),
+				Details: map[string]interface{}{
+					"quota":     q.config.Name,
+					"source":    config.Name,
+					"action":    q.config.Action,
+					"max_bytes": q.config.MaxBytes,
+					"max_lines": q.config.MaxLines,
+					"until":     q.end,
+				},
+			})
+		}
+	}
+	lc.usage.add(usageIngested, config, size)
+	return quotaAdmit, time.Time{}
+}
+
+func (q *quota) applies(config LogSourceConfig) bool {
+	if q.config.Source != "" {
+		return config.Name == q.config.Source
+	}
+	for _, tag := range config.Tags {
+		if tag == q.config.Tag {
+			return true
+		}
+	}
+	return false
+}
+
+// check decides on a line once the quota is exceeded
+func (q *quota) check(now time.Time, canDefer bool) (quotaVerdict, time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll(now)
+	if !q.exceeded {
+		return quotaAdmit, time.Time{}
+	}
+	switch q.config.Action {
+	case QuotaSample:
+		q.seen++
+		if (q.seen-1)%uint64(q.config.SampleRate) == 0 {
+			q.sampled.Add(1)
+			return quotaAdmit, time.Time{}
+		}
+	case QuotaPause:
+		if canDefer {
+			q.deferred.Add(1)
+			return quotaDefer, q.end
+		}
+	}
+	q.dropped.Add(1)
+	return quotaReject, time.Time{}
+}
+
+// charge adds a line to the usage and reports whether it exceeded the
+// quota
+func (q *quota) charge(now time.Time, size int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll(now)
+	q.bytes += size
+	q.lines++
+	if q.exceeded {
+		return false
+	}
+	q.exceeded = (q.config.MaxBytes > 0 && q.bytes >= q.config.MaxBytes) ||
+		(q.config.MaxLines > 0 && q.lines >= q.config.MaxLines)
+	return q.exceeded
+}
+
+// roll starts a new period when the current one ended. q.mu must be held.
+func (q *quota) roll(now time.Time) {
+	if now.Before(q.end) {
+		return
+	}
+	now = now.UTC()
+	if q.config.Period == QuotaHourly {
+		q.start = now.Truncate(time.Hour)
+		q.end = q.start.Add(time.Hour)
+	} else {
+		q.start = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		q.end = q.start.AddDate(0, 0, 1)
+	}
+	q.bytes, q.lines, q.exceeded, q.seen = 0, 0, false, 0
+}
@@ -0,0 +1,89 @@
+package collector
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+func TestQuotaPauseResume(t *testing.T) {
+	tests := []struct {
+		name   string
+		action QuotaAction
+		ending string
+		// unterminated leaves the last line without its ending
+		unterminated bool
+		// resumed is what is read once the quota is lifted
+		resumed []string
+	}{
+		{name: "drop", action: QuotaDrop, ending: "\n"},
+		{name: "pause", action: QuotaPause, ending: "\n", resumed: []string{"three", "four"}},
+		{name: "pause with CRLF", action: QuotaPause, ending: "\r\n", resumed: []string{"three", "four"}},
+		{name: "pause without a final line ending", action: QuotaPause, ending: "\r\n", unterminated: true, resumed: []string{"three", "four"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lc := New(audit.NewWithWriter(io.Discard))
+			if err := lc.ConfigureOutputs(OutputConfig{DisableConsole: true, Writers: map[string]io.Writer{"discard": io.Discard}}); err != nil {
+				t.Fatal(err)
+			}
+			defer lc.Close()
+			entries, unsubscribe := lc.Subscribe(Filter{})
+			defer unsubscribe()
+			if err := lc.SetQuotas([]QuotaConfig{{Name: "app", Source: "app", Period: QuotaHourly, MaxLines: 2, Action: test.action}}); err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join(t.TempDir(), "app.log")
+			data := strings.Join([]string{"one", "two", "three", "four"}, test.ending)
+			if !test.unterminated {
+				data += test.ending
+			}
+			appendFile(t, path, data)
+			config := LogSourceConfig{Name: "app", Source: SourceCustom, Path: path, Enabled: true, Interval: 1}
+			state := lc.sourceStateFor(config)
+			tail := &tailedFile{}
+			defer tail.close()
+
+			if err := lc.readNewLines(config, state, tail); err != nil {
+				t.Fatal(err)
+			}
+			if got := received(entries); !reflect.DeepEqual(got, []string{"one", "two"}) {
+				t.Fatalf("read %q over the quota", got)
+			}
+			// A paused source stops at the first deferred line
+			offset := int64(len(data))
+			if test.action == QuotaPause {
+				offset = int64(len("one" + test.ending + "two" + test.ending))
+			}
+			if got := state.getOffset(); got != offset {
+				t.Fatalf("offset %d, want %d", got, offset)
+			}
+			if state.isPaused() != (test.action == QuotaPause) {
+				t.Fatalf("paused %v", state.isPaused())
+			}
+
+			// New quotas start the usage over
+			if err := lc.SetQuotas(nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := lc.readNewLines(config, state, tail); err != nil {
+				t.Fatal(err)
+			}
+			if got := received(entries); !reflect.DeepEqual(got, test.resumed) {
+				t.Fatalf("read %q after the quota was lifted, want %q", got, test.resumed)
+			}
+			if info, err := os.Stat(path); err != nil || state.getOffset() != info.Size() {
+				t.Fatalf("offset %d after resuming, err %v", state.getOffset(), err)
+			}
+			if state.isPaused() {
+				t.Fatal("the source is still paused")
+			}
+		})
+	}
+}
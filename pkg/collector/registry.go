@@ -0,0 +1,145 @@
+package collector
+
+import (
+	"fmt"
+	"sync"
+)
+
+// sourceRegistry holds the configured log sources. All reads return deep
+// copies so callers can never observe or cause concurrent mutation.
+type sourceRegistry struct {
+	mu      sync.RWMutex
+	sources []LogSourceConfig
+}
+
+// list returns a deep copy of all sources in registration order
+func (r *sourceRegistry) list() []LogSourceConfig {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sources := make([]LogSourceConfig, len(r.sources))
+	for i, source := range r.sources {
+		sources[i] = source.clone()
+	}
+	return sources
+}
+
+// get returns a deep copy of the named source
+func (r *sourceRegistry) get(name string) (LogSourceConfig, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, source := range r.sources {
+		if source.Name == name {
+			return source.clone(), true
+		}
+	}
+	return LogSourceConfig{}, false
+}
+
+// replace swaps the full set of sources
+func (r *sourceRegistry) replace(sources []LogSourceConfig) error {
+	seen := make(map[string]bool, len(sources))
+	copied := make([]LogSourceConfig, len(sources))
+	for i, source := range sources {
+		if source.Name == "" {
+			return fmt.Errorf("source %d has no name", i)
+		}
+		if err := source.Validate(); err != nil {
+			return err
+		}
+		if seen[source.Name] {
+			return fmt.Errorf("duplicate source name: %s", source.Name)
+		}
+		seen[source.Name] = true
+		copied[i] = source.clone()
+	}
+
+	r.mu.Lock()
+	r.sources = copied
+	r.mu.Unlock()
+	return nil
+}
+
+// clone returns a deep copy of the source configuration
+func (c LogSourceConfig) clone() LogSourceConfig {
+	c.Tags = append([]string(nil), c.Tags...)
+	c.TimestampLayouts = append([]string(nil), c.TimestampLayouts...)
+	c.FieldMap = c.FieldMap.clone()
+	return c
+}
+
+// SourceSnapshot is a point-in-time copy of a source configuration together
+// with its live runtime state
+type SourceSnapshot struct {
+	LogSourceConfig
+	Runtime *SourceStatus `json:"runtime,omitempty"`
+}
+
+// GetSources returns a copy of all configured log sources
+func (lc *LogCollector) GetSources() []LogSourceConfig {
+	return lc.registry.list()
+}
+
+// GetSource returns a copy of the named log source
+func (lc *LogCollector) GetSource(name string) (LogSourceConfig, bool) {
+	return lc.registry.get(name)
+}
+
+// GetSourceSnapshots returns every source with its runtime state (offset,
+// last activity, last error). Sources that never ran have no runtime state.
+func (lc *LogCollector) GetSourceSnapshots() []SourceSnapshot {
+	sources := lc.registry.list()
+	snapshots := make([]SourceSnapshot, len(sources))
+
+	lc.statesMu.RLock()
+	defer lc.statesMu.RUnlock()
+
+	for i, source := range sources {
+		snapshots[i] = SourceSnapshot{LogSourceConfig: source}
+		if state, exists := lc.states[source.Name]; exists {
+			status := state.snapshot()
+			snapshots[i].Runtime = &status
+		}
+	}
+	return snapshots
+}
+
+// SetSources replaces the configured log sources.
+// It must be called while the collector is stopped.
+func (lc *LogCollector) SetSources(sources []LogSourceConfig) error {
+	lc.lifecycleMu.Lock()
+	defer lc.lifecycleMu.Unlock()
+
+	if lc.running.Load() {
+		return fmt.Errorf("cannot change sources while log collector is running")
+	}
+	for _, source := range sources {
+		for _, custom := range lc.customSources {
+			if custom.Config().Name == source.Name {
+				return fmt.Errorf("duplicate source name: %s", source.Name)
+			}
+		}
+	}
+
+	return lc.registry.replace(sources)
+}
+
+// ReplaceSources swaps the configured log sources while collecting: a
+// running collector is stopped, given the new sources and started again;
+// sources keep their positions by name. On error the previous sources are
+// kept.
+func (lc *LogCollector) ReplaceSources(sources []LogSourceConfig) error {
+	wasRunning := lc.IsRunning()
+	lc.Stop()
+	if err := lc.SetSources(sources); err != nil {
+		if wasRunning {
+			lc.Start()
+		}
+		return err
+	}
+	if wasRunning {
+		return lc.Start()
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package collector
+
+import "testing"
+
+func TestSourceConfigCloneIsolated(t *testing.T) {
+	original := LogSourceConfig{
+		Name:             "app",
+		Tags:             []string{"web"},
+		TimestampLayouts: []string{"2006-01-02"},
+		FieldMap:         FieldMap{"host": {"hostname"}},
+	}
+	copied := original.clone()
+	copied.Tags[0] = "changed"
+	copied.TimestampLayouts[0] = "changed"
+	copied.FieldMap["host"][0] = "changed"
+	copied.FieldMap["service"] = []string{"app"}
+
+	if original.Tags[0] != "web" || original.TimestampLayouts[0] != "2006-01-02" {
+		t.Fatalf("clone shares slices: %+v", original)
+	}
+	if original.FieldMap["host"][0] != "hostname" {
+		t.Fatalf("clone shares field map names: %v", original.FieldMap)
+	}
+	if _, ok := original.FieldMap["service"]; ok {
+		t.Fatalf("clone shares the field map: %v", original.FieldMap)
+	}
+	if (LogSourceConfig{}).clone().FieldMap != nil {
+		t.Fatal("clone of a nil field map is not nil")
+	}
+}
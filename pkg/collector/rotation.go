@@ -0,0 +1,104 @@
+package collector
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// How a source's file was rotated, see SourceStatus.LastRotation
+const (
+	// RotationRenamed means the file was renamed or removed and another
+	// file took its path (logrotate's default)
+	RotationRenamed = "renamed"
+	// RotationTruncated means the file was truncated in place (logrotate's
+	// copytruncate)
+	RotationTruncated = "truncated"
+	// RotationReplaced means the file at the path no longer starts with
+	// the bytes read before, e.g. after a rotation while gonder was down
+	RotationReplaced = "replaced"
+)
+
+// keepFilesOpen keeps the file a source reads open between reads, so it can
+// be finished after a rotation renamed it. Windows doesn't let a file be
+// renamed while it is open, so there it is opened for each read.
+const keepFilesOpen = runtime.GOOS != "windows"
+
+// tailedFile is the file a source is reading. Its identity, the device and
+// inode on Unix, tells whether the path still names it.
+type tailedFile struct {
+	file *os.File
+	info os.FileInfo
+}
+
+func (t *tailedFile) open(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open log file %s: %w", path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file %s: %w", path, err)
+	}
+	t.file, t.info = file, info
+	return nil
+}
+
+func (t *tailedFile) close() {
+	if t.file != nil {
+		t.file.Close()
+		t.file, t.info = nil, nil
+	}
+}
+
+// drainRotated reads the lines written to the open file since it was
+// renamed or removed, e.g. by logrotate, until another file took its path,
+// so they are not lost. Then it closes it, and the source starts over with
+// the new file. It reports false while a pausing quota holds the rest of
+// the old file.
+func (lc *LogCollector) drainRotated(config LogSourceConfig, state *sourceState, tail *tailedFile) (bool, error) {
+	from := state.getOffset()
+	info, err := tail.file.Stat()
+	if err != nil {
+		tail.close()
+		return false, fmt.Errorf("failed to stat rotated log file %s: %w", config.Path, err)
+	}
+	if info.Size() > from {
+		done, err := lc.readFrom(config, state, tail.file, info.Size(), from)
+		if err != nil || !done {
+			return false, err
+		}
+	}
+
+	drained := state.getOffset() - from
+	tail.close()
+	state.setOffset(0)
+	state.setFingerprint(fingerprint{})
+	lc.catalog.release(config.Name)
+	lc.logRotation(config, state, RotationRenamed, from, drained)
+	return true, nil
+}
+
+// logRotation records a rotation of a source's file. offset is where the
+// old file had been read to, drained the bytes read from it afterwards.
+func (lc *LogCollector) logRotation(config LogSourceConfig, state *sourceState, rotation string, offset, drained int64) {
+	state.recordRotation(rotation)
+	message := fmt.Sprintf("Log file of source %s was %s, reading the new file from the start", config.Name, rotation)
+	if drained > 0 {
+		message = fmt.Sprintf("Log file of source %s was %s, read the last %d bytes of the old file", config.Name, rotation, drained)
+	}
+	lc.auditLogger.LogEvent(audit.AuditEvent{
+		EventType: "log_source_rotated",
+		Message:   message,
+		Details: map[string]interface{}{
+			"source":        config.Name,
+			"path":          config.Path,
+			"rotation":      rotation,
+			"offset":        offset,
+			"drained_bytes": drained,
+		},
+	})
+}
@@ -0,0 +1,160 @@
+package collector
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// received drains the messages delivered to a subscription so far
+func received(entries <-chan SystemLog) []string {
+	var messages []string
+	for {
+		select {
+		case entry := <-entries:
+			messages = append(messages, entry.Message)
+		default:
+			return messages
+		}
+	}
+}
+
+func TestReadNewLinesRotation(t *testing.T) {
+	tests := []struct {
+		name string
+		// rotate changes the file at path after "one" and "two" were read
+		rotate func(t *testing.T, path string)
+		// renames needs the old file to stay open across reads
+		renames  bool
+		want     []string
+		rotation string
+	}{
+		{
+			name: "appended",
+			rotate: func(t *testing.T, path string) {
+				appendFile(t, path, "three\n")
+			},
+			want: []string{"three"},
+		},
+		{
+			name: "renamed",
+			rotate: func(t *testing.T, path string) {
+				if err := os.Rename(path, path+".1"); err != nil {
+					t.Fatal(err)
+				}
+				// Written by the service before it reopened its log
+				appendFile(t, path+".1", "three\n")
+				appendFile(t, path, "four\n")
+			},
+			renames:  true,
+			want:     []string{"three", "four"},
+			rotation: RotationRenamed,
+		},
+		{
+			name: "removed",
+			rotate: func(t *testing.T, path string) {
+				// The service still writes to the file it has open
+				file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer file.Close()
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := file.WriteString("three\n"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			renames: true,
+			want:    []string{"three"},
+		},
+		{
+			name: "truncated",
+			rotate: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, []byte("3\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:     []string{"3"},
+			rotation: RotationTruncated,
+		},
+		{
+			name: "replaced by a longer file",
+			rotate: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, []byte("three\nfour\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:     []string{"three", "four"},
+			rotation: RotationReplaced,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.renames && !keepFilesOpen {
+				t.Skip("files are not kept open between reads")
+			}
+			lc := New(audit.NewWithWriter(io.Discard))
+			if err := lc.ConfigureOutputs(OutputConfig{DisableConsole: true, Writers: map[string]io.Writer{"discard": io.Discard}}); err != nil {
+				t.Fatal(err)
+			}
+			defer lc.Close()
+			entries, unsubscribe := lc.Subscribe(Filter{})
+			defer unsubscribe()
+
+			path := filepath.Join(t.TempDir(), "app.log")
+			appendFile(t, path, "one\ntwo\n")
+			config := LogSourceConfig{Name: "app", Source: SourceCustom, Path: path, Enabled: true, Interval: 1}
+			state := lc.sourceStateFor(config)
+			tail := &tailedFile{}
+			defer tail.close()
+
+			if err := lc.readNewLines(config, state, tail); err != nil {
+				t.Fatal(err)
+			}
+			if got := received(entries); !reflect.DeepEqual(got, []string{"one", "two"}) {
+				t.Fatalf("first read got %q", got)
+			}
+
+			test.rotate(t, path)
+			if err := lc.readNewLines(config, state, tail); err != nil {
+				t.Fatal(err)
+			}
+			if got := received(entries); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("read %q after the rotation, want %q", got, test.want)
+			}
+			status := state.snapshot()
+			if status.LastRotation != test.rotation {
+				t.Fatalf("last rotation %q, want %q", status.LastRotation, test.rotation)
+			}
+			if test.rotation != "" && status.Rotations != 1 {
+				t.Fatalf("%d rotations recorded", status.Rotations)
+			}
+
+			// Nothing is read twice afterwards
+			if err := lc.readNewLines(config, state, tail); err != nil {
+				t.Fatal(err)
+			}
+			if got := received(entries); len(got) != 0 {
+				t.Fatalf("read %q again", got)
+			}
+		})
+	}
+}
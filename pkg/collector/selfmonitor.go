@@ -0,0 +1,191 @@
+package collector
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// SourceSelf marks logs describing gonder itself
+const SourceSelf LogSource = "gonder"
+
+// selfMonitorQueueSize bounds the events waiting to be processed
+const selfMonitorQueueSize = 1024
+
+// SelfMonitorStatus reports the self-monitoring counters
+type SelfMonitorStatus struct {
+	Enabled   bool   `json:"enabled"`
+	Processed uint64 `json:"processed"`
+	// Dropped counts events lost because the queue was full
+	Dropped uint64 `json:"dropped"`
+	// Suppressed counts events raised while a self event was being processed;
+	// they are not fed back to avoid loops
+	Suppressed uint64 `json:"suppressed"`
+}
+
+// selfMonitor feeds the collector's own audit events through the output
+// pipeline as SourceSelf logs
+type selfMonitor struct {
+	exclude map[audit.EventType]bool
+	host    string
+	events  chan audit.AuditEvent
+	done    chan struct{}
+	wg      sync.WaitGroup
+
+	// processing is set while a self event is being processed, so events
+	// it causes (e.g. an output write error) are not fed back
+	processing atomic.Bool
+	stopped    atomic.Bool
+
+	processed  atomic.Uint64
+	dropped    atomic.Uint64
+	suppressed atomic.Uint64
+}
+
+// EnableSelfMonitoring makes the collector process its own audit events
+// (startup, errors, source restarts, ...) like any other source, so they
+// reach the same outputs. Event types in exclude are skipped; HTTP request
+// events are usually excluded to avoid noise.
+func (lc *LogCollector) EnableSelfMonitoring(exclude []string) {
+	lc.lifecycleMu.Lock()
+	defer lc.lifecycleMu.Unlock()
+
+	if lc.self != nil {
+		return
+	}
+
+	host, _ := os.Hostname()
+	sm := &selfMonitor{
+		exclude: make(map[audit.EventType]bool, len(exclude)),
+		host:    host,
+		events:  make(chan audit.AuditEvent, selfMonitorQueueSize),
+		done:    make(chan struct{}),
+	}
+	for _, eventType := range exclude {
+		sm.exclude[audit.EventType(eventType)] = true
+	}
+	lc.self = sm
+
+	lc.auditLogger.AddSink(sm.enqueue)
+	sm.wg.Add(1)
+	go lc.runSelfMonitor(sm)
+}
+
+// enqueue is the audit sink; it never blocks the caller
+func (sm *selfMonitor) enqueue(event audit.AuditEvent) {
+	if sm.stopped.Load() || sm.exclude[event.EventType] {
+		return
+	}
+	if sm.processing.Load() {
+		sm.suppressed.Add(1)
+		return
+	}
+	select {
+	case sm.events <- event:
+	default:
+		sm.dropped.Add(1)
+	}
+}
+
+func (lc *LogCollector) runSelfMonitor(sm *selfMonitor) {
+	defer sm.wg.Done()
+
+	for {
+		select {
+		case <-sm.done:
+			return
+		case event := <-sm.events:
+			sm.processing.Store(true)
+			systemLog := sm.toSystemLog(event)
+			lc.processSystemLog(systemLog)
+			releaseSystemLog(systemLog)
+			sm.processing.Store(false)
+			sm.processed.Add(1)
+		}
+	}
+}
+
+// stopSelfMonitor stops feeding events; pending events are discarded
+func (lc *LogCollector) stopSelfMonitor() {
+	sm := lc.self
+	if sm == nil || sm.stopped.Swap(true) {
+		return
+	}
+	close(sm.done)
+	sm.wg.Wait()
+}
+
+// SelfMonitorStatus returns the self-monitoring counters
+func (lc *LogCollector) SelfMonitorStatus() SelfMonitorStatus {
+	lc.lifecycleMu.Lock()
+	sm := lc.self
+	lc.lifecycleMu.Unlock()
+
+	if sm == nil {
+		return SelfMonitorStatus{}
+	}
+	return SelfMonitorStatus{
+		Enabled:    !sm.stopped.Load(),
+		Processed:  sm.processed.Load(),
+		Dropped:    sm.dropped.Load(),
+		Suppressed: sm.suppressed.Load(),
+	}
+}
+
+// toSystemLog converts an audit event to a log entry
+func (sm *selfMonitor) toSystemLog(event audit.AuditEvent) *SystemLog {
+	now := time.Now()
+	systemLog := acquireSystemLog()
+	systemLog.ID = newLogID(now)
+	systemLog.Timestamp = event.Timestamp.UTC()
+	systemLog.Source = SourceSelf
+	systemLog.Level = selfEventLevel(event)
+	systemLog.Message = event.Message
+	systemLog.Host = sm.host
+	systemLog.Service = "gonder"
+	systemLog.PID = os.Getpid()
+	systemLog.Method = event.Method
+	systemLog.Path = event.Path
+	systemLog.StatusCode = event.StatusCode
+	systemLog.Tags = []string{"self"}
+	systemLog.CollectedAt = now
+
+	raw, _ := json.Marshal(event)
+	systemLog.RawLog = string(raw)
+
+	systemLog.ParsedData["event_type"] = string(event.EventType)
+	if event.Error != "" {
+		systemLog.ParsedData["error"] = event.Error
+	}
+	if event.Duration != "" {
+		systemLog.ParsedData["duration"] = event.Duration
+	}
+	if event.Details != nil {
+		systemLog.ParsedData["details"] = event.Details
+	}
+	return systemLog
+}
+
+// selfEventLevel maps an audit event to a log level
+func selfEventLevel(event audit.AuditEvent) LogLevel {
+	switch {
+	case event.EventType == audit.EventTypeError || event.Error != "":
+		return LevelError
+	case event.StatusCode >= 500:
+		return LevelError
+	case event.StatusCode >= 400:
+		return LevelWarn
+	case event.EventType == audit.EventTypeShutdown || event.EventType == "system_shutdown":
+		return LevelWarn
+	case event.EventType == "source_stalled":
+		return LevelWarn
+	case event.EventType == audit.EventTypeSlowRequest || event.EventType == audit.EventTypeLargeResponse:
+		return LevelWarn
+	default:
+		return LevelInfo
+	}
+}
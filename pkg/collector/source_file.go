@@ -0,0 +1,122 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// KnownSources lists the source types the collector understands
+var KnownSources = []LogSource{
+	SourceSyslog,
+	SourceNginx,
+	SourceApache,
+	SourceDocker,
+	SourceKubernetes,
+	SourceCRI,
+	SourceCustom,
+}
+
+// IsKnownSource reports whether source is a built-in source type or one
+// added with RegisterParser
+func IsKnownSource(source LogSource) bool {
+	for _, known := range KnownSources {
+		if source == known {
+			return true
+		}
+	}
+	_, registered := registeredParsers()[source]
+	return registered
+}
+
+// Validate checks a source configuration for errors that would prevent it
+// from being collected correctly
+func (c LogSourceConfig) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("source name is required")
+	}
+	if !IsKnownSource(c.Source) {
+		return fmt.Errorf("source %s: unknown source type %q", c.Name, c.Source)
+	}
+	if c.Path == "" {
+		return fmt.Errorf("source %s: path is required", c.Name)
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("source %s: interval must be positive", c.Name)
+	}
+	if c.MaxInterval < 0 {
+		return fmt.Errorf("source %s: max_interval must not be negative", c.Name)
+	}
+	if c.Timezone != "" {
+		if _, err := time.LoadLocation(c.Timezone); err != nil {
+			return fmt.Errorf("source %s: invalid timezone %q: %w", c.Name, c.Timezone, err)
+		}
+	}
+	if c.LogFormat != "" {
+		if _, err := compileNginxLogFormat(c.Source, c.LogFormat); err != nil {
+			return fmt.Errorf("source %s: %w", c.Name, err)
+		}
+	}
+	if c.StallAfter < 0 {
+		return fmt.Errorf("source %s: stall_after must not be negative", c.Name)
+	}
+	if err := c.FieldMap.Validate(); err != nil {
+		return fmt.Errorf("source %s: field_map: %w", c.Name, err)
+	}
+	for _, layout := range c.TimestampLayouts {
+		if layout == "" {
+			return fmt.Errorf("source %s: empty timestamp layout", c.Name)
+		}
+	}
+	return nil
+}
+
+// LoadSourcesFile reads a JSON array of source configurations from path
+func LoadSourcesFile(path string) ([]LogSourceConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sources file %s: %w", path, err)
+	}
+
+	// Unknown fields are rejected so a misspelled setting is not ignored
+	var sources []LogSourceConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&sources); err != nil {
+		return nil, fmt.Errorf("failed to decode sources file %s: %w", path, err)
+	}
+	return sources, nil
+}
+
+// SaveSourcesFile atomically writes sources to path in the format read by
+// LoadSourcesFile
+func SaveSourcesFile(path string, sources []LogSourceConfig) error {
+	data, err := json.MarshalIndent(sources, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create sources file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write sources file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to fsync sources file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace sources file %s: %w", path, err)
+	}
+	return nil
+}
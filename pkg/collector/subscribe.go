@@ -0,0 +1,244 @@
+package collector
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultSubscriptionBuffer is the channel capacity of a subscription
+const DefaultSubscriptionBuffer = 256
+
+// levelRanks orders levels for Filter.MinLevel; unknown ranks lowest
+var levelRanks = map[LogLevel]int{
+	LevelUnknown: 0,
+	LevelDebug:   1,
+	LevelInfo:    2,
+	LevelWarn:    3,
+	LevelError:   4,
+	LevelFatal:   5,
+}
+
+// Filter selects log entries. Every set field must match; the zero Filter
+// matches everything.
+type Filter struct {
+	// Sources matches any of the source types
+	Sources []LogSource `json:"sources,omitempty"`
+	// MinLevel matches entries at this level or above; entries with an
+	// unknown level only match when MinLevel is empty
+	MinLevel LogLevel `json:"min_level,omitempty"`
+	// Tags matches entries carrying all of the tags
+	Tags []string `json:"tags,omitempty"`
+	// Contains matches messages containing the text, ignoring case
+	Contains string `json:"contains,omitempty"`
+	// Query matches entries selected by the query, see ParseQuery
+	Query *Query `json:"query,omitempty"`
+}
+
+// Match reports whether log is selected by the filter
+func (f Filter) Match(log *SystemLog) bool {
+	if len(f.Sources) > 0 {
+		found := false
+		for _, source := range f.Sources {
+			if log.Source == source {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.MinLevel != "" && levelRanks[log.Level] < levelRanks[f.MinLevel] {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !hasTag(log.Tags, tag) {
+			return false
+		}
+	}
+	if f.Contains != "" && !containsFold(log.Message, f.Contains) {
+		return false
+	}
+	return f.Query.Match(log)
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// SubscriptionStatus reports the state of one subscription
+type SubscriptionStatus struct {
+	ID        uint64 `json:"id"`
+	Filter    Filter `json:"filter"`
+	Delivered uint64 `json:"delivered"`
+	// Dropped counts entries lost because the subscriber did not keep up
+	Dropped uint64 `json:"dropped"`
+	Queued  int    `json:"queued"`
+}
+
+// subscription is a consumer of the log stream
+type subscription struct {
+	id     uint64
+	filter Filter
+	ch     chan SystemLog
+
+	// mu guards closed so deliver never sends on a closed channel
+	mu     sync.Mutex
+	closed bool
+
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// deliver hands a copy of log to the subscriber without blocking
+func (s *subscription) deliver(log *SystemLog) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.ch <- copySystemLog(log):
+		s.delivered.Add(1)
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+func (s *subscription) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
+
+// copySystemLog copies an entry so it outlives the pooled original
+func copySystemLog(log *SystemLog) SystemLog {
+	c := *log
+	c.Tags = append([]string(nil), log.Tags...)
+	if log.ParsedData != nil {
+		c.ParsedData = make(map[string]interface{}, len(log.ParsedData))
+		for k, v := range log.ParsedData {
+			c.ParsedData[k] = v
+		}
+	}
+	return c
+}
+
+// subscribers is the set of active subscriptions. The slice is replaced on
+// every change so processSystemLog can read it without locking.
+type subscribers struct {
+	mu     sync.Mutex
+	nextID uint64
+	list   atomic.Pointer[[]*subscription]
+}
+
+func (s *subscribers) load() []*subscription {
+	if list := s.list.Load(); list != nil {
+		return *list
+	}
+	return nil
+}
+
+// Subscribe returns a channel receiving a copy of every processed entry
+// matching filter, from all sources and ingestion endpoints. Delivery never
+// blocks the pipeline: entries are dropped (and counted) while the channel
+// is full. The cancel function removes the subscription and closes the
+// channel; Close cancels all subscriptions.
+func (lc *LogCollector) Subscribe(filter Filter) (<-chan SystemLog, func()) {
+	return lc.SubscribeBuffered(filter, DefaultSubscriptionBuffer)
+}
+
+// SubscribeBuffered is Subscribe with a custom channel capacity
+func (lc *LogCollector) SubscribeBuffered(filter Filter, size int) (<-chan SystemLog, func()) {
+	if size <= 0 {
+		size = DefaultSubscriptionBuffer
+	}
+
+	lc.subs.mu.Lock()
+	lc.subs.nextID++
+	sub := &subscription{
+		id:     lc.subs.nextID,
+		filter: filter,
+		ch:     make(chan SystemLog, size),
+	}
+	current := lc.subs.load()
+	list := make([]*subscription, 0, len(current)+1)
+	list = append(append(list, current...), sub)
+	lc.subs.list.Store(&list)
+	lc.subs.mu.Unlock()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() { lc.unsubscribe(sub) })
+	}
+}
+
+// unsubscribe removes a subscription and closes its channel
+func (lc *LogCollector) unsubscribe(sub *subscription) {
+	lc.subs.mu.Lock()
+	current := lc.subs.load()
+	list := make([]*subscription, 0, len(current))
+	for _, s := range current {
+		if s != sub {
+			list = append(list, s)
+		}
+	}
+	lc.subs.list.Store(&list)
+	lc.subs.mu.Unlock()
+
+	sub.close()
+}
+
+// CloseSubscriptions cancels every subscription, e.g. to end streaming
+// HTTP responses before the server shuts down. Later subscriptions work as
+// usual.
+func (lc *LogCollector) CloseSubscriptions() {
+	lc.closeSubscriptions()
+}
+
+// closeSubscriptions cancels every subscription
+func (lc *LogCollector) closeSubscriptions() {
+	lc.subs.mu.Lock()
+	current := lc.subs.load()
+	lc.subs.list.Store(nil)
+	lc.subs.mu.Unlock()
+
+	for _, sub := range current {
+		sub.close()
+	}
+}
+
+// publish delivers log to every matching subscription
+func (lc *LogCollector) publish(log *SystemLog) {
+	for _, sub := range lc.subs.load() {
+		if sub.filter.Match(log) {
+			sub.deliver(log)
+		}
+	}
+}
+
+// SubscriptionStatuses returns the counters of every active subscription
+func (lc *LogCollector) SubscriptionStatuses() []SubscriptionStatus {
+	current := lc.subs.load()
+	statuses := make([]SubscriptionStatus, 0, len(current))
+	for _, sub := range current {
+		statuses = append(statuses, SubscriptionStatus{
+			ID:        sub.id,
+			Filter:    sub.filter,
+			Delivered: sub.delivered.Load(),
+			Dropped:   sub.dropped.Load(),
+			Queued:    len(sub.ch),
+		})
+	}
+	return statuses
+}
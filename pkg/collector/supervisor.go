@@ -0,0 +1,303 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+const (
+	// minRestartBackoff is the delay before the first restart of a failed source
+	minRestartBackoff = 1 * time.Second
+	// maxRestartBackoff caps the exponential restart delay
+	maxRestartBackoff = 2 * time.Minute
+)
+
+// How a source notices new lines, see SourceStatus.Tailing
+const (
+	// TailingNotify reads the file when the kernel reports a change
+	TailingNotify = "notify"
+	// TailingPoll reads the file every poll interval
+	TailingPoll = "poll"
+)
+
+// SourceStatus represents the runtime status of a supervised log source
+type SourceStatus struct {
+	Name         string `json:"name"`
+	Running      bool   `json:"running"`
+	Offset       int64  `json:"offset"`
+	FileSize     int64  `json:"file_size"`
+	PollInterval string `json:"poll_interval,omitempty"`
+	// Tailing is TailingNotify or TailingPoll for file sources
+	Tailing      string     `json:"tailing,omitempty"`
+	LastActivity *time.Time `json:"last_activity,omitempty"`
+	Restarts     int        `json:"restarts"`
+	LastError    string     `json:"last_error,omitempty"`
+	LastErrorAt  *time.Time `json:"last_error_at,omitempty"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	// PausedUntil is set while a pausing quota holds the source
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+	// Stalled is set while a source with a stall_after has been silent for
+	// longer; StalledSince is its last activity
+	Stalled      bool       `json:"stalled,omitempty"`
+	StalledSince *time.Time `json:"stalled_since,omitempty"`
+	// Rotations counts how often the file was rotated, LastRotation is how
+	// the last time: RotationRenamed, RotationTruncated or RotationReplaced
+	Rotations    int        `json:"rotations,omitempty"`
+	LastRotation string     `json:"last_rotation,omitempty"`
+	RotatedAt    *time.Time `json:"rotated_at,omitempty"`
+	// Circuit opens when the source keeps failing, see BreakerPolicy
+	Circuit BreakerStatus `json:"circuit"`
+}
+
+// sourceState holds the mutable runtime state of a single source
+type sourceState struct {
+	mu          sync.Mutex
+	status      SourceStatus
+	fingerprint fingerprint // of the file the offset belongs to
+	breaker     *breaker
+}
+
+func (s *sourceState) getOffset() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status.Offset
+}
+
+func (s *sourceState) setOffset(offset int64) {
+	s.mu.Lock()
+	s.status.Offset = offset
+	s.mu.Unlock()
+}
+
+func (s *sourceState) getFingerprint() fingerprint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fingerprint
+}
+
+func (s *sourceState) setFingerprint(fp fingerprint) {
+	s.mu.Lock()
+	s.fingerprint = fp
+	s.mu.Unlock()
+}
+
+func (s *sourceState) setFileSize(size int64) {
+	s.mu.Lock()
+	s.status.FileSize = size
+	s.mu.Unlock()
+}
+
+func (s *sourceState) setPollInterval(interval time.Duration) {
+	s.mu.Lock()
+	s.status.PollInterval = interval.String()
+	s.mu.Unlock()
+}
+
+func (s *sourceState) setTailing(tailing string) {
+	s.mu.Lock()
+	s.status.Tailing = tailing
+	s.mu.Unlock()
+}
+
+func (s *sourceState) isPaused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status.PausedUntil != nil
+}
+
+func (s *sourceState) setPausedUntil(until time.Time) {
+	s.mu.Lock()
+	s.status.PausedUntil = nil
+	if !until.IsZero() {
+		s.status.PausedUntil = &until
+	}
+	s.mu.Unlock()
+}
+
+func (s *sourceState) recordRotation(rotation string) {
+	now := time.Now()
+	s.mu.Lock()
+	s.status.Rotations++
+	s.status.LastRotation = rotation
+	s.status.RotatedAt = &now
+	s.mu.Unlock()
+}
+
+func (s *sourceState) markActivity() {
+	now := time.Now()
+	s.mu.Lock()
+	s.status.LastActivity = &now
+	s.mu.Unlock()
+}
+
+func (s *sourceState) markStarted() {
+	now := time.Now()
+	s.mu.Lock()
+	s.status.Running = true
+	s.status.StartedAt = &now
+	s.mu.Unlock()
+}
+
+func (s *sourceState) markStopped() {
+	s.mu.Lock()
+	s.status.Running = false
+	s.mu.Unlock()
+}
+
+func (s *sourceState) recordFailure(err error) int {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Running = false
+	s.status.Restarts++
+	s.status.LastError = err.Error()
+	s.status.LastErrorAt = &now
+	return s.status.Restarts
+}
+
+// snapshot returns a copy of the status that shares no pointers with the state
+func (s *sourceState) snapshot() SourceStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.status
+	status.LastErrorAt = copyTime(status.LastErrorAt)
+	status.StartedAt = copyTime(status.StartedAt)
+	status.LastActivity = copyTime(status.LastActivity)
+	status.PausedUntil = copyTime(status.PausedUntil)
+	status.StalledSince = copyTime(status.StalledSince)
+	status.RotatedAt = copyTime(status.RotatedAt)
+	status.Circuit = s.breaker.snapshot()
+	return status
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+// sourceStateFor returns the runtime state of a source, creating it on first
+// use with the position restored from its checkpoint
+func (lc *LogCollector) sourceStateFor(config LogSourceConfig) *sourceState {
+	lc.statesMu.Lock()
+	defer lc.statesMu.Unlock()
+
+	state, exists := lc.states[config.Name]
+	if !exists {
+		offset, fp := lc.restorePosition(config)
+		state = &sourceState{
+			status:      SourceStatus{Name: config.Name, Offset: offset},
+			fingerprint: fp,
+			breaker:     lc.newBreaker(),
+		}
+		lc.states[config.Name] = state
+	}
+	return state
+}
+
+// superviseSource runs a source goroutine and restarts it with exponential
+// backoff whenever it exits with an error or panics, until ctx is cancelled.
+// A source that keeps failing opens its circuit: it is then only probed
+// after the breaker's cooldown, and the failed probes are not logged.
+func (lc *LogCollector) superviseSource(ctx context.Context, config LogSourceConfig, state *sourceState, run func(context.Context) error) {
+	backoff := minRestartBackoff
+
+	for {
+		startedAt := time.Now()
+		state.breaker.allow()
+		state.markStarted()
+		err := runSource(ctx, config, run)
+		if ctx.Err() != nil {
+			state.markStopped()
+			return
+		}
+		if err == nil {
+			err = errors.New("source goroutine exited unexpectedly")
+		}
+
+		// A source that ran stably for a while starts over with a short delay
+		if time.Since(startedAt) > maxRestartBackoff {
+			backoff = minRestartBackoff
+		}
+
+		restarts := state.recordFailure(err)
+		wait := backoff
+		switch transition := state.breaker.failure(err, time.Now()); transition {
+		case circuitUnchanged:
+			lc.auditLogger.LogEvent(audit.AuditEvent{
+				EventType: "log_source_restart",
+				Message:   fmt.Sprintf("Log source %s failed, restarting in %s", config.Name, backoff),
+				Error:     err.Error(),
+				Details: map[string]interface{}{
+					"source":   config.Name,
+					"path":     config.Path,
+					"restarts": restarts,
+					"backoff":  backoff.String(),
+				},
+			})
+		default:
+			lc.logCircuit("source", config.Name, state.breaker, transition, err)
+			wait = time.Until(state.breaker.probeAt())
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > maxRestartBackoff {
+			backoff = maxRestartBackoff
+		}
+	}
+}
+
+// sourceSucceeded records a working read of a source, closing its circuit
+// after a successful probe
+func (lc *LogCollector) sourceSucceeded(config LogSourceConfig, state *sourceState) {
+	if state.breaker.success() == circuitClosed {
+		lc.logCircuit("source", config.Name, state.breaker, circuitClosed, nil)
+	}
+}
+
+// runSource runs a source, converting a panic into an error
+func runSource(ctx context.Context, config LogSourceConfig, run func(context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic in source %s: %v", config.Name, r)
+		}
+	}()
+	return run(ctx)
+}
+
+// GetSourceStatuses returns the runtime status of every source that has been started
+func (lc *LogCollector) GetSourceStatuses() []SourceStatus {
+	sources := lc.registry.list()
+	lc.lifecycleMu.Lock()
+	for _, source := range lc.customSources {
+		sources = append(sources, source.Config())
+	}
+	lc.lifecycleMu.Unlock()
+
+	lc.statesMu.RLock()
+	defer lc.statesMu.RUnlock()
+
+	statuses := make([]SourceStatus, 0, len(lc.states))
+	for _, source := range sources {
+		if state, exists := lc.states[source.Name]; exists {
+			statuses = append(statuses, state.snapshot())
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,90 @@
+package collector
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// timestampFormats are the common timestamp layouts tried by parseTimestamp
+// when a source doesn't configure its own
+var timestampFormats = []string{
+	"Jan _2 15:04:05",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05Z",
+	"2006-01-02 15:04:05",
+	"02/Jan/2006:15:04:05 -0700",
+}
+
+// yearInferenceSlack is how far in the future a year-less timestamp may be
+// before it is assumed to belong to the previous year (clock drift between
+// the writer and the collector)
+const yearInferenceSlack = 24 * time.Hour
+
+// locationCache caches resolved source timezones by name
+var locationCache sync.Map
+
+// sourceLocation resolves the timezone of a source, falling back to the
+// local timezone when it is unset or invalid
+func (lc *LogCollector) sourceLocation(config LogSourceConfig) *time.Location {
+	if config.Timezone == "" {
+		return time.Local
+	}
+	if loc, ok := locationCache.Load(config.Timezone); ok {
+		return loc.(*time.Location)
+	}
+
+	loc, err := time.LoadLocation(config.Timezone)
+	if err != nil {
+		lc.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: audit.EventTypeError,
+			Message:   fmt.Sprintf("Invalid timezone %q for source %s, using local time", config.Timezone, config.Name),
+			Error:     err.Error(),
+		})
+		loc = time.Local
+	}
+	locationCache.Store(config.Timezone, loc)
+	return loc
+}
+
+// parseTimestamp parses a timestamp from a log line of the given source and
+// returns it normalized to UTC. Timestamps without a zone offset are read in
+// the source timezone; timestamps without a year get the year inferred
+// relative to now.
+func (lc *LogCollector) parseTimestamp(ts string, config LogSourceConfig, now time.Time) (time.Time, error) {
+	layouts := config.TimestampLayouts
+	if len(layouts) == 0 {
+		layouts = timestampFormats
+	}
+	loc := lc.sourceLocation(config)
+
+	for _, layout := range layouts {
+		t, err := time.ParseInLocation(layout, ts, loc)
+		if err != nil {
+			continue
+		}
+		if t.Year() == 0 {
+			t = inferYear(t, now.In(loc))
+		}
+		return t.UTC(), nil
+	}
+
+	return time.Time{}, fmt.Errorf("unable to parse timestamp: %s", ts)
+}
+
+// inferYear sets the year of a year-less timestamp (e.g. syslog "Dec 31
+// 23:59:59"). It picks the current year unless that would put the entry in
+// the future, which happens when December lines are read in January.
+func inferYear(t time.Time, now time.Time) time.Time {
+	withYear := func(year int) time.Time {
+		return time.Date(year, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	}
+
+	candidate := withYear(now.Year())
+	if candidate.After(now.Add(yearInferenceSlack)) {
+		candidate = withYear(now.Year() - 1)
+	}
+	return candidate
+}
@@ -0,0 +1,295 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultUsageRetention is the number of days of usage kept
+	DefaultUsageRetention = 30
+	// usageSaveInterval is how often changed usage is written to its file
+	usageSaveInterval = time.Minute
+)
+
+// UsageCounter counts entries and their bytes
+type UsageCounter struct {
+	Entries uint64 `json:"entries"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+func (c *UsageCounter) add(bytes int) {
+	c.Entries++
+	c.Bytes += uint64(bytes)
+}
+
+func (c *UsageCounter) merge(other UsageCounter) {
+	c.Entries += other.Entries
+	c.Bytes += other.Bytes
+}
+
+// Usage is what went through the pipeline for a source or tenant. Ingested
+// lines were admitted by the quotas, stored entries passed the processors
+// and were written to the outputs, forwarded lines were handed to the
+// aggregator (on agents, which store nothing themselves).
+type Usage struct {
+	Ingested  UsageCounter `json:"ingested"`
+	Stored    UsageCounter `json:"stored"`
+	Forwarded UsageCounter `json:"forwarded"`
+}
+
+func (u *Usage) merge(other *Usage) {
+	u.Ingested.merge(other.Ingested)
+	u.Stored.merge(other.Stored)
+	u.Forwarded.merge(other.Forwarded)
+}
+
+// UsageDay is the usage of one UTC day. Sources are counted by name and
+// tenants by tag; a line of a source with several tags counts for each of
+// them. Outputs count the entries they accepted with their encoded size.
+type UsageDay struct {
+	Date    string                   `json:"date"`
+	Total   Usage                    `json:"total"`
+	Sources map[string]*Usage        `json:"sources"`
+	Tenants map[string]*Usage        `json:"tenants"`
+	Outputs map[string]*UsageCounter `json:"outputs"`
+}
+
+func newUsageDay(date string) *UsageDay {
+	return &UsageDay{
+		Date:    date,
+		Sources: make(map[string]*Usage),
+		Tenants: make(map[string]*Usage),
+		Outputs: make(map[string]*UsageCounter),
+	}
+}
+
+// Merge adds the usage of other to d
+func (d *UsageDay) Merge(other *UsageDay) {
+	d.Total.merge(&other.Total)
+	for name, usage := range other.Sources {
+		usageOf(d.Sources, name).merge(usage)
+	}
+	for tag, usage := range other.Tenants {
+		usageOf(d.Tenants, tag).merge(usage)
+	}
+	for name, counter := range other.Outputs {
+		counterOf(d.Outputs, name).merge(*counter)
+	}
+}
+
+func usageOf(m map[string]*Usage, key string) *Usage {
+	usage, ok := m[key]
+	if !ok {
+		usage = &Usage{}
+		m[key] = usage
+	}
+	return usage
+}
+
+func counterOf(m map[string]*UsageCounter, key string) *UsageCounter {
+	counter, ok := m[key]
+	if !ok {
+		counter = &UsageCounter{}
+		m[key] = counter
+	}
+	return counter
+}
+
+// usageStage selects the counter of Usage a line is added to
+type usageStage func(u *Usage) *UsageCounter
+
+var (
+	usageIngested  usageStage = func(u *Usage) *UsageCounter { return &u.Ingested }
+	usageStored    usageStage = func(u *Usage) *UsageCounter { return &u.Stored }
+	usageForwarded usageStage = func(u *Usage) *UsageCounter { return &u.Forwarded }
+)
+
+// usageTracker keeps daily usage, optionally persisted to a file
+type usageTracker struct {
+	mu        sync.Mutex
+	days      []*UsageDay // oldest first
+	retention int
+	path      string
+	dirty     bool
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func newUsageTracker() *usageTracker {
+	return &usageTracker{retention: DefaultUsageRetention}
+}
+
+// today returns the usage of the current day. ut.mu must be held.
+func (ut *usageTracker) today() *UsageDay {
+	date := time.Now().UTC().Format("2006-01-02")
+	if n := len(ut.days); n > 0 && ut.days[n-1].Date == date {
+		return ut.days[n-1]
+	}
+	day := newUsageDay(date)
+	ut.days = append(ut.days, day)
+	if len(ut.days) > ut.retention {
+		ut.days = ut.days[len(ut.days)-ut.retention:]
+	}
+	return day
+}
+
+// add counts a line of config
+func (ut *usageTracker) add(stage usageStage, config LogSourceConfig, bytes int) {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	day := ut.today()
+	stage(&day.Total).add(bytes)
+	stage(usageOf(day.Sources, config.Name)).add(bytes)
+	for _, tag := range config.Tags {
+		stage(usageOf(day.Tenants, tag)).add(bytes)
+	}
+	ut.dirty = true
+}
+
+// addOutput counts an entry written to an output
+func (ut *usageTracker) addOutput(name string, bytes int) {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	counterOf(ut.today().Outputs, name).add(bytes)
+	ut.dirty = true
+}
+
+// ConfigureUsage keeps retention days of usage (DefaultUsageRetention when
+// 0) and, when path is set, persists it there so it survives restarts. It
+// must be called while the collector is stopped.
+func (lc *LogCollector) ConfigureUsage(path string, retention int) error {
+	lc.lifecycleMu.Lock()
+	defer lc.lifecycleMu.Unlock()
+
+	if lc.running.Load() {
+		return fmt.Errorf("cannot configure usage while log collector is running")
+	}
+	if retention < 0 {
+		return fmt.Errorf("usage retention must not be negative")
+	}
+	if retention == 0 {
+		retention = DefaultUsageRetention
+	}
+
+	ut := lc.usage
+	ut.close()
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	ut.retention = retention
+	ut.path = path
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return fmt.Errorf("failed to read usage file %s: %w", path, err)
+	default:
+		var days []*UsageDay
+		if err := json.Unmarshal(data, &days); err != nil {
+			return fmt.Errorf("failed to decode usage file %s: %w", path, err)
+		}
+		for _, day := range days {
+			loaded := newUsageDay(day.Date)
+			loaded.Merge(day)
+			ut.days = append(ut.days, loaded)
+		}
+		if len(ut.days) > retention {
+			ut.days = ut.days[len(ut.days)-retention:]
+		}
+	}
+
+	ut.stop, ut.done = make(chan struct{}), make(chan struct{})
+	go lc.saveUsageLoop(ut.stop, ut.done)
+	return nil
+}
+
+// Usage returns the usage of the last days (all kept days when 0), newest
+// first
+func (lc *LogCollector) Usage(days int) []UsageDay {
+	ut := lc.usage
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	if days <= 0 || days > len(ut.days) {
+		days = len(ut.days)
+	}
+	usage := make([]UsageDay, 0, days)
+	for i := len(ut.days) - 1; i >= len(ut.days)-days; i-- {
+		day := newUsageDay(ut.days[i].Date)
+		day.Merge(ut.days[i])
+		usage = append(usage, *day)
+	}
+	return usage
+}
+
+func (lc *LogCollector) saveUsageLoop(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(usageSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := lc.usage.save(); err != nil {
+				lc.auditLogger.LogError(err, "Failed to write usage file", nil)
+			}
+		}
+	}
+}
+
+// close stops the save loop. The final save is left to the caller.
+func (ut *usageTracker) close() {
+	ut.mu.Lock()
+	stop, done := ut.stop, ut.done
+	ut.stop, ut.done = nil, nil
+	ut.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// save writes the usage to its file when it changed
+func (ut *usageTracker) save() error {
+	ut.mu.Lock()
+	if ut.path == "" || !ut.dirty {
+		ut.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(ut.days, "", "  ")
+	ut.dirty = false
+	path := ut.path
+	ut.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create usage directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create usage file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write usage file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace usage file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileWatch signals changes to a watched file, so a source reads appended
+// lines as soon as they are written instead of on its next poll
+type fileWatch struct {
+	// changed receives a value after the file was written, created, renamed
+	// or removed; changes coalesce while the value is not received
+	changed chan struct{}
+	// failed is closed when the watch stopped working, see Err
+	failed chan struct{}
+
+	watcher  *fsnotify.Watcher
+	failOnce sync.Once
+	err      error
+}
+
+// watchFile watches path with the file notifications of the platform
+// (inotify, kqueue, ReadDirectoryChangesW). The directory holding it is
+// watched rather than the file, so a file that doesn't exist yet or is
+// replaced by a rotation keeps being seen; for a symlink the directory of
+// its target is watched too.
+func watchFile(path string) (*fileWatch, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{path}
+	if target, err := filepath.EvalSymlinks(path); err == nil && target != path {
+		paths = append(paths, target)
+	}
+	names := make(map[string]bool, len(paths))
+	dirs := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		dir := filepath.Dir(p)
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("watch of %s: %w", dir, err)
+		}
+		names[p] = true
+		dirs[dir] = true
+	}
+
+	w := &fileWatch{
+		changed: make(chan struct{}, 1),
+		failed:  make(chan struct{}),
+		watcher: watcher,
+	}
+	go w.run(names, dirs)
+	return w, nil
+}
+
+// run turns the events of the watched files into change notifications
+// until the watcher is closed
+func (w *fileWatch) run(names, dirs map[string]bool) {
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			name := filepath.Clean(event.Name)
+			switch {
+			case names[name]:
+				w.notify()
+			case dirs[name] && event.Has(fsnotify.Remove|fsnotify.Rename):
+				w.fail(errors.New("the watched directory was removed or renamed"))
+				return
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				// Events were lost; a read finds out what changed
+				w.notify()
+				continue
+			}
+			w.fail(err)
+			return
+		}
+	}
+}
+
+// notify records a change without blocking the reader of the notifications
+func (w *fileWatch) notify() {
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
+
+// fail stops the watch because of err
+func (w *fileWatch) fail(err error) {
+	w.failOnce.Do(func() {
+		w.err = err
+		close(w.failed)
+	})
+}
+
+// Err returns why the watch stopped working, once failed is closed
+func (w *fileWatch) Err() error {
+	<-w.failed
+	return w.err
+}
+
+// Close stops watching
+func (w *fileWatch) Close() error {
+	return w.watcher.Close()
+}
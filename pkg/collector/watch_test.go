@@ -0,0 +1,72 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchFile(t *testing.T) {
+	tests := []struct {
+		name string
+		// change changes the watched file at path, or a file next to it
+		change  func(t *testing.T, path string)
+		changed bool
+	}{
+		{"created", func(t *testing.T, path string) { appendFile(t, path, "one\n") }, true},
+		{"renamed", func(t *testing.T, path string) {
+			appendFile(t, path+".new", "one\n")
+			if err := os.Rename(path+".new", path); err != nil {
+				t.Fatal(err)
+			}
+		}, true},
+		{"other file", func(t *testing.T, path string) { appendFile(t, path+".1", "one\n") }, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.log")
+			watch, err := watchFile(path)
+			if err != nil {
+				t.Skipf("file notifications are not available: %v", err)
+			}
+			defer watch.Close()
+
+			test.change(t, path)
+			select {
+			case <-watch.changed:
+				if !test.changed {
+					t.Fatal("a change to another file was reported")
+				}
+			case <-time.After(200 * time.Millisecond):
+				if test.changed {
+					t.Fatal("the change was not reported")
+				}
+			}
+		})
+	}
+}
+
+func TestWatchFileDirectoryRemoved(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	watch, err := watchFile(filepath.Join(dir, "app.log"))
+	if err != nil {
+		t.Skipf("file notifications are not available: %v", err)
+	}
+	defer watch.Close()
+
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-watch.failed:
+		if watch.Err() == nil {
+			t.Fatal("the watch failed without an error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the watch kept running without its directory")
+	}
+}
@@ -0,0 +1,144 @@
+package collector
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultOutputBufferSize is the default number of bytes buffered per output
+	DefaultOutputBufferSize = 64 * 1024
+	// DefaultOutputFlushInterval is the default maximum time data stays buffered
+	DefaultOutputFlushInterval = 1 * time.Second
+)
+
+// BatchWriter buffers writes to an underlying writer and flushes them in
+// batches when the buffer fills up or the flush interval elapses
+type BatchWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	buf    []byte
+	size   int
+	closed bool
+	// failed is a background flush error not yet returned by Flush
+	failed error
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewBatchWriter creates a BatchWriter flushing at size bytes or every interval
+func NewBatchWriter(w io.Writer, size int, interval time.Duration) *BatchWriter {
+	if size <= 0 {
+		size = DefaultOutputBufferSize
+	}
+	if interval <= 0 {
+		interval = DefaultOutputFlushInterval
+	}
+
+	bw := &BatchWriter{
+		w:    w,
+		buf:  make([]byte, 0, size),
+		size: size,
+		done: make(chan struct{}),
+	}
+
+	bw.wg.Add(1)
+	go bw.flushLoop(interval)
+
+	return bw
+}
+
+// Write buffers p, flushing first if it would not fit in the buffer
+func (bw *BatchWriter) Write(p []byte) (int, error) {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+
+	if bw.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	if len(bw.buf)+len(p) > bw.size {
+		if err := bw.flushLocked(); err != nil {
+			return 0, err
+		}
+	}
+
+	// Entries larger than the whole buffer go straight through
+	if len(p) > bw.size {
+		return bw.w.Write(p)
+	}
+
+	bw.buf = append(bw.buf, p...)
+	return len(p), nil
+}
+
+// Flush writes all buffered data to the underlying writer. It also reports
+// a failed periodic flush since the last call, whose data is lost.
+func (bw *BatchWriter) Flush() error {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	err := bw.flushLocked()
+	if bw.failed != nil {
+		if err == nil {
+			err = bw.failed
+		}
+		bw.failed = nil
+	}
+	return err
+}
+
+// Close stops the flush loop and synchronously flushes remaining data
+func (bw *BatchWriter) Close() error {
+	bw.mu.Lock()
+	if bw.closed {
+		bw.mu.Unlock()
+		return nil
+	}
+	bw.closed = true
+	bw.mu.Unlock()
+
+	close(bw.done)
+	bw.wg.Wait()
+
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	return bw.flushLocked()
+}
+
+// Buffered returns the number of bytes waiting to be flushed
+func (bw *BatchWriter) Buffered() int {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	return len(bw.buf)
+}
+
+func (bw *BatchWriter) flushLocked() error {
+	if len(bw.buf) == 0 {
+		return nil
+	}
+	_, err := bw.w.Write(bw.buf)
+	bw.buf = bw.buf[:0]
+	return err
+}
+
+func (bw *BatchWriter) flushLoop(interval time.Duration) {
+	defer bw.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-bw.done:
+			return
+		case <-ticker.C:
+			bw.mu.Lock()
+			if err := bw.flushLocked(); err != nil && bw.failed == nil {
+				bw.failed = err
+			}
+			bw.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Package encryption seals data gonder keeps on disk (the agent spool and
+// checkpoint files) with AES-256-GCM, for hosts where collected logs must
+// not be readable at rest.
+//
+// Sealed data starts with a short header naming the format version and the
+// key, followed by a random nonce and the ciphertext. Each use passes its
+// own purpose string as additional data, so a sealed spool batch can't be
+// passed off as a checkpoint file or the other way around.
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// KeySize is the AES-256 key length in bytes
+const KeySize = 32
+
+// Purposes binding sealed data to its use
+const (
+	PurposeSpool      = "gonder/spool"
+	PurposeCheckpoint = "gonder/checkpoint"
+)
+
+// magic starts every sealed blob, followed by the version byte and the key ID
+var magic = []byte("GNDE")
+
+const (
+	version    = 1
+	keyIDSize  = 4
+	headerSize = 4 + 1 + keyIDSize
+)
+
+// keyCommandTimeout bounds a KMS key command
+const keyCommandTimeout = 30 * time.Second
+
+var (
+	// ErrWrongKey is returned when data was sealed with a different key
+	ErrWrongKey = errors.New("data was encrypted with a different key")
+	// ErrNoKey is returned when sealed data is read without a key
+	ErrNoKey = errors.New("data is encrypted but no encryption key is configured")
+)
+
+// KeySource says where the key comes from; exactly one field may be set
+type KeySource struct {
+	// Key is the base64 or hex encoded key
+	Key string
+	// File holds the encoded key, e.g. a mounted secret
+	File string
+	// Command prints the encoded key, e.g. a KMS or Vault CLI decrypting a
+	// wrapped data key. It runs without a shell.
+	Command []string
+}
+
+// Cipher seals and opens data with one key
+type Cipher struct {
+	aead  cipher.AEAD
+	keyID []byte
+}
+
+// Load reads the key from source and returns a cipher, or nil when no key
+// is configured
+func Load(source KeySource) (*Cipher, error) {
+	set := 0
+	for _, ok := range []bool{source.Key != "", source.File != "", len(source.Command) > 0} {
+		if ok {
+			set++
+		}
+	}
+	switch set {
+	case 0:
+		return nil, nil
+	case 1:
+	default:
+		return nil, fmt.Errorf("only one of the encryption key, key file and key command may be set")
+	}
+
+	encoded := source.Key
+	switch {
+	case source.File != "":
+		data, err := os.ReadFile(source.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		encoded = string(data)
+	case len(source.Command) > 0:
+		ctx, cancel := context.WithTimeout(context.Background(), keyCommandTimeout)
+		defer cancel()
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, source.Command[0], source.Command[1:]...)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("encryption key command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		encoded = string(out)
+	}
+
+	key, err := decodeKey(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, err
+	}
+	return New(key)
+}
+
+// decodeKey accepts a base64 (standard or URL) or hex encoded 32-byte key
+func decodeKey(encoded string) ([]byte, error) {
+	for _, decode := range []func(string) ([]byte, error){
+		base64.StdEncoding.DecodeString,
+		base64.RawStdEncoding.DecodeString,
+		base64.URLEncoding.DecodeString,
+		base64.RawURLEncoding.DecodeString,
+		hex.DecodeString,
+	} {
+		if key, err := decode(encoded); err == nil && len(key) == KeySize {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("encryption key must be %d bytes, base64 or hex encoded (e.g. openssl rand -base64 32)", KeySize)
+}
+
+// New returns a cipher for a 32-byte key
+func New(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &Cipher{aead: aead, keyID: sum[:keyIDSize]}, nil
+}
+
+// KeyID identifies the key without revealing it
+func (c *Cipher) KeyID() string {
+	return hex.EncodeToString(c.keyID)
+}
+
+// Seal encrypts data for the given purpose. A nil cipher returns data
+// unchanged.
+func (c *Cipher) Seal(data []byte, purpose string) ([]byte, error) {
+	if c == nil {
+		return data, nil
+	}
+	out := make([]byte, headerSize+c.aead.NonceSize(), headerSize+c.aead.NonceSize()+len(data)+c.aead.Overhead())
+	copy(out, magic)
+	out[len(magic)] = version
+	copy(out[len(magic)+1:], c.keyID)
+	nonce := out[headerSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(out, nonce, data, []byte(purpose)), nil
+}
+
+// Open decrypts data sealed for the given purpose. Data that isn't sealed
+// is returned unchanged, so files written before encryption was enabled
+// stay readable; they are encrypted when next rewritten. A nil cipher fails
+// on sealed data with ErrNoKey.
+func (c *Cipher) Open(data []byte, purpose string) ([]byte, error) {
+	if !IsSealed(data) {
+		return data, nil
+	}
+	if c == nil {
+		return nil, ErrNoKey
+	}
+	if data[len(magic)] != version {
+		return nil, fmt.Errorf("unsupported encryption format version %d", data[len(magic)])
+	}
+	if !bytes.Equal(data[len(magic)+1:headerSize], c.keyID) {
+		return nil, fmt.Errorf("%w (key %x, configured key %s)", ErrWrongKey, data[len(magic)+1:headerSize], c.KeyID())
+	}
+	if len(data) < headerSize+c.aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted data is truncated")
+	}
+	nonce := data[headerSize : headerSize+c.aead.NonceSize()]
+	plain, err := c.aead.Open(nil, nonce, data[headerSize+c.aead.NonceSize():], []byte(purpose))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s data: corrupted or tampered with", purpose)
+	}
+	return plain, nil
+}
+
+// IsSealed reports whether data starts with the sealed header
+func IsSealed(data []byte) bool {
+	return len(data) >= headerSize && bytes.HasPrefix(data, magic)
+}
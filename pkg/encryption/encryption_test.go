@@ -0,0 +1,137 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+func TestSealOpen(t *testing.T) {
+	sealer, err := New(testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := New(testKey(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := []byte(`{"offsets":{"/var/log/syslog":4096}}`)
+	sealed, err := sealer.Seal(plain, PurposeCheckpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name    string
+		cipher  *Cipher
+		data    []byte
+		purpose string
+		want    []byte
+		// err is matched with errors.Is; any marks an error without a
+		// sentinel
+		err error
+		any bool
+	}{
+		{name: "round trip", cipher: sealer, data: sealed, purpose: PurposeCheckpoint, want: plain},
+		{name: "wrong key", cipher: other, data: sealed, purpose: PurposeCheckpoint, err: ErrWrongKey},
+		{name: "no key", data: sealed, purpose: PurposeCheckpoint, err: ErrNoKey},
+		{name: "other purpose", cipher: sealer, data: sealed, purpose: PurposeSpool, any: true},
+		{name: "tampered", cipher: sealer, data: tampered, purpose: PurposeCheckpoint, any: true},
+		{name: "truncated", cipher: sealer, data: sealed[:headerSize+2], purpose: PurposeCheckpoint, any: true},
+		{name: "written before encryption", cipher: sealer, data: plain, purpose: PurposeCheckpoint, want: plain},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.cipher.Open(test.data, test.purpose)
+			switch {
+			case test.err != nil || test.any:
+				if err == nil || (test.err != nil && !errors.Is(err, test.err)) {
+					t.Fatalf("Open() error = %v, want %v", err, test.err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case !bytes.Equal(got, test.want):
+				t.Fatalf("Open() = %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	if bytes.Contains(sealed, plain) {
+		t.Fatal("the sealed data contains the plaintext")
+	}
+	again, err := sealer.Seal(plain, PurposeCheckpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(again, sealed) {
+		t.Fatal("sealing twice gave the same nonce")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	key := testKey(7)
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	want, err := New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		source KeySource
+		// keyID is the ID of the loaded key; empty expects no cipher
+		keyID   string
+		invalid bool
+	}{
+		{name: "no key", source: KeySource{}},
+		{name: "base64", source: KeySource{Key: base64.StdEncoding.EncodeToString(key)}, keyID: want.KeyID()},
+		{name: "hex", source: KeySource{Key: hex.EncodeToString(key)}, keyID: want.KeyID()},
+		{name: "file", source: KeySource{File: keyFile}, keyID: want.KeyID()},
+		{name: "missing file", source: KeySource{File: filepath.Join(dir, "missing")}, invalid: true},
+		{name: "short key", source: KeySource{Key: base64.StdEncoding.EncodeToString(key[:16])}, invalid: true},
+		{name: "key and file", source: KeySource{Key: hex.EncodeToString(key), File: keyFile}, invalid: true},
+		{name: "command", source: KeySource{Command: []string{"cat", keyFile}}, keyID: want.KeyID()},
+		{name: "failing command", source: KeySource{Command: []string{filepath.Join(dir, "missing")}}, invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.name == "command" && runtime.GOOS == "windows" {
+				t.Skip("needs cat")
+			}
+			cipher, err := Load(test.source)
+			if test.invalid {
+				if err == nil {
+					t.Fatal("an invalid key source was accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.keyID == "" {
+				if cipher != nil {
+					t.Fatal("a cipher was returned without a key")
+				}
+				return
+			}
+			if cipher == nil || cipher.KeyID() != test.keyID {
+				t.Fatalf("loaded key %v, want %s", cipher, test.keyID)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package fleet
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/forward"
+)
+
+// DefaultHeartbeatInterval is how often agents report to the aggregator
+const DefaultHeartbeatInterval = 15 * time.Second
+
+// FilteredForwarder drops lines rejected by the pushed filters before
+// handing them to the next forwarder
+type FilteredForwarder struct {
+	next    collector.LineForwarder
+	filters atomic.Pointer[[]compiledFilter]
+	dropped atomic.Uint64
+}
+
+// NewFilteredForwarder wraps next with an initially empty filter set
+func NewFilteredForwarder(next collector.LineForwarder) *FilteredForwarder {
+	return &FilteredForwarder{next: next}
+}
+
+// SetFilters replaces the active filters
+func (f *FilteredForwarder) SetFilters(filters []Filter) error {
+	compiled, err := compileFilters(filters)
+	if err != nil {
+		return err
+	}
+	f.filters.Store(&compiled)
+	return nil
+}
+
+// Dropped returns the number of lines rejected by filters
+func (f *FilteredForwarder) Dropped() uint64 {
+	return f.dropped.Load()
+}
+
+// Forward implements collector.LineForwarder
+func (f *FilteredForwarder) Forward(line collector.RawLine) error {
+	if filters := f.filters.Load(); filters != nil && !allows(*filters, line) {
+		f.dropped.Add(1)
+		return nil
+	}
+	return f.next.Forward(line)
+}
+
+// Sync implements collector.LineSyncer when the next forwarder does
+func (f *FilteredForwarder) Sync() error {
+	if syncer, ok := f.next.(collector.LineSyncer); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
+
+// AgentOptions configures the agent side of fleet management
+type AgentOptions struct {
+	Token    string
+	AgentID  string
+	Version  string
+	Interval time.Duration
+	Client   *http.Client
+}
+
+// Agent sends heartbeats to the aggregator and applies configuration pushed
+// back in the responses
+type Agent struct {
+	opts        AgentOptions
+	client      *http.Client
+	collector   *collector.LogCollector
+	forwarder   *forward.Forwarder
+	filtered    *FilteredForwarder
+	auditLogger *audit.Logger
+
+	mu            sync.Mutex
+	configVersion int64
+	configError   string
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewAgent creates the fleet agent. filtered must be the forwarder installed
+// on lc so pushed filters take effect.
+func NewAgent(opts AgentOptions, lc *collector.LogCollector, forwarder *forward.Forwarder, filtered *FilteredForwarder, auditLogger *audit.Logger) *Agent {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultHeartbeatInterval
+	}
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: forward.DefaultTimeout}
+	}
+	return &Agent{
+		opts:        opts,
+		client:      client,
+		collector:   lc,
+		forwarder:   forwarder,
+		filtered:    filtered,
+		auditLogger: auditLogger,
+		done:        make(chan struct{}),
+	}
+}
+
+// Start begins sending heartbeats
+func (a *Agent) Start() {
+	a.wg.Add(1)
+	go a.loop()
+}
+
+// Stop stops sending heartbeats
+func (a *Agent) Stop() {
+	close(a.done)
+	a.wg.Wait()
+}
+
+// ConfigVersion returns the version of the last applied configuration
+func (a *Agent) ConfigVersion() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.configVersion
+}
+
+func (a *Agent) loop() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.heartbeat(); err != nil {
+			a.auditLogger.LogError(err, "Agent heartbeat failed", map[string]interface{}{
+				"aggregator": a.forwarder.URL(),
+			})
+		}
+
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// heartbeat reports the agent state and applies a returned configuration
+func (a *Agent) heartbeat() error {
+	hostname, _ := os.Hostname()
+	a.mu.Lock()
+	hb := Heartbeat{
+		AgentID:       a.opts.AgentID,
+		Version:       a.opts.Version,
+		Hostname:      hostname,
+		ConfigVersion: a.configVersion,
+		ConfigError:   a.configError,
+		Running:       a.collector.IsRunning(),
+		Sources:       a.collector.GetSourceStatuses(),
+		Forward:       a.forwarder.Stats(),
+		SentAt:        time.Now().UTC(),
+	}
+	a.mu.Unlock()
+
+	body, err := json.Marshal(hb)
+	if err != nil {
+		return err
+	}
+	// Heartbeats follow the forwarder so they reach the aggregator that
+	// currently receives this agent's data
+	req, err := http.NewRequest(http.MethodPost, a.forwarder.URL()+HeartbeatPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(forward.HeaderProtocol, forward.ProtocolVersion)
+	req.Header.Set(forward.HeaderAgentID, a.opts.AgentID)
+	req.Header.Set(forward.HeaderAgentVersion, a.opts.Version)
+	if a.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.opts.Token)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("aggregator responded %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var response HeartbeatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("invalid heartbeat response: %w", err)
+	}
+	if response.Config != nil {
+		a.apply(*response.Config)
+	}
+	return nil
+}
+
+// apply installs a pushed configuration. The collector is restarted when the
+// sources change. A configuration that fails to apply is reported in the
+// next heartbeat and not retried until a new version is pushed.
+func (a *Agent) apply(cfg AgentConfig) {
+	err := a.applyConfig(cfg)
+
+	a.mu.Lock()
+	a.configVersion = cfg.Version
+	a.configError = ""
+	if err != nil {
+		a.configError = err.Error()
+	}
+	a.mu.Unlock()
+
+	event := audit.AuditEvent{
+		EventType: "agent_config_applied",
+		Message:   fmt.Sprintf("Applied fleet configuration version %d", cfg.Version),
+		Details: map[string]interface{}{
+			"version": cfg.Version,
+			"sources": len(cfg.Sources),
+			"filters": len(cfg.Filters),
+		},
+	}
+	if err != nil {
+		event.EventType = "agent_config_failed"
+		event.Message = fmt.Sprintf("Fleet configuration version %d could not be applied", cfg.Version)
+		event.Error = err.Error()
+	}
+	a.auditLogger.LogEvent(event)
+}
+
+func (a *Agent) applyConfig(cfg AgentConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if err := a.filtered.SetFilters(cfg.Filters); err != nil {
+		return err
+	}
+	if cfg.Sources == nil {
+		return nil
+	}
+
+	return a.collector.ReplaceSources(cfg.Sources)
+}
@@ -0,0 +1,158 @@
+// Package fleet manages gonder agents from the aggregator: agents report
+// their state with periodic heartbeats and receive their source and filter
+// configuration in the heartbeat response.
+package fleet
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/forward"
+)
+
+// HeartbeatPath is the aggregator endpoint receiving agent heartbeats
+const HeartbeatPath = "/api/agent/heartbeat"
+
+// Filter decides which lines an agent forwards. A filter applies to the
+// source named Source, or to every source when Source is empty. Lines are
+// forwarded only if they match Include and Query (when set) and do not
+// match Exclude. Agents don't parse lines, so a query only sees the source
+// type, the tags and the line as message and raw.
+type Filter struct {
+	Source  string `json:"source,omitempty"`
+	Include string `json:"include,omitempty"`
+	Exclude string `json:"exclude,omitempty"`
+	Query   string `json:"query,omitempty"`
+}
+
+// AgentConfig is the configuration pushed down to agents. A nil Sources
+// leaves the agent's local sources in place.
+type AgentConfig struct {
+	Version   int64                       `json:"version"`
+	Sources   []collector.LogSourceConfig `json:"sources,omitempty"`
+	Filters   []Filter                    `json:"filters,omitempty"`
+	UpdatedAt time.Time                   `json:"updated_at"`
+}
+
+// Validate checks the sources and compiles the filters
+func (c AgentConfig) Validate() error {
+	seen := make(map[string]bool, len(c.Sources))
+	for _, source := range c.Sources {
+		if err := source.Validate(); err != nil {
+			return err
+		}
+		if seen[source.Name] {
+			return fmt.Errorf("duplicate source name %q", source.Name)
+		}
+		seen[source.Name] = true
+	}
+	_, err := compileFilters(c.Filters)
+	return err
+}
+
+// Heartbeat is periodically sent by an agent to report its state
+type Heartbeat struct {
+	AgentID       string                   `json:"agent_id"`
+	Version       string                   `json:"version"`
+	Hostname      string                   `json:"hostname,omitempty"`
+	ConfigVersion int64                    `json:"config_version"`
+	ConfigError   string                   `json:"config_error,omitempty"`
+	Running       bool                     `json:"running"`
+	Sources       []collector.SourceStatus `json:"sources"`
+	Forward       forward.Stats            `json:"forward"`
+	SentAt        time.Time                `json:"sent_at"`
+}
+
+// HeartbeatResponse carries the agent's configuration when it differs from
+// the version the agent reported
+type HeartbeatResponse struct {
+	Config *AgentConfig `json:"config,omitempty"`
+}
+
+// compiledFilter is a Filter with its patterns compiled
+type compiledFilter struct {
+	source  string
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+	query   *collector.Query
+}
+
+func compileFilters(filters []Filter) ([]compiledFilter, error) {
+	compiled := make([]compiledFilter, 0, len(filters))
+	for i, f := range filters {
+		cf := compiledFilter{source: f.Source}
+		var err error
+		if f.Include != "" {
+			if cf.include, err = regexp.Compile(f.Include); err != nil {
+				return nil, fmt.Errorf("filter %d: invalid include pattern: %w", i, err)
+			}
+		}
+		if f.Exclude != "" {
+			if cf.exclude, err = regexp.Compile(f.Exclude); err != nil {
+				return nil, fmt.Errorf("filter %d: invalid exclude pattern: %w", i, err)
+			}
+		}
+		if f.Query != "" {
+			if cf.query, err = collector.ParseQuery(f.Query); err != nil {
+				return nil, fmt.Errorf("filter %d: %w", i, err)
+			}
+		}
+		if cf.include == nil && cf.exclude == nil && cf.query == nil {
+			return nil, fmt.Errorf("filter %d: include or exclude pattern or query is required", i)
+		}
+		compiled = append(compiled, cf)
+	}
+	return compiled, nil
+}
+
+// allows reports whether line passes every applicable filter
+func allows(filters []compiledFilter, line collector.RawLine) bool {
+	return rejecting(filters, line) < 0
+}
+
+// rejecting returns the index of the first filter dropping line, or -1
+func rejecting(filters []compiledFilter, line collector.RawLine) int {
+	var entry *collector.SystemLog
+	for i, f := range filters {
+		if f.source != "" && f.source != line.Source {
+			continue
+		}
+		if f.include != nil && !f.include.MatchString(line.Line) {
+			return i
+		}
+		if f.exclude != nil && f.exclude.MatchString(line.Line) {
+			return i
+		}
+		if f.query != nil {
+			if entry == nil {
+				entry = &collector.SystemLog{Source: line.Type, Message: line.Line, RawLog: line.Line, Tags: line.Tags}
+			}
+			if !f.query.Match(entry) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// FilterSet is a compiled list of filters, to check lines the way agents do
+type FilterSet struct {
+	filters []compiledFilter
+}
+
+// NewFilterSet compiles filters like AgentConfig.Validate
+func NewFilterSet(filters []Filter) (*FilterSet, error) {
+	compiled, err := compileFilters(filters)
+	if err != nil {
+		return nil, err
+	}
+	return &FilterSet{filters: compiled}, nil
+}
+
+// Rejecting returns the index of the first filter an agent would drop line
+// for, or -1 when the line is forwarded
+func (s *FilterSet) Rejecting(line collector.RawLine) int {
+	return rejecting(s.filters, line)
+}
@@ -0,0 +1,487 @@
+package fleet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// staleAfter marks an agent stale when no heartbeat arrived for this long
+	staleAfter = 1 * time.Minute
+	// offlineAfter marks an agent offline
+	offlineAfter = 5 * time.Minute
+)
+
+// Agent health values
+const (
+	HealthHealthy  = "healthy"
+	HealthDegraded = "degraded"
+	HealthStale    = "stale"
+	HealthOffline  = "offline"
+)
+
+// AgentInfo is the aggregator's view of an agent
+type AgentInfo struct {
+	ID               string     `json:"id"`
+	Version          string     `json:"version"`
+	RemoteAddr       string     `json:"remote_addr"`
+	Health           string     `json:"health"`
+	FirstSeen        time.Time  `json:"first_seen"`
+	LastSeen         time.Time  `json:"last_seen"`
+	LastHeartbeat    *Heartbeat `json:"last_heartbeat,omitempty"`
+	LastBatchAt      *time.Time `json:"last_batch_at,omitempty"`
+	BatchesReceived  uint64     `json:"batches_received"`
+	LinesReceived    uint64     `json:"lines_received"`
+	LagBytes         int64      `json:"lag_bytes"`
+	ConfigVersion    int64      `json:"config_version"`
+	DesiredVersion   int64      `json:"desired_config_version"`
+	ConfigError      string     `json:"config_error,omitempty"`
+	ConfigOverridden bool       `json:"config_overridden"`
+	// ClockSkew is how far the agent's clock is ahead of ours (negative
+	// when behind), measured with its last heartbeat; ClockSkewed is set
+	// when it exceeds the tolerance
+	ClockSkew   string `json:"clock_skew,omitempty"`
+	ClockSkewed bool   `json:"clock_skewed,omitempty"`
+
+	skew    time.Duration
+	hasSkew bool
+}
+
+// AnyVersion skips the version check of SetConfig and DeleteConfig
+const AnyVersion int64 = -1
+
+// ErrVersionMismatch is returned by SetConfig and DeleteConfig when the
+// configuration changed since the version the change was based on
+var ErrVersionMismatch = errors.New("configuration was changed in the meantime")
+
+// VersionMismatchError reports the version a configuration has instead of
+// the expected one; it matches ErrVersionMismatch
+type VersionMismatchError struct {
+	// Current is the stored version, 0 when there is no configuration
+	Current int64
+}
+
+func (e *VersionMismatchError) Error() string {
+	return fmt.Sprintf("%v: current version is %d", ErrVersionMismatch, e.Current)
+}
+
+func (e *VersionMismatchError) Is(target error) bool {
+	return target == ErrVersionMismatch
+}
+
+// persistedConfigs is the on-disk form of the pushed configuration
+type persistedConfigs struct {
+	Default *AgentConfig            `json:"default,omitempty"`
+	Agents  map[string]*AgentConfig `json:"agents,omitempty"`
+}
+
+// ConfigBackend loads and saves the pushed configuration
+type ConfigBackend interface {
+	Load() ([]byte, bool, error)
+	Save(data []byte) error
+}
+
+// fileBackend keeps the configuration in a local JSON file
+type fileBackend struct {
+	path string
+}
+
+func (b fileBackend) Load() ([]byte, bool, error) {
+	data, err := os.ReadFile(b.path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read fleet config file %s: %w", b.path, err)
+	}
+	return data, true, nil
+}
+
+// Save atomically writes the configuration file
+func (b fileBackend) Save(data []byte) error {
+	dir := filepath.Dir(b.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create fleet config directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(b.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create fleet config file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write fleet config file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to fsync fleet config file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), b.path); err != nil {
+		return fmt.Errorf("failed to replace fleet config file: %w", err)
+	}
+	return nil
+}
+
+// Registry tracks connected agents and the configuration assigned to them
+type Registry struct {
+	mu      sync.RWMutex
+	agents  map[string]*AgentInfo
+	configs persistedConfigs
+	backend ConfigBackend
+	version int64
+
+	skewTolerance time.Duration
+}
+
+// NewRegistry creates an agent registry. When path is not empty the pushed
+// configuration is loaded from and persisted to that file.
+func NewRegistry(path string) (*Registry, error) {
+	var backend ConfigBackend
+	if path != "" {
+		backend = fileBackend{path: path}
+	}
+	return NewRegistryWithBackend(backend)
+}
+
+// NewRegistryWithBackend creates an agent registry persisting the pushed
+// configuration through backend (nil keeps it in memory only)
+func NewRegistryWithBackend(backend ConfigBackend) (*Registry, error) {
+	r := &Registry{
+		agents:  make(map[string]*AgentInfo),
+		configs: persistedConfigs{Agents: make(map[string]*AgentConfig)},
+		backend: backend,
+	}
+	if err := r.Refresh(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Refresh reloads the pushed configuration from the backend. Aggregators
+// sharing a backend call it periodically to pick up each other's changes.
+func (r *Registry) Refresh() error {
+	if r.backend == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loadLocked()
+}
+
+func (r *Registry) loadLocked() error {
+	data, ok, err := r.backend.Load()
+	if err != nil || !ok {
+		return err
+	}
+
+	configs := persistedConfigs{}
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return fmt.Errorf("failed to decode fleet configuration: %w", err)
+	}
+	if configs.Agents == nil {
+		configs.Agents = make(map[string]*AgentConfig)
+	}
+	r.configs = configs
+
+	// Keep issuing increasing versions across restarts
+	if configs.Default != nil && configs.Default.Version > r.version {
+		r.version = configs.Default.Version
+	}
+	for _, cfg := range configs.Agents {
+		if cfg.Version > r.version {
+			r.version = cfg.Version
+		}
+	}
+	return nil
+}
+
+// agentLocked returns the entry of an agent, creating it on first contact
+func (r *Registry) agentLocked(id, version, remoteAddr string) *AgentInfo {
+	now := time.Now()
+	agent, exists := r.agents[id]
+	if !exists {
+		agent = &AgentInfo{ID: id, FirstSeen: now}
+		r.agents[id] = agent
+	}
+	if version != "" {
+		agent.Version = version
+	}
+	agent.RemoteAddr = remoteAddr
+	agent.LastSeen = now
+	return agent
+}
+
+// RecordBatch records a batch of lines received from an agent
+func (r *Registry) RecordBatch(id, version, remoteAddr string, lines int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	agent := r.agentLocked(id, version, remoteAddr)
+	now := agent.LastSeen
+	agent.LastBatchAt = &now
+	agent.BatchesReceived++
+	agent.LinesReceived += uint64(lines)
+}
+
+// RecordHeartbeat stores a heartbeat and returns the agent's configuration
+// when the agent does not run the current version yet
+func (r *Registry) RecordHeartbeat(hb Heartbeat, remoteAddr string) HeartbeatResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	agent := r.agentLocked(hb.AgentID, hb.Version, remoteAddr)
+	agent.LastHeartbeat = &hb
+	if !hb.SentAt.IsZero() {
+		// Includes the network delay, which tolerances are far above
+		agent.skew, agent.hasSkew = hb.SentAt.Sub(agent.LastSeen), true
+	}
+	agent.ConfigVersion = hb.ConfigVersion
+	agent.ConfigError = hb.ConfigError
+
+	desired := r.configForLocked(hb.AgentID)
+	if desired == nil || desired.Version == hb.ConfigVersion {
+		return HeartbeatResponse{}
+	}
+	cfg := *desired
+	return HeartbeatResponse{Config: &cfg}
+}
+
+// SetClockSkewTolerance sets the clock skew beyond which agents are
+// reported as skewed; 0 never reports them
+func (r *Registry) SetClockSkewTolerance(tolerance time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.skewTolerance = tolerance
+}
+
+// ClockSkew returns the last measured clock skew of an agent and whether it
+// exceeds the tolerance
+func (r *Registry) ClockSkew(id string) (time.Duration, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	agent, exists := r.agents[id]
+	if !exists || !agent.hasSkew {
+		return 0, false
+	}
+	return agent.skew, r.skewedLocked(agent.skew)
+}
+
+func (r *Registry) skewedLocked(skew time.Duration) bool {
+	if skew < 0 {
+		skew = -skew
+	}
+	return r.skewTolerance > 0 && skew > r.skewTolerance
+}
+
+// configForLocked returns the configuration assigned to an agent
+func (r *Registry) configForLocked(id string) *AgentConfig {
+	if cfg, ok := r.configs.Agents[id]; ok {
+		return cfg
+	}
+	return r.configs.Default
+}
+
+// List returns all known agents sorted by ID
+func (r *Registry) List() []AgentInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	agents := make([]AgentInfo, 0, len(r.agents))
+	for id := range r.agents {
+		agents = append(agents, r.infoLocked(id))
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].ID < agents[j].ID })
+	return agents
+}
+
+// Get returns a single agent
+func (r *Registry) Get(id string) (AgentInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, exists := r.agents[id]; !exists {
+		return AgentInfo{}, false
+	}
+	return r.infoLocked(id), true
+}
+
+// infoLocked returns a copy of an agent entry with derived fields filled in
+func (r *Registry) infoLocked(id string) AgentInfo {
+	info := *r.agents[id]
+
+	if desired := r.configForLocked(id); desired != nil {
+		info.DesiredVersion = desired.Version
+	}
+	_, info.ConfigOverridden = r.configs.Agents[id]
+
+	if info.hasSkew {
+		info.ClockSkew = info.skew.Round(time.Millisecond).String()
+		info.ClockSkewed = r.skewedLocked(info.skew)
+	}
+
+	info.LagBytes = 0
+	if hb := info.LastHeartbeat; hb != nil {
+		for _, source := range hb.Sources {
+			if pending := source.FileSize - source.Offset; pending > 0 {
+				info.LagBytes += pending
+			}
+		}
+	}
+
+	since := time.Since(info.LastSeen)
+	switch {
+	case since > offlineAfter:
+		info.Health = HealthOffline
+	case since > staleAfter:
+		info.Health = HealthStale
+	case info.ConfigError != "" || info.ClockSkewed || (info.LastHeartbeat != nil && (!info.LastHeartbeat.Running || info.LastHeartbeat.Forward.LastError != "")):
+		info.Health = HealthDegraded
+	default:
+		info.Health = HealthHealthy
+	}
+	return info
+}
+
+// Config returns the configuration assigned to an agent ("" for the default)
+func (r *Registry) Config(id string) (*AgentConfig, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cfg := r.configs.Default
+	if id != "" {
+		cfg = r.configs.Agents[id]
+	}
+	if cfg == nil {
+		return nil, false
+	}
+	c := *cfg
+	return &c, true
+}
+
+// SetConfig assigns a configuration to an agent, or to every agent without
+// its own configuration when id is empty. The stored configuration gets a
+// new version, which agents pick up with their next heartbeat. expected is
+// the version the change is based on, 0 when there should be no
+// configuration yet, or AnyVersion.
+func (r *Registry) SetConfig(id string, cfg AgentConfig, expected int64) (AgentConfig, error) {
+	if err := cfg.Validate(); err != nil {
+		return AgentConfig{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Start from the latest shared state so concurrent aggregators don't
+	// overwrite each other's assignments or reuse versions
+	if r.backend != nil {
+		if err := r.loadLocked(); err != nil {
+			return AgentConfig{}, err
+		}
+	}
+
+	if err := r.checkVersionLocked(id, expected); err != nil {
+		return AgentConfig{}, err
+	}
+
+	r.version++
+	cfg.Version = r.version
+	cfg.UpdatedAt = time.Now().UTC()
+
+	stored := cfg
+	previousDefault := r.configs.Default
+	previousAgent, hadAgent := r.configs.Agents[id]
+	if id == "" {
+		r.configs.Default = &stored
+	} else {
+		r.configs.Agents[id] = &stored
+	}
+
+	if err := r.saveLocked(); err != nil {
+		r.configs.Default = previousDefault
+		if hadAgent {
+			r.configs.Agents[id] = previousAgent
+		} else {
+			delete(r.configs.Agents, id)
+		}
+		return AgentConfig{}, err
+	}
+	return cfg, nil
+}
+
+// DeleteConfig removes an agent's own configuration so it falls back to the
+// default (or removes the default when id is empty). expected is the
+// version being removed, or AnyVersion.
+func (r *Registry) DeleteConfig(id string, expected int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.backend != nil {
+		if err := r.loadLocked(); err != nil {
+			return err
+		}
+	}
+	if err := r.checkVersionLocked(id, expected); err != nil {
+		return err
+	}
+
+	if id == "" {
+		r.configs.Default = nil
+	} else {
+		delete(r.configs.Agents, id)
+	}
+	return r.saveLocked()
+}
+
+// checkVersionLocked compares the version of a configuration with the
+// expected one
+func (r *Registry) checkVersionLocked(id string, expected int64) error {
+	if expected == AnyVersion {
+		return nil
+	}
+	cfg := r.configs.Default
+	if id != "" {
+		cfg = r.configs.Agents[id]
+	}
+	var current int64
+	if cfg != nil {
+		current = cfg.Version
+	}
+	if current != expected {
+		return &VersionMismatchError{Current: current}
+	}
+	return nil
+}
+
+// Forget removes an agent from the registry
+func (r *Registry) Forget(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, exists := r.agents[id]
+	delete(r.agents, id)
+	return exists
+}
+
+// saveLocked persists the configuration through the backend
+func (r *Registry) saveLocked() error {
+	if r.backend == nil {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(r.configs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return r.backend.Save(data)
+}
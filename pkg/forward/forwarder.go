@@ -0,0 +1,657 @@
+package forward
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/encryption"
+)
+
+// Default forwarding settings
+const (
+	DefaultBatchSize     = 500
+	DefaultBatchBytes    = 1024 * 1024
+	DefaultFlushInterval = 2 * time.Second
+	DefaultMaxRetries    = 5
+	DefaultRetryBackoff  = 500 * time.Millisecond
+	DefaultSpoolMaxBytes = 256 * 1024 * 1024
+	DefaultTimeout       = 10 * time.Second
+)
+
+// Config configures a Forwarder
+type Config struct {
+	// URL is the aggregator base URL, e.g. https://aggregator:8080. Several
+	// comma-separated URLs enable failover: the forwarder sticks to one
+	// aggregator and moves to the next when it becomes unreachable.
+	URL          string
+	Token        string
+	AgentID      string
+	AgentVersion string
+
+	BatchSize     int
+	BatchBytes    int
+	FlushInterval time.Duration
+	MaxRetries    int
+	RetryBackoff  time.Duration
+
+	// Compression lists the compressions to use, preferred first; the first
+	// one the aggregator accepts is used. Defaults to gzip.
+	Compression []string
+
+	SpoolDir      string
+	SpoolMaxBytes int64
+	// Cipher encrypts spooled batches when set
+	Cipher *encryption.Cipher
+
+	// Client overrides the HTTP client (e.g. for custom TLS settings)
+	Client *http.Client
+}
+
+// Stats reports forwarding counters
+type Stats struct {
+	AggregatorURL string `json:"aggregator_url"`
+	LinesQueued   uint64 `json:"lines_queued"`
+	LinesSent     uint64 `json:"lines_sent"`
+	BatchesSent   uint64 `json:"batches_sent"`
+	BatchesFailed uint64 `json:"batches_failed"`
+	// BatchesLost were neither sent nor spooled
+	BatchesLost uint64 `json:"batches_lost"`
+	// BytesSent is the size of the sent batches on the wire
+	BytesSent uint64 `json:"bytes_sent"`
+	// Encoding is the one negotiated with the current aggregator
+	Encoding      string     `json:"encoding,omitempty"`
+	PendingLines  int        `json:"pending_lines"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+	Spool         SpoolStats `json:"spool"`
+}
+
+// Forwarder batches raw lines and ships them to an aggregator. It implements
+// collector.LineForwarder.
+type Forwarder struct {
+	cfg         Config
+	urls        []string
+	current     atomic.Int32
+	client      *http.Client
+	auditLogger *audit.Logger
+	spool       *Spool
+
+	mu           sync.Mutex
+	pending      []collector.RawLine
+	pendingBytes int
+	closed       bool
+
+	batches chan queuedBatch
+	done    chan struct{}
+	wg      sync.WaitGroup
+
+	batchSeq      atomic.Uint64
+	linesQueued   atomic.Uint64
+	linesSent     atomic.Uint64
+	batchesSent   atomic.Uint64
+	batchesFailed atomic.Uint64
+	batchesLost   atomic.Uint64
+	bytesSent     atomic.Uint64
+
+	// Batches handed to the sender are numbered; acked is the last one
+	// sent or spooled
+	queued  uint64
+	ackMu   sync.Mutex
+	ackCond *sync.Cond
+	acked   uint64
+
+	statusMu      sync.Mutex
+	lastError     string
+	lastSuccessAt *time.Time
+	// encodings holds the encoding negotiated with each aggregator
+	encodings map[string]Encoding
+}
+
+// New creates a forwarder and starts its background send and replay loops
+func New(cfg Config, auditLogger *audit.Logger) (*Forwarder, error) {
+	var urls []string
+	for _, u := range strings.Split(cfg.URL, ",") {
+		if u = strings.TrimRight(strings.TrimSpace(u), "/"); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("aggregator URL is required")
+	}
+	if cfg.AgentID == "" {
+		return nil, fmt.Errorf("agent ID is required")
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.BatchBytes <= 0 {
+		cfg.BatchBytes = DefaultBatchBytes
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = DefaultFlushInterval
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = DefaultMaxRetries
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = DefaultRetryBackoff
+	}
+	if cfg.SpoolMaxBytes == 0 {
+		cfg.SpoolMaxBytes = DefaultSpoolMaxBytes
+	}
+	if len(cfg.Compression) == 0 {
+		cfg.Compression = []string{CompressionGzip}
+	}
+	if err := ValidateCompressions(cfg.Compression); err != nil {
+		return nil, err
+	}
+
+	client := cfg.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+
+	f := &Forwarder{
+		cfg:         cfg,
+		urls:        urls,
+		client:      client,
+		auditLogger: auditLogger,
+		batches:     make(chan queuedBatch, 4),
+		done:        make(chan struct{}),
+		encodings:   make(map[string]Encoding),
+	}
+	f.ackCond = sync.NewCond(&f.ackMu)
+
+	if cfg.SpoolDir != "" {
+		spool, err := OpenSpool(cfg.SpoolDir, cfg.SpoolMaxBytes, cfg.Cipher)
+		if err != nil {
+			return nil, err
+		}
+		f.spool = spool
+	}
+
+	f.wg.Add(2)
+	go f.sendLoop()
+	go f.flushLoop()
+
+	return f, nil
+}
+
+// URL returns the aggregator currently sent to
+func (f *Forwarder) URL() string {
+	return f.urls[int(f.current.Load())%len(f.urls)]
+}
+
+// failover moves to the next aggregator after url failed
+func (f *Forwarder) failover(url string, cause error) {
+	if len(f.urls) < 2 {
+		return
+	}
+	idx := f.current.Load()
+	if f.urls[int(idx)%len(f.urls)] != url || !f.current.CompareAndSwap(idx, (idx+1)%int32(len(f.urls))) {
+		// Another send already moved on
+		return
+	}
+	f.auditLogger.LogEvent(audit.AuditEvent{
+		EventType: "forward_failover",
+		Message:   fmt.Sprintf("Aggregator %s failed, switching to %s", url, f.URL()),
+		Error:     cause.Error(),
+		Details: map[string]interface{}{
+			"from": url,
+			"to":   f.URL(),
+		},
+	})
+}
+
+// Forward queues a line for the next batch
+func (f *Forwarder) Forward(line collector.RawLine) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return fmt.Errorf("forwarder is closed")
+	}
+
+	f.pending = append(f.pending, line)
+	f.pendingBytes += len(line.Line)
+	f.linesQueued.Add(1)
+
+	if len(f.pending) >= f.cfg.BatchSize || f.pendingBytes >= f.cfg.BatchBytes {
+		f.enqueueLocked()
+	}
+	return nil
+}
+
+// enqueueLocked hands the pending batch to the sender, spooling it to disk
+// when the sender is backed up
+func (f *Forwarder) enqueueLocked() {
+	if len(f.pending) == 0 {
+		return
+	}
+	batch := f.pending
+	f.pending = nil
+	f.pendingBytes = 0
+
+	select {
+	case f.batches <- queuedBatch{lines: batch, seq: f.queued + 1}:
+		f.queued++
+	default:
+		f.spoolBatch(batch, fmt.Errorf("send queue full"))
+	}
+}
+
+// queuedBatch is a batch waiting for the sender
+type queuedBatch struct {
+	lines []collector.RawLine
+	seq   uint64
+}
+
+// Sync queues the pending lines and waits until every line forwarded so
+// far was sent or spooled. It implements collector.LineSyncer and fails
+// once any batch has been lost.
+func (f *Forwarder) Sync() error {
+	f.mu.Lock()
+	if !f.closed {
+		f.enqueueLocked()
+	}
+	target := f.queued
+	f.mu.Unlock()
+
+	f.ackMu.Lock()
+	for f.acked < target {
+		f.ackCond.Wait()
+	}
+	f.ackMu.Unlock()
+
+	if lost := f.batchesLost.Load(); lost > 0 {
+		return fmt.Errorf("%d batches could be neither sent nor spooled", lost)
+	}
+	return nil
+}
+
+// Flush queues the pending lines as a batch immediately
+func (f *Forwarder) Flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.enqueueLocked()
+	}
+}
+
+// Close sends (or spools) everything still pending and stops the forwarder
+func (f *Forwarder) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	batch := f.pending
+	f.pending = nil
+	f.closed = true
+	f.mu.Unlock()
+
+	close(f.done)
+	if len(batch) > 0 {
+		f.mu.Lock()
+		f.queued++
+		seq := f.queued
+		f.mu.Unlock()
+		f.batches <- queuedBatch{lines: batch, seq: seq}
+	}
+	close(f.batches)
+	f.wg.Wait()
+	return nil
+}
+
+// Stats returns the current forwarding counters
+func (f *Forwarder) Stats() Stats {
+	f.mu.Lock()
+	pending := len(f.pending)
+	f.mu.Unlock()
+
+	f.statusMu.Lock()
+	stats := Stats{
+		AggregatorURL: f.URL(),
+		LastError:     f.lastError,
+		LastSuccessAt: f.lastSuccessAt,
+	}
+	if encoding, ok := f.encodings[stats.AggregatorURL]; ok {
+		stats.Encoding = encoding.String()
+	}
+	f.statusMu.Unlock()
+
+	stats.LinesQueued = f.linesQueued.Load()
+	stats.LinesSent = f.linesSent.Load()
+	stats.BatchesSent = f.batchesSent.Load()
+	stats.BatchesFailed = f.batchesFailed.Load()
+	stats.BatchesLost = f.batchesLost.Load()
+	stats.BytesSent = f.bytesSent.Load()
+	stats.PendingLines = pending
+	if f.spool != nil {
+		stats.Spool = f.spool.Stats()
+	}
+	return stats
+}
+
+// sendLoop sends queued batches until the forwarder is closed
+func (f *Forwarder) sendLoop() {
+	defer f.wg.Done()
+
+	for batch := range f.batches {
+		f.sendBatch(batch.lines)
+		f.ackMu.Lock()
+		f.acked = batch.seq
+		f.ackCond.Broadcast()
+		f.ackMu.Unlock()
+	}
+}
+
+// sendBatch sends a batch, spooling it when sending fails
+func (f *Forwarder) sendBatch(batch []collector.RawLine) {
+	p := &payload{lines: batch}
+	if err := f.sendWithRetry(p, f.nextBatchID()); err != nil {
+		f.batchesFailed.Add(1)
+		data, encodeErr := p.encode(Baseline)
+		if encodeErr != nil {
+			f.batchesLost.Add(1)
+			f.auditLogger.LogError(encodeErr, "Failed to encode forward batch", nil)
+			return
+		}
+		f.spoolEncoded(data, len(batch), err)
+		return
+	}
+	f.linesSent.Add(uint64(len(batch)))
+}
+
+// payload is a batch to send, encoded for each aggregator as negotiated.
+// Spooled batches are only available in the baseline encoding.
+type payload struct {
+	lines   []collector.RawLine
+	encoded map[Encoding][]byte
+}
+
+func (p *payload) encode(encoding Encoding) ([]byte, error) {
+	if data, ok := p.encoded[encoding]; ok {
+		return data, nil
+	}
+	data, err := Encode(p.lines, encoding)
+	if err != nil {
+		return nil, err
+	}
+	if p.encoded == nil {
+		p.encoded = make(map[Encoding][]byte, 1)
+	}
+	p.encoded[encoding] = data
+	return data, nil
+}
+
+// flushLoop flushes partial batches on an interval and replays the spool
+func (f *Forwarder) flushLoop() {
+	defer f.wg.Done()
+
+	ticker := time.NewTicker(f.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+			f.Flush()
+			f.replaySpool()
+		}
+	}
+}
+
+// replaySpool resends spooled batches oldest first, stopping at the first failure
+func (f *Forwarder) replaySpool() {
+	if f.spool == nil {
+		return
+	}
+	for {
+		select {
+		case <-f.done:
+			return
+		default:
+		}
+
+		name, data, err := f.spool.Oldest()
+		if err != nil && name != "" {
+			// Set aside as unreadable; go on with the next batch
+			f.auditLogger.LogError(err, "Unreadable spooled batch", map[string]interface{}{"batch": name})
+			continue
+		}
+		if err != nil || name == "" {
+			return
+		}
+		spooled := &payload{encoded: map[Encoding][]byte{Baseline: data}}
+		if err := f.send(spooled, strings.TrimSuffix(name, spoolSuffix)); err != nil {
+			f.recordError(err)
+			return
+		}
+		f.spool.Remove(name)
+	}
+}
+
+func (f *Forwarder) nextBatchID() string {
+	return fmt.Sprintf("%s-%d-%d", f.cfg.AgentID, time.Now().UnixNano(), f.batchSeq.Add(1))
+}
+
+// spoolBatch encodes and spools a batch that couldn't be sent
+func (f *Forwarder) spoolBatch(batch []collector.RawLine, cause error) {
+	data, err := EncodeBatch(batch)
+	if err != nil {
+		f.batchesLost.Add(1)
+		f.auditLogger.LogError(err, "Failed to encode forward batch", nil)
+		return
+	}
+	f.spoolEncoded(data, len(batch), cause)
+}
+
+// spoolEncoded writes an encoded batch to the spool, or drops it when no
+// spool is configured
+func (f *Forwarder) spoolEncoded(data []byte, lines int, cause error) {
+	details := map[string]interface{}{
+		"aggregator": f.URL(),
+		"lines":      lines,
+		"cause":      cause.Error(),
+	}
+	if f.spool == nil {
+		f.batchesLost.Add(1)
+		f.auditLogger.LogError(fmt.Errorf("no spool configured"), "Dropped forward batch", details)
+		return
+	}
+	if err := f.spool.Write(data); err != nil {
+		f.batchesLost.Add(1)
+		f.auditLogger.LogError(err, "Failed to spool forward batch", details)
+	}
+}
+
+// sendWithRetry sends a batch, retrying transient failures with exponential backoff
+func (f *Forwarder) sendWithRetry(p *payload, batchID string) error {
+	backoff := f.cfg.RetryBackoff
+	var err error
+	for attempt := 1; attempt <= f.cfg.MaxRetries; attempt++ {
+		err = f.send(p, batchID)
+		if err == nil {
+			return nil
+		}
+		f.recordError(err)
+		if !isRetryable(err) || attempt == f.cfg.MaxRetries {
+			break
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-f.done:
+			// Shutting down: don't wait, let the batch be spooled
+			return err
+		}
+		backoff *= 2
+	}
+	return err
+}
+
+// sendError is a non-2xx aggregator response
+type sendError struct {
+	status int
+	body   string
+}
+
+func (e *sendError) Error() string {
+	return fmt.Sprintf("aggregator responded %d: %s", e.status, e.body)
+}
+
+// isRetryable reports whether a send failure may succeed on retry
+func isRetryable(err error) bool {
+	if se, ok := err.(*sendError); ok {
+		// 415: the aggregator no longer takes the negotiated encoding; the
+		// next attempt renegotiates
+		return se.status >= 500 || se.status == http.StatusTooManyRequests || se.status == http.StatusUnsupportedMediaType
+	}
+	return true
+}
+
+// send POSTs one batch to the current aggregator, failing over to the next
+// one when it is unreachable or unhealthy
+func (f *Forwarder) send(p *payload, batchID string) error {
+	url := f.URL()
+	encoding := Baseline
+	if p.lines != nil {
+		encoding = f.encodingFor(url)
+	}
+	data, err := p.encode(encoding)
+	if err != nil {
+		return err
+	}
+
+	err = f.post(url, data, encoding, batchID)
+	se, ok := err.(*sendError)
+	switch {
+	case err == nil:
+	case ok && se.status == http.StatusUnsupportedMediaType:
+		f.forgetEncoding(url)
+	case !ok:
+		// Unreachable aggregators may come back as another version
+		f.forgetEncoding(url)
+		f.failover(url, err)
+	case isRetryable(err):
+		f.failover(url, err)
+	}
+	return err
+}
+
+// encodingFor returns the encoding negotiated with url, doing the
+// handshake first when there is none yet
+func (f *Forwarder) encodingFor(url string) Encoding {
+	f.statusMu.Lock()
+	encoding, ok := f.encodings[url]
+	f.statusMu.Unlock()
+	if ok {
+		return encoding
+	}
+
+	encoding, ok = f.handshake(url)
+	if !ok {
+		return Baseline
+	}
+	f.statusMu.Lock()
+	f.encodings[url] = encoding
+	f.statusMu.Unlock()
+	f.auditLogger.LogEvent(audit.AuditEvent{
+		EventType: "forward_negotiated",
+		Message:   fmt.Sprintf("Sending %s batches to %s", encoding, url),
+		Details: map[string]interface{}{
+			"aggregator": url,
+			"encoding":   encoding.String(),
+		},
+	})
+	return encoding
+}
+
+func (f *Forwarder) forgetEncoding(url string) {
+	f.statusMu.Lock()
+	delete(f.encodings, url)
+	f.statusMu.Unlock()
+}
+
+// handshake asks an aggregator which encodings it accepts. ok is false
+// when it couldn't answer, so the handshake is tried again later.
+func (f *Forwarder) handshake(url string) (encoding Encoding, ok bool) {
+	req, err := http.NewRequest(http.MethodOptions, url+IngestPath, nil)
+	if err != nil {
+		return Baseline, false
+	}
+	f.setHeaders(req)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return Baseline, false
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode >= 500:
+		return Baseline, false
+	case resp.StatusCode > 299:
+		// Aggregators without negotiation reject OPTIONS
+		return Baseline, true
+	}
+	return Negotiate(f.cfg.Compression, resp.Header.Get("Accept-Encoding"), resp.Header.Get(HeaderBatchFormats)), true
+}
+
+// setHeaders sets the headers identifying the agent
+func (f *Forwarder) setHeaders(req *http.Request) {
+	req.Header.Set(HeaderProtocol, ProtocolVersion)
+	req.Header.Set(HeaderAgentID, f.cfg.AgentID)
+	req.Header.Set(HeaderAgentVersion, f.cfg.AgentVersion)
+	if f.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.cfg.Token)
+	}
+}
+
+func (f *Forwarder) post(url string, data []byte, encoding Encoding, batchID string) error {
+	req, err := http.NewRequest(http.MethodPost, url+IngestPath, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", encoding.ContentType())
+	req.Header.Set("Content-Encoding", encoding.Compression)
+	req.Header.Set(HeaderBatchID, batchID)
+	f.setHeaders(req)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &sendError{status: resp.StatusCode, body: strings.TrimSpace(string(body))}
+	}
+	io.Copy(io.Discard, resp.Body)
+
+	now := time.Now()
+	f.statusMu.Lock()
+	f.lastError = ""
+	f.lastSuccessAt = &now
+	f.statusMu.Unlock()
+	f.batchesSent.Add(1)
+	f.bytesSent.Add(uint64(len(data)))
+	return nil
+}
+
+func (f *Forwarder) recordError(err error) {
+	f.statusMu.Lock()
+	f.lastError = err.Error()
+	f.statusMu.Unlock()
+}
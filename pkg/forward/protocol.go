@@ -0,0 +1,453 @@
+// Package forward implements the agent side of the agent → aggregator
+// forwarding protocol: raw lines are batched, compressed as NDJSON and
+// POSTed to the aggregator, with retries and a disk spool for outages.
+//
+// Every aggregator accepts gzip-compressed NDJSON. Before its first batch
+// to an aggregator the agent asks (OPTIONS on the ingest path) which
+// compressions and batch formats it accepts, and picks the best both sides
+// support; aggregators that don't answer get the gzip NDJSON baseline.
+package forward
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/gonderpb"
+)
+
+const (
+	// ProtocolVersion is sent with every batch so aggregators can reject
+	// agents speaking an incompatible protocol
+	ProtocolVersion = "1"
+
+	// IngestPath is the aggregator endpoint receiving agent batches
+	IngestPath = "/api/agent/ingest"
+
+	HeaderProtocol     = "X-Gonder-Protocol"
+	HeaderAgentID      = "X-Gonder-Agent-Id"
+	HeaderAgentVersion = "X-Gonder-Agent-Version"
+	HeaderBatchID      = "X-Gonder-Batch-Id"
+	// HeaderBatchFormats lists the batch formats an aggregator accepts; the
+	// accepted compressions are listed in Accept-Encoding
+	HeaderBatchFormats = "X-Gonder-Batch-Formats"
+
+	// maxLineSize bounds a single decoded line
+	maxLineSize = 1024 * 1024
+
+	// MaxBatchSize bounds a decoded batch, so a small gzip body can't
+	// expand without limit
+	MaxBatchSize = 256 * 1024 * 1024
+)
+
+// Batch formats, sent as the Content-Type
+const (
+	// FormatNDJSON is one JSON RawLine per line
+	FormatNDJSON = "ndjson"
+	// FormatDelta sends each source's fields once per batch and only the
+	// line, plus offset and read time when they aren't predictable
+	FormatDelta = "delta"
+	// FormatProtobuf is a gonder.v1.RawLineBatch message, see gonderpb
+	FormatProtobuf = "protobuf"
+
+	contentTypeNDJSON   = "application/x-ndjson"
+	contentTypeDelta    = "application/x-gonder-delta"
+	contentTypeProtobuf = "application/x-protobuf"
+)
+
+// Compressions, sent as the Content-Encoding
+const (
+	CompressionGzip     = "gzip"
+	CompressionZstd     = "zstd"
+	CompressionSnappy   = "snappy"
+	CompressionIdentity = "identity"
+)
+
+// Formats and Compressions are what this version accepts, best first
+var (
+	Formats      = []string{FormatProtobuf, FormatDelta, FormatNDJSON}
+	Compressions = []string{CompressionZstd, CompressionGzip, CompressionSnappy, CompressionIdentity}
+)
+
+// Encoding is how a batch is encoded on the wire
+type Encoding struct {
+	Format      string
+	Compression string
+}
+
+// Baseline is the encoding every aggregator accepts. Spooled batches are
+// always stored in it.
+var Baseline = Encoding{Format: FormatNDJSON, Compression: CompressionGzip}
+
+func (e Encoding) String() string {
+	return e.Format + "+" + e.Compression
+}
+
+// ContentType returns the Content-Type of the encoding's format
+func (e Encoding) ContentType() string {
+	switch e.Format {
+	case FormatProtobuf:
+		return contentTypeProtobuf
+	case FormatDelta:
+		return contentTypeDelta
+	}
+	return contentTypeNDJSON
+}
+
+var (
+	// ErrBatchTooLarge is returned by DecodeBatch for batches over MaxBatchSize
+	ErrBatchTooLarge = errors.New("batch exceeds the maximum decoded size")
+	// ErrUnsupportedEncoding is returned by DecodeBatch for a compression or
+	// format it doesn't know
+	ErrUnsupportedEncoding = errors.New("unsupported batch encoding")
+)
+
+// ValidateCompressions checks a compression preference list
+func ValidateCompressions(compressions []string) error {
+	for _, c := range compressions {
+		if !slices.Contains(Compressions, c) {
+			return fmt.Errorf("unknown forward compression %q (supported: %s)", c, strings.Join(Compressions, ", "))
+		}
+	}
+	return nil
+}
+
+// Negotiate picks the encoding for an aggregator from what it accepts:
+// the best of Formats it lists, and the first of the preferred
+// compressions it lists. Anything unmatched falls back to the baseline.
+func Negotiate(preferred []string, acceptEncoding, batchFormats string) Encoding {
+	encoding := Baseline
+	formats := splitList(batchFormats)
+	for _, format := range Formats {
+		if slices.Contains(formats, format) {
+			encoding.Format = format
+			break
+		}
+	}
+	accepted := splitList(acceptEncoding)
+	for _, c := range preferred {
+		if slices.Contains(accepted, c) {
+			encoding.Compression = c
+			break
+		}
+	}
+	return encoding
+}
+
+// splitList splits a comma-separated header value, dropping parameters
+// such as q-values
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item, _, _ = strings.Cut(item, ";")
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// EncodeBatch encodes lines in the baseline encoding, gzip-compressed NDJSON
+func EncodeBatch(lines []collector.RawLine) ([]byte, error) {
+	return Encode(lines, Baseline)
+}
+
+// Encode encodes lines in the given encoding
+func Encode(lines []collector.RawLine, encoding Encoding) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var zw *gzip.Writer
+	if encoding.Compression == CompressionGzip {
+		zw = gzip.NewWriter(&buf)
+		w = zw
+	}
+
+	var err error
+	switch encoding.Format {
+	case FormatProtobuf:
+		_, err = w.Write(gonderpb.AppendRawLineBatch(nil, lines))
+	case FormatDelta:
+		err = encodeDelta(w, lines)
+	default:
+		encoder := json.NewEncoder(w)
+		for _, line := range lines {
+			if err = encoder.Encode(line); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch encoding.Compression {
+	case CompressionGzip:
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	case CompressionZstd:
+		return zstdEncode(buf.Bytes()), nil
+	case CompressionSnappy:
+		return snappyEncode(buf.Bytes()), nil
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeBatch decodes a batch sent with the given Content-Type and
+// Content-Encoding. An empty Content-Type means NDJSON.
+func DecodeBatch(r io.Reader, contentType, contentEncoding string) ([]collector.RawLine, error) {
+	switch contentEncoding {
+	case "", CompressionIdentity:
+	case CompressionGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		defer zr.Close()
+		r = io.LimitReader(zr, MaxBatchSize+1)
+	case CompressionZstd:
+		body, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		data, err := zstdDecode(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	case CompressionSnappy:
+		body, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		data, err := snappyDecode(body, MaxBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	default:
+		return nil, fmt.Errorf("%w: content encoding %s", ErrUnsupportedEncoding, contentEncoding)
+	}
+
+	delta := false
+	if contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		switch {
+		case err == nil && mediaType == contentTypeProtobuf:
+			return decodeProtobuf(r)
+		case err == nil && mediaType == contentTypeDelta:
+			delta = true
+		case err == nil && mediaType == contentTypeNDJSON:
+		default:
+			return nil, fmt.Errorf("%w: content type %s", ErrUnsupportedEncoding, contentType)
+		}
+	}
+
+	counter := &countingReader{r: r}
+	scanner := bufio.NewScanner(counter)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	var lines []collector.RawLine
+	var err error
+	if delta {
+		lines, err = decodeDelta(scanner)
+	} else {
+		lines, err = decodeNDJSON(scanner)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if counter.n > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+	return lines, nil
+}
+
+func decodeProtobuf(r io.Reader) ([]collector.RawLine, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxBatchSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+	lines, err := gonderpb.DecodeRawLineBatch(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid protobuf batch: %w", err)
+	}
+	return lines, nil
+}
+
+func decodeNDJSON(scanner *bufio.Scanner) ([]collector.RawLine, error) {
+	var lines []collector.RawLine
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var line collector.RawLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("invalid batch record %d: %w", len(lines)+1, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// deltaRecord is one record of a delta batch. A record with Define starts
+// a new source context and selects it; other records are lines of the
+// selected context, or of Context when set.
+type deltaRecord struct {
+	Define  *deltaContext `json:"x,omitempty"`
+	Context *int          `json:"c,omitempty"`
+	// Offset is omitted when the line follows the previous line of its
+	// context in the file
+	Offset *int64 `json:"o,omitempty"`
+	// Elapsed is the read time in nanoseconds after the previous line's,
+	// or since the epoch for the first line
+	Elapsed int64  `json:"t,omitempty"`
+	Line    string `json:"l,omitempty"`
+}
+
+// deltaContext holds the fields shared by the lines of a source
+type deltaContext struct {
+	Source   string              `json:"source"`
+	Type     collector.LogSource `json:"type"`
+	Path     string              `json:"path"`
+	Tags     []string            `json:"tags,omitempty"`
+	Timezone string              `json:"timezone,omitempty"`
+
+	// next is the offset a following line would start at
+	next int64
+}
+
+// noLineYet is the next offset of a context before its first line; -1 is
+// kept by sources without stable positions
+const noLineYet = -2
+
+func (c *deltaContext) matches(line *collector.RawLine) bool {
+	return c.Source == line.Source && c.Type == line.Type && c.Path == line.Path &&
+		c.Timezone == line.Timezone && slices.Equal(c.Tags, line.Tags)
+}
+
+// advance records line as the last line of the context
+func (c *deltaContext) advance(offset int64, line string) {
+	c.next = -1
+	if offset >= 0 {
+		c.next = offset + int64(len(line)) + 1
+	}
+}
+
+func encodeDelta(w io.Writer, lines []collector.RawLine) error {
+	encoder := json.NewEncoder(w)
+	var contexts []*deltaContext
+	current := -1
+	var previous int64
+	for i := range lines {
+		line := &lines[i]
+		var record deltaRecord
+
+		index := -1
+		for j, c := range contexts {
+			if c.matches(line) {
+				index = j
+				break
+			}
+		}
+		switch {
+		case index < 0:
+			c := &deltaContext{Source: line.Source, Type: line.Type, Path: line.Path, Tags: line.Tags, Timezone: line.Timezone, next: noLineYet}
+			if err := encoder.Encode(deltaRecord{Define: c}); err != nil {
+				return err
+			}
+			contexts = append(contexts, c)
+			index = len(contexts) - 1
+		case index != current:
+			record.Context = &index
+		}
+		current = index
+
+		c := contexts[index]
+		if line.Offset != c.next {
+			offset := line.Offset
+			record.Offset = &offset
+		}
+		c.advance(line.Offset, line.Line)
+		readAt := line.ReadAt.UnixNano()
+		record.Elapsed = readAt - previous
+		previous = readAt
+		record.Line = line.Line
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeDelta(scanner *bufio.Scanner) ([]collector.RawLine, error) {
+	var lines []collector.RawLine
+	var contexts []*deltaContext
+	current := -1
+	var readAt int64
+	for n := 1; scanner.Scan(); n++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record deltaRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("invalid batch record %d: %w", n, err)
+		}
+		if record.Define != nil {
+			record.Define.next = noLineYet
+			contexts = append(contexts, record.Define)
+			current = len(contexts) - 1
+			continue
+		}
+		if record.Context != nil {
+			current = *record.Context
+		}
+		if current < 0 || current >= len(contexts) {
+			return nil, fmt.Errorf("invalid batch record %d: unknown source context", n)
+		}
+
+		c := contexts[current]
+		offset := c.next
+		if record.Offset != nil {
+			offset = *record.Offset
+		} else if offset == noLineYet {
+			return nil, fmt.Errorf("invalid batch record %d: missing offset", n)
+		}
+		c.advance(offset, record.Line)
+		readAt += record.Elapsed
+		lines = append(lines, collector.RawLine{
+			Source:   c.Source,
+			Type:     c.Type,
+			Path:     c.Path,
+			Tags:     c.Tags,
+			Timezone: c.Timezone,
+			Offset:   offset,
+			Line:     record.Line,
+			ReadAt:   time.Unix(0, readAt).UTC(),
+		})
+	}
+	return lines, scanner.Err()
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
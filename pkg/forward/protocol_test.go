@@ -0,0 +1,135 @@
+package forward
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+func testLines() []collector.RawLine {
+	readAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	return []collector.RawLine{
+		{Source: "nginx", Type: "nginx", Path: "/var/log/nginx/access.log", Tags: []string{"web"}, Offset: 0, Line: `127.0.0.1 - - "GET / HTTP/1.1" 200 512`, ReadAt: readAt},
+		{Source: "nginx", Type: "nginx", Path: "/var/log/nginx/access.log", Tags: []string{"web"}, Offset: 39, Line: `127.0.0.1 - - "GET /a HTTP/1.1" 404 0`, ReadAt: readAt.Add(time.Millisecond)},
+		{Source: "app", Type: "json", Path: "/var/log/app.log", Timezone: "Europe/Istanbul", Offset: 1000, Line: `{"level":"error"}`, ReadAt: readAt.Add(time.Second)},
+		{Source: "journal", Type: "syslog", Offset: -1, Line: "kernel: eth0 up", ReadAt: readAt.Add(2 * time.Second)},
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	lines := testLines()
+	for _, format := range Formats {
+		for _, compression := range Compressions {
+			encoding := Encoding{Format: format, Compression: compression}
+			t.Run(encoding.String(), func(t *testing.T) {
+				body, err := Encode(lines, encoding)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := DecodeBatch(bytes.NewReader(body), encoding.ContentType(), compression)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, lines) {
+					t.Fatalf("decoded %+v, want %+v", got, lines)
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeBatchErrors(t *testing.T) {
+	tests := []struct {
+		name            string
+		body            []byte
+		contentType     string
+		contentEncoding string
+		want            error
+	}{
+		{"unknown compression", nil, "", "br", ErrUnsupportedEncoding},
+		{"unknown format", nil, "text/csv", "", ErrUnsupportedEncoding},
+		{"corrupt zstd", []byte("not zstd"), "", CompressionZstd, nil},
+		{"corrupt snappy", []byte{0xff}, "", CompressionSnappy, errSnappyCorrupt},
+		{"oversized zstd", zstdEncode(make([]byte, MaxBatchSize+1)), "", CompressionZstd, ErrBatchTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeBatch(bytes.NewReader(test.body), test.contentType, test.contentEncoding)
+			if err == nil {
+				t.Fatal("DecodeBatch() succeeded")
+			}
+			if test.want != nil && !errors.Is(err, test.want) {
+				t.Fatalf("DecodeBatch() = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestSnappyRoundTrip(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":      {},
+		"short":      []byte("abc"),
+		"repetitive": bytes.Repeat([]byte("GET /index.html 200\n"), 5000),
+		"long match": append([]byte("x"), bytes.Repeat([]byte{'a'}, 100000)...),
+		"binary":     []byte(strings.Repeat("\x00\x01\xfe\xff", 3000) + "tail"),
+	}
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			encoded := snappyEncode(src)
+			decoded, err := snappyDecode(encoded, len(src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, src) {
+				t.Fatalf("round trip changed %d bytes into %d", len(src), len(decoded))
+			}
+			if len(src) > 1000 && len(encoded) >= len(src)/2 {
+				t.Errorf("encoded %d bytes into %d", len(src), len(encoded))
+			}
+		})
+	}
+}
+
+func TestSnappyDecodeLimit(t *testing.T) {
+	encoded := snappyEncode(bytes.Repeat([]byte("a"), 1000))
+	if _, err := snappyDecode(encoded, 999); err == nil {
+		t.Fatal("snappyDecode() ignored the limit")
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name           string
+		preferred      []string
+		acceptEncoding string
+		batchFormats   string
+		want           Encoding
+	}{
+		{"no answer", []string{CompressionZstd}, "", "", Baseline},
+		{"preferred accepted", []string{CompressionZstd, CompressionGzip}, "gzip, zstd", "ndjson", Encoding{FormatNDJSON, CompressionZstd}},
+		{"first accepted", []string{CompressionZstd, CompressionSnappy}, "gzip, snappy;q=0.5", "", Encoding{FormatNDJSON, CompressionSnappy}},
+		{"best format", []string{CompressionGzip}, "gzip", "ndjson, delta, protobuf", Encoding{FormatProtobuf, CompressionGzip}},
+		{"unknown format", []string{CompressionGzip}, "GZIP", "avro", Encoding{FormatNDJSON, CompressionGzip}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Negotiate(test.preferred, test.acceptEncoding, test.batchFormats); got != test.want {
+				t.Fatalf("Negotiate() = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateCompressions(t *testing.T) {
+	if err := ValidateCompressions([]string{CompressionZstd, CompressionGzip, CompressionSnappy, CompressionIdentity}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateCompressions([]string{"brotli"}); err == nil {
+		t.Fatal("ValidateCompressions() accepted brotli")
+	}
+}
@@ -0,0 +1,160 @@
+package forward
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// This file implements the snappy block format
+// (https://github.com/google/snappy/blob/main/format_description.txt),
+// which is what "Content-Encoding: snappy" means for HTTP bodies, e.g. in
+// Prometheus remote write. Snappy compresses less than gzip but costs a
+// fraction of the CPU, which matters on small edge machines.
+
+var errSnappyCorrupt = errors.New("invalid snappy body")
+
+const (
+	snappyTableBits = 14
+	// snappyMaxOffset keeps every copy within the two-byte offset form
+	snappyMaxOffset = 1<<16 - 1
+)
+
+// snappyEncode compresses src into one snappy block
+func snappyEncode(src []byte) []byte {
+	dst := make([]byte, 0, len(src)/2+16)
+	dst = binary.AppendUvarint(dst, uint64(len(src)))
+
+	// table holds the last position+1 of each hashed 4-byte sequence
+	var table [1 << snappyTableBits]int32
+	literal := 0
+	for i := 0; i+4 <= len(src); {
+		v := binary.LittleEndian.Uint32(src[i:])
+		h := (v * 0x1e35a7bd) >> (32 - snappyTableBits)
+		candidate := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if candidate < 0 || i-candidate > snappyMaxOffset || binary.LittleEndian.Uint32(src[candidate:]) != v {
+			i++
+			continue
+		}
+
+		length := 4
+		for i+length < len(src) && src[candidate+length] == src[i+length] {
+			length++
+		}
+		dst = snappyLiteral(dst, src[literal:i])
+		dst = snappyCopy(dst, i-candidate, length)
+		i += length
+		literal = i
+	}
+	return snappyLiteral(dst, src[literal:])
+}
+
+// snappyLiteral appends a literal element
+func snappyLiteral(dst, literal []byte) []byte {
+	if len(literal) == 0 {
+		return dst
+	}
+	n := uint32(len(literal) - 1)
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2)
+	case n < 1<<8:
+		dst = append(dst, 60<<2, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, literal...)
+}
+
+// snappyCopy appends copy elements repeating length bytes from offset back
+func snappyCopy(dst []byte, offset, length int) []byte {
+	// A copy element holds at most 64 bytes; keep the remainder at least 4
+	for length >= 68 {
+		dst = append(dst, 2|63<<2, byte(offset), byte(offset>>8))
+		length -= 64
+	}
+	if length > 64 {
+		dst = append(dst, 2|59<<2, byte(offset), byte(offset>>8))
+		length -= 60
+	}
+	if length >= 12 || offset >= 2048 {
+		return append(dst, 2|byte(length-1)<<2, byte(offset), byte(offset>>8))
+	}
+	return append(dst, 1|byte(length-4)<<2|byte(offset>>8)<<5, byte(offset))
+}
+
+// snappyDecode decompresses one snappy block of at most limit bytes
+func snappyDecode(src []byte, limit int) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errSnappyCorrupt
+	}
+	if size > uint64(limit) {
+		return nil, ErrBatchTooLarge
+	}
+	dst := make([]byte, 0, size)
+
+	for s := n; s < len(src); {
+		tag := src[s]
+		var offset, length int
+		switch tag & 3 {
+		case 0:
+			x := int(tag >> 2)
+			s++
+			if x >= 60 {
+				extra := x - 59
+				if s+extra > len(src) {
+					return nil, errSnappyCorrupt
+				}
+				x = 0
+				for i := 0; i < extra; i++ {
+					x |= int(src[s+i]) << (8 * i)
+				}
+				s += extra
+			}
+			length = x + 1
+			if length <= 0 || length > len(src)-s || length > int(size)-len(dst) {
+				return nil, errSnappyCorrupt
+			}
+			dst = append(dst, src[s:s+length]...)
+			s += length
+			continue
+		case 1:
+			if s+2 > len(src) {
+				return nil, errSnappyCorrupt
+			}
+			length = 4 + int(tag>>2&7)
+			offset = int(tag&0xe0)<<3 | int(src[s+1])
+			s += 2
+		case 2:
+			if s+3 > len(src) {
+				return nil, errSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[s+1:]))
+			s += 3
+		case 3:
+			if s+5 > len(src) {
+				return nil, errSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[s+1:]))
+			s += 5
+		}
+		if offset <= 0 || offset > len(dst) || length > int(size)-len(dst) {
+			return nil, errSnappyCorrupt
+		}
+		// Copies may overlap their own output, so go byte by byte
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if len(dst) != int(size) {
+		return nil, errSnappyCorrupt
+	}
+	return dst, nil
+}
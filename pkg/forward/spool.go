@@ -0,0 +1,191 @@
+package forward
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/encryption"
+)
+
+const spoolSuffix = ".batch"
+
+// unreadableSuffix is appended to batches that can't be decrypted, so they
+// stop blocking the replay but can be recovered with the right key
+const unreadableSuffix = ".unreadable"
+
+// Spool stores encoded batches on disk while the aggregator is unreachable.
+// Batches are replayed oldest first; when the spool exceeds its size limit
+// the oldest batches are dropped. With a cipher, batches are encrypted on
+// disk.
+type Spool struct {
+	mu         sync.Mutex
+	dir        string
+	maxBytes   int64
+	cipher     *encryption.Cipher
+	size       int64
+	count      int
+	seq        atomic.Uint64
+	dropped    atomic.Uint64
+	unreadable atomic.Uint64
+}
+
+// OpenSpool opens (creating if needed) a spool directory. cipher may be nil
+// to store batches unencrypted.
+func OpenSpool(dir string, maxBytes int64, cipher *encryption.Cipher) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory %s: %w", dir, err)
+	}
+
+	s := &Spool{dir: dir, maxBytes: maxBytes, cipher: cipher}
+	names, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			s.size += info.Size()
+			s.count++
+		}
+	}
+	return s, nil
+}
+
+// list returns spooled batch file names, oldest first
+func (s *Spool) list() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), spoolSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Write durably stores an encoded batch
+func (s *Spool) Write(data []byte) error {
+	data, err := s.cipher.Seal(data, encryption.PurposeSpool)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt spool file: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq.Add(1)%1000000, spoolSuffix)
+	tmp := filepath.Join(s.dir, "."+name+".tmp")
+	if err := os.WriteFile(tmp, data, 0640); err != nil {
+		return fmt.Errorf("failed to write spool file: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to commit spool file: %w", err)
+	}
+	s.size += int64(len(data))
+	s.count++
+
+	return s.enforceLimitLocked()
+}
+
+// enforceLimitLocked drops the oldest batches until the spool fits its limit
+func (s *Spool) enforceLimitLocked() error {
+	if s.maxBytes <= 0 || s.size <= s.maxBytes {
+		return nil
+	}
+	names, err := s.list()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if s.size <= s.maxBytes {
+			break
+		}
+		s.removeLocked(name)
+		s.dropped.Add(1)
+	}
+	return nil
+}
+
+// Oldest returns the oldest spooled batch, or an empty name if none exists.
+// A batch that can't be decrypted is renamed with an ".unreadable" suffix
+// and reported as an error together with its name, so replay can go on.
+func (s *Spool) Oldest() (string, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := s.list()
+	if err != nil || len(names) == 0 {
+		return "", nil, err
+	}
+	path := filepath.Join(s.dir, names[0])
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read spool file: %w", err)
+	}
+	plain, err := s.cipher.Open(data, encryption.PurposeSpool)
+	if err != nil {
+		err = fmt.Errorf("failed to decrypt spool file %s: %w", names[0], err)
+		if os.Rename(path, path+unreadableSuffix) != nil {
+			return "", nil, err
+		}
+		s.size -= int64(len(data))
+		s.count--
+		s.unreadable.Add(1)
+		return names[0], nil, err
+	}
+	return names[0], plain, nil
+}
+
+// Remove deletes a replayed batch
+func (s *Spool) Remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(name)
+}
+
+func (s *Spool) removeLocked(name string) {
+	path := filepath.Join(s.dir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if os.Remove(path) == nil {
+		s.size -= info.Size()
+		s.count--
+	}
+}
+
+// SpoolStats describes the spool backlog
+type SpoolStats struct {
+	Dir     string `json:"dir"`
+	Batches int    `json:"batches"`
+	Bytes   int64  `json:"bytes"`
+	Dropped uint64 `json:"dropped_batches"`
+	// Unreadable counts batches set aside because they couldn't be decrypted
+	Unreadable uint64 `json:"unreadable_batches,omitempty"`
+	Encrypted  bool   `json:"encrypted"`
+}
+
+// Stats returns the current spool backlog
+func (s *Spool) Stats() SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SpoolStats{
+		Dir:        s.dir,
+		Batches:    s.count,
+		Bytes:      s.size,
+		Dropped:    s.dropped.Load(),
+		Unreadable: s.unreadable.Load(),
+		Encrypted:  s.cipher != nil,
+	}
+}
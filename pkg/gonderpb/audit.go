@@ -0,0 +1,85 @@
+package gonderpb
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// AppendAuditEvent appends event as an AuditEvent message
+func AppendAuditEvent(b []byte, event *audit.AuditEvent) []byte {
+	b = appendTimestamp(b, 1, event.Timestamp)
+	b = appendString(b, 2, string(event.EventType))
+	b = appendString(b, 3, event.UserID)
+	b = appendString(b, 4, event.SessionID)
+	b = appendString(b, 5, event.RequestID)
+	b = appendString(b, 6, event.Method)
+	b = appendString(b, 7, event.Path)
+	b = appendInt32(b, 8, event.StatusCode)
+	b = appendString(b, 9, event.Duration)
+	b = appendString(b, 10, event.Message)
+	if event.Details != nil {
+		if data, err := json.Marshal(event.Details); err == nil && string(data) != "null" {
+			b = appendBytes(b, 11, data)
+		}
+	}
+	b = appendString(b, 12, event.Error)
+	b = appendString(b, 13, event.RemoteAddr)
+	b = appendString(b, 14, event.UserAgent)
+	return b
+}
+
+// DecodeAuditEvent decodes an AuditEvent message. Details are decoded from
+// their JSON, as a map for objects.
+func DecodeAuditEvent(data []byte) (*audit.AuditEvent, error) {
+	event := &audit.AuditEvent{}
+	err := forEachField(data, func(field, wireType int, payload []byte, n uint64) error {
+		if wireType == wireVarint {
+			if field == 8 {
+				event.StatusCode = int(int32(n))
+			}
+			return nil
+		}
+		if wireType != wireBytes {
+			return nil
+		}
+
+		var err error
+		switch field {
+		case 1:
+			event.Timestamp, err = decodeTimestamp(payload)
+		case 2:
+			event.EventType = audit.EventType(payload)
+		case 3:
+			event.UserID = string(payload)
+		case 4:
+			event.SessionID = string(payload)
+		case 5:
+			event.RequestID = string(payload)
+		case 6:
+			event.Method = string(payload)
+		case 7:
+			event.Path = string(payload)
+		case 9:
+			event.Duration = string(payload)
+		case 10:
+			event.Message = string(payload)
+		case 11:
+			if err = json.Unmarshal(payload, &event.Details); err != nil {
+				err = fmt.Errorf("invalid details_json: %w", err)
+			}
+		case 12:
+			event.Error = string(payload)
+		case 13:
+			event.RemoteAddr = string(payload)
+		case 14:
+			event.UserAgent = string(payload)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
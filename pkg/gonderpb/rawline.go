@@ -0,0 +1,79 @@
+package gonderpb
+
+import (
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// AppendRawLineBatch appends lines as a RawLineBatch message
+func AppendRawLineBatch(b []byte, lines []collector.RawLine) []byte {
+	var line []byte
+	for i := range lines {
+		line = appendRawLine(line[:0], &lines[i])
+		b = appendBytes(b, 1, line)
+	}
+	return b
+}
+
+func appendRawLine(b []byte, line *collector.RawLine) []byte {
+	b = appendString(b, 1, line.Source)
+	b = appendString(b, 2, string(line.Type))
+	b = appendString(b, 3, line.Path)
+	b = appendStrings(b, 4, line.Tags)
+	b = appendString(b, 5, line.Timezone)
+	b = appendSint64(b, 6, line.Offset)
+	b = appendString(b, 7, line.Line)
+	b = appendTimestamp(b, 8, line.ReadAt)
+	return b
+}
+
+// DecodeRawLineBatch decodes a RawLineBatch message
+func DecodeRawLineBatch(data []byte) ([]collector.RawLine, error) {
+	var lines []collector.RawLine
+	err := forEachField(data, func(field, wireType int, payload []byte, n uint64) error {
+		if field != 1 || wireType != wireBytes {
+			return nil
+		}
+		line, err := decodeRawLine(payload)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line)
+		return nil
+	})
+	return lines, err
+}
+
+func decodeRawLine(data []byte) (collector.RawLine, error) {
+	var line collector.RawLine
+	err := forEachField(data, func(field, wireType int, payload []byte, n uint64) error {
+		if wireType == wireVarint {
+			if field == 6 {
+				line.Offset = decodeSint64(n)
+			}
+			return nil
+		}
+		if wireType != wireBytes {
+			return nil
+		}
+
+		var err error
+		switch field {
+		case 1:
+			line.Source = string(payload)
+		case 2:
+			line.Type = collector.LogSource(payload)
+		case 3:
+			line.Path = string(payload)
+		case 4:
+			line.Tags = append(line.Tags, string(payload))
+		case 5:
+			line.Timezone = string(payload)
+		case 7:
+			line.Line = string(payload)
+		case 8:
+			line.ReadAt, err = decodeTimestamp(payload)
+		}
+		return err
+	})
+	return line, err
+}
@@ -0,0 +1,100 @@
+package gonderpb
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// AppendSystemLog appends entry as a SystemLog message
+func AppendSystemLog(b []byte, entry *collector.SystemLog) []byte {
+	b = appendString(b, 1, entry.ID)
+	b = appendTimestamp(b, 2, entry.Timestamp)
+	b = appendString(b, 3, entry.OriginalTimestamp)
+	b = appendString(b, 4, string(entry.Source))
+	b = appendString(b, 5, string(entry.Level))
+	b = appendString(b, 6, entry.Message)
+	b = appendString(b, 7, entry.Host)
+	b = appendString(b, 8, entry.Service)
+	b = appendInt32(b, 9, entry.PID)
+	b = appendString(b, 10, entry.User)
+	b = appendString(b, 11, entry.IP)
+	b = appendString(b, 12, entry.Method)
+	b = appendString(b, 13, entry.Path)
+	b = appendInt32(b, 14, entry.StatusCode)
+	b = appendString(b, 15, entry.RawLog)
+	if len(entry.ParsedData) > 0 {
+		if data, err := json.Marshal(entry.ParsedData); err == nil {
+			b = appendBytes(b, 16, data)
+		}
+	}
+	b = appendStrings(b, 17, entry.Tags)
+	b = appendTimestamp(b, 18, entry.CollectedAt)
+	b = appendString(b, 19, entry.Fingerprint)
+	return b
+}
+
+// DecodeSystemLog decodes a SystemLog message
+func DecodeSystemLog(data []byte) (*collector.SystemLog, error) {
+	entry := &collector.SystemLog{}
+	err := forEachField(data, func(field, wireType int, payload []byte, n uint64) error {
+		if wireType == wireVarint {
+			switch field {
+			case 9:
+				entry.PID = int(int32(n))
+			case 14:
+				entry.StatusCode = int(int32(n))
+			}
+			return nil
+		}
+		if wireType != wireBytes {
+			return nil
+		}
+
+		var err error
+		switch field {
+		case 1:
+			entry.ID = string(payload)
+		case 2:
+			entry.Timestamp, err = decodeTimestamp(payload)
+		case 3:
+			entry.OriginalTimestamp = string(payload)
+		case 4:
+			entry.Source = collector.LogSource(payload)
+		case 5:
+			entry.Level = collector.LogLevel(payload)
+		case 6:
+			entry.Message = string(payload)
+		case 7:
+			entry.Host = string(payload)
+		case 8:
+			entry.Service = string(payload)
+		case 10:
+			entry.User = string(payload)
+		case 11:
+			entry.IP = string(payload)
+		case 12:
+			entry.Method = string(payload)
+		case 13:
+			entry.Path = string(payload)
+		case 15:
+			entry.RawLog = string(payload)
+		case 16:
+			if err = json.Unmarshal(payload, &entry.ParsedData); err != nil {
+				err = fmt.Errorf("invalid parsed_data_json: %w", err)
+			}
+		case 17:
+			entry.Tags = append(entry.Tags, string(payload))
+		case 18:
+			entry.CollectedAt, err = decodeTimestamp(payload)
+		case 19:
+			entry.Fingerprint = string(payload)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
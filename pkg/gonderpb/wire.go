@@ -0,0 +1,169 @@
+// Package gonderpb encodes and decodes the messages of gonder.proto, the
+// core types in the protobuf wire format. Unknown fields are skipped, as
+// protobuf requires, and empty fields are left out, as proto3 does.
+//
+// The codecs are written by hand on purpose, like those of the OTLP
+// receiver and pkg/logstream, and there is no go:generate step: generated
+// code would convert between its own structs and collector.SystemLog,
+// audit.AuditEvent and collector.RawLine on every entry, and would make the
+// protobuf runtime a dependency of the server and the build need protoc.
+// Clients in other languages generate theirs from gonder.proto.
+// TestProtoFields fails when a codec and gonder.proto disagree, so a field
+// added to one must be added to the other.
+package gonderpb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated protobuf message")
+
+// forEachField calls fn for every field of a message. For numeric fields
+// the value is passed in n, for length-delimited fields the payload.
+func forEachField(data []byte, fn func(field, wireType int, payload []byte, n uint64) error) error {
+	for len(data) > 0 {
+		key, size := binary.Uvarint(data)
+		if size <= 0 {
+			return errTruncated
+		}
+		data = data[size:]
+		field, wireType := int(key>>3), int(key&7)
+
+		var payload []byte
+		var n uint64
+		switch wireType {
+		case wireVarint:
+			if n, size = binary.Uvarint(data); size <= 0 {
+				return errTruncated
+			}
+			data = data[size:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errTruncated
+			}
+			n, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return errTruncated
+			}
+			n, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case wireBytes:
+			length, size := binary.Uvarint(data)
+			if size <= 0 || uint64(len(data)-size) < length {
+				return errTruncated
+			}
+			payload, data = data[size:size+int(length)], data[size+int(length):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", wireType)
+		}
+		if err := fn(field, wireType, payload, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendKey(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func appendBytes(b []byte, field int, data []byte) []byte {
+	b = appendKey(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = appendKey(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// appendStrings appends a repeated string; its elements are written even
+// when empty
+func appendStrings(b []byte, field int, values []string) []byte {
+	for _, s := range values {
+		b = appendKey(b, field, wireBytes)
+		b = binary.AppendUvarint(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+	return b
+}
+
+func appendInt32(b []byte, field int, n int) []byte {
+	if n == 0 {
+		return b
+	}
+	b = appendKey(b, field, wireVarint)
+	// Negative int32 values are sign-extended to ten bytes
+	return binary.AppendUvarint(b, uint64(int64(int32(n))))
+}
+
+// appendSint64 appends a zigzag-encoded sint64
+func appendSint64(b []byte, field int, n int64) []byte {
+	if n == 0 {
+		return b
+	}
+	b = appendKey(b, field, wireVarint)
+	return binary.AppendUvarint(b, uint64(n<<1)^uint64(n>>63))
+}
+
+// decodeSint64 decodes a zigzag-encoded sint64
+func decodeSint64(n uint64) int64 {
+	return int64(n>>1) ^ -int64(n&1)
+}
+
+// appendTimestamp appends a google.protobuf.Timestamp
+func appendTimestamp(b []byte, field int, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	var ts []byte
+	if seconds := t.Unix(); seconds != 0 {
+		ts = appendKey(ts, 1, wireVarint)
+		ts = binary.AppendUvarint(ts, uint64(seconds))
+	}
+	if nanos := t.Nanosecond(); nanos != 0 {
+		ts = appendKey(ts, 2, wireVarint)
+		ts = binary.AppendUvarint(ts, uint64(nanos))
+	}
+	return appendBytes(b, field, ts)
+}
+
+// decodeTimestamp decodes a google.protobuf.Timestamp as a UTC time
+func decodeTimestamp(data []byte) (time.Time, error) {
+	var seconds, nanos int64
+	err := forEachField(data, func(field, wireType int, payload []byte, n uint64) error {
+		if wireType != wireVarint {
+			return nil
+		}
+		switch field {
+		case 1:
+			seconds = int64(n)
+		case 2:
+			nanos = int64(int32(n))
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if nanos < 0 || nanos >= int64(time.Second) {
+		return time.Time{}, fmt.Errorf("invalid timestamp nanos %d", nanos)
+	}
+	return time.Unix(seconds, nanos).UTC(), nil
+}
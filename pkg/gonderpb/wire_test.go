@@ -0,0 +1,220 @@
+package gonderpb
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// unknownFields holds one field of every wire type under numbers no
+// message uses; decoders must skip them
+var unknownFields = func() []byte {
+	b := appendInt32(nil, 90, 7)
+	b = appendString(b, 91, "future")
+	b = append(appendKey(b, 92, wireFixed64), 1, 2, 3, 4, 5, 6, 7, 8)
+	return append(appendKey(b, 93, wireFixed32), 1, 2, 3, 4)
+}()
+
+// fullSystemLog and fullAuditEvent set every field of their messages
+var fullSystemLog = &collector.SystemLog{
+	ID:                "abc123",
+	Timestamp:         time.Date(2026, 3, 1, 12, 30, 0, 123456789, time.UTC),
+	OriginalTimestamp: "Mar  1 14:30:00",
+	Source:            collector.SourceNginx,
+	Level:             collector.LevelError,
+	Message:           "upstream timed out",
+	Host:              "web-1",
+	Service:           "nginx",
+	PID:               4242,
+	User:              "www-data",
+	IP:                "10.0.0.1",
+	Method:            "GET",
+	Path:              "/api/items",
+	StatusCode:        504,
+	RawLog:            "raw line ü",
+	ParsedData:        map[string]interface{}{"upstream": "10.0.0.2:8080", "bytes": float64(512), "cached": false},
+	Tags:              []string{"web", "", "prod"},
+	CollectedAt:       time.Date(2026, 3, 1, 12, 30, 1, 0, time.UTC),
+	Fingerprint:       "f00d",
+}
+
+var fullAuditEvent = &audit.AuditEvent{
+	Timestamp:  time.Date(2026, 3, 1, 12, 0, 0, 1, time.UTC),
+	EventType:  audit.EventTypeAPICall,
+	UserID:     "admin",
+	SessionID:  "s1",
+	RequestID:  "r1",
+	Method:     "POST",
+	Path:       "/api/config",
+	StatusCode: 201,
+	Duration:   "12ms",
+	Message:    "API call",
+	Details:    map[string]interface{}{"changes": []interface{}{"a", "b"}, "count": float64(2)},
+	Error:      "none",
+	RemoteAddr: "127.0.0.1:5000",
+	UserAgent:  "curl/8",
+}
+
+func TestSystemLogRoundTrip(t *testing.T) {
+	tests := map[string]*collector.SystemLog{
+		"empty": {},
+		"full":  fullSystemLog,
+		"negative and pre-epoch": {
+			Timestamp:  time.Date(1960, 1, 1, 0, 0, 0, 500, time.UTC),
+			PID:        -1,
+			StatusCode: -2,
+		},
+	}
+	for name, entry := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := DecodeSystemLog(AppendSystemLog(nil, entry))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, entry) {
+				t.Fatalf("decoded %+v, want %+v", got, entry)
+			}
+			got, err = DecodeSystemLog(append(AppendSystemLog(nil, entry), unknownFields...))
+			if err != nil || !reflect.DeepEqual(got, entry) {
+				t.Fatalf("with unknown fields: decoded %+v, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestAuditEventRoundTrip(t *testing.T) {
+	event := fullAuditEvent
+	got, err := DecodeAuditEvent(append(AppendAuditEvent(nil, event), unknownFields...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, event) {
+		t.Fatalf("decoded %+v, want %+v", got, event)
+	}
+
+	// Details that aren't an object come back as their JSON value
+	got, err = DecodeAuditEvent(AppendAuditEvent(nil, &audit.AuditEvent{Details: "text"}))
+	if err != nil || got.Details != "text" {
+		t.Fatalf("decoded details %#v, %v", got.Details, err)
+	}
+}
+
+func TestRawLineBatchRoundTrip(t *testing.T) {
+	lines := []collector.RawLine{
+		{Source: "nginx", Type: collector.SourceNginx, Path: "/var/log/nginx/access.log", Tags: []string{"web"}, Offset: 1 << 40, Line: "GET /", ReadAt: time.Date(2026, 3, 1, 0, 0, 0, 7, time.UTC)},
+		{Source: "journal", Type: collector.SourceSyslog, Timezone: "Europe/Istanbul", Offset: -1, Line: ""},
+		{},
+	}
+	got, err := DecodeRawLineBatch(AppendRawLineBatch(nil, lines))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, lines) {
+		t.Fatalf("decoded %+v, want %+v", got, lines)
+	}
+	if got, err := DecodeRawLineBatch(nil); err != nil || len(got) != 0 {
+		t.Fatalf("empty batch decoded as %+v, %v", got, err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid := AppendSystemLog(nil, &collector.SystemLog{Message: "hello", Timestamp: time.Now()})
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"truncated payload", valid[:len(valid)-1], errTruncated},
+		{"truncated key", []byte{0x80}, errTruncated},
+		{"truncated varint", []byte{9 << 3, 0x80}, errTruncated},
+		{"truncated fixed64", append(appendKey(nil, 9, wireFixed64), 1, 2), errTruncated},
+		{"group wire type", appendKey(nil, 9, 3), nil},
+		{"invalid nanos", appendBytes(nil, 2, appendInt32(nil, 2, -1)), nil},
+		{"invalid parsed data", appendBytes(nil, 16, []byte("{")), nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeSystemLog(test.data)
+			if err == nil {
+				t.Fatal("DecodeSystemLog() succeeded")
+			}
+			if test.want != nil && !errors.Is(err, test.want) {
+				t.Fatalf("DecodeSystemLog() = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+// protoFields returns the field numbers and wire types of every message
+// declared in gonder.proto
+func protoFields(t *testing.T) map[string]map[int]int {
+	t.Helper()
+	data, err := os.ReadFile("gonder.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messageRE := regexp.MustCompile(`^message (\w+) \{`)
+	fieldRE := regexp.MustCompile(`^\s*(?:repeated\s+)?([\w.]+)\s+\w+\s*=\s*(\d+);`)
+	messages := make(map[string]map[int]int)
+	var fields map[int]int
+	for _, line := range strings.Split(string(data), "\n") {
+		if m := messageRE.FindStringSubmatch(line); m != nil {
+			fields = make(map[int]int)
+			messages[m[1]] = fields
+			continue
+		}
+		m := fieldRE.FindStringSubmatch(line)
+		if m == nil || fields == nil {
+			continue
+		}
+		number, _ := strconv.Atoi(m[2])
+		wireType := wireBytes
+		if m[1] == "int32" || m[1] == "sint64" {
+			wireType = wireVarint
+		}
+		fields[number] = wireType
+	}
+	return messages
+}
+
+// TestProtoFields keeps the hand-written codecs in step with gonder.proto:
+// a message with every field set must be encoded with exactly the numbers
+// and wire types the schema declares
+func TestProtoFields(t *testing.T) {
+	rawLine := collector.RawLine{Source: "nginx", Type: collector.SourceNginx, Path: "/var/log/nginx/access.log", Tags: []string{"web"}, Timezone: "UTC", Offset: 42, Line: "GET /", ReadAt: time.Now()}
+	tests := map[string][]byte{
+		"SystemLog":    AppendSystemLog(nil, fullSystemLog),
+		"AuditEvent":   AppendAuditEvent(nil, fullAuditEvent),
+		"RawLine":      appendRawLine(nil, &rawLine),
+		"RawLineBatch": AppendRawLineBatch(nil, []collector.RawLine{rawLine}),
+	}
+	messages := protoFields(t)
+	if names := slices.Sorted(maps.Keys(messages)); !reflect.DeepEqual(names, slices.Sorted(maps.Keys(tests))) {
+		t.Fatalf("gonder.proto declares %q", names)
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := make(map[int]int)
+			err := forEachField(data, func(field, wireType int, payload []byte, n uint64) error {
+				got[field] = wireType
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, messages[name]) {
+				t.Fatalf("encoded fields %v, gonder.proto declares %v", got, messages[name])
+			}
+		})
+	}
+}
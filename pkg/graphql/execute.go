@@ -0,0 +1,555 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Request is a GraphQL request as sent over HTTP
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Response is the result of a request. Data is left out when the request
+// failed before execution, e.g. on a syntax or validation error.
+type Response struct {
+	Data     interface{}
+	Errors   []*Error
+	executed bool
+}
+
+// MarshalJSON writes errors first, then data, as the spec recommends
+func (r *Response) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	if len(r.Errors) > 0 {
+		errs, err := json.Marshal(r.Errors)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(`"errors":`)
+		buf.Write(errs)
+		if r.executed {
+			buf.WriteByte(',')
+		}
+	}
+	if r.executed {
+		data, err := json.Marshal(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(`"data":`)
+		buf.Write(data)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Error is an error of a request, located in the query and, for errors of
+// resolvers, in the response
+type Error struct {
+	Message   string        `json:"message"`
+	Locations []Location    `json:"locations,omitempty"`
+	Path      []interface{} `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// object is a selection set result, marshaled with its fields in query order
+type object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		value, err := json.Marshal(o.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+type schemaKey struct{}
+
+// Execute parses, validates and runs a query. Only query operations are
+// supported; fields are resolved one after another.
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return &Response{Errors: []*Error{asError(err)}}
+	}
+	op, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return &Response{Errors: []*Error{asError(err)}}
+	}
+	if errs := s.validate(doc, op); len(errs) > 0 {
+		return &Response{Errors: errs}
+	}
+	vars, err := s.coerceVariables(op, req.Variables)
+	if err != nil {
+		return &Response{Errors: []*Error{asError(err)}}
+	}
+
+	e := &executor{schema: s, doc: doc, vars: vars}
+	ctx = context.WithValue(ctx, schemaKey{}, s)
+	data, ok := e.selectionSet(ctx, s.query, nil, op.selections, nil)
+	resp := &Response{Errors: e.errors, executed: true}
+	if ok {
+		resp.Data = data
+	}
+	return resp
+}
+
+func asError(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return &Error{Message: err.Error()}
+}
+
+func selectOperation(doc *document, name string) (*operation, error) {
+	if name == "" {
+		if len(doc.operations) > 1 {
+			return nil, &Error{Message: "Must provide operation name if query contains multiple operations."}
+		}
+		return doc.operations[0], nil
+	}
+	for _, op := range doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, &Error{Message: fmt.Sprintf("Unknown operation named %q.", name)}
+}
+
+// resolveType returns the type a variable definition refers to, nil if
+// there's no such type
+func (s *Schema) resolveType(ref *typeRef) *Type {
+	var t *Type
+	if ref.elem != nil {
+		elem := s.resolveType(ref.elem)
+		if elem == nil {
+			return nil
+		}
+		t = ListOf(elem)
+	} else if t = s.types[ref.name]; t == nil {
+		return nil
+	}
+	if ref.nonNull {
+		t = NonNull(t)
+	}
+	return t
+}
+
+// fieldDef returns the definition of a field of t, including the meta
+// fields __typename, and __schema and __type on the query type
+func (s *Schema) fieldDef(t *Type, name string) *Field {
+	switch {
+	case name == "__typename":
+		return typenameField
+	case t == s.query && name == "__schema":
+		return schemaField
+	case t == s.query && name == "__type":
+		return typeField
+	}
+	return t.field(name)
+}
+
+func (s *Schema) coerceVariables(op *operation, input map[string]interface{}) (map[string]interface{}, error) {
+	vars := make(map[string]interface{})
+	for _, def := range op.vars {
+		t := s.resolveType(def.typ)
+		v, provided := input[def.name]
+		if !provided {
+			if def.def != nil {
+				value, err := coerceLiteral(def.def, t, nil)
+				if err != nil {
+					return nil, &Error{Message: fmt.Sprintf("Variable \"$%s\" has an invalid default value: %v", def.name, err), Locations: []Location{def.loc}}
+				}
+				vars[def.name] = value
+			} else if t.Kind == KindNonNull {
+				return nil, &Error{Message: fmt.Sprintf("Variable \"$%s\" of required type %q was not provided.", def.name, t), Locations: []Location{def.loc}}
+			}
+			continue
+		}
+		value, err := coerceVariable(v, t)
+		if err != nil {
+			return nil, &Error{Message: fmt.Sprintf("Variable \"$%s\" got invalid value %s; %v", def.name, inputString(v), err), Locations: []Location{def.loc}}
+		}
+		vars[def.name] = value
+	}
+	return vars, nil
+}
+
+// coerceVariable coerces the decoded JSON value of a variable to t
+func coerceVariable(v interface{}, t *Type) (interface{}, error) {
+	if t.Kind == KindNonNull {
+		if v == nil {
+			return nil, fmt.Errorf("Expected non-nullable type %q not to be null.", t)
+		}
+		return coerceVariable(v, t.OfType)
+	}
+	if v == nil {
+		return nil, nil
+	}
+	switch t.Kind {
+	case KindList:
+		items, ok := v.([]interface{})
+		if !ok {
+			// A single value is a list of one
+			item, err := coerceVariable(v, t.OfType)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{item}, nil
+		}
+		list := make([]interface{}, len(items))
+		for i, item := range items {
+			value, err := coerceVariable(item, t.OfType)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = value
+		}
+		return list, nil
+	case KindEnum:
+		if s, ok := v.(string); ok && t.hasValue(s) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("Value %s does not exist in %q enum.", inputString(v), t.Name)
+	case KindScalar:
+		return t.parse(v)
+	}
+	return nil, fmt.Errorf("%q is not an input type.", t)
+}
+
+// coerceLiteral coerces a value written in the query to t. Variables are
+// looked up in vars; one that wasn't provided is null.
+func coerceLiteral(v value, t *Type, vars map[string]interface{}) (interface{}, error) {
+	if name, ok := v.(variable); ok {
+		value := vars[string(name)]
+		if value == nil && t.Kind == KindNonNull {
+			return nil, fmt.Errorf("Expected non-nullable type %q not to be null.", t)
+		}
+		return value, nil
+	}
+	if t.Kind == KindNonNull {
+		if lit, ok := v.(literalValue); ok && lit.v == nil {
+			return nil, fmt.Errorf("Expected value of type %q, found null.", t)
+		}
+		return coerceLiteral(v, t.OfType, vars)
+	}
+	if lit, ok := v.(literalValue); ok && lit.v == nil {
+		return nil, nil
+	}
+	switch t.Kind {
+	case KindList:
+		items, ok := v.(listValue)
+		if !ok {
+			item, err := coerceLiteral(v, t.OfType, vars)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{item}, nil
+		}
+		list := make([]interface{}, len(items))
+		for i, item := range items {
+			value, err := coerceLiteral(item, t.OfType, vars)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = value
+		}
+		return list, nil
+	case KindEnum:
+		if e, ok := v.(enumValue); ok && t.hasValue(string(e)) {
+			return string(e), nil
+		}
+		return nil, fmt.Errorf("Value %s does not exist in %q enum.", literalString(v), t.Name)
+	case KindScalar:
+		lit, ok := v.(literalValue)
+		if !ok {
+			return nil, fmt.Errorf("Expected value of type %q, found %s.", t, literalString(v))
+		}
+		if t == Int {
+			// A float literal is not an Int, even when it's integral
+			if _, isFloat := lit.v.(float64); isFloat {
+				return nil, fmt.Errorf("Int cannot represent non-integer value: %s", literalString(v))
+			}
+		}
+		return t.parse(lit.v)
+	}
+	return nil, fmt.Errorf("%q is not an input type.", t)
+}
+
+// literalString renders a value as written, for error messages
+func literalString(v value) string {
+	switch v := v.(type) {
+	case variable:
+		return "$" + string(v)
+	case enumValue:
+		return string(v)
+	case literalValue:
+		if v.v == nil {
+			return "null"
+		}
+		return inputString(v.v)
+	case listValue:
+		s := "["
+		for i, item := range v {
+			if i > 0 {
+				s += ", "
+			}
+			s += literalString(item)
+		}
+		return s + "]"
+	case objectValue:
+		s := "{"
+		for i, f := range v {
+			if i > 0 {
+				s += ", "
+			}
+			s += f.name + ": " + literalString(f.value)
+		}
+		return s + "}"
+	}
+	return fmt.Sprint(v)
+}
+
+func (t *Type) hasValue(name string) bool {
+	for _, v := range t.Values {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+type executor struct {
+	schema *Schema
+	doc    *document
+	vars   map[string]interface{}
+	errors []*Error
+}
+
+func (e *executor) errorf(fields []*field, path []interface{}, format string, args ...interface{}) {
+	e.errors = append(e.errors, &Error{
+		Message:   fmt.Sprintf(format, args...),
+		Locations: []Location{fields[0].loc},
+		Path:      append([]interface{}(nil), path...),
+	})
+}
+
+// collect groups the fields of a selection set by response key, following
+// fragments and applying @skip and @include
+func (e *executor) collect(selections []selection, keys *[]string, fields map[string][]*field, visited map[string]bool) {
+	for _, sel := range selections {
+		switch sel := sel.(type) {
+		case *field:
+			if !e.included(sel.directives) {
+				continue
+			}
+			key := sel.key()
+			if _, ok := fields[key]; !ok {
+				*keys = append(*keys, key)
+			}
+			fields[key] = append(fields[key], sel)
+		case *fragmentSpread:
+			if !e.included(sel.directives) || visited[sel.name] {
+				continue
+			}
+			visited[sel.name] = true
+			e.collect(e.doc.fragments[sel.name].selections, keys, fields, visited)
+		case *inlineFragment:
+			if !e.included(sel.directives) {
+				continue
+			}
+			e.collect(sel.selections, keys, fields, visited)
+		}
+	}
+}
+
+func (e *executor) included(directives []*directive) bool {
+	for _, d := range directives {
+		if len(d.args) == 0 {
+			continue
+		}
+		cond, _ := coerceLiteral(d.args[0].value, NonNull(Boolean), e.vars)
+		if b, _ := cond.(bool); b == (d.name == "skip") {
+			return false
+		}
+	}
+	return true
+}
+
+// selectionSet resolves the fields selected on an object. It returns false
+// if a non-null field is null, which makes the object null in turn.
+func (e *executor) selectionSet(ctx context.Context, t *Type, source interface{}, selections []selection, path []interface{}) (*object, bool) {
+	var keys []string
+	fields := make(map[string][]*field)
+	e.collect(selections, &keys, fields, make(map[string]bool))
+
+	result := &object{keys: keys, values: make(map[string]interface{}, len(keys))}
+	for _, key := range keys {
+		value, ok := e.field(ctx, t, source, fields[key], append(path, key))
+		if !ok {
+			return nil, false
+		}
+		result.values[key] = value
+	}
+	return result, true
+}
+
+func (e *executor) field(ctx context.Context, t *Type, source interface{}, fields []*field, path []interface{}) (interface{}, bool) {
+	f := fields[0]
+	def := e.schema.fieldDef(t, f.name)
+	if def == typenameField {
+		return t.Name, true
+	}
+	args, err := e.arguments(def, f)
+	var value interface{}
+	if err == nil {
+		if def.Resolve != nil {
+			value, err = def.Resolve(ctx, source, args)
+		} else {
+			value, err = resolveDefault(source, def.Name)
+		}
+	}
+	if err != nil {
+		e.errorf(fields, path, "%v", err)
+		return nil, def.Type.Kind != KindNonNull
+	}
+	value, ok := e.complete(ctx, def.Type, fields, value, path)
+	if !ok && def.Type.Kind != KindNonNull {
+		return nil, true
+	}
+	return value, ok
+}
+
+func (e *executor) arguments(def *Field, f *field) (map[string]interface{}, error) {
+	args := make(map[string]interface{}, len(def.Args))
+	for _, arg := range def.Args {
+		var written *argument
+		for _, a := range f.args {
+			if a.name == arg.Name {
+				written = a
+			}
+		}
+		if written == nil {
+			args[arg.Name] = arg.Default
+			continue
+		}
+		if name, ok := written.value.(variable); ok {
+			if _, provided := e.vars[string(name)]; !provided && arg.Default != nil {
+				args[arg.Name] = arg.Default
+				continue
+			}
+		}
+		value, err := coerceLiteral(written.value, arg.Type, e.vars)
+		if err != nil {
+			return nil, fmt.Errorf("Argument %q has an invalid value: %v", arg.Name, err)
+		}
+		args[arg.Name] = value
+	}
+	return args, nil
+}
+
+// complete converts a resolved value to its result for t. It returns false
+// when a non-null value is null; the error is recorded already.
+func (e *executor) complete(ctx context.Context, t *Type, fields []*field, value interface{}, path []interface{}) (interface{}, bool) {
+	if t.Kind == KindNonNull {
+		result, ok := e.complete(ctx, t.OfType, fields, value, path)
+		if !ok {
+			return nil, false
+		}
+		if result == nil {
+			e.errorf(fields, path, "Cannot return null for non-nullable field %s.", fields[0].name)
+			return nil, false
+		}
+		return result, true
+	}
+	if isNull(value) {
+		if t.Kind == KindList && value != nil && reflect.ValueOf(value).Kind() == reflect.Slice {
+			// A nil slice is an empty list
+			return []interface{}{}, true
+		}
+		return nil, true
+	}
+
+	switch t.Kind {
+	case KindList:
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			e.errorf(fields, path, "Expected a list for field %s, got %T.", fields[0].name, value)
+			return nil, false
+		}
+		list := make([]interface{}, rv.Len())
+		for i := range list {
+			item, ok := e.complete(ctx, t.OfType, fields, rv.Index(i).Interface(), append(path, i))
+			if !ok {
+				if t.OfType.Kind == KindNonNull {
+					return nil, false
+				}
+				item = nil
+			}
+			list[i] = item
+		}
+		return list, true
+	case KindObject:
+		var selections []selection
+		for _, f := range fields {
+			selections = append(selections, f.selections...)
+		}
+		result, ok := e.selectionSet(ctx, t, value, selections, path)
+		if !ok {
+			return nil, false
+		}
+		return result, true
+	case KindEnum:
+		s, err := serializeString(value)
+		if err != nil || !t.hasValue(s.(string)) {
+			e.errorf(fields, path, "Enum %q cannot represent value: %s", t.Name, inputString(value))
+			return nil, false
+		}
+		return s, true
+	}
+	result, err := t.serialize(value)
+	if err != nil {
+		e.errorf(fields, path, "%v", err)
+		return nil, false
+	}
+	return result, true
+}
+
+// isNull reports whether a resolved value is nil, including typed nil
+// pointers, maps and slices
+func isNull(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func:
+		return rv.IsNil()
+	}
+	return false
+}
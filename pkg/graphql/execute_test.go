@@ -0,0 +1,247 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+type testEntry struct {
+	ID      string    `json:"id"`
+	Message string    `json:"message"`
+	Level   string    `json:"level"`
+	Host    string    `json:"host,omitempty"`
+	Tags    []string  `json:"tags,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+var testEntries = []testEntry{
+	{ID: "1", Message: "started", Level: "info", Host: "web-1", Tags: []string{"a"}, At: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)},
+	{ID: "2", Message: "failed", Level: "error", At: time.Date(2026, 3, 1, 12, 0, 1, 500, time.UTC)},
+	{ID: "3", Message: "bad level", Level: "fatal"},
+}
+
+func testSchema(t *testing.T) *Schema {
+	level := NewEnum("Level", "Severity of an entry.", "info", "error")
+	entry := NewObject("Entry", "A log entry.",
+		&Field{Name: "id", Type: NonNull(ID)},
+		&Field{Name: "message", Type: NonNull(String)},
+		&Field{Name: "level", Type: level},
+		&Field{Name: "host", Type: String},
+		&Field{Name: "tags", Type: ListOf(NonNull(String))},
+		&Field{Name: "at", Type: Time},
+		&Field{Name: "broken", Type: NonNull(String), Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return nil, errors.New("resolver failed")
+		}},
+	)
+	query := NewObject("Query", "",
+		&Field{
+			Name: "entries",
+			Type: NonNull(ListOf(NonNull(entry))),
+			Args: []*Arg{{Name: "limit", Type: Int, Default: 2}, {Name: "level", Type: level}},
+			Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				var entries []testEntry
+				for _, e := range testEntries {
+					if args["level"] == nil || args["level"] == e.Level {
+						entries = append(entries, e)
+					}
+				}
+				return entries[:min(args["limit"].(int), len(entries))], nil
+			},
+		},
+		&Field{
+			Name: "entry",
+			Type: entry,
+			Args: []*Arg{{Name: "id", Type: NonNull(ID)}},
+			Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				for _, e := range testEntries {
+					if e.ID == args["id"] {
+						return &e, nil
+					}
+				}
+				return nil, nil
+			},
+		},
+		&Field{
+			Name: "echo",
+			Type: JSON,
+			Args: []*Arg{{Name: "value", Type: ListOf(Float)}, {Name: "since", Type: Time}},
+			Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				if since, ok := args["since"].(time.Time); ok {
+					return since.Unix(), nil
+				}
+				return args["value"], nil
+			},
+		},
+	)
+	s, err := NewSchema(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		operation string
+		variables map[string]interface{}
+		want      string
+	}{
+		{
+			"default argument",
+			`{ entries { id message level } }`, "", nil,
+			`{"data":{"entries":[{"id":"1","message":"started","level":"info"},{"id":"2","message":"failed","level":"error"}]}}`,
+		},
+		{
+			"aliases and typename",
+			`{ first: entry(id: 1) { __typename host tags at } second: entry(id: "2") { host tags at } none: entry(id: "9") { id } }`, "", nil,
+			`{"data":{"first":{"__typename":"Entry","host":"web-1","tags":["a"],"at":"2026-03-01T12:00:00Z"},"second":{"host":null,"tags":null,"at":"2026-03-01T12:00:01.0000005Z"},"none":null}}`,
+		},
+		{
+			"variables and enum argument",
+			`query Q($n: Int!, $level: Level) { entries(limit: $n, level: $level) { id } }`, "",
+			map[string]interface{}{"n": float64(5), "level": "error"},
+			`{"data":{"entries":[{"id":"2"}]}}`,
+		},
+		{
+			"variable left out uses the argument default",
+			`query Q($n: Int) { entries(limit: $n) { id } }`, "", nil,
+			`{"data":{"entries":[{"id":"1"},{"id":"2"}]}}`,
+		},
+		{
+			"fragments and directives",
+			`query Q($skip: Boolean!) { entry(id: 1) { ...F ... on Entry @include(if: false) { host } ... @skip(if: $skip) { level } } } fragment F on Entry { id message @skip(if: true) }`, "",
+			map[string]interface{}{"skip": false},
+			`{"data":{"entry":{"id":"1","level":"info"}}}`,
+		},
+		{
+			"operation name",
+			`query A { entry(id: 1) { id } } query B { entry(id: 2) { id } }`, "B", nil,
+			`{"data":{"entry":{"id":"2"}}}`,
+		},
+		{
+			"list and string literals",
+			`{ a: echo(value: [1, 2.5, -3e2]) b: echo(value: 4) c: echo(since: """2026-03-01T00:00:00Z""") }`, "", nil,
+			`{"data":{"a":[1,2.5,-300],"b":[4],"c":1772323200}}`,
+		},
+		{
+			"resolver error nulls the nearest nullable parent",
+			`{ entry(id: 1) { id broken } }`, "", nil,
+			`{"errors":[{"message":"resolver failed","locations":[{"line":1,"column":21}],"path":["entry","broken"]}],"data":{"entry":null}}`,
+		},
+		{
+			"invalid enum value nulls the field",
+			`{ entries(limit: 3) { level id } }`, "", nil,
+			`{"errors":[{"message":"Enum \"Level\" cannot represent value: \"fatal\"","locations":[{"line":1,"column":23}],"path":["entries",2,"level"]}],"data":{"entries":[{"level":"info","id":"1"},{"level":"error","id":"2"},{"level":null,"id":"3"}]}}`,
+		},
+		{
+			"null in a non-null list nulls the data",
+			`{ entries { broken } }`, "", nil,
+			`{"errors":[{"message":"resolver failed","locations":[{"line":1,"column":13}],"path":["entries",0,"broken"]}],"data":null}`,
+		},
+		{
+			"syntax error",
+			"{\n  entries { id \n", "", nil,
+			`{"errors":[{"message":"Syntax Error: Unexpected \u003cEOF\u003e.","locations":[{"line":3,"column":1}]}]}`,
+		},
+		{
+			"unknown field",
+			`{ entries { id nope } }`, "", nil,
+			`{"errors":[{"message":"Cannot query field \"nope\" on type \"Entry\".","locations":[{"line":1,"column":16}]}]}`,
+		},
+		{
+			"missing required argument",
+			`{ entry { id } }`, "", nil,
+			`{"errors":[{"message":"Argument \"id\" of type \"ID!\" is required on field \"Query.entry\", but it was not provided.","locations":[{"line":1,"column":3}]}]}`,
+		},
+		{
+			"invalid variable",
+			`query Q($n: Int!) { entries(limit: $n) { id } }`, "",
+			map[string]interface{}{"n": "many"},
+			`{"errors":[{"message":"Variable \"$n\" got invalid value \"many\"; Int cannot represent non-integer value: \"many\"","locations":[{"line":1,"column":9}]}]}`,
+		},
+		{
+			"mutation",
+			`mutation { entries { id } }`, "", nil,
+			`{"errors":[{"message":"Schema is not configured to execute mutation operation.","locations":[{"line":1,"column":1}]}]}`,
+		},
+	}
+	s := testSchema(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := s.Execute(context.Background(), Request{Query: test.query, OperationName: test.operation, Variables: test.variables})
+			got, err := json.Marshal(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Fatalf("response\n  %s\nwant\n  %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIntrospection(t *testing.T) {
+	s := testSchema(t)
+	resp := s.Execute(context.Background(), Request{Query: `{
+		__schema { queryType { name } }
+		__type(name: "Entry") { kind name fields { name type { kind name ofType { kind name } } } }
+		level: __type(name: "Level") { kind enumValues { name } }
+	}`})
+	if len(resp.Errors) > 0 {
+		t.Fatal(resp.Errors[0])
+	}
+	data, _ := json.Marshal(resp)
+	var got struct {
+		Data struct {
+			Schema struct {
+				QueryType struct{ Name string } `json:"queryType"`
+			} `json:"__schema"`
+			Type struct {
+				Kind   string
+				Name   string
+				Fields []struct {
+					Name string
+					Type struct {
+						Kind   string
+						OfType struct{ Name string } `json:"ofType"`
+					}
+				}
+			} `json:"__type"`
+			Level struct {
+				Kind       string
+				EnumValues []struct{ Name string } `json:"enumValues"`
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Data.Schema.QueryType.Name != "Query" {
+		t.Fatalf("query type %q", got.Data.Schema.QueryType.Name)
+	}
+	if got.Data.Type.Kind != "OBJECT" || len(got.Data.Type.Fields) != 7 {
+		t.Fatalf("Entry type %+v", got.Data.Type)
+	}
+	if f := got.Data.Type.Fields[0]; f.Name != "id" || f.Type.Kind != "NON_NULL" || f.Type.OfType.Name != "ID" {
+		t.Fatalf("first field %+v", f)
+	}
+	if got.Data.Level.Kind != "ENUM" || len(got.Data.Level.EnumValues) != 2 || got.Data.Level.EnumValues[1].Name != "error" {
+		t.Fatalf("Level type %+v", got.Data.Level)
+	}
+}
+
+func TestNewSchemaErrors(t *testing.T) {
+	a := NewObject("Thing", "", &Field{Name: "x", Type: String})
+	b := NewObject("Thing", "", &Field{Name: "y", Type: String})
+	if _, err := NewSchema(NewObject("Query", "", &Field{Name: "a", Type: a}, &Field{Name: "b", Type: b})); err == nil {
+		t.Fatal("two types with one name were accepted")
+	}
+	if _, err := NewSchema(NewObject("Query", "", &Field{Name: "empty", Type: NewObject("Empty", "")})); err == nil {
+		t.Fatal("an object type without fields was accepted")
+	}
+}
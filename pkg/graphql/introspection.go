@@ -0,0 +1,245 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Introspection (https://spec.graphql.org/October2021/#sec-Introspection):
+// the __schema and __type fields of the query type and the types they
+// return, so GraphiQL and client code generators can read the schema.
+
+// directiveDefinition describes one of the directives the executor supports
+type directiveDefinition struct {
+	Name        string
+	Description string
+	Locations   []string
+	Args        []*Arg
+}
+
+var directives = []*directiveDefinition{
+	{
+		Name:        "include",
+		Description: "Directs the executor to include this field or fragment only when the `if` argument is true.",
+		Locations:   []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+		Args:        []*Arg{{Name: "if", Description: "Included when true.", Type: NonNull(Boolean)}},
+	},
+	{
+		Name:        "skip",
+		Description: "Directs the executor to skip this field or fragment when the `if` argument is true.",
+		Locations:   []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+		Args:        []*Arg{{Name: "if", Description: "Skipped when true.", Type: NonNull(Boolean)}},
+	},
+}
+
+func directiveDef(name string) *directiveDefinition {
+	for _, d := range directives {
+		if d.Name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+var (
+	typeKindType = NewEnum("__TypeKind", "The kind of a type.",
+		"SCALAR", "OBJECT", "INTERFACE", "UNION", "ENUM", "INPUT_OBJECT", "LIST", "NON_NULL")
+	directiveLocationType = NewEnum("__DirectiveLocation", "Where a directive may be used.",
+		"QUERY", "MUTATION", "SUBSCRIPTION", "FIELD", "FRAGMENT_DEFINITION", "FRAGMENT_SPREAD", "INLINE_FRAGMENT", "VARIABLE_DEFINITION",
+		"SCHEMA", "SCALAR", "OBJECT", "FIELD_DEFINITION", "ARGUMENT_DEFINITION", "INTERFACE", "UNION", "ENUM", "ENUM_VALUE", "INPUT_OBJECT", "INPUT_FIELD_DEFINITION")
+
+	schemaType     = NewObject("__Schema", "The types and directives of the schema, and its root types.")
+	typeType       = NewObject("__Type", "A type of the schema, or a list or non-null wrapper of one.")
+	fieldType      = NewObject("__Field", "A field of an object type.")
+	inputValueType = NewObject("__InputValue", "An argument of a field or directive.")
+	enumValueType  = NewObject("__EnumValue", "A value of an enum type.")
+	directiveType  = NewObject("__Directive", "A directive the executor supports.")
+
+	typenameField = &Field{Name: "__typename", Description: "The name of the object type.", Type: NonNull(String)}
+	schemaField   = &Field{
+		Name:        "__schema",
+		Description: "The schema.",
+		Type:        NonNull(schemaType),
+		Resolve: func(ctx context.Context, _ interface{}, _ map[string]interface{}) (interface{}, error) {
+			return ctx.Value(schemaKey{}), nil
+		},
+	}
+	typeField = &Field{
+		Name:        "__type",
+		Description: "A type by name, null if there's no such type.",
+		Type:        typeType,
+		Args:        []*Arg{{Name: "name", Type: NonNull(String)}},
+		Resolve: func(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+			s := ctx.Value(schemaKey{}).(*Schema)
+			if t, ok := s.types[args["name"].(string)]; ok {
+				return t, nil
+			}
+			return nil, nil
+		},
+	}
+)
+
+// The introspection types refer to each other, so their fields are set here
+func init() {
+	boolFalse := func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+		return false, nil
+	}
+	null := func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+		return nil, nil
+	}
+	includeDeprecated := []*Arg{{Name: "includeDeprecated", Type: Boolean, Default: false}}
+
+	schemaType.Fields = []*Field{
+		{Name: "description", Type: String, Resolve: null},
+		{Name: "types", Type: NonNull(ListOf(NonNull(typeType))), Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			s := source.(*Schema)
+			types := make([]*Type, len(s.names))
+			for i, name := range s.names {
+				types[i] = s.types[name]
+			}
+			return types, nil
+		}},
+		{Name: "queryType", Type: NonNull(typeType), Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*Schema).query, nil
+		}},
+		{Name: "mutationType", Type: typeType, Resolve: null},
+		{Name: "subscriptionType", Type: typeType, Resolve: null},
+		{Name: "directives", Type: NonNull(ListOf(NonNull(directiveType))), Resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+			return directives, nil
+		}},
+	}
+
+	typeType.Fields = []*Field{
+		{Name: "kind", Type: NonNull(typeKindType), Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return string(source.(*Type).Kind), nil
+		}},
+		{Name: "name", Type: String, Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return optional(source.(*Type).Name), nil
+		}},
+		{Name: "description", Type: String, Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return optional(source.(*Type).Description), nil
+		}},
+		{Name: "specifiedByURL", Type: String, Resolve: null},
+		{Name: "fields", Type: ListOf(NonNull(fieldType)), Args: includeDeprecated, Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			if t := source.(*Type); t.Kind == KindObject {
+				return t.Fields, nil
+			}
+			return nil, nil
+		}},
+		{Name: "interfaces", Type: ListOf(NonNull(typeType)), Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			if source.(*Type).Kind == KindObject {
+				return []*Type{}, nil
+			}
+			return nil, nil
+		}},
+		{Name: "possibleTypes", Type: ListOf(NonNull(typeType)), Resolve: null},
+		{Name: "enumValues", Type: ListOf(NonNull(enumValueType)), Args: includeDeprecated, Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			if t := source.(*Type); t.Kind == KindEnum {
+				return t.Values, nil
+			}
+			return nil, nil
+		}},
+		{Name: "inputFields", Type: ListOf(NonNull(inputValueType)), Args: includeDeprecated, Resolve: null},
+		{Name: "ofType", Type: typeType, Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*Type).OfType, nil
+		}},
+		{Name: "isOneOf", Type: Boolean, Resolve: null},
+	}
+
+	fieldType.Fields = []*Field{
+		{Name: "name", Type: NonNull(String), Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*Field).Name, nil
+		}},
+		{Name: "description", Type: String, Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return optional(source.(*Field).Description), nil
+		}},
+		{Name: "args", Type: NonNull(ListOf(NonNull(inputValueType))), Args: includeDeprecated, Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return append([]*Arg{}, source.(*Field).Args...), nil
+		}},
+		{Name: "type", Type: NonNull(typeType), Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*Field).Type, nil
+		}},
+		{Name: "isDeprecated", Type: NonNull(Boolean), Resolve: boolFalse},
+		{Name: "deprecationReason", Type: String, Resolve: null},
+	}
+
+	inputValueType.Fields = []*Field{
+		{Name: "name", Type: NonNull(String), Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*Arg).Name, nil
+		}},
+		{Name: "description", Type: String, Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return optional(source.(*Arg).Description), nil
+		}},
+		{Name: "type", Type: NonNull(typeType), Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*Arg).Type, nil
+		}},
+		{Name: "defaultValue", Type: String, Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			arg := source.(*Arg)
+			if arg.Default == nil {
+				return nil, nil
+			}
+			return printValue(arg.Default, arg.Type), nil
+		}},
+		{Name: "isDeprecated", Type: NonNull(Boolean), Resolve: boolFalse},
+		{Name: "deprecationReason", Type: String, Resolve: null},
+	}
+
+	enumValueType.Fields = []*Field{
+		{Name: "name", Type: NonNull(String), Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source, nil
+		}},
+		{Name: "description", Type: String, Resolve: null},
+		{Name: "isDeprecated", Type: NonNull(Boolean), Resolve: boolFalse},
+		{Name: "deprecationReason", Type: String, Resolve: null},
+	}
+
+	directiveType.Fields = []*Field{
+		{Name: "name", Type: NonNull(String), Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*directiveDefinition).Name, nil
+		}},
+		{Name: "description", Type: String, Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return optional(source.(*directiveDefinition).Description), nil
+		}},
+		{Name: "isRepeatable", Type: NonNull(Boolean), Resolve: boolFalse},
+		{Name: "locations", Type: NonNull(ListOf(NonNull(directiveLocationType))), Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*directiveDefinition).Locations, nil
+		}},
+		{Name: "args", Type: NonNull(ListOf(NonNull(inputValueType))), Args: includeDeprecated, Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*directiveDefinition).Args, nil
+		}},
+	}
+}
+
+// optional returns nil for an empty string, which introspection reports as
+// null
+func optional(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// printValue writes a default value as a GraphQL literal
+func printValue(v interface{}, t *Type) string {
+	if t.Kind == KindNonNull {
+		t = t.OfType
+	}
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		if t.Kind == KindEnum {
+			return v
+		}
+		return strconv.Quote(v)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = printValue(item, t.OfType)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return fmt.Sprint(v)
+}
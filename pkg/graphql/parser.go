@@ -0,0 +1,770 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A parser for GraphQL executable documents
+// (https://spec.graphql.org/October2021/#sec-Language): operations with
+// variables, fields with aliases and arguments, fragments and directives.
+// Type system definitions are not accepted, the schema is built in Go.
+
+// Location is a position in the query text, counted from 1
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// document is a parsed query
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind       string // query, mutation or subscription
+	name       string
+	vars       []*varDef
+	directives []*directive
+	selections []selection
+	loc        Location
+}
+
+type varDef struct {
+	name string
+	typ  *typeRef
+	def  value // nil without a default
+	loc  Location
+}
+
+// typeRef is a type as written in a variable definition
+type typeRef struct {
+	name    string
+	elem    *typeRef // set for lists
+	nonNull bool
+}
+
+func (t *typeRef) String() string {
+	s := t.name
+	if t.elem != nil {
+		s = "[" + t.elem.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
+
+type fragment struct {
+	name       string
+	typeCond   string
+	directives []*directive
+	selections []selection
+	loc        Location
+}
+
+// selection is a *field, *fragmentSpread or *inlineFragment
+type selection interface{}
+
+type field struct {
+	alias      string
+	name       string
+	args       []*argument
+	directives []*directive
+	selections []selection
+	loc        Location
+}
+
+// key is the name of the field in the response
+func (f *field) key() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+type fragmentSpread struct {
+	name       string
+	directives []*directive
+	loc        Location
+}
+
+type inlineFragment struct {
+	typeCond   string // empty without a type condition
+	directives []*directive
+	selections []selection
+	loc        Location
+}
+
+type argument struct {
+	name  string
+	value value
+	loc   Location
+}
+
+type directive struct {
+	name string
+	args []*argument
+	loc  Location
+}
+
+// value is an input value as written: a variable, a literal, a list or an
+// object
+type value interface{}
+
+type (
+	variable     string
+	enumValue    string
+	listValue    []value
+	objectValue  []*argument
+	literalValue struct {
+		v interface{} // int64, float64, string, bool or nil
+	}
+)
+
+// Token kinds
+const (
+	tokEOF = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind int
+	text string // the punctuator, name, number or decoded string
+	loc  Location
+}
+
+// lexer splits a query into tokens, skipping whitespace, commas and
+// comments
+type lexer struct {
+	src       string
+	pos       int
+	line, col int
+}
+
+func (l *lexer) errorf(loc Location, format string, args ...interface{}) error {
+	return &Error{Message: "Syntax Error: " + fmt.Sprintf(format, args...), Locations: []Location{loc}}
+}
+
+func (l *lexer) advance(n int) {
+	for i := 0; i < n; i++ {
+		if l.src[l.pos] == '\n' {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+		l.pos++
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			l.advance(1)
+		} else if c == '#' {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.advance(1)
+			}
+		} else {
+			break
+		}
+	}
+	loc := Location{Line: l.line, Column: l.col}
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, loc: loc}, nil
+	}
+
+	rest := l.src[l.pos:]
+	c := rest[0]
+	switch {
+	case strings.HasPrefix(rest, "..."):
+		l.advance(3)
+		return token{kind: tokPunct, text: "...", loc: loc}, nil
+	case strings.IndexByte("!$&():=@[]{}|", c) >= 0:
+		l.advance(1)
+		return token{kind: tokPunct, text: string(c), loc: loc}, nil
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		n := 1
+		for n < len(rest) && isNameChar(rest[n]) {
+			n++
+		}
+		l.advance(n)
+		return token{kind: tokName, text: rest[:n], loc: loc}, nil
+	case c == '-' || c >= '0' && c <= '9':
+		return l.number(loc)
+	case strings.HasPrefix(rest, `"""`):
+		return l.blockString(loc)
+	case c == '"':
+		return l.string(loc)
+	}
+	r, _ := utf8.DecodeRuneInString(rest)
+	return token{}, l.errorf(loc, "Unexpected character %q.", r)
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func (l *lexer) number(loc Location) (token, error) {
+	rest := l.src[l.pos:]
+	n, float := 0, false
+	if rest[n] == '-' {
+		n++
+	}
+	digits := func() int {
+		start := n
+		for n < len(rest) && rest[n] >= '0' && rest[n] <= '9' {
+			n++
+		}
+		return n - start
+	}
+	if d := digits(); d == 0 || (d > 1 && rest[n-d] == '0') {
+		return token{}, l.errorf(loc, "Invalid number %q.", rest[:max(n, 1)])
+	}
+	if n < len(rest) && rest[n] == '.' {
+		n++
+		float = true
+		if digits() == 0 {
+			return token{}, l.errorf(loc, "Invalid number %q.", rest[:n])
+		}
+	}
+	if n < len(rest) && (rest[n] == 'e' || rest[n] == 'E') {
+		n++
+		float = true
+		if n < len(rest) && (rest[n] == '+' || rest[n] == '-') {
+			n++
+		}
+		if digits() == 0 {
+			return token{}, l.errorf(loc, "Invalid number %q.", rest[:n])
+		}
+	}
+	if n < len(rest) && (isNameChar(rest[n]) || rest[n] == '.') {
+		return token{}, l.errorf(loc, "Invalid number %q.", rest[:n+1])
+	}
+	l.advance(n)
+	if float {
+		return token{kind: tokFloat, text: rest[:n], loc: loc}, nil
+	}
+	return token{kind: tokInt, text: rest[:n], loc: loc}, nil
+}
+
+func (l *lexer) string(loc Location) (token, error) {
+	var b strings.Builder
+	l.advance(1)
+	for {
+		if l.pos >= len(l.src) || l.src[l.pos] == '\n' {
+			return token{}, l.errorf(loc, "Unterminated string.")
+		}
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.advance(1)
+			return token{kind: tokString, text: b.String(), loc: loc}, nil
+		case c == '\\':
+			if l.pos+1 >= len(l.src) {
+				return token{}, l.errorf(loc, "Unterminated string.")
+			}
+			switch e := l.src[l.pos+1]; e {
+			case '"', '\\', '/':
+				b.WriteByte(e)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if l.pos+6 > len(l.src) {
+					return token{}, l.errorf(loc, "Invalid unicode escape.")
+				}
+				r, err := strconv.ParseUint(l.src[l.pos+2:l.pos+6], 16, 32)
+				if err != nil {
+					return token{}, l.errorf(loc, "Invalid unicode escape.")
+				}
+				b.WriteRune(rune(r))
+				l.advance(4)
+			default:
+				return token{}, l.errorf(loc, "Invalid escape \\%c.", e)
+			}
+			l.advance(2)
+		default:
+			b.WriteByte(c)
+			l.advance(1)
+		}
+	}
+}
+
+// blockString reads a """block string""", removing the common indentation
+// and leading and trailing blank lines
+func (l *lexer) blockString(loc Location) (token, error) {
+	l.advance(3)
+	var b strings.Builder
+	for {
+		if l.pos >= len(l.src) {
+			return token{}, l.errorf(loc, "Unterminated string.")
+		}
+		rest := l.src[l.pos:]
+		if strings.HasPrefix(rest, `"""`) {
+			l.advance(3)
+			break
+		}
+		if strings.HasPrefix(rest, `\"""`) {
+			b.WriteString(`"""`)
+			l.advance(4)
+			continue
+		}
+		b.WriteByte(rest[0])
+		l.advance(1)
+	}
+
+	lines := strings.Split(strings.ReplaceAll(b.String(), "\r\n", "\n"), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && (indent < 0 || len(line)-len(trimmed) < indent) {
+			indent = len(line) - len(trimmed)
+		}
+	}
+	if indent > 0 {
+		for i := 1; i < len(lines); i++ {
+			lines[i] = lines[i][min(indent, len(lines[i])):]
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return token{kind: tokString, text: strings.Join(lines, "\n"), loc: loc}, nil
+}
+
+// parser builds a document from the tokens of a query
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+// parse parses a query into a document
+func parse(query string) (*document, error) {
+	p := &parser{lex: &lexer{src: strings.TrimPrefix(query, "\uFEFF"), line: 1, col: 1}}
+	if err := p.read(); err != nil {
+		return nil, err
+	}
+	doc := &document{fragments: make(map[string]*fragment)}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.peek("{"):
+			op := &operation{kind: "query", loc: p.tok.loc}
+			var err error
+			if op.selections, err = p.selectionSet(); err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.tok.kind == tokName && (p.tok.text == "query" || p.tok.text == "mutation" || p.tok.text == "subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.tok.kind == tokName && p.tok.text == "fragment":
+			f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.fragments[f.name]; ok {
+				return nil, &Error{Message: fmt.Sprintf("There can be only one fragment named %q.", f.name), Locations: []Location{f.loc}}
+			}
+			doc.fragments[f.name] = f
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, &Error{Message: "The document contains no operation."}
+	}
+	return doc, nil
+}
+
+func (p *parser) read() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) peek(punct string) bool {
+	return p.tok.kind == tokPunct && p.tok.text == punct
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokEOF {
+		return p.lex.errorf(p.tok.loc, "Unexpected <EOF>.")
+	}
+	return p.lex.errorf(p.tok.loc, "Unexpected %q.", p.tok.text)
+}
+
+// expect consumes the punctuator or fails
+func (p *parser) expect(punct string) error {
+	if !p.peek(punct) {
+		if p.tok.kind == tokEOF {
+			return p.lex.errorf(p.tok.loc, "Expected %q, found <EOF>.", punct)
+		}
+		return p.lex.errorf(p.tok.loc, "Expected %q, found %q.", punct, p.tok.text)
+	}
+	return p.read()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokName {
+		return "", p.unexpected()
+	}
+	name := p.tok.text
+	return name, p.read()
+}
+
+func (p *parser) operation() (*operation, error) {
+	op := &operation{kind: p.tok.text, loc: p.tok.loc}
+	if err := p.read(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokName {
+		op.name = p.tok.text
+		if err := p.read(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek("(") {
+		if err := p.read(); err != nil {
+			return nil, err
+		}
+		for !p.peek(")") {
+			def, err := p.varDef()
+			if err != nil {
+				return nil, err
+			}
+			op.vars = append(op.vars, def)
+		}
+		if err := p.read(); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if op.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if op.selections, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (p *parser) varDef() (*varDef, error) {
+	def := &varDef{loc: p.tok.loc}
+	if err := p.expect("$"); err != nil {
+		return nil, err
+	}
+	var err error
+	if def.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	if def.typ, err = p.typeRef(); err != nil {
+		return nil, err
+	}
+	if p.peek("=") {
+		if err := p.read(); err != nil {
+			return nil, err
+		}
+		if def.def, err = p.value(true); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	return def, nil
+}
+
+func (p *parser) typeRef() (*typeRef, error) {
+	t := &typeRef{}
+	if p.peek("[") {
+		if err := p.read(); err != nil {
+			return nil, err
+		}
+		elem, err := p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+		t.elem = elem
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		t.name = name
+	}
+	if p.peek("!") {
+		t.nonNull = true
+		if err := p.read(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (p *parser) fragment() (*fragment, error) {
+	f := &fragment{loc: p.tok.loc}
+	if err := p.read(); err != nil {
+		return nil, err
+	}
+	var err error
+	if f.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if f.name == "on" {
+		return nil, p.lex.errorf(f.loc, "Unexpected name \"on\".")
+	}
+	if p.tok.kind != tokName || p.tok.text != "on" {
+		return nil, p.lex.errorf(p.tok.loc, "Expected \"on\", found %q.", p.tok.text)
+	}
+	if err := p.read(); err != nil {
+		return nil, err
+	}
+	if f.typeCond, err = p.name(); err != nil {
+		return nil, err
+	}
+	if f.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if f.selections, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p *parser) selectionSet() ([]selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var set []selection
+	for !p.peek("}") {
+		s, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, s)
+	}
+	if len(set) == 0 {
+		return nil, p.lex.errorf(p.tok.loc, "Expected a selection, found \"}\".")
+	}
+	return set, p.read()
+}
+
+func (p *parser) selection() (selection, error) {
+	loc := p.tok.loc
+	if p.peek("...") {
+		if err := p.read(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokName && p.tok.text != "on" {
+			spread := &fragmentSpread{name: p.tok.text, loc: loc}
+			if err := p.read(); err != nil {
+				return nil, err
+			}
+			var err error
+			spread.directives, err = p.directives()
+			return spread, err
+		}
+		inline := &inlineFragment{loc: loc}
+		if p.tok.kind == tokName {
+			if err := p.read(); err != nil {
+				return nil, err
+			}
+			var err error
+			if inline.typeCond, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if inline.directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		inline.selections, err = p.selectionSet()
+		return inline, err
+	}
+
+	f := &field{loc: loc}
+	var err error
+	if f.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if p.peek(":") {
+		if err := p.read(); err != nil {
+			return nil, err
+		}
+		f.alias = f.name
+		if f.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if f.args, err = p.arguments(false); err != nil {
+		return nil, err
+	}
+	if f.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.peek("{") {
+		if f.selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (p *parser) arguments(constant bool) ([]*argument, error) {
+	if !p.peek("(") {
+		return nil, nil
+	}
+	if err := p.read(); err != nil {
+		return nil, err
+	}
+	var args []*argument
+	for !p.peek(")") {
+		arg := &argument{loc: p.tok.loc}
+		var err error
+		if arg.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if arg.value, err = p.value(constant); err != nil {
+			return nil, err
+		}
+		for _, other := range args {
+			if other.name == arg.name {
+				return nil, &Error{Message: fmt.Sprintf("There can be only one argument named %q.", arg.name), Locations: []Location{other.loc, arg.loc}}
+			}
+		}
+		args = append(args, arg)
+	}
+	if len(args) == 0 {
+		return nil, p.lex.errorf(p.tok.loc, "Expected an argument, found \")\".")
+	}
+	return args, p.read()
+}
+
+func (p *parser) directives() ([]*directive, error) {
+	var directives []*directive
+	for p.peek("@") {
+		d := &directive{loc: p.tok.loc}
+		if err := p.read(); err != nil {
+			return nil, err
+		}
+		var err error
+		if d.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if d.args, err = p.arguments(false); err != nil {
+			return nil, err
+		}
+		directives = append(directives, d)
+	}
+	return directives, nil
+}
+
+// value parses an input value; constant values may not hold variables
+func (p *parser) value(constant bool) (value, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokPunct:
+		switch tok.text {
+		case "$":
+			if constant {
+				return nil, p.lex.errorf(tok.loc, "Unexpected variable in a constant value.")
+			}
+			if err := p.read(); err != nil {
+				return nil, err
+			}
+			name, err := p.name()
+			return variable(name), err
+		case "[":
+			if err := p.read(); err != nil {
+				return nil, err
+			}
+			list := listValue{}
+			for !p.peek("]") {
+				v, err := p.value(constant)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			return list, p.read()
+		case "{":
+			if err := p.read(); err != nil {
+				return nil, err
+			}
+			object := objectValue{}
+			for !p.peek("}") {
+				f := &argument{loc: p.tok.loc}
+				var err error
+				if f.name, err = p.name(); err != nil {
+					return nil, err
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				if f.value, err = p.value(constant); err != nil {
+					return nil, err
+				}
+				object = append(object, f)
+			}
+			return object, p.read()
+		}
+	case tokInt:
+		n, err := strconv.ParseInt(tok.text, 10, 64)
+		if err != nil {
+			return nil, p.lex.errorf(tok.loc, "Invalid number %q.", tok.text)
+		}
+		return literalValue{n}, p.read()
+	case tokFloat:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.lex.errorf(tok.loc, "Invalid number %q.", tok.text)
+		}
+		return literalValue{f}, p.read()
+	case tokString:
+		return literalValue{tok.text}, p.read()
+	case tokName:
+		switch tok.text {
+		case "true", "false":
+			return literalValue{tok.text == "true"}, p.read()
+		case "null":
+			return literalValue{nil}, p.read()
+		}
+		return enumValue(tok.text), p.read()
+	}
+	return nil, p.unexpected()
+}
@@ -0,0 +1,417 @@
+// Package graphql executes GraphQL queries
+// (https://spec.graphql.org/October2021/) against a schema built in Go:
+// object, enum and scalar types whose fields are resolved by functions or
+// read from structs by their JSON names. It covers what a read-only API
+// needs: queries with variables, fragments, aliases, @skip and @include,
+// validation, null propagation and introspection. Mutations,
+// subscriptions, interfaces, unions and input objects are not supported.
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind is the kind of a type
+type Kind string
+
+// Kinds, named as introspection reports them
+const (
+	KindScalar  Kind = "SCALAR"
+	KindObject  Kind = "OBJECT"
+	KindEnum    Kind = "ENUM"
+	KindList    Kind = "LIST"
+	KindNonNull Kind = "NON_NULL"
+)
+
+// Type is a scalar, enum or object type, or a list or non-null wrapper of
+// another type
+type Type struct {
+	Kind        Kind
+	Name        string
+	Description string
+	// Fields of an object type, in the order introspection lists them
+	Fields []*Field
+	// Values of an enum type
+	Values []string
+	// OfType is the wrapped type of a list or non-null type
+	OfType *Type
+
+	// serialize converts a resolved value of a scalar to its JSON value
+	serialize func(interface{}) (interface{}, error)
+	// parse coerces an input value of a scalar: int64, float64, string or
+	// bool from a literal, or the decoded JSON of a variable
+	parse func(interface{}) (interface{}, error)
+}
+
+// Field is a field of an object type
+type Field struct {
+	Name        string
+	Description string
+	Type        *Type
+	Args        []*Arg
+	// Resolve returns the value of the field. Without it the field is read
+	// from the object: the struct field with this JSON name, or the map key.
+	Resolve ResolveFunc
+}
+
+// Arg is an argument of a field
+type Arg struct {
+	Name        string
+	Description string
+	Type        *Type
+	// Default is used when the argument is left out; nil is no default
+	Default interface{}
+}
+
+// ResolveFunc returns the value of a field of source. Arguments are
+// coerced to their types: int, float64, string, bool, []interface{} for
+// lists, nil when left out without a default.
+type ResolveFunc func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error)
+
+// NewObject creates an object type
+func NewObject(name, description string, fields ...*Field) *Type {
+	return &Type{Kind: KindObject, Name: name, Description: description, Fields: fields}
+}
+
+// NewEnum creates an enum type; its values are strings
+func NewEnum(name, description string, values ...string) *Type {
+	return &Type{Kind: KindEnum, Name: name, Description: description, Values: values}
+}
+
+// NonNull wraps t as non-null
+func NonNull(t *Type) *Type {
+	return &Type{Kind: KindNonNull, OfType: t}
+}
+
+// ListOf wraps t as a list
+func ListOf(t *Type) *Type {
+	return &Type{Kind: KindList, OfType: t}
+}
+
+// field returns the field of an object type by name
+func (t *Type) field(name string) *Field {
+	for _, f := range t.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// named returns the type without its list and non-null wrappers
+func (t *Type) named() *Type {
+	for t.OfType != nil {
+		t = t.OfType
+	}
+	return t
+}
+
+// String returns the type as written in a query, e.g. [String!]!
+func (t *Type) String() string {
+	switch t.Kind {
+	case KindNonNull:
+		return t.OfType.String() + "!"
+	case KindList:
+		return "[" + t.OfType.String() + "]"
+	}
+	return t.Name
+}
+
+func (t *Type) isLeaf() bool {
+	return t.Kind == KindScalar || t.Kind == KindEnum
+}
+
+// Built-in scalars, and Time (an RFC 3339 string) and JSON (any value)
+var (
+	String = &Type{Kind: KindScalar, Name: "String", Description: "UTF-8 text.", serialize: serializeString, parse: parseString}
+	Int    = &Type{Kind: KindScalar, Name: "Int", Description: "A signed 32-bit integer.", serialize: serializeInt, parse: parseInt}
+	Float  = &Type{Kind: KindScalar, Name: "Float", Description: "A double-precision number.", serialize: serializeFloat, parse: parseFloat}
+	// Boolean is true or false
+	Boolean = &Type{Kind: KindScalar, Name: "Boolean", Description: "true or false.", serialize: serializeBoolean, parse: parseBoolean}
+	ID      = &Type{Kind: KindScalar, Name: "ID", Description: "A unique identifier, serialized as a string.", serialize: serializeString, parse: parseID}
+	Time    = &Type{Kind: KindScalar, Name: "Time", Description: "A point in time as an RFC 3339 string.", serialize: serializeTime, parse: parseTime}
+	JSON    = &Type{Kind: KindScalar, Name: "JSON", Description: "Any JSON value.", serialize: func(v interface{}) (interface{}, error) { return v, nil }, parse: func(v interface{}) (interface{}, error) { return v, nil }}
+)
+
+// Schema is the types reachable from a query root type
+type Schema struct {
+	query *Type
+	types map[string]*Type
+	// names lists the named types in the order introspection reports them
+	names []string
+}
+
+// NewSchema creates a schema with its query root type. Types are checked
+// for name clashes and object types without fields.
+func NewSchema(query *Type) (*Schema, error) {
+	s := &Schema{query: query, types: make(map[string]*Type)}
+	for _, t := range []*Type{query, String, Boolean, schemaType} {
+		if err := s.add(t); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Schema) add(t *Type) error {
+	t = t.named()
+	if existing, ok := s.types[t.Name]; ok {
+		if existing != t {
+			return fmt.Errorf("graphql: two types named %s", t.Name)
+		}
+		return nil
+	}
+	if t.Kind == KindObject && len(t.Fields) == 0 {
+		return fmt.Errorf("graphql: object type %s has no fields", t.Name)
+	}
+	s.types[t.Name] = t
+	s.names = append(s.names, t.Name)
+	for _, f := range t.Fields {
+		if err := s.add(f.Type); err != nil {
+			return err
+		}
+		for _, arg := range f.Args {
+			if err := s.add(arg.Type); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func serializeString(v interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), nil
+	}
+	if s, ok := v.(fmt.Stringer); ok {
+		return s.String(), nil
+	}
+	return nil, fmt.Errorf("cannot represent %T as String", v)
+}
+
+func serializeInt(v interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+	var n int64
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > math.MaxInt32 {
+			return nil, fmt.Errorf("Int cannot represent %d, use Float", rv.Uint())
+		}
+		n = int64(rv.Uint())
+	default:
+		return nil, fmt.Errorf("cannot represent %T as Int", v)
+	}
+	if n > math.MaxInt32 || n < math.MinInt32 {
+		return nil, fmt.Errorf("Int cannot represent %d, use Float", n)
+	}
+	return n, nil
+}
+
+func serializeFloat(v interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), nil
+	}
+	return nil, fmt.Errorf("cannot represent %T as Float", v)
+}
+
+func serializeBoolean(v interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Bool {
+		return rv.Bool(), nil
+	}
+	return nil, fmt.Errorf("cannot represent %T as Boolean", v)
+}
+
+func serializeTime(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t.Format(time.RFC3339Nano), nil
+	case *time.Time:
+		return t.Format(time.RFC3339Nano), nil
+	case string:
+		return t, nil
+	}
+	return nil, fmt.Errorf("cannot represent %T as Time", v)
+}
+
+func parseString(v interface{}) (interface{}, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("String cannot represent a non-string value: %s", inputString(v))
+}
+
+func parseID(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		if v == math.Trunc(v) {
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		}
+	}
+	return nil, fmt.Errorf("ID cannot represent value: %s", inputString(v))
+}
+
+func parseInt(v interface{}) (interface{}, error) {
+	var n int64
+	switch v := v.(type) {
+	case int64:
+		n = v
+	case float64:
+		// JSON variables are decoded as floats
+		if v != math.Trunc(v) {
+			return nil, fmt.Errorf("Int cannot represent non-integer value: %s", inputString(v))
+		}
+		n = int64(v)
+	default:
+		return nil, fmt.Errorf("Int cannot represent non-integer value: %s", inputString(v))
+	}
+	if n > math.MaxInt32 || n < math.MinInt32 {
+		return nil, fmt.Errorf("Int cannot represent non 32-bit signed integer value: %d", n)
+	}
+	return int(n), nil
+}
+
+func parseFloat(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	}
+	return nil, fmt.Errorf("Float cannot represent non numeric value: %s", inputString(v))
+}
+
+func parseBoolean(v interface{}) (interface{}, error) {
+	if b, ok := v.(bool); ok {
+		return b, nil
+	}
+	return nil, fmt.Errorf("Boolean cannot represent a non boolean value: %s", inputString(v))
+}
+
+func parseTime(v interface{}) (interface{}, error) {
+	if s, ok := v.(string); ok {
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("Time cannot represent value: %s, use an RFC 3339 time", inputString(v))
+}
+
+// inputString renders an input value for error messages
+func inputString(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// resolveDefault reads a field from an object: a struct field by its JSON
+// name, embedded structs included, or a map entry. Struct fields the JSON
+// leaves out when empty (omitempty) are null when empty, except booleans.
+func resolveDefault(source interface{}, name string) (interface{}, error) {
+	rv := reflect.ValueOf(source)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+		v := rv.MapIndex(reflect.ValueOf(name).Convert(rv.Type().Key()))
+		if !v.IsValid() {
+			return nil, nil
+		}
+		return v.Interface(), nil
+	case reflect.Struct:
+		f, ok := jsonFields(rv.Type())[name]
+		if !ok {
+			return nil, fmt.Errorf("no field %s in %s", name, rv.Type())
+		}
+		v, err := rv.FieldByIndexErr(f.index)
+		if err != nil || f.omitEmpty && v.Kind() != reflect.Bool && v.IsZero() {
+			// A nil embedded pointer, or an empty value
+			return nil, nil
+		}
+		return v.Interface(), nil
+	}
+	return nil, fmt.Errorf("cannot read field %s of %T", name, source)
+}
+
+// jsonField is a struct field by its JSON name
+type jsonField struct {
+	index     []int
+	omitEmpty bool
+}
+
+var jsonFieldCache sync.Map // reflect.Type → map[string]jsonField
+
+// jsonFields maps the JSON names of the exported fields of a struct type,
+// promoted fields of embedded structs included, to the fields
+func jsonFields(t reflect.Type) map[string]jsonField {
+	if cached, ok := jsonFieldCache.Load(t); ok {
+		return cached.(map[string]jsonField)
+	}
+	fields := make(map[string]jsonField)
+	var walk func(t reflect.Type, index []int)
+	walk = func(t reflect.Type, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" || !f.IsExported() && !f.Anonymous {
+				continue
+			}
+			fieldIndex := append(append([]int(nil), index...), i)
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+				walk(ft, fieldIndex)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			// Shallower fields win, as in encoding/json
+			if existing, ok := fields[name]; !ok || len(existing.index) > len(fieldIndex) {
+				fields[name] = jsonField{index: fieldIndex, omitEmpty: strings.Contains(","+opts+",", ",omitempty,")}
+			}
+		}
+	}
+	walk(t, nil)
+	jsonFieldCache.Store(t, fields)
+	return fields
+}
@@ -0,0 +1,254 @@
+package graphql
+
+import "fmt"
+
+// validate checks an operation against the schema before it runs
+// (https://spec.graphql.org/October2021/#sec-Validation): fields and
+// arguments exist, required arguments are given, leaf fields have no
+// selections and objects have some, fragments exist, apply to the type
+// they're spread on and don't spread themselves, and variables are
+// defined, of input types and used where their type fits.
+func (s *Schema) validate(doc *document, op *operation) []*Error {
+	v := &validator{schema: s, doc: doc, vars: make(map[string]*varDef)}
+	if op.kind != "query" {
+		v.errorf([]Location{op.loc}, "Schema is not configured to execute %s operation.", op.kind)
+		return v.errors
+	}
+	for _, def := range op.vars {
+		if _, ok := v.vars[def.name]; ok {
+			v.errorf([]Location{def.loc}, "There can be only one variable named \"$%s\".", def.name)
+			continue
+		}
+		v.vars[def.name] = def
+		t := s.resolveType(def.typ)
+		switch {
+		case t == nil:
+			v.errorf([]Location{def.loc}, "Unknown type %q.", def.typ.name)
+		case t.named().Kind == KindObject:
+			v.errorf([]Location{def.loc}, "Variable \"$%s\" cannot be non-input type %q.", def.name, def.typ)
+		case def.def != nil:
+			if _, err := coerceLiteral(def.def, t, nil); err != nil {
+				v.errorf([]Location{def.loc}, "Variable \"$%s\" has an invalid default value: %v", def.name, err)
+			}
+		}
+	}
+	v.misplaced(op.directives, "QUERY")
+	v.selectionSet(s.query, op.selections, nil)
+	return v.errors
+}
+
+type validator struct {
+	schema *Schema
+	doc    *document
+	vars   map[string]*varDef
+	errors []*Error
+}
+
+func (v *validator) errorf(locs []Location, format string, args ...interface{}) {
+	v.errors = append(v.errors, &Error{Message: fmt.Sprintf(format, args...), Locations: locs})
+}
+
+// selectionSet validates selections on t; spreading lists the fragments
+// being expanded, to catch cycles
+func (v *validator) selectionSet(t *Type, selections []selection, spreading []string) {
+	for _, sel := range selections {
+		switch sel := sel.(type) {
+		case *field:
+			v.field(t, sel, spreading)
+		case *fragmentSpread:
+			v.directives(sel.directives)
+			f, ok := v.doc.fragments[sel.name]
+			if !ok {
+				v.errorf([]Location{sel.loc}, "Unknown fragment %q.", sel.name)
+				continue
+			}
+			cycle := false
+			for _, name := range spreading {
+				cycle = cycle || name == sel.name
+			}
+			if cycle {
+				v.errorf([]Location{sel.loc}, "Cannot spread fragment %q within itself.", sel.name)
+				continue
+			}
+			if !v.typeCondition(t, f.typeCond, f.loc) {
+				continue
+			}
+			v.misplaced(f.directives, "FRAGMENT_DEFINITION")
+			v.selectionSet(t, f.selections, append(spreading, sel.name))
+		case *inlineFragment:
+			v.directives(sel.directives)
+			if sel.typeCond != "" && !v.typeCondition(t, sel.typeCond, sel.loc) {
+				continue
+			}
+			v.selectionSet(t, sel.selections, spreading)
+		}
+	}
+}
+
+// typeCondition checks that a fragment on cond can apply to objects of type
+// t. The schema has no interfaces or unions, so that's only t itself.
+func (v *validator) typeCondition(t *Type, cond string, loc Location) bool {
+	condType, ok := v.schema.types[cond]
+	switch {
+	case !ok:
+		v.errorf([]Location{loc}, "Unknown type %q.", cond)
+		return false
+	case condType.Kind != KindObject:
+		v.errorf([]Location{loc}, "Fragment cannot condition on non composite type %q.", cond)
+		return false
+	case condType != t:
+		v.errorf([]Location{loc}, "Fragment cannot be spread here as objects of type %q can never be of type %q.", t.Name, cond)
+		return false
+	}
+	return true
+}
+
+func (v *validator) field(t *Type, f *field, spreading []string) {
+	def := v.schema.fieldDef(t, f.name)
+	if def == nil {
+		v.errorf([]Location{f.loc}, "Cannot query field %q on type %q.", f.name, t.Name)
+		return
+	}
+	v.directives(f.directives)
+	v.arguments(f.args, def.Args, fmt.Sprintf("field \"%s.%s\"", t.Name, f.name), f.loc)
+
+	named := def.Type.named()
+	switch {
+	case named.isLeaf() && len(f.selections) > 0:
+		v.errorf([]Location{f.loc}, "Field %q must not have a selection since type %q has no subfields.", f.name, def.Type)
+	case !named.isLeaf() && len(f.selections) == 0:
+		v.errorf([]Location{f.loc}, "Field %q of type %q must have a selection of subfields. Did you mean \"%s { ... }\"?", f.name, def.Type, f.name)
+	case !named.isLeaf():
+		v.selectionSet(named, f.selections, spreading)
+	}
+}
+
+func (v *validator) arguments(written []*argument, defs []*Arg, of string, loc Location) {
+	for _, arg := range written {
+		var def *Arg
+		for _, d := range defs {
+			if d.Name == arg.name {
+				def = d
+			}
+		}
+		if def == nil {
+			v.errorf([]Location{arg.loc}, "Unknown argument %q on %s.", arg.name, of)
+			continue
+		}
+		v.value(arg.value, def.Type, def.Default != nil, arg.loc)
+	}
+	for _, def := range defs {
+		if def.Type.Kind != KindNonNull || def.Default != nil {
+			continue
+		}
+		given := false
+		for _, arg := range written {
+			given = given || arg.name == def.Name
+		}
+		if !given {
+			v.errorf([]Location{loc}, "Argument %q of type %q is required on %s, but it was not provided.", def.Name, def.Type, of)
+		}
+	}
+}
+
+// value checks a written value against the type of its argument, and the
+// variables in it against their definitions
+func (v *validator) value(val value, t *Type, hasDefault bool, loc Location) {
+	switch val := val.(type) {
+	case variable:
+		def, ok := v.vars[string(val)]
+		if !ok {
+			v.errorf([]Location{loc}, "Variable \"$%s\" is not defined.", val)
+			return
+		}
+		varType := v.schema.resolveType(def.typ)
+		if varType == nil {
+			return
+		}
+		// A nullable variable with a default fits a non-null argument, and
+		// so does one passed to an argument with a default
+		if t.Kind == KindNonNull && varType.Kind != KindNonNull && (def.def != nil || hasDefault) {
+			t = t.OfType
+		}
+		if !fits(varType, t) {
+			v.errorf([]Location{def.loc, loc}, "Variable \"$%s\" of type %q used in position expecting type %q.", val, varType, t)
+		}
+		return
+	case listValue:
+		inner := t
+		if inner.Kind == KindNonNull {
+			inner = inner.OfType
+		}
+		if inner.Kind == KindList {
+			for _, item := range val {
+				v.value(item, inner.OfType, false, loc)
+			}
+			return
+		}
+	}
+	if _, err := coerceLiteral(val, t, nil); err != nil && !containsVariable(val) {
+		v.errorf([]Location{loc}, "%v", err)
+	}
+}
+
+// fits reports whether a variable of type varType may be used where t is
+// expected
+func fits(varType, t *Type) bool {
+	if t.Kind == KindNonNull {
+		return varType.Kind == KindNonNull && fits(varType.OfType, t.OfType)
+	}
+	if varType.Kind == KindNonNull {
+		return fits(varType.OfType, t)
+	}
+	if t.Kind == KindList {
+		return varType.Kind == KindList && fits(varType.OfType, t.OfType)
+	}
+	return varType.Kind != KindList && varType.Name == t.Name
+}
+
+func containsVariable(val value) bool {
+	switch val := val.(type) {
+	case variable:
+		return true
+	case listValue:
+		for _, item := range val {
+			if containsVariable(item) {
+				return true
+			}
+		}
+	case objectValue:
+		for _, f := range val {
+			if containsVariable(f.value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v *validator) directives(directives []*directive) {
+	seen := make(map[string]bool)
+	for _, d := range directives {
+		def := directiveDef(d.name)
+		if def == nil {
+			v.errorf([]Location{d.loc}, "Unknown directive \"@%s\".", d.name)
+			continue
+		}
+		if seen[d.name] {
+			v.errorf([]Location{d.loc}, "The directive \"@%s\" can only be used once at this location.", d.name)
+		}
+		seen[d.name] = true
+		v.arguments(d.args, def.Args, fmt.Sprintf("directive \"@%s\"", d.name), d.loc)
+	}
+}
+
+// misplaced reports directives at a location none of them applies to
+func (v *validator) misplaced(directives []*directive, location string) {
+	for _, d := range directives {
+		if directiveDef(d.name) == nil {
+			v.errorf([]Location{d.loc}, "Unknown directive \"@%s\".", d.name)
+			continue
+		}
+		v.errorf([]Location{d.loc}, "Directive \"@%s\" may not be used on %s.", d.name, location)
+	}
+}
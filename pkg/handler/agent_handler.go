@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/cluster"
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/fleet"
+	"github.com/ercansavas/gonder/pkg/forward"
+)
+
+// AgentHandler receives batches and heartbeats from gonder agents
+type AgentHandler struct {
+	collector   *collector.LogCollector
+	fleet       *fleet.Registry
+	cluster     *cluster.Node
+	auditLogger *audit.Logger
+	token       string
+	// correctSkew moves the timestamps of agents whose clock is skewed
+	// beyond the fleet tolerance
+	correctSkew bool
+}
+
+// NewAgentHandler creates a new agent handler. When token is empty agent
+// ingestion is disabled.
+func NewAgentHandler(collector *collector.LogCollector, fleet *fleet.Registry, cluster *cluster.Node, auditLogger *audit.Logger, token string) *AgentHandler {
+	return &AgentHandler{
+		collector:   collector,
+		fleet:       fleet,
+		cluster:     cluster,
+		auditLogger: auditLogger,
+		token:       token,
+	}
+}
+
+// SetClockSkewCorrection makes the timestamps parsed from the lines of
+// agents with a skewed clock (see fleet.Registry.SetClockSkewTolerance)
+// corrected by their measured skew
+func (ah *AgentHandler) SetClockSkewCorrection(enabled bool) {
+	ah.correctSkew = enabled
+}
+
+// authorize checks the agent bearer token
+func (ah *AgentHandler) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if ah.token == "" {
+		writeError(w, r, ErrForbidden, "Agent ingestion is disabled (AGENT_TOKEN not configured)", nil)
+		return false
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(ah.token)) != 1 {
+		ah.auditLogger.LogAuthFailure(r, "agent", fmt.Errorf("invalid agent token"), map[string]interface{}{
+			"agent_id": r.Header.Get(forward.HeaderAgentID),
+		})
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gonder-agent"`)
+		writeError(w, r, ErrUnauthorized, "Unauthorized", nil)
+		return false
+	}
+	return true
+}
+
+// Ingest accepts a batch of raw lines from an agent and runs them through
+// the local parse and output pipeline
+func (ah *AgentHandler) Ingest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodOptions {
+		methodNotAllowed(w, r)
+		return
+	}
+	if !ah.authorize(w, r) {
+		return
+	}
+	if r.Method == http.MethodOptions {
+		// Handshake: tell the agent which batch encodings we accept
+		setAcceptedEncodings(w)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if rejectInMaintenance(w, r, ah.collector) {
+		return
+	}
+	if v := r.Header.Get(forward.HeaderProtocol); v != forward.ProtocolVersion {
+		writeError(w, r, ErrInvalidRequest, fmt.Sprintf("Unsupported protocol version %q", v), map[string]interface{}{
+			"supported": forward.ProtocolVersion,
+		})
+		return
+	}
+	agentID := r.Header.Get(forward.HeaderAgentID)
+	if agentID == "" {
+		writeError(w, r, ErrInvalidRequest, "Missing "+forward.HeaderAgentID+" header", nil)
+		return
+	}
+
+	lines, err := forward.DecodeBatch(http.MaxBytesReader(w, r.Body, maxIngestBodySize), r.Header.Get("Content-Type"), r.Header.Get("Content-Encoding"))
+	if err != nil {
+		ah.auditLogger.LogError(err, "Invalid agent batch", map[string]interface{}{
+			"agent_id": agentID,
+			"batch_id": r.Header.Get(forward.HeaderBatchID),
+		})
+		if errors.Is(err, forward.ErrUnsupportedEncoding) {
+			setAcceptedEncodings(w)
+			writeError(w, r, ErrUnsupportedMediaType, err.Error(), nil)
+			return
+		}
+		limit := int64(maxIngestBodySize)
+		if errors.Is(err, forward.ErrBatchTooLarge) {
+			limit = forward.MaxBatchSize
+		}
+		writeBodyError(w, r, ErrInvalidRequest, "Invalid batch", limit, err)
+		return
+	}
+
+	// An agent that timed out waiting for our response may resend the batch,
+	// possibly to another aggregator of the cluster
+	batchID := r.Header.Get(forward.HeaderBatchID)
+	if batchID != "" {
+		seen, err := ah.cluster.SeenBatch(agentID, batchID)
+		if err != nil {
+			ah.auditLogger.LogError(err, "Batch deduplication failed", map[string]interface{}{
+				"agent_id": agentID,
+				"batch_id": batchID,
+			})
+			writeError(w, r, ErrUnavailable, "Cluster store unavailable", nil)
+			return
+		}
+		if seen {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success":   true,
+				"batch_id":  batchID,
+				"duplicate": true,
+				"accepted":  0,
+			})
+			return
+		}
+	}
+
+	ah.fleet.RecordBatch(agentID, r.Header.Get(forward.HeaderAgentVersion), r.RemoteAddr, len(lines))
+
+	var skew time.Duration
+	if ah.correctSkew {
+		if measured, skewed := ah.fleet.ClockSkew(agentID); skewed {
+			skew = measured
+		}
+	}
+
+	failures := ah.collector.DeliveryFailures()
+	skipped := ah.collector.SkippedEntries()
+	counts := map[collector.ParseStatus]int{}
+	for _, line := range lines {
+		config := collector.LogSourceConfig{
+			Name:      agentID + "/" + line.Source,
+			Source:    line.Type,
+			Path:      line.Path,
+			Enabled:   true,
+			Timezone:  line.Timezone,
+			Tags:      append(append([]string{}, line.Tags...), "agent:"+agentID),
+			ClockSkew: skew,
+		}
+		counts[ah.collector.IngestLineAt(line.Line, line.Offset, config)]++
+	}
+
+	// Only acknowledge once the outputs have the batch, so the agent keeps
+	// (and resends) anything that could get lost here
+	err = ah.collector.SyncOutputs()
+	switch {
+	case err != nil:
+	case ah.collector.DeliveryFailures() != failures:
+		err = fmt.Errorf("an output rejected entries")
+	case ah.collector.SkippedEntries() != skipped:
+		err = fmt.Errorf("an output with an open circuit skipped entries")
+	}
+	if err != nil {
+		ah.auditLogger.LogError(err, "Agent batch not delivered", map[string]interface{}{
+			"agent_id": agentID,
+			"batch_id": batchID,
+		})
+		if batchID != "" {
+			ah.cluster.ForgetBatch(agentID, batchID)
+		}
+		writeError(w, r, ErrUnavailable, "Batch could not be delivered to the outputs", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"batch_id":  batchID,
+		"accepted":  len(lines),
+		"matched":   counts[collector.ParseMatched],
+		"unmatched": counts[collector.ParseUnmatched],
+		"raw":       counts[collector.ParseRaw],
+		"skipped":   counts[collector.ParseSkipped],
+	})
+}
+
+// Heartbeat records an agent heartbeat and returns the agent's pushed
+// configuration when it is out of date
+func (ah *AgentHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	if !ah.authorize(w, r) {
+		return
+	}
+
+	var hb fleet.Heartbeat
+	// Agents may be newer than the aggregator, so unknown fields are allowed
+	if err := decodeJSON(w, r, maxJSONBodySize, false, "Invalid heartbeat", &hb); err != nil {
+		return
+	}
+	if hb.AgentID == "" {
+		hb.AgentID = r.Header.Get(forward.HeaderAgentID)
+	}
+	if hb.AgentID == "" {
+		writeError(w, r, ErrInvalidRequest, "Missing agent ID", nil)
+		return
+	}
+
+	_, wasSkewed := ah.fleet.ClockSkew(hb.AgentID)
+	response := ah.fleet.RecordHeartbeat(hb, r.RemoteAddr)
+	if skew, skewed := ah.fleet.ClockSkew(hb.AgentID); skewed != wasSkewed {
+		message := fmt.Sprintf("Clock of agent %s is off by %s", hb.AgentID, skew.Round(time.Millisecond))
+		if !skewed {
+			message = fmt.Sprintf("Clock of agent %s is back within tolerance (%s)", hb.AgentID, skew.Round(time.Millisecond))
+		}
+		ah.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: "clock_skew",
+			Message:   message,
+			Details: map[string]interface{}{
+				"agent_id":  hb.AgentID,
+				"skew":      skew.Round(time.Millisecond).String(),
+				"skewed":    skewed,
+				"corrected": ah.correctSkew && skewed,
+			},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// setAcceptedEncodings advertises the batch compressions and formats this
+// aggregator decodes
+func setAcceptedEncodings(w http.ResponseWriter) {
+	w.Header().Set("Accept-Encoding", strings.Join(forward.Compressions, ", "))
+	w.Header().Set(forward.HeaderBatchFormats, strings.Join(forward.Formats, ", "))
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ercansavas/gonder/pkg/alertmanager"
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// maxAlertBodySize caps a single Alertmanager notification
+const maxAlertBodySize = 4 * 1024 * 1024
+
+// AlertmanagerHandler receives Prometheus Alertmanager webhook notifications
+type AlertmanagerHandler struct {
+	collector   *collector.LogCollector
+	auditLogger *audit.Logger
+}
+
+// NewAlertmanagerHandler creates a new Alertmanager webhook handler
+func NewAlertmanagerHandler(collector *collector.LogCollector, auditLogger *audit.Logger) *AlertmanagerHandler {
+	return &AlertmanagerHandler{
+		collector:   collector,
+		auditLogger: auditLogger,
+	}
+}
+
+// Webhook converts the alerts of a notification into log entries
+func (ah *AlertmanagerHandler) Webhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	if rejectInMaintenance(w, r, ah.collector) {
+		return
+	}
+
+	var webhook alertmanager.Webhook
+	if err := decodeJSON(w, r, maxAlertBodySize, false, "Invalid notification", &webhook); err != nil {
+		return
+	}
+	if err := webhook.Validate(); err != nil {
+		ah.auditLogger.LogError(err, "Invalid Alertmanager notification", map[string]interface{}{
+			"receiver":    webhook.Receiver,
+			"remote_addr": r.RemoteAddr,
+		})
+		writeError(w, r, ErrInvalidRequest, "Invalid notification: "+err.Error(), nil)
+		return
+	}
+
+	firing := 0
+	for _, log := range alertmanager.ToSystemLogs(&webhook) {
+		if log.ParsedData["status"] == alertmanager.StatusFiring {
+			firing++
+		}
+		ah.collector.IngestLog(log)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"accepted": len(webhook.Alerts),
+		"firing":   firing,
+		"resolved": len(webhook.Alerts) - firing,
+	})
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/analytics"
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// AnalyticsHandler answers top-N and counts-over-time questions about
+// recent entries
+type AnalyticsHandler struct {
+	tracker *analytics.Tracker // nil when disabled
+	cache   *queryCache
+}
+
+// NewAnalyticsHandler creates a new analytics handler
+func NewAnalyticsHandler(tracker *analytics.Tracker) *AnalyticsHandler {
+	return &AnalyticsHandler{tracker: tracker}
+}
+
+// SetCache keeps up to size results of top and histogram queries for ttl;
+// a zero ttl disables caching
+func (ah *AnalyticsHandler) SetCache(ttl time.Duration, size int) {
+	ah.cache = newQueryCache(ttl, size)
+}
+
+// Top serves GET /api/logs/top?dimension=service&window=1h&level=error&limit=10
+func (ah *AnalyticsHandler) Top(w http.ResponseWriter, r *http.Request) {
+	if !ah.ready(w, r) {
+		return
+	}
+	if result, ok := ah.cache.get(w, r); ok {
+		writeAnalytics(w, result)
+		return
+	}
+
+	params := r.URL.Query()
+	query := analytics.Query{
+		Filter:    parseFilter(r),
+		Dimension: analytics.Dimension(params.Get("dimension")),
+	}
+	if query.Dimension == "" {
+		writeError(w, r, ErrInvalidRequest, "dimension is required", map[string]interface{}{
+			"dimensions": analytics.Dimensions,
+		})
+		return
+	}
+	var ok bool
+	if query.Window, ok = parseDuration(w, r, "window", ah.tracker.Retention()); !ok {
+		return
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			writeError(w, r, ErrInvalidRequest, "limit must be a positive number", nil)
+			return
+		}
+		query.Limit = n
+	}
+
+	result, err := ah.tracker.Top(query, time.Now())
+	if err != nil {
+		writeError(w, r, ErrInvalidRequest, err.Error(), map[string]interface{}{
+			"dimensions": analytics.Dimensions,
+			"retention":  ah.tracker.Retention().String(),
+		})
+		return
+	}
+	ah.cache.put(w, r, result)
+	writeAnalytics(w, result)
+}
+
+// Histogram serves GET /api/logs/histogram?window=1h&interval=5m&source=nginx&level=error&q=timeout&split=level
+func (ah *AnalyticsHandler) Histogram(w http.ResponseWriter, r *http.Request) {
+	if !ah.ready(w, r) {
+		return
+	}
+	if result, ok := ah.cache.get(w, r); ok {
+		writeAnalytics(w, result)
+		return
+	}
+
+	params := r.URL.Query()
+	query := analytics.HistogramQuery{
+		Filter: parseFilter(r),
+		Query:  params.Get("q"),
+		Split:  params.Get("split"),
+	}
+	var ok bool
+	if query.Window, ok = parseDuration(w, r, "window", ah.tracker.Retention()); !ok {
+		return
+	}
+	if query.Interval, ok = parseDuration(w, r, "interval", 0); !ok {
+		return
+	}
+
+	result, err := ah.tracker.Histogram(query, time.Now())
+	if err != nil {
+		writeError(w, r, ErrInvalidRequest, err.Error(), map[string]interface{}{
+			"retention": ah.tracker.Retention().String(),
+		})
+		return
+	}
+	ah.cache.put(w, r, result)
+	writeAnalytics(w, result)
+}
+
+// ready rejects requests the handler can't answer
+func (ah *AnalyticsHandler) ready(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return false
+	}
+	if ah.tracker == nil {
+		writeError(w, r, ErrUnavailable, "Log analytics are disabled (TOP_RETENTION=0)", nil)
+		return false
+	}
+	return true
+}
+
+// parseFilter reads the repeatable source parameter and level
+func parseFilter(r *http.Request) analytics.Filter {
+	params := r.URL.Query()
+	filter := analytics.Filter{MinLevel: collector.LogLevel(params.Get("level"))}
+	for _, source := range params["source"] {
+		if source != "" {
+			filter.Sources = append(filter.Sources, collector.LogSource(source))
+		}
+	}
+	return filter
+}
+
+// parseDuration reads a duration parameter, writing the error response when
+// it is invalid
+func parseDuration(w http.ResponseWriter, r *http.Request, name string, fallback time.Duration) (time.Duration, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, true
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		writeError(w, r, ErrInvalidRequest, "Invalid "+name+": "+err.Error(), nil)
+		return 0, false
+	}
+	return d, true
+}
+
+func writeAnalytics(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    result,
+	})
+}
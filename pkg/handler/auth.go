@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// RequireAdmin protects an endpoint with the admin token. The token is read
+// from an "Authorization: Bearer <token>" or "X-Admin-Token" header. When no
+// admin token is configured the endpoint is disabled.
+func RequireAdmin(auditLogger *audit.Logger, adminToken string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			writeError(w, r, ErrForbidden, "Admin endpoints are disabled (ADMIN_TOKEN not configured)", nil)
+			return
+		}
+
+		token := r.Header.Get("X-Admin-Token")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			auditLogger.LogAuthFailure(r, "admin", fmt.Errorf("invalid admin token"), nil)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gonder-admin"`)
+			writeError(w, r, ErrUnauthorized, "Unauthorized", nil)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// RequireIngestToken protects a push ingestion endpoint (OTLP, webhooks)
+// with a bearer token. Unlike admin endpoints, ingestion stays open when no
+// token is configured.
+func RequireIngestToken(auditLogger *audit.Logger, ingestToken string, next http.HandlerFunc) http.HandlerFunc {
+	if ingestToken == "" {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(ingestToken)) != 1 {
+			auditLogger.LogAuthFailure(r, "ingest", fmt.Errorf("invalid ingest token"), nil)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gonder-ingest"`)
+			writeError(w, r, ErrUnauthorized, "Unauthorized", nil)
+			return
+		}
+
+		next(w, r)
+	}
+}
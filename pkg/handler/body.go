@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ercansavas/gonder/pkg/forward"
+)
+
+// Request body limits
+const (
+	// maxJSONBodySize caps configuration and control documents
+	maxJSONBodySize = 1024 * 1024
+	// maxIngestBodySize caps a single ingestion request, and separately the
+	// same request after decompression
+	maxIngestBodySize = 32 * 1024 * 1024
+)
+
+// errBodyTooLarge is returned by limitReader past its limit
+var errBodyTooLarge = errors.New("body too large")
+
+// limitReader fails with errBodyTooLarge instead of silently truncating
+// like io.LimitReader, so decompressed bodies are bounded too
+type limitReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func newLimitReader(r io.Reader, limit int64) io.Reader {
+	return &limitReader{r: r, remaining: limit}
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// Only fail if there is more data
+		var b [1]byte
+		if n, _ := l.r.Read(b[:]); n > 0 {
+			return 0, errBodyTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// isTooLarge reports whether err comes from a body limit
+func isTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr) || errors.Is(err, errBodyTooLarge) || errors.Is(err, forward.ErrBatchTooLarge)
+}
+
+// writeBodyError answers a failed body read or decode with 413 when a limit
+// was hit and 400 otherwise
+func writeBodyError(w http.ResponseWriter, r *http.Request, code ErrorCode, prefix string, limit int64, err error) {
+	if isTooLarge(err) {
+		writeError(w, r, ErrPayloadTooLarge, fmt.Sprintf("%s: body exceeds %d bytes", prefix, limit), map[string]interface{}{
+			"limit_bytes": limit,
+		})
+		return
+	}
+
+	var details interface{}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		details = map[string]interface{}{"field": strings.Trim(field, `"`)}
+	}
+	writeError(w, r, code, prefix+": "+err.Error(), details)
+}
+
+// decodeJSON decodes a single JSON document of at most limit bytes into v;
+// when that fails it writes the error response and returns the error. Strict decoding rejects
+// unknown fields so a typo in a setting is reported rather than ignored; it
+// is used for gonder's own documents, not for payloads of other tools that
+// may gain fields.
+func decodeJSON(w http.ResponseWriter, r *http.Request, limit int64, strict bool, prefix string, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+
+	err := decoder.Decode(v)
+	if err == nil {
+		var extra json.RawMessage
+		if decoder.Decode(&extra) != io.EOF {
+			err = errors.New("unexpected data after the JSON document")
+		}
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = errors.New("empty body")
+		}
+		writeBodyError(w, r, ErrInvalidJSON, prefix, limit, err)
+		return err
+	}
+	return nil
+}
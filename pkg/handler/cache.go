@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// queryCache keeps the results of expensive GET queries for a short time,
+// so dashboards refreshing every few seconds don't recompute them. Results
+// are keyed by path and normalized query parameters; errors are not cached.
+type queryCache struct {
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	results map[string]cachedResult
+}
+
+type cachedResult struct {
+	value   interface{}
+	expires time.Time
+}
+
+// newQueryCache creates a cache of up to size results kept for ttl; it
+// returns nil, which caches nothing, when either is not positive
+func newQueryCache(ttl time.Duration, size int) *queryCache {
+	if ttl <= 0 || size <= 0 {
+		return nil
+	}
+	return &queryCache{ttl: ttl, size: size, results: make(map[string]cachedResult)}
+}
+
+// cacheKey normalizes a request: parameters are sorted by name, the values
+// of repeated parameters are sorted and empty values are left out
+func cacheKey(r *http.Request) string {
+	query := r.URL.Query()
+	normalized := make(url.Values, len(query))
+	for name, values := range query {
+		var kept []string
+		for _, value := range values {
+			if value != "" {
+				kept = append(kept, value)
+			}
+		}
+		if len(kept) > 0 {
+			sort.Strings(kept)
+			normalized[name] = kept
+		}
+	}
+	return r.URL.Path + "?" + normalized.Encode()
+}
+
+// get returns the cached result of a request and sets the X-Cache header
+func (qc *queryCache) get(w http.ResponseWriter, r *http.Request) (interface{}, bool) {
+	if qc == nil {
+		return nil, false
+	}
+	key := cacheKey(r)
+	qc.mu.Lock()
+	result, ok := qc.results[key]
+	qc.mu.Unlock()
+	if ok && time.Now().Before(result.expires) {
+		w.Header().Set("X-Cache", "HIT")
+		return result.value, true
+	}
+	return nil, false
+}
+
+// put caches the result of a request and sets the X-Cache header
+func (qc *queryCache) put(w http.ResponseWriter, r *http.Request, value interface{}) {
+	if qc == nil {
+		return
+	}
+	w.Header().Set("X-Cache", "MISS")
+	key := cacheKey(r)
+	now := time.Now()
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	if len(qc.results) >= qc.size {
+		for k, result := range qc.results {
+			if !now.Before(result.expires) {
+				delete(qc.results, k)
+			}
+		}
+		// Still full: make room by dropping any one result
+		for k := range qc.results {
+			if len(qc.results) < qc.size {
+				break
+			}
+			delete(qc.results, k)
+		}
+	}
+	qc.results[key] = cachedResult{value: value, expires: now.Add(qc.ttl)}
+}
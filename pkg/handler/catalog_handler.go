@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/ercansavas/gonder/pkg/backfill"
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// CatalogPath lists the files the collector has tailed
+const CatalogPath = "/api/catalog"
+
+// CatalogHandler serves the file catalog
+type CatalogHandler struct {
+	collector  *collector.LogCollector
+	backfiller *backfill.Backfiller
+}
+
+// NewCatalogHandler creates a catalog handler
+func NewCatalogHandler(lc *collector.LogCollector, backfiller *backfill.Backfiller) *CatalogHandler {
+	return &CatalogHandler{collector: lc, backfiller: backfiller}
+}
+
+// reingestRequest narrows a re-ingest; the catalogued range is the default
+type reingestRequest struct {
+	From *int64 `json:"from,omitempty"`
+	To   *int64 `json:"to,omitempty"`
+	Rate int    `json:"rate,omitempty"`
+}
+
+// List handles GET /api/catalog: the tailed files, the most recently read
+// first, optionally of one source (?source=nginx_access)
+func (ch *CatalogHandler) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	files := ch.collector.CatalogFiles(r.URL.Query().Get("source"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    files,
+		"count":   len(files),
+	})
+}
+
+// File handles /api/catalog/{id}: GET reports the file, POST
+// /api/catalog/{id}/reingest reads it again as a backfill job
+func (ch *CatalogHandler) File(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, CatalogPath+"/"), "/")
+	if id == "" || (action != "" && action != "reingest") {
+		writeError(w, r, ErrNotFound, "No endpoint at "+r.URL.Path, nil)
+		return
+	}
+	if (action == "" && r.Method != http.MethodGet) || (action != "" && r.Method != http.MethodPost) {
+		methodNotAllowed(w, r)
+		return
+	}
+	file, ok := ch.collector.CatalogFile(id)
+	if !ok {
+		writeError(w, r, ErrNotFound, "File not found in the catalog", map[string]interface{}{"file_id": id})
+		return
+	}
+	if action == "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"data":    file,
+		})
+		return
+	}
+
+	var req reingestRequest
+	if r.ContentLength != 0 {
+		if err := decodeJSON(w, r, maxJSONBodySize, true, "Invalid re-ingest", &req); err != nil {
+			return
+		}
+	}
+	source, ok := ch.collector.GetSource(file.Source)
+	if !ok {
+		writeError(w, r, ErrConflict, "Source "+file.Source+" is no longer configured", map[string]interface{}{"file_id": id})
+		return
+	}
+	if err := collector.VerifyCatalogFile(file); err != nil {
+		writeError(w, r, ErrConflict, "File can't be re-ingested: "+err.Error(), map[string]interface{}{"file_id": id, "path": file.Path})
+		return
+	}
+	backfillReq := backfill.Request{
+		Paths:  []string{file.Path},
+		Source: source,
+		Rate:   req.Rate,
+		From:   file.FirstOffset,
+		To:     file.LastOffset,
+	}
+	if req.From != nil {
+		backfillReq.From = *req.From
+	}
+	if req.To != nil {
+		backfillReq.To = *req.To
+	}
+	record, err := ch.backfiller.Start(backfillReq)
+	if err != nil {
+		writeError(w, r, ErrInvalidRequest, "Invalid re-ingest: "+err.Error(), nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", JobsPath+"/"+record.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    record,
+	})
+}
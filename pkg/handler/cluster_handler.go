@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ercansavas/gonder/pkg/cluster"
+)
+
+// ClusterHandler exposes the aggregator cluster state
+type ClusterHandler struct {
+	node *cluster.Node
+}
+
+// NewClusterHandler creates a new cluster handler
+func NewClusterHandler(node *cluster.Node) *ClusterHandler {
+	return &ClusterHandler{
+		node: node,
+	}
+}
+
+// Status returns cluster membership and leadership
+func (ch *ClusterHandler) Status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    ch.node.Status(),
+	})
+}
@@ -0,0 +1,649 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/rules"
+)
+
+// maxBatchOperations bounds the operations of one batch request
+const maxBatchOperations = 1000
+
+// ConfigHandler changes the collector's sources and the alert rules at
+// runtime. Changes are written back to SOURCES_FILE and RULES_FILE when
+// those are set, so they survive a restart.
+type ConfigHandler struct {
+	collector *collector.LogCollector
+	engine    *rules.Engine // nil when no rules are configured
+	// setRules replaces the rules of engine
+	setRules    func([]rules.RuleConfig) error
+	sourcesFile string
+	rulesFile   string
+	auditLogger *audit.Logger
+
+	// mu serializes changes, so concurrent batches don't overwrite each
+	// other's results
+	mu      sync.Mutex
+	history configHistory
+}
+
+// NewConfigHandler creates a new configuration handler
+func NewConfigHandler(lc *collector.LogCollector, engine *rules.Engine, sourcesFile, rulesFile string, auditLogger *audit.Logger) *ConfigHandler {
+	ch := &ConfigHandler{
+		collector:   lc,
+		engine:      engine,
+		sourcesFile: sourcesFile,
+		rulesFile:   rulesFile,
+		auditLogger: auditLogger,
+	}
+	if engine != nil {
+		ch.setRules = engine.SetRules
+	}
+	return ch
+}
+
+// Batch actions
+const (
+	batchCreate = "create"
+	batchUpdate = "update"
+	batchDelete = "delete"
+)
+
+// batchOperation is one create, update or delete of a named item. Create
+// and update carry the item; delete only needs its name.
+type batchOperation[T any] struct {
+	Action string
+	Name   string
+	Item   *T
+}
+
+// BatchResult is the outcome of one operation of a batch
+type BatchResult struct {
+	Index  int        `json:"index"`
+	Action string     `json:"action"`
+	Name   string     `json:"name,omitempty"`
+	Status string     `json:"status"` // created, updated, deleted or failed
+	Error  *ErrorBody `json:"error,omitempty"`
+}
+
+// applyBatch runs operations against items in order, each seeing the
+// changes of those before it. Operations that fail are reported and
+// skipped. It returns the resulting items and whether any operation
+// succeeded.
+func applyBatch[T any](items []T, ops []batchOperation[T], nameOf func(T) string, validate func(T) error) ([]T, []BatchResult, bool) {
+	results := make([]BatchResult, len(ops))
+	changed := false
+	for i, op := range ops {
+		result := BatchResult{Index: i, Action: op.Action, Name: op.Name}
+		if op.Item != nil && result.Name == "" {
+			result.Name = nameOf(*op.Item)
+		}
+		index := -1
+		for j, item := range items {
+			if nameOf(item) == result.Name {
+				index = j
+				break
+			}
+		}
+
+		var code ErrorCode
+		var err error
+		switch {
+		case op.Action != batchCreate && op.Action != batchUpdate && op.Action != batchDelete:
+			code, err = ErrInvalidRequest, fmt.Errorf("unknown action %q; use create, update or delete", op.Action)
+		case result.Name == "":
+			code, err = ErrInvalidRequest, fmt.Errorf("name is required")
+		case op.Action != batchDelete && op.Item == nil:
+			code, err = ErrInvalidRequest, fmt.Errorf("%s needs the full configuration", op.Action)
+		case op.Item != nil && nameOf(*op.Item) != result.Name:
+			code, err = ErrInvalidRequest, fmt.Errorf("name %q does not match the configuration's name %q", result.Name, nameOf(*op.Item))
+		case op.Action == batchCreate && index >= 0:
+			code, err = ErrConflict, fmt.Errorf("%s already exists", result.Name)
+		case op.Action != batchCreate && index < 0:
+			code, err = ErrNotFound, fmt.Errorf("%s does not exist", result.Name)
+		case op.Action != batchDelete:
+			if err = validate(*op.Item); err != nil {
+				code = ErrInvalidRequest
+			}
+		}
+		if err != nil {
+			result.Status = "failed"
+			result.Error = &ErrorBody{Code: code, Message: err.Error()}
+			results[i] = result
+			continue
+		}
+
+		switch op.Action {
+		case batchCreate:
+			items = append(items, *op.Item)
+			result.Status = "created"
+		case batchUpdate:
+			items[index] = *op.Item
+			result.Status = "updated"
+		case batchDelete:
+			items = append(items[:index:index], items[index+1:]...)
+			result.Status = "deleted"
+		}
+		results[i] = result
+		changed = true
+	}
+	return items, results, changed
+}
+
+// sourceBatch is the body of POST /api/logs/sources/batch
+type sourceBatch struct {
+	Operations []struct {
+		Action string                     `json:"action"`
+		Name   string                     `json:"name,omitempty"`
+		Source *collector.LogSourceConfig `json:"source,omitempty"`
+	} `json:"operations"`
+	// Atomic applies the operations only if all of them succeed
+	Atomic bool `json:"atomic,omitempty"`
+}
+
+// SourcesBatch creates, updates and deletes many sources in one call:
+//
+//	{"operations": [
+//	  {"action": "create", "source": {"name": "nginx_access", ...}},
+//	  {"action": "update", "source": {"name": "syslog", ...}},
+//	  {"action": "delete", "name": "old_app"}
+//	]}
+//
+// Every operation gets its own result; failed ones are skipped unless the
+// batch is atomic, in which case nothing is applied. The collector is
+// restarted once with the resulting sources. If-Match must name the
+// configuration revision the batch is based on, see Version.
+func (ch *ConfigHandler) SourcesBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	var batch sourceBatch
+	if err := decodeJSON(w, r, maxJSONBodySize, true, "Invalid batch", &batch); err != nil {
+		return
+	}
+	if !checkBatchSize(w, r, len(batch.Operations)) {
+		return
+	}
+	ops := make([]batchOperation[collector.LogSourceConfig], len(batch.Operations))
+	for i, op := range batch.Operations {
+		ops[i] = batchOperation[collector.LogSourceConfig]{Action: op.Action, Name: op.Name, Item: op.Source}
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if !ch.checkVersion(w, r) {
+		return
+	}
+	before := ch.current()
+	sources, results, changed := applyBatch(ch.collector.GetSources(), ops,
+		func(s collector.LogSourceConfig) string { return s.Name },
+		collector.LogSourceConfig.Validate)
+	if !ch.batchApplicable(w, r, results, batch.Atomic) {
+		return
+	}
+	if changed {
+		if err := ch.collector.ReplaceSources(sources); err != nil {
+			writeError(w, r, ErrInvalidRequest, "No changes were applied: "+err.Error(), nil)
+			return
+		}
+		if ch.sourcesFile != "" {
+			if err := collector.SaveSourcesFile(ch.sourcesFile, sources); err != nil {
+				ch.auditLogger.LogError(err, "Sources file could not be saved", map[string]interface{}{"path": ch.sourcesFile})
+			}
+		}
+		ch.logBatch("source", "Source", results)
+		ch.recordRevision(r, before, ConfigOriginAPI, r.URL.Path)
+	}
+	w.Header().Set("ETag", versionETag(ch.Version()))
+	writeBatchResults(w, results)
+}
+
+// ruleBatch is the body of POST /api/rules/batch
+type ruleBatch struct {
+	Operations []struct {
+		Action string            `json:"action"`
+		Name   string            `json:"name,omitempty"`
+		Rule   *rules.RuleConfig `json:"rule,omitempty"`
+	} `json:"operations"`
+	// Atomic applies the operations only if all of them succeed
+	Atomic bool `json:"atomic,omitempty"`
+}
+
+// RulesBatch creates, updates and deletes many alert rules in one call,
+// like SourcesBatch with "rule" in place of "source". Rules are swapped
+// without pausing evaluation; unchanged rules keep their counters.
+func (ch *ConfigHandler) RulesBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	if ch.engine == nil {
+		writeError(w, r, ErrConflict, "Alert rules are disabled; set RULES_FILE to enable them", nil)
+		return
+	}
+	var batch ruleBatch
+	if err := decodeJSON(w, r, maxJSONBodySize, true, "Invalid batch", &batch); err != nil {
+		return
+	}
+	if !checkBatchSize(w, r, len(batch.Operations)) {
+		return
+	}
+	ops := make([]batchOperation[rules.RuleConfig], len(batch.Operations))
+	for i, op := range batch.Operations {
+		ops[i] = batchOperation[rules.RuleConfig]{Action: op.Action, Name: op.Name, Item: op.Rule}
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if !ch.checkVersion(w, r) {
+		return
+	}
+	before := ch.current()
+	configs, results, changed := applyBatch(ch.engine.Configs(), ops,
+		func(c rules.RuleConfig) string { return c.Name },
+		ch.engine.Validate)
+	if !ch.batchApplicable(w, r, results, batch.Atomic) {
+		return
+	}
+	if changed {
+		if err := ch.setRules(configs); err != nil {
+			writeError(w, r, ErrInvalidRequest, "No changes were applied: "+err.Error(), nil)
+			return
+		}
+		if err := rules.SaveFile(ch.rulesFile, configs); err != nil {
+			ch.auditLogger.LogError(err, "Rules file could not be saved", map[string]interface{}{"path": ch.rulesFile})
+		}
+		ch.logBatch("rule", "Alert rule", results)
+		ch.recordRevision(r, before, ConfigOriginAPI, r.URL.Path)
+	}
+	w.Header().Set("ETag", versionETag(ch.Version()))
+	writeBatchResults(w, results)
+}
+
+// checkBatchSize rejects empty and oversized batches
+func checkBatchSize(w http.ResponseWriter, r *http.Request, n int) bool {
+	if n == 0 || n > maxBatchOperations {
+		writeError(w, r, ErrInvalidRequest, fmt.Sprintf("A batch needs 1 to %d operations", maxBatchOperations), map[string]interface{}{"operations": n})
+		return false
+	}
+	return true
+}
+
+// batchApplicable rejects an atomic batch with failed operations, with the
+// results in the error details
+func (ch *ConfigHandler) batchApplicable(w http.ResponseWriter, r *http.Request, results []BatchResult, atomic bool) bool {
+	if !atomic {
+		return true
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Error != nil {
+			failed++
+		}
+	}
+	if failed == 0 {
+		return true
+	}
+	writeError(w, r, ErrInvalidRequest, fmt.Sprintf("No changes were applied: %d of %d operations failed", failed, len(results)), map[string]interface{}{
+		"results": results,
+	})
+	return false
+}
+
+// logBatch records an audit event per applied operation, e.g.
+// source_created or rule_deleted
+func (ch *ConfigHandler) logBatch(kind, label string, results []BatchResult) {
+	for _, result := range results {
+		if result.Error != nil {
+			continue
+		}
+		ch.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: audit.EventType(kind + "_" + result.Status),
+			Message:   fmt.Sprintf("%s %s %s", label, result.Name, result.Status),
+			Details:   map[string]interface{}{kind: result.Name},
+		})
+	}
+}
+
+// writeBatchResults answers a batch with the result of every operation
+func writeBatchResults(w http.ResponseWriter, results []BatchResult) {
+	failed := 0
+	for _, result := range results {
+		if result.Error != nil {
+			failed++
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": failed == 0,
+		"applied": len(results) - failed,
+		"failed":  failed,
+		"results": results,
+	})
+}
+
+// DesiredConfig is the state managed declaratively through /api/config:
+// the collector's sources and the alert rules. An omitted list is left as
+// it is.
+type DesiredConfig struct {
+	Sources []collector.LogSourceConfig `json:"sources,omitempty"`
+	Rules   []rules.RuleConfig          `json:"rules,omitempty"`
+}
+
+// ConfigChanges lists what applying a DesiredConfig adds, changes and
+// removes, by name
+type ConfigChanges struct {
+	Added     []string `json:"added"`
+	Changed   []string `json:"changed"`
+	Removed   []string `json:"removed"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// empty reports whether applying changes nothing
+func (c ConfigChanges) empty() bool {
+	return len(c.Added) == 0 && len(c.Changed) == 0 && len(c.Removed) == 0
+}
+
+// diffNamed compares the current items with the desired ones by name; an
+// item changed when its JSON differs
+func diffNamed[T any](current, desired []T, nameOf func(T) string) ConfigChanges {
+	changes := ConfigChanges{Added: []string{}, Changed: []string{}, Removed: []string{}}
+	existing := make(map[string][]byte, len(current))
+	for _, item := range current {
+		data, _ := json.Marshal(item)
+		existing[nameOf(item)] = data
+	}
+	wanted := make(map[string]bool, len(desired))
+	for _, item := range desired {
+		name := nameOf(item)
+		wanted[name] = true
+		data, _ := json.Marshal(item)
+		switch old, ok := existing[name]; {
+		case !ok:
+			changes.Added = append(changes.Added, name)
+		case !bytes.Equal(old, data):
+			changes.Changed = append(changes.Changed, name)
+		default:
+			changes.Unchanged++
+		}
+	}
+	for _, item := range current {
+		if name := nameOf(item); !wanted[name] {
+			changes.Removed = append(changes.Removed, name)
+		}
+	}
+	return changes
+}
+
+// Config serves GET /api/config: the current sources and rules in the form
+// Apply takes, so a tool can read the state it manages. The ETag is the
+// configuration revision.
+func (ch *ConfigHandler) Config(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if version := ch.Version(); version > 0 {
+		w.Header().Set("ETag", versionETag(version))
+	}
+	response := map[string]interface{}{
+		"success": true,
+		"sources": ch.collector.GetSources(),
+	}
+	if ch.engine != nil {
+		response["rules"] = ch.engine.Configs()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Apply serves POST /api/config/apply. It takes the complete desired set of
+// sources and/or rules and reconciles the running state with it: items are
+// added, changed or removed by name so that afterwards exactly the given
+// ones exist. The whole document is validated first and either applied
+// completely or not at all; applying the same document again changes
+// nothing. With dry_run=true only the planned changes are reported;
+// otherwise If-Match must name the configuration revision the document is
+// based on, see Version.
+func (ch *ConfigHandler) Apply(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	var raw struct {
+		DesiredConfig
+		Outputs json.RawMessage `json:"outputs,omitempty"`
+	}
+	if err := decodeJSON(w, r, maxJSONBodySize, true, "Invalid configuration", &raw); err != nil {
+		return
+	}
+	desired := raw.DesiredConfig
+	if raw.Outputs != nil {
+		writeError(w, r, ErrInvalidRequest, "Outputs are configured through the environment and can't be applied", nil)
+		return
+	}
+	if desired.Sources == nil && desired.Rules == nil {
+		writeError(w, r, ErrInvalidRequest, "Give the desired sources, rules or both", nil)
+		return
+	}
+	if desired.Rules != nil && ch.engine == nil {
+		writeError(w, r, ErrConflict, "Alert rules are disabled; set RULES_FILE to enable them", nil)
+		return
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if !dryRun && !ch.checkVersion(w, r) {
+		return
+	}
+	before := ch.current()
+	changes, problems := ch.plan(desired)
+	if len(problems) > 0 {
+		writeError(w, r, ErrInvalidRequest, "No changes were applied: the configuration is invalid", map[string]interface{}{
+			"problems": problems,
+		})
+		return
+	}
+
+	if !dryRun {
+		sourcesErr, rulesErr := ch.applyPlanned(desired, changes, true)
+		ch.recordRevision(r, before, ConfigOriginAPI, r.URL.Path)
+		if sourcesErr != nil {
+			writeError(w, r, ErrInvalidRequest, "No changes were applied: "+sourcesErr.Error(), nil)
+			return
+		}
+		if rulesErr != nil {
+			writeError(w, r, ErrInternal, "No changes were applied: the rules failed: "+rulesErr.Error(), nil)
+			return
+		}
+	}
+
+	w.Header().Set("ETag", versionETag(ch.Version()))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"dry_run": dryRun,
+		"changes": changes,
+	})
+}
+
+// Reload re-reads SOURCES_FILE and RULES_FILE and applies them like
+// Apply, so files changed by hand or by configuration management take
+// effect without a restart. via tells what triggered it, e.g. SIGHUP; r is
+// nil when it wasn't a request. Files that are not configured are left
+// out, and a file that can't be read or is invalid changes nothing.
+func (ch *ConfigHandler) Reload(r *http.Request, via string) (map[string]ConfigChanges, error) {
+	var desired DesiredConfig
+	if ch.sourcesFile != "" {
+		sources, err := collector.LoadSourcesFile(ch.sourcesFile)
+		if err != nil {
+			return nil, err
+		}
+		desired.Sources = sources
+	}
+	if ch.engine != nil && ch.rulesFile != "" {
+		configs, err := rules.LoadFile(ch.rulesFile)
+		if err != nil {
+			return nil, err
+		}
+		desired.Rules = configs
+	}
+	if desired.Sources == nil && desired.Rules == nil {
+		return nil, fmt.Errorf("neither SOURCES_FILE nor RULES_FILE is set")
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	before := ch.current()
+	changes, problems := ch.plan(desired)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("the configuration files are invalid: %s", strings.Join(problems, "; "))
+	}
+	sourcesErr, rulesErr := ch.applyPlanned(desired, changes, false)
+	ch.recordRevision(r, before, ConfigOriginFile, via)
+	if sourcesErr != nil {
+		return nil, sourcesErr
+	}
+	if rulesErr != nil {
+		return nil, rulesErr
+	}
+	return changes, nil
+}
+
+// ReloadFiles serves POST /api/config/reload, a Reload of the
+// configuration files
+func (ch *ConfigHandler) ReloadFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	changes, err := ch.Reload(r, r.URL.Path)
+	if err != nil {
+		ch.auditLogger.LogError(err, "Configuration reload failed", nil)
+		writeError(w, r, ErrInvalidRequest, "Configuration reload failed: "+err.Error(), map[string]interface{}{
+			"changes": changes,
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"changes": changes,
+	})
+}
+
+// applyPlanned puts the parts of desired that plan found changes in into
+// effect, sources first, and logs the changed items. When the rules fail
+// the previous sources are restored, so either both parts change or
+// neither. With save the applied parts are written back to SOURCES_FILE
+// and RULES_FILE.
+func (ch *ConfigHandler) applyPlanned(desired DesiredConfig, changes map[string]ConfigChanges, save bool) (sourcesErr, rulesErr error) {
+	sourceChanges, ruleChanges := changes["sources"], changes["rules"]
+	if !sourceChanges.empty() {
+		previous := ch.collector.GetSources()
+		if err := ch.collector.ReplaceSources(desired.Sources); err != nil {
+			return err, nil
+		}
+		if !ruleChanges.empty() {
+			if err := ch.setRules(desired.Rules); err != nil {
+				if restoreErr := ch.collector.ReplaceSources(previous); restoreErr != nil {
+					ch.auditLogger.LogError(restoreErr, "Previous sources could not be restored", nil)
+				}
+				return nil, err
+			}
+		}
+	} else if !ruleChanges.empty() {
+		if err := ch.setRules(desired.Rules); err != nil {
+			return nil, err
+		}
+	}
+
+	if !sourceChanges.empty() {
+		if save && ch.sourcesFile != "" {
+			if err := collector.SaveSourcesFile(ch.sourcesFile, desired.Sources); err != nil {
+				ch.auditLogger.LogError(err, "Sources file could not be saved", map[string]interface{}{"path": ch.sourcesFile})
+			}
+		}
+		ch.logBatch("source", "Source", changeResults(sourceChanges))
+	}
+	if !ruleChanges.empty() {
+		if save {
+			if err := rules.SaveFile(ch.rulesFile, desired.Rules); err != nil {
+				ch.auditLogger.LogError(err, "Rules file could not be saved", map[string]interface{}{"path": ch.rulesFile})
+			}
+		}
+		ch.logBatch("rule", "Alert rule", changeResults(ruleChanges))
+	}
+	if !sourceChanges.empty() || !ruleChanges.empty() {
+		ch.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: "config_applied",
+			Message:   "Declarative configuration applied",
+			Details:   map[string]interface{}{"changes": changes},
+		})
+	}
+	return nil, nil
+}
+
+// plan validates the given parts of desired and compares them with the
+// running configuration. It returns the changes by part and the problems
+// that keep desired from being applied.
+func (ch *ConfigHandler) plan(desired DesiredConfig) (map[string]ConfigChanges, []string) {
+	changes := map[string]ConfigChanges{}
+	var problems []string
+	if desired.Sources != nil {
+		names := make(map[string]bool, len(desired.Sources))
+		for _, source := range desired.Sources {
+			if err := source.Validate(); err != nil {
+				problems = append(problems, err.Error())
+			} else if names[source.Name] {
+				problems = append(problems, "duplicate source name: "+source.Name)
+			}
+			names[source.Name] = true
+		}
+		changes["sources"] = diffNamed(ch.collector.GetSources(), desired.Sources,
+			func(s collector.LogSourceConfig) string { return s.Name })
+	}
+	if desired.Rules != nil {
+		names := make(map[string]bool, len(desired.Rules))
+		for _, rule := range desired.Rules {
+			if err := ch.engine.Validate(rule); err != nil {
+				problems = append(problems, err.Error())
+			} else if names[rule.Name] {
+				problems = append(problems, "duplicate rule name: "+rule.Name)
+			}
+			names[rule.Name] = true
+		}
+		changes["rules"] = diffNamed(ch.engine.Configs(), desired.Rules,
+			func(c rules.RuleConfig) string { return c.Name })
+	}
+	return changes, problems
+}
+
+// changeResults turns the changes of an apply into results for logBatch
+func changeResults(changes ConfigChanges) []BatchResult {
+	var results []BatchResult
+	for _, group := range []struct {
+		status string
+		names  []string
+	}{
+		{"created", changes.Added},
+		{"updated", changes.Changed},
+		{"deleted", changes.Removed},
+	} {
+		for _, name := range group.names {
+			results = append(results, BatchResult{Name: name, Status: group.status})
+		}
+	}
+	return results
+}
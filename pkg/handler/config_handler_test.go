@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/rules"
+)
+
+func TestConfigVersion(t *testing.T) {
+	current := map[string]int{"b": 10, "c": 10}
+	sourcesBatch := `{"operations": [{"action": "delete", "name": "c"}]}`
+	apply := `{"sources": [{"name": "b", "source": "nginx", "path": "/var/log/b.log", "enabled": true, "interval": 10}]}`
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		ifMatch string
+		status  int
+		code    ErrorCode
+		// etag is the ETag of the response, sources the intervals afterwards
+		etag    string
+		sources map[string]int
+	}{
+		{"batch", "/api/logs/sources/batch", sourcesBatch, `"4"`, http.StatusOK, "", `"5"`, map[string]int{"b": 10}},
+		{"batch with any version", "/api/logs/sources/batch", sourcesBatch, "*", http.StatusOK, "", `"5"`, map[string]int{"b": 10}},
+		{"batch with a stale version", "/api/logs/sources/batch", sourcesBatch, `"3"`, http.StatusPreconditionFailed, ErrPreconditionFailed, `"4"`, current},
+		{"batch without a version", "/api/logs/sources/batch", sourcesBatch, "", http.StatusPreconditionRequired, ErrPreconditionRequired, "", current},
+		{"batch with a weak version", "/api/logs/sources/batch", sourcesBatch, `W/"4"`, http.StatusPreconditionFailed, ErrPreconditionFailed, "", current},
+		{"apply", "/api/config/apply", apply, `"4"`, http.StatusOK, "", `"5"`, map[string]int{"b": 10}},
+		{"apply with a stale version", "/api/config/apply", apply, `"2"`, http.StatusPreconditionFailed, ErrPreconditionFailed, `"4"`, current},
+		{"apply without a version", "/api/config/apply", apply, "", http.StatusPreconditionRequired, ErrPreconditionRequired, "", current},
+		{"dry run without a version", "/api/config/apply?dry_run=true", apply, "", http.StatusOK, "", `"4"`, current},
+		{"rollback with a stale version", "/api/config/rollback/2", "", `"3"`, http.StatusPreconditionFailed, ErrPreconditionFailed, `"4"`, current},
+		{"rollback without a version", "/api/config/rollback/2", "", "", http.StatusPreconditionRequired, ErrPreconditionRequired, "", current},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ch, lc := historyHandler(t)
+			handlers := map[string]http.HandlerFunc{
+				"/api/logs/sources/batch": ch.SourcesBatch,
+				"/api/config/apply":       ch.Apply,
+				"/api/config/rollback/":   ch.Rollback,
+			}
+			req := httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body))
+			if test.ifMatch != "" {
+				req.Header.Set("If-Match", test.ifMatch)
+			}
+			rec := httptest.NewRecorder()
+			for prefix, serve := range handlers {
+				if strings.HasPrefix(req.URL.Path, prefix) {
+					serve(rec, req)
+				}
+			}
+			if rec.Code != test.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, test.status, rec.Body)
+			}
+			if test.code != "" && !strings.Contains(rec.Body.String(), `"code":"`+string(test.code)+`"`) {
+				t.Fatalf("response %s, want error %s", rec.Body, test.code)
+			}
+			if got := rec.Header().Get("ETag"); got != test.etag {
+				t.Fatalf("ETag %s, want %s", got, test.etag)
+			}
+			if got := sourceIntervals(lc); !reflect.DeepEqual(got, test.sources) {
+				t.Fatalf("sources %v, want %v", got, test.sources)
+			}
+		})
+	}
+}
+
+func TestConfigETag(t *testing.T) {
+	ch, lc := historyHandler(t)
+	lh := NewLogHandler(lc)
+	lh.SetConfigVersion(ch.Version)
+	for name, serve := range map[string]http.HandlerFunc{"/api/config": ch.Config, "/api/logs/sources": lh.GetSources} {
+		rec := httptest.NewRecorder()
+		serve(rec, httptest.NewRequest(http.MethodGet, name, nil))
+		if got := rec.Header().Get("ETag"); got != `"4"` {
+			t.Fatalf("%s: ETag %s, want the revision in effect", name, got)
+		}
+	}
+
+	// A write based on the ETag is accepted once; the second editor, based
+	// on the same ETag, is told to fetch the configuration again
+	for i, want := range []int{http.StatusOK, http.StatusPreconditionFailed} {
+		req := httptest.NewRequest(http.MethodPost, "/api/logs/sources/batch", strings.NewReader(`{"operations": [{"action": "delete", "name": "c"}]}`))
+		req.Header.Set("If-Match", `"4"`)
+		rec := httptest.NewRecorder()
+		ch.SourcesBatch(rec, req)
+		if rec.Code != want {
+			t.Fatalf("write %d: status %d, want %d: %s", i+1, rec.Code, want, rec.Body)
+		}
+	}
+}
+
+func TestApplyRulesFailure(t *testing.T) {
+	apply := `{"sources": [{"name": "b", "source": "nginx", "path": "/var/log/b.log", "enabled": true, "interval": 10}], "rules": [{"name": "errors", "min_level": "error"}]}`
+	tests := []struct {
+		name     string
+		setRules error
+		status   int
+		// sources are the intervals afterwards, rules the rule names
+		sources  map[string]int
+		rules    []string
+		revision int
+	}{
+		{"rules applied", nil, http.StatusOK, map[string]int{"b": 10}, []string{"errors"}, 5},
+		{"rules fail", errors.New("rule engine unavailable"), http.StatusInternalServerError, map[string]int{"b": 10, "c": 10}, nil, 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ch, lc := historyHandler(t)
+			engine, err := rules.New(nil, rules.Policy{}, audit.NewWithWriter(io.Discard))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ch.engine = engine
+			ch.setRules = func(configs []rules.RuleConfig) error {
+				if test.setRules != nil {
+					return test.setRules
+				}
+				return engine.SetRules(configs)
+			}
+			ch.sourcesFile = filepath.Join(t.TempDir(), "sources.json")
+			ch.rulesFile = filepath.Join(t.TempDir(), "rules.json")
+
+			req := httptest.NewRequest(http.MethodPost, "/api/config/apply", strings.NewReader(apply))
+			req.Header.Set("If-Match", versionETag(4))
+			rec := httptest.NewRecorder()
+			ch.Apply(rec, req)
+			if rec.Code != test.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, test.status, rec.Body)
+			}
+			if got := sourceIntervals(lc); !reflect.DeepEqual(got, test.sources) {
+				t.Fatalf("sources %v, want %v", got, test.sources)
+			}
+			var names []string
+			for _, rule := range engine.Configs() {
+				names = append(names, rule.Name)
+			}
+			if !reflect.DeepEqual(names, test.rules) {
+				t.Fatalf("rules %q, want %q", names, test.rules)
+			}
+			if got := ch.Version(); got != int64(test.revision) {
+				t.Fatalf("revision %d, want %d", got, test.revision)
+			}
+			// The files only change with the configuration in effect
+			for _, path := range []string{ch.sourcesFile, ch.rulesFile} {
+				if _, err := os.Stat(path); os.IsNotExist(err) == (test.setRules == nil) {
+					t.Fatalf("%s: saved %v", filepath.Base(path), err == nil)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,472 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/rules"
+)
+
+// Origins of a configuration revision
+const (
+	// ConfigOriginStartup is the configuration gonder started with
+	ConfigOriginStartup = "startup"
+	// ConfigOriginAPI is a change through /api/config/apply or a batch
+	ConfigOriginAPI = "api"
+	// ConfigOriginFile is a reload of SOURCES_FILE and RULES_FILE
+	ConfigOriginFile = "file"
+	// ConfigOriginRollback restores an earlier revision
+	ConfigOriginRollback = "rollback"
+)
+
+// maskedValue replaces secrets in configuration diffs
+const maskedValue = "[masked]"
+
+// ConfigRevision is one version of the sources and rules, with what
+// changed from the version before
+type ConfigRevision struct {
+	Revision int       `json:"revision"`
+	Time     time.Time `json:"time"`
+	Origin   string    `json:"origin"`
+	// Via is the endpoint of an api revision or the trigger of a file one
+	Via       string `json:"via,omitempty"`
+	UserID    string `json:"user_id,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	// RestoredRevision is the revision a rollback went back to
+	RestoredRevision int          `json:"restored_revision,omitempty"`
+	Changes          []ConfigDiff `json:"changes"`
+
+	// config is the complete configuration of the revision, unmasked
+	config DesiredConfig
+}
+
+// ConfigDiff is one change between two revisions. Path names the item and
+// field, e.g. sources.nginx_access.interval or rules.ssh.actions[0].url.
+// Secrets such as tokens, passwords and credentials in URLs are masked.
+type ConfigDiff struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"` // added, removed or changed
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// configHistory keeps the last revisions of the configuration
+type configHistory struct {
+	mu        sync.Mutex
+	size      int
+	last      int
+	revisions []ConfigRevision // oldest first
+}
+
+// SetHistory keeps the last size revisions of the sources and rules for
+// /api/config/history, starting with the current configuration. Zero
+// disables the history; changes are still logged as config_changed events.
+func (ch *ConfigHandler) SetHistory(size int) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.history.mu.Lock()
+	ch.history.size = size
+	ch.history.mu.Unlock()
+	ch.history.add(ConfigRevision{Time: time.Now(), Origin: ConfigOriginStartup, Changes: []ConfigDiff{}, config: ch.current()})
+}
+
+// add numbers a revision and keeps it, dropping the oldest beyond size
+func (h *configHistory) add(revision ConfigRevision) ConfigRevision {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last++
+	revision.Revision = h.last
+	if h.size <= 0 {
+		return revision
+	}
+	h.revisions = append(h.revisions, revision)
+	if len(h.revisions) > h.size {
+		h.revisions = append(h.revisions[:0:0], h.revisions[len(h.revisions)-h.size:]...)
+	}
+	return revision
+}
+
+// get returns the revision numbered n if it is still kept
+func (h *configHistory) get(n int) (ConfigRevision, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, revision := range h.revisions {
+		if revision.Revision == n {
+			return revision, true
+		}
+	}
+	return ConfigRevision{}, false
+}
+
+// version returns the number of the last revision, kept or not
+func (h *configHistory) version() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return int64(h.last)
+}
+
+// Version returns the configuration revision in effect. It is the ETag of
+// GET /api/config and /api/logs/sources, which the batches, /api/config/apply
+// and rollbacks must send back as If-Match.
+func (ch *ConfigHandler) Version() int64 {
+	return ch.history.version()
+}
+
+// checkVersion rejects a change whose If-Match doesn't name the revision
+// in effect with 412, or that has none with 428. It must be called with
+// ch.mu held, so no other change comes in between.
+func (ch *ConfigHandler) checkVersion(w http.ResponseWriter, r *http.Request) bool {
+	expected, ok := expectedVersion(w, r)
+	if !ok {
+		return false
+	}
+	if current := ch.history.version(); expected != versionAny && expected != current {
+		writeVersionMismatch(w, r, current)
+		return false
+	}
+	return true
+}
+
+// list returns up to limit revisions, newest first
+func (h *configHistory) list(limit int) []ConfigRevision {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result := make([]ConfigRevision, 0, min(limit, len(h.revisions)))
+	for i := len(h.revisions) - 1; i >= 0 && len(result) < limit; i-- {
+		result = append(result, h.revisions[i])
+	}
+	return result
+}
+
+// current returns the configuration in effect; Rules is nil when rules are
+// disabled
+func (ch *ConfigHandler) current() DesiredConfig {
+	config := DesiredConfig{Sources: ch.collector.GetSources()}
+	if ch.engine != nil {
+		config.Rules = ch.engine.Configs()
+	}
+	return config
+}
+
+// recordRevision compares the configuration in effect with before, and if
+// anything changed logs a config_changed event with the masked diff and
+// keeps the new revision. r is nil for changes not made through the API.
+func (ch *ConfigHandler) recordRevision(r *http.Request, before DesiredConfig, origin, via string) {
+	ch.record(r, before, ConfigRevision{Origin: origin, Via: via})
+}
+
+// record is recordRevision for a revision with origin, via and, for a
+// rollback, RestoredRevision set. It returns the number of the new
+// revision, or 0 when nothing changed.
+func (ch *ConfigHandler) record(r *http.Request, before DesiredConfig, revision ConfigRevision) int {
+	after := ch.current()
+	changes := diffConfig(before, after)
+	if len(changes) == 0 {
+		return 0
+	}
+	revision.Time, revision.Changes, revision.config = time.Now(), changes, after
+	if r != nil {
+		revision.UserID = audit.RequestUser(r)
+		revision.RequestID = audit.RequestID(r)
+	}
+	revision = ch.history.add(revision)
+	details := map[string]interface{}{
+		"revision": revision.Revision,
+		"origin":   revision.Origin,
+		"via":      revision.Via,
+		"changes":  changes,
+	}
+	if revision.RestoredRevision != 0 {
+		details["restored_revision"] = revision.RestoredRevision
+	}
+	ch.auditLogger.LogEvent(audit.AuditEvent{
+		EventType: "config_changed",
+		UserID:    revision.UserID,
+		RequestID: revision.RequestID,
+		Message:   fmt.Sprintf("Configuration revision %d (%s): %d changes", revision.Revision, revision.Origin, len(changes)),
+		Details:   details,
+	})
+	return revision.Revision
+}
+
+// History serves GET /api/config/history: the last revisions of the
+// sources and rules with their masked diffs, newest first. limit bounds
+// how many are returned.
+func (ch *ConfigHandler) History(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeError(w, r, ErrInvalidRequest, "limit must be a positive number", map[string]interface{}{"limit": value})
+			return
+		}
+		limit = n
+	}
+
+	revisions := ch.history.list(limit)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    revisions,
+		"count":   len(revisions),
+	})
+}
+
+// Rollback serves POST /api/config/rollback/{revision}: it restores the
+// sources and rules of a revision still in the history like
+// /api/config/apply, completely or not at all, and records the result as a
+// new revision with origin rollback. With dry_run=true only the planned
+// changes are reported; otherwise If-Match must name the revision in
+// effect, see Version.
+func (ch *ConfigHandler) Rollback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	value := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/config/rollback/"), "/")
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		writeError(w, r, ErrInvalidRequest, "The revision must be a positive number", map[string]interface{}{"revision": value})
+		return
+	}
+	target, ok := ch.history.get(n)
+	if !ok {
+		writeError(w, r, ErrNotFound, "Revision not found; only the last CONFIG_HISTORY_SIZE revisions are kept", map[string]interface{}{"revision": n})
+		return
+	}
+	desired := DesiredConfig{Sources: slices.Clone(target.config.Sources)}
+	if ch.engine != nil {
+		desired.Rules = slices.Clone(target.config.Rules)
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if !dryRun && !ch.checkVersion(w, r) {
+		return
+	}
+	before := ch.current()
+	changes, problems := ch.plan(desired)
+	if len(problems) > 0 {
+		// Sources or rules can become invalid, e.g. after a plugin parser
+		// they use was unloaded
+		writeError(w, r, ErrConflict, "No changes were applied: the revision is no longer valid", map[string]interface{}{
+			"revision": n,
+			"problems": problems,
+		})
+		return
+	}
+
+	revision := 0
+	if !dryRun {
+		sourcesErr, rulesErr := ch.applyPlanned(desired, changes, true)
+		revision = ch.record(r, before, ConfigRevision{Origin: ConfigOriginRollback, Via: r.URL.Path, RestoredRevision: n})
+		if sourcesErr != nil {
+			writeError(w, r, ErrInvalidRequest, "No changes were applied: "+sourcesErr.Error(), nil)
+			return
+		}
+		if rulesErr != nil {
+			writeError(w, r, ErrInternal, "No changes were applied: the rules failed: "+rulesErr.Error(), nil)
+			return
+		}
+	}
+
+	response := map[string]interface{}{
+		"success":           true,
+		"dry_run":           dryRun,
+		"restored_revision": n,
+		"changes":           changes,
+	}
+	if revision != 0 {
+		response["revision"] = revision
+	}
+	w.Header().Set("ETag", versionETag(ch.Version()))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// diffConfig lists the changes from before to after by item name. A part
+// that is nil in after, i.e. rules while they are disabled, is left out.
+func diffConfig(before, after DesiredConfig) []ConfigDiff {
+	changes := diffItems("sources", before.Sources, after.Sources,
+		func(s collector.LogSourceConfig) string { return s.Name })
+	if after.Rules != nil {
+		changes = append(changes, diffItems("rules", before.Rules, after.Rules,
+			func(c rules.RuleConfig) string { return c.Name })...)
+	}
+	return changes
+}
+
+// diffItems compares named items field by field through their JSON form
+func diffItems[T any](kind string, before, after []T, nameOf func(T) string) []ConfigDiff {
+	old := make(map[string]interface{}, len(before))
+	for _, item := range before {
+		old[nameOf(item)] = jsonValue(item)
+	}
+	changes := []ConfigDiff{}
+	kept := make(map[string]bool, len(after))
+	for _, item := range after {
+		name := nameOf(item)
+		kept[name] = true
+		path := kind + "." + name
+		if previous, ok := old[name]; ok {
+			changes = diffValues(changes, path, "", previous, jsonValue(item))
+		} else {
+			changes = append(changes, ConfigDiff{Path: path, Op: "added", New: maskValue("", jsonValue(item))})
+		}
+	}
+	for _, item := range before {
+		if name := nameOf(item); !kept[name] {
+			changes = append(changes, ConfigDiff{Path: kind + "." + name, Op: "removed", Old: maskValue("", old[name])})
+		}
+	}
+	return changes
+}
+
+// diffValues appends the differences of two JSON values at path; key is
+// the object key holding them, which decides whether they are masked
+func diffValues(changes []ConfigDiff, path, key string, old, new interface{}) []ConfigDiff {
+	if isSecretKey(key) {
+		// Not even which part of a secret changed is shown
+		if !reflect.DeepEqual(old, new) {
+			changes = append(changes, ConfigDiff{Path: path, Op: "changed", Old: maskedValue, New: maskedValue})
+		}
+		return changes
+	}
+	switch oldValue := old.(type) {
+	case map[string]interface{}:
+		if newValue, ok := new.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(oldValue)+len(newValue))
+			for k := range oldValue {
+				keys = append(keys, k)
+			}
+			for k := range newValue {
+				if _, ok := oldValue[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				o, inOld := oldValue[k]
+				n, inNew := newValue[k]
+				switch {
+				case !inOld:
+					changes = append(changes, ConfigDiff{Path: path + "." + k, Op: "added", New: maskValue(k, n)})
+				case !inNew:
+					changes = append(changes, ConfigDiff{Path: path + "." + k, Op: "removed", Old: maskValue(k, o)})
+				default:
+					changes = diffValues(changes, path+"."+k, k, o, n)
+				}
+			}
+			return changes
+		}
+	case []interface{}:
+		if newValue, ok := new.([]interface{}); ok {
+			for i := 0; i < max(len(oldValue), len(newValue)); i++ {
+				elementPath := fmt.Sprintf("%s[%d]", path, i)
+				switch {
+				case i >= len(oldValue):
+					changes = append(changes, ConfigDiff{Path: elementPath, Op: "added", New: maskValue(key, newValue[i])})
+				case i >= len(newValue):
+					changes = append(changes, ConfigDiff{Path: elementPath, Op: "removed", Old: maskValue(key, oldValue[i])})
+				default:
+					changes = diffValues(changes, elementPath, key, oldValue[i], newValue[i])
+				}
+			}
+			return changes
+		}
+	}
+	if reflect.DeepEqual(old, new) {
+		return changes
+	}
+	return append(changes, ConfigDiff{Path: path, Op: "changed", Old: maskValue(key, old), New: maskValue(key, new)})
+}
+
+// jsonValue converts v to its generic JSON form
+func jsonValue(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var value interface{}
+	json.Unmarshal(data, &value)
+	return value
+}
+
+// secretKeys are parts of object keys whose values are masked, e.g. a
+// webhook action's Authorization header
+var secretKeys = []string{"token", "secret", "password", "passwd", "authorization", "cookie", "credential", "api_key", "apikey", "api-key", "private_key"}
+
+// isSecretKey reports whether values under key are masked
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskValue masks the secrets in a JSON value held under key: everything
+// under a secret key, and passwords and secret query parameters of URLs
+func maskValue(key string, value interface{}) interface{} {
+	if isSecretKey(key) {
+		return maskedValue
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(v))
+		for k, element := range v {
+			masked[k] = maskValue(k, element)
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(v))
+		for i, element := range v {
+			masked[i] = maskValue(key, element)
+		}
+		return masked
+	case string:
+		if strings.Contains(v, "://") {
+			return maskURL(v)
+		}
+	}
+	return value
+}
+
+// maskURL hides the password and the values of secret query parameters
+// of a URL with xxxxx, like url.URL.Redacted
+func maskURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	query := parsed.Query()
+	masked := false
+	for name := range query {
+		if isSecretKey(name) || strings.EqualFold(name, "key") || strings.EqualFold(name, "sig") {
+			query.Set(name, "xxxxx")
+			masked = true
+		}
+	}
+	if masked {
+		parsed.RawQuery = query.Encode()
+	}
+	return parsed.Redacted()
+}
@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+func testSource(name string, interval int) collector.LogSourceConfig {
+	return collector.LogSourceConfig{Name: name, Source: collector.SourceNginx, Path: "/var/log/" + name + ".log", Enabled: true, Interval: interval}
+}
+
+// historyHandler returns a handler keeping 3 revisions that went through
+// {a:10}, {a:20 b:10}, {b:10} and {b:10 c:10}, so revision 1 was dropped
+func historyHandler(t *testing.T) (*ConfigHandler, *collector.LogCollector) {
+	t.Helper()
+	auditLogger := audit.NewWithWriter(io.Discard)
+	lc := collector.New(auditLogger)
+	t.Cleanup(func() { lc.Close() })
+	if err := lc.SetSources([]collector.LogSourceConfig{testSource("a", 10)}); err != nil {
+		t.Fatal(err)
+	}
+	ch := NewConfigHandler(lc, nil, "", "", auditLogger)
+	ch.SetHistory(3)
+	for _, sources := range [][]collector.LogSourceConfig{
+		{testSource("a", 20), testSource("b", 10)},
+		{testSource("b", 10)},
+		{testSource("b", 10), testSource("c", 10)},
+	} {
+		before := ch.current()
+		if err := lc.ReplaceSources(sources); err != nil {
+			t.Fatal(err)
+		}
+		ch.recordRevision(nil, before, ConfigOriginFile, "test")
+	}
+	return ch, lc
+}
+
+// sourceIntervals returns the interval of every configured source by name
+func sourceIntervals(lc *collector.LogCollector) map[string]int {
+	intervals := map[string]int{}
+	for _, source := range lc.GetSources() {
+		intervals[source.Name] = source.Interval
+	}
+	return intervals
+}
+
+// clashingSource is a custom source named like a source of revision 2
+type clashingSource struct{}
+
+func (clashingSource) Config() collector.LogSourceConfig {
+	return collector.LogSourceConfig{Name: "a", Source: collector.SourceCustom}
+}
+
+func (clashingSource) Run(ctx context.Context, emit func(line string)) error {
+	<-ctx.Done()
+	return nil
+}
+
+func TestConfigRollback(t *testing.T) {
+	current := map[string]int{"b": 10, "c": 10}
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		// setup runs before the request
+		setup  func(t *testing.T, lc *collector.LogCollector)
+		status int
+		code   ErrorCode
+		// sources are the intervals afterwards, revision the new revision
+		sources  map[string]int
+		revision int
+	}{
+		{
+			name: "restores a revision", method: http.MethodPost, path: "/api/config/rollback/2",
+			status: http.StatusOK, sources: map[string]int{"a": 20, "b": 10}, revision: 5,
+		},
+		{
+			name: "dry run", method: http.MethodPost, path: "/api/config/rollback/2?dry_run=true",
+			status: http.StatusOK, sources: current,
+		},
+		{
+			name: "current revision", method: http.MethodPost, path: "/api/config/rollback/4/",
+			status: http.StatusOK, sources: current,
+		},
+		{
+			name: "dropped revision", method: http.MethodPost, path: "/api/config/rollback/1",
+			status: http.StatusNotFound, code: ErrNotFound, sources: current,
+		},
+		{
+			name: "invalid revision", method: http.MethodPost, path: "/api/config/rollback/latest",
+			status: http.StatusBadRequest, code: ErrInvalidRequest, sources: current,
+		},
+		{
+			name: "zero revision", method: http.MethodPost, path: "/api/config/rollback/0",
+			status: http.StatusBadRequest, code: ErrInvalidRequest, sources: current,
+		},
+		{
+			name: "wrong method", method: http.MethodGet, path: "/api/config/rollback/2",
+			status: http.StatusMethodNotAllowed, code: ErrMethodNotAllowed, sources: current,
+		},
+		{
+			name: "sources rejected", method: http.MethodPost, path: "/api/config/rollback/2",
+			setup: func(t *testing.T, lc *collector.LogCollector) {
+				if err := lc.AddSource(clashingSource{}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			status: http.StatusBadRequest, code: ErrInvalidRequest, sources: current,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ch, lc := historyHandler(t)
+			if test.setup != nil {
+				test.setup(t, lc)
+			}
+			req := httptest.NewRequest(test.method, test.path, nil)
+			req.Header.Set("If-Match", versionETag(4))
+			rec := httptest.NewRecorder()
+			ch.Rollback(rec, req)
+			if rec.Code != test.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, test.status, rec.Body)
+			}
+			var body struct {
+				Error    ErrorBody
+				Revision int
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error.Code != test.code {
+				t.Fatalf("error code %q, want %q", body.Error.Code, test.code)
+			}
+			if body.Revision != test.revision {
+				t.Fatalf("new revision %d, want %d", body.Revision, test.revision)
+			}
+			if got := sourceIntervals(lc); !reflect.DeepEqual(got, test.sources) {
+				t.Fatalf("sources %v, want %v", got, test.sources)
+			}
+
+			latest := ch.history.list(1)[0]
+			if test.revision == 0 {
+				if latest.Revision != 4 {
+					t.Fatalf("revision %d was recorded", latest.Revision)
+				}
+				return
+			}
+			if latest.Revision != test.revision || latest.Origin != ConfigOriginRollback || latest.RestoredRevision != 2 {
+				t.Fatalf("latest revision %+v", latest)
+			}
+			var paths []string
+			for _, change := range latest.Changes {
+				paths = append(paths, fmt.Sprintf("%s %s", change.Op, change.Path))
+			}
+			if want := []string{"added sources.a", "removed sources.c"}; !reflect.DeepEqual(paths, want) {
+				t.Fatalf("changes %q, want %q", paths, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// DebugHandler contains runtime diagnostics handlers
+type DebugHandler struct {
+	collector *collector.LogCollector
+	startedAt time.Time
+}
+
+// NewDebugHandler creates a new debug handler
+func NewDebugHandler(collector *collector.LogCollector) *DebugHandler {
+	return &DebugHandler{
+		collector: collector,
+		startedAt: time.Now(),
+	}
+}
+
+// sourceQueueStatus describes how far a source is behind its file
+type sourceQueueStatus struct {
+	Name         string `json:"name"`
+	Running      bool   `json:"running"`
+	Offset       int64  `json:"offset"`
+	FileSize     int64  `json:"file_size"`
+	PendingBytes int64  `json:"pending_bytes"`
+}
+
+// Runtime returns goroutine, memory, GC and queue diagnostics
+func (dh *DebugHandler) Runtime(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	// Most recent GC pauses, newest first
+	recentPauses := []string{}
+	for i := 0; i < 10 && i < int(mem.NumGC); i++ {
+		idx := (int(mem.NumGC) - 1 - i + len(mem.PauseNs)) % len(mem.PauseNs)
+		recentPauses = append(recentPauses, time.Duration(mem.PauseNs[idx]).String())
+	}
+
+	var lastGC string
+	if mem.LastGC > 0 {
+		lastGC = time.Unix(0, int64(mem.LastGC)).Format(time.RFC3339)
+	}
+
+	sources := []sourceQueueStatus{}
+	for _, status := range dh.collector.GetSourceStatuses() {
+		pending := status.FileSize - status.Offset
+		if pending < 0 {
+			pending = 0
+		}
+		sources = append(sources, sourceQueueStatus{
+			Name:         status.Name,
+			Running:      status.Running,
+			Offset:       status.Offset,
+			FileSize:     status.FileSize,
+			PendingBytes: pending,
+		})
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"runtime": map[string]interface{}{
+			"go_version": runtime.Version(),
+			"goroutines": runtime.NumGoroutine(),
+			"gomaxprocs": runtime.GOMAXPROCS(0),
+			"num_cpu":    runtime.NumCPU(),
+			"uptime":     time.Since(dh.startedAt).Round(time.Second).String(),
+		},
+		"memory": map[string]interface{}{
+			"heap_alloc":     mem.HeapAlloc,
+			"heap_inuse":     mem.HeapInuse,
+			"heap_idle":      mem.HeapIdle,
+			"heap_objects":   mem.HeapObjects,
+			"total_alloc":    mem.TotalAlloc,
+			"sys":            mem.Sys,
+			"mallocs":        mem.Mallocs,
+			"frees":          mem.Frees,
+			"stack_inuse":    mem.StackInuse,
+			"next_gc":        mem.NextGC,
+			"gc_cpu_percent": mem.GCCPUFraction * 100,
+		},
+		"gc": map[string]interface{}{
+			"num_gc":        mem.NumGC,
+			"pause_total":   time.Duration(mem.PauseTotalNs).String(),
+			"recent_pauses": recentPauses,
+			"last_gc":       lastGC,
+		},
+		"queues": map[string]interface{}{
+			"sources": sources,
+			"outputs": dh.collector.GetOutputStatuses(),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
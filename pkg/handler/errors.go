@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// ErrorCode is the machine-readable reason of an error response. Codes are
+// stable; messages are meant for humans and may change.
+type ErrorCode string
+
+const (
+	ErrInvalidRequest       ErrorCode = "invalid_request"
+	ErrInvalidJSON          ErrorCode = "invalid_json"
+	ErrUnauthorized         ErrorCode = "unauthorized"
+	ErrForbidden            ErrorCode = "forbidden"
+	ErrCSRFFailed           ErrorCode = "csrf_failed"
+	ErrReadOnly             ErrorCode = "read_only"
+	ErrNotFound             ErrorCode = "not_found"
+	ErrMethodNotAllowed     ErrorCode = "method_not_allowed"
+	ErrConflict             ErrorCode = "conflict"
+	ErrIdempotencyKeyReused ErrorCode = "idempotency_key_reused"
+	ErrPreconditionFailed   ErrorCode = "precondition_failed"
+	ErrPreconditionRequired ErrorCode = "precondition_required"
+	ErrPayloadTooLarge      ErrorCode = "payload_too_large"
+	ErrUnsupportedMediaType ErrorCode = "unsupported_media_type"
+	ErrInternal             ErrorCode = "internal_error"
+	ErrNotImplemented       ErrorCode = "not_implemented"
+	ErrUnavailable          ErrorCode = "unavailable"
+)
+
+// ErrorCodeInfo documents an error code
+type ErrorCodeInfo struct {
+	Code        ErrorCode `json:"code"`
+	Status      int       `json:"status"`
+	Description string    `json:"description"`
+}
+
+// ErrorCatalog lists every error code with its HTTP status
+var ErrorCatalog = []ErrorCodeInfo{
+	{ErrInvalidRequest, http.StatusBadRequest, "The request is malformed or fails validation"},
+	{ErrInvalidJSON, http.StatusBadRequest, "The request body is not valid JSON for this endpoint"},
+	{ErrUnauthorized, http.StatusUnauthorized, "The bearer token is missing or wrong"},
+	{ErrForbidden, http.StatusForbidden, "The endpoint is disabled because its token is not configured"},
+	{ErrCSRFFailed, http.StatusForbidden, "A session request that changes something lacks the session's CSRF token or comes from another site"},
+	{ErrReadOnly, http.StatusForbidden, "The server runs in read-only mode and rejects changes"},
+	{ErrNotFound, http.StatusNotFound, "The endpoint or resource does not exist"},
+	{ErrMethodNotAllowed, http.StatusMethodNotAllowed, "The endpoint does not support this HTTP method"},
+	{ErrConflict, http.StatusConflict, "The request conflicts with the current state, e.g. starting a running collector"},
+	{ErrPreconditionFailed, http.StatusPreconditionFailed, "If-Match does not name the current version of the resource"},
+	{ErrIdempotencyKeyReused, http.StatusUnprocessableEntity, "The Idempotency-Key was already used with a different request"},
+	{ErrPayloadTooLarge, http.StatusRequestEntityTooLarge, "The request body exceeds the endpoint's size limit"},
+	{ErrUnsupportedMediaType, http.StatusUnsupportedMediaType, "The Content-Type is not accepted by this endpoint"},
+	{ErrPreconditionRequired, http.StatusPreconditionRequired, "Changing the resource requires an If-Match header"},
+	{ErrInternal, http.StatusInternalServerError, "The server failed to complete the request"},
+	{ErrNotImplemented, http.StatusNotImplemented, "The feature is not compiled into this build"},
+	{ErrUnavailable, http.StatusServiceUnavailable, "A dependency is temporarily unavailable; retry later"},
+}
+
+// errorStatus maps codes to their HTTP status
+var errorStatus = func() map[ErrorCode]int {
+	m := make(map[ErrorCode]int, len(ErrorCatalog))
+	for _, info := range ErrorCatalog {
+		m[info.Code] = info.Status
+	}
+	return m
+}()
+
+// ErrorBody is the "error" object of an error response
+type ErrorBody struct {
+	Code      ErrorCode   `json:"code"`
+	Message   string      `json:"message"`
+	RequestID string      `json:"request_id,omitempty"`
+	Details   interface{} `json:"details,omitempty"`
+}
+
+// writeError sends the JSON error envelope
+// {"success": false, "error": {"code", "message", "request_id", "details"}}
+// with the status of code
+func writeError(w http.ResponseWriter, r *http.Request, code ErrorCode, message string, details interface{}) {
+	status, ok := errorStatus[code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"error": ErrorBody{
+			Code:      code,
+			Message:   message,
+			RequestID: audit.RequestID(r),
+			Details:   details,
+		},
+	})
+}
+
+// methodNotAllowed rejects a request with an unsupported method
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, ErrMethodNotAllowed, "Method not allowed", map[string]interface{}{
+		"method": r.Method,
+	})
+}
+
+// Errors returns the error-code catalog
+func (h *Handler) Errors(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    ErrorCatalog,
+		"count":   len(ErrorCatalog),
+	})
+}
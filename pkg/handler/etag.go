@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// versionETag is the ETag of a resource version
+func versionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// Preconditions of a change, see expectedVersion
+const (
+	// versionAny is If-Match: *, any existing version
+	versionAny int64 = -1
+	// versionNone is If-None-Match: *, no resource yet
+	versionNone int64 = 0
+)
+
+// expectedVersion reads the version a change of a versioned resource is
+// based on: the version in If-Match, versionAny for If-Match: * or
+// versionNone for If-None-Match: * when creating it. Changes without
+// either are rejected with 428, so concurrent editors can't overwrite each
+// other unknowingly; ok is false once the error is written.
+func expectedVersion(w http.ResponseWriter, r *http.Request) (version int64, ok bool) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	ifNoneMatch := strings.TrimSpace(r.Header.Get("If-None-Match"))
+	switch {
+	case ifMatch == "*":
+		return versionAny, true
+	case ifMatch != "":
+		unquoted, err := strconv.Unquote(ifMatch)
+		if err == nil && ifMatch[0] == '"' {
+			if version, err = strconv.ParseInt(unquoted, 10, 64); err == nil && version > 0 {
+				return version, true
+			}
+		}
+		// Weak or foreign ETags never match
+		writeError(w, r, ErrPreconditionFailed, "If-Match does not match the current version", nil)
+		return 0, false
+	case ifNoneMatch == "*":
+		return versionNone, true
+	}
+	writeError(w, r, ErrPreconditionRequired, "Send the ETag of the version you changed as If-Match, or If-None-Match: * to create", nil)
+	return 0, false
+}
+
+// writeVersionMismatch rejects a change based on an outdated version with
+// 412 and the current ETag
+func writeVersionMismatch(w http.ResponseWriter, r *http.Request, current int64) {
+	if current > 0 {
+		w.Header().Set("ETag", versionETag(current))
+	}
+	writeError(w, r, ErrPreconditionFailed, "The resource was changed in the meantime; fetch it again and reapply your change", map[string]interface{}{
+		"current_version": current,
+	})
+}
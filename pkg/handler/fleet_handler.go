@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/fleet"
+)
+
+// FleetHandler exposes agent fleet management endpoints
+type FleetHandler struct {
+	fleet       *fleet.Registry
+	auditLogger *audit.Logger
+}
+
+// NewFleetHandler creates a new fleet handler
+func NewFleetHandler(fleet *fleet.Registry, auditLogger *audit.Logger) *FleetHandler {
+	return &FleetHandler{
+		fleet:       fleet,
+		auditLogger: auditLogger,
+	}
+}
+
+// ListAgents returns every known agent with its health and lag
+func (fh *FleetHandler) ListAgents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	agents := fh.fleet.List()
+	health := map[string]int{}
+	for _, agent := range agents {
+		health[agent.Health]++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    agents,
+		"count":   len(agents),
+		"health":  health,
+	})
+}
+
+// Agent serves /api/agents/{id} and /api/agents/{id}/config. The agent ID
+// "default" in /api/agents/default/config addresses the configuration of
+// every agent without its own.
+func (fh *FleetHandler) Agent(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/agents/"), "/")
+	id, sub, _ := strings.Cut(rest, "/")
+
+	switch {
+	case id == "":
+		fh.ListAgents(w, r)
+	case sub == "config":
+		if id == "default" {
+			id = ""
+		}
+		fh.agentConfig(w, r, id)
+	case sub == "":
+		fh.agent(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// agent returns (GET) or forgets (DELETE) a single agent
+func (fh *FleetHandler) agent(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		agent, ok := fh.fleet.Get(id)
+		if !ok {
+			writeError(w, r, ErrNotFound, "Agent not found", map[string]interface{}{"agent_id": id})
+			return
+		}
+		config, overridden := fh.fleet.Config(id)
+		if !overridden {
+			config, _ = fh.fleet.Config("")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"data":    agent,
+			"config":  config,
+		})
+	case http.MethodDelete:
+		if !fh.fleet.Forget(id) {
+			writeError(w, r, ErrNotFound, "Agent not found", map[string]interface{}{"agent_id": id})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Agent removed from the fleet registry",
+		})
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// agentConfig reads, replaces or removes the configuration pushed to an
+// agent. The ETag is the configuration version; changes must send it as
+// If-Match (or If-None-Match: * to assign the first configuration).
+func (fh *FleetHandler) agentConfig(w http.ResponseWriter, r *http.Request, id string) {
+	target := id
+	if target == "" {
+		target = "default"
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		config, ok := fh.fleet.Config(id)
+		if !ok {
+			writeError(w, r, ErrNotFound, "No configuration assigned", map[string]interface{}{"agent_id": id})
+			return
+		}
+		w.Header().Set("ETag", versionETag(config.Version))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"data":    config,
+		})
+	case http.MethodPut:
+		expected, ok := fh.expectedConfigVersion(w, r, id)
+		if !ok {
+			return
+		}
+		var config fleet.AgentConfig
+		if err := decodeJSON(w, r, maxJSONBodySize, true, "Invalid configuration", &config); err != nil {
+			return
+		}
+		stored, err := fh.fleet.SetConfig(id, config, expected)
+		var mismatch *fleet.VersionMismatchError
+		switch {
+		case errors.As(err, &mismatch):
+			writeVersionMismatch(w, r, mismatch.Current)
+			return
+		case err != nil:
+			writeError(w, r, ErrInvalidRequest, "Invalid configuration: "+err.Error(), nil)
+			return
+		}
+		fh.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: "fleet_config_updated",
+			Message:   "Agent configuration updated for " + target,
+			Details: map[string]interface{}{
+				"agent":   target,
+				"version": stored.Version,
+				"sources": len(stored.Sources),
+				"filters": len(stored.Filters),
+			},
+		})
+		w.Header().Set("ETag", versionETag(stored.Version))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Configuration will be applied on the next agent heartbeat",
+			"data":    stored,
+		})
+	case http.MethodDelete:
+		expected, ok := fh.expectedConfigVersion(w, r, id)
+		if !ok {
+			return
+		}
+		err := fh.fleet.DeleteConfig(id, expected)
+		var mismatch *fleet.VersionMismatchError
+		switch {
+		case errors.As(err, &mismatch):
+			writeVersionMismatch(w, r, mismatch.Current)
+			return
+		case err != nil:
+			writeError(w, r, ErrInternal, "Failed to remove configuration: "+err.Error(), nil)
+			return
+		}
+		fh.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: "fleet_config_removed",
+			Message:   "Agent configuration removed for " + target,
+			Details:   map[string]interface{}{"agent": target},
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Configuration removed",
+		})
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// expectedConfigVersion reads the precondition of a configuration change;
+// If-Match: * stands for the version currently assigned
+func (fh *FleetHandler) expectedConfigVersion(w http.ResponseWriter, r *http.Request, id string) (int64, bool) {
+	expected, ok := expectedVersion(w, r)
+	if !ok || expected != versionAny {
+		return expected, ok
+	}
+	config, exists := fh.fleet.Config(id)
+	if !exists {
+		writeVersionMismatch(w, r, 0)
+		return 0, false
+	}
+	return config.Version, true
+}
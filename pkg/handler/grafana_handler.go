@@ -0,0 +1,302 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/analytics"
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// GrafanaPath is where the Grafana JSON data source API is served
+const GrafanaPath = "/api/grafana/"
+
+// maxGrafanaBodySize bounds Grafana request bodies
+const maxGrafanaBodySize = 1 << 20
+
+// GrafanaHandler implements the Grafana JSON (SimpleJSON) data source
+// contract over the analytics endpoints, so gonder can be charted from
+// Grafana without a database in between. A target is an analytics query
+// written like a URL: "histogram?source=nginx&split=level" becomes a time
+// series, "top?dimension=service&level=error" a table.
+type GrafanaHandler struct {
+	tracker *analytics.Tracker // nil when disabled
+}
+
+// NewGrafanaHandler creates a new Grafana data source handler
+func NewGrafanaHandler(tracker *analytics.Tracker) *GrafanaHandler {
+	return &GrafanaHandler{tracker: tracker}
+}
+
+// grafanaQuery is the body of POST /query
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	IntervalMs    int64 `json:"intervalMs"`
+	MaxDataPoints int   `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string `json:"target"`
+		RefID  string `json:"refId"`
+		Hide   bool   `json:"hide"`
+	} `json:"targets"`
+	AdhocFilters []grafanaFilter `json:"adhocFilters"`
+}
+
+// grafanaFilter is an ad hoc filter set on a dashboard
+type grafanaFilter struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+// Serve routes the data source calls below GrafanaPath
+func (gh *GrafanaHandler) Serve(w http.ResponseWriter, r *http.Request) {
+	call := strings.Trim(strings.TrimPrefix(r.URL.Path, GrafanaPath), "/")
+	if call == "" {
+		// "Save & test" in Grafana
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			methodNotAllowed(w, r)
+			return
+		}
+		if gh.tracker == nil {
+			writeError(w, r, ErrUnavailable, "Log analytics are disabled (TOP_RETENTION=0)", nil)
+			return
+		}
+		writeGrafana(w, map[string]interface{}{"status": "ok"})
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	if gh.tracker == nil {
+		writeError(w, r, ErrUnavailable, "Log analytics are disabled (TOP_RETENTION=0)", nil)
+		return
+	}
+	switch call {
+	case "search":
+		gh.search(w, r)
+	case "query":
+		gh.query(w, r)
+	case "annotations":
+		writeGrafana(w, []interface{}{})
+	case "tag-keys":
+		writeGrafana(w, []map[string]string{
+			{"type": "string", "text": "source"},
+			{"type": "string", "text": "level"},
+		})
+	case "tag-values":
+		gh.tagValues(w, r)
+	default:
+		writeError(w, r, ErrNotFound, "Unknown Grafana data source call: "+call, nil)
+	}
+}
+
+// search lists ready-made targets for the query editor
+func (gh *GrafanaHandler) search(w http.ResponseWriter, r *http.Request) {
+	targets := []string{"histogram", "histogram?split=level", "histogram?split=source"}
+	for _, dimension := range analytics.Dimensions {
+		targets = append(targets, "top?dimension="+string(dimension))
+	}
+	writeGrafana(w, targets)
+}
+
+func (gh *GrafanaHandler) tagValues(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Key string `json:"key"`
+	}
+	if err := decodeJSON(w, r, maxGrafanaBodySize, false, "Invalid tag values request", &body); err != nil {
+		return
+	}
+
+	values := []map[string]string{}
+	switch body.Key {
+	case "source":
+		for _, source := range gh.tracker.Sources(time.Now()) {
+			values = append(values, map[string]string{"text": string(source)})
+		}
+	case "level":
+		for _, level := range []collector.LogLevel{collector.LevelDebug, collector.LevelInfo, collector.LevelWarn, collector.LevelError, collector.LevelFatal} {
+			values = append(values, map[string]string{"text": string(level)})
+		}
+	}
+	writeGrafana(w, values)
+}
+
+func (gh *GrafanaHandler) query(w http.ResponseWriter, r *http.Request) {
+	var body grafanaQuery
+	if err := decodeJSON(w, r, maxGrafanaBodySize, false, "Invalid query", &body); err != nil {
+		return
+	}
+
+	now := time.Now()
+	results := []interface{}{}
+	for _, target := range body.Targets {
+		if target.Hide || target.Target == "" {
+			continue
+		}
+		kind, rawParams, _ := strings.Cut(target.Target, "?")
+		params, err := url.ParseQuery(rawParams)
+		if err != nil {
+			writeError(w, r, ErrInvalidRequest, fmt.Sprintf("Invalid target %q: %v", target.Target, err), nil)
+			return
+		}
+		filter, err := grafanaFilterOf(params, body.AdhocFilters)
+		if err != nil {
+			writeError(w, r, ErrInvalidRequest, fmt.Sprintf("Invalid target %q: %v", target.Target, err), nil)
+			return
+		}
+
+		switch kind {
+		case "histogram":
+			series, err := gh.histogram(body, params, filter, now)
+			if err != nil {
+				writeError(w, r, ErrInvalidRequest, fmt.Sprintf("Invalid target %q: %v", target.Target, err), nil)
+				return
+			}
+			results = append(results, series...)
+		case "top":
+			table, err := gh.top(body, params, filter, now)
+			if err != nil {
+				writeError(w, r, ErrInvalidRequest, fmt.Sprintf("Invalid target %q: %v", target.Target, err), nil)
+				return
+			}
+			results = append(results, table)
+		default:
+			writeError(w, r, ErrInvalidRequest, fmt.Sprintf("Invalid target %q: must start with histogram or top", target.Target), nil)
+			return
+		}
+	}
+	writeGrafana(w, results)
+}
+
+// histogram answers a histogram target with one time series per series
+func (gh *GrafanaHandler) histogram(body grafanaQuery, params url.Values, filter analytics.Filter, now time.Time) ([]interface{}, error) {
+	window := gh.window(body, now)
+	// Grafana's interval suits its pixel width; round it up to minutes and
+	// widen it when the window would need too many points
+	interval := time.Duration(body.IntervalMs) * time.Millisecond
+	if maxPoints := body.MaxDataPoints; maxPoints > 0 && maxPoints < analytics.MaxPoints {
+		interval = max(interval, window/time.Duration(maxPoints))
+	}
+	interval = max(interval, (window+analytics.MaxPoints-1)/analytics.MaxPoints)
+	interval = max((interval+time.Minute-1)/time.Minute*time.Minute, time.Minute)
+
+	result, err := gh.tracker.Histogram(analytics.HistogramQuery{
+		Filter:   filter,
+		Window:   window,
+		Interval: interval,
+		Query:    params.Get("q"),
+		Split:    params.Get("split"),
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+
+	series := make([]interface{}, 0, len(result.Series))
+	for _, s := range result.Series {
+		datapoints := make([][2]int64, 0, len(s.Points))
+		for _, p := range s.Points {
+			if !body.Range.To.IsZero() && p.Time.After(body.Range.To) {
+				continue
+			}
+			if p.Time.Add(interval).Before(body.Range.From) {
+				continue
+			}
+			datapoints = append(datapoints, [2]int64{int64(p.Count), p.Time.UnixMilli()})
+		}
+		series = append(series, map[string]interface{}{
+			"target":     s.Name,
+			"datapoints": datapoints,
+		})
+	}
+	return series, nil
+}
+
+// top answers a top target with a table
+func (gh *GrafanaHandler) top(body grafanaQuery, params url.Values, filter analytics.Filter, now time.Time) (interface{}, error) {
+	query := analytics.Query{
+		Filter:    filter,
+		Dimension: analytics.Dimension(params.Get("dimension")),
+		Window:    gh.window(body, now),
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("limit must be a positive number")
+		}
+		query.Limit = n
+	}
+	result, err := gh.tracker.Top(query, now)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([][]interface{}, 0, len(result.Top))
+	for _, item := range result.Top {
+		rows = append(rows, []interface{}{item.Key, item.Count, item.Error})
+	}
+	return map[string]interface{}{
+		"type": "table",
+		"columns": []map[string]string{
+			{"text": string(result.Dimension), "type": "string"},
+			{"text": "count", "type": "number"},
+			{"text": "error", "type": "number"},
+		},
+		"rows": rows,
+	}, nil
+}
+
+// window is how far back the dashboard's range starts, clamped to what the
+// tracker keeps; analytics windows always end now
+func (gh *GrafanaHandler) window(body grafanaQuery, now time.Time) time.Duration {
+	window := gh.tracker.Retention()
+	if !body.Range.From.IsZero() {
+		window = min(now.Sub(body.Range.From), window)
+	}
+	return max(window, time.Minute)
+}
+
+// grafanaFilterOf combines the source and level of a target with the
+// dashboard's ad hoc filters, which take precedence
+func grafanaFilterOf(params url.Values, adhoc []grafanaFilter) (analytics.Filter, error) {
+	filter := analytics.Filter{MinLevel: collector.LogLevel(params.Get("level"))}
+	for _, source := range params["source"] {
+		if source != "" {
+			filter.Sources = append(filter.Sources, collector.LogSource(source))
+		}
+	}
+	var sources []collector.LogSource
+	for _, f := range adhoc {
+		if f.Operator != "" && f.Operator != "=" {
+			return filter, fmt.Errorf("ad hoc filter on %s: only = is supported", f.Key)
+		}
+		switch f.Key {
+		case "source":
+			sources = append(sources, collector.LogSource(f.Value))
+		case "level":
+			filter.MinLevel = collector.LogLevel(f.Value)
+		default:
+			return filter, fmt.Errorf("ad hoc filter on %s: only source and level are supported", f.Key)
+		}
+	}
+	if len(sources) > 0 {
+		filter.Sources = sources
+	}
+	return filter, nil
+}
+
+// writeGrafana writes a bare JSON body; Grafana doesn't expect gonder's
+// success envelope
+func writeGrafana(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
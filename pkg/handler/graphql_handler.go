@@ -0,0 +1,587 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/alertmanager"
+	"github.com/ercansavas/gonder/pkg/analytics"
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/graphql"
+	"github.com/ercansavas/gonder/pkg/rules"
+)
+
+// GraphQLPath serves the GraphQL API
+const GraphQLPath = "/api/graphql"
+
+// GraphQLHandler answers GraphQL queries over the logs, sources, alerts and
+// statistics the REST endpoints expose one at a time, so a dashboard can
+// fetch the nested data it needs in a single request
+type GraphQLHandler struct {
+	collector *collector.LogCollector
+	tracker   *analytics.Tracker // nil when disabled
+	engine    *rules.Engine      // nil when no rules are configured
+	schema    *graphql.Schema
+}
+
+// NewGraphQLHandler creates a new GraphQL handler
+func NewGraphQLHandler(collector *collector.LogCollector, tracker *analytics.Tracker, engine *rules.Engine) *GraphQLHandler {
+	gh := &GraphQLHandler{collector: collector, tracker: tracker, engine: engine}
+	schema, err := graphql.NewSchema(gh.queryType())
+	if err != nil {
+		// The schema is fixed, so this is a programming error
+		panic(err)
+	}
+	gh.schema = schema
+	return gh
+}
+
+// Serve answers GET /api/graphql?query=...&variables=... and POST with a
+// JSON body of query, operationName and variables. Errors of the query
+// itself are reported in the GraphQL response, with 200.
+func (gh *GraphQLHandler) Serve(w http.ResponseWriter, r *http.Request) {
+	var req graphql.Request
+	switch r.Method {
+	case http.MethodGet:
+		params := r.URL.Query()
+		req.Query = params.Get("query")
+		req.OperationName = params.Get("operationName")
+		if variables := params.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				writeError(w, r, ErrInvalidJSON, "Invalid variables: "+err.Error(), nil)
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := decodeJSON(w, r, maxJSONBodySize, false, "Invalid GraphQL request", &req); err != nil {
+			return
+		}
+	default:
+		methodNotAllowed(w, r)
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		writeError(w, r, ErrInvalidRequest, "query is required", nil)
+		return
+	}
+
+	resp := gh.schema.Execute(r.Context(), req)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Enums of the schema, valued as in the REST API
+var (
+	gqlDimension = graphql.NewEnum("Dimension", "What top results are grouped by.",
+		"service", "host", "path", "status", "template")
+	gqlLevel = graphql.NewEnum("Level", "A log level, from least to most severe.",
+		"debug", "info", "warn", "error", "fatal")
+	gqlSplit       = graphql.NewEnum("Split", "How a histogram is split into series.", "level", "source")
+	gqlAlertStatus = graphql.NewEnum("AlertStatus", "The status of an Alertmanager alert.",
+		alertmanager.StatusFiring, alertmanager.StatusResolved)
+)
+
+// Counters are Float, since they can outgrow the 32 bits of Int
+var (
+	gqlCounter = graphql.NewObject("Counter", "Entries and their bytes.",
+		&graphql.Field{Name: "entries", Type: graphql.NonNull(graphql.Float)},
+		&graphql.Field{Name: "bytes", Type: graphql.NonNull(graphql.Float)},
+	)
+	gqlUsage = graphql.NewObject("Usage", "What went through the pipeline for a source or tenant.",
+		&graphql.Field{Name: "name", Type: graphql.String, Description: "The source or tenant; null for totals."},
+		&graphql.Field{Name: "ingested", Type: graphql.NonNull(gqlCounter), Description: "Lines admitted by the quotas."},
+		&graphql.Field{Name: "stored", Type: graphql.NonNull(gqlCounter), Description: "Entries written to the outputs."},
+		&graphql.Field{Name: "forwarded", Type: graphql.NonNull(gqlCounter), Description: "Lines handed to the aggregator."},
+	)
+	gqlOutputUsage = graphql.NewObject("OutputUsage", "The entries an output accepted with their encoded size.",
+		&graphql.Field{Name: "name", Type: graphql.NonNull(graphql.String)},
+		&graphql.Field{Name: "entries", Type: graphql.NonNull(graphql.Float)},
+		&graphql.Field{Name: "bytes", Type: graphql.NonNull(graphql.Float)},
+	)
+	gqlUsageDay = graphql.NewObject("UsageDay", "The usage of one UTC day.",
+		&graphql.Field{Name: "date", Type: graphql.NonNull(graphql.String)},
+		&graphql.Field{Name: "total", Type: graphql.NonNull(gqlUsage), Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			day := source.(collector.UsageDay)
+			return namedUsage{Usage: &day.Total}, nil
+		}},
+		&graphql.Field{Name: "sources", Type: graphql.NonNull(graphql.ListOf(graphql.NonNull(gqlUsage))), Resolve: usageList(func(d collector.UsageDay) map[string]*collector.Usage { return d.Sources })},
+		&graphql.Field{Name: "tenants", Type: graphql.NonNull(graphql.ListOf(graphql.NonNull(gqlUsage))), Resolve: usageList(func(d collector.UsageDay) map[string]*collector.Usage { return d.Tenants })},
+		&graphql.Field{Name: "outputs", Type: graphql.NonNull(graphql.ListOf(graphql.NonNull(gqlOutputUsage))), Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			outputs := source.(collector.UsageDay).Outputs
+			list := make([]namedCounter, 0, len(outputs))
+			for _, name := range sortedKeys(outputs) {
+				list = append(list, namedCounter{Name: name, UsageCounter: outputs[name]})
+			}
+			return list, nil
+		}},
+	)
+
+	gqlLog = graphql.NewObject("Log", "A log entry; fields the REST API leaves out when empty are null.",
+		&graphql.Field{Name: "id", Type: graphql.NonNull(graphql.ID)},
+		&graphql.Field{Name: "timestamp", Type: graphql.NonNull(graphql.Time)},
+		&graphql.Field{Name: "original_timestamp", Type: graphql.String},
+		&graphql.Field{Name: "source", Type: graphql.NonNull(graphql.String), Description: "The source type, e.g. nginx."},
+		&graphql.Field{Name: "source_name", Type: graphql.String, Description: "The source the entry was read from."},
+		&graphql.Field{Name: "level", Type: graphql.NonNull(graphql.String)},
+		&graphql.Field{Name: "message", Type: graphql.NonNull(graphql.String)},
+		&graphql.Field{Name: "host", Type: graphql.String},
+		&graphql.Field{Name: "service", Type: graphql.String},
+		&graphql.Field{Name: "pid", Type: graphql.Int},
+		&graphql.Field{Name: "user", Type: graphql.String},
+		&graphql.Field{Name: "ip", Type: graphql.String},
+		&graphql.Field{Name: "method", Type: graphql.String},
+		&graphql.Field{Name: "path", Type: graphql.String},
+		&graphql.Field{Name: "status_code", Type: graphql.Int},
+		&graphql.Field{Name: "raw_log", Type: graphql.NonNull(graphql.String)},
+		&graphql.Field{Name: "parsed_data", Type: graphql.JSON},
+		&graphql.Field{Name: "tags", Type: graphql.ListOf(graphql.NonNull(graphql.String))},
+		&graphql.Field{Name: "collected_at", Type: graphql.NonNull(graphql.Time)},
+		&graphql.Field{Name: "fingerprint", Type: graphql.String},
+	)
+	gqlLogPage = graphql.NewObject("LogPage", "A page of search results, oldest first.",
+		&graphql.Field{Name: "entries", Type: graphql.NonNull(graphql.ListOf(graphql.NonNull(gqlLog)))},
+		&graphql.Field{Name: "truncated", Type: graphql.NonNull(graphql.Boolean), Description: "More entries matched than the limit."},
+		&graphql.Field{Name: "scanned", Type: graphql.NonNull(graphql.Int)},
+		&graphql.Field{Name: "skipped", Type: graphql.NonNull(graphql.Int), Description: "Entries passed over by bloom filters."},
+		&graphql.Field{Name: "next_cursor", Type: graphql.String, Description: "Pass as cursor for the older entries."},
+	)
+	gqlLogContext = graphql.NewObject("LogContext", "An entry with the entries read before and after it from the same source.",
+		&graphql.Field{Name: "source", Type: graphql.NonNull(graphql.String)},
+		&graphql.Field{Name: "before", Type: graphql.NonNull(graphql.ListOf(graphql.NonNull(gqlLog)))},
+		&graphql.Field{Name: "entry", Type: graphql.NonNull(gqlLog)},
+		&graphql.Field{Name: "after", Type: graphql.NonNull(graphql.ListOf(graphql.NonNull(gqlLog)))},
+	)
+
+	gqlSourceRuntime = graphql.NewObject("SourceRuntime", "The live state of a source that ran.",
+		&graphql.Field{Name: "running", Type: graphql.NonNull(graphql.Boolean)},
+		&graphql.Field{Name: "offset", Type: graphql.NonNull(graphql.Float)},
+		&graphql.Field{Name: "file_size", Type: graphql.NonNull(graphql.Float)},
+		&graphql.Field{Name: "poll_interval", Type: graphql.String},
+		&graphql.Field{Name: "last_activity", Type: graphql.Time},
+		&graphql.Field{Name: "restarts", Type: graphql.NonNull(graphql.Int)},
+		&graphql.Field{Name: "last_error", Type: graphql.String},
+		&graphql.Field{Name: "last_error_at", Type: graphql.Time},
+		&graphql.Field{Name: "started_at", Type: graphql.Time},
+		&graphql.Field{Name: "paused_until", Type: graphql.Time},
+		&graphql.Field{Name: "stalled", Type: graphql.NonNull(graphql.Boolean)},
+		&graphql.Field{Name: "stalled_since", Type: graphql.Time},
+	)
+
+	gqlAlert = graphql.NewObject("Alert", "An Alertmanager alert received by the webhook.",
+		&graphql.Field{Name: "id", Type: graphql.NonNull(graphql.ID), Description: "The ID of the log entry."},
+		&graphql.Field{Name: "timestamp", Type: graphql.NonNull(graphql.Time)},
+		&graphql.Field{Name: "name", Type: graphql.String, Resolve: alertTag("alertname:")},
+		&graphql.Field{Name: "status", Type: graphql.NonNull(gqlAlertStatus), Resolve: alertTag("status:")},
+		&graphql.Field{Name: "severity", Type: graphql.String, Resolve: alertTag("severity:")},
+		&graphql.Field{Name: "level", Type: graphql.NonNull(graphql.String)},
+		&graphql.Field{Name: "message", Type: graphql.NonNull(graphql.String)},
+		&graphql.Field{Name: "host", Type: graphql.String},
+		&graphql.Field{Name: "service", Type: graphql.String},
+		&graphql.Field{Name: "labels", Type: graphql.JSON, Resolve: alertData("labels")},
+		&graphql.Field{Name: "annotations", Type: graphql.JSON, Resolve: alertData("annotations")},
+		&graphql.Field{Name: "starts_at", Type: graphql.Time, Resolve: alertData("starts_at")},
+		&graphql.Field{Name: "ends_at", Type: graphql.Time, Resolve: alertData("ends_at")},
+		&graphql.Field{Name: "fingerprint", Type: graphql.String, Resolve: alertData("fingerprint")},
+		&graphql.Field{Name: "generator_url", Type: graphql.String, Resolve: alertData("generator_url")},
+		&graphql.Field{Name: "receiver", Type: graphql.String, Resolve: alertData("receiver")},
+		&graphql.Field{Name: "log", Type: graphql.NonNull(gqlLog), Description: "The log entry of the alert.", Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source, nil
+		}},
+	)
+
+	gqlRule = graphql.NewObject("Rule", "The counters of an alert rule.",
+		&graphql.Field{Name: "name", Type: graphql.NonNull(graphql.String)},
+		&graphql.Field{Name: "matched", Type: graphql.NonNull(graphql.Float)},
+		&graphql.Field{Name: "fired", Type: graphql.NonNull(graphql.Float)},
+		&graphql.Field{Name: "groups", Type: graphql.NonNull(graphql.Int)},
+		&graphql.Field{Name: "actions", Type: graphql.NonNull(graphql.Int)},
+	)
+	gqlRules = graphql.NewObject("Rules", "The alert rules and their response actions.",
+		&graphql.Field{Name: "enabled", Type: graphql.NonNull(graphql.Boolean)},
+		&graphql.Field{Name: "rules", Type: graphql.NonNull(graphql.ListOf(graphql.NonNull(gqlRule)))},
+		&graphql.Field{Name: "actions", Type: graphql.JSON, Description: "Action outcomes, as in /api/rules."},
+	)
+
+	gqlTopItem = graphql.NewObject("TopItem", "A top result; the true count is within error of count.",
+		&graphql.Field{Name: "key", Type: graphql.NonNull(graphql.String)},
+		&graphql.Field{Name: "count", Type: graphql.NonNull(graphql.Float)},
+		&graphql.Field{Name: "error", Type: graphql.Float},
+	)
+	gqlTop = graphql.NewObject("Top", "The keys of a dimension seen most often within a window.",
+		&graphql.Field{Name: "dimension", Type: graphql.NonNull(gqlDimension)},
+		&graphql.Field{Name: "window", Type: graphql.NonNull(graphql.String)},
+		&graphql.Field{Name: "sources", Type: graphql.ListOf(graphql.NonNull(graphql.String))},
+		&graphql.Field{Name: "min_level", Type: gqlLevel},
+		&graphql.Field{Name: "from", Type: graphql.NonNull(graphql.Time)},
+		&graphql.Field{Name: "total", Type: graphql.NonNull(graphql.Float)},
+		&graphql.Field{Name: "top", Type: graphql.NonNull(graphql.ListOf(graphql.NonNull(gqlTopItem)))},
+	)
+	gqlPoint = graphql.NewObject("Point", "The entries within one interval.",
+		&graphql.Field{Name: "time", Type: graphql.NonNull(graphql.Time)},
+		&graphql.Field{Name: "count", Type: graphql.NonNull(graphql.Float)},
+	)
+	gqlSeries = graphql.NewObject("Series", "A named list of points, oldest first.",
+		&graphql.Field{Name: "name", Type: graphql.NonNull(graphql.String)},
+		&graphql.Field{Name: "points", Type: graphql.NonNull(graphql.ListOf(graphql.NonNull(gqlPoint)))},
+	)
+	gqlHistogram = graphql.NewObject("Histogram", "Entry counts per interval over a window.",
+		&graphql.Field{Name: "window", Type: graphql.NonNull(graphql.String)},
+		&graphql.Field{Name: "interval", Type: graphql.NonNull(graphql.String)},
+		&graphql.Field{Name: "from", Type: graphql.NonNull(graphql.Time)},
+		&graphql.Field{Name: "sources", Type: graphql.ListOf(graphql.NonNull(graphql.String))},
+		&graphql.Field{Name: "min_level", Type: gqlLevel},
+		&graphql.Field{Name: "query", Type: graphql.String},
+		&graphql.Field{Name: "split", Type: gqlSplit},
+		&graphql.Field{Name: "approximate", Type: graphql.NonNull(graphql.Boolean)},
+		&graphql.Field{Name: "total", Type: graphql.NonNull(graphql.Float)},
+		&graphql.Field{Name: "series", Type: graphql.NonNull(graphql.ListOf(graphql.NonNull(gqlSeries)))},
+	)
+
+	gqlQuota = graphql.NewObject("Quota", "The usage of an ingestion quota in its current period.",
+		&graphql.Field{Name: "name", Type: graphql.NonNull(graphql.String)},
+		&graphql.Field{Name: "source", Type: graphql.String},
+		&graphql.Field{Name: "tag", Type: graphql.String},
+		&graphql.Field{Name: "period", Type: graphql.NonNull(graphql.String)},
+		&graphql.Field{Name: "max_bytes", Type: graphql.Float},
+		&graphql.Field{Name: "max_lines", Type: graphql.Float},
+		&graphql.Field{Name: "action", Type: graphql.String},
+		&graphql.Field{Name: "sample_rate", Type: graphql.Int},
+		&graphql.Field{Name: "period_start", Type: graphql.NonNull(graphql.Time)},
+		&graphql.Field{Name: "period_end", Type: graphql.NonNull(graphql.Time)},
+		&graphql.Field{Name: "bytes", Type: graphql.NonNull(graphql.Float)},
+		&graphql.Field{Name: "lines", Type: graphql.NonNull(graphql.Float)},
+		&graphql.Field{Name: "exceeded", Type: graphql.NonNull(graphql.Boolean)},
+		&graphql.Field{Name: "dropped", Type: graphql.NonNull(graphql.Float)},
+		&graphql.Field{Name: "sampled", Type: graphql.NonNull(graphql.Float)},
+		&graphql.Field{Name: "deferred", Type: graphql.NonNull(graphql.Float)},
+	)
+
+	gqlStatus = graphql.NewObject("Status", "The state of the log collector.",
+		&graphql.Field{Name: "running", Type: graphql.NonNull(graphql.Boolean)},
+		&graphql.Field{Name: "total_sources", Type: graphql.NonNull(graphql.Int)},
+		&graphql.Field{Name: "enabled_sources", Type: graphql.NonNull(graphql.Int)},
+		&graphql.Field{Name: "self_monitor", Type: graphql.JSON},
+		&graphql.Field{Name: "subscriptions", Type: graphql.JSON},
+	)
+)
+
+// searchArgs are the arguments of a log search; sources searches all
+// sources when left out
+func searchArgs(sources bool) []*graphql.Arg {
+	args := []*graphql.Arg{
+		{Name: "query", Type: graphql.String, Description: "A query as in /api/logs/search, e.g. level>=warn status>=500."},
+		{Name: "since", Type: graphql.String, Description: "A duration back from now (1h) or an RFC 3339 time."},
+		{Name: "limit", Type: graphql.Int, Default: defaultSearchLimit, Description: "The most entries returned, the newest ones."},
+		{Name: "cursor", Type: graphql.String, Description: "The next_cursor of the previous page."},
+	}
+	if sources {
+		args = append(args, &graphql.Arg{Name: "sources", Type: graphql.ListOf(graphql.NonNull(graphql.String)), Description: "Source names to search."})
+	}
+	return args
+}
+
+func analyticsArgs() []*graphql.Arg {
+	return []*graphql.Arg{
+		{Name: "window", Type: graphql.String, Description: "How far back to look, e.g. 1h; defaults to TOP_RETENTION."},
+		{Name: "sources", Type: graphql.ListOf(graphql.NonNull(graphql.String)), Description: "Source types to count; all when left out."},
+		{Name: "min_level", Type: gqlLevel, Description: "Only count entries at least this severe."},
+	}
+}
+
+// queryType builds the root type. Its fields are nullable, so one failing
+// field of a dashboard query doesn't void the others. Source fields nest a
+// search of the source's own entries.
+func (gh *GraphQLHandler) queryType() *graphql.Type {
+	source := graphql.NewObject("Source", "A configured log source.",
+		&graphql.Field{Name: "name", Type: graphql.NonNull(graphql.String)},
+		&graphql.Field{Name: "source", Type: graphql.NonNull(graphql.String), Description: "The source type, e.g. nginx."},
+		&graphql.Field{Name: "path", Type: graphql.NonNull(graphql.String)},
+		&graphql.Field{Name: "pattern", Type: graphql.String},
+		&graphql.Field{Name: "enabled", Type: graphql.NonNull(graphql.Boolean)},
+		&graphql.Field{Name: "tags", Type: graphql.ListOf(graphql.NonNull(graphql.String))},
+		&graphql.Field{Name: "interval", Type: graphql.NonNull(graphql.Int), Description: "Poll interval in seconds."},
+		&graphql.Field{Name: "timezone", Type: graphql.String},
+		&graphql.Field{Name: "stall_after", Type: graphql.Int},
+		&graphql.Field{Name: "runtime", Type: gqlSourceRuntime, Description: "Null if the source never ran."},
+		&graphql.Field{Name: "logs", Type: gqlLogPage, Args: searchArgs(false), Description: "Search the recent entries of this source.",
+			Resolve: func(_ context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				args["sources"] = []interface{}{source.(collector.SourceSnapshot).Name}
+				return gh.search(args)
+			}},
+	)
+
+	return graphql.NewObject("Query", "The root of every query.",
+		&graphql.Field{Name: "status", Type: gqlStatus, Description: "The state of the log collector, as in /api/logs/status.", Resolve: gh.status},
+		&graphql.Field{Name: "sources", Type: graphql.ListOf(graphql.NonNull(source)), Description: "The configured sources, or the named one.",
+			Args: []*graphql.Arg{{Name: "name", Type: graphql.String}},
+			Resolve: func(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				sources := gh.collector.GetSourceSnapshots()
+				if name, ok := args["name"].(string); ok {
+					for _, s := range sources {
+						if s.Name == name {
+							return []collector.SourceSnapshot{s}, nil
+						}
+					}
+					return []collector.SourceSnapshot{}, nil
+				}
+				return sources, nil
+			}},
+		&graphql.Field{Name: "logs", Type: gqlLogPage, Args: searchArgs(true), Description: "Search the recent entries kept per source, as in /api/logs/search.",
+			Resolve: func(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				return gh.search(args)
+			}},
+		&graphql.Field{Name: "log", Type: gqlLogContext, Description: "An entry with the entries around it; null if it is no longer kept.",
+			Args: []*graphql.Arg{
+				{Name: "id", Type: graphql.NonNull(graphql.ID)},
+				{Name: "before", Type: graphql.Int, Default: 0},
+				{Name: "after", Type: graphql.Int, Default: 0},
+			},
+			Resolve: gh.logContext},
+		&graphql.Field{Name: "alerts", Type: graphql.ListOf(graphql.NonNull(gqlAlert)), Description: "Alertmanager alerts among the recent entries, oldest first.",
+			Args: []*graphql.Arg{
+				{Name: "status", Type: gqlAlertStatus},
+				{Name: "query", Type: graphql.String, Description: "A query the alert entries must also match."},
+				{Name: "since", Type: graphql.String, Description: "A duration back from now (1h) or an RFC 3339 time."},
+				{Name: "limit", Type: graphql.Int, Default: defaultSearchLimit},
+			},
+			Resolve: gh.alerts},
+		&graphql.Field{Name: "rules", Type: gqlRules, Description: "Alert rule counters, as in /api/rules.", Resolve: gh.rules},
+		&graphql.Field{Name: "top", Type: gqlTop, Description: "Top services, hosts, paths, statuses or message templates.",
+			Args: append([]*graphql.Arg{{Name: "dimension", Type: graphql.NonNull(gqlDimension)}},
+				append(analyticsArgs(), &graphql.Arg{Name: "limit", Type: graphql.Int, Default: analytics.DefaultLimit})...),
+			Resolve: gh.top},
+		&graphql.Field{Name: "histogram", Type: gqlHistogram, Description: "Log counts over time for charts.",
+			Args: append(analyticsArgs(),
+				&graphql.Arg{Name: "interval", Type: graphql.String, Description: "The width of a point, in whole minutes."},
+				&graphql.Arg{Name: "query", Type: graphql.String, Description: "Only count entries whose message template contains the text."},
+				&graphql.Arg{Name: "split", Type: gqlSplit},
+			),
+			Resolve: gh.histogram},
+		&graphql.Field{Name: "usage", Type: graphql.ListOf(graphql.NonNull(gqlUsageDay)), Description: "Daily usage accounting, newest day first.",
+			Args: []*graphql.Arg{{Name: "days", Type: graphql.Int, Default: 7}},
+			Resolve: func(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				days := args["days"].(int)
+				if days <= 0 {
+					return nil, errors.New("days must be a positive number")
+				}
+				return gh.collector.Usage(days), nil
+			}},
+		&graphql.Field{Name: "quotas", Type: graphql.ListOf(graphql.NonNull(gqlQuota)), Description: "Ingestion quota usage and dropped lines.",
+			Resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+				return gh.collector.QuotaStatuses(), nil
+			}},
+	)
+}
+
+func (gh *GraphQLHandler) status(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+	sources := gh.collector.GetSources()
+	enabled := 0
+	for _, source := range sources {
+		if source.Enabled {
+			enabled++
+		}
+	}
+	return map[string]interface{}{
+		"running":         gh.collector.IsRunning(),
+		"total_sources":   len(sources),
+		"enabled_sources": enabled,
+		"self_monitor":    gh.collector.SelfMonitorStatus(),
+		"subscriptions":   gh.collector.SubscriptionStatuses(),
+	}, nil
+}
+
+// search runs a search with the arguments of searchArgs
+func (gh *GraphQLHandler) search(args map[string]interface{}) (*collector.SearchResult, error) {
+	text, _ := args["query"].(string)
+	query, err := collector.ParseQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	opts := collector.SearchOptions{Query: query, Sources: stringList(args["sources"])}
+	if opts.Limit = args["limit"].(int); opts.Limit <= 0 || opts.Limit > maxSearchLimit {
+		return nil, fmt.Errorf("limit must be a number between 1 and %d", maxSearchLimit)
+	}
+	if since, ok := args["since"].(string); ok {
+		if opts.Since, ok = parseSince(since); !ok {
+			return nil, errors.New(errInvalidSince)
+		}
+	}
+	if cursor, ok := args["cursor"].(string); ok {
+		if opts.Before, err = collector.ParseSearchCursor(cursor); err != nil {
+			return nil, errors.New("invalid cursor: pass the next_cursor of a previous search")
+		}
+	}
+	result, err := gh.collector.Search(opts)
+	if err != nil {
+		return nil, errors.New("search is disabled (CONTEXT_BUFFER=0)")
+	}
+	return result, nil
+}
+
+func (gh *GraphQLHandler) logContext(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+	before, after := args["before"].(int), args["after"].(int)
+	if before < 0 || before > maxContextLines || after < 0 || after > maxContextLines {
+		return nil, fmt.Errorf("before and after must be numbers between 0 and %d", maxContextLines)
+	}
+	logContext, err := gh.collector.Context(args["id"].(string), before, after)
+	switch {
+	case errors.Is(err, collector.ErrContextDisabled):
+		return nil, errors.New("context retrieval is disabled (CONTEXT_BUFFER=0)")
+	case errors.Is(err, collector.ErrEntryNotFound):
+		return nil, nil
+	}
+	return logContext, err
+}
+
+func (gh *GraphQLHandler) alerts(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+	text := "source:" + string(alertmanager.SourceAlertmanager)
+	if status, ok := args["status"].(string); ok {
+		text += " tag:status:" + status
+	}
+	if query, ok := args["query"].(string); ok && strings.TrimSpace(query) != "" {
+		text += " (" + query + ")"
+	}
+	args["query"] = text
+	result, err := gh.search(args)
+	if err != nil {
+		return nil, err
+	}
+	return result.Entries, nil
+}
+
+func (gh *GraphQLHandler) rules(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+	if gh.engine == nil {
+		return map[string]interface{}{"enabled": false, "rules": []rules.RuleStatus{}}, nil
+	}
+	status := gh.engine.Status()
+	status["enabled"] = true
+	return status, nil
+}
+
+// filter reads the arguments of analyticsArgs
+func (gh *GraphQLHandler) filter(args map[string]interface{}) (analytics.Filter, time.Duration, error) {
+	if gh.tracker == nil {
+		return analytics.Filter{}, 0, errors.New("log analytics are disabled (TOP_RETENTION=0)")
+	}
+	filter := analytics.Filter{}
+	if level, ok := args["min_level"].(string); ok {
+		filter.MinLevel = collector.LogLevel(level)
+	}
+	for _, source := range stringList(args["sources"]) {
+		filter.Sources = append(filter.Sources, collector.LogSource(source))
+	}
+	window, err := durationArg(args, "window", gh.tracker.Retention())
+	return filter, window, err
+}
+
+func (gh *GraphQLHandler) top(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+	filter, window, err := gh.filter(args)
+	if err != nil {
+		return nil, err
+	}
+	return gh.tracker.Top(analytics.Query{
+		Filter:    filter,
+		Dimension: analytics.Dimension(args["dimension"].(string)),
+		Window:    window,
+		Limit:     args["limit"].(int),
+	}, time.Now())
+}
+
+func (gh *GraphQLHandler) histogram(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+	filter, window, err := gh.filter(args)
+	if err != nil {
+		return nil, err
+	}
+	interval, err := durationArg(args, "interval", 0)
+	if err != nil {
+		return nil, err
+	}
+	query, _ := args["query"].(string)
+	split, _ := args["split"].(string)
+	return gh.tracker.Histogram(analytics.HistogramQuery{
+		Filter:   filter,
+		Window:   window,
+		Interval: interval,
+		Query:    query,
+		Split:    split,
+	}, time.Now())
+}
+
+func durationArg(args map[string]interface{}, name string, fallback time.Duration) (time.Duration, error) {
+	value, ok := args[name].(string)
+	if !ok {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return d, nil
+}
+
+// stringList converts a list argument; nil stays nil
+func stringList(v interface{}) []string {
+	items, _ := v.([]interface{})
+	var list []string
+	for _, item := range items {
+		list = append(list, item.(string))
+	}
+	return list
+}
+
+// alertTag resolves an alert field from the tag with the prefix, e.g.
+// severity:critical
+func alertTag(prefix string) graphql.ResolveFunc {
+	return func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+		for _, tag := range source.(collector.ContextLine).Tags {
+			if value, ok := strings.CutPrefix(tag, prefix); ok {
+				return value, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// alertData resolves an alert field from the parsed data of its entry
+func alertData(key string) graphql.ResolveFunc {
+	return func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+		return source.(collector.ContextLine).ParsedData[key], nil
+	}
+}
+
+// namedUsage and namedCounter are usage map entries as list items
+type namedUsage struct {
+	Name string `json:"name,omitempty"`
+	*collector.Usage
+}
+
+type namedCounter struct {
+	Name string `json:"name"`
+	*collector.UsageCounter
+}
+
+func usageList(usage func(collector.UsageDay) map[string]*collector.Usage) graphql.ResolveFunc {
+	return func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+		m := usage(source.(collector.UsageDay))
+		list := make([]namedUsage, 0, len(m))
+		for _, name := range sortedKeys(m) {
+			list = append(list, namedUsage{Name: name, Usage: m[name]})
+		}
+		return list, nil
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -6,7 +6,7 @@ import (
 	"net/http"
 	"time"
 
-	"gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/audit"
 )
 
 // Handler contains HTTP handlers
@@ -23,6 +23,12 @@ func New(auditLogger *audit.Logger) *Handler {
 
 // Home is the homepage handler
 func (h *Handler) Home(w http.ResponseWriter, r *http.Request) {
+	// The mux routes every unknown path here
+	if r.URL.Path != "/" {
+		writeError(w, r, ErrNotFound, "No endpoint at "+r.URL.Path, nil)
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	html := `
 <!DOCTYPE html>
@@ -136,8 +142,9 @@ curl -X POST http://localhost:8080/api/logs/stop
         <div class="card">
             <h3>System Log Example</h3>
             <pre>[SYSTEM_LOG] {
-  "id": "log_1749941868123456789",
-  "timestamp": "2025-06-15T01:57:48+03:00",
+  "id": "log_1749941868123456_3f9a1c",
+  "timestamp": "2025-06-14T22:57:48Z",
+  "original_timestamp": "Jun 15 01:57:48",
   "source": "syslog",
   "level": "info",
   "message": "systemd[1]: Started nginx.service",
@@ -152,7 +159,8 @@ curl -X POST http://localhost:8080/api/logs/stop
     "message": "Started nginx.service"
   },
   "tags": ["system", "syslog"],
-  "collected_at": "2025-06-15T01:57:48+03:00"
+  "collected_at": "2025-06-15T01:57:48+03:00",
+  "fingerprint": "9d4c2f61b0e87a35c1f04d92e6ab7310"
 }</pre>
         </div>
         
@@ -201,17 +209,14 @@ type SendResponse struct {
 // Send message sending handler (legacy, for backward compatibility)
 func (h *Handler) Send(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 		return
 	}
 
 	var req SendRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSON(w, r, maxJSONBodySize, true, "Invalid JSON", &req); err != nil {
 		// Error audit log
-		h.auditLogger.LogError(err, "JSON decode error in Send endpoint", map[string]interface{}{
-			"request_body": r.Body,
-		})
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		h.auditLogger.LogError(err, "JSON decode error in Send endpoint", nil)
 		return
 	}
 
@@ -220,7 +225,7 @@ func (h *Handler) Send(w http.ResponseWriter, r *http.Request) {
 		h.auditLogger.LogError(fmt.Errorf("message field is empty"), "Validation error in Send endpoint", map[string]interface{}{
 			"request": req,
 		})
-		http.Error(w, "Message is required", http.StatusBadRequest)
+		writeError(w, r, ErrInvalidRequest, "Message is required", map[string]interface{}{"field": "message"})
 		return
 	}
 
@@ -228,7 +233,7 @@ func (h *Handler) Send(w http.ResponseWriter, r *http.Request) {
 		h.auditLogger.LogError(fmt.Errorf("recipient field is empty"), "Validation error in Send endpoint", map[string]interface{}{
 			"request": req,
 		})
-		http.Error(w, "Recipient is required", http.StatusBadRequest)
+		writeError(w, r, ErrInvalidRequest, "Recipient is required", map[string]interface{}{"field": "recipient"})
 		return
 	}
 
@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// IdempotencyKeyHeader names a POST so retries of it are answered with the
+// first response instead of being carried out again
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength bounds the keys clients choose
+const maxIdempotencyKeyLength = 255
+
+// maxIdempotentResponseSize bounds a remembered response; larger responses
+// are not remembered and retries run again
+const maxIdempotentResponseSize = 1024 * 1024
+
+// idempotencyStore remembers the responses to POSTs of mutating endpoints
+// by their Idempotency-Key for ttl. A key is bound to the request it was
+// first sent with: the same key with another method, path or body is
+// rejected rather than answered with an unrelated response.
+type idempotencyStore struct {
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*idempotentEntry
+}
+
+// idempotentEntry is a request in flight or its remembered response
+type idempotentEntry struct {
+	fingerprint [sha256.Size]byte
+	// done is closed once the response is recorded
+	done    chan struct{}
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// newIdempotencyStore creates a store of up to size responses kept for
+// ttl; it returns nil, which lets every request through, when either is
+// not positive
+func newIdempotencyStore(ttl time.Duration, size int) *idempotencyStore {
+	if ttl <= 0 || size <= 0 {
+		return nil
+	}
+	return &idempotencyStore{ttl: ttl, size: size, entries: make(map[string]*idempotentEntry)}
+}
+
+// begin looks up key. It returns the entry to replay when the request was
+// answered before, or a new in-flight entry the caller must complete or
+// abandon. ok is false when the key is in flight or bound to another
+// request (reused).
+func (s *idempotencyStore) begin(key string, fingerprint [sha256.Size]byte) (entry *idempotentEntry, replay, reused, ok bool) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, found := s.entries[key]; found && (existing.expires.IsZero() || now.Before(existing.expires)) {
+		if existing.fingerprint != fingerprint {
+			return nil, false, true, false
+		}
+		select {
+		case <-existing.done:
+			return existing, true, false, true
+		default:
+			return nil, false, false, false
+		}
+	}
+
+	if len(s.entries) >= s.size {
+		for k, e := range s.entries {
+			if !e.expires.IsZero() && !now.Before(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		// Still full: make room by dropping any answered request
+		for k, e := range s.entries {
+			if len(s.entries) < s.size {
+				break
+			}
+			if !e.expires.IsZero() {
+				delete(s.entries, k)
+			}
+		}
+	}
+	entry = &idempotentEntry{fingerprint: fingerprint, done: make(chan struct{})}
+	s.entries[key] = entry
+	return entry, false, false, true
+}
+
+// complete remembers the response of an in-flight entry
+func (s *idempotencyStore) complete(entry *idempotentEntry, status int, header http.Header, body []byte) {
+	s.mu.Lock()
+	entry.status, entry.header, entry.body = status, header, body
+	entry.expires = time.Now().Add(s.ttl)
+	s.mu.Unlock()
+	close(entry.done)
+}
+
+// abandon forgets an in-flight entry, so a retry runs the request again
+func (s *idempotencyStore) abandon(key string, entry *idempotentEntry) {
+	s.mu.Lock()
+	if s.entries[key] == entry {
+		delete(s.entries, key)
+	}
+	s.mu.Unlock()
+}
+
+// idempotent wraps the handler of a Mutating endpoint. A POST with an
+// Idempotency-Key runs once; retries with the same key and request get the
+// recorded response with Idempotent-Replayed: true. Server errors are not
+// recorded, so they can be retried.
+func (rt *Router) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		store := rt.idempotency
+		if key == "" || store == nil || r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, r, ErrInvalidRequest, IdempotencyKeyHeader+" must be at most "+strconv.Itoa(maxIdempotencyKeyLength)+" characters", nil)
+			return
+		}
+
+		// The body is read to bind the key to it and handed on unchanged
+		body, err := io.ReadAll(newLimitReader(r.Body, maxJSONBodySize))
+		if err != nil {
+			writeError(w, r, ErrPayloadTooLarge, "Request bodies with an "+IdempotencyKeyHeader+" are limited to "+strconv.Itoa(maxJSONBodySize)+" bytes", nil)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h := sha256.New()
+		io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+		h.Write(body)
+		var fingerprint [sha256.Size]byte
+		h.Sum(fingerprint[:0])
+
+		entry, replay, reused, ok := store.begin(r.URL.Path+"\n"+key, fingerprint)
+		switch {
+		case reused:
+			writeError(w, r, ErrIdempotencyKeyReused, "The "+IdempotencyKeyHeader+" was already used for a different request", nil)
+			return
+		case !ok:
+			w.Header().Set("Retry-After", "1")
+			writeError(w, r, ErrConflict, "A request with this "+IdempotencyKeyHeader+" is still being processed", nil)
+			return
+		case replay:
+			for name, values := range entry.header {
+				// The replay keeps its own request ID
+				if name != http.CanonicalHeaderKey(audit.RequestIDHeader) {
+					w.Header()[name] = values
+				}
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(entry.status)
+			w.Write(entry.body)
+			return
+		}
+
+		rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			if p := recover(); p != nil {
+				store.abandon(r.URL.Path+"\n"+key, entry)
+				panic(p)
+			}
+			if rec.status >= 500 || rec.overflow {
+				store.abandon(r.URL.Path+"\n"+key, entry)
+				return
+			}
+			if !rec.wroteHeader {
+				rec.header = w.Header().Clone()
+			}
+			store.complete(entry, rec.status, rec.header, rec.body.Bytes())
+		}()
+		next(rec, r)
+	}
+}
+
+// recordingWriter passes a response through while keeping a copy of its
+// status, headers and body
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	header      http.Header
+	body        bytes.Buffer
+	wroteHeader bool
+	overflow    bool
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.wroteHeader = true
+		rw.status = status
+		rw.header = rw.ResponseWriter.Header().Clone()
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if !rw.overflow {
+		if rw.body.Len()+len(p) > maxIdempotentResponseSize {
+			rw.overflow = true
+			rw.body.Reset()
+		} else {
+			rw.body.Write(p)
+		}
+	}
+	return rw.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the wrapped writer, so http.ResponseController can reach
+// its Flush and deadlines
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ercansavas/gonder/pkg/backfill"
+	"github.com/ercansavas/gonder/pkg/jobs"
+)
+
+const (
+	// JobsPath lists the background jobs
+	JobsPath = "/api/jobs"
+	// BackfillPath starts backfill jobs
+	BackfillPath = JobsPath + "/backfill"
+)
+
+// JobsHandler serves the background job endpoints
+type JobsHandler struct {
+	jobs       *jobs.Manager
+	backfiller *backfill.Backfiller
+}
+
+// NewJobsHandler creates a jobs handler
+func NewJobsHandler(manager *jobs.Manager, backfiller *backfill.Backfiller) *JobsHandler {
+	return &JobsHandler{jobs: manager, backfiller: backfiller}
+}
+
+// List handles GET /api/jobs: the kept jobs newest first, optionally of
+// one kind (?kind=backfill) or state (?state=running)
+func (jh *JobsHandler) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	jh.writeList(w, r.URL.Query().Get("kind"), r.URL.Query().Get("state"))
+}
+
+func (jh *JobsHandler) writeList(w http.ResponseWriter, kind, state string) {
+	records := jh.jobs.List(kind)
+	if state != "" {
+		filtered := records[:0]
+		for _, record := range records {
+			if string(record.State) == state {
+				filtered = append(filtered, record)
+			}
+		}
+		records = filtered
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    records,
+		"count":   len(records),
+	})
+}
+
+// Backfill handles /api/jobs/backfill: GET lists the backfill jobs, POST
+// starts one
+func (jh *JobsHandler) Backfill(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		jh.writeList(w, backfill.Kind, r.URL.Query().Get("state"))
+	case http.MethodPost:
+		var req backfill.Request
+		if err := decodeJSON(w, r, maxJSONBodySize, true, "Invalid backfill", &req); err != nil {
+			return
+		}
+		record, err := jh.backfiller.Start(req)
+		if err != nil {
+			writeError(w, r, ErrInvalidRequest, "Invalid backfill: "+err.Error(), nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", JobsPath+"/"+record.ID)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"data":    record,
+		})
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// Job handles /api/jobs/{id}: GET reports the job, DELETE cancels it
+func (jh *JobsHandler) Job(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, JobsPath+"/")
+	if id == "" || strings.Contains(id, "/") {
+		writeError(w, r, ErrNotFound, "No endpoint at "+r.URL.Path, nil)
+		return
+	}
+
+	var record jobs.Record
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		record, err = jh.jobs.Get(id)
+	case http.MethodDelete:
+		record, err = jh.jobs.Cancel(id)
+	default:
+		methodNotAllowed(w, r)
+		return
+	}
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		writeError(w, r, ErrNotFound, err.Error(), map[string]interface{}{"job_id": id})
+		return
+	case errors.Is(err, jobs.ErrFinished):
+		writeError(w, r, ErrConflict, err.Error(), map[string]interface{}{"job_id": id, "state": record.State})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    record,
+	})
+}
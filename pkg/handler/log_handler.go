@@ -1,15 +1,25 @@
 package handler
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
-	"gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/arrow"
+	"github.com/ercansavas/gonder/pkg/binenc"
+	"github.com/ercansavas/gonder/pkg/collector"
 )
 
 // LogHandler contains handlers for log collection
 type LogHandler struct {
 	collector *collector.LogCollector
+	// configVersion returns the ETag of the sources, see SetConfigVersion
+	configVersion func() int64
 }
 
 // NewLogHandler creates a new log handler
@@ -19,14 +29,27 @@ func NewLogHandler(collector *collector.LogCollector) *LogHandler {
 	}
 }
 
+// SetConfigVersion makes GET /api/logs/sources return version, the
+// configuration revision of ConfigHandler.Version, as its ETag
+func (lh *LogHandler) SetConfigVersion(version func() int64) {
+	lh.configVersion = version
+}
+
 // GetSources returns log sources
 func (lh *LogHandler) GetSources(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 		return
 	}
 
-	sources := lh.collector.GetSources()
+	// The version is read first: a change in between makes it stale, so
+	// a write based on it fails rather than overwriting the change
+	if lh.configVersion != nil {
+		if version := lh.configVersion(); version > 0 {
+			w.Header().Set("ETag", versionETag(version))
+		}
+	}
+	sources := lh.collector.GetSourceSnapshots()
 
 	response := map[string]interface{}{
 		"success": true,
@@ -39,33 +62,394 @@ func (lh *LogHandler) GetSources(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// Context lines returned around an entry by default and at most
+const (
+	defaultContextLines = 20
+	maxContextLines     = 500
+)
+
+// Entry serves GET /api/logs/{id}/context?before=20&after=20, the entries
+// read before and after an entry from the same source
+func (lh *LogHandler) Entry(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/logs/"), "/")
+	id, sub, _ := strings.Cut(rest, "/")
+	if id == "" || sub != "context" {
+		writeError(w, r, ErrNotFound, "Unknown log endpoint", nil)
+		return
+	}
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	params := r.URL.Query()
+	lines := map[string]int{"before": defaultContextLines, "after": defaultContextLines}
+	for name := range lines {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > maxContextLines {
+			writeError(w, r, ErrInvalidRequest, name+" must be a number between 0 and "+strconv.Itoa(maxContextLines), nil)
+			return
+		}
+		lines[name] = n
+	}
+
+	logContext, err := lh.collector.Context(id, lines["before"], lines["after"])
+	switch {
+	case errors.Is(err, collector.ErrContextDisabled):
+		writeError(w, r, ErrUnavailable, "Context retrieval is disabled (CONTEXT_BUFFER=0)", nil)
+		return
+	case errors.Is(err, collector.ErrEntryNotFound):
+		writeError(w, r, ErrNotFound, "Log entry not found; only the last CONTEXT_BUFFER entries of each source are kept", map[string]interface{}{
+			"log_id": id,
+		})
+		return
+	}
+
+	w.Header().Add("Vary", "Accept")
+	if f := binenc.Negotiate(r.Header.Get("Accept")); f != nil {
+		data, err := f.Append(nil, binenc.Map{{Key: "success", Value: true}, {Key: "data", Value: logContext}})
+		if err != nil {
+			writeError(w, r, ErrInternal, "Failed to encode context", nil)
+			return
+		}
+		w.Header().Set("Content-Type", f.ContentType)
+		w.Write(data)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    logContext,
+	})
+}
+
+const (
+	// streamBuffer is the subscription capacity of a stream; entries are
+	// dropped while a slow client has this many pending
+	streamBuffer = 1024
+	// streamKeepAlive is how often an idle stream sends a comment, so
+	// proxies don't close it
+	streamKeepAlive = 15 * time.Second
+)
+
+// Stream serves GET /api/logs/stream?q=level:error, a server-sent events
+// stream of the processed entries matching the query, as "log" events with
+// the entry as JSON. An Accept header asking for MessagePack or CBOR gets
+// the entries as a sequence of values in that encoding instead.
+func (lh *LogHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	query, err := collector.ParseQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		writeError(w, r, ErrInvalidRequest, err.Error(), nil)
+		return
+	}
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		writeError(w, r, ErrInternal, "Failed to start stream", nil)
+		return
+	}
+
+	logs, cancel := lh.collector.SubscribeBuffered(collector.Filter{Query: query}, streamBuffer)
+	defer cancel()
+
+	w.Header().Set("Vary", "Accept")
+	if f := binenc.Negotiate(r.Header.Get("Accept")); f != nil {
+		streamBinary(w, r, rc, logs, f)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(": connected\n\n"))
+	rc.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+		case entry, ok := <-logs:
+			if !ok {
+				// The collector is closing or the server shutting down
+				return
+			}
+			data, err := json.Marshal(entry)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: log\nid: %s\ndata: %s\n\n", entry.ID, data); err != nil {
+				return
+			}
+			// Send what has queued up in one write
+			if len(logs) > 0 {
+				continue
+			}
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+// streamBinary writes the entries of a stream as a sequence of values in a
+// binary format. The sequence has no room for keep-alives, so a proxy with
+// an idle timeout may close a quiet stream.
+func streamBinary(w http.ResponseWriter, r *http.Request, rc *http.ResponseController, logs <-chan collector.SystemLog, f *binenc.Format) {
+	w.Header().Set("Content-Type", f.StreamContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	var buf []byte
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case entry, ok := <-logs:
+			if !ok {
+				return
+			}
+			var err error
+			if buf, err = f.Append(buf[:0], &entry); err != nil {
+				continue
+			}
+			if _, err := w.Write(buf); err != nil {
+				return
+			}
+			if len(logs) > 0 {
+				continue
+			}
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+// Entries returned by a search by default and at most
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 10000
+)
+
+// Search serves GET /api/logs/search?q=level:error&source=auth_log&since=1h&limit=100,
+// the matching entries among the recent entries kept for context. The
+// next_cursor of a truncated result is passed as cursor for the page of
+// older entries. With format=ndjson the entries are sent one per line instead of in the JSON
+// envelope, with format=arrow as an Arrow IPC stream for pandas or polars.
+// format=msgpack and format=cbor, or an Accept header asking for either,
+// send the JSON envelope in that encoding.
+func (lh *LogHandler) Search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	params := r.URL.Query()
+	format := params.Get("format")
+	if format != "" && format != "json" && format != "ndjson" && format != "arrow" && binenc.ByName(format) == nil {
+		writeError(w, r, ErrInvalidRequest, "format must be json, ndjson, arrow, msgpack or cbor", nil)
+		return
+	}
+	if format == "" {
+		w.Header().Add("Vary", "Accept")
+		if f := binenc.Negotiate(r.Header.Get("Accept")); f != nil {
+			format = f.Name
+		}
+	}
+	query, err := collector.ParseQuery(params.Get("q"))
+	if err != nil {
+		writeError(w, r, ErrInvalidRequest, err.Error(), nil)
+		return
+	}
+	opts := collector.SearchOptions{Query: query, Limit: defaultSearchLimit}
+	for _, source := range params["source"] {
+		if source != "" {
+			opts.Sources = append(opts.Sources, source)
+		}
+	}
+	if since := params.Get("since"); since != "" {
+		var ok bool
+		if opts.Since, ok = parseSince(since); !ok {
+			writeError(w, r, ErrInvalidRequest, errInvalidSince, nil)
+			return
+		}
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > maxSearchLimit {
+			writeError(w, r, ErrInvalidRequest, "limit must be a number between 1 and "+strconv.Itoa(maxSearchLimit), nil)
+			return
+		}
+		opts.Limit = n
+	}
+	if cursor := params.Get("cursor"); cursor != "" {
+		if opts.Before, err = collector.ParseSearchCursor(cursor); err != nil {
+			writeError(w, r, ErrInvalidRequest, "Invalid cursor: pass the next_cursor of a previous search", nil)
+			return
+		}
+	}
+
+	result, err := lh.collector.Search(opts)
+	if err != nil {
+		writeError(w, r, ErrUnavailable, "Search is disabled (CONTEXT_BUFFER=0)", nil)
+		return
+	}
+	writeSearchResult(w, result, format)
+}
+
+const errInvalidSince = "since must be a positive duration (1h) or an RFC 3339 time"
+
+// parseSince reads the start of a search: a duration back from now, or a
+// time
+func parseSince(since string) (time.Time, bool) {
+	if d, err := time.ParseDuration(since); err == nil && d > 0 {
+		return time.Now().Add(-d), true
+	}
+	if t, err := time.Parse(time.RFC3339, since); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// writeSearchResult streams the entries of a search one at a time, so the
+// response is never held in memory as a whole. The JSON envelope is the
+// one json.Encoder would write for the result; NDJSON and Arrow carry the
+// truncation, the scanned and skipped counts and the next cursor in headers.
+func writeSearchResult(w http.ResponseWriter, result *collector.SearchResult, format string) {
+	bw := bufio.NewWriterSize(w, 32*1024)
+	if f := binenc.ByName(format); f != nil {
+		writeBinarySearchResult(bw, w, result, f)
+		return
+	}
+	enc := json.NewEncoder(bw)
+	if format == "ndjson" || format == "arrow" {
+		w.Header().Set("X-Search-Truncated", strconv.FormatBool(result.Truncated))
+		w.Header().Set("X-Search-Scanned", strconv.Itoa(result.Scanned))
+		w.Header().Set("X-Search-Skipped", strconv.Itoa(result.Skipped))
+		if result.NextCursor != "" {
+			w.Header().Set("X-Search-Next-Cursor", result.NextCursor)
+		}
+	}
+	if format == "arrow" {
+		w.Header().Set("Content-Type", arrow.ContentType)
+		aw := arrow.NewWriter(bw)
+		for i := range result.Entries {
+			if aw.WriteLine(&result.Entries[i]) != nil {
+				return
+			}
+		}
+		if aw.Close() == nil {
+			bw.Flush()
+		}
+		return
+	}
+	if format == "ndjson" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for i := range result.Entries {
+			if enc.Encode(&result.Entries[i]) != nil {
+				return
+			}
+		}
+		bw.Flush()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(bw, `{"count":%d,"data":{"entries":[`, len(result.Entries))
+	for i := range result.Entries {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		if enc.Encode(&result.Entries[i]) != nil {
+			return
+		}
+	}
+	fmt.Fprintf(bw, "],\"truncated\":%t,\"scanned\":%d,\"skipped\":%d", result.Truncated, result.Scanned, result.Skipped)
+	if result.NextCursor != "" {
+		fmt.Fprintf(bw, ",\"next_cursor\":%q", result.NextCursor)
+	}
+	bw.WriteString("},\"success\":true}\n")
+	bw.Flush()
+}
+
+// writeBinarySearchResult writes the JSON envelope of a search in a binary
+// format, one entry at a time
+func writeBinarySearchResult(bw *bufio.Writer, w http.ResponseWriter, result *collector.SearchResult, f *binenc.Format) {
+	w.Header().Set("Content-Type", f.ContentType)
+	data := binenc.Map{
+		{Key: "truncated", Value: result.Truncated},
+		{Key: "scanned", Value: result.Scanned},
+		{Key: "skipped", Value: result.Skipped},
+	}
+	if result.NextCursor != "" {
+		data = append(data, binenc.KeyValue{Key: "next_cursor", Value: result.NextCursor})
+	}
+
+	b := f.AppendMapHeader(nil, 3)
+	b = f.AppendString(b, "count")
+	b = f.AppendInt(b, int64(len(result.Entries)))
+	b = f.AppendString(b, "data")
+	b = f.AppendMapHeader(b, len(data)+1)
+	b = f.AppendString(b, "entries")
+	b = f.AppendArrayHeader(b, len(result.Entries))
+	var err error
+	for i := range result.Entries {
+		if b, err = f.Append(b, &result.Entries[i]); err != nil {
+			return
+		}
+		if _, err := bw.Write(b); err != nil {
+			return
+		}
+		b = b[:0]
+	}
+	for _, kv := range data {
+		b = f.AppendString(b, kv.Key)
+		if b, err = f.Append(b, kv.Value); err != nil {
+			return
+		}
+	}
+	b = f.AppendString(b, "success")
+	b, _ = f.Append(b, true)
+	bw.Write(b)
+	bw.Flush()
+}
+
 // StartCollector starts the log collector
 func (lh *LogHandler) StartCollector(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 		return
 	}
 
 	if lh.collector.IsRunning() {
-		response := map[string]interface{}{
-			"success": false,
-			"message": "Log collector is already running",
-			"running": true,
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, ErrConflict, "Log collector is already running", map[string]interface{}{"running": true})
 		return
 	}
 
 	err := lh.collector.Start()
+	if errors.Is(err, collector.ErrMaintenance) {
+		writeError(w, r, ErrConflict, "Log collector is in maintenance mode; leave it with POST /api/admin/maintenance", map[string]interface{}{"running": false})
+		return
+	}
 	if err != nil {
-		response := map[string]interface{}{
-			"success": false,
-			"message": "Log collector could not be started: " + err.Error(),
-			"running": false,
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, ErrInternal, "Log collector could not be started: "+err.Error(), map[string]interface{}{"running": false})
 		return
 	}
 
@@ -82,18 +466,12 @@ func (lh *LogHandler) StartCollector(w http.ResponseWriter, r *http.Request) {
 // StopCollector stops the log collector
 func (lh *LogHandler) StopCollector(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 		return
 	}
 
 	if !lh.collector.IsRunning() {
-		response := map[string]interface{}{
-			"success": false,
-			"message": "Log collector is already stopped",
-			"running": false,
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, ErrConflict, "Log collector is already stopped", map[string]interface{}{"running": false})
 		return
 	}
 
@@ -112,7 +490,7 @@ func (lh *LogHandler) StopCollector(w http.ResponseWriter, r *http.Request) {
 // GetStatus returns log collector status
 func (lh *LogHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r)
 		return
 	}
 
@@ -131,9 +509,71 @@ func (lh *LogHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
 			"total_sources":   len(sources),
 			"enabled_sources": enabledCount,
 			"sources":         sources,
+			"source_status":   lh.collector.GetSourceStatuses(),
+			"self_monitor":    lh.collector.SelfMonitorStatus(),
+			"subscriptions":   lh.collector.SubscriptionStatuses(),
 		},
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+// GetQuotas returns the usage of the ingestion quotas in their current
+// period with the lines they dropped
+func (lh *LogHandler) GetQuotas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	quotas := lh.collector.QuotaStatuses()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    quotas,
+		"count":   len(quotas),
+	})
+}
+
+// GetUsage returns the daily usage accounting, newest day first, with the
+// totals of the days returned. ?days=N limits it to the last N days
+// (default 7).
+func (lh *LogHandler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	days := 7
+	if value := r.URL.Query().Get("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeError(w, r, ErrInvalidRequest, "days must be a positive number", nil)
+			return
+		}
+		days = n
+	}
+
+	usage := lh.collector.Usage(days)
+	total := collector.UsageDay{
+		Sources: map[string]*collector.Usage{},
+		Tenants: map[string]*collector.Usage{},
+		Outputs: map[string]*collector.UsageCounter{},
+	}
+	for i := range usage {
+		total.Merge(&usage[i])
+	}
+	if len(usage) > 0 {
+		total.Date = usage[len(usage)-1].Date + "/" + usage[0].Date
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data": map[string]interface{}{
+			"days":  usage,
+			"total": total,
+		},
+	})
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/gonderpb"
+	"github.com/ercansavas/gonder/pkg/logstream"
+)
+
+// maxStreamRequestSize bounds a StreamLogsRequest
+const maxStreamRequestSize = 64 << 10
+
+// LogStreamHandler serves the LogStream gRPC service, the typed live tail
+// for services consuming the stream with generated gRPC clients
+type LogStreamHandler struct {
+	collector *collector.LogCollector
+}
+
+// NewLogStreamHandler creates a new LogStream handler
+func NewLogStreamHandler(collector *collector.LogCollector) *LogStreamHandler {
+	return &LogStreamHandler{
+		collector: collector,
+	}
+}
+
+// StreamLogs serves the StreamLogs RPC: it decodes the StreamLogsRequest,
+// subscribes with its filter and sends every matching entry as a SystemLog
+// message until the client cancels, the grpc-timeout expires or the
+// server shuts down. HTTP/2 flow control holds writes to a slow client, so
+// its subscription fills up and drops entries like the SSE stream does.
+func (sh *LogStreamHandler) StreamLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	if r.ProtoMajor != 2 || (contentType != "application/grpc" && !strings.HasPrefix(contentType, "application/grpc+proto")) {
+		writeError(w, r, ErrInvalidRequest, "StreamLogs is a gRPC method; call it over HTTP/2 with content-type application/grpc", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+
+	ctx := r.Context()
+	if timeout := r.Header.Get("Grpc-Timeout"); timeout != "" {
+		d, err := logstream.ParseTimeout(timeout)
+		if err != nil {
+			writeGRPCStatus(w, false, logstream.Errorf(logstream.InvalidArgument, "%v", err))
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
+	message, err := logstream.ReadMessage(r.Body, r.Header.Get("Grpc-Encoding"), maxStreamRequestSize)
+	if err != nil {
+		writeGRPCStatus(w, false, err)
+		return
+	}
+	req, err := logstream.DecodeRequest(message)
+	if err != nil {
+		writeGRPCStatus(w, false, logstream.Errorf(logstream.InvalidArgument, "%v", err))
+		return
+	}
+	filter, err := req.Filter()
+	if err != nil {
+		writeGRPCStatus(w, false, logstream.Errorf(logstream.InvalidArgument, "%v", err))
+		return
+	}
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		writeGRPCStatus(w, false, logstream.Errorf(logstream.Internal, "failed to start stream"))
+		return
+	}
+
+	logs, cancel := sh.collector.SubscribeBuffered(filter, streamBuffer)
+	defer cancel()
+
+	w.WriteHeader(http.StatusOK)
+	if rc.Flush() != nil {
+		return
+	}
+
+	var frame, entryMessage []byte
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && r.Context().Err() == nil {
+				writeGRPCStatus(w, true, logstream.Errorf(logstream.DeadlineExceeded, "grpc-timeout expired"))
+			}
+			// A canceled client reads no status
+			return
+		case entry, ok := <-logs:
+			if !ok {
+				// The collector is closing or the server shutting down
+				writeGRPCStatus(w, true, logstream.Errorf(logstream.Unavailable, "server is shutting down"))
+				return
+			}
+			entryMessage = gonderpb.AppendSystemLog(entryMessage[:0], &entry)
+			frame = logstream.AppendFrame(frame[:0], entryMessage)
+			if _, err := w.Write(frame); err != nil {
+				return
+			}
+			// Send what has queued up in one write
+			if len(logs) > 0 {
+				continue
+			}
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+// writeGRPCStatus ends a gRPC response with the status of err, nil for OK.
+// Before the response has started the status is sent in the headers, as a
+// trailers-only response; after, in the trailers.
+func writeGRPCStatus(w http.ResponseWriter, started bool, err error) {
+	status := &logstream.Status{Code: logstream.OK}
+	if err != nil && !errors.As(err, &status) {
+		status = logstream.Errorf(logstream.Internal, "%v", err)
+	}
+
+	prefix := ""
+	if started {
+		prefix = http.TrailerPrefix
+	}
+	w.Header().Set(prefix+"Grpc-Status", strconv.Itoa(int(status.Code)))
+	if status.Message != "" {
+		w.Header().Set(prefix+"Grpc-Message", logstream.EncodeMessage(status.Message))
+	}
+	if !started {
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// maintenanceRetryAfter is the Retry-After, in seconds, of inputs refused
+// during maintenance
+const maintenanceRetryAfter = "30"
+
+// MaintenanceHandler switches maintenance mode, see
+// collector.EnterMaintenance
+type MaintenanceHandler struct {
+	collector   *collector.LogCollector
+	auditLogger *audit.Logger
+}
+
+// NewMaintenanceHandler creates a new maintenance handler
+func NewMaintenanceHandler(collector *collector.LogCollector, auditLogger *audit.Logger) *MaintenanceHandler {
+	return &MaintenanceHandler{collector: collector, auditLogger: auditLogger}
+}
+
+// Maintenance serves /api/admin/maintenance: GET reports the mode and the
+// drain progress, POST {"enabled": true, "reason": "..."} pauses ingestion
+// and drains the outputs, POST {"enabled": false} resumes
+func (mh *MaintenanceHandler) Maintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeMaintenance(w, http.StatusOK, mh.collector.Maintenance(), "")
+	case http.MethodPost:
+		var req struct {
+			Enabled *bool  `json:"enabled"`
+			Reason  string `json:"reason"`
+		}
+		if err := decodeJSON(w, r, maxJSONBodySize, true, "Invalid maintenance request", &req); err != nil {
+			return
+		}
+		if req.Enabled == nil {
+			writeError(w, r, ErrInvalidRequest, `"enabled" is required`, nil)
+			return
+		}
+		if *req.Enabled {
+			// 202: the drain goes on in the background
+			writeMaintenance(w, http.StatusAccepted, mh.collector.EnterMaintenance(req.Reason), "Maintenance mode entered; outputs are draining")
+			return
+		}
+		status, err := mh.collector.ExitMaintenance()
+		if err != nil {
+			writeError(w, r, ErrInternal, "Maintenance mode left, but the log collector could not be restarted: "+err.Error(), nil)
+			return
+		}
+		writeMaintenance(w, http.StatusOK, status, "Maintenance mode left; ingestion resumed")
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+func writeMaintenance(w http.ResponseWriter, status int, maintenance collector.MaintenanceStatus, message string) {
+	response := map[string]interface{}{
+		"success": true,
+		"data":    maintenance,
+	}
+	if message != "" {
+		response["message"] = message
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// rejectInMaintenance answers a network input with 503 while the collector
+// is in maintenance mode, so senders keep their entries and retry later.
+// It returns true once the error is written.
+func rejectInMaintenance(w http.ResponseWriter, r *http.Request, lc *collector.LogCollector) bool {
+	if !lc.InMaintenance() {
+		return false
+	}
+	w.Header().Set("Retry-After", maintenanceRetryAfter)
+	writeError(w, r, ErrUnavailable, "Ingestion is paused for maintenance; retry later", nil)
+	return true
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/otlp"
+)
+
+// OTLPHandler receives logs exported by OpenTelemetry SDKs and collectors
+type OTLPHandler struct {
+	collector   *collector.LogCollector
+	auditLogger *audit.Logger
+}
+
+// NewOTLPHandler creates a new OTLP handler
+func NewOTLPHandler(collector *collector.LogCollector, auditLogger *audit.Logger) *OTLPHandler {
+	return &OTLPHandler{
+		collector:   collector,
+		auditLogger: auditLogger,
+	}
+}
+
+// Logs implements the OTLP/HTTP logs endpoint (POST /v1/logs) for the
+// protobuf and JSON encodings, optionally gzip-compressed
+func (oh *OTLPHandler) Logs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	if rejectInMaintenance(w, r, oh.collector) {
+		return
+	}
+
+	var body io.Reader = http.MaxBytesReader(w, r.Body, maxIngestBodySize)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			writeError(w, r, ErrInvalidRequest, "Invalid gzip body: "+err.Error(), nil)
+			return
+		}
+		defer zr.Close()
+		body = newLimitReader(zr, maxIngestBodySize)
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		writeBodyError(w, r, ErrInvalidRequest, "Failed to read body", maxIngestBodySize, err)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	req, err := otlp.Decode(data, contentType)
+	if err != nil {
+		oh.auditLogger.LogError(err, "Invalid OTLP request", map[string]interface{}{
+			"content_type": contentType,
+			"remote_addr":  r.RemoteAddr,
+		})
+		code := ErrInvalidRequest
+		if errors.Is(err, otlp.ErrUnsupportedContentType) {
+			code = ErrUnsupportedMediaType
+		}
+		writeError(w, r, code, "Invalid OTLP request: "+err.Error(), nil)
+		return
+	}
+
+	for _, log := range otlp.ToSystemLogs(req) {
+		oh.collector.IngestLog(log)
+	}
+
+	// An empty ExportLogsServiceResponse means full success
+	if otlp.IsProtobuf(contentType) {
+		w.Header().Set("Content-Type", otlp.ContentTypeProtobuf)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Header().Set("Content-Type", otlp.ContentTypeJSON)
+	json.NewEncoder(w).Encode(map[string]interface{}{})
+}
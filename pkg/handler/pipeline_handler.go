@@ -0,0 +1,227 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/fleet"
+	"github.com/ercansavas/gonder/pkg/rules"
+)
+
+const (
+	// maxSimulateBodySize caps a simulation request with its sample lines
+	maxSimulateBodySize = 8 * 1024 * 1024
+	// maxSimulateLines caps the lines of one simulation
+	maxSimulateLines = 10000
+)
+
+// PipelineHandler simulates pipeline changes
+type PipelineHandler struct {
+	collector *collector.LogCollector
+	policy    rules.Policy
+}
+
+// NewPipelineHandler creates a pipeline handler; candidate rule actions are
+// checked against policy like those of RULES_FILE
+func NewPipelineHandler(lc *collector.LogCollector, policy rules.Policy) *PipelineHandler {
+	return &PipelineHandler{collector: lc, policy: policy}
+}
+
+// SimulateRequest is the body of POST /api/pipeline/simulate
+type SimulateRequest struct {
+	// Source is the candidate source configuration the lines are parsed
+	// with. A name alone uses the current configuration of that source.
+	Source collector.LogSourceConfig `json:"source"`
+	// Lines are sample lines; Recent replays the raw lines of the source's
+	// recent entries instead
+	Lines  []string        `json:"lines,omitempty"`
+	Recent *SimulateRecent `json:"recent,omitempty"`
+	// Filters are candidate agent filters, applied to the raw lines
+	Filters []fleet.Filter `json:"filters,omitempty"`
+	// Rules are candidate alert rules, evaluated on the parsed entries
+	Rules []rules.RuleConfig `json:"rules,omitempty"`
+}
+
+// SimulateRecent selects recent entries of the source to replay
+type SimulateRecent struct {
+	// Since is a duration such as "15m"; empty replays everything kept
+	Since string `json:"since,omitempty"`
+	// Limit is the most lines replayed, the newest ones; defaults to 1000
+	Limit int `json:"limit,omitempty"`
+}
+
+// SimulatedLine is what would happen to one line
+type SimulatedLine struct {
+	Line string `json:"line"`
+	// Dropped tells why the line would not reach the outputs
+	Dropped string                `json:"dropped,omitempty"`
+	Status  collector.ParseStatus `json:"status,omitempty"`
+	// Entry is the entry the line would become
+	Entry *collector.SystemLog `json:"entry,omitempty"`
+}
+
+// Simulate handles POST /api/pipeline/simulate: it runs sample lines through
+// a candidate source configuration, agent filters and alert rules and
+// reports what would be dropped, how the rest would be parsed, which outputs
+// would receive it and which rules would fire. Nothing is changed, written
+// or run. The processors of the running pipeline (scripts, plugins,
+// enrichment) are not applied.
+func (ph *PipelineHandler) Simulate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	var req SimulateRequest
+	if err := decodeJSON(w, r, maxSimulateBodySize, true, "Invalid simulation", &req); err != nil {
+		return
+	}
+	source, err := ph.candidateSource(req.Source)
+	if err != nil {
+		writeError(w, r, ErrInvalidRequest, err.Error(), nil)
+		return
+	}
+	lines, err := ph.sampleLines(req, source.Name)
+	if err != nil {
+		code := ErrInvalidRequest
+		if errors.Is(err, collector.ErrContextDisabled) {
+			code = ErrUnavailable
+		}
+		writeError(w, r, code, err.Error(), nil)
+		return
+	}
+	filters, err := fleet.NewFilterSet(req.Filters)
+	if err != nil {
+		writeError(w, r, ErrInvalidRequest, "Invalid filters: "+err.Error(), nil)
+		return
+	}
+
+	// A name of its own keeps partial CRI lines apart from the live source
+	parseConfig := source
+	parseConfig.Name = "simulate/" + source.Name
+
+	results := make([]SimulatedLine, 0, len(lines))
+	var entries []collector.SystemLog
+	counts := map[string]int{"lines": len(lines), "dropped": 0, "delivered": 0, "unmatched": 0}
+	for _, line := range lines {
+		result := SimulatedLine{Line: line}
+		raw := collector.RawLine{Source: source.Name, Type: source.Source, Tags: source.Tags, Line: line}
+		if i := filters.Rejecting(raw); i >= 0 {
+			result.Dropped = fmt.Sprintf("filter %d", i)
+		} else {
+			entry, status := ph.collector.ParseLine(line, parseConfig)
+			result.Status = status
+			if status == collector.ParseSkipped {
+				result.Dropped = "skipped by the parser"
+			} else {
+				result.Entry = &entry
+				entries = append(entries, entry)
+				if status == collector.ParseUnmatched {
+					counts["unmatched"]++
+				}
+			}
+		}
+		if result.Dropped != "" {
+			counts["dropped"]++
+		} else {
+			counts["delivered"]++
+		}
+		results = append(results, result)
+	}
+
+	alerts, err := rules.Simulate(req.Rules, ph.policy, entries)
+	if err != nil {
+		writeError(w, r, ErrInvalidRequest, "Invalid rules: "+err.Error(), nil)
+		return
+	}
+	outputs := []string{}
+	for _, output := range ph.collector.GetOutputStatuses() {
+		outputs = append(outputs, output.Name)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data": map[string]interface{}{
+			"source":  source,
+			"summary": counts,
+			"lines":   results,
+			// There is no routing: every delivered entry reaches every output
+			"outputs": outputs,
+			"alerts":  alerts,
+		},
+	})
+}
+
+// candidateSource completes and validates the source of a simulation
+func (ph *PipelineHandler) candidateSource(source collector.LogSourceConfig) (collector.LogSourceConfig, error) {
+	if source.Source == "" {
+		current, ok := ph.collector.GetSource(source.Name)
+		if !ok {
+			return source, fmt.Errorf("source.source is required unless source.name is a configured source")
+		}
+		return current, nil
+	}
+	if source.Name == "" {
+		source.Name = string(source.Source)
+	}
+	// Path and interval only matter for tailing
+	check := source
+	if check.Path == "" {
+		check.Path = "-"
+	}
+	if check.Interval <= 0 {
+		check.Interval = 1
+	}
+	return source, check.Validate()
+}
+
+// sampleLines returns the lines to simulate
+func (ph *PipelineHandler) sampleLines(req SimulateRequest, source string) ([]string, error) {
+	if req.Recent == nil {
+		if len(req.Lines) == 0 {
+			return nil, fmt.Errorf("lines or recent is required")
+		}
+		if len(req.Lines) > maxSimulateLines {
+			return nil, fmt.Errorf("at most %d lines can be simulated", maxSimulateLines)
+		}
+		return req.Lines, nil
+	}
+	if len(req.Lines) > 0 {
+		return nil, fmt.Errorf("lines and recent are exclusive")
+	}
+
+	opts := collector.SearchOptions{Sources: []string{source}, Limit: req.Recent.Limit}
+	if opts.Limit <= 0 {
+		opts.Limit = 1000
+	}
+	if opts.Limit > maxSimulateLines {
+		return nil, fmt.Errorf("recent.limit must be at most %d", maxSimulateLines)
+	}
+	if req.Recent.Since != "" {
+		since, err := time.ParseDuration(req.Recent.Since)
+		if err != nil || since <= 0 {
+			return nil, fmt.Errorf("invalid recent.since %q", req.Recent.Since)
+		}
+		opts.Since = time.Now().Add(-since)
+	}
+	found, err := ph.collector.Search(opts)
+	if err != nil {
+		return nil, fmt.Errorf("recent entries are not kept: %w (CONTEXT_BUFFER=0)", err)
+	}
+	lines := make([]string, 0, len(found.Entries))
+	for _, entry := range found.Entries {
+		if strings.TrimSpace(entry.RawLog) != "" {
+			lines = append(lines, entry.RawLog)
+		}
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("no recent lines of source %s to replay", source)
+	}
+	return lines, nil
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/wasm"
+)
+
+// maxPluginSize caps an uploaded WASM module
+const maxPluginSize = 64 * 1024 * 1024
+
+// PluginHandler manages WASM parser and processor plugins
+type PluginHandler struct {
+	plugins     *wasm.Manager
+	auditLogger *audit.Logger
+}
+
+// NewPluginHandler creates a new plugin handler
+func NewPluginHandler(plugins *wasm.Manager, auditLogger *audit.Logger) *PluginHandler {
+	return &PluginHandler{
+		plugins:     plugins,
+		auditLogger: auditLogger,
+	}
+}
+
+// ListPlugins returns the loaded plugins with their call counters
+func (ph *PluginHandler) ListPlugins(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	plugins := ph.plugins.List()
+	limits := ph.plugins.Limits()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"data":      plugins,
+		"count":     len(plugins),
+		"available": wasm.Available(),
+		"limits": map[string]interface{}{
+			"timeout":      limits.Timeout.String(),
+			"memory_bytes": limits.MemoryLimit,
+		},
+	})
+}
+
+// Plugin serves /api/plugins/{name}: PUT uploads a module (the raw .wasm
+// body, with type and source query parameters) and loads or replaces it,
+// DELETE unloads it
+func (ph *PluginHandler) Plugin(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/plugins/"), "/")
+	if name == "" {
+		ph.ListPlugins(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		module, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPluginSize))
+		if err != nil {
+			writeBodyError(w, r, ErrInvalidRequest, "Failed to read module", maxPluginSize, err)
+			return
+		}
+		manifest := wasm.Manifest{
+			Name:   name,
+			Type:   r.URL.Query().Get("type"),
+			Source: collector.LogSource(r.URL.Query().Get("source")),
+		}
+		if err := ph.plugins.Load(manifest, module); err != nil {
+			ph.auditLogger.LogError(err, "Plugin load failed", map[string]interface{}{
+				"plugin": name,
+				"type":   manifest.Type,
+			})
+			code := ErrInvalidRequest
+			if err == wasm.ErrUnsupported {
+				code = ErrNotImplemented
+			}
+			writeError(w, r, code, "Failed to load plugin: "+err.Error(), nil)
+			return
+		}
+		ph.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: "plugin_loaded",
+			Message:   "WASM plugin " + name + " loaded",
+			Details: map[string]interface{}{
+				"plugin": name,
+				"type":   manifest.Type,
+				"source": manifest.Source,
+				"bytes":  len(module),
+			},
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Plugin loaded",
+			"data":    manifest,
+		})
+	case http.MethodDelete:
+		if err := ph.plugins.Unload(name); err != nil {
+			writeError(w, r, ErrNotFound, err.Error(), nil)
+			return
+		}
+		ph.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: "plugin_unloaded",
+			Message:   "WASM plugin " + name + " unloaded",
+			Details:   map[string]interface{}{"plugin": name},
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Plugin unloaded",
+		})
+	default:
+		methodNotAllowed(w, r)
+	}
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// Authentication required by an endpoint
+const (
+	// AuthNone is open to everyone
+	AuthNone = "none"
+	// AuthAdmin requires ADMIN_TOKEN
+	AuthAdmin = "admin"
+	// AuthIngest requires INGEST_TOKEN when one is configured
+	AuthIngest = "ingest"
+	// AuthAgent requires AGENT_TOKEN, checked by the handler itself
+	AuthAgent = "agent"
+)
+
+// Endpoint describes a route registered on a Router
+type Endpoint struct {
+	// Path is a ServeMux pattern; a trailing slash matches the subtree
+	Path        string   `json:"path"`
+	Methods     []string `json:"methods"`
+	Auth        string   `json:"auth"`
+	Description string   `json:"description"`
+	Deprecated  bool     `json:"deprecated,omitempty"`
+	// Mutating endpoints change the server's state; in read-only mode they
+	// only accept GET and HEAD
+	Mutating bool `json:"mutating,omitempty"`
+}
+
+// Router is a ServeMux that wraps every handler with the audit middleware
+// and the authentication of its endpoint, and remembers the endpoints for
+// Endpoints and the /api/endpoints listing
+type Router struct {
+	mux         *http.ServeMux
+	auditLogger *audit.Logger
+	adminToken  string
+	ingestToken string
+	readOnly    bool
+	idempotency *idempotencyStore
+	sessions    *SessionStore
+	endpoints   []Endpoint
+}
+
+// NewRouter creates a Router checking admin and ingest endpoints against
+// the given tokens
+func NewRouter(auditLogger *audit.Logger, adminToken, ingestToken string) *Router {
+	return &Router{
+		mux:         http.NewServeMux(),
+		auditLogger: auditLogger,
+		adminToken:  adminToken,
+		ingestToken: ingestToken,
+	}
+}
+
+// SetReadOnly rejects changes through Mutating endpoints with ErrReadOnly,
+// so the API can be exposed to people who should only look
+func (rt *Router) SetReadOnly(readOnly bool) {
+	rt.readOnly = readOnly
+}
+
+// SetIdempotency remembers the responses to POSTs of Mutating endpoints
+// sent with an Idempotency-Key for ttl, up to size of them, so retried
+// requests aren't carried out twice. A zero ttl or size disables it.
+func (rt *Router) SetIdempotency(ttl time.Duration, size int) {
+	rt.idempotency = newIdempotencyStore(ttl, size)
+}
+
+// Handle registers next for the endpoint. It panics like ServeMux on a
+// duplicate path.
+func (rt *Router) Handle(endpoint Endpoint, next http.HandlerFunc) {
+	name := audit.HandlerName(next)
+	if endpoint.Mutating {
+		next = rt.rejectInReadOnly(rt.idempotent(next))
+	}
+	switch endpoint.Auth {
+	case AuthAdmin:
+		next = rt.requireAdmin(next)
+	case AuthIngest:
+		next = RequireIngestToken(rt.auditLogger, rt.ingestToken, next)
+	case "":
+		endpoint.Auth = AuthNone
+	}
+	rt.mux.HandleFunc(endpoint.Path, audit.MiddlewareNamed(rt.auditLogger, name, next))
+	rt.endpoints = append(rt.endpoints, endpoint)
+}
+
+// rejectInReadOnly wraps the handler of a Mutating endpoint. It runs after
+// authentication, so only authenticated callers learn about the mode.
+func (rt *Router) rejectInReadOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rt.readOnly && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, r, ErrReadOnly, "The server is read-only (READ_ONLY); "+r.Method+" "+r.URL.Path+" is disabled", nil)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Endpoints returns the registered endpoints sorted by path
+func (rt *Router) Endpoints() []Endpoint {
+	endpoints := append([]Endpoint(nil), rt.endpoints...)
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Path < endpoints[j].Path
+	})
+	return endpoints
+}
+
+// ServeHTTP dispatches the request to the endpoint matching its path
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
+
+// ListEndpoints handles GET /api/endpoints
+func (rt *Router) ListEndpoints(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	endpoints := rt.Endpoints()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"data":      endpoints,
+		"count":     len(endpoints),
+		"read_only": rt.readOnly,
+	})
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ercansavas/gonder/pkg/rules"
+)
+
+// RulesHandler reports the alert rules and their response actions
+type RulesHandler struct {
+	engine *rules.Engine // nil when no rules are configured
+}
+
+// NewRulesHandler creates a new rules handler
+func NewRulesHandler(engine *rules.Engine) *RulesHandler {
+	return &RulesHandler{engine: engine}
+}
+
+// Rules serves GET /api/rules with the match and firing counters of every
+// rule and the action outcomes
+func (rh *RulesHandler) Rules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"enabled": rh.engine != nil,
+	}
+	if rh.engine != nil {
+		response["data"] = rh.engine.Status()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/script"
+)
+
+// ScriptHandler reports and reloads the Lua scripting stage
+type ScriptHandler struct {
+	stage       *script.Stage // nil when no script is configured
+	auditLogger *audit.Logger
+}
+
+// NewScriptHandler creates a new script handler
+func NewScriptHandler(stage *script.Stage, auditLogger *audit.Logger) *ScriptHandler {
+	return &ScriptHandler{
+		stage:       stage,
+		auditLogger: auditLogger,
+	}
+}
+
+// Script serves /api/script: GET returns the stage counters, POST reloads
+// the script from disk
+func (sh *ScriptHandler) Script(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	if sh.stage == nil {
+		if r.Method == http.MethodPost {
+			writeError(w, r, ErrNotFound, "No script configured (set SCRIPT_FILE)", nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"enabled":   false,
+			"available": script.Available(),
+		})
+		return
+	}
+
+	message := ""
+	if r.Method == http.MethodPost {
+		if err := sh.stage.Reload(); err != nil {
+			sh.auditLogger.LogError(err, "Script reload failed", map[string]interface{}{
+				"path": sh.stage.Status().Path,
+			})
+			writeError(w, r, ErrInvalidRequest, "Failed to reload script: "+err.Error(), nil)
+			return
+		}
+		sh.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: "script_reloaded",
+			Message:   "Lua script reloaded",
+			Details:   map[string]interface{}{"path": sh.stage.Status().Path},
+		})
+		message = "Script reloaded"
+	}
+
+	response := map[string]interface{}{
+		"success":   true,
+		"enabled":   true,
+		"available": true,
+		"data":      sh.stage.Status(),
+	}
+	if message != "" {
+		response["message"] = message
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
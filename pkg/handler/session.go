@@ -0,0 +1,539 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// Session login lets a team use the admin endpoints from a browser with
+// their own username and password instead of sharing ADMIN_TOKEN. A login
+// sets an HttpOnly session cookie; requests that change something with it
+// must also send the session's CSRF token in X-CSRF-Token, which a page
+// from another site can't read.
+const (
+	// SessionCookie names the session cookie
+	SessionCookie = "gonder_session"
+	// CSRFHeader carries the CSRF token of the session
+	CSRFHeader = "X-CSRF-Token"
+	// LoginPath serves the login form and takes the login
+	LoginPath = "/login"
+	// LogoutPath ends the session
+	LogoutPath = "/logout"
+	// SessionPath returns the session's user and CSRF token
+	SessionPath = "/api/session"
+)
+
+const (
+	// passwordScheme prefixes password hashes:
+	// pbkdf2-sha256$<iterations>$<salt>$<key>, salt and key in unpadded
+	// base64
+	passwordScheme     = "pbkdf2-sha256"
+	passwordIterations = 600000
+	passwordKeyLength  = 32
+
+	// maxLoginFailures failed logins from one address within loginLockout
+	// block further attempts from it until loginLockout has passed
+	maxLoginFailures = 5
+	loginLockout     = 15 * time.Minute
+)
+
+// UIUser is a user allowed to log in, as written in UI_USERS_FILE
+type UIUser struct {
+	Username string `json:"username"`
+	// PasswordHash is created with HashPassword (gonder hash-password)
+	PasswordHash string `json:"password_hash"`
+}
+
+// HashPassword returns the hash of password to store in UI_USERS_FILE
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, passwordKeyLength)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s$%d$%s$%s", passwordScheme, passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// passwordHash is a parsed password hash
+type passwordHash struct {
+	iterations int
+	salt, key  []byte
+}
+
+func parsePasswordHash(hash string) (passwordHash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != passwordScheme {
+		return passwordHash{}, fmt.Errorf("password hash must be %s$<iterations>$<salt>$<key>", passwordScheme)
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return passwordHash{}, fmt.Errorf("invalid iteration count %q", parts[1])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return passwordHash{}, fmt.Errorf("invalid salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) == 0 {
+		return passwordHash{}, fmt.Errorf("invalid key")
+	}
+	return passwordHash{iterations: iterations, salt: salt, key: key}, nil
+}
+
+// matches reports whether password has this hash, in constant time
+func (h passwordHash) matches(password string) bool {
+	key, err := pbkdf2.Key(sha256.New, password, h.salt, h.iterations, len(h.key))
+	return err == nil && subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// LoadUsersFile reads a JSON array of UIUser. Unknown fields are rejected
+// so a misspelled setting is not ignored.
+func LoadUsersFile(path string) ([]UIUser, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users file %s: %w", path, err)
+	}
+	var users []UIUser
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&users); err != nil {
+		return nil, fmt.Errorf("failed to decode users file %s: %w", path, err)
+	}
+	return users, nil
+}
+
+// SessionStore keeps the login sessions of the UI users in memory; a
+// restart logs everyone out
+type SessionStore struct {
+	users map[string]passwordHash
+	// dummy is checked for unknown users, so their logins take as long as
+	// those of known users
+	dummy passwordHash
+	ttl   time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	failures map[string]*loginFailures
+}
+
+// session is a logged-in user
+type session struct {
+	user    string
+	csrf    string
+	expires time.Time
+}
+
+// loginFailures counts the failed logins of one address
+type loginFailures struct {
+	count int
+	since time.Time
+}
+
+// NewSessionStore creates the sessions of users, each lasting ttl
+func NewSessionStore(users []UIUser, ttl time.Duration) (*SessionStore, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("session TTL must be positive")
+	}
+	store := &SessionStore{
+		users:    make(map[string]passwordHash, len(users)),
+		ttl:      ttl,
+		sessions: make(map[string]*session),
+		failures: make(map[string]*loginFailures),
+	}
+	for _, user := range users {
+		if user.Username == "" {
+			return nil, fmt.Errorf("user without username")
+		}
+		if _, exists := store.users[user.Username]; exists {
+			return nil, fmt.Errorf("duplicate user: %s", user.Username)
+		}
+		hash, err := parsePasswordHash(user.PasswordHash)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", user.Username, err)
+		}
+		store.users[user.Username] = hash
+	}
+	dummy, _ := HashPassword("")
+	store.dummy, _ = parsePasswordHash(dummy)
+	return store, nil
+}
+
+// login checks the credentials of a login from addr and starts a session.
+// locked is true while addr is blocked after too many failures.
+func (s *SessionStore) login(addr, username, password string) (id string, sess *session, locked bool) {
+	now := time.Now()
+	s.mu.Lock()
+	if f := s.failures[addr]; f != nil && f.count >= maxLoginFailures && now.Sub(f.since) < loginLockout {
+		s.mu.Unlock()
+		return "", nil, true
+	}
+	s.mu.Unlock()
+
+	hash, known := s.users[username]
+	if !known {
+		hash = s.dummy
+	}
+	ok := hash.matches(password) && known
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !ok {
+		f := s.failures[addr]
+		if f == nil || now.Sub(f.since) >= loginLockout {
+			f = &loginFailures{since: now}
+			s.failures[addr] = f
+		}
+		f.count++
+		return "", nil, false
+	}
+	delete(s.failures, addr)
+	for key, existing := range s.sessions {
+		if now.After(existing.expires) {
+			delete(s.sessions, key)
+		}
+	}
+	id, csrf := randomToken(), randomToken()
+	sess = &session{user: username, csrf: csrf, expires: now.Add(s.ttl)}
+	s.sessions[id] = sess
+	return id, sess, false
+}
+
+// lookup returns the session of the request's cookie
+func (s *SessionStore) lookup(r *http.Request) (string, *session, bool) {
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil || cookie.Value == "" {
+		return "", nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[cookie.Value]
+	if !ok {
+		return "", nil, false
+	}
+	if time.Now().After(sess.expires) {
+		delete(s.sessions, cookie.Value)
+		return "", nil, false
+	}
+	return cookie.Value, sess, true
+}
+
+// logout ends a session
+func (s *SessionStore) logout(id string) {
+	s.mu.Lock()
+	delete(s.sessions, id)
+	s.mu.Unlock()
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validCSRF checks the CSRF token and, when the browser sent one, that the
+// Origin is this server
+func validCSRF(r *http.Request, sess *session, token string) bool {
+	return sameOrigin(r) && token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(sess.csrf)) == 1
+}
+
+// sameOrigin rejects requests a browser sent from another site's page
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && parsed.Host == r.Host
+}
+
+// safeMethod reports methods that must not change anything
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// hasAdminToken reports whether a request authenticates with a token
+// rather than a session
+func hasAdminToken(r *http.Request) bool {
+	return r.Header.Get("X-Admin-Token") != "" || strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// SetSessions makes admin endpoints also accept the login sessions of
+// store, next to the admin token
+func (rt *Router) SetSessions(store *SessionStore) {
+	rt.sessions = store
+}
+
+// requireAdmin authenticates an admin endpoint with a session cookie or
+// the admin token
+func (rt *Router) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	byToken := RequireAdmin(rt.auditLogger, rt.adminToken, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rt.sessions == nil || hasAdminToken(r) {
+			byToken(w, r)
+			return
+		}
+		_, sess, ok := rt.sessions.lookup(r)
+		if !ok {
+			byToken(w, r)
+			return
+		}
+		if !safeMethod(r.Method) && !validCSRF(r, sess, r.Header.Get(CSRFHeader)) {
+			rt.auditLogger.LogAuthFailure(r, "session", fmt.Errorf("missing or invalid CSRF token"), map[string]interface{}{"user": sess.user})
+			writeError(w, r, ErrCSRFFailed, "Send the session's CSRF token in "+CSRFHeader, nil)
+			return
+		}
+		audit.SetUser(r, sess.user)
+		next(w, r)
+	}
+}
+
+// SessionHandler serves the login form, login, logout and the session
+type SessionHandler struct {
+	sessions    *SessionStore
+	auditLogger *audit.Logger
+}
+
+// NewSessionHandler creates a new session handler
+func NewSessionHandler(sessions *SessionStore, auditLogger *audit.Logger) *SessionHandler {
+	return &SessionHandler{sessions: sessions, auditLogger: auditLogger}
+}
+
+// loginPage is the login form; error and next are filled in
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Gonder - Log in</title>
+    <style>
+        body { font-family: Arial, sans-serif; background: #f5f5f5; }
+        form { max-width: 320px; margin: 80px auto; background: white; padding: 30px; border-radius: 10px; box-shadow: 0 4px 6px rgba(0,0,0,0.1); }
+        h1 { color: #2c3e50; font-size: 22px; margin-top: 0; }
+        label { display: block; margin: 12px 0 4px; color: #2c3e50; }
+        input { width: 100%; padding: 8px; box-sizing: border-box; border: 1px solid #ddd; border-radius: 5px; }
+        button { margin-top: 20px; width: 100%; background: #3498db; color: white; padding: 10px; border: none; border-radius: 5px; cursor: pointer; }
+        .error { color: #e74c3c; }
+    </style>
+</head>
+<body>
+    <form method="post" action="/login">
+        <h1>Gonder</h1>
+        {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+        <input type="hidden" name="next" value="{{.Next}}">
+        <label for="username">Username</label>
+        <input id="username" name="username" autocomplete="username" autofocus required>
+        <label for="password">Password</label>
+        <input id="password" name="password" type="password" autocomplete="current-password" required>
+        <button type="submit">Log in</button>
+    </form>
+</body>
+</html>
+`))
+
+// Login serves /login: GET shows the form, POST logs in with the form
+// fields username and password (or a JSON body with the same keys). A form
+// login redirects to next; a JSON login returns the CSRF token.
+func (sh *SessionHandler) Login(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		sh.writeLoginPage(w, http.StatusOK, "", localPath(r.URL.Query().Get("next")))
+		return
+	case http.MethodPost:
+	default:
+		methodNotAllowed(w, r)
+		return
+	}
+
+	var credentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	form := !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+	next := "/"
+	if form {
+		r.Body = http.MaxBytesReader(w, r.Body, maxJSONBodySize)
+		if err := r.ParseForm(); err != nil {
+			writeError(w, r, ErrInvalidRequest, "Invalid login form", nil)
+			return
+		}
+		credentials.Username, credentials.Password = r.PostForm.Get("username"), r.PostForm.Get("password")
+		next = localPath(r.PostForm.Get("next"))
+	} else if err := decodeJSON(w, r, maxJSONBodySize, true, "Invalid login", &credentials); err != nil {
+		return
+	}
+	// A login from another site's page would log the browser into the
+	// attacker's account
+	if !sameOrigin(r) {
+		writeError(w, r, ErrCSRFFailed, "Cross-site login rejected", nil)
+		return
+	}
+
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	id, sess, locked := sh.sessions.login(addr, credentials.Username, credentials.Password)
+	if sess == nil {
+		reason := "invalid username or password"
+		if locked {
+			reason = "too many failed logins"
+		}
+		sh.auditLogger.LogAuthFailure(r, "session", fmt.Errorf("%s", reason), map[string]interface{}{"user": credentials.Username})
+		message := "Invalid username or password"
+		if locked {
+			message = "Too many failed logins; try again later"
+		}
+		if form {
+			sh.writeLoginPage(w, http.StatusUnauthorized, message, next)
+			return
+		}
+		writeError(w, r, ErrUnauthorized, message, nil)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  sess.expires,
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	audit.SetUser(r, sess.user)
+	sh.auditLogger.LogEvent(audit.AuditEvent{
+		EventType:  "session_login",
+		UserID:     sess.user,
+		RequestID:  audit.RequestID(r),
+		Message:    "User " + sess.user + " logged in",
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+	})
+	if form {
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
+	writeSession(w, sess)
+}
+
+// Logout serves POST /logout with the CSRF token in X-CSRF-Token or the
+// csrf_token form field
+func (sh *SessionHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	id, sess, ok := sh.sessions.lookup(r)
+	if !ok {
+		writeError(w, r, ErrUnauthorized, "Not logged in", nil)
+		return
+	}
+	token := r.Header.Get(CSRFHeader)
+	if token == "" {
+		r.Body = http.MaxBytesReader(w, r.Body, maxJSONBodySize)
+		token = r.PostFormValue("csrf_token")
+	}
+	if !validCSRF(r, sess, token) {
+		writeError(w, r, ErrCSRFFailed, "Send the session's CSRF token in "+CSRFHeader, nil)
+		return
+	}
+
+	sh.sessions.logout(id)
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	audit.SetUser(r, sess.user)
+	sh.auditLogger.LogEvent(audit.AuditEvent{
+		EventType: "session_logout",
+		UserID:    sess.user,
+		RequestID: audit.RequestID(r),
+		Message:   "User " + sess.user + " logged out",
+	})
+	if r.Header.Get(CSRFHeader) == "" {
+		http.Redirect(w, r, LoginPath, http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Logged out",
+	})
+}
+
+// Session serves GET /api/session: the logged-in user and the CSRF token
+// pages send with their changes
+func (sh *SessionHandler) Session(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	_, sess, ok := sh.sessions.lookup(r)
+	if !ok {
+		writeError(w, r, ErrUnauthorized, "Not logged in", map[string]interface{}{"login": LoginPath})
+		return
+	}
+	audit.SetUser(r, sess.user)
+	writeSession(w, sess)
+}
+
+func writeSession(w http.ResponseWriter, sess *session) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data": map[string]interface{}{
+			"user":       sess.user,
+			"expires_at": sess.expires,
+			"csrf_token": sess.csrf,
+		},
+	})
+}
+
+func (sh *SessionHandler) writeLoginPage(w http.ResponseWriter, status int, message, next string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	loginPage.Execute(w, map[string]string{"Error": message, "Next": next})
+}
+
+// localPath returns next when it is a path on this server, "/" otherwise,
+// so a login link can't redirect to another site
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// secureRequest reports whether the request reached gonder, or the proxy
+// in front of it, over HTTPS
+func secureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
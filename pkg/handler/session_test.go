@@ -0,0 +1,301 @@
+package handler
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// testHash hashes password with few iterations, so tests stay fast
+func testHash(t *testing.T, password string) string {
+	t.Helper()
+	salt := []byte("0123456789abcdef")
+	key, err := pbkdf2.Key(sha256.New, password, salt, 1000, passwordKeyLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("%s$1000$%s$%s", passwordScheme, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parsePasswordHash(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.iterations != passwordIterations || !parsed.matches("correct horse") || parsed.matches("correct horse ") {
+		t.Fatalf("hash %s does not check passwords", hash)
+	}
+	if again, _ := HashPassword("correct horse"); again == hash {
+		t.Fatal("two hashes of one password share their salt")
+	}
+}
+
+func TestParsePasswordHash(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"other scheme", "bcrypt$10$c2FsdA$a2V5"},
+		{"missing part", "pbkdf2-sha256$1000$c2FsdA"},
+		{"zero iterations", "pbkdf2-sha256$0$c2FsdA$a2V5"},
+		{"invalid iterations", "pbkdf2-sha256$many$c2FsdA$a2V5"},
+		{"invalid salt", "pbkdf2-sha256$1000$!!$a2V5"},
+		{"empty key", "pbkdf2-sha256$1000$c2FsdA$"},
+		{"padded key", "pbkdf2-sha256$1000$c2FsdA$a2V5eQ=="},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := parsePasswordHash(test.hash); err == nil {
+				t.Fatalf("parsePasswordHash(%q) succeeded", test.hash)
+			}
+		})
+	}
+	if _, err := parsePasswordHash(testHash(t, "x")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewSessionStoreErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		users []UIUser
+		ttl   time.Duration
+	}{
+		{"zero ttl", nil, 0},
+		{"no username", []UIUser{{PasswordHash: testHash(t, "x")}}, time.Hour},
+		{"duplicate user", []UIUser{{"ada", testHash(t, "x")}, {"ada", testHash(t, "y")}}, time.Hour},
+		{"invalid hash", []UIUser{{"ada", "plain"}}, time.Hour},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewSessionStore(test.users, test.ttl); err == nil {
+				t.Fatal("NewSessionStore() succeeded")
+			}
+		})
+	}
+}
+
+// sessionServer serves the session endpoints and an admin endpoint echoing
+// the authenticated user
+func sessionServer(t *testing.T, ttl time.Duration) *httptest.Server {
+	t.Helper()
+	store, err := NewSessionStore([]UIUser{{Username: "ada", PasswordHash: testHash(t, "secret")}}, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLogger := audit.NewWithWriter(io.Discard)
+	router := NewRouter(auditLogger, "admin-token", "")
+	router.SetSessions(store)
+	sessions := NewSessionHandler(store, auditLogger)
+	router.Handle(Endpoint{Path: LoginPath, Methods: []string{http.MethodGet, http.MethodPost}}, sessions.Login)
+	router.Handle(Endpoint{Path: LogoutPath, Methods: []string{http.MethodPost}}, sessions.Logout)
+	router.Handle(Endpoint{Path: SessionPath, Methods: []string{http.MethodGet}}, sessions.Session)
+	router.Handle(Endpoint{Path: "/api/admin", Methods: []string{http.MethodGet, http.MethodPost}, Auth: AuthAdmin}, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// request sends a request and returns the status and the error code of
+// error responses
+func request(t *testing.T, server *httptest.Server, method, path, body string, headers map[string]string) (*http.Response, ErrorCode) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Error ErrorBody `json:"error"`
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		json.NewDecoder(resp.Body).Decode(&result)
+	}
+	return resp, result.Error.Code
+}
+
+// login logs ada in and returns the session cookie and CSRF token
+func login(t *testing.T, server *httptest.Server) (cookie, csrf string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, server.URL+LoginPath, strings.NewReader(`{"username":"ada","password":"secret"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("login returned %d", resp.StatusCode)
+	}
+	var result struct {
+		Data struct {
+			CSRFToken string `json:"csrf_token"`
+		} `json:"data"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	for _, c := range resp.Cookies() {
+		if c.Name == SessionCookie {
+			if !c.HttpOnly || c.SameSite != http.SameSiteStrictMode {
+				t.Fatalf("session cookie %+v is not HttpOnly and SameSite=Strict", c)
+			}
+			return SessionCookie + "=" + c.Value, result.Data.CSRFToken
+		}
+	}
+	t.Fatal("login set no session cookie")
+	return "", ""
+}
+
+func TestSessionAdminAccess(t *testing.T) {
+	server := sessionServer(t, time.Hour)
+	cookie, csrf := login(t, server)
+	if csrf == "" {
+		t.Fatal("login returned no CSRF token")
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		status  int
+		code    ErrorCode
+	}{
+		{"nothing", http.MethodGet, nil, http.StatusUnauthorized, ErrUnauthorized},
+		{"session read", http.MethodGet, map[string]string{"Cookie": cookie}, http.StatusOK, ""},
+		{"session change without CSRF token", http.MethodPost, map[string]string{"Cookie": cookie}, http.StatusForbidden, ErrCSRFFailed},
+		{"session change with wrong CSRF token", http.MethodPost, map[string]string{"Cookie": cookie, CSRFHeader: "x" + csrf[1:]}, http.StatusForbidden, ErrCSRFFailed},
+		{"session change", http.MethodPost, map[string]string{"Cookie": cookie, CSRFHeader: csrf}, http.StatusOK, ""},
+		{"session change from another site", http.MethodPost, map[string]string{"Cookie": cookie, CSRFHeader: csrf, "Origin": "https://evil.example"}, http.StatusForbidden, ErrCSRFFailed},
+		{"unknown session", http.MethodGet, map[string]string{"Cookie": SessionCookie + "=forged"}, http.StatusUnauthorized, ErrUnauthorized},
+		{"admin token", http.MethodPost, map[string]string{"Authorization": "Bearer admin-token"}, http.StatusOK, ""},
+		// A token request is checked as one, even with a session
+		{"wrong token with session", http.MethodGet, map[string]string{"Cookie": cookie, "X-Admin-Token": "wrong"}, http.StatusUnauthorized, ErrUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, code := request(t, server, test.method, "/api/admin", "", test.headers)
+			if resp.StatusCode != test.status || code != test.code {
+				t.Fatalf("got %d %q, want %d %q", resp.StatusCode, code, test.status, test.code)
+			}
+		})
+	}
+}
+
+func TestSessionLogout(t *testing.T) {
+	server := sessionServer(t, time.Hour)
+	cookie, csrf := login(t, server)
+
+	if resp, code := request(t, server, http.MethodPost, LogoutPath, "", map[string]string{"Cookie": cookie}); code != ErrCSRFFailed {
+		t.Fatalf("logout without CSRF token returned %d %q", resp.StatusCode, code)
+	}
+	if resp, _ := request(t, server, http.MethodPost, LogoutPath, "", map[string]string{"Cookie": cookie, CSRFHeader: csrf}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("logout returned %d", resp.StatusCode)
+	}
+	if resp, _ := request(t, server, http.MethodGet, SessionPath, "", map[string]string{"Cookie": cookie}); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("session still valid after logout: %d", resp.StatusCode)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	server := sessionServer(t, 50*time.Millisecond)
+	cookie, _ := login(t, server)
+	if resp, _ := request(t, server, http.MethodGet, SessionPath, "", map[string]string{"Cookie": cookie}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("fresh session returned %d", resp.StatusCode)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if resp, _ := request(t, server, http.MethodGet, "/api/admin", "", map[string]string{"Cookie": cookie}); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expired session returned %d", resp.StatusCode)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	form := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+	tests := []struct {
+		name     string
+		body     string
+		headers  map[string]string
+		status   int
+		location string
+	}{
+		{"form", "username=ada&password=secret&next=/ui/logs", form, http.StatusSeeOther, "/ui/logs"},
+		{"form to another site", "username=ada&password=secret&next=//evil.example/", form, http.StatusSeeOther, "/"},
+		{"form with wrong password", "username=ada&password=nope", form, http.StatusUnauthorized, ""},
+		{"json with unknown user", `{"username":"bob","password":"secret"}`, map[string]string{"Content-Type": "application/json"}, http.StatusUnauthorized, ""},
+		{"cross-site", "username=ada&password=secret", map[string]string{"Content-Type": "application/x-www-form-urlencoded", "Origin": "https://evil.example"}, http.StatusForbidden, ""},
+	}
+	server := sessionServer(t, time.Hour)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, _ := request(t, server, http.MethodPost, LoginPath, test.body, test.headers)
+			if resp.StatusCode != test.status || resp.Header.Get("Location") != test.location {
+				t.Fatalf("got %d to %q, want %d to %q", resp.StatusCode, resp.Header.Get("Location"), test.status, test.location)
+			}
+		})
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	store, err := NewSessionStore([]UIUser{{Username: "ada", PasswordHash: testHash(t, "secret")}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxLoginFailures; i++ {
+		if _, sess, locked := store.login("10.0.0.1", "ada", "guess"); sess != nil || locked {
+			t.Fatalf("failure %d: session %v, locked %v", i+1, sess, locked)
+		}
+	}
+	if _, sess, locked := store.login("10.0.0.1", "ada", "secret"); sess != nil || !locked {
+		t.Fatal("the right password was accepted from a locked out address")
+	}
+	if _, sess, _ := store.login("10.0.0.2", "ada", "secret"); sess == nil {
+		t.Fatal("another address was locked out too")
+	}
+
+	// The lockout ends after loginLockout
+	store.failures["10.0.0.1"].since = time.Now().Add(-loginLockout)
+	if _, sess, _ := store.login("10.0.0.1", "ada", "secret"); sess == nil {
+		t.Fatal("the lockout did not end")
+	}
+	if _, ok := store.failures["10.0.0.1"]; ok {
+		t.Fatal("a successful login kept the failures")
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	tests := map[string]string{
+		"":                     "/",
+		"/ui/logs?level=error": "/ui/logs?level=error",
+		"https://evil.example": "/",
+		"//evil.example":       "/",
+		`/\evil.example`:       "/",
+		"ui":                   "/",
+	}
+	for next, want := range tests {
+		if got := localPath(next); got != want {
+			t.Errorf("localPath(%q) = %q, want %q", next, got, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ercansavas/gonder/pkg/snapshot"
+)
+
+const (
+	// SnapshotPath takes a snapshot of the archive and checkpoints
+	SnapshotPath = "/api/store/snapshot"
+	// SnapshotsPath lists the snapshots kept locally
+	SnapshotsPath = "/api/store/snapshots"
+)
+
+// SnapshotHandler serves the snapshot endpoints
+type SnapshotHandler struct {
+	snapshotter *snapshot.Snapshotter
+}
+
+// NewSnapshotHandler creates a snapshot handler
+func NewSnapshotHandler(snapshotter *snapshot.Snapshotter) *SnapshotHandler {
+	return &SnapshotHandler{snapshotter: snapshotter}
+}
+
+// Create handles POST /api/store/snapshot: the snapshot is taken as a
+// background job, optionally uploaded to {"upload_url": "..."}
+func (sh *SnapshotHandler) Create(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	if !sh.snapshotter.Enabled() {
+		writeError(w, r, ErrUnavailable, "Snapshots are disabled, set SNAPSHOT_DIR to enable them", nil)
+		return
+	}
+	var req snapshot.Request
+	if r.ContentLength != 0 {
+		if err := decodeJSON(w, r, maxJSONBodySize, true, "Invalid snapshot", &req); err != nil {
+			return
+		}
+	}
+	record, err := sh.snapshotter.Start(req)
+	if err != nil {
+		writeError(w, r, ErrInvalidRequest, "Invalid snapshot: "+err.Error(), nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", JobsPath+"/"+record.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    record,
+	})
+}
+
+// List handles GET /api/store/snapshots: the local snapshots, newest first
+func (sh *SnapshotHandler) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	snapshots, err := sh.snapshotter.List()
+	if err != nil {
+		writeError(w, r, ErrInternal, "Failed to list snapshots", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    snapshots,
+		"count":   len(snapshots),
+	})
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// StatsHandler exposes the request statistics of the HTTP API
+type StatsHandler struct {
+	stats *audit.HTTPStats
+}
+
+// NewStatsHandler creates a new HTTP statistics handler
+func NewStatsHandler(stats *audit.HTTPStats) *StatsHandler {
+	return &StatsHandler{stats: stats}
+}
+
+// HTTP returns the requests, status classes and latency percentiles per
+// route and method, slowest first
+func (sh *StatsHandler) HTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	routes := sh.stats.Snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    routes,
+		"count":   len(routes),
+		"since":   sh.stats.Since().Format(time.RFC3339),
+	})
+}
+
+// Metrics serves the statistics in the Prometheus text format
+func (sh *StatsHandler) Metrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	sh.stats.WritePrometheus(w)
+}
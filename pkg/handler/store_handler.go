@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/archive"
+)
+
+const (
+	// StoreStatusPath reports the health and capacity of the archive
+	StoreStatusPath = "/api/store/status"
+	// StoreSummariesPath serves the hourly summaries of downsampled entries
+	StoreSummariesPath = "/api/store/summaries"
+	// StoreHoldsPath lists and places holds on archive objects
+	StoreHoldsPath = "/api/store/holds"
+)
+
+// StoreHandler serves the status of the archive
+type StoreHandler struct {
+	archive *archive.Output // nil without archive
+}
+
+// NewStoreHandler creates a store handler; archiveOutput may be nil
+func NewStoreHandler(archiveOutput *archive.Output) *StoreHandler {
+	return &StoreHandler{archive: archiveOutput}
+}
+
+// Status handles GET /api/store/status: object counts, disk usage against
+// the capacity, the time range kept, the compaction backlog and the days
+// left at the recent ingest rate
+func (sh *StoreHandler) Status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	if sh.archive == nil {
+		writeError(w, r, ErrUnavailable, "The archive is disabled, set ARCHIVE_DIR to enable it", nil)
+		return
+	}
+	status, err := sh.archive.Status()
+	if err != nil {
+		writeError(w, r, ErrInternal, "Failed to read archive status: "+err.Error(), nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    status,
+	})
+}
+
+// Summaries handles GET /api/store/summaries: the hourly summaries of
+// downsampled entries, optionally between ?from= and ?to= (durations back
+// from now or RFC 3339 times) and of one ?source=
+func (sh *StoreHandler) Summaries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+	if sh.archive == nil {
+		writeError(w, r, ErrUnavailable, "The archive is disabled, set ARCHIVE_DIR to enable it", nil)
+		return
+	}
+	params := r.URL.Query()
+	var bounds [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			bounds[i] = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, value); err == nil {
+			bounds[i] = t
+		} else {
+			writeError(w, r, ErrInvalidRequest, name+" must be a positive duration (720h) or an RFC 3339 time", nil)
+			return
+		}
+	}
+	hours, err := sh.archive.Summaries(bounds[0], bounds[1], params.Get("source"))
+	if err != nil {
+		writeError(w, r, ErrInternal, "Failed to read summaries: "+err.Error(), nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    hours,
+		"count":   len(hours),
+	})
+}
+
+// holdRequest is the body of POST /api/store/holds and PUT
+// /api/store/holds/{id}
+type holdRequest struct {
+	Reason string     `json:"reason"`
+	From   *time.Time `json:"from"`
+	To     *time.Time `json:"to"`
+	Until  *time.Time `json:"until"`
+	Locked bool       `json:"locked"`
+}
+
+// Holds handles /api/store/holds: GET lists the holds, POST places one
+// keeping the objects with entries between from and to immutable until it
+// expires or is released
+func (sh *StoreHandler) Holds(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+	if sh.archive == nil {
+		writeError(w, r, ErrUnavailable, "The archive is disabled, set ARCHIVE_DIR to enable it", nil)
+		return
+	}
+	if r.Method == http.MethodGet {
+		holds := sh.archive.Holds()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"data":    holds,
+			"count":   len(holds),
+		})
+		return
+	}
+
+	var req holdRequest
+	if err := decodeJSON(w, r, maxJSONBodySize, true, "Invalid hold", &req); err != nil {
+		return
+	}
+	hold, err := sh.archive.PlaceHold(archive.Hold{Reason: req.Reason, From: req.From, To: req.To, Until: req.Until, Locked: req.Locked})
+	if err != nil {
+		writeHoldError(w, r, "", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", StoreHoldsPath+"/"+hold.ID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    hold,
+	})
+}
+
+// Hold handles /api/store/holds/{id}: PUT {"until": ...} moves the expiry,
+// DELETE releases the hold. Locked holds can only be extended and are
+// released once expired.
+func (sh *StoreHandler) Hold(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, StoreHoldsPath+"/")
+	if id == "" || strings.Contains(id, "/") {
+		writeError(w, r, ErrNotFound, "No endpoint at "+r.URL.Path, nil)
+		return
+	}
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		methodNotAllowed(w, r)
+		return
+	}
+	if sh.archive == nil {
+		writeError(w, r, ErrUnavailable, "The archive is disabled, set ARCHIVE_DIR to enable it", nil)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := sh.archive.ReleaseHold(id); err != nil {
+			writeHoldError(w, r, id, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Hold " + id + " released",
+		})
+		return
+	}
+
+	var req struct {
+		Until *time.Time `json:"until"`
+	}
+	if err := decodeJSON(w, r, maxJSONBodySize, true, "Invalid hold", &req); err != nil {
+		return
+	}
+	hold, err := sh.archive.ExtendHold(id, req.Until)
+	if err != nil {
+		writeHoldError(w, r, id, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    hold,
+	})
+}
+
+func writeHoldError(w http.ResponseWriter, r *http.Request, id string, err error) {
+	var details interface{}
+	if id != "" {
+		details = map[string]interface{}{"hold_id": id}
+	}
+	switch {
+	case errors.Is(err, archive.ErrHoldNotFound):
+		writeError(w, r, ErrNotFound, err.Error(), details)
+	case errors.Is(err, archive.ErrHoldLocked):
+		writeError(w, r, ErrConflict, err.Error(), details)
+	case errors.Is(err, archive.ErrInvalidHold):
+		writeError(w, r, ErrInvalidRequest, err.Error(), details)
+	default:
+		writeError(w, r, ErrInternal, err.Error(), details)
+	}
+}
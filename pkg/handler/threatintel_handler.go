@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/threatintel"
+)
+
+// ThreatIntelHandler reports and reloads the threat intel feeds
+type ThreatIntelHandler struct {
+	intel       *threatintel.Intel // nil when no feeds are configured
+	auditLogger *audit.Logger
+}
+
+// NewThreatIntelHandler creates a new threat intel handler
+func NewThreatIntelHandler(intel *threatintel.Intel, auditLogger *audit.Logger) *ThreatIntelHandler {
+	return &ThreatIntelHandler{
+		intel:       intel,
+		auditLogger: auditLogger,
+	}
+}
+
+// ThreatIntel serves /api/threatintel: GET returns the feeds and match
+// counters, POST reloads the feeds
+func (th *ThreatIntelHandler) ThreatIntel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	if th.intel == nil {
+		if r.Method == http.MethodPost {
+			writeError(w, r, ErrNotFound, "No threat feeds configured (set THREAT_INTEL_FEEDS)", nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"enabled": false,
+		})
+		return
+	}
+
+	message := ""
+	if r.Method == http.MethodPost {
+		if err := th.intel.Reload(); err != nil {
+			th.auditLogger.LogError(err, "Threat feed reload failed", nil)
+			writeError(w, r, ErrUnavailable, err.Error(), th.intel.Status())
+			return
+		}
+		th.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: "threat_feeds_reloaded",
+			Message:   "Threat intel feeds reloaded",
+		})
+		message = "Feeds reloaded"
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"enabled": true,
+		"data":    th.intel.Status(),
+	}
+	if message != "" {
+		response["message"] = message
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
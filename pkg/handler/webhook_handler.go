@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// AuditWebhooksPath lists the audit webhooks; /{name} registers or removes
+// one
+const AuditWebhooksPath = "/api/audit/webhooks"
+
+// WebhookHandler manages the webhooks notified of audit events
+type WebhookHandler struct {
+	webhooks    *audit.Webhooks
+	auditLogger *audit.Logger
+}
+
+// NewWebhookHandler creates a new audit webhook handler
+func NewWebhookHandler(webhooks *audit.Webhooks, auditLogger *audit.Logger) *WebhookHandler {
+	return &WebhookHandler{webhooks: webhooks, auditLogger: auditLogger}
+}
+
+// List returns the webhooks with their delivery counters
+func (wh *WebhookHandler) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	webhooks := wh.webhooks.List()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    webhooks,
+		"count":   len(webhooks),
+	})
+}
+
+// Webhook serves /api/audit/webhooks/{name}: PUT registers or replaces the
+// webhook, DELETE removes it
+func (wh *WebhookHandler) Webhook(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, AuditWebhooksPath+"/"), "/")
+	if name == "" {
+		wh.List(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var config audit.WebhookConfig
+		if err := decodeJSON(w, r, maxJSONBodySize, true, "Invalid webhook", &config); err != nil {
+			return
+		}
+		if config.Name == "" {
+			config.Name = name
+		}
+		if config.Name != name {
+			writeError(w, r, ErrInvalidRequest, "The webhook name in the body does not match the path", map[string]interface{}{"name": config.Name})
+			return
+		}
+		if err := config.Validate(); err != nil {
+			writeError(w, r, ErrInvalidRequest, "Invalid webhook: "+err.Error(), nil)
+			return
+		}
+		if err := wh.webhooks.Set(config); err != nil {
+			writeError(w, r, ErrInternal, "Failed to save webhook: "+err.Error(), nil)
+			return
+		}
+		wh.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: "audit_webhook_set",
+			Message:   "Audit webhook " + name + " registered",
+			Details:   map[string]interface{}{"webhook": name, "events": config.Events},
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Webhook registered",
+		})
+	case http.MethodDelete:
+		if err := wh.webhooks.Delete(name); err != nil {
+			if errors.Is(err, audit.ErrWebhookNotFound) {
+				writeError(w, r, ErrNotFound, "Webhook not found", map[string]interface{}{"webhook": name})
+				return
+			}
+			writeError(w, r, ErrInternal, "Failed to remove webhook: "+err.Error(), nil)
+			return
+		}
+		wh.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: "audit_webhook_deleted",
+			Message:   "Audit webhook " + name + " removed",
+			Details:   map[string]interface{}{"webhook": name},
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Webhook removed",
+		})
+	default:
+		methodNotAllowed(w, r)
+	}
+}
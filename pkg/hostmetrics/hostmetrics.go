@@ -0,0 +1,184 @@
+// Package hostmetrics samples host CPU, memory, disk and load at an interval
+// so log entries can carry the system state at the time they occurred.
+package hostmetrics
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// SourceHostMetrics is the source of periodic metric entries
+const SourceHostMetrics collector.LogSource = "host_metrics"
+
+// ErrUnsupported is returned on platforms without a metrics reader
+var ErrUnsupported = errors.New("host metrics are not supported on this platform")
+
+// Snapshot is one sample of the host state
+type Snapshot struct {
+	SampledAt time.Time `json:"sampled_at"`
+
+	// CPUPercent is the busy share of all CPUs since the previous sample
+	CPUPercent float64 `json:"cpu_percent"`
+	Load1      float64 `json:"load1"`
+	Load5      float64 `json:"load5"`
+	Load15     float64 `json:"load15"`
+
+	MemTotalBytes     uint64  `json:"mem_total_bytes"`
+	MemAvailableBytes uint64  `json:"mem_available_bytes"`
+	MemUsedPercent    float64 `json:"mem_used_percent"`
+
+	DiskPath        string  `json:"disk_path"`
+	DiskTotalBytes  uint64  `json:"disk_total_bytes"`
+	DiskFreeBytes   uint64  `json:"disk_free_bytes"`
+	DiskUsedPercent float64 `json:"disk_used_percent"`
+}
+
+// Fields returns the snapshot as a parsed_data value. A new map is returned
+// on every call since entries own their parsed data.
+func (s Snapshot) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"sampled_at":          s.SampledAt,
+		"cpu_percent":         s.CPUPercent,
+		"load1":               s.Load1,
+		"load5":               s.Load5,
+		"load15":              s.Load15,
+		"mem_total_bytes":     s.MemTotalBytes,
+		"mem_available_bytes": s.MemAvailableBytes,
+		"mem_used_percent":    s.MemUsedPercent,
+		"disk_path":           s.DiskPath,
+		"disk_total_bytes":    s.DiskTotalBytes,
+		"disk_free_bytes":     s.DiskFreeBytes,
+		"disk_used_percent":   s.DiskUsedPercent,
+	}
+}
+
+// Config configures a Sampler
+type Config struct {
+	// Interval between samples; defaults to 15s
+	Interval time.Duration
+	// DiskPath is the filesystem whose usage is reported; defaults to "/"
+	DiskPath string
+	// OnSample is called with every new snapshot
+	OnSample func(Snapshot)
+}
+
+// Sampler samples the host at an interval and keeps the latest snapshot
+type Sampler struct {
+	config Config
+	reader *reader
+	latest atomic.Pointer[Snapshot]
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// New creates a sampler. It fails with ErrUnsupported on platforms without
+// a metrics reader.
+func New(cfg Config) (*Sampler, error) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 15 * time.Second
+	}
+	if cfg.DiskPath == "" {
+		cfg.DiskPath = "/"
+	}
+	reader, err := newReader(cfg.DiskPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Sampler{
+		config: cfg,
+		reader: reader,
+		stop:   make(chan struct{}),
+	}, nil
+}
+
+// Start samples in the background until Close. CPU usage is measured
+// between samples, so the first snapshot is available after one interval.
+func (s *Sampler) Start() {
+	go func() {
+		ticker := time.NewTicker(s.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.sample()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// sample takes a snapshot; failed reads keep the previous snapshot
+func (s *Sampler) sample() {
+	snapshot, err := s.reader.read()
+	if err != nil {
+		return
+	}
+	snapshot.SampledAt = time.Now().UTC()
+	s.latest.Store(&snapshot)
+	if s.config.OnSample != nil {
+		s.config.OnSample(snapshot)
+	}
+}
+
+// Latest returns the latest snapshot, or nil before the first one
+func (s *Sampler) Latest() *Snapshot {
+	return s.latest.Load()
+}
+
+// Close stops sampling
+func (s *Sampler) Close() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+}
+
+// Processor attaches the latest snapshot as parsed_data.host_metrics to
+// entries at minLevel or above
+func (s *Sampler) Processor(minLevel collector.LogLevel) collector.Processor {
+	return collector.ProcessorFunc(func(entry *collector.SystemLog) bool {
+		if entry.Source == SourceHostMetrics || !entry.Level.AtLeast(minLevel) {
+			return true
+		}
+		snapshot := s.Latest()
+		if snapshot == nil {
+			return true
+		}
+		if entry.ParsedData == nil {
+			entry.ParsedData = make(map[string]interface{})
+		}
+		entry.ParsedData["host_metrics"] = snapshot.Fields()
+		return true
+	})
+}
+
+// Log returns the snapshot as a metric log entry
+func (s Snapshot) Log() collector.SystemLog {
+	host, _ := os.Hostname()
+	return collector.SystemLog{
+		Timestamp: s.SampledAt,
+		Source:    SourceHostMetrics,
+		Level:     collector.LevelInfo,
+		Message: fmt.Sprintf("cpu %.1f%% mem %.1f%% disk %.1f%% load %.2f %.2f %.2f",
+			s.CPUPercent, s.MemUsedPercent, s.DiskUsedPercent, s.Load1, s.Load5, s.Load15),
+		Host:       host,
+		Service:    "gonder",
+		ParsedData: s.Fields(),
+		Tags:       []string{"metrics", "host"},
+	}
+}
+
+// percent returns part/total in percent, rounded to two decimals
+func percent(part, total float64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(int64(part/total*10000+0.5)) / 100
+}
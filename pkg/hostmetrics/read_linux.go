@@ -0,0 +1,150 @@
+//go:build linux
+
+package hostmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// reader reads host metrics from /proc and statfs
+type reader struct {
+	diskPath string
+
+	// CPU counters of the previous sample
+	prevBusy, prevTotal uint64
+}
+
+func newReader(diskPath string) (*reader, error) {
+	r := &reader{diskPath: diskPath}
+	busy, total, err := readCPU()
+	if err != nil {
+		return nil, err
+	}
+	r.prevBusy, r.prevTotal = busy, total
+	return r, nil
+}
+
+// read takes a snapshot; it is only called from the sampler goroutine
+func (r *reader) read() (Snapshot, error) {
+	var snapshot Snapshot
+
+	busy, total, err := readCPU()
+	if err != nil {
+		return snapshot, err
+	}
+	if total > r.prevTotal {
+		snapshot.CPUPercent = percent(float64(busy-r.prevBusy), float64(total-r.prevTotal))
+	}
+	r.prevBusy, r.prevTotal = busy, total
+
+	if err := readLoad(&snapshot); err != nil {
+		return snapshot, err
+	}
+	if err := readMemory(&snapshot); err != nil {
+		return snapshot, err
+	}
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(r.diskPath, &fs); err != nil {
+		return snapshot, fmt.Errorf("statfs %s: %w", r.diskPath, err)
+	}
+	snapshot.DiskPath = r.diskPath
+	snapshot.DiskTotalBytes = fs.Blocks * uint64(fs.Bsize)
+	snapshot.DiskFreeBytes = fs.Bavail * uint64(fs.Bsize)
+	used := (fs.Blocks - fs.Bfree) * uint64(fs.Bsize)
+	// Like df, the reserved blocks count as neither used nor available
+	snapshot.DiskUsedPercent = percent(float64(used), float64(used+snapshot.DiskFreeBytes))
+	return snapshot, nil
+}
+
+// readCPU returns the busy and total jiffies of all CPUs from /proc/stat
+func readCPU() (busy, total uint64, err error) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, fmt.Errorf("unexpected /proc/stat format")
+	}
+	for i, field := range fields[1:] {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unexpected /proc/stat format: %w", err)
+		}
+		// guest and guest_nice are already included in user and nice
+		if i >= 8 {
+			break
+		}
+		total += value
+		// idle and iowait
+		if i != 3 && i != 4 {
+			busy += value
+		}
+	}
+	return busy, total, nil
+}
+
+// readLoad reads the load averages from /proc/loadavg
+func readLoad(snapshot *Snapshot) error {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return fmt.Errorf("unexpected /proc/loadavg format")
+	}
+	loads := []*float64{&snapshot.Load1, &snapshot.Load5, &snapshot.Load15}
+	for i, load := range loads {
+		if *load, err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return fmt.Errorf("unexpected /proc/loadavg format: %w", err)
+		}
+	}
+	return nil
+}
+
+// readMemory reads total and available memory from /proc/meminfo
+func readMemory(snapshot *Snapshot) error {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		var target *uint64
+		switch name {
+		case "MemTotal":
+			target = &snapshot.MemTotalBytes
+		case "MemAvailable":
+			target = &snapshot.MemAvailableBytes
+		default:
+			continue
+		}
+		kb, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("unexpected /proc/meminfo format: %w", err)
+		}
+		*target = kb * 1024
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if snapshot.MemTotalBytes == 0 {
+		return fmt.Errorf("MemTotal missing from /proc/meminfo")
+	}
+	snapshot.MemUsedPercent = percent(float64(snapshot.MemTotalBytes-snapshot.MemAvailableBytes), float64(snapshot.MemTotalBytes))
+	return nil
+}
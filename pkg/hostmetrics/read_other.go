@@ -0,0 +1,14 @@
+//go:build !linux
+
+package hostmetrics
+
+// reader is not implemented on this platform
+type reader struct{}
+
+func newReader(diskPath string) (*reader, error) {
+	return nil, ErrUnsupported
+}
+
+func (r *reader) read() (Snapshot, error) {
+	return Snapshot{}, ErrUnsupported
+}
@@ -0,0 +1,456 @@
+// Package jobs runs long operations (backfills, replays, exports, sweeps)
+// in the background and keeps a record of each: its kind and parameters,
+// state, progress and error. Records are optionally persisted to a file so
+// the history survives restarts; jobs that were running when the process
+// stopped are marked interrupted since their work can't be resumed. Every
+// state transition is an audit event.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// DefaultKeep is the number of finished jobs kept when Config.Keep is 0
+const DefaultKeep = 100
+
+// saveInterval is how often the progress of running jobs is persisted
+const saveInterval = 10 * time.Second
+
+var (
+	// ErrNotFound is returned for unknown job IDs
+	ErrNotFound = errors.New("job not found")
+	// ErrFinished is returned when cancelling a job that already ended
+	ErrFinished = errors.New("job already finished")
+)
+
+// State is the lifecycle state of a job
+type State string
+
+const (
+	StateRunning   State = "running"
+	StateCompleted State = "completed"
+	StateFailed    State = "failed"
+	StateCancelled State = "cancelled"
+	// StateInterrupted marks jobs that were running when the process
+	// stopped
+	StateInterrupted State = "interrupted"
+)
+
+// Progress is what a job reports about its work
+type Progress struct {
+	Done  int64 `json:"done"`
+	Total int64 `json:"total,omitempty"`
+	// Unit names what Done and Total count, e.g. "bytes"
+	Unit    string  `json:"unit,omitempty"`
+	Percent float64 `json:"percent"`
+	// Details are kind specific, e.g. the files of a backfill
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// Record is the state of a job
+type Record struct {
+	ID         string          `json:"id"`
+	Kind       string          `json:"kind"`
+	State      State           `json:"state"`
+	Params     json.RawMessage `json:"params,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Progress   Progress        `json:"progress"`
+}
+
+// Func does the work of a job. It should return soon after ctx is
+// cancelled; the job is then cancelled whatever Func returns.
+type Func func(ctx context.Context, job *Job) error
+
+// Job is the handle a Func reports its progress through
+type Job struct {
+	mu     sync.Mutex
+	record Record
+	report func() Progress
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Report registers fn to be called for the progress of the job whenever it
+// is listed or saved, so the job can keep its counters however it likes
+func (j *Job) Report(fn func() Progress) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.report = fn
+}
+
+// ID returns the job ID
+func (j *Job) ID() string {
+	return j.record.ID
+}
+
+// snapshot returns the record with the current progress
+func (j *Job) snapshot() Record {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.report != nil {
+		j.record.Progress = j.report()
+		if j.record.State != StateRunning {
+			// Final progress is kept; the reporter may hold large state
+			j.report = nil
+		}
+	}
+	if p := &j.record.Progress; p.Total > 0 {
+		p.Percent = float64(p.Done) * 100 / float64(p.Total)
+	}
+	if j.record.State == StateCompleted {
+		j.record.Progress.Percent = 100
+	}
+	return j.record
+}
+
+func (j *Job) finished() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.record.State != StateRunning
+}
+
+// Config configures a Manager
+type Config struct {
+	// File persists the job records; empty keeps them in memory
+	File string
+	// Keep is the number of finished jobs kept; defaults to DefaultKeep
+	Keep int
+}
+
+// Manager runs jobs and keeps their records
+type Manager struct {
+	config      Config
+	auditLogger *audit.Logger
+
+	mu     sync.Mutex
+	jobs   map[string]*Job
+	order  []string
+	closed bool
+	wg     sync.WaitGroup
+
+	saveMu sync.Mutex
+	stop   chan struct{}
+}
+
+// New creates a manager and loads the records of cfg.File
+func New(cfg Config, auditLogger *audit.Logger) (*Manager, error) {
+	if cfg.Keep <= 0 {
+		cfg.Keep = DefaultKeep
+	}
+	m := &Manager{
+		config:      cfg,
+		auditLogger: auditLogger,
+		jobs:        make(map[string]*Job),
+		stop:        make(chan struct{}),
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	go m.saveLoop()
+	return m, nil
+}
+
+// load reads the persisted records, marking jobs left running as
+// interrupted
+func (m *Manager) load() error {
+	if m.config.File == "" {
+		return nil
+	}
+	data, err := os.ReadFile(m.config.File)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read jobs file %s: %w", m.config.File, err)
+	}
+	var records []Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("failed to decode jobs file %s: %w", m.config.File, err)
+	}
+
+	interrupted := 0
+	for _, record := range records {
+		if record.State == StateRunning {
+			record.State = StateInterrupted
+			record.Error = "gonder stopped while the job was running"
+			now := time.Now()
+			record.FinishedAt = &now
+			interrupted++
+			m.auditLogger.LogEvent(audit.AuditEvent{
+				EventType: "job_interrupted",
+				Message:   fmt.Sprintf("Job %s (%s) was interrupted by a restart", record.ID, record.Kind),
+				Details:   map[string]interface{}{"job_id": record.ID, "kind": record.Kind},
+			})
+		}
+		done := make(chan struct{})
+		close(done)
+		m.jobs[record.ID] = &Job{record: record, done: done}
+		m.order = append(m.order, record.ID)
+	}
+	if interrupted > 0 {
+		return m.save()
+	}
+	return nil
+}
+
+// Start records a job of kind with params and runs fn in the background
+func (m *Manager) Start(kind string, params interface{}, fn Func) (Record, error) {
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to encode job parameters: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		record: Record{
+			ID:        newJobID(),
+			Kind:      kind,
+			State:     StateRunning,
+			Params:    encoded,
+			CreatedAt: time.Now(),
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		cancel()
+		return Record{}, fmt.Errorf("job manager is closed")
+	}
+	m.jobs[job.record.ID] = job
+	m.order = append(m.order, job.record.ID)
+	m.wg.Add(1)
+	m.mu.Unlock()
+
+	m.auditLogger.LogEvent(audit.AuditEvent{
+		EventType: "job_started",
+		Message:   fmt.Sprintf("Job %s (%s) started", job.record.ID, kind),
+		Details:   map[string]interface{}{"job_id": job.record.ID, "kind": kind, "params": params},
+	})
+	m.saveOrLog()
+	go m.run(ctx, job, fn)
+	return job.snapshot(), nil
+}
+
+// run runs fn and records how it ended
+func (m *Manager) run(ctx context.Context, job *Job, fn Func) {
+	defer m.wg.Done()
+	defer close(job.done)
+
+	err := fn(ctx, job)
+	stopped := ctx.Err() != nil
+	job.cancel()
+
+	m.mu.Lock()
+	closing := m.closed
+	m.mu.Unlock()
+
+	job.mu.Lock()
+	now := time.Now()
+	job.record.FinishedAt = &now
+	switch {
+	case stopped && closing:
+		job.record.State = StateInterrupted
+		job.record.Error = "gonder stopped while the job was running"
+	case stopped:
+		job.record.State = StateCancelled
+	case err != nil:
+		job.record.State = StateFailed
+		job.record.Error = err.Error()
+	default:
+		job.record.State = StateCompleted
+	}
+	job.mu.Unlock()
+	record := job.snapshot()
+
+	m.auditLogger.LogEvent(audit.AuditEvent{
+		EventType: audit.EventType("job_" + string(record.State)),
+		Message:   fmt.Sprintf("Job %s (%s) %s", record.ID, record.Kind, record.State),
+		Error:     record.Error,
+		Details: map[string]interface{}{
+			"job_id":   record.ID,
+			"kind":     record.Kind,
+			"done":     record.Progress.Done,
+			"total":    record.Progress.Total,
+			"unit":     record.Progress.Unit,
+			"duration": now.Sub(record.CreatedAt).String(),
+		},
+	})
+
+	m.mu.Lock()
+	m.prune()
+	m.mu.Unlock()
+	m.saveOrLog()
+}
+
+// prune forgets the oldest finished jobs beyond Keep. m.mu must be held.
+func (m *Manager) prune() {
+	finished := 0
+	for i := len(m.order) - 1; i >= 0; i-- {
+		id := m.order[i]
+		if m.jobs[id].finished() {
+			finished++
+			if finished > m.config.Keep {
+				delete(m.jobs, id)
+				m.order = append(m.order[:i], m.order[i+1:]...)
+			}
+		}
+	}
+}
+
+// Get returns the record of a job
+func (m *Manager) Get(id string) (Record, error) {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		return Record{}, ErrNotFound
+	}
+	return job.snapshot(), nil
+}
+
+// List returns the records of the kept jobs of kind (all kinds when
+// empty), newest first
+func (m *Manager) List(kind string) []Record {
+	records := []Record{}
+	for _, job := range m.all() {
+		if kind == "" || job.record.Kind == kind {
+			records = append(records, job.snapshot())
+		}
+	}
+	return records
+}
+
+// all returns the kept jobs, newest first
+func (m *Manager) all() []*Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]*Job, 0, len(m.order))
+	for i := len(m.order) - 1; i >= 0; i-- {
+		jobs = append(jobs, m.jobs[m.order[i]])
+	}
+	return jobs
+}
+
+// Cancel stops a running job and waits for it to end
+func (m *Manager) Cancel(id string) (Record, error) {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		return Record{}, ErrNotFound
+	}
+	if job.finished() {
+		return job.snapshot(), ErrFinished
+	}
+	job.cancel()
+	<-job.done
+	return job.snapshot(), nil
+}
+
+// Close cancels the running jobs, waits for them and saves the records
+func (m *Manager) Close() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	for _, job := range m.jobs {
+		if job.cancel != nil {
+			job.cancel()
+		}
+	}
+	m.mu.Unlock()
+	m.wg.Wait()
+	close(m.stop)
+	m.saveOrLog()
+}
+
+// saveLoop persists the progress of running jobs
+func (m *Manager) saveLoop() {
+	if m.config.File == "" {
+		return
+	}
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			for _, job := range m.all() {
+				if !job.finished() {
+					m.saveOrLog()
+					break
+				}
+			}
+		}
+	}
+}
+
+func (m *Manager) saveOrLog() {
+	if err := m.save(); err != nil {
+		m.auditLogger.LogError(err, "Failed to save job records", map[string]interface{}{"file": m.config.File})
+	}
+}
+
+// save atomically writes the records, oldest first
+func (m *Manager) save() error {
+	if m.config.File == "" {
+		return nil
+	}
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+
+	jobs := m.all()
+	records := make([]Record, len(jobs))
+	for i, job := range jobs {
+		records[len(jobs)-1-i] = job.snapshot()
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(m.config.File)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create jobs directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(m.config.File)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create jobs file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write jobs file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), m.config.File); err != nil {
+		return fmt.Errorf("failed to replace jobs file: %w", err)
+	}
+	return nil
+}
+
+func newJobID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return "job_" + hex.EncodeToString(b[:])
+}
@@ -0,0 +1,157 @@
+// Package kubernetes watches Kubernetes Events through the API server and
+// turns them into log entries, so scheduling failures, OOM kills and other
+// cluster events appear next to application logs.
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// SourceKubernetesEvents is the source type of Kubernetes events
+const SourceKubernetesEvents collector.LogSource = "kubernetes_events"
+
+func init() {
+	collector.RegisterParser(SourceKubernetesEvents, collector.ParserFunc(parseEvent))
+}
+
+// Event is the subset of a core/v1 Event gonder uses
+type Event struct {
+	Metadata struct {
+		Name              string    `json:"name"`
+		Namespace         string    `json:"namespace"`
+		UID               string    `json:"uid"`
+		ResourceVersion   string    `json:"resourceVersion"`
+		CreationTimestamp time.Time `json:"creationTimestamp"`
+	} `json:"metadata"`
+	InvolvedObject ObjectReference `json:"involvedObject"`
+	Reason         string          `json:"reason"`
+	Message        string          `json:"message"`
+	Source         struct {
+		Component string `json:"component"`
+		Host      string `json:"host"`
+	} `json:"source"`
+	FirstTimestamp     *time.Time `json:"firstTimestamp"`
+	LastTimestamp      *time.Time `json:"lastTimestamp"`
+	EventTime          *time.Time `json:"eventTime"`
+	Count              int        `json:"count"`
+	Type               string     `json:"type"`
+	ReportingComponent string     `json:"reportingComponent"`
+	ReportingInstance  string     `json:"reportingInstance"`
+	Series             *struct {
+		Count            int        `json:"count"`
+		LastObservedTime *time.Time `json:"lastObservedTime"`
+	} `json:"series"`
+}
+
+// ObjectReference identifies the object an event is about
+type ObjectReference struct {
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	UID        string `json:"uid"`
+	APIVersion string `json:"apiVersion"`
+	FieldPath  string `json:"fieldPath"`
+}
+
+// errorReasons are Warning events that mean a workload was killed or
+// evicted; they are logged at error level
+var errorReasons = map[string]bool{
+	"OOMKilling":   true,
+	"OOMKilled":    true,
+	"Evicted":      true,
+	"NodeNotReady": true,
+}
+
+// Level maps the event type to a log level
+func (e *Event) Level() collector.LogLevel {
+	switch {
+	case e.Type == "Warning" && errorReasons[e.Reason]:
+		return collector.LevelError
+	case e.Type == "Warning":
+		return collector.LevelWarn
+	default:
+		return collector.LevelInfo
+	}
+}
+
+// Timestamp is the time the event was last observed
+func (e *Event) Timestamp() time.Time {
+	switch {
+	case e.Series != nil && e.Series.LastObservedTime != nil:
+		return *e.Series.LastObservedTime
+	case e.LastTimestamp != nil && !e.LastTimestamp.IsZero():
+		return *e.LastTimestamp
+	case e.EventTime != nil && !e.EventTime.IsZero():
+		return *e.EventTime
+	default:
+		return e.Metadata.CreationTimestamp
+	}
+}
+
+// occurrences is how often the event happened
+func (e *Event) occurrences() int {
+	if e.Series != nil && e.Series.Count > 0 {
+		return e.Series.Count
+	}
+	if e.Count > 0 {
+		return e.Count
+	}
+	return 1
+}
+
+// objectName is "Kind namespace/name" of the involved object
+func (e *Event) objectName() string {
+	name := e.InvolvedObject.Name
+	if e.InvolvedObject.Namespace != "" {
+		name = e.InvolvedObject.Namespace + "/" + name
+	}
+	return e.InvolvedObject.Kind + " " + name
+}
+
+// parseEvent parses an event emitted by the watcher as JSON
+func parseEvent(line string, config collector.LogSourceConfig, entry *collector.SystemLog) bool {
+	var event Event
+	if err := json.Unmarshal([]byte(line), &event); err != nil || event.Metadata.UID == "" {
+		return false
+	}
+
+	entry.Timestamp = event.Timestamp().UTC()
+	entry.Level = event.Level()
+	entry.Message = fmt.Sprintf("%s: %s: %s", event.objectName(), event.Reason, event.Message)
+	entry.Service = event.Source.Component
+	if entry.Service == "" {
+		entry.Service = event.ReportingComponent
+	}
+	entry.Host = event.Source.Host
+	if entry.Host == "" {
+		entry.Host = event.ReportingInstance
+	}
+
+	// Tags of the source config are shared between entries, so copy them
+	tags := make([]string, 0, len(entry.Tags)+4)
+	tags = append(tags, entry.Tags...)
+	tags = append(tags, "kubernetes", "event", "reason:"+event.Reason, "kind:"+event.InvolvedObject.Kind)
+	entry.Tags = tags
+
+	entry.ParsedData["type"] = event.Type
+	entry.ParsedData["reason"] = event.Reason
+	entry.ParsedData["namespace"] = event.Metadata.Namespace
+	entry.ParsedData["count"] = event.occurrences()
+	entry.ParsedData["event_uid"] = event.Metadata.UID
+	entry.ParsedData["involved_object"] = map[string]interface{}{
+		"kind":        event.InvolvedObject.Kind,
+		"namespace":   event.InvolvedObject.Namespace,
+		"name":        event.InvolvedObject.Name,
+		"uid":         event.InvolvedObject.UID,
+		"api_version": event.InvolvedObject.APIVersion,
+		"field_path":  event.InvolvedObject.FieldPath,
+	}
+	if event.FirstTimestamp != nil && !event.FirstTimestamp.IsZero() {
+		entry.ParsedData["first_timestamp"] = event.FirstTimestamp.UTC()
+	}
+	return true
+}
@@ -0,0 +1,250 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// In-cluster service account files
+const (
+	serviceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCA    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// watchTimeout is how long the API server keeps one watch open; the watch
+// is then resumed from the last resource version
+const watchTimeout = 5 * time.Minute
+
+// errExpired reports a resource version the API server no longer has
+// (410 Gone); the watcher lists again to get a current one
+var errExpired = errors.New("resource version expired")
+
+// Config configures the events watcher
+type Config struct {
+	// Name of the source; defaults to "kubernetes-events"
+	Name string
+	// Namespace limits the watch to one namespace; empty watches all
+	Namespace string
+	// APIServer is the API server URL. Empty uses the in-cluster address
+	// and service account token and CA.
+	APIServer string
+	// TokenFile holds a bearer token; it is re-read on every request so
+	// rotated projected tokens are picked up
+	TokenFile string
+	// CAFile verifies the API server certificate
+	CAFile string
+	Tags   []string
+}
+
+// EventSource is a collector source watching Kubernetes Events. It starts
+// at the current resource version, so events recorded before it started
+// (or while it was restarting) are not replayed.
+type EventSource struct {
+	config    Config
+	eventsURL string
+	client    *http.Client
+}
+
+// NewEventSource creates an events watcher
+func NewEventSource(cfg Config) (*EventSource, error) {
+	if cfg.Name == "" {
+		cfg.Name = "kubernetes-events"
+	}
+	if cfg.APIServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("not running in a cluster (KUBERNETES_SERVICE_HOST is not set); configure the API server URL")
+		}
+		cfg.APIServer = "https://" + net.JoinHostPort(host, port)
+		if cfg.TokenFile == "" {
+			cfg.TokenFile = serviceAccountToken
+		}
+		if cfg.CAFile == "" {
+			cfg.CAFile = serviceAccountCA
+		}
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(cfg.APIServer, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid API server URL: %s", cfg.APIServer)
+	}
+	path := "/api/v1/events"
+	if cfg.Namespace != "" {
+		path = "/api/v1/namespaces/" + url.PathEscape(cfg.Namespace) + "/events"
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = 30 * time.Second
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cfg.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	return &EventSource{
+		config:    cfg,
+		eventsURL: base.String() + path,
+		// No client timeout: watches are long-lived and end with ctx
+		client: &http.Client{Transport: transport},
+	}, nil
+}
+
+// Config implements collector.Source
+func (s *EventSource) Config() collector.LogSourceConfig {
+	return collector.LogSourceConfig{
+		Name:    s.config.Name,
+		Source:  SourceKubernetesEvents,
+		Path:    s.eventsURL,
+		Enabled: true,
+		Tags:    s.config.Tags,
+	}
+}
+
+// Run lists events for the current resource version and watches from
+// there, emitting added and updated events as JSON lines
+func (s *EventSource) Run(ctx context.Context, emit func(line string)) error {
+	version, err := s.list(ctx)
+	if err != nil {
+		return err
+	}
+	for {
+		version, err = s.watch(ctx, version, emit)
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case errors.Is(err, errExpired):
+			if version, err = s.list(ctx); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		}
+	}
+}
+
+// list returns the current resource version of the event list
+func (s *EventSource) list(ctx context.Context) (string, error) {
+	resp, err := s.get(ctx, url.Values{"limit": {"1"}})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return "", fmt.Errorf("failed to decode event list: %w", err)
+	}
+	return list.Metadata.ResourceVersion, nil
+}
+
+// watch streams events after version until the server ends the watch. It
+// returns the last resource version seen.
+func (s *EventSource) watch(ctx context.Context, version string, emit func(line string)) (string, error) {
+	resp, err := s.get(ctx, url.Values{
+		"watch":               {"1"},
+		"resourceVersion":     {version},
+		"allowWatchBookmarks": {"true"},
+		"timeoutSeconds":      {fmt.Sprint(int(watchTimeout.Seconds()))},
+	})
+	if err != nil {
+		return version, err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := decoder.Decode(&event); err != nil {
+			if err == io.EOF {
+				return version, nil
+			}
+			return version, fmt.Errorf("watch stream: %w", err)
+		}
+
+		if event.Type == "ERROR" {
+			var status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			json.Unmarshal(event.Object, &status)
+			if status.Code == http.StatusGone {
+				return version, errExpired
+			}
+			return version, fmt.Errorf("watch error %d: %s", status.Code, status.Message)
+		}
+
+		var object struct {
+			Metadata struct {
+				ResourceVersion string `json:"resourceVersion"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(event.Object, &object); err == nil && object.Metadata.ResourceVersion != "" {
+			version = object.Metadata.ResourceVersion
+		}
+
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			var line bytes.Buffer
+			if err := json.Compact(&line, event.Object); err == nil {
+				emit(line.String())
+			}
+		}
+	}
+}
+
+// get requests the events URL with the given query
+func (s *EventSource) get(ctx context.Context, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.eventsURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if s.config.TokenFile != "" {
+		token, err := os.ReadFile(s.config.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read token file: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode == http.StatusGone {
+		return nil, errExpired
+	}
+	return nil, fmt.Errorf("kubernetes API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
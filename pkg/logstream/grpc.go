@@ -0,0 +1,138 @@
+package logstream
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Codes are gRPC status codes, sent in the grpc-status trailer
+type Code int
+
+// The status codes StreamLogs ends with
+const (
+	OK                Code = 0
+	Canceled          Code = 1
+	InvalidArgument   Code = 3
+	DeadlineExceeded  Code = 4
+	ResourceExhausted Code = 8
+	Unimplemented     Code = 12
+	Internal          Code = 13
+	Unavailable       Code = 14
+	Unauthenticated   Code = 16
+)
+
+// Status is a gRPC status an error ends a call with
+type Status struct {
+	Code    Code
+	Message string
+}
+
+func (s *Status) Error() string {
+	return fmt.Sprintf("grpc status %d: %s", s.Code, s.Message)
+}
+
+// Errorf returns a Status with a formatted message
+func Errorf(code Code, format string, args ...interface{}) *Status {
+	return &Status{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// frameHeaderSize is the size of the header of a length-prefixed message:
+// a compressed flag and the big-endian message length
+const frameHeaderSize = 5
+
+// ReadMessage reads the single message of a unary request, uncompressing
+// it with the grpc-encoding of the request. Messages over max bytes are
+// rejected.
+func ReadMessage(r io.Reader, encoding string, max int) ([]byte, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			// No message is an empty request
+			return nil, nil
+		}
+		return nil, Errorf(InvalidArgument, "reading request: %v", err)
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if uint64(length) > uint64(max) {
+		return nil, Errorf(ResourceExhausted, "request of %d bytes exceeds the limit of %d", length, max)
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, Errorf(InvalidArgument, "reading request: %v", err)
+	}
+
+	if header[0] == 0 {
+		return message, nil
+	}
+	switch encoding {
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(message))
+		if err != nil {
+			return nil, Errorf(InvalidArgument, "decompressing request: %v", err)
+		}
+		message, err = io.ReadAll(io.LimitReader(zr, int64(max)+1))
+		if err != nil {
+			return nil, Errorf(InvalidArgument, "decompressing request: %v", err)
+		}
+		if len(message) > max {
+			return nil, Errorf(ResourceExhausted, "request exceeds the limit of %d bytes", max)
+		}
+		return message, nil
+	case "", "identity":
+		return nil, Errorf(InvalidArgument, "compressed request without grpc-encoding")
+	}
+	return nil, Errorf(Unimplemented, "unsupported grpc-encoding %q", encoding)
+}
+
+// AppendFrame appends message as an uncompressed length-prefixed message
+func AppendFrame(b, message []byte) []byte {
+	b = append(b, 0)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(message)))
+	return append(b, message...)
+}
+
+// ParseTimeout parses a grpc-timeout header such as "30S" or "500m"
+func ParseTimeout(value string) (time.Duration, error) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", value)
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", value)
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", value)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// EncodeMessage percent-encodes a status message for the grpc-message
+// trailer
+func EncodeMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
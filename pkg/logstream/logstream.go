@@ -0,0 +1,114 @@
+// Package logstream implements the LogStream gRPC service of
+// logstream.proto: the request in the protobuf wire format and the gRPC
+// framing over HTTP/2 (https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md),
+// so gonder serves typed live tails without generated code and the gRPC
+// runtime. The streamed entries are encoded by gonderpb.
+package logstream
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// Path is the HTTP/2 path of the StreamLogs method
+const Path = "/gonder.logstream.v1.LogStream/StreamLogs"
+
+// Request is a StreamLogsRequest
+type Request struct {
+	Query    string
+	Sources  []string
+	MinLevel string
+	Tags     []string
+	Contains string
+}
+
+// Filter returns the collector filter selecting the requested entries
+func (r *Request) Filter() (collector.Filter, error) {
+	query, err := collector.ParseQuery(r.Query)
+	if err != nil {
+		return collector.Filter{}, err
+	}
+	filter := collector.Filter{
+		MinLevel: collector.LogLevel(r.MinLevel),
+		Tags:     r.Tags,
+		Contains: r.Contains,
+		Query:    query,
+	}
+	if filter.MinLevel != "" && !filter.MinLevel.AtLeast(collector.LevelDebug) {
+		return collector.Filter{}, fmt.Errorf("unknown level %q", r.MinLevel)
+	}
+	for _, source := range r.Sources {
+		filter.Sources = append(filter.Sources, collector.LogSource(source))
+	}
+	return filter, nil
+}
+
+// Wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated protobuf message")
+
+// DecodeRequest decodes a protobuf-encoded StreamLogsRequest. Unknown
+// fields are skipped, as protobuf requires.
+func DecodeRequest(data []byte) (*Request, error) {
+	req := &Request{}
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errTruncated
+		}
+		data = data[n:]
+		field, wireType := key>>3, key&7
+
+		var payload []byte
+		switch wireType {
+		case wireVarint:
+			if _, n = binary.Uvarint(data); n <= 0 {
+				return nil, errTruncated
+			}
+			data = data[n:]
+		case wireFixed64, wireFixed32:
+			size := 8
+			if wireType == wireFixed32 {
+				size = 4
+			}
+			if len(data) < size {
+				return nil, errTruncated
+			}
+			data = data[size:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return nil, errTruncated
+			}
+			payload, data = data[n:n+int(length)], data[n+int(length):]
+		default:
+			return nil, fmt.Errorf("unsupported protobuf wire type %d", wireType)
+		}
+		if wireType != wireBytes {
+			continue
+		}
+
+		switch field {
+		case 1:
+			req.Query = string(payload)
+		case 2:
+			req.Sources = append(req.Sources, string(payload))
+		case 3:
+			req.MinLevel = string(payload)
+		case 4:
+			req.Tags = append(req.Tags, string(payload))
+		case 5:
+			req.Contains = string(payload)
+		}
+	}
+	return req, nil
+}
@@ -0,0 +1,182 @@
+package otlp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// SourceOTLP marks logs received over OTLP
+const SourceOTLP collector.LogSource = "otlp"
+
+// Content types of the two OTLP/HTTP encodings
+const (
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeJSON     = "application/json"
+)
+
+// ErrUnsupportedContentType is returned for bodies that are neither OTLP
+// protobuf nor OTLP JSON
+var ErrUnsupportedContentType = errors.New("unsupported content type")
+
+// IsProtobuf reports whether contentType is the protobuf encoding
+func IsProtobuf(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == ContentTypeProtobuf
+}
+
+// Decode decodes a request body according to its content type
+func Decode(body []byte, contentType string) (*ExportLogsRequest, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case ContentTypeProtobuf:
+		return DecodeProtobuf(body)
+	case ContentTypeJSON:
+		req := &ExportLogsRequest{}
+		if err := json.Unmarshal(body, req); err != nil {
+			return nil, err
+		}
+		return req, nil
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedContentType, contentType)
+	}
+}
+
+// Resource attributes mapped to SystemLog fields and tags
+const (
+	attrServiceName  = "service.name"
+	attrHostName     = "host.name"
+	attrProcessPID   = "process.pid"
+	attrEnvironment  = "deployment.environment"
+	attrEnvironment2 = "deployment.environment.name"
+	attrNamespace    = "service.namespace"
+)
+
+// ToSystemLogs converts every log record of a request. Resource attributes
+// fill Service, Host and PID and are kept under "resource" in ParsedData;
+// record attributes are kept under "attributes".
+func ToSystemLogs(req *ExportLogsRequest) []collector.SystemLog {
+	now := time.Now()
+	var logs []collector.SystemLog
+
+	for _, rl := range req.ResourceLogs {
+		resource := attributeMap(rl.Resource.Attributes)
+		service, _ := resource[attrServiceName].(string)
+		host, _ := resource[attrHostName].(string)
+		pid := toInt(resource[attrProcessPID])
+
+		tags := []string{"otlp"}
+		if service != "" {
+			tags = append(tags, "service:"+service)
+		}
+		for _, key := range []string{attrEnvironment, attrEnvironment2} {
+			if env, ok := resource[key].(string); ok && env != "" {
+				tags = append(tags, "env:"+env)
+				break
+			}
+		}
+		if ns, ok := resource[attrNamespace].(string); ok && ns != "" {
+			tags = append(tags, "namespace:"+ns)
+		}
+
+		for _, sl := range rl.ScopeLogs {
+			for _, record := range sl.LogRecords {
+				message := ""
+				if record.Body != nil {
+					message = record.Body.String()
+				}
+
+				parsed := map[string]interface{}{
+					"resource": resource,
+				}
+				if len(record.Attributes) > 0 {
+					parsed["attributes"] = attributeMap(record.Attributes)
+				}
+				if sl.Scope.Name != "" {
+					parsed["scope"] = sl.Scope.Name
+				}
+				if record.SeverityText != "" {
+					parsed["severity_text"] = record.SeverityText
+				}
+				if record.SeverityNumber != 0 {
+					parsed["severity_number"] = record.SeverityNumber
+				}
+				if record.TraceID != "" {
+					parsed["trace_id"] = record.TraceID
+				}
+				if record.SpanID != "" {
+					parsed["span_id"] = record.SpanID
+				}
+				if record.EventName != "" {
+					parsed["event_name"] = record.EventName
+				}
+
+				logs = append(logs, collector.SystemLog{
+					Timestamp:   recordTime(record, now),
+					Source:      SourceOTLP,
+					Level:       severityLevel(record.SeverityNumber, record.SeverityText, message),
+					Message:     message,
+					Host:        host,
+					Service:     service,
+					PID:         pid,
+					RawLog:      message,
+					ParsedData:  parsed,
+					Tags:        tags,
+					CollectedAt: now,
+				})
+			}
+		}
+	}
+	return logs
+}
+
+// recordTime returns the event time, falling back to the observed time
+func recordTime(record LogRecord, now time.Time) time.Time {
+	switch {
+	case record.TimeUnixNano != 0:
+		return time.Unix(0, int64(record.TimeUnixNano)).UTC()
+	case record.ObservedTimeUnixNano != 0:
+		return time.Unix(0, int64(record.ObservedTimeUnixNano)).UTC()
+	}
+	return now.UTC()
+}
+
+// severityLevel maps OTLP severity numbers (1-24, in ranges of four per
+// level) to log levels, falling back to the severity text and then to the
+// message keywords
+func severityLevel(number int32, text, message string) collector.LogLevel {
+	switch {
+	case number >= 21:
+		return collector.LevelFatal
+	case number >= 17:
+		return collector.LevelError
+	case number >= 13:
+		return collector.LevelWarn
+	case number >= 9:
+		return collector.LevelInfo
+	case number >= 1:
+		return collector.LevelDebug
+	}
+	if text != "" {
+		return collector.DetectLogLevel(text)
+	}
+	return collector.DetectLogLevel(message)
+}
+
+func toInt(v interface{}) int {
+	switch n := v.(type) {
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	case string:
+		i, _ := strconv.Atoi(n)
+		return i
+	}
+	return 0
+}
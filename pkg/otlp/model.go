@@ -0,0 +1,236 @@
+// Package otlp decodes OpenTelemetry OTLP/HTTP log export requests, in both
+// the protobuf and the JSON encoding, and maps log records to SystemLogs.
+// Only the logs signal is supported.
+package otlp
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// ExportLogsRequest is an OTLP ExportLogsServiceRequest
+type ExportLogsRequest struct {
+	ResourceLogs []ResourceLogs `json:"resourceLogs"`
+}
+
+// ResourceLogs groups the logs of one resource (e.g. a service instance)
+type ResourceLogs struct {
+	Resource  Resource    `json:"resource"`
+	ScopeLogs []ScopeLogs `json:"scopeLogs"`
+	SchemaURL string      `json:"schemaUrl,omitempty"`
+}
+
+// Resource describes the entity producing logs
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+// ScopeLogs groups the logs of one instrumentation scope
+type ScopeLogs struct {
+	Scope      InstrumentationScope `json:"scope"`
+	LogRecords []LogRecord          `json:"logRecords"`
+}
+
+// InstrumentationScope identifies the library that emitted logs
+type InstrumentationScope struct {
+	Name       string     `json:"name,omitempty"`
+	Version    string     `json:"version,omitempty"`
+	Attributes []KeyValue `json:"attributes,omitempty"`
+}
+
+// LogRecord is a single OTLP log record
+type LogRecord struct {
+	TimeUnixNano         Uint64     `json:"timeUnixNano,omitempty"`
+	ObservedTimeUnixNano Uint64     `json:"observedTimeUnixNano,omitempty"`
+	SeverityNumber       int32      `json:"severityNumber,omitempty"`
+	SeverityText         string     `json:"severityText,omitempty"`
+	EventName            string     `json:"eventName,omitempty"`
+	Body                 *AnyValue  `json:"body,omitempty"`
+	Attributes           []KeyValue `json:"attributes,omitempty"`
+	Flags                uint32     `json:"flags,omitempty"`
+	// TraceID and SpanID are hex encoded, as in the OTLP JSON encoding
+	TraceID string `json:"traceId,omitempty"`
+	SpanID  string `json:"spanId,omitempty"`
+}
+
+// KeyValue is an attribute
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// AnyValue holds exactly one attribute or body value
+type AnyValue struct {
+	StringValue *string       `json:"stringValue,omitempty"`
+	BoolValue   *bool         `json:"boolValue,omitempty"`
+	IntValue    *Int64        `json:"intValue,omitempty"`
+	DoubleValue *float64      `json:"doubleValue,omitempty"`
+	ArrayValue  *ArrayValue   `json:"arrayValue,omitempty"`
+	KvlistValue *KeyValueList `json:"kvlistValue,omitempty"`
+	BytesValue  []byte        `json:"bytesValue,omitempty"`
+}
+
+// ArrayValue is a list of values
+type ArrayValue struct {
+	Values []AnyValue `json:"values"`
+}
+
+// KeyValueList is a nested map of values
+type KeyValueList struct {
+	Values []KeyValue `json:"values"`
+}
+
+// Interface converts the value to a plain Go value (string, bool, int64,
+// float64, []interface{}, map[string]interface{} or []byte)
+func (v AnyValue) Interface() interface{} {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != nil:
+		return int64(*v.IntValue)
+	case v.DoubleValue != nil:
+		return *v.DoubleValue
+	case v.ArrayValue != nil:
+		values := make([]interface{}, len(v.ArrayValue.Values))
+		for i, item := range v.ArrayValue.Values {
+			values[i] = item.Interface()
+		}
+		return values
+	case v.KvlistValue != nil:
+		return attributeMap(v.KvlistValue.Values)
+	case v.BytesValue != nil:
+		return v.BytesValue
+	}
+	return nil
+}
+
+// String renders the value as text; structured values are JSON encoded
+func (v AnyValue) String() string {
+	switch value := v.Interface().(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case bool:
+		return strconv.FormatBool(value)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	default:
+		data, _ := json.Marshal(value)
+		return string(data)
+	}
+}
+
+// Value converts a plain Go value to an AnyValue; maps and slices become
+// kvlist and array values, unknown types are rendered with fmt
+func Value(v interface{}) AnyValue {
+	switch value := v.(type) {
+	case nil:
+		return AnyValue{}
+	case string:
+		return AnyValue{StringValue: &value}
+	case bool:
+		return AnyValue{BoolValue: &value}
+	case int:
+		n := Int64(value)
+		return AnyValue{IntValue: &n}
+	case int32:
+		n := Int64(value)
+		return AnyValue{IntValue: &n}
+	case int64:
+		n := Int64(value)
+		return AnyValue{IntValue: &n}
+	case float32:
+		f := float64(value)
+		return AnyValue{DoubleValue: &f}
+	case float64:
+		return AnyValue{DoubleValue: &value}
+	case []byte:
+		return AnyValue{BytesValue: value}
+	case []interface{}:
+		values := make([]AnyValue, len(value))
+		for i, item := range value {
+			values[i] = Value(item)
+		}
+		return AnyValue{ArrayValue: &ArrayValue{Values: values}}
+	case map[string]interface{}:
+		return AnyValue{KvlistValue: &KeyValueList{Values: Attributes(value)}}
+	}
+	s := fmt.Sprint(v)
+	return AnyValue{StringValue: &s}
+}
+
+// Attributes converts a map to attributes, sorted by key
+func Attributes(m map[string]interface{}) []KeyValue {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attributes := make([]KeyValue, 0, len(keys))
+	for _, key := range keys {
+		attributes = append(attributes, KeyValue{Key: key, Value: Value(m[key])})
+	}
+	return attributes
+}
+
+// attributeMap converts attributes to a map
+func attributeMap(attributes []KeyValue) map[string]interface{} {
+	m := make(map[string]interface{}, len(attributes))
+	for _, kv := range attributes {
+		m[kv.Key] = kv.Value.Interface()
+	}
+	return m
+}
+
+// Uint64 is a uint64 that, as OTLP JSON requires, may be encoded as a
+// decimal string
+type Uint64 uint64
+
+// UnmarshalJSON accepts both numbers and strings
+func (u *Uint64) UnmarshalJSON(data []byte) error {
+	n, err := strconv.ParseUint(unquote(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid uint64 %s", data)
+	}
+	*u = Uint64(n)
+	return nil
+}
+
+// MarshalJSON encodes the value as a decimal string
+func (u Uint64) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + strconv.FormatUint(uint64(u), 10) + `"`), nil
+}
+
+// Int64 is an int64 that may be encoded as a decimal string
+type Int64 int64
+
+// MarshalJSON encodes the value as a decimal string
+func (i Int64) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + strconv.FormatInt(int64(i), 10) + `"`), nil
+}
+
+// UnmarshalJSON accepts both numbers and strings
+func (i *Int64) UnmarshalJSON(data []byte) error {
+	n, err := strconv.ParseInt(unquote(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid int64 %s", data)
+	}
+	*i = Int64(n)
+	return nil
+}
+
+func unquote(data []byte) string {
+	s := string(data)
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
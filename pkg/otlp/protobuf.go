@@ -0,0 +1,293 @@
+package otlp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// A minimal protobuf wire-format reader for the OTLP logs messages, so
+// gonder doesn't need generated code and the protobuf runtime for a single
+// endpoint. Unknown fields are skipped, as protobuf requires.
+
+// Wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated protobuf message")
+
+// wireReader iterates over the fields of one message
+type wireReader struct {
+	data []byte
+}
+
+// next returns the next field number, wire type and, for length-delimited
+// fields, its payload; for numeric fields the value is returned in n
+func (r *wireReader) next() (field int, wireType int, payload []byte, n uint64, err error) {
+	key, err := r.varint()
+	if err != nil {
+		return 0, 0, nil, 0, err
+	}
+	field, wireType = int(key>>3), int(key&7)
+
+	switch wireType {
+	case wireVarint:
+		n, err = r.varint()
+	case wireFixed64:
+		if len(r.data) < 8 {
+			return 0, 0, nil, 0, errTruncated
+		}
+		n = binary.LittleEndian.Uint64(r.data)
+		r.data = r.data[8:]
+	case wireFixed32:
+		if len(r.data) < 4 {
+			return 0, 0, nil, 0, errTruncated
+		}
+		n = uint64(binary.LittleEndian.Uint32(r.data))
+		r.data = r.data[4:]
+	case wireBytes:
+		var length uint64
+		if length, err = r.varint(); err != nil {
+			return 0, 0, nil, 0, err
+		}
+		if uint64(len(r.data)) < length {
+			return 0, 0, nil, 0, errTruncated
+		}
+		payload = r.data[:length]
+		r.data = r.data[length:]
+	default:
+		return 0, 0, nil, 0, fmt.Errorf("unsupported protobuf wire type %d", wireType)
+	}
+	return field, wireType, payload, n, err
+}
+
+func (r *wireReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	r.data = r.data[n:]
+	return v, nil
+}
+
+// forEachField calls fn for every field of a message
+func forEachField(data []byte, fn func(field, wireType int, payload []byte, n uint64) error) error {
+	r := wireReader{data: data}
+	for len(r.data) > 0 {
+		field, wireType, payload, n, err := r.next()
+		if err != nil {
+			return err
+		}
+		if err := fn(field, wireType, payload, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DecodeProtobuf decodes a protobuf-encoded ExportLogsServiceRequest
+func DecodeProtobuf(data []byte) (*ExportLogsRequest, error) {
+	req := &ExportLogsRequest{}
+	err := forEachField(data, func(field, wireType int, payload []byte, n uint64) error {
+		if field == 1 && wireType == wireBytes {
+			rl, err := decodeResourceLogs(payload)
+			if err != nil {
+				return err
+			}
+			req.ResourceLogs = append(req.ResourceLogs, rl)
+		}
+		return nil
+	})
+	return req, err
+}
+
+func decodeResourceLogs(data []byte) (ResourceLogs, error) {
+	var rl ResourceLogs
+	err := forEachField(data, func(field, wireType int, payload []byte, n uint64) error {
+		if wireType != wireBytes {
+			return nil
+		}
+		switch field {
+		case 1:
+			return forEachField(payload, func(field, wireType int, payload []byte, n uint64) error {
+				if field == 1 && wireType == wireBytes {
+					kv, err := decodeKeyValue(payload)
+					if err != nil {
+						return err
+					}
+					rl.Resource.Attributes = append(rl.Resource.Attributes, kv)
+				}
+				return nil
+			})
+		case 2:
+			sl, err := decodeScopeLogs(payload)
+			if err != nil {
+				return err
+			}
+			rl.ScopeLogs = append(rl.ScopeLogs, sl)
+		case 3:
+			rl.SchemaURL = string(payload)
+		}
+		return nil
+	})
+	return rl, err
+}
+
+func decodeScopeLogs(data []byte) (ScopeLogs, error) {
+	var sl ScopeLogs
+	err := forEachField(data, func(field, wireType int, payload []byte, n uint64) error {
+		if wireType != wireBytes {
+			return nil
+		}
+		switch field {
+		case 1:
+			return forEachField(payload, func(field, wireType int, payload []byte, n uint64) error {
+				if wireType != wireBytes {
+					return nil
+				}
+				switch field {
+				case 1:
+					sl.Scope.Name = string(payload)
+				case 2:
+					sl.Scope.Version = string(payload)
+				case 3:
+					kv, err := decodeKeyValue(payload)
+					if err != nil {
+						return err
+					}
+					sl.Scope.Attributes = append(sl.Scope.Attributes, kv)
+				}
+				return nil
+			})
+		case 2:
+			record, err := decodeLogRecord(payload)
+			if err != nil {
+				return err
+			}
+			sl.LogRecords = append(sl.LogRecords, record)
+		}
+		return nil
+	})
+	return sl, err
+}
+
+func decodeLogRecord(data []byte) (LogRecord, error) {
+	var record LogRecord
+	err := forEachField(data, func(field, wireType int, payload []byte, n uint64) error {
+		switch field {
+		case 1:
+			record.TimeUnixNano = Uint64(n)
+		case 11:
+			record.ObservedTimeUnixNano = Uint64(n)
+		case 2:
+			record.SeverityNumber = int32(n)
+		case 3:
+			record.SeverityText = string(payload)
+		case 5:
+			body, err := decodeAnyValue(payload)
+			if err != nil {
+				return err
+			}
+			record.Body = &body
+		case 6:
+			kv, err := decodeKeyValue(payload)
+			if err != nil {
+				return err
+			}
+			record.Attributes = append(record.Attributes, kv)
+		case 8:
+			record.Flags = uint32(n)
+		case 9:
+			record.TraceID = hex.EncodeToString(payload)
+		case 10:
+			record.SpanID = hex.EncodeToString(payload)
+		case 12:
+			record.EventName = string(payload)
+		}
+		return nil
+	})
+	return record, err
+}
+
+func decodeKeyValue(data []byte) (KeyValue, error) {
+	var kv KeyValue
+	err := forEachField(data, func(field, wireType int, payload []byte, n uint64) error {
+		if wireType != wireBytes {
+			return nil
+		}
+		switch field {
+		case 1:
+			kv.Key = string(payload)
+		case 2:
+			value, err := decodeAnyValue(payload)
+			if err != nil {
+				return err
+			}
+			kv.Value = value
+		}
+		return nil
+	})
+	return kv, err
+}
+
+func decodeAnyValue(data []byte) (AnyValue, error) {
+	var v AnyValue
+	err := forEachField(data, func(field, wireType int, payload []byte, n uint64) error {
+		switch field {
+		case 1:
+			s := string(payload)
+			v.StringValue = &s
+		case 2:
+			b := n != 0
+			v.BoolValue = &b
+		case 3:
+			i := Int64(int64(n))
+			v.IntValue = &i
+		case 4:
+			f := math.Float64frombits(n)
+			v.DoubleValue = &f
+		case 5:
+			array := &ArrayValue{}
+			err := forEachField(payload, func(field, wireType int, payload []byte, n uint64) error {
+				if field == 1 && wireType == wireBytes {
+					item, err := decodeAnyValue(payload)
+					if err != nil {
+						return err
+					}
+					array.Values = append(array.Values, item)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			v.ArrayValue = array
+		case 6:
+			list := &KeyValueList{}
+			err := forEachField(payload, func(field, wireType int, payload []byte, n uint64) error {
+				if field == 1 && wireType == wireBytes {
+					kv, err := decodeKeyValue(payload)
+					if err != nil {
+						return err
+					}
+					list.Values = append(list.Values, kv)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			v.KvlistValue = list
+		case 7:
+			v.BytesValue = append([]byte{}, payload...)
+		}
+		return nil
+	})
+	return v, err
+}
@@ -0,0 +1,130 @@
+// Package procinfo enriches entries that carry the PID of a process running
+// on this host with its executable, owner and container from /proc, so
+// noisy log lines can be attributed to workloads.
+package procinfo
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// ErrUnsupported is returned on platforms without /proc
+var ErrUnsupported = errors.New("process enrichment is not supported on this platform")
+
+// cacheTTL is how long process details are reused. Processes rarely change
+// owner or executable, and a PID is seldom reused within this time.
+const cacheTTL = 10 * time.Second
+
+// maxCacheSize triggers a sweep of expired cache entries
+const maxCacheSize = 4096
+
+// Process holds the details of a running process
+type Process struct {
+	PID         int
+	Command     string
+	Exe         string
+	UID         string
+	User        string
+	Cgroup      string
+	ContainerID string
+}
+
+// Fields returns the process as a parsed_data value
+func (p *Process) Fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"pid":     p.PID,
+		"command": p.Command,
+		"exe":     p.Exe,
+		"uid":     p.UID,
+		"user":    p.User,
+		"cgroup":  p.Cgroup,
+	}
+	if p.ContainerID != "" {
+		fields["container_id"] = p.ContainerID
+	}
+	return fields
+}
+
+type cacheEntry struct {
+	process *Process // nil when the process is gone
+	expires time.Time
+}
+
+// Enricher looks up processes by PID
+type Enricher struct {
+	hostnames map[string]bool
+
+	mu    sync.Mutex
+	cache map[int]cacheEntry
+}
+
+// New creates an enricher for processes of this host. It fails with
+// ErrUnsupported on platforms without /proc.
+func New() (*Enricher, error) {
+	if !supported() {
+		return nil, ErrUnsupported
+	}
+	hostnames := map[string]bool{"": true, "localhost": true}
+	if hostname, err := os.Hostname(); err == nil {
+		hostnames[strings.ToLower(hostname)] = true
+		short, _, _ := strings.Cut(hostname, ".")
+		hostnames[strings.ToLower(short)] = true
+	}
+	return &Enricher{
+		hostnames: hostnames,
+		cache:     make(map[int]cacheEntry),
+	}, nil
+}
+
+// Lookup returns the details of a running process, or nil when it is gone
+func (e *Enricher) Lookup(pid int) *Process {
+	now := time.Now()
+	e.mu.Lock()
+	cached, ok := e.cache[pid]
+	e.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.process
+	}
+
+	process, err := readProcess(pid)
+	if err != nil {
+		process = nil
+	}
+
+	e.mu.Lock()
+	if len(e.cache) >= maxCacheSize {
+		for cachedPID, entry := range e.cache {
+			if !now.Before(entry.expires) {
+				delete(e.cache, cachedPID)
+			}
+		}
+	}
+	e.cache[pid] = cacheEntry{process: process, expires: now.Add(cacheTTL)}
+	e.mu.Unlock()
+	return process
+}
+
+// Processor adds parsed_data.process to entries with the PID of a running
+// process. Entries from other hosts (e.g. forwarded by agents or relayed by
+// syslog) are left alone since their PIDs mean nothing here.
+func (e *Enricher) Processor() collector.Processor {
+	return collector.ProcessorFunc(func(entry *collector.SystemLog) bool {
+		if entry.PID <= 0 || !e.hostnames[strings.ToLower(entry.Host)] {
+			return true
+		}
+		process := e.Lookup(entry.PID)
+		if process == nil {
+			return true
+		}
+		if entry.ParsedData == nil {
+			entry.ParsedData = make(map[string]interface{})
+		}
+		entry.ParsedData["process"] = process.Fields()
+		return true
+	})
+}
@@ -0,0 +1,100 @@
+//go:build linux
+
+package procinfo
+
+import (
+	"os"
+	"os/user"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+func supported() bool {
+	_, err := os.Stat("/proc/self/status")
+	return err == nil
+}
+
+// containerIDPattern finds the 64 hex digit container ID in cgroup paths of
+// Docker (/docker/<id>), containerd and CRI-O (cri-containerd-<id>.scope,
+// crio-<id>.scope) and Podman (libpod-<id>.scope)
+var containerIDPattern = regexp.MustCompile(`(?:^|[/-])([0-9a-f]{64})(?:\.scope)?(?:/|$)`)
+
+// userNames caches uid → user name lookups
+var userNames sync.Map
+
+// readProcess reads the details of pid from /proc
+func readProcess(pid int) (*Process, error) {
+	dir := "/proc/" + strconv.Itoa(pid)
+	status, err := os.ReadFile(dir + "/status")
+	if err != nil {
+		return nil, err
+	}
+
+	process := &Process{PID: pid}
+	for _, line := range strings.Split(string(status), "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch name {
+		case "Name":
+			process.Command = strings.TrimSpace(value)
+		case "Uid":
+			// Real, effective, saved and filesystem UIDs; the real one owns it
+			if fields := strings.Fields(value); len(fields) > 0 {
+				process.UID = fields[0]
+			}
+		}
+	}
+	process.User = userName(process.UID)
+
+	// The executable link is only readable for our own processes unless
+	// running as root
+	if exe, err := os.Readlink(dir + "/exe"); err == nil {
+		process.Exe = strings.TrimSuffix(exe, " (deleted)")
+	}
+
+	if cgroup, err := os.ReadFile(dir + "/cgroup"); err == nil {
+		process.Cgroup, process.ContainerID = parseCgroup(string(cgroup))
+	}
+	return process, nil
+}
+
+// parseCgroup returns the cgroup path and container ID from a
+// /proc/<pid>/cgroup file, preferring the cgroup v2 entry
+func parseCgroup(data string) (path, containerID string) {
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		// hierarchy-ID:controllers:path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if path == "" || parts[0] == "0" {
+			path = parts[2]
+		}
+		if containerID == "" {
+			if match := containerIDPattern.FindStringSubmatch(parts[2]); match != nil {
+				containerID = match[1]
+			}
+		}
+	}
+	return path, containerID
+}
+
+// userName resolves a UID to a user name, or "" when it is unknown
+func userName(uid string) string {
+	if uid == "" {
+		return ""
+	}
+	if name, ok := userNames.Load(uid); ok {
+		return name.(string)
+	}
+	name := ""
+	if u, err := user.LookupId(uid); err == nil {
+		name = u.Username
+	}
+	userNames.Store(uid, name)
+	return name
+}
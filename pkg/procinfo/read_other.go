@@ -0,0 +1,11 @@
+//go:build !linux
+
+package procinfo
+
+func supported() bool {
+	return false
+}
+
+func readProcess(pid int) (*Process, error) {
+	return nil, ErrUnsupported
+}
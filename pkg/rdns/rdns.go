@@ -0,0 +1,149 @@
+// Package rdns reverse-resolves entry IPs into host names, with an LRU
+// cache so repeated addresses (a scanning host, a busy client) cost one
+// lookup.
+package rdns
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// Config configures a Resolver; zero values use the defaults
+type Config struct {
+	// Timeout bounds one lookup; defaults to 200ms. Lookups run on the
+	// collection path, so keep it short.
+	Timeout time.Duration
+	// CacheSize is the number of addresses kept; defaults to 10000
+	CacheSize int
+	// TTL is how long resolved names are kept; defaults to 1h
+	TTL time.Duration
+	// NegativeTTL is how long failed lookups are kept; defaults to 5m
+	NegativeTTL time.Duration
+}
+
+// cacheEntry is one cached lookup; an empty name caches a failure
+type cacheEntry struct {
+	ip      string
+	name    string
+	expires time.Time
+}
+
+// call is a lookup in progress, shared by concurrent callers
+type call struct {
+	done chan struct{}
+	name string
+}
+
+// Resolver resolves IPs to host names through the system resolver
+type Resolver struct {
+	config Config
+	lookup func(ctx context.Context, addr string) ([]string, error)
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	order    *list.List // most recently used first
+	inflight map[string]*call
+}
+
+// New creates a resolver
+func New(cfg Config) *Resolver {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 200 * time.Millisecond
+	}
+	if cfg.CacheSize <= 0 {
+		cfg.CacheSize = 10000
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = time.Hour
+	}
+	if cfg.NegativeTTL <= 0 {
+		cfg.NegativeTTL = 5 * time.Minute
+	}
+	return &Resolver{
+		config:   cfg,
+		lookup:   net.DefaultResolver.LookupAddr,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		inflight: make(map[string]*call),
+	}
+}
+
+// Resolve returns the host name of ip, or "" when it has none or the
+// lookup failed
+func (r *Resolver) Resolve(ip string) string {
+	now := time.Now()
+	r.mu.Lock()
+	if element, ok := r.entries[ip]; ok {
+		entry := element.Value.(*cacheEntry)
+		if now.Before(entry.expires) {
+			r.order.MoveToFront(element)
+			r.mu.Unlock()
+			return entry.name
+		}
+	}
+	if pending, ok := r.inflight[ip]; ok {
+		r.mu.Unlock()
+		<-pending.done
+		return pending.name
+	}
+	pending := &call{done: make(chan struct{})}
+	r.inflight[ip] = pending
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.Timeout)
+	names, err := r.lookup(ctx, ip)
+	cancel()
+	ttl := r.config.NegativeTTL
+	if err == nil && len(names) > 0 {
+		pending.name = strings.TrimSuffix(names[0], ".")
+		ttl = r.config.TTL
+	}
+
+	r.mu.Lock()
+	delete(r.inflight, ip)
+	r.store(ip, pending.name, now.Add(ttl))
+	r.mu.Unlock()
+	close(pending.done)
+	return pending.name
+}
+
+// store caches a lookup, evicting the least recently used address when
+// the cache is full. r.mu must be held.
+func (r *Resolver) store(ip, name string, expires time.Time) {
+	if element, ok := r.entries[ip]; ok {
+		entry := element.Value.(*cacheEntry)
+		entry.name, entry.expires = name, expires
+		r.order.MoveToFront(element)
+		return
+	}
+	if r.order.Len() >= r.config.CacheSize {
+		oldest := r.order.Back()
+		r.order.Remove(oldest)
+		delete(r.entries, oldest.Value.(*cacheEntry).ip)
+	}
+	r.entries[ip] = r.order.PushFront(&cacheEntry{ip: ip, name: name, expires: expires})
+}
+
+// Processor adds parsed_data.hostname to entries whose IP resolves
+func (r *Resolver) Processor() collector.Processor {
+	return collector.ProcessorFunc(func(entry *collector.SystemLog) bool {
+		if entry.IP == "" || net.ParseIP(entry.IP) == nil {
+			return true
+		}
+		name := r.Resolve(entry.IP)
+		if name == "" {
+			return true
+		}
+		if entry.ParsedData == nil {
+			entry.ParsedData = make(map[string]interface{})
+		}
+		entry.ParsedData["hostname"] = name
+		return true
+	})
+}
@@ -0,0 +1,358 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+)
+
+// Action types
+const (
+	ActionCommand = "command"
+	ActionWebhook = "webhook"
+)
+
+// maxOutput bounds the command output and webhook response kept for the
+// audit event
+const maxOutput = 4096
+
+// queueSize is the number of actions waiting to run; further actions are
+// dropped (and audited) so a flood of matches can't block collection
+const queueSize = 256
+
+// ActionConfig is a response action as written in the rules file. Args,
+// URL, Body and header values are Go templates over the match parameters:
+// the pattern's named groups, key, count, rule, source, host, service, ip,
+// user, level and message.
+type ActionConfig struct {
+	Type string `json:"type"`
+
+	// command: an absolute path listed in the policy's allowed commands; it
+	// runs without a shell
+	Command string   `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+
+	// webhook
+	URL     string            `json:"url,omitempty"`
+	Method  string            `json:"method,omitempty"` // defaults to POST
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+
+	// DryRun only records what would have run
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// Policy limits what actions may do
+type Policy struct {
+	// AllowedCommands are the absolute paths commands may run; commands
+	// are rejected when it is empty
+	AllowedCommands []string
+	// DryRun records every action without running it
+	DryRun bool
+	// Timeout bounds one action; defaults to 30s
+	Timeout time.Duration
+}
+
+// action is a compiled ActionConfig
+type action struct {
+	config  ActionConfig
+	args    []*template.Template
+	url     *template.Template
+	body    *template.Template
+	headers map[string]*template.Template
+}
+
+// compileAction validates an action against the policy
+func compileAction(cfg ActionConfig, policy Policy) (*action, error) {
+	a := &action{config: cfg}
+	switch cfg.Type {
+	case ActionCommand:
+		if !filepath.IsAbs(cfg.Command) {
+			return nil, fmt.Errorf("command must be an absolute path: %q", cfg.Command)
+		}
+		if !commandAllowed(cfg.Command, policy.AllowedCommands) {
+			return nil, fmt.Errorf("command %s is not in the allowed commands", cfg.Command)
+		}
+		for i, arg := range cfg.Args {
+			tmpl, err := parseTemplate(fmt.Sprintf("arg%d", i), arg)
+			if err != nil {
+				return nil, err
+			}
+			a.args = append(a.args, tmpl)
+		}
+	case ActionWebhook:
+		parsed, err := url.Parse(cfg.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return nil, fmt.Errorf("webhook url must be http or https: %q", cfg.URL)
+		}
+		if a.url, err = parseTemplate("url", cfg.URL); err != nil {
+			return nil, err
+		}
+		if a.body, err = parseTemplate("body", cfg.Body); err != nil {
+			return nil, err
+		}
+		a.headers = make(map[string]*template.Template, len(cfg.Headers))
+		for name, value := range cfg.Headers {
+			if a.headers[name], err = parseTemplate(name, value); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown action type %q (command, webhook)", cfg.Type)
+	}
+	return a, nil
+}
+
+// commandAllowed reports whether command is one of the allowed paths
+func commandAllowed(command string, allowed []string) bool {
+	command = filepath.Clean(command)
+	for _, path := range allowed {
+		if filepath.Clean(path) == command {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTemplate parses a template; missing parameters are errors rather
+// than "<no value>"
+func parseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template %s: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+func render(tmpl *template.Template, params map[string]string) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, params); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// job is an action queued with its parameters
+type job struct {
+	rule   string
+	action *action
+	params map[string]string
+}
+
+// runner runs actions one at a time in the background
+type runner struct {
+	policy      Policy
+	auditLogger *audit.Logger
+	client      *http.Client
+	jobs        chan job
+	done        chan struct{}
+	closeOnce   sync.Once
+
+	succeeded atomic.Uint64
+	failed    atomic.Uint64
+	dryRuns   atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+func newRunner(policy Policy, auditLogger *audit.Logger) *runner {
+	if policy.Timeout <= 0 {
+		policy.Timeout = 30 * time.Second
+	}
+	r := &runner{
+		policy:      policy,
+		auditLogger: auditLogger,
+		client:      &http.Client{Timeout: policy.Timeout},
+		jobs:        make(chan job, queueSize),
+		done:        make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// enqueue queues an action without blocking
+func (r *runner) enqueue(rule string, a *action, params map[string]string) {
+	select {
+	case r.jobs <- job{rule: rule, action: a, params: params}:
+	default:
+		r.dropped.Add(1)
+		r.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: "response_action",
+			Message:   fmt.Sprintf("Response action of rule %s dropped: queue is full", rule),
+			Error:     "action queue full",
+			Details:   map[string]interface{}{"rule": rule, "type": a.config.Type, "key": params["key"]},
+		})
+	}
+}
+
+func (r *runner) run() {
+	defer close(r.done)
+	for j := range r.jobs {
+		r.execute(j)
+	}
+}
+
+// close waits for the queued actions
+func (r *runner) close() {
+	r.closeOnce.Do(func() {
+		close(r.jobs)
+	})
+	<-r.done
+}
+
+func (r *runner) stats() map[string]uint64 {
+	return map[string]uint64{
+		"succeeded": r.succeeded.Load(),
+		"failed":    r.failed.Load(),
+		"dry_runs":  r.dryRuns.Load(),
+		"dropped":   r.dropped.Load(),
+	}
+}
+
+// execute runs an action and audits the outcome
+func (r *runner) execute(j job) {
+	started := time.Now()
+	dryRun := r.policy.DryRun || j.action.config.DryRun
+	details := map[string]interface{}{
+		"rule":    j.rule,
+		"type":    j.action.config.Type,
+		"key":     j.params["key"],
+		"dry_run": dryRun,
+	}
+
+	var output string
+	var err error
+	switch j.action.config.Type {
+	case ActionCommand:
+		output, err = r.runCommand(j, dryRun, details)
+	case ActionWebhook:
+		output, err = r.callWebhook(j, dryRun, details)
+	}
+	if output != "" {
+		details["output"] = output
+	}
+	details["duration"] = time.Since(started).String()
+
+	event := audit.AuditEvent{EventType: "response_action", Details: details}
+	switch {
+	case err != nil:
+		r.failed.Add(1)
+		details["success"] = false
+		event.Message = fmt.Sprintf("Response action %s of rule %s failed", j.action.config.Type, j.rule)
+		event.Error = err.Error()
+	case dryRun:
+		r.dryRuns.Add(1)
+		details["success"] = true
+		event.Message = fmt.Sprintf("Response action %s of rule %s (dry run)", j.action.config.Type, j.rule)
+	default:
+		r.succeeded.Add(1)
+		details["success"] = true
+		event.Message = fmt.Sprintf("Response action %s of rule %s completed", j.action.config.Type, j.rule)
+	}
+	r.auditLogger.LogEvent(event)
+}
+
+// runCommand renders the arguments and runs the command without a shell
+func (r *runner) runCommand(j job, dryRun bool, details map[string]interface{}) (string, error) {
+	args := make([]string, len(j.action.args))
+	for i, tmpl := range j.action.args {
+		arg, err := render(tmpl, j.params)
+		if err != nil {
+			return "", err
+		}
+		// A parameter taken from a log line must not turn into an option
+		if strings.HasPrefix(arg, "-") && !strings.HasPrefix(j.action.config.Args[i], "-") {
+			return "", fmt.Errorf("argument %d %q from a match parameter looks like an option", i, arg)
+		}
+		args[i] = arg
+	}
+	details["command"] = j.action.config.Command
+	details["args"] = args
+	if dryRun {
+		return "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.policy.Timeout)
+	defer cancel()
+	var out limitedBuffer
+	cmd := exec.CommandContext(ctx, j.action.config.Command, args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", r.policy.Timeout)
+	}
+	return strings.TrimSpace(out.String()), err
+}
+
+// callWebhook renders and sends the request; non-2xx responses fail
+func (r *runner) callWebhook(j job, dryRun bool, details map[string]interface{}) (string, error) {
+	target, err := render(j.action.url, j.params)
+	if err != nil {
+		return "", err
+	}
+	body, err := render(j.action.body, j.params)
+	if err != nil {
+		return "", err
+	}
+	method := j.action.config.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	details["url"] = target
+	details["method"] = method
+	if dryRun {
+		details["body"] = body
+		return "", nil
+	}
+
+	req, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	for name, tmpl := range j.action.headers {
+		value, err := render(tmpl, j.params)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set(name, value)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, maxOutput))
+	details["status_code"] = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return string(response), fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return string(response), nil
+}
+
+// limitedBuffer keeps the first maxOutput bytes written to it
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := maxOutput - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
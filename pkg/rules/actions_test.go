@@ -0,0 +1,82 @@
+package rules
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCompileAction(t *testing.T) {
+	command, err := filepath.Abs(filepath.Join("testdata", "block-ip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := Policy{AllowedCommands: []string{command}}
+	tests := []struct {
+		name   string
+		config ActionConfig
+		valid  bool
+	}{
+		{"allowed command", ActionConfig{Type: ActionCommand, Command: command, Args: []string{"{{.ip}}"}}, true},
+		{"relative path", ActionConfig{Type: ActionCommand, Command: "block-ip"}, false},
+		{"relative path in the allowed directory", ActionConfig{Type: ActionCommand, Command: filepath.Join("testdata", "block-ip")}, false},
+		{"command not allowed", ActionConfig{Type: ActionCommand, Command: filepath.Join(filepath.Dir(command), "other")}, false},
+		{"invalid argument template", ActionConfig{Type: ActionCommand, Command: command, Args: []string{"{{.ip"}}, false},
+		{"webhook", ActionConfig{Type: ActionWebhook, URL: "https://hooks.example.com/{{.rule}}"}, true},
+		{"webhook without http", ActionConfig{Type: ActionWebhook, URL: "file:///etc/passwd"}, false},
+		{"unknown type", ActionConfig{Type: "email"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := compileAction(test.config, policy); (err == nil) != test.valid {
+				t.Fatalf("compileAction() = %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestRunCommandArguments(t *testing.T) {
+	command, err := filepath.Abs(filepath.Join("testdata", "block-ip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := Policy{AllowedCommands: []string{command}}
+	tests := []struct {
+		name   string
+		args   []string
+		params map[string]string
+		// want is the rendered arguments; nil expects the action to fail
+		want []string
+	}{
+		{"parameter", []string{"{{.ip}}"}, map[string]string{"ip": "10.0.0.1"}, []string{"10.0.0.1"}},
+		{"literal option", []string{"--add", "{{.ip}}"}, map[string]string{"ip": "10.0.0.1"}, []string{"--add", "10.0.0.1"}},
+		{"parameter rendered as an option", []string{"{{.ip}}"}, map[string]string{"ip": "-flag"}, nil},
+		{"parameter rendered as a long option", []string{"--add", "{{.user}}"}, map[string]string{"user": "--exec=/bin/sh"}, nil},
+		{"parameter after a dash", []string{"-u{{.user}}"}, map[string]string{"user": "root"}, []string{"-uroot"}},
+		{"missing parameter", []string{"{{.ip}}"}, map[string]string{}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := compileAction(ActionConfig{Type: ActionCommand, Command: command, Args: test.args}, policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := &runner{policy: policy}
+			details := map[string]interface{}{}
+			// A dry run checks the arguments without running the command
+			_, err = r.runCommand(job{rule: "ssh", action: a, params: test.params}, true, details)
+			if test.want == nil {
+				if err == nil {
+					t.Fatalf("arguments %q were accepted", details["args"])
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := details["args"].([]string); !slices.Equal(got, test.want) {
+				t.Fatalf("arguments %q, want %q", got, test.want)
+			}
+		})
+	}
+}
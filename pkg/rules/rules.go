@@ -0,0 +1,482 @@
+// Package rules raises alerts when log entries matching a rule reach a
+// threshold within a time window, and runs the rule's response actions:
+// an allowlisted local command or a webhook call, with parameters
+// templated from the entry. A typical rule bans an IP after repeated SSH
+// login failures:
+//
+//	[{
+//	  "name": "ssh_bruteforce",
+//	  "tags": ["auth"],
+//	  "pattern": "Failed password for .* from (?P<ip>[0-9a-fA-F.:]+)",
+//	  "group_by": "ip",
+//	  "threshold": 5,
+//	  "window": "10m",
+//	  "cooldown": "1h",
+//	  "actions": [
+//	    {"type": "command", "command": "/usr/sbin/iptables", "args": ["-I", "INPUT", "-s", "{{.ip}}", "-j", "DROP"]},
+//	    {"type": "webhook", "url": "https://hooks.example.com/ban", "body": "{\"ip\": \"{{.ip}}\"}"}
+//	  ]
+//	}]
+//
+// Every firing and every action is recorded as an audit event.
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// maxKeys bounds the groups tracked per rule; stale groups are swept when
+// it is reached
+const maxKeys = 100000
+
+// RuleConfig is a rule as written in the rules file
+type RuleConfig struct {
+	Name string `json:"name"`
+
+	// Conditions; all given ones must hold
+	Source   collector.LogSource `json:"source,omitempty"`
+	Tags     []string            `json:"tags,omitempty"` // any of them
+	MinLevel collector.LogLevel  `json:"min_level,omitempty"`
+	// Query selects entries with the log query language, e.g.
+	// "service:sshd -user:backup"; see collector.ParseQuery
+	Query string `json:"query,omitempty"`
+	// Pattern is matched against the message; its named groups become
+	// template parameters
+	Pattern string `json:"pattern,omitempty"`
+
+	// GroupBy counts matches per value of a pattern group, an entry field
+	// (ip, host, service, source, user) or a parsed_data field; empty
+	// counts all matches together
+	GroupBy   string `json:"group_by,omitempty"`
+	Threshold int    `json:"threshold,omitempty"` // defaults to 1
+	Window    string `json:"window,omitempty"`    // e.g. "10m"; defaults to 1m
+	// Cooldown keeps a group from firing again; defaults to the window
+	Cooldown string `json:"cooldown,omitempty"`
+
+	Actions []ActionConfig `json:"actions,omitempty"`
+}
+
+// Rule is a compiled rule
+type Rule struct {
+	config    RuleConfig
+	threshold int
+	query     *collector.Query
+	pattern   *regexp.Regexp
+	window    time.Duration
+	cooldown  time.Duration
+	actions   []*action
+
+	mu     sync.Mutex
+	groups map[string]*group
+
+	matched atomic.Uint64
+	fired   atomic.Uint64
+}
+
+// group is the recent matches of one GroupBy value
+type group struct {
+	hits      []time.Time
+	lastFired time.Time
+}
+
+// RuleStatus reports a rule's counters
+type RuleStatus struct {
+	Name    string `json:"name"`
+	Matched uint64 `json:"matched"`
+	Fired   uint64 `json:"fired"`
+	Groups  int    `json:"groups"`
+	Actions int    `json:"actions"`
+}
+
+// LoadFile reads a JSON array of rules. Unknown fields are rejected so a
+// misspelled setting is not ignored.
+func LoadFile(path string) ([]RuleConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file %s: %w", path, err)
+	}
+	var configs []RuleConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&configs); err != nil {
+		return nil, fmt.Errorf("failed to decode rules file %s: %w", path, err)
+	}
+	return configs, nil
+}
+
+// SaveFile atomically writes rules to path in the format read by LoadFile
+func SaveFile(path string, configs []RuleConfig) error {
+	data, err := json.MarshalIndent(configs, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create rules file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write rules file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to fsync rules file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace rules file %s: %w", path, err)
+	}
+	return nil
+}
+
+// compile validates a rule against the action policy
+func compile(cfg RuleConfig, policy Policy) (*Rule, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("rule name is required")
+	}
+	rule := &Rule{config: cfg, groups: make(map[string]*group)}
+	if cfg.Query != "" {
+		query, err := collector.ParseQuery(cfg.Query)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", cfg.Name, err)
+		}
+		rule.query = query
+	}
+	if cfg.Pattern != "" {
+		pattern, err := regexp.Compile(cfg.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: invalid pattern: %w", cfg.Name, err)
+		}
+		rule.pattern = pattern
+	}
+	rule.threshold = cfg.Threshold
+	if rule.threshold <= 0 {
+		rule.threshold = 1
+	}
+
+	rule.window = time.Minute
+	if cfg.Window != "" {
+		window, err := time.ParseDuration(cfg.Window)
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("rule %s: invalid window %q", cfg.Name, cfg.Window)
+		}
+		rule.window = window
+	}
+	rule.cooldown = rule.window
+	if cfg.Cooldown != "" {
+		cooldown, err := time.ParseDuration(cfg.Cooldown)
+		if err != nil || cooldown < 0 {
+			return nil, fmt.Errorf("rule %s: invalid cooldown %q", cfg.Name, cfg.Cooldown)
+		}
+		rule.cooldown = cooldown
+	}
+
+	for i, actionCfg := range cfg.Actions {
+		action, err := compileAction(actionCfg, policy)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: action %d: %w", cfg.Name, i, err)
+		}
+		rule.actions = append(rule.actions, action)
+	}
+	return rule, nil
+}
+
+// match checks the conditions and returns the template parameters
+func (r *Rule) match(entry *collector.SystemLog) (map[string]string, bool) {
+	if r.config.Source != "" && entry.Source != r.config.Source {
+		return nil, false
+	}
+	if r.config.MinLevel != "" && !entry.Level.AtLeast(r.config.MinLevel) {
+		return nil, false
+	}
+	if len(r.config.Tags) > 0 && !hasAnyTag(entry.Tags, r.config.Tags) {
+		return nil, false
+	}
+	if !r.query.Match(entry) {
+		return nil, false
+	}
+
+	params := map[string]string{
+		"rule":    r.config.Name,
+		"source":  string(entry.Source),
+		"host":    entry.Host,
+		"service": entry.Service,
+		"ip":      entry.IP,
+		"user":    entry.User,
+		"level":   string(entry.Level),
+		"message": entry.Message,
+	}
+	if r.pattern != nil {
+		groups := r.pattern.FindStringSubmatch(entry.Message)
+		if groups == nil {
+			return nil, false
+		}
+		for i, name := range r.pattern.SubexpNames() {
+			if name != "" {
+				params[name] = groups[i]
+			}
+		}
+	}
+	return params, true
+}
+
+// observe counts entry at now if it matches. It returns the template
+// parameters of a match (nil otherwise), the matches of its group within the
+// window and whether the group fires; the parameters of a firing include
+// key and count.
+func (r *Rule) observe(entry *collector.SystemLog, now time.Time) (map[string]string, int, bool) {
+	params, ok := r.match(entry)
+	if !ok {
+		return nil, 0, false
+	}
+	key := r.groupKey(entry, params)
+	count, fire := r.record(key, now)
+	if fire {
+		params["key"] = key
+		params["count"] = fmt.Sprint(count)
+	}
+	return params, count, fire
+}
+
+// groupKey returns the GroupBy value of a match
+func (r *Rule) groupKey(entry *collector.SystemLog, params map[string]string) string {
+	if r.config.GroupBy == "" {
+		return ""
+	}
+	if value, ok := params[r.config.GroupBy]; ok {
+		return value
+	}
+	if value, ok := entry.ParsedData[r.config.GroupBy]; ok {
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+// record counts a match at now and reports whether the group fires
+func (r *Rule) record(key string, now time.Time) (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, ok := r.groups[key]
+	if !ok {
+		if len(r.groups) >= maxKeys {
+			r.sweep(now)
+		}
+		g = &group{}
+		r.groups[key] = g
+	}
+
+	// Keep only the matches within the window; at most threshold are needed
+	cutoff := now.Add(-r.window)
+	kept := g.hits[:0]
+	for _, hit := range g.hits {
+		if hit.After(cutoff) {
+			kept = append(kept, hit)
+		}
+	}
+	g.hits = append(kept, now)
+	if len(g.hits) > r.threshold {
+		g.hits = g.hits[len(g.hits)-r.threshold:]
+	}
+
+	count := len(g.hits)
+	if count < r.threshold || (!g.lastFired.IsZero() && now.Sub(g.lastFired) < r.cooldown) {
+		return count, false
+	}
+	g.lastFired = now
+	g.hits = g.hits[:0]
+	return count, true
+}
+
+// sweep drops groups without recent matches or firings. r.mu must be held.
+func (r *Rule) sweep(now time.Time) {
+	for key, g := range r.groups {
+		recent := len(g.hits) > 0 && now.Sub(g.hits[len(g.hits)-1]) < r.window
+		cooling := !g.lastFired.IsZero() && now.Sub(g.lastFired) < r.cooldown
+		if !recent && !cooling {
+			delete(r.groups, key)
+		}
+	}
+}
+
+// Engine evaluates rules against entries and dispatches their actions
+type Engine struct {
+	policy      Policy
+	runner      *runner
+	auditLogger *audit.Logger
+
+	mu    sync.RWMutex
+	rules []*Rule
+}
+
+// New compiles the rules. Commands not allowed by the policy are rejected.
+func New(configs []RuleConfig, policy Policy, auditLogger *audit.Logger) (*Engine, error) {
+	rules, err := compileAll(configs, policy)
+	if err != nil {
+		return nil, err
+	}
+	engine := &Engine{rules: rules, policy: policy, auditLogger: auditLogger}
+	engine.runner = newRunner(policy, auditLogger)
+	return engine, nil
+}
+
+// Validate checks a rule as SetRules would, without changing the engine
+func (e *Engine) Validate(cfg RuleConfig) error {
+	_, err := compile(cfg, e.policy)
+	return err
+}
+
+// Configs returns the configuration of every rule, in evaluation order
+func (e *Engine) Configs() []RuleConfig {
+	rules := e.current()
+	configs := make([]RuleConfig, len(rules))
+	for i, rule := range rules {
+		configs[i] = rule.config
+	}
+	return configs
+}
+
+// SetRules replaces the rules while entries are evaluated. Rules whose
+// configuration is unchanged keep their counters and open windows. On
+// error the current rules are kept.
+func (e *Engine) SetRules(configs []RuleConfig) error {
+	rules, err := compileAll(configs, e.policy)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	previous := make(map[string]*Rule, len(e.rules))
+	for _, rule := range e.rules {
+		previous[rule.config.Name] = rule
+	}
+	for i, rule := range rules {
+		if old, ok := previous[rule.config.Name]; ok && reflect.DeepEqual(old.config, rule.config) {
+			rules[i] = old
+		}
+	}
+	e.rules = rules
+	return nil
+}
+
+// current returns the rules being evaluated
+func (e *Engine) current() []*Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.rules
+}
+
+// compileAll compiles rules with unique names
+func compileAll(configs []RuleConfig, policy Policy) ([]*Rule, error) {
+	names := make(map[string]bool)
+	var rules []*Rule
+	for _, cfg := range configs {
+		rule, err := compile(cfg, policy)
+		if err != nil {
+			return nil, err
+		}
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("duplicate rule name: %s", cfg.Name)
+		}
+		names[cfg.Name] = true
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Processor evaluates every entry; it never drops entries
+func (e *Engine) Processor() collector.Processor {
+	return collector.ProcessorFunc(func(entry *collector.SystemLog) bool {
+		e.evaluate(entry, time.Now())
+		return true
+	})
+}
+
+// evaluate counts the entry for every matching rule and fires rules that
+// reach their threshold
+func (e *Engine) evaluate(entry *collector.SystemLog, now time.Time) {
+	for _, rule := range e.current() {
+		params, count, fire := rule.observe(entry, now)
+		if params == nil {
+			continue
+		}
+		rule.matched.Add(1)
+		if !fire {
+			continue
+		}
+		rule.fired.Add(1)
+		key := params["key"]
+
+		e.auditLogger.LogEvent(audit.AuditEvent{
+			EventType: "rule_fired",
+			Message:   fmt.Sprintf("Rule %s fired: %d matches within %s", rule.config.Name, count, rule.window),
+			Details: map[string]interface{}{
+				"rule":    rule.config.Name,
+				"key":     key,
+				"count":   count,
+				"log_id":  entry.ID,
+				"source":  entry.Source,
+				"host":    entry.Host,
+				"actions": len(rule.actions),
+			},
+		})
+		for _, action := range rule.actions {
+			e.runner.enqueue(rule.config.Name, action, params)
+		}
+	}
+}
+
+// Status returns the counters of every rule and of the action runner
+func (e *Engine) Status() map[string]interface{} {
+	current := e.current()
+	rules := make([]RuleStatus, len(current))
+	for i, rule := range current {
+		rule.mu.Lock()
+		groups := len(rule.groups)
+		rule.mu.Unlock()
+		rules[i] = RuleStatus{
+			Name:    rule.config.Name,
+			Matched: rule.matched.Load(),
+			Fired:   rule.fired.Load(),
+			Groups:  groups,
+			Actions: len(rule.actions),
+		}
+	}
+	return map[string]interface{}{
+		"rules":   rules,
+		"actions": e.runner.stats(),
+	}
+}
+
+// Close stops the action runner after the queued actions have run
+func (e *Engine) Close() {
+	e.runner.close()
+}
+
+func hasAnyTag(tags, wanted []string) bool {
+	for _, tag := range tags {
+		for _, w := range wanted {
+			if tag == w {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package rules
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// Firing is a rule firing found by Simulate
+type Firing struct {
+	Rule      string    `json:"rule"`
+	Key       string    `json:"key,omitempty"`
+	Count     int       `json:"count"`
+	LogID     string    `json:"log_id"`
+	Timestamp time.Time `json:"timestamp"`
+	// Actions are the actions that would run, rendered as in their dry run
+	// audit events
+	Actions []map[string]interface{} `json:"actions,omitempty"`
+}
+
+// SimulationResult is the answer of Simulate
+type SimulationResult struct {
+	Firings []Firing `json:"firings"`
+	// Matches counts the matching entries per rule
+	Matches map[string]int `json:"matches"`
+}
+
+// Simulate compiles configs like New and evaluates entries with fresh
+// counters, in timestamp order and using the entry timestamps as the time
+// of the matches, so windows apply to when things were logged. Nothing is
+// run or audited: the firings carry the actions they would have run.
+func Simulate(configs []RuleConfig, policy Policy, entries []collector.SystemLog) (*SimulationResult, error) {
+	rules, err := compileAll(configs, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	ordered := make([]*collector.SystemLog, len(entries))
+	for i := range entries {
+		ordered[i] = &entries[i]
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Timestamp.Before(ordered[j].Timestamp)
+	})
+
+	result := &SimulationResult{Firings: []Firing{}, Matches: make(map[string]int, len(rules))}
+	for _, rule := range rules {
+		result.Matches[rule.config.Name] = 0
+	}
+	for _, entry := range ordered {
+		for _, rule := range rules {
+			params, count, fire := rule.observe(entry, entry.Timestamp)
+			if params == nil {
+				continue
+			}
+			result.Matches[rule.config.Name]++
+			if !fire {
+				continue
+			}
+			firing := Firing{
+				Rule:      rule.config.Name,
+				Key:       params["key"],
+				Count:     count,
+				LogID:     entry.ID,
+				Timestamp: entry.Timestamp,
+			}
+			for _, a := range rule.actions {
+				firing.Actions = append(firing.Actions, preview(rule.config.Name, a, params))
+			}
+			result.Firings = append(result.Firings, firing)
+		}
+	}
+	return result, nil
+}
+
+// preview renders an action the way its dry run does, without running it
+func preview(rule string, a *action, params map[string]string) map[string]interface{} {
+	details := map[string]interface{}{"type": a.config.Type}
+	j := job{rule: rule, action: a, params: params}
+	// Dry runs only render the templates, the runner itself is not used
+	var r runner
+	var err error
+	switch a.config.Type {
+	case ActionCommand:
+		_, err = r.runCommand(j, true, details)
+	case ActionWebhook:
+		_, err = r.callWebhook(j, true, details)
+	}
+	if err != nil {
+		details["error"] = err.Error()
+	}
+	return details
+}
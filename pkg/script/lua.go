@@ -0,0 +1,234 @@
+//go:build !nolua
+
+package script
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+func init() {
+	newVM = newLuaVM
+}
+
+// luaVM is a sandboxed Lua state with the script loaded
+type luaVM struct {
+	state *lua.LState
+	fn    lua.LValue
+}
+
+func newLuaVM(name, source string) (vm, error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+
+	// Only the pure libraries: no io, os, package or debug
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, unsafe := range []string{"dofile", "loadfile", "require", "collectgarbage"} {
+		L.SetGlobal(unsafe, lua.LNil)
+	}
+
+	fn, err := L.LoadString(source)
+	if err != nil {
+		L.Close()
+		return nil, fmt.Errorf("failed to load %s: %w", name, err)
+	}
+	L.Push(fn)
+	if err := L.PCall(0, 0, nil); err != nil {
+		L.Close()
+		return nil, fmt.Errorf("failed to run %s: %w", name, err)
+	}
+
+	process := L.GetGlobal("process")
+	if process.Type() != lua.LTFunction {
+		L.Close()
+		return nil, fmt.Errorf("%s does not define a process(log) function", name)
+	}
+	return &luaVM{state: L, fn: process}, nil
+}
+
+func (v *luaVM) process(ctx context.Context, entry *collector.SystemLog) (bool, error) {
+	L := v.state
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	table := v.toTable(entry)
+	if err := L.CallByParam(lua.P{Fn: v.fn, NRet: 1, Protect: true}, table); err != nil {
+		return true, err
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+
+	v.fromTable(table, entry)
+	if keep, ok := ret.(lua.LBool); ok && !bool(keep) {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (v *luaVM) close() {
+	v.state.Close()
+}
+
+func (v *luaVM) toTable(entry *collector.SystemLog) *lua.LTable {
+	L := v.state
+	t := L.NewTable()
+	t.RawSetString("id", lua.LString(entry.ID))
+	t.RawSetString("timestamp", lua.LString(entry.Timestamp.Format(time.RFC3339Nano)))
+	t.RawSetString("source", lua.LString(entry.Source))
+	t.RawSetString("level", lua.LString(entry.Level))
+	t.RawSetString("message", lua.LString(entry.Message))
+	t.RawSetString("host", lua.LString(entry.Host))
+	t.RawSetString("service", lua.LString(entry.Service))
+	t.RawSetString("pid", lua.LNumber(entry.PID))
+	t.RawSetString("user", lua.LString(entry.User))
+	t.RawSetString("ip", lua.LString(entry.IP))
+	t.RawSetString("method", lua.LString(entry.Method))
+	t.RawSetString("path", lua.LString(entry.Path))
+	t.RawSetString("status_code", lua.LNumber(entry.StatusCode))
+	t.RawSetString("raw_log", lua.LString(entry.RawLog))
+
+	tags := L.NewTable()
+	for _, tag := range entry.Tags {
+		tags.Append(lua.LString(tag))
+	}
+	t.RawSetString("tags", tags)
+
+	parsed := L.NewTable()
+	for key, value := range entry.ParsedData {
+		parsed.RawSetString(key, toLua(L, value))
+	}
+	t.RawSetString("parsed_data", parsed)
+	return t
+}
+
+// fromTable copies the fields back into entry; fields set to a value of the
+// wrong type are left unchanged
+func (v *luaVM) fromTable(t *lua.LTable, entry *collector.SystemLog) {
+	str := func(key string, field *string) {
+		if s, ok := t.RawGetString(key).(lua.LString); ok {
+			*field = string(s)
+		}
+	}
+	num := func(key string, field *int) {
+		if n, ok := t.RawGetString(key).(lua.LNumber); ok {
+			*field = int(n)
+		}
+	}
+
+	level, source := string(entry.Level), string(entry.Source)
+	str("level", &level)
+	str("source", &source)
+	entry.Level = collector.LogLevel(level)
+	entry.Source = collector.LogSource(source)
+	str("message", &entry.Message)
+	str("host", &entry.Host)
+	str("service", &entry.Service)
+	num("pid", &entry.PID)
+	str("user", &entry.User)
+	str("ip", &entry.IP)
+	str("method", &entry.Method)
+	str("path", &entry.Path)
+	num("status_code", &entry.StatusCode)
+	str("raw_log", &entry.RawLog)
+
+	if s, ok := t.RawGetString("timestamp").(lua.LString); ok {
+		if ts, err := time.Parse(time.RFC3339Nano, string(s)); err == nil {
+			entry.Timestamp = ts
+		}
+	}
+
+	if tags, ok := t.RawGetString("tags").(*lua.LTable); ok {
+		// A new slice: entries share their tags with the source config
+		entry.Tags = make([]string, 0, tags.Len())
+		for i := 1; i <= tags.Len(); i++ {
+			if s, ok := tags.RawGetInt(i).(lua.LString); ok {
+				entry.Tags = append(entry.Tags, string(s))
+			}
+		}
+	}
+
+	if parsed, ok := t.RawGetString("parsed_data").(*lua.LTable); ok {
+		data := make(map[string]interface{})
+		parsed.ForEach(func(k, value lua.LValue) {
+			if key, ok := k.(lua.LString); ok {
+				data[string(key)] = fromLua(value)
+			}
+		})
+		entry.ParsedData = data
+	}
+}
+
+// toLua converts a parsed_data value
+func toLua(L *lua.LState, value interface{}) lua.LValue {
+	switch v := value.(type) {
+	case string:
+		return lua.LString(v)
+	case bool:
+		return lua.LBool(v)
+	case int:
+		return lua.LNumber(v)
+	case int64:
+		return lua.LNumber(v)
+	case float64:
+		return lua.LNumber(v)
+	case []interface{}:
+		t := L.NewTable()
+		for _, item := range v {
+			t.Append(toLua(L, item))
+		}
+		return t
+	case map[string]interface{}:
+		t := L.NewTable()
+		for key, item := range v {
+			t.RawSetString(key, toLua(L, item))
+		}
+		return t
+	case nil:
+		return lua.LNil
+	default:
+		return lua.LString(fmt.Sprint(v))
+	}
+}
+
+// fromLua converts a value back; tables with only 1..n keys become arrays
+func fromLua(value lua.LValue) interface{} {
+	switch v := value.(type) {
+	case lua.LString:
+		return string(v)
+	case lua.LBool:
+		return bool(v)
+	case lua.LNumber:
+		return float64(v)
+	case *lua.LTable:
+		if n := v.Len(); n > 0 && v.MaxN() == n {
+			items := make([]interface{}, 0, n)
+			for i := 1; i <= n; i++ {
+				items = append(items, fromLua(v.RawGetInt(i)))
+			}
+			return items
+		}
+		m := make(map[string]interface{})
+		v.ForEach(func(k, item lua.LValue) {
+			m[k.String()] = fromLua(item)
+		})
+		return m
+	default:
+		return nil
+	}
+}
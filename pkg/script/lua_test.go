@@ -0,0 +1,128 @@
+//go:build !nolua
+
+package script
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+func writeScript(t *testing.T, source string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "script.lua")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestScriptProcess(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		keep    bool
+		level   collector.LogLevel
+		tags    []string
+		message string
+	}{
+		{"keep", `function process(log) end`, true, "info", []string{"web"}, "GET /healthz"},
+		{"drop", `function process(log) if log.path == "/healthz" then return false end end`, false, "info", []string{"web"}, "GET /healthz"},
+		{"modify", `function process(log)
+			if log.status_code >= 500 then log.level = "error" end
+			table.insert(log.tags, "edge")
+			log.message = string.upper(log.message)
+		end`, true, "error", []string{"web", "edge"}, "GET /HEALTHZ"},
+		{"runtime error", `function process(log) error("boom") end`, true, "info", []string{"web"}, "GET /healthz"},
+		{"wrong type", `function process(log) log.level = 5 end`, true, "info", []string{"web"}, "GET /healthz"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stage, err := New(writeScript(t, test.source), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stage.Close()
+
+			configTags := []string{"web", "spare"}
+			entry := &collector.SystemLog{
+				Level:      "info",
+				Message:    "GET /healthz",
+				Path:       "/healthz",
+				StatusCode: 503,
+				Tags:       configTags[:1],
+				ParsedData: map[string]interface{}{"upstream": "api"},
+			}
+			if keep := stage.Process(entry); keep != test.keep {
+				t.Fatalf("Process() = %v, want %v", keep, test.keep)
+			}
+			if entry.Level != test.level || entry.Message != test.message {
+				t.Fatalf("entry level %q message %q, want %q %q", entry.Level, entry.Message, test.level, test.message)
+			}
+			if strings.Join(entry.Tags, ",") != strings.Join(test.tags, ",") {
+				t.Fatalf("tags = %v, want %v", entry.Tags, test.tags)
+			}
+			if configTags[1] != "spare" {
+				t.Fatalf("script wrote into the source's tags: %v", configTags)
+			}
+			if entry.ParsedData["upstream"] != "api" {
+				t.Fatalf("parsed_data = %v", entry.ParsedData)
+			}
+		})
+	}
+}
+
+func TestScriptSandbox(t *testing.T) {
+	for _, source := range []string{
+		`os.exit(1) function process(log) end`,
+		`io.open("/etc/passwd") function process(log) end`,
+		`require("os") function process(log) end`,
+		`dofile("/etc/passwd") function process(log) end`,
+		`x = 1`,
+	} {
+		if stage, err := New(writeScript(t, source), 0); err == nil {
+			stage.Close()
+			t.Errorf("script %q loaded", source)
+		}
+	}
+}
+
+func TestScriptTimeout(t *testing.T) {
+	stage, err := New(writeScript(t, `function process(log) while true do end end`), 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stage.Close()
+
+	entry := &collector.SystemLog{Level: "info", ParsedData: map[string]interface{}{}}
+	if !stage.Process(entry) {
+		t.Fatal("a timed out script dropped the entry")
+	}
+	status := stage.Status()
+	if status.Timeouts != 1 || status.Errors != 1 {
+		t.Fatalf("status = %+v, want one timeout", status)
+	}
+}
+
+func TestScriptReload(t *testing.T) {
+	path := writeScript(t, `function process(log) log.level = "warn" end`)
+	stage, err := New(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stage.Close()
+
+	os.WriteFile(path, []byte(`function process(`), 0644)
+	if err := stage.Reload(); err == nil {
+		t.Fatal("a broken script was loaded")
+	}
+	entry := &collector.SystemLog{Level: "info", ParsedData: map[string]interface{}{}}
+	stage.Process(entry)
+	if entry.Level != "warn" {
+		t.Fatalf("level = %q, the previous script should still run", entry.Level)
+	}
+}
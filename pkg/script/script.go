@@ -0,0 +1,214 @@
+// Package script runs a user-provided script as a pipeline stage that can
+// inspect, modify and drop every entry.
+//
+// The script defines a global function process(log). log is a table with
+// the SystemLog fields (id, timestamp, source, level, message, host,
+// service, pid, user, ip, method, path, status_code, raw_log, tags,
+// parsed_data); changes to it are applied to the entry. Returning false
+// drops the entry, anything else keeps it:
+//
+//	function process(log)
+//	  if log.path == "/healthz" then return false end
+//	  if log.status_code >= 500 then log.level = "error" end
+//	  table.insert(log.tags, "edge")
+//	end
+//
+// The Lua engine is gopher-lua. Building with the "nolua" tag leaves it
+// out, and New then fails with ErrUnsupported.
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// DefaultTimeout is the time budget of one script call
+const DefaultTimeout = 10 * time.Millisecond
+
+// ErrUnsupported is returned when gonder was built without a script engine
+var ErrUnsupported = errors.New("Lua scripting not available; this build used -tags nolua")
+
+// vm is one interpreter with the script loaded. VMs are not safe for
+// concurrent use.
+type vm interface {
+	// process runs the script on entry and reports whether to keep it
+	process(ctx context.Context, entry *collector.SystemLog) (bool, error)
+	close()
+}
+
+// newVM loads a script into a new interpreter; it is set by the Lua engine
+// unless built with the nolua tag
+var newVM func(name, source string) (vm, error)
+
+// Available reports whether a script engine is compiled in
+func Available() bool {
+	return newVM != nil
+}
+
+// Status reports the script stage and its counters
+type Status struct {
+	Path     string    `json:"path"`
+	LoadedAt time.Time `json:"loaded_at"`
+	Timeout  string    `json:"timeout"`
+	Calls    uint64    `json:"calls"`
+	Dropped  uint64    `json:"dropped"`
+	Errors   uint64    `json:"errors"`
+	// Timeouts counts calls aborted for exceeding the time limit
+	Timeouts  uint64 `json:"timeouts"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// program is a loaded version of the script with its idle interpreters
+type program struct {
+	source   string
+	loadedAt time.Time
+	idle     chan vm
+}
+
+// Stage runs the script on every entry. Script errors keep the entry
+// unchanged so a broken script never loses logs.
+type Stage struct {
+	path    string
+	timeout time.Duration
+
+	mu      sync.Mutex // serializes Reload
+	current atomic.Pointer[program]
+
+	calls     atomic.Uint64
+	dropped   atomic.Uint64
+	errors    atomic.Uint64
+	timeouts  atomic.Uint64
+	lastError atomic.Value // string
+}
+
+// New loads the script at path
+func New(path string, timeout time.Duration) (*Stage, error) {
+	if newVM == nil {
+		return nil, ErrUnsupported
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	s := &Stage{path: path, timeout: timeout}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the script again and switches to it once it loads without
+// errors; entries in flight finish with the previous version
+func (s *Stage) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read script: %w", err)
+	}
+	first, err := newVM(s.path, string(data))
+	if err != nil {
+		return err
+	}
+
+	p := &program{
+		source:   string(data),
+		loadedAt: time.Now(),
+		idle:     make(chan vm, runtime.GOMAXPROCS(0)),
+	}
+	p.idle <- first
+
+	if old := s.current.Swap(p); old != nil {
+		old.drain()
+	}
+	return nil
+}
+
+// drain closes the idle interpreters of a replaced program
+func (p *program) drain() {
+	for {
+		select {
+		case v := <-p.idle:
+			v.close()
+		default:
+			return
+		}
+	}
+}
+
+// Process implements collector.Processor
+func (s *Stage) Process(entry *collector.SystemLog) bool {
+	p := s.current.Load()
+	s.calls.Add(1)
+
+	var v vm
+	select {
+	case v = <-p.idle:
+	default:
+		var err error
+		if v, err = newVM(s.path, p.source); err != nil {
+			s.fail(context.Background(), err)
+			return true
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	keep, err := v.process(ctx, entry)
+	if err != nil {
+		// An aborted interpreter may be left in an inconsistent state
+		v.close()
+		s.fail(ctx, err)
+		return true
+	}
+
+	select {
+	case p.idle <- v:
+	default:
+		v.close()
+	}
+	if !keep {
+		s.dropped.Add(1)
+	}
+	return keep
+}
+
+func (s *Stage) fail(ctx context.Context, err error) {
+	s.errors.Add(1)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		s.timeouts.Add(1)
+	}
+	s.lastError.Store(err.Error())
+}
+
+// Status returns the stage counters
+func (s *Stage) Status() Status {
+	status := Status{
+		Path:     s.path,
+		LoadedAt: s.current.Load().loadedAt,
+		Timeout:  s.timeout.String(),
+		Calls:    s.calls.Load(),
+		Dropped:  s.dropped.Load(),
+		Errors:   s.errors.Load(),
+		Timeouts: s.timeouts.Load(),
+	}
+	status.LastError, _ = s.lastError.Load().(string)
+	return status
+}
+
+// Close releases the interpreters
+func (s *Stage) Close() {
+	if p := s.current.Load(); p != nil {
+		p.drain()
+	}
+}
@@ -0,0 +1,264 @@
+// Package secrets detects credentials that applications accidentally log
+// (private keys, JWTs, cloud access keys, passwords in URLs), tags the
+// entries and optionally redacts them before they reach any output.
+package secrets
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// Rule detects one kind of secret
+type Rule struct {
+	Name string
+	// Keywords are cheap substrings, one of which must be present before
+	// the pattern runs
+	Keywords []string
+	Pattern  *regexp.Regexp
+	// Group is the capture group holding the secret; 0 redacts the whole
+	// match
+	Group int
+}
+
+// DefaultRules are the built-in detection rules
+var DefaultRules = []Rule{
+	{
+		Name:     "private_key",
+		Keywords: []string{"PRIVATE KEY"},
+		Pattern:  regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----(?:[\s\S]*?-----END (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----|[A-Za-z0-9+/=\s\\]*)`),
+	},
+	{
+		Name:     "jwt",
+		Keywords: []string{"eyJ"},
+		Pattern:  regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`),
+	},
+	{
+		Name:     "aws_access_key",
+		Keywords: []string{"AKIA", "ASIA"},
+		Pattern:  regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+	},
+	{
+		Name:     "aws_secret_key",
+		Keywords: []string{"aws_secret", "AWS_SECRET", "SecretAccessKey", "secretAccessKey"},
+		Pattern:  regexp.MustCompile(`(?i)(?:aws_secret_access_key|aws_secret_key|secretaccesskey)["']?\s*[:=]\s*["']?([A-Za-z0-9/+]{40})\b`),
+		Group:    1,
+	},
+	{
+		Name:     "gcp_api_key",
+		Keywords: []string{"AIza"},
+		Pattern:  regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`),
+	},
+	{
+		Name:     "gcp_service_account_key",
+		Keywords: []string{"private_key_id"},
+		Pattern:  regexp.MustCompile(`"private_key_id"\s*:\s*"([0-9a-f]{40})"`),
+		Group:    1,
+	},
+	{
+		Name:     "github_token",
+		Keywords: []string{"ghp_", "gho_", "ghu_", "ghs_", "ghr_", "github_pat_"},
+		Pattern:  regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`),
+	},
+	{
+		Name:     "slack_token",
+		Keywords: []string{"xox"},
+		Pattern:  regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`),
+	},
+	{
+		Name:     "url_password",
+		Keywords: []string{"://"},
+		Pattern:  regexp.MustCompile(`\b[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:([^/\s@]+)@`),
+		Group:    1,
+	},
+}
+
+// Config configures a Detector
+type Config struct {
+	// Rules defaults to DefaultRules
+	Rules []Rule
+	// Redact replaces detected secrets in the message, raw line and string
+	// parsed fields with [REDACTED:<rule>]
+	Redact bool
+	// AlertCooldown suppresses repeated alerts for the same source and
+	// rule; defaults to 10m
+	AlertCooldown time.Duration
+}
+
+// Detector finds secrets in entries
+type Detector struct {
+	config      Config
+	auditLogger *audit.Logger
+
+	alertMu   sync.Mutex
+	lastAlert map[string]time.Time
+}
+
+// New creates a detector
+func New(cfg Config, auditLogger *audit.Logger) *Detector {
+	if cfg.Rules == nil {
+		cfg.Rules = DefaultRules
+	}
+	if cfg.AlertCooldown <= 0 {
+		cfg.AlertCooldown = 10 * time.Minute
+	}
+	return &Detector{
+		config:      cfg,
+		auditLogger: auditLogger,
+		lastAlert:   make(map[string]time.Time),
+	}
+}
+
+// Scan returns the names of the rules matching text
+func (d *Detector) Scan(text string) []string {
+	var found []string
+	for i := range d.config.Rules {
+		rule := &d.config.Rules[i]
+		if rule.candidate(text) && rule.Pattern.MatchString(text) {
+			found = append(found, rule.Name)
+		}
+	}
+	return found
+}
+
+// candidate reports whether text contains one of the rule's keywords
+func (r *Rule) candidate(text string) bool {
+	if len(r.Keywords) == 0 {
+		return true
+	}
+	for _, keyword := range r.Keywords {
+		if strings.Contains(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact replaces the secrets found by rule in text
+func (r *Rule) redact(text string) string {
+	if !r.candidate(text) {
+		return text
+	}
+	replacement := "[REDACTED:" + r.Name + "]"
+	matches := r.Pattern.FindAllStringSubmatchIndex(text, -1)
+	if matches == nil {
+		return text
+	}
+	var b strings.Builder
+	last := 0
+	for _, match := range matches {
+		start, end := match[2*r.Group], match[2*r.Group+1]
+		if start < 0 {
+			continue
+		}
+		b.WriteString(text[last:start])
+		b.WriteString(replacement)
+		last = end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// Processor tags entries containing secrets with "secret" and
+// "secret:<rule>", lists the rules in parsed_data.secrets, redacts them
+// when configured and raises a secret_leak audit event
+func (d *Detector) Processor() collector.Processor {
+	return collector.ProcessorFunc(func(entry *collector.SystemLog) bool {
+		found := d.Scan(entry.Message)
+		if entry.RawLog != entry.Message {
+			for _, name := range d.Scan(entry.RawLog) {
+				found = appendUnique(found, name)
+			}
+		}
+		if len(found) == 0 {
+			return true
+		}
+
+		// Tags of the source config are shared between entries, so copy them
+		tags := make([]string, 0, len(entry.Tags)+1+len(found))
+		tags = append(tags, entry.Tags...)
+		tags = append(tags, "secret")
+		for _, name := range found {
+			tags = append(tags, "secret:"+name)
+		}
+		entry.Tags = tags
+
+		if d.config.Redact {
+			d.redactEntry(entry)
+		}
+		if entry.ParsedData == nil {
+			entry.ParsedData = make(map[string]interface{})
+		}
+		entry.ParsedData["secrets"] = found
+		d.alert(entry, found)
+		return true
+	})
+}
+
+// redactEntry redacts the message, raw line and string parsed fields
+func (d *Detector) redactEntry(entry *collector.SystemLog) {
+	for i := range d.config.Rules {
+		rule := &d.config.Rules[i]
+		entry.Message = rule.redact(entry.Message)
+		entry.RawLog = rule.redact(entry.RawLog)
+		for key, value := range entry.ParsedData {
+			if text, ok := value.(string); ok {
+				entry.ParsedData[key] = rule.redact(text)
+			}
+		}
+	}
+}
+
+// alert reports the rules that haven't alerted for this source within the
+// cooldown. The secret itself is never included.
+func (d *Detector) alert(entry *collector.SystemLog, found []string) {
+	now := time.Now()
+	var fresh []string
+	d.alertMu.Lock()
+	for _, name := range found {
+		key := string(entry.Source) + "\x00" + entry.Host + "\x00" + name
+		if last, ok := d.lastAlert[key]; ok && now.Sub(last) < d.config.AlertCooldown {
+			continue
+		}
+		d.lastAlert[key] = now
+		fresh = append(fresh, name)
+	}
+	if len(d.lastAlert) > 10000 {
+		for key, last := range d.lastAlert {
+			if now.Sub(last) >= d.config.AlertCooldown {
+				delete(d.lastAlert, key)
+			}
+		}
+	}
+	d.alertMu.Unlock()
+	if len(fresh) == 0 {
+		return
+	}
+
+	d.auditLogger.LogEvent(audit.AuditEvent{
+		EventType: "secret_leak",
+		Message:   fmt.Sprintf("Possible %s leaked in %s logs", strings.Join(fresh, ", "), entry.Source),
+		Details: map[string]interface{}{
+			"rules":    fresh,
+			"log_id":   entry.ID,
+			"source":   entry.Source,
+			"host":     entry.Host,
+			"service":  entry.Service,
+			"redacted": d.config.Redact,
+		},
+	})
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
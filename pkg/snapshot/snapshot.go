@@ -0,0 +1,511 @@
+// Package snapshot takes consistent backups of the state of an instance:
+// the archive, the checkpoints and the file catalog, in one tar file that
+// can be kept locally and uploaded to object storage, and restores them.
+//
+// The checkpoints are written (after the outputs confirm delivery) and
+// copied before the archive's open object is sealed and exported, so every
+// position in a snapshot points at lines that are in its archive. A
+// restored instance may read a few lines again, but never skips any.
+package snapshot
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/archive"
+	"github.com/ercansavas/gonder/pkg/collector"
+	"github.com/ercansavas/gonder/pkg/jobs"
+)
+
+// Kind is the job kind of snapshots
+const Kind = "snapshot"
+
+// Files of a snapshot
+const (
+	archiveFile     = "archive.tar.gz"
+	checkpointsFile = "checkpoints.json"
+	catalogFile     = "catalog.json"
+	metadataFile    = "snapshot.json"
+
+	filePrefix = "snapshot-"
+	fileSuffix = ".tar"
+)
+
+// DefaultKeep is the number of snapshots kept when Config.Keep is 0
+const DefaultKeep = 7
+
+// Config configures snapshots
+type Config struct {
+	// Dir holds the snapshots
+	Dir string
+	// Keep is the number of snapshots kept in Dir; older ones are removed
+	Keep int
+	// UploadURL is where snapshots are PUT, with the snapshot file name
+	// appended; UploadToken is sent as a bearer token
+	UploadURL   string
+	UploadToken string
+
+	// The state files of the instance; empty ones are left out
+	ArchiveDir     string
+	CheckpointFile string
+	CatalogFile    string
+}
+
+// Metadata describes a snapshot; it is stored in it as snapshot.json
+type Metadata struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Host      string    `json:"host,omitempty"`
+	// Files are the state files in the snapshot with their SHA-256
+	Files   []File                  `json:"files"`
+	Archive *archive.TransferReport `json:"archive,omitempty"`
+}
+
+// File is a file of a snapshot
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Info is a snapshot kept in the snapshot directory
+type Info struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Request is a snapshot to take
+type Request struct {
+	// UploadURL overrides Config.UploadURL with the exact URL to PUT the
+	// snapshot to, e.g. a presigned one; no token is sent to it
+	UploadURL string `json:"upload_url,omitempty"`
+}
+
+// Snapshotter takes snapshots as background jobs
+type Snapshotter struct {
+	config    Config
+	collector *collector.LogCollector
+	archive   *archive.Output // nil without archive
+	jobs      *jobs.Manager
+	client    *http.Client
+
+	// mu lets one snapshot run at a time
+	mu sync.Mutex
+}
+
+// New creates a snapshotter; archiveOutput may be nil
+func New(cfg Config, lc *collector.LogCollector, archiveOutput *archive.Output, manager *jobs.Manager) *Snapshotter {
+	if cfg.Keep <= 0 {
+		cfg.Keep = DefaultKeep
+	}
+	return &Snapshotter{
+		config:    cfg,
+		collector: lc,
+		archive:   archiveOutput,
+		jobs:      manager,
+		client:    &http.Client{Timeout: time.Hour},
+	}
+}
+
+// Enabled reports whether a snapshot directory is configured
+func (s *Snapshotter) Enabled() bool {
+	return s.config.Dir != ""
+}
+
+// Start takes a snapshot in a background job
+func (s *Snapshotter) Start(req Request) (jobs.Record, error) {
+	if !s.Enabled() {
+		return jobs.Record{}, fmt.Errorf("snapshots are disabled (SNAPSHOT_DIR is not set)")
+	}
+	if req.UploadURL != "" && !strings.HasPrefix(req.UploadURL, "http://") && !strings.HasPrefix(req.UploadURL, "https://") {
+		return jobs.Record{}, fmt.Errorf("upload_url must be an http or https URL")
+	}
+	// Presigned URLs carry credentials in their query; job records don't
+	params := req
+	params.UploadURL, _, _ = strings.Cut(params.UploadURL, "?")
+	return s.jobs.Start(Kind, params, func(ctx context.Context, handle *jobs.Job) error {
+		j := &job{stage: "waiting"}
+		handle.Report(j.progress)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		meta, err := s.take(ctx, req, j.setStage)
+		j.mu.Lock()
+		j.snapshot = meta
+		j.mu.Unlock()
+		return err
+	})
+}
+
+// job is the progress of a running snapshot
+type job struct {
+	mu       sync.Mutex
+	stage    string
+	snapshot *Metadata
+}
+
+func (j *job) setStage(stage string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.stage = stage
+}
+
+func (j *job) progress() jobs.Progress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	details := map[string]interface{}{"stage": j.stage}
+	if j.snapshot != nil {
+		details["snapshot"] = j.snapshot
+	}
+	return jobs.Progress{Details: details}
+}
+
+// take writes a snapshot to the snapshot directory and uploads it
+func (s *Snapshotter) take(ctx context.Context, req Request, stage func(string)) (*Metadata, error) {
+	if err := os.MkdirAll(s.config.Dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	work, err := os.MkdirTemp(s.config.Dir, ".snapshot-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(work)
+
+	now := time.Now().UTC()
+	meta := &Metadata{Name: filePrefix + now.Format("20060102T150405Z") + fileSuffix, CreatedAt: now}
+	meta.Host, _ = os.Hostname()
+
+	// Positions first: they must not get ahead of the archive
+	stage("checkpoints")
+	if err := s.collector.PersistState(); err != nil {
+		return nil, fmt.Errorf("failed to write checkpoints: %w", err)
+	}
+	for name, path := range map[string]string{checkpointsFile: s.config.CheckpointFile, catalogFile: s.config.CatalogFile} {
+		if path == "" {
+			continue
+		}
+		if err := copyFile(path, filepath.Join(work, name)); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.archive != nil {
+		stage("archive")
+		file, err := os.Create(filepath.Join(work, archiveFile))
+		if err != nil {
+			return nil, err
+		}
+		meta.Archive, err = s.archive.Snapshot(file)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to export archive: %w", err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	stage("packing")
+	path := filepath.Join(s.config.Dir, meta.Name)
+	if err := pack(work, path, meta); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	s.prune()
+
+	uploadURL, token := req.UploadURL, ""
+	if uploadURL == "" && s.config.UploadURL != "" {
+		uploadURL, token = strings.TrimSuffix(s.config.UploadURL, "/")+"/"+meta.Name, s.config.UploadToken
+	}
+	if uploadURL != "" {
+		stage("upload")
+		if err := s.upload(ctx, path, uploadURL, token); err != nil {
+			return nil, err
+		}
+	}
+	stage("done")
+	return meta, nil
+}
+
+// pack writes the files of work and their metadata to a tar at path,
+// through a temporary file so a snapshot is complete once it has its name
+func pack(work, path string, meta *Metadata) error {
+	entries, err := os.ReadDir(work)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sum, size, err := hashFile(filepath.Join(work, name))
+		if err != nil {
+			return err
+		}
+		meta.Files = append(meta.Files, File{Name: name, Size: size, SHA256: sum})
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	tw := tar.NewWriter(tmp)
+	// The metadata first, so a restore knows what to expect
+	err = tw.WriteHeader(&tar.Header{Name: metadataFile, Mode: 0640, Size: int64(len(data)), ModTime: meta.CreatedAt})
+	if err == nil {
+		_, err = tw.Write(data)
+	}
+	for _, file := range meta.Files {
+		if err != nil {
+			break
+		}
+		err = addFile(tw, filepath.Join(work, file.Name), file, meta.CreatedAt)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func addFile(tw *tar.Writer, path string, file File, modTime time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := tw.WriteHeader(&tar.Header{Name: file.Name, Mode: 0640, Size: file.Size, ModTime: modTime}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// upload PUTs a snapshot to url
+func (s *Snapshotter) upload(ctx context.Context, path, url, token string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, file)
+	if err != nil {
+		return fmt.Errorf("invalid upload URL: %w", err)
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/x-tar")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("failed to upload snapshot: %s", resp.Status)
+	}
+	return nil
+}
+
+// List returns the snapshots in the snapshot directory, newest first
+func (s *Snapshotter) List() ([]Info, error) {
+	if s.config.Dir == "" {
+		return []Info{}, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(s.config.Dir, filePrefix+"*"+fileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]Info, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		name := filepath.Base(path)
+		created, _ := time.Parse("20060102T150405Z", strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix))
+		snapshots = append(snapshots, Info{Name: name, Path: path, Size: info.Size(), CreatedAt: created})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Name > snapshots[j].Name })
+	return snapshots, nil
+}
+
+// prune removes the snapshots beyond Keep, oldest first
+func (s *Snapshotter) prune() {
+	snapshots, err := s.List()
+	if err != nil {
+		return
+	}
+	for i := s.config.Keep; i < len(snapshots); i++ {
+		os.Remove(snapshots[i].Path)
+	}
+}
+
+// Restore puts the state of a snapshot in place for an instance that is not
+// running: the archive is imported into ArchiveDir (verified, signatures
+// too with key), the checkpoints and catalog are written to their files.
+// Existing checkpoint and catalog files are only replaced with force.
+// Files of the snapshot the configuration has no place for are skipped and
+// returned.
+func Restore(path string, cfg Config, key ed25519.PublicKey, force bool) (*Metadata, []string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	targets := map[string]string{checkpointsFile: cfg.CheckpointFile, catalogFile: cfg.CatalogFile}
+	if !force {
+		for name, target := range targets {
+			if _, err := os.Stat(target); target != "" && err == nil {
+				return nil, nil, fmt.Errorf("%s already exists, restore with force to replace it (%s)", target, name)
+			}
+		}
+	}
+
+	tr := tar.NewReader(file)
+	header, err := tr.Next()
+	if err != nil || header.Name != metadataFile {
+		return nil, nil, fmt.Errorf("%s is not a snapshot", path)
+	}
+	var meta Metadata
+	if err := json.NewDecoder(io.LimitReader(tr, 1<<20)).Decode(&meta); err != nil {
+		return nil, nil, fmt.Errorf("invalid snapshot metadata: %w", err)
+	}
+	expected := make(map[string]File, len(meta.Files))
+	for _, f := range meta.Files {
+		expected[f.Name] = f
+	}
+
+	var skipped []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid snapshot: %w", err)
+		}
+		want, ok := expected[header.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid snapshot: unexpected file %q", header.Name)
+		}
+		delete(expected, header.Name)
+		hash := sha256.New()
+		content := io.TeeReader(tr, hash)
+
+		switch target := targets[header.Name]; {
+		case header.Name == archiveFile && cfg.ArchiveDir != "":
+			if _, err := archive.Import(content, cfg.ArchiveDir, key); err != nil {
+				return nil, nil, fmt.Errorf("failed to restore archive: %w", err)
+			}
+			io.Copy(io.Discard, content)
+		case target != "":
+			data, err := io.ReadAll(content)
+			if err != nil {
+				return nil, nil, err
+			}
+			if hex.EncodeToString(hash.Sum(nil)) != want.SHA256 {
+				return nil, nil, fmt.Errorf("%s: SHA-256 does not match the snapshot metadata", header.Name)
+			}
+			if err := writeFile(target, data); err != nil {
+				return nil, nil, err
+			}
+			continue
+		default:
+			skipped = append(skipped, header.Name)
+			io.Copy(io.Discard, content)
+		}
+		if hex.EncodeToString(hash.Sum(nil)) != want.SHA256 {
+			return nil, nil, fmt.Errorf("%s: SHA-256 does not match the snapshot metadata", header.Name)
+		}
+	}
+	for name := range expected {
+		return nil, nil, fmt.Errorf("invalid snapshot: %s is missing", name)
+	}
+	return &meta, skipped, nil
+}
+
+func copyFile(from, to string) error {
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(to, data, 0640)
+}
+
+// writeFile replaces path through a temporary file
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func hashFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
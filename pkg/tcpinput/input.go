@@ -0,0 +1,296 @@
+// Package tcpinput receives log lines over TCP, e.g. from syslog daemons
+// forwarding with RFC 6587 newline framing or GELF senders delimiting
+// messages with NUL bytes. Each input has its own listener and, with a TLS
+// block, terminates TLS and can require client certificates, so remote
+// shippers don't send logs in plaintext.
+package tcpinput
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// Client certificate modes of TLSConfig.ClientAuth
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+const (
+	// MaxLineSize is the longest line accepted; a connection sending a
+	// longer one is closed
+	MaxLineSize = 1024 * 1024
+	// handshakeTimeout bounds the TLS handshake of a connection
+	handshakeTimeout = 10 * time.Second
+)
+
+// TLSConfig is the TLS block of an input. ClientAuth is none, optional or
+// require; it defaults to require when ClientCAFile is set. The files are
+// read when the input starts listening.
+type TLSConfig struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+	ClientAuth   string `json:"client_auth,omitempty"`
+}
+
+// Config configures a TCP input
+type Config struct {
+	Name string `json:"name"`
+	// Source selects the parser of the received lines
+	Source collector.LogSource `json:"source"`
+	// Listen is the address to listen on, e.g. ":6514"
+	Listen string     `json:"listen"`
+	Tags   []string   `json:"tags,omitempty"`
+	TLS    *TLSConfig `json:"tls,omitempty"`
+}
+
+// LoadFile reads a JSON array of input configurations from path
+func LoadFile(path string) ([]Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inputs file %s: %w", path, err)
+	}
+
+	// Unknown fields are rejected so a misspelled setting is not ignored
+	var inputs []Config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&inputs); err != nil {
+		return nil, fmt.Errorf("failed to decode inputs file %s: %w", path, err)
+	}
+	return inputs, nil
+}
+
+// Validate checks an input configuration
+func (c Config) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("input name is required")
+	}
+	if !collector.IsKnownSource(c.Source) {
+		return fmt.Errorf("input %s: unknown source type %q", c.Name, c.Source)
+	}
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("input %s: invalid listen address %q: %w", c.Name, c.Listen, err)
+	}
+	if c.TLS == nil {
+		return nil
+	}
+	if c.TLS.CertFile == "" || c.TLS.KeyFile == "" {
+		return fmt.Errorf("input %s: tls requires cert_file and key_file", c.Name)
+	}
+	switch c.TLS.clientAuth() {
+	case ClientAuthNone:
+	case ClientAuthOptional, ClientAuthRequire:
+		if c.TLS.ClientCAFile == "" {
+			return fmt.Errorf("input %s: client_auth %s requires client_ca_file", c.Name, c.TLS.ClientAuth)
+		}
+	default:
+		return fmt.Errorf("input %s: invalid client_auth %q (none, optional, require)", c.Name, c.TLS.ClientAuth)
+	}
+	return nil
+}
+
+// clientAuth returns the client certificate mode with its default applied
+func (t *TLSConfig) clientAuth() string {
+	if t.ClientAuth != "" {
+		return t.ClientAuth
+	}
+	if t.ClientCAFile != "" {
+		return ClientAuthRequire
+	}
+	return ClientAuthNone
+}
+
+// serverConfig reads the certificate files of the block
+func (t *TLSConfig) serverConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate %s: %w", t.CertFile, err)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	switch t.clientAuth() {
+	case ClientAuthNone:
+		return tlsConfig, nil
+	case ClientAuthOptional:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	pem, err := os.ReadFile(t.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", t.ClientCAFile)
+	}
+	return tlsConfig, nil
+}
+
+// Input is a collector source listening for lines on a TCP address. Lines
+// end with a newline or a NUL byte; a trailing carriage return is dropped.
+type Input struct {
+	config Config
+
+	// emitMu serializes the lines of concurrent connections
+	emitMu sync.Mutex
+}
+
+// New creates an input, checking its configuration and TLS files
+func New(cfg Config) (*Input, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.TLS != nil {
+		if _, err := cfg.TLS.serverConfig(); err != nil {
+			return nil, fmt.Errorf("input %s: %w", cfg.Name, err)
+		}
+	}
+	return &Input{config: cfg}, nil
+}
+
+// URL returns the listen address with the scheme of the input, tcp or tls
+func (c Config) URL() string {
+	if c.TLS != nil {
+		return "tls://" + c.Listen
+	}
+	return "tcp://" + c.Listen
+}
+
+// Config implements collector.Source
+func (in *Input) Config() collector.LogSourceConfig {
+	return collector.LogSourceConfig{
+		Name:    in.config.Name,
+		Source:  in.config.Source,
+		Path:    in.config.URL(),
+		Enabled: true,
+		Tags:    in.config.Tags,
+	}
+}
+
+// Run listens until ctx is cancelled, emitting the lines of every
+// connection. The TLS files are read here, so a restarted input picks up
+// renewed certificates.
+func (in *Input) Run(ctx context.Context, emit func(line string)) error {
+	var tlsConfig *tls.Config
+	if in.config.TLS != nil {
+		var err error
+		if tlsConfig, err = in.config.TLS.serverConfig(); err != nil {
+			return err
+		}
+	}
+	var lc net.ListenConfig
+	ln, err := lc.Listen(ctx, "tcp", in.config.Listen)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	return in.serve(ctx, ln, emit)
+}
+
+// serve accepts connections on ln until ctx is cancelled or accepting
+// fails, then closes the open ones and waits for them
+func (in *Input) serve(ctx context.Context, ln net.Listener, emit func(line string)) error {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		closed bool
+		conns  = make(map[net.Conn]bool)
+	)
+	closeAll := func() {
+		ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		closed = true
+		for conn := range conns {
+			conn.Close()
+		}
+	}
+	defer context.AfterFunc(ctx, closeAll)()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			closeAll()
+			wg.Wait()
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("input %s: %w", in.config.Name, err)
+		}
+		mu.Lock()
+		if closed {
+			mu.Unlock()
+			conn.Close()
+			continue
+		}
+		conns[conn] = true
+		mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			in.read(ctx, conn, emit)
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// read emits the lines of one connection until it is closed. A failed
+// handshake, e.g. a client without a required certificate, ends it.
+func (in *Input) read(ctx context.Context, conn net.Conn, emit func(line string)) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		handshakeCtx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+		err := tlsConn.HandshakeContext(handshakeCtx)
+		cancel()
+		if err != nil {
+			return
+		}
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxLineSize)
+	scanner.Split(scanLines)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		in.emitMu.Lock()
+		emit(line)
+		in.emitMu.Unlock()
+	}
+}
+
+// scanLines is bufio.ScanLines splitting on NUL bytes too
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexAny(data, "\n\x00"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,215 @@
+package tcpinput
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// testCert is a certificate with its key, issued by parent or a
+// self-signed CA
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pair tls.Certificate
+}
+
+func issue(t *testing.T, name string, parent *testCert, server bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		template.ExtKeyUsage = nil
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, pair: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}}
+}
+
+// writePEM writes the certificate and key of c and returns their paths
+func (c *testCert) writePEM(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// freeAddr returns a local address nothing listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// dial connects to addr once the input listens on it
+func dial(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			return conn
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestInputTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "gonder test CA", nil, false)
+	caFile, _ := ca.writePEM(t, dir, "ca")
+	certFile, keyFile := issue(t, "gonder", ca, true).writePEM(t, dir, "server")
+	client := issue(t, "shipper", ca, false)
+	stranger := issue(t, "stranger", issue(t, "other CA", nil, false), false)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	tests := []struct {
+		name string
+		tls  *TLSConfig
+		// client is the certificate presented; plaintext skips TLS
+		client    *testCert
+		plaintext bool
+		want      []string
+	}{
+		{name: "plain input", plaintext: true, want: []string{"one", "two", "three"}},
+		{name: "server certificate", tls: &TLSConfig{CertFile: certFile, KeyFile: keyFile}, want: []string{"one", "two", "three"}},
+		{name: "client certificate", tls: &TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}, client: client, want: []string{"one", "two", "three"}},
+		{name: "missing client certificate", tls: &TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}},
+		{name: "untrusted client certificate", tls: &TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}, client: stranger},
+		{name: "optional client certificate", tls: &TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientAuth: ClientAuthOptional}, want: []string{"one", "two", "three"}},
+		{name: "plaintext to a TLS input", tls: &TLSConfig{CertFile: certFile, KeyFile: keyFile}, plaintext: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr := freeAddr(t)
+			input, err := New(Config{Name: "syslog-tcp", Source: collector.SourceSyslog, Listen: addr, TLS: test.tls})
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := make(chan string, 10)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- input.Run(ctx, func(line string) { lines <- line }) }()
+			defer func() {
+				cancel()
+				if err := <-done; err != nil {
+					t.Fatal(err)
+				}
+			}()
+
+			conn := dial(t, addr)
+			if !test.plaintext {
+				config := &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+				if test.client != nil {
+					config.Certificates = []tls.Certificate{test.client.pair}
+				}
+				tlsConn := tls.Client(conn, config)
+				if err := tlsConn.Handshake(); err != nil && test.want != nil {
+					t.Fatalf("handshake: %v", err)
+				}
+				conn = tlsConn
+			}
+			// With TLS 1.3 a rejected client certificate only fails the
+			// write or the next read
+			conn.Write([]byte("one\r\ntwo\x00three\n"))
+			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			conn.Read(make([]byte, 1))
+			conn.Close()
+
+			var got []string
+			timeout := time.After(200 * time.Millisecond)
+		receive:
+			for len(got) < len(test.want) || test.want == nil {
+				select {
+				case line := <-lines:
+					got = append(got, line)
+				case <-timeout:
+					break receive
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("received %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		valid  bool
+	}{
+		{"plain", Config{Name: "in", Source: collector.SourceSyslog, Listen: ":6514"}, true},
+		{"tls", Config{Name: "in", Source: collector.SourceSyslog, Listen: ":6514", TLS: &TLSConfig{CertFile: "c", KeyFile: "k"}}, true},
+		{"no name", Config{Source: collector.SourceSyslog, Listen: ":6514"}, false},
+		{"unknown source", Config{Name: "in", Source: "gelf", Listen: ":6514"}, false},
+		{"no port", Config{Name: "in", Source: collector.SourceSyslog, Listen: "localhost"}, false},
+		{"key without certificate", Config{Name: "in", Source: collector.SourceSyslog, Listen: ":6514", TLS: &TLSConfig{KeyFile: "k"}}, false},
+		{"client auth without CA", Config{Name: "in", Source: collector.SourceSyslog, Listen: ":6514", TLS: &TLSConfig{CertFile: "c", KeyFile: "k", ClientAuth: ClientAuthRequire}}, false},
+		{"invalid client auth", Config{Name: "in", Source: collector.SourceSyslog, Listen: ":6514", TLS: &TLSConfig{CertFile: "c", KeyFile: "k", ClientCAFile: "ca", ClientAuth: "always"}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.config.Validate(); (err == nil) != test.valid {
+				t.Fatalf("Validate() = %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
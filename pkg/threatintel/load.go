@@ -0,0 +1,102 @@
+package threatintel
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxFeedSize bounds a downloaded feed
+const maxFeedSize = 64 * 1024 * 1024
+
+// feedClient fetches remote feeds
+var feedClient = &http.Client{Timeout: time.Minute}
+
+// loadFeed reads a feed from a file or URL
+func loadFeed(source string) (*feed, error) {
+	var body io.ReadCloser
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		resp, err := feedClient.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("feed returned %s", resp.Status)
+		}
+		body = resp.Body
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		body = f
+	}
+	defer body.Close()
+
+	loaded, err := parseFeed(io.LimitReader(body, maxFeedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	loaded.status.LoadedAt = &now
+	return loaded, nil
+}
+
+// parseFeed reads one indicator per line; unrecognized lines are skipped
+func parseFeed(r io.Reader) (*feed, error) {
+	f := &feed{
+		ips:     make(map[netip.Addr]struct{}),
+		domains: make(map[string]struct{}),
+	}
+	var size int64
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		size += int64(len(line)) + 1
+		if size > maxFeedSize {
+			return nil, fmt.Errorf("feed is larger than %d bytes", maxFeedSize)
+		}
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		indicator := fields[0]
+		// hosts-file format: "0.0.0.0 bad.example"
+		if (indicator == "0.0.0.0" || indicator == "127.0.0.1" || indicator == "::") && len(fields) > 1 {
+			indicator = fields[1]
+		}
+
+		if prefix, err := netip.ParsePrefix(indicator); err == nil {
+			if prefix.IsSingleIP() {
+				f.ips[prefix.Addr().Unmap()] = struct{}{}
+			} else {
+				f.ranges = append(f.ranges, prefix.Masked())
+			}
+			continue
+		}
+		if addr, err := netip.ParseAddr(indicator); err == nil {
+			f.ips[addr.Unmap()] = struct{}{}
+			continue
+		}
+		domain := strings.ToLower(strings.TrimSuffix(indicator, "."))
+		if domainPattern.FindString(domain) == domain {
+			f.domains[domain] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	f.status.IPs = len(f.ips)
+	f.status.Ranges = len(f.ranges)
+	f.status.Domains = len(f.domains)
+	return f, nil
+}
@@ -0,0 +1,366 @@
+// Package threatintel matches IPs and domains in log entries against
+// blocklists, from local files or periodically fetched feeds, and tags the
+// entries that hit one.
+//
+// A feed lists one indicator per line: an IP address, a CIDR range or a
+// domain (which also matches its subdomains). Comments start with '#' or
+// ';', and hosts-file lines ("0.0.0.0 bad.example") are read as domains.
+package threatintel
+
+import (
+	"fmt"
+	"net/netip"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/audit"
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// Config configures the matcher
+type Config struct {
+	// Feeds are file paths or http(s) URLs
+	Feeds []string
+	// Refresh is how often feeds are reloaded; defaults to 1h
+	Refresh time.Duration
+	// AlertSources are the source types and tags whose matches raise an
+	// alert, e.g. "nginx", "apache", "auth"
+	AlertSources []string
+	// AlertLevel is the minimum level of entries that raise an alert
+	AlertLevel collector.LogLevel
+	// AlertCooldown suppresses repeated alerts for the same indicator;
+	// defaults to 10m
+	AlertCooldown time.Duration
+}
+
+// FeedStatus describes a loaded feed
+type FeedStatus struct {
+	Name       string     `json:"name"`
+	Source     string     `json:"source"`
+	IPs        int        `json:"ips"`
+	Ranges     int        `json:"ranges"`
+	Domains    int        `json:"domains"`
+	LoadedAt   *time.Time `json:"loaded_at,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+	LastFailed *time.Time `json:"last_failed,omitempty"`
+}
+
+// Status reports the feeds and match counters
+type Status struct {
+	Feeds   []FeedStatus `json:"feeds"`
+	Matches uint64       `json:"matches"`
+	Alerts  uint64       `json:"alerts"`
+}
+
+// Match is one indicator found in an entry
+type Match struct {
+	Indicator string `json:"indicator"`
+	Type      string `json:"type"` // "ip" or "domain"
+	Value     string `json:"value"`
+	Feed      string `json:"feed"`
+}
+
+// feed is a loaded blocklist
+type feed struct {
+	status  FeedStatus
+	ips     map[netip.Addr]struct{}
+	ranges  []netip.Prefix
+	domains map[string]struct{}
+}
+
+// Intel holds the feeds and matches entries against them
+type Intel struct {
+	config      Config
+	auditLogger *audit.Logger
+
+	feeds   atomic.Pointer[[]*feed]
+	loadMu  sync.Mutex
+	matches atomic.Uint64
+	alerts  atomic.Uint64
+
+	alertMu   sync.Mutex
+	lastAlert map[string]time.Time
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// New loads the feeds. Feeds that fail to load are reported in Status and
+// retried on refresh; the returned error joins their errors.
+func New(cfg Config, auditLogger *audit.Logger) (*Intel, error) {
+	if cfg.Refresh <= 0 {
+		cfg.Refresh = time.Hour
+	}
+	if cfg.AlertCooldown <= 0 {
+		cfg.AlertCooldown = 10 * time.Minute
+	}
+	if cfg.AlertLevel == "" {
+		cfg.AlertLevel = collector.LevelWarn
+	}
+	intel := &Intel{
+		config:      cfg,
+		auditLogger: auditLogger,
+		lastAlert:   make(map[string]time.Time),
+		stop:        make(chan struct{}),
+	}
+	feeds := make([]*feed, len(cfg.Feeds))
+	for i, source := range cfg.Feeds {
+		feeds[i] = &feed{status: FeedStatus{Name: feedName(source), Source: source}}
+	}
+	intel.feeds.Store(&feeds)
+	return intel, intel.Reload()
+}
+
+// feedName names a feed after its file name
+func feedName(source string) string {
+	name := path.Base(strings.TrimRight(source, "/"))
+	if i := strings.IndexAny(name, "?#"); i >= 0 {
+		name = name[:i]
+	}
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// Reload reloads every feed. A feed that fails keeps its previous entries.
+func (in *Intel) Reload() error {
+	in.loadMu.Lock()
+	defer in.loadMu.Unlock()
+
+	current := *in.feeds.Load()
+	reloaded := make([]*feed, len(current))
+	var errs []string
+	for i, old := range current {
+		loaded, err := loadFeed(old.status.Source)
+		if err != nil {
+			failed := *old
+			failed.status.LastError = err.Error()
+			now := time.Now().UTC()
+			failed.status.LastFailed = &now
+			reloaded[i] = &failed
+			errs = append(errs, fmt.Sprintf("%s: %v", old.status.Source, err))
+			continue
+		}
+		loaded.status.Name = old.status.Name
+		loaded.status.Source = old.status.Source
+		reloaded[i] = loaded
+	}
+	in.feeds.Store(&reloaded)
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to load threat feeds: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Start reloads the feeds every Refresh until Close
+func (in *Intel) Start() {
+	go func() {
+		ticker := time.NewTicker(in.config.Refresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := in.Reload(); err != nil {
+					in.auditLogger.LogError(err, "Threat feed refresh failed", nil)
+				}
+			case <-in.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops refreshing
+func (in *Intel) Close() {
+	in.once.Do(func() {
+		close(in.stop)
+	})
+}
+
+// Status returns the feeds and counters
+func (in *Intel) Status() Status {
+	feeds := *in.feeds.Load()
+	status := Status{
+		Feeds:   make([]FeedStatus, len(feeds)),
+		Matches: in.matches.Load(),
+		Alerts:  in.alerts.Load(),
+	}
+	for i, f := range feeds {
+		status.Feeds[i] = f.status
+	}
+	return status
+}
+
+// ipPattern and domainPattern find candidate indicators in messages
+var (
+	ipPattern     = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|\b[0-9a-fA-F]{0,4}(?::[0-9a-fA-F]{0,4}){2,7}\b`)
+	domainPattern = regexp.MustCompile(`\b(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z]{2,63}\b`)
+)
+
+// Check returns the indicators found in the entry's IP, host name and
+// message
+func (in *Intel) Check(entry *collector.SystemLog) []Match {
+	feeds := *in.feeds.Load()
+	var matches []Match
+	seen := make(map[string]bool)
+
+	checkIP := func(value string) {
+		addr, err := netip.ParseAddr(value)
+		if err != nil || seen[value] {
+			return
+		}
+		seen[value] = true
+		addr = addr.Unmap()
+		for _, f := range feeds {
+			if _, ok := f.ips[addr]; ok {
+				matches = append(matches, Match{Indicator: addr.String(), Type: "ip", Value: value, Feed: f.status.Name})
+				continue
+			}
+			for _, prefix := range f.ranges {
+				if prefix.Contains(addr) {
+					matches = append(matches, Match{Indicator: prefix.String(), Type: "ip", Value: value, Feed: f.status.Name})
+					break
+				}
+			}
+		}
+	}
+	checkDomain := func(value string) {
+		domain := strings.ToLower(strings.TrimSuffix(value, "."))
+		if seen[domain] {
+			return
+		}
+		seen[domain] = true
+		for _, f := range feeds {
+			// The domain itself or any parent domain
+			for candidate := domain; strings.Contains(candidate, "."); {
+				if _, ok := f.domains[candidate]; ok {
+					matches = append(matches, Match{Indicator: candidate, Type: "domain", Value: domain, Feed: f.status.Name})
+					break
+				}
+				_, candidate, _ = strings.Cut(candidate, ".")
+			}
+		}
+	}
+
+	hasIPs, hasDomains := false, false
+	for _, f := range feeds {
+		hasIPs = hasIPs || len(f.ips) > 0 || len(f.ranges) > 0
+		hasDomains = hasDomains || len(f.domains) > 0
+	}
+	if hasIPs {
+		if entry.IP != "" {
+			checkIP(entry.IP)
+		}
+		for _, value := range ipPattern.FindAllString(entry.Message, -1) {
+			checkIP(value)
+		}
+	}
+	if hasDomains {
+		if hostname, ok := entry.ParsedData["hostname"].(string); ok {
+			checkDomain(hostname)
+		}
+		for _, value := range domainPattern.FindAllString(entry.Message, -1) {
+			checkDomain(value)
+		}
+	}
+	return matches
+}
+
+// Processor tags matching entries with "threat" and "threat:<feed>" and
+// stores the matches in parsed_data.threat. Matches in alert sources are
+// raised to at least AlertLevel and reported as threat_match audit events.
+func (in *Intel) Processor() collector.Processor {
+	return collector.ProcessorFunc(func(entry *collector.SystemLog) bool {
+		matches := in.Check(entry)
+		if len(matches) == 0 {
+			return true
+		}
+		in.matches.Add(1)
+
+		// Tags of the source config are shared between entries, so copy them
+		tags := make([]string, 0, len(entry.Tags)+1+len(matches))
+		tags = append(tags, entry.Tags...)
+		tags = append(tags, "threat")
+		for _, match := range matches {
+			tags = appendUnique(tags, "threat:"+match.Feed)
+		}
+		if entry.ParsedData == nil {
+			entry.ParsedData = make(map[string]interface{})
+		}
+		entry.ParsedData["threat"] = matches
+
+		if in.alertSource(entry) {
+			if !entry.Level.AtLeast(in.config.AlertLevel) {
+				entry.Level = in.config.AlertLevel
+			}
+			in.alert(entry, matches)
+		}
+		entry.Tags = tags
+		return true
+	})
+}
+
+// alertSource reports whether matches in entry raise an alert
+func (in *Intel) alertSource(entry *collector.SystemLog) bool {
+	for _, source := range in.config.AlertSources {
+		if string(entry.Source) == source {
+			return true
+		}
+		for _, tag := range entry.Tags {
+			if tag == source {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// alert reports matches whose indicator hasn't alerted within the cooldown
+func (in *Intel) alert(entry *collector.SystemLog, matches []Match) {
+	now := time.Now()
+	var fresh []Match
+	in.alertMu.Lock()
+	for _, match := range matches {
+		key := match.Feed + "\x00" + match.Indicator
+		if last, ok := in.lastAlert[key]; ok && now.Sub(last) < in.config.AlertCooldown {
+			continue
+		}
+		in.lastAlert[key] = now
+		fresh = append(fresh, match)
+	}
+	if len(in.lastAlert) > 10000 {
+		for key, last := range in.lastAlert {
+			if now.Sub(last) >= in.config.AlertCooldown {
+				delete(in.lastAlert, key)
+			}
+		}
+	}
+	in.alertMu.Unlock()
+	if len(fresh) == 0 {
+		return
+	}
+
+	in.alerts.Add(1)
+	in.auditLogger.LogEvent(audit.AuditEvent{
+		EventType: "threat_match",
+		Message:   fmt.Sprintf("Known-bad %s %s seen in %s logs", fresh[0].Type, fresh[0].Value, entry.Source),
+		Details: map[string]interface{}{
+			"matches": fresh,
+			"log_id":  entry.ID,
+			"source":  entry.Source,
+			"host":    entry.Host,
+			"message": entry.Message,
+		},
+	})
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,502 @@
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// Plugin types
+const (
+	TypeParser    = "parser"
+	TypeProcessor = "processor"
+)
+
+// Manifest describes a plugin
+type Manifest struct {
+	Name string `json:"name"`
+	// Type is "parser" or "processor"
+	Type string `json:"type"`
+	// Source is the source type a parser plugin handles. Built-in source
+	// types cannot be taken over.
+	Source collector.LogSource `json:"source,omitempty"`
+}
+
+// Validate checks the manifest
+func (m Manifest) Validate() error {
+	if m.Name == "" {
+		return fmt.Errorf("plugin name is required")
+	}
+	switch m.Type {
+	case TypeParser:
+		if m.Source == "" {
+			return fmt.Errorf("plugin %s: parser plugins need a source type", m.Name)
+		}
+		for _, builtin := range collector.KnownSources {
+			if m.Source == builtin {
+				return fmt.Errorf("plugin %s: %q is a built-in source type", m.Name, m.Source)
+			}
+		}
+	case TypeProcessor:
+		if m.Source != "" {
+			return fmt.Errorf("plugin %s: source is only valid for parser plugins", m.Name)
+		}
+	default:
+		return fmt.Errorf("plugin %s: unknown type %q", m.Name, m.Type)
+	}
+	return nil
+}
+
+// ParseInput is the document passed to gonder_parse
+type ParseInput struct {
+	Line   string              `json:"line"`
+	Source collector.LogSource `json:"source"`
+	Name   string              `json:"name"`
+	Tags   []string            `json:"tags,omitempty"`
+}
+
+// ParseResult is the document returned by gonder_parse for a matched line.
+// Empty fields are left as gonder set them.
+type ParseResult struct {
+	Timestamp  time.Time              `json:"timestamp"`
+	Level      collector.LogLevel     `json:"level,omitempty"`
+	Message    string                 `json:"message,omitempty"`
+	Host       string                 `json:"host,omitempty"`
+	Service    string                 `json:"service,omitempty"`
+	PID        int                    `json:"pid,omitempty"`
+	User       string                 `json:"user,omitempty"`
+	IP         string                 `json:"ip,omitempty"`
+	Method     string                 `json:"method,omitempty"`
+	Path       string                 `json:"path,omitempty"`
+	StatusCode int                    `json:"status_code,omitempty"`
+	ParsedData map[string]interface{} `json:"parsed_data,omitempty"`
+	Tags       []string               `json:"tags,omitempty"`
+}
+
+// ProcessResult is the document returned by gonder_process when it changes
+// or drops the entry it received (a SystemLog document)
+type ProcessResult struct {
+	Drop  bool                 `json:"drop,omitempty"`
+	Entry *collector.SystemLog `json:"entry,omitempty"`
+}
+
+// PluginStatus reports a loaded plugin and its counters
+type PluginStatus struct {
+	Manifest
+	LoadedAt time.Time `json:"loaded_at"`
+	Calls    uint64    `json:"calls"`
+	Errors   uint64    `json:"errors"`
+	// Timeouts counts calls aborted for exceeding the time limit
+	Timeouts  uint64 `json:"timeouts"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Plugin is a loaded module with a pool of instances, so concurrent source
+// goroutines don't serialize on one instance
+type Plugin struct {
+	manifest Manifest
+	limits   Limits
+	runtime  Runtime
+	loadedAt time.Time
+	idle     chan Instance
+
+	calls     atomic.Uint64
+	errors    atomic.Uint64
+	timeouts  atomic.Uint64
+	lastError atomic.Value // string
+}
+
+// call runs export on an idle instance, creating one when none is idle.
+// Instances that fail are discarded since a trap or timeout leaves them
+// unusable.
+func (p *Plugin) call(export string, input []byte) ([]byte, error) {
+	p.calls.Add(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.limits.Timeout)
+	defer cancel()
+
+	var instance Instance
+	select {
+	case instance = <-p.idle:
+	default:
+		var err error
+		if instance, err = p.runtime.Instantiate(context.Background()); err != nil {
+			return nil, p.fail(ctx, fmt.Errorf("failed to instantiate plugin %s: %w", p.manifest.Name, err))
+		}
+	}
+
+	output, err := instance.Call(ctx, export, input)
+	if err != nil {
+		instance.Close(context.Background())
+		return nil, p.fail(ctx, fmt.Errorf("plugin %s: %w", p.manifest.Name, err))
+	}
+
+	select {
+	case p.idle <- instance:
+	default:
+		instance.Close(context.Background())
+	}
+	return output, nil
+}
+
+func (p *Plugin) fail(ctx context.Context, err error) error {
+	p.errors.Add(1)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		p.timeouts.Add(1)
+	}
+	p.lastError.Store(err.Error())
+	return err
+}
+
+func (p *Plugin) status() PluginStatus {
+	status := PluginStatus{
+		Manifest: p.manifest,
+		LoadedAt: p.loadedAt,
+		Calls:    p.calls.Load(),
+		Errors:   p.errors.Load(),
+		Timeouts: p.timeouts.Load(),
+	}
+	status.LastError, _ = p.lastError.Load().(string)
+	return status
+}
+
+func (p *Plugin) close() {
+	for {
+		select {
+		case instance := <-p.idle:
+			instance.Close(context.Background())
+		default:
+			p.runtime.Close(context.Background())
+			return
+		}
+	}
+}
+
+// parse implements a parser plugin call
+func (p *Plugin) parse(line string, config collector.LogSourceConfig, entry *collector.SystemLog) bool {
+	input, err := json.Marshal(ParseInput{
+		Line:   line,
+		Source: config.Source,
+		Name:   config.Name,
+		Tags:   config.Tags,
+	})
+	if err != nil {
+		return false
+	}
+	output, err := p.call(ExportParse, input)
+	if err != nil || len(output) == 0 {
+		return false
+	}
+
+	var result ParseResult
+	if err := json.Unmarshal(output, &result); err != nil {
+		p.fail(context.Background(), fmt.Errorf("plugin %s: invalid parse result: %w", p.manifest.Name, err))
+		return false
+	}
+
+	if !result.Timestamp.IsZero() {
+		entry.Timestamp = result.Timestamp.UTC()
+	}
+	setString(&entry.Message, result.Message)
+	setString(&entry.Host, result.Host)
+	setString(&entry.Service, result.Service)
+	setString(&entry.User, result.User)
+	setString(&entry.IP, result.IP)
+	setString(&entry.Method, result.Method)
+	setString(&entry.Path, result.Path)
+	if result.Level != "" {
+		entry.Level = result.Level
+	}
+	if result.PID != 0 {
+		entry.PID = result.PID
+	}
+	if result.StatusCode != 0 {
+		entry.StatusCode = result.StatusCode
+	}
+	for k, v := range result.ParsedData {
+		entry.ParsedData[k] = v
+	}
+	if len(result.Tags) > 0 {
+		entry.Tags = append(append([]string(nil), entry.Tags...), result.Tags...)
+	}
+	if entry.Message == "" {
+		entry.Message = line
+	}
+	return true
+}
+
+func setString(field *string, value string) {
+	if value != "" {
+		*field = value
+	}
+}
+
+// process implements a processor plugin call. Failures keep the entry
+// unchanged so a broken plugin never loses logs.
+func (p *Plugin) process(entry *collector.SystemLog) bool {
+	input, err := json.Marshal(entry)
+	if err != nil {
+		return true
+	}
+	output, err := p.call(ExportProcess, input)
+	if err != nil || len(output) == 0 {
+		return true
+	}
+
+	var result ProcessResult
+	if err := json.Unmarshal(output, &result); err != nil {
+		p.fail(context.Background(), fmt.Errorf("plugin %s: invalid process result: %w", p.manifest.Name, err))
+		return true
+	}
+	if result.Drop {
+		return false
+	}
+	if result.Entry != nil {
+		// Keep the pooled ParsedData map of the entry
+		parsed := entry.ParsedData
+		*entry = *result.Entry
+		if parsed != nil {
+			clear(parsed)
+			for k, v := range result.Entry.ParsedData {
+				parsed[k] = v
+			}
+			entry.ParsedData = parsed
+		}
+	}
+	return true
+}
+
+// Manager loads, replaces and unloads plugins while the collector runs
+type Manager struct {
+	limits Limits
+
+	mu      sync.Mutex
+	plugins map[string]*Plugin
+	// registered records the source types whose parser was registered with
+	// the collector; registrations cannot be undone, so they dispatch
+	// through parsers and fall back to raw lines once unloaded
+	registered map[collector.LogSource]bool
+
+	parsers    atomic.Pointer[map[collector.LogSource]*Plugin]
+	processors atomic.Pointer[[]*Plugin]
+}
+
+// NewManager creates a plugin manager
+func NewManager(limits Limits) *Manager {
+	return &Manager{
+		limits:     limits.withDefaults(),
+		plugins:    make(map[string]*Plugin),
+		registered: make(map[collector.LogSource]bool),
+	}
+}
+
+// Limits returns the limits applied to plugin calls
+func (m *Manager) Limits() Limits {
+	return m.limits
+}
+
+// Load compiles a module and activates it, replacing a plugin with the same
+// name
+func (m *Manager) Load(manifest Manifest, wasm []byte) error {
+	if err := manifest.Validate(); err != nil {
+		return err
+	}
+	if newRuntime == nil {
+		return ErrUnsupported
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name, plugin := range m.plugins {
+		if name != manifest.Name && manifest.Type == TypeParser && plugin.manifest.Source == manifest.Source {
+			return fmt.Errorf("plugin %s already parses source type %q", name, manifest.Source)
+		}
+	}
+
+	rt, err := newRuntime(context.Background(), wasm, m.limits)
+	if err != nil {
+		return fmt.Errorf("plugin %s: %w", manifest.Name, err)
+	}
+	plugin := &Plugin{
+		manifest: manifest,
+		limits:   m.limits,
+		runtime:  rt,
+		loadedAt: time.Now(),
+		idle:     make(chan Instance, runtime.GOMAXPROCS(0)),
+	}
+
+	// Fail early on modules that cannot be instantiated
+	instance, err := rt.Instantiate(context.Background())
+	if err != nil {
+		rt.Close(context.Background())
+		return fmt.Errorf("plugin %s: %w", manifest.Name, err)
+	}
+	plugin.idle <- instance
+
+	old := m.plugins[manifest.Name]
+	m.plugins[manifest.Name] = plugin
+	if manifest.Type == TypeParser && !m.registered[manifest.Source] {
+		collector.RegisterParser(manifest.Source, m.dispatchParser(manifest.Source))
+		m.registered[manifest.Source] = true
+	}
+	m.publish()
+
+	if old != nil {
+		old.close()
+	}
+	return nil
+}
+
+// Unload deactivates a plugin
+func (m *Manager) Unload(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	plugin, exists := m.plugins[name]
+	if !exists {
+		return fmt.Errorf("plugin %s is not loaded", name)
+	}
+	delete(m.plugins, name)
+	m.publish()
+	plugin.close()
+	return nil
+}
+
+// publish rebuilds the lock-free views of the loaded plugins
+func (m *Manager) publish() {
+	parsers := make(map[collector.LogSource]*Plugin)
+	var processors []*Plugin
+	for _, plugin := range m.plugins {
+		switch plugin.manifest.Type {
+		case TypeParser:
+			parsers[plugin.manifest.Source] = plugin
+		case TypeProcessor:
+			processors = append(processors, plugin)
+		}
+	}
+	// Processors run in name order so the result doesn't depend on load order
+	sortPlugins(processors)
+	m.parsers.Store(&parsers)
+	m.processors.Store(&processors)
+}
+
+// List returns every loaded plugin
+func (m *Manager) List() []PluginStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	plugins := make([]*Plugin, 0, len(m.plugins))
+	for _, plugin := range m.plugins {
+		plugins = append(plugins, plugin)
+	}
+	sortPlugins(plugins)
+
+	statuses := make([]PluginStatus, len(plugins))
+	for i, plugin := range plugins {
+		statuses[i] = plugin.status()
+	}
+	return statuses
+}
+
+// Close unloads every plugin
+func (m *Manager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name, plugin := range m.plugins {
+		plugin.close()
+		delete(m.plugins, name)
+	}
+	m.publish()
+}
+
+// dispatchParser returns the collector parser for a source type, which
+// calls whichever plugin currently handles it
+func (m *Manager) dispatchParser(source collector.LogSource) collector.Parser {
+	return collector.ParserFunc(func(line string, config collector.LogSourceConfig, entry *collector.SystemLog) bool {
+		parsers := m.parsers.Load()
+		if parsers == nil {
+			return false
+		}
+		plugin, ok := (*parsers)[source]
+		if !ok {
+			return false
+		}
+		return plugin.parse(line, config, entry)
+	})
+}
+
+// LoadDir loads every <name>.wasm file of dir, described by a <name>.json
+// manifest next to it. The manifest name defaults to the file name. It
+// returns the first error after trying every plugin.
+func (m *Manager) LoadDir(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.wasm"))
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, path := range paths {
+		if err := m.loadFile(path); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (m *Manager) loadFile(path string) error {
+	base := strings.TrimSuffix(path, ".wasm")
+	data, err := os.ReadFile(base + ".json")
+	if err != nil {
+		return fmt.Errorf("failed to read plugin manifest: %w", err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("invalid plugin manifest %s.json: %w", base, err)
+	}
+	if manifest.Name == "" {
+		manifest.Name = filepath.Base(base)
+	}
+
+	wasm, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read plugin: %w", err)
+	}
+	return m.Load(manifest, wasm)
+}
+
+func sortPlugins(plugins []*Plugin) {
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].manifest.Name < plugins[j].manifest.Name
+	})
+}
+
+// Processor returns a collector processor running every loaded processor
+// plugin. Add it to the collector once; plugins loaded later take part
+// without restarting the collector.
+func (m *Manager) Processor() collector.Processor {
+	return collector.ProcessorFunc(func(entry *collector.SystemLog) bool {
+		processors := m.processors.Load()
+		if processors == nil {
+			return true
+		}
+		for _, plugin := range *processors {
+			if !plugin.process(entry) {
+				return false
+			}
+		}
+		return true
+	})
+}
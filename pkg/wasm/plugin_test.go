@@ -0,0 +1,164 @@
+//go:build !nowazero
+
+package wasm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ercansavas/gonder/pkg/collector"
+)
+
+// resultOffset is where testModule places the result document
+const resultOffset = 2048
+
+// testModule assembles a plugin exporting memory, gonder_alloc and export.
+// export returns result from a data segment, or spins forever with spin.
+func testModule(export, result string, spin bool) []byte {
+	packed := int64(resultOffset)<<32 | int64(len(result))
+	body := []byte{0x42} // i64.const
+	body = appendSLEB(body, packed)
+	if spin {
+		// loop br 0 end, then the result the validator expects
+		body = append([]byte{0x03, 0x40, 0x0c, 0x00, 0x0b}, body...)
+	}
+	body = append(body, 0x0b)
+
+	alloc := append([]byte{0x41}, appendSLEB(nil, 1024)...) // i32.const 1024
+	alloc = append(alloc, 0x0b)
+
+	module := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+	// Types: (i32) -> i32, (i32, i32) -> i64
+	module = appendSection(module, 1, []byte{0x02,
+		0x60, 0x01, 0x7f, 0x01, 0x7f,
+		0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e})
+	module = appendSection(module, 3, []byte{0x02, 0x00, 0x01})
+	module = appendSection(module, 5, []byte{0x01, 0x00, 0x01})
+	exports := []byte{0x03}
+	exports = append(appendName(exports, "memory"), 0x02, 0x00)
+	exports = append(appendName(exports, ExportAlloc), 0x00, 0x00)
+	exports = append(appendName(exports, export), 0x00, 0x01)
+	module = appendSection(module, 7, exports)
+	code := []byte{0x02}
+	for _, fn := range [][]byte{alloc, body} {
+		code = appendULEB(code, uint64(len(fn)+1))
+		code = append(code, 0x00) // no locals
+		code = append(code, fn...)
+	}
+	module = appendSection(module, 10, code)
+	data := []byte{0x01, 0x00, 0x41}
+	data = appendSLEB(data, resultOffset)
+	data = append(data, 0x0b)
+	data = appendName(data, result)
+	return appendSection(module, 11, data)
+}
+
+func appendSection(module []byte, id byte, content []byte) []byte {
+	module = append(module, id)
+	module = appendULEB(module, uint64(len(content)))
+	return append(module, content...)
+}
+
+func appendName(b []byte, name string) []byte {
+	b = appendULEB(b, uint64(len(name)))
+	return append(b, name...)
+}
+
+func appendULEB(b []byte, v uint64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if v == 0 {
+			return b
+		}
+	}
+}
+
+func appendSLEB(b []byte, v int64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func TestRuntimeAvailable(t *testing.T) {
+	if !Available() {
+		t.Fatal("the default build has no WASM runtime")
+	}
+}
+
+func TestParserPlugin(t *testing.T) {
+	m := NewManager(Limits{})
+	defer m.Close()
+	manifest := Manifest{Name: "kv", Type: TypeParser, Source: "wasm_test"}
+	if err := m.Load(manifest, testModule(ExportParse, `{"level":"error","message":"parsed","status_code":502}`, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := &collector.SystemLog{ParsedData: map[string]interface{}{}}
+	if !m.plugins["kv"].parse("raw line", collector.LogSourceConfig{Name: "app", Source: "wasm_test"}, entry) {
+		t.Fatal("parser plugin did not match")
+	}
+	if entry.Level != collector.LogLevel("error") || entry.Message != "parsed" || entry.StatusCode != 502 {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+}
+
+func TestProcessorPlugin(t *testing.T) {
+	tests := []struct {
+		name   string
+		result string
+		keep   bool
+		level  collector.LogLevel
+	}{
+		{"unchanged", "", true, "info"},
+		{"drop", `{"drop":true}`, false, "info"},
+		{"replace", `{"entry":{"level":"warn","message":"rewritten"}}`, true, "warn"},
+		{"invalid", `{not json`, true, "info"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := NewManager(Limits{})
+			defer m.Close()
+			if err := m.Load(Manifest{Name: "p", Type: TypeProcessor}, testModule(ExportProcess, test.result, false)); err != nil {
+				t.Fatal(err)
+			}
+			entry := &collector.SystemLog{Level: "info", Message: "original", ParsedData: map[string]interface{}{}}
+			if keep := m.Processor().Process(entry); keep != test.keep {
+				t.Fatalf("Process() = %v, want %v", keep, test.keep)
+			}
+			if entry.Level != test.level {
+				t.Fatalf("level = %q, want %q", entry.Level, test.level)
+			}
+		})
+	}
+}
+
+func TestPluginTimeout(t *testing.T) {
+	m := NewManager(Limits{Timeout: 20 * time.Millisecond})
+	defer m.Close()
+	if err := m.Load(Manifest{Name: "spin", Type: TypeProcessor}, testModule(ExportProcess, "", true)); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := &collector.SystemLog{Level: "info", ParsedData: map[string]interface{}{}}
+	start := time.Now()
+	if !m.Processor().Process(entry) {
+		t.Fatal("a timed out plugin dropped the entry")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("call was not aborted, took %s", elapsed)
+	}
+	status := m.List()[0]
+	if status.Timeouts != 1 || status.Errors != 1 {
+		t.Fatalf("status = %+v, want one timeout", status)
+	}
+}
@@ -0,0 +1,98 @@
+// Package wasm runs parsers and processors compiled to WebAssembly, so they
+// can be written in any language and run sandboxed with CPU and memory
+// limits.
+//
+// A plugin is a WASI reactor module exporting its linear memory and:
+//
+//	gonder_alloc(size u32) u32          allocate size bytes for the input
+//	gonder_parse(ptr u32, len u32) u64  parser plugins
+//	gonder_process(ptr u32, len u32) u64 processor plugins
+//	gonder_free(ptr u32, len u32)        optional, releases input and output
+//
+// The input is JSON. The result is packed as ptr<<32 | len and points to a
+// JSON document in guest memory; a zero length means "no match" for parsers
+// and "unchanged" for processors. See ParseInput, ParseResult and
+// ProcessResult for the documents.
+//
+// The runtime is provided by wazero. Building with the "nowazero" tag
+// leaves it out, and loading a plugin then fails with ErrUnsupported.
+package wasm
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Exported function names of the plugin ABI
+const (
+	ExportAlloc   = "gonder_alloc"
+	ExportFree    = "gonder_free"
+	ExportParse   = "gonder_parse"
+	ExportProcess = "gonder_process"
+)
+
+// Default plugin limits
+const (
+	DefaultTimeout     = 10 * time.Millisecond
+	DefaultMemoryLimit = 16 * 1024 * 1024
+)
+
+// wasmPageSize is the size of a WebAssembly memory page
+const wasmPageSize = 64 * 1024
+
+// ErrUnsupported is returned when gonder was built without a WASM runtime
+var ErrUnsupported = errors.New("WASM runtime not available; this build used -tags nowazero")
+
+// Limits bound what a single plugin call may use
+type Limits struct {
+	// Timeout is the CPU time budget of one call; the instance is aborted
+	// and replaced when it is exceeded
+	Timeout time.Duration
+	// MemoryLimit caps the linear memory of each instance, in bytes
+	MemoryLimit int64
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.Timeout <= 0 {
+		l.Timeout = DefaultTimeout
+	}
+	if l.MemoryLimit <= 0 {
+		l.MemoryLimit = DefaultMemoryLimit
+	}
+	return l
+}
+
+// memoryPages returns MemoryLimit in WebAssembly pages
+func (l Limits) memoryPages() uint32 {
+	pages := l.MemoryLimit / wasmPageSize
+	if pages < 1 {
+		pages = 1
+	}
+	return uint32(pages)
+}
+
+// Runtime is a compiled plugin module
+type Runtime interface {
+	// Instantiate creates an isolated instance of the module
+	Instantiate(ctx context.Context) (Instance, error)
+	Close(ctx context.Context) error
+}
+
+// Instance is one instance of a plugin. Instances are not safe for
+// concurrent use.
+type Instance interface {
+	// Call copies input into the instance, calls export with it and returns
+	// a copy of the result, nil for an empty result
+	Call(ctx context.Context, export string, input []byte) ([]byte, error)
+	Close(ctx context.Context) error
+}
+
+// newRuntime compiles a module; it is set by the wazero runtime unless
+// built with the nowazero tag
+var newRuntime func(ctx context.Context, wasm []byte, limits Limits) (Runtime, error)
+
+// Available reports whether a WASM runtime is compiled in
+func Available() bool {
+	return newRuntime != nil
+}
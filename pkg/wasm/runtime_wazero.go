@@ -0,0 +1,114 @@
+//go:build !nowazero
+
+package wasm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+func init() {
+	newRuntime = newWazeroRuntime
+}
+
+// wazeroRuntime is a module compiled by a dedicated wazero runtime, so the
+// memory limit applies per plugin
+type wazeroRuntime struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+}
+
+func newWazeroRuntime(ctx context.Context, wasm []byte, limits Limits) (Runtime, error) {
+	config := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(limits.memoryPages()).
+		WithCloseOnContextDone(true)
+	runtime := wazero.NewRuntimeWithConfig(ctx, config)
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("failed to instantiate WASI: %w", err)
+	}
+	compiled, err := runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("failed to compile module: %w", err)
+	}
+	if _, ok := compiled.ExportedFunctions()[ExportAlloc]; !ok {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("module does not export %s", ExportAlloc)
+	}
+	return &wazeroRuntime{runtime: runtime, compiled: compiled}, nil
+}
+
+func (w *wazeroRuntime) Instantiate(ctx context.Context) (Instance, error) {
+	// Anonymous modules can be instantiated many times; reactors built by
+	// TinyGo or Rust initialize in _initialize
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize")
+	module, err := w.runtime.InstantiateModule(ctx, w.compiled, config)
+	if err != nil {
+		return nil, err
+	}
+	return &wazeroInstance{module: module}, nil
+}
+
+func (w *wazeroRuntime) Close(ctx context.Context) error {
+	return w.runtime.Close(ctx)
+}
+
+// wazeroInstance is one instantiated plugin module
+type wazeroInstance struct {
+	module api.Module
+}
+
+func (i *wazeroInstance) Call(ctx context.Context, export string, input []byte) ([]byte, error) {
+	fn := i.module.ExportedFunction(export)
+	if fn == nil {
+		return nil, fmt.Errorf("module does not export %s", export)
+	}
+
+	results, err := i.module.ExportedFunction(ExportAlloc).Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w", ExportAlloc, err)
+	}
+	inPtr := uint32(results[0])
+	if !i.module.Memory().Write(inPtr, input) {
+		return nil, fmt.Errorf("%s returned an out of range pointer", ExportAlloc)
+	}
+
+	results, err = fn.Call(ctx, uint64(inPtr), uint64(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w", export, err)
+	}
+	outPtr, outLen := uint32(results[0]>>32), uint32(results[0])
+
+	var output []byte
+	if outLen > 0 {
+		view, ok := i.module.Memory().Read(outPtr, outLen)
+		if !ok {
+			return nil, fmt.Errorf("%s returned an out of range result", export)
+		}
+		output = append([]byte(nil), view...)
+	}
+
+	if free := i.module.ExportedFunction(ExportFree); free != nil {
+		if _, err := free.Call(ctx, uint64(inPtr), uint64(len(input))); err != nil {
+			return nil, fmt.Errorf("%s failed: %w", ExportFree, err)
+		}
+		if outLen > 0 {
+			if _, err := free.Call(ctx, uint64(outPtr), uint64(outLen)); err != nil {
+				return nil, fmt.Errorf("%s failed: %w", ExportFree, err)
+			}
+		}
+	}
+	return output, nil
+}
+
+func (i *wazeroInstance) Close(ctx context.Context) error {
+	return i.module.Close(ctx)
+}